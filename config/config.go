@@ -29,11 +29,24 @@ type Config struct {
 	DisableCheckpoints bool     `long:"nocheckpoints" description:"Disable built-in checkpoints.  Don't do this unless you know what you're doing."`
 	TxIndex            bool     `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
 	DropTxIndex        bool     `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
-	AddrIndex          bool     `long:"addrindex" description:"Maintain a full address-based transaction index which makes the getrawtransactions RPC available"`
-	DropAddrIndex      bool     `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
+	AddrIndex          bool     `long:"addrindex" description:"Maintain a full address-based transaction index and an address utxo index which make the getrawtransactions, getAddressBalance, getAddressUtxos and getAddressDeltas RPCs available"`
+	DropAddrIndex      bool     `long:"dropaddrindex" description:"Deletes the address-based transaction index and the address utxo index from the database on start up and then exits."`
+	CFIndex            bool     `long:"cfindex" description:"Maintain a committed filter index which makes block filters available via the getcfilter RPC and p2p messages"`
+	DropCFIndex        bool     `long:"dropcfindex" description:"Deletes the committed filter index from the database on start up and then exits."`
+	SpendIndex         bool     `long:"spendindex" description:"Maintain a spent outpoint index which makes the spending transactions of outputs available via the getSpendingTx RPC"`
+	DropSpendIndex     bool     `long:"dropspendindex" description:"Deletes the spent outpoint index from the database on start up and then exits."`
+	StatsIndex         bool     `long:"blockstatsindex" description:"Maintain a block statistics index which makes the fees, transaction counts and sizes of blocks available via the getBlockStats RPC"`
+	DropStatsIndex     bool     `long:"dropblockstatsindex" description:"Deletes the block statistics index from the database on start up and then exits."`
+	StateCommit        bool     `long:"statecommit" description:"Maintain a per-block commitment of the UTXO set in a state trie, which makes Merkle proofs available via the getstateproof RPC"`
+	Prune              uint64   `long:"prune" description:"Prune already validated blocks from the database. Must specify a target size in MiB (minimum value of 1536, default value of 0 will disable pruning)"`
 	LightNode          bool     `long:"light" description:"start as a qitmeer light node"`
 	SigCacheMaxSize    uint     `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
 	DumpBlockchain     string   `long:"dumpblockchain" description:"Write blockchain as a flat file of blocks for use with addblock, to the specified filename"`
+	ImportBlockchain   string   `long:"importblockchain" description:"Import the blocks of a flat file written by --dumpblockchain from the specified filename and then exit"`
+	ImportSkipScripts  bool     `long:"importskipscripts" description:"Skip the script validation of the imported blocks before the latest checkpoint"`
+	DumpUtxoSnapshot   string   `long:"dumputxosnapshot" description:"Write the UTXO set after the block at --utxosnapshotorder as a snapshot file to the specified filename and then exit"`
+	UtxoSnapshotOrder  uint64   `long:"utxosnapshotorder" description:"The DAG order of the block after which --dumputxosnapshot writes the UTXO set (default is the main chain tip)"`
+	LoadUtxoSnapshot   string   `long:"loadutxosnapshot" description:"Load the UTXO set of a new chain from the specified snapshot file, whose hash must be pinned in the checkpoint of its block"`
 	TestNet            bool     `long:"testnet" description:"Use the test network"`
 	MixNet             bool     `long:"mixnet" description:"Use the test mix pow network"`
 	PrivNet            bool     `long:"privnet" description:"Use the private network"`
@@ -42,11 +55,13 @@ type Config struct {
 	DebugLevel         string   `short:"d" long:"debuglevel" description:"Logging level {trace, debug, info, warn, error, critical} "`
 	DebugPrintOrigins  bool     `long:"printorigin" description:"Print log debug location (file:line) "`
 	// MemPool Config
-	NoRelayPriority  bool    `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
-	FreeTxRelayLimit float64 `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
-	AcceptNonStd     bool    `long:"acceptnonstd" description:"Accept and relay non-standard transactions to the network regardless of the default settings for the active network."`
-	MaxOrphanTxs     int     `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
-	MinTxFee         int64   `long:"mintxfee" description:"The minimum transaction fee in AtomMEER/kB."`
+	NoRelayPriority   bool    `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
+	FreeTxRelayLimit  float64 `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
+	AcceptNonStd      bool    `long:"acceptnonstd" description:"Accept and relay non-standard transactions to the network regardless of the default settings for the active network."`
+	MaxOrphanTxs      int     `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
+	MinTxFee          int64   `long:"mintxfee" description:"The minimum transaction fee in AtomMEER/kB."`
+	MaxMempool        uint    `long:"maxmempool" description:"Maximum size of the mempool in megabytes, above which the transactions paying the lowest fee rates are evicted"`
+	RejectReplacement bool    `long:"rejectreplacement" description:"Reject transactions that attempt to replace existing transactions within the mempool through the Replace-By-Fee (RBF) signaling policy."`
 	// Miner
 	Generate          bool     `long:"generate" description:"Generate (mine) coins using the CPU"`
 	MiningAddrs       []string `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
@@ -54,18 +69,25 @@ type Config struct {
 	BlockMinSize      uint32   `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
 	BlockMaxSize      uint32   `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
 	BlockPrioritySize uint32   `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
+	StratumListeners  []string `long:"stratumlisten" description:"Add an interface/port to listen for Stratum connections of external miners -- The Stratum server is disabled if none is specified"`
+	StratumDiff       float64  `long:"stratumdiff" description:"Share difficulty of the Stratum miners, relative to the minimum difficulty of the network"`
+	StratumPass       string   `long:"stratumpass" default-mask:"-" description:"Password the Stratum miners must authorize with -- Any miner is authorized if none is specified"`
 	miningAddrs       []types.Address
+	// Account manager
+	WalletFile string `long:"walletfile" description:"Path to the encrypted HD wallet file used by the account manager, which is unlocked with the walletPassphrase RPC (default: wallet.json in the data directory)"`
+	WalletPath string `long:"walletpath" description:"BIP32 derivation path of the wallet account (default: m/44'/223'/0'/0)"`
 	//WebSocket support
 	RPCMaxWebsockets int `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
 	//P2P
-	BlocksOnly      bool     `long:"blocksonly" description:"Do not accept transactions from remote peers."`
-	MiningStateSync bool     `long:"miningstatesync" description:"Synchronizing the mining state with other nodes"`
-	AddPeers        []string `short:"a" long:"addpeer" description:"Add a peer to connect with at startup"`
-	ConnectPeers    []string `long:"connect" description:"Connect only to the specified peers at startup"`
-	ExternalIPs     []string `long:"externalip" description:"list of local addresses we claim to listen on to peers"`
-	Upnp            bool     `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
-	Whitelists      []string `long:"whitelist" description:"Add an IP network or IP that will not be banned. (eg. 192.168.1.0/24 or ::1)"`
-	whitelists      []*net.IPNet
+	BlocksOnly         bool     `long:"blocksonly" description:"Do not accept transactions from remote peers."`
+	NoPeerBloomFilters bool     `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
+	MiningStateSync    bool     `long:"miningstatesync" description:"Synchronizing the mining state with other nodes"`
+	AddPeers           []string `short:"a" long:"addpeer" description:"Add a peer to connect with at startup"`
+	ConnectPeers       []string `long:"connect" description:"Connect only to the specified peers at startup"`
+	ExternalIPs        []string `long:"externalip" description:"list of local addresses we claim to listen on to peers"`
+	Upnp               bool     `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
+	Whitelists         []string `long:"whitelist" description:"Add an IP network or IP that will not be banned. (eg. 192.168.1.0/24 or ::1)"`
+	whitelists         []*net.IPNet
 	//P2P - server ban
 	DisableBanning bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
 	BanDuration    time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
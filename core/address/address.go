@@ -63,6 +63,10 @@ type PubKeyHashAddress struct {
 	net   *params.Params
 	netID [2]byte
 	hash  [ripemd160.Size]byte
+
+	// bech32 is set when the address is encoded with bech32 instead of
+	// base58check.
+	bech32 bool
 }
 
 // NewAddressPubKeyHash returns a new AddressPubKeyHash.  pkHash must
@@ -127,6 +131,9 @@ func (a *PubKeyHashAddress) EcType() ecc.EcType {
 	return -1
 }
 func (a *PubKeyHashAddress) Encode() string {
+	if a.bech32 {
+		return encodeBech32Address(a.net.Bech32HRP, bech32PubKeyHashType, a.hash[:])
+	}
 	//TODO error handling
 	return encodeAddress(a.hash[:], a.netID)
 }
@@ -188,6 +195,10 @@ type ScriptHashAddress struct {
 	net   *params.Params
 	hash  [ripemd160.Size]byte
 	netID [2]byte
+
+	// bech32 is set when the address is encoded with bech32 instead of
+	// base58check.
+	bech32 bool
 }
 
 // NewAddressScriptHashFromHash returns a new AddressScriptHash.  scriptHash
@@ -230,6 +241,9 @@ func (a *ScriptHashAddress) Hash160() *[ripemd160.Size]byte {
 // EncodeAddress returns the string encoding of a pay-to-script-hash
 // address.  Part of the Address interface.
 func (a *ScriptHashAddress) Encode() string {
+	if a.bech32 {
+		return encodeBech32Address(a.net.Bech32HRP, bech32ScriptHashType, a.hash[:])
+	}
 	return encodeAddress(a.hash[:], a.netID)
 }
 
@@ -518,6 +532,12 @@ type ContractAddress struct {
 // DecodeAddress decodes the string encoding of an address and returns
 // the Address if addr is a valid encoding for a known address type
 func DecodeAddress(addr string) (types.Address, error) {
+	// Bech32 encoded addresses start with the human-readable part of
+	// their network.
+	if net := detectNetworkForBech32(addr); net != nil {
+		return decodeBech32Address(addr, net)
+	}
+
 	// Switch on decoded length to determine the type.
 	decoded, netID, err := base58.QitmeerCheckDecode(addr)
 	if err != nil {
@@ -18,6 +18,7 @@ import (
 	"encoding/hex"
 	// "qitmeer/common/encode/base58"
 	"golang.org/x/crypto/ripemd160"
+	"strings"
 	// "qitmeer/common/hash"
 	"testing"
 )
@@ -336,3 +337,81 @@ func TestAddress(t *testing.T) {
 		}
 	}
 }
+
+func TestIsForNetwork(t *testing.T) {
+	scriptHash := make([]byte, ripemd160.Size)
+	tests := []struct {
+		name string
+		addr types.Address
+	}{
+		{"pubkeyhash", mustAddr(NewPubKeyHashAddress(scriptHash, &params.PrivNetParams, ecc.ECDSA_Secp256k1))},
+		{"scripthash", mustAddr(NewAddressScriptHashFromHash(scriptHash, &params.PrivNetParams))},
+	}
+	for _, test := range tests {
+		if !IsForNetwork(test.addr, &params.PrivNetParams) {
+			t.Errorf("%v: address is not for its own network", test.name)
+		}
+		if IsForNetwork(test.addr, &params.MainNetParams) {
+			t.Errorf("%v: address is for another network", test.name)
+		}
+	}
+}
+
+func mustAddr(addr types.Address, err error) types.Address {
+	if err != nil {
+		panic(err)
+	}
+	return addr
+}
+
+func TestBech32Address(t *testing.T) {
+	h, _ := hex.DecodeString("a4e22220d86f6b5cb31b181ec9cd24fd760d9501")
+	pkh := mustAddr(NewPubKeyHashAddress(h, &params.PrivNetParams, ecc.ECDSA_Secp256k1))
+	sh := mustAddr(NewAddressScriptHashFromHash(h, &params.MainNetParams))
+	tests := []struct {
+		addr types.Address
+		net  *params.Params
+	}{
+		{pkh, &params.PrivNetParams},
+		{sh, &params.MainNetParams},
+	}
+	for _, test := range tests {
+		addr := test.addr
+		b, err := ToBech32(addr)
+		if err != nil {
+			t.Fatalf("ToBech32 %v: %v", addr, err)
+		}
+		encoded := b.Encode()
+		for _, s := range []string{encoded, strings.ToUpper(encoded)} {
+			decoded, err := DecodeAddress(s)
+			if err != nil {
+				t.Fatalf("DecodeAddress %v: %v", s, err)
+			}
+			if reflect.TypeOf(decoded) != reflect.TypeOf(addr) {
+				t.Errorf("%v: decoded address type %T, want %T", s, decoded, addr)
+			}
+			if !bytes.Equal(decoded.ScriptAddress(), addr.ScriptAddress()) {
+				t.Errorf("%v: decoded hash %x, want %x", s,
+					decoded.ScriptAddress(), addr.ScriptAddress())
+			}
+			if decoded.Encode() != encoded {
+				t.Errorf("%v: re-encoded as %v", s, decoded.Encode())
+			}
+		}
+		if !IsForNetwork(b, test.net) {
+			t.Errorf("%v: address is not for its own network", encoded)
+		}
+	}
+
+	encoded := mustAddr(ToBech32(pkh)).Encode()
+	if !strings.HasPrefix(encoded, params.PrivNetParams.Bech32HRP+"1") {
+		t.Errorf("%v: missing human-readable part", encoded)
+	}
+	corrupted := encoded[:len(encoded)-1] + "q"
+	if encoded[len(encoded)-1] == 'q' {
+		corrupted = encoded[:len(encoded)-1] + "p"
+	}
+	if _, err := DecodeAddress(corrupted); err == nil {
+		t.Errorf("%v: corrupted address decoded", corrupted)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package address
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/encode/bech32"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/params"
+	"strings"
+)
+
+// The type of a bech32 encoded address is the first 5-bit group of its data
+// part, which is followed by the hash of the address.
+const (
+	bech32PubKeyHashType byte = 0
+	bech32ScriptHashType byte = 1
+)
+
+// encodeBech32Address returns the bech32 encoding of an address of the passed
+// type and hash.
+func encodeBech32Address(hrp string, addrType byte, hash []byte) string {
+	converted, err := bech32.ConvertBits(hash, 8, 5, true)
+	if err != nil {
+		return ""
+	}
+	data := append([]byte{addrType}, converted...)
+	encoded, err := bech32.EncodeBech32(hrp, data)
+	if err != nil {
+		return ""
+	}
+	return encoded
+}
+
+// TODO, refactor the params design for address
+// detectNetworkForBech32 returns the network whose bech32 human-readable part
+// the passed address starts with, or nil if it isn't a bech32 address of any
+// known network.
+func detectNetworkForBech32(addr string) *params.Params {
+	one := strings.LastIndexByte(addr, '1')
+	if one < 1 {
+		return nil
+	}
+	// Bech32 strings are either all lowercase or all uppercase, mixed case
+	// strings are base58check encoded.
+	hrp := addr[:one]
+	if hrp != strings.ToLower(hrp) && hrp != strings.ToUpper(hrp) {
+		return nil
+	}
+
+	switch strings.ToLower(hrp) {
+	case params.MainNetParams.Bech32HRP:
+		return &params.MainNetParams
+	case params.TestNetParams.Bech32HRP:
+		return &params.TestNetParams
+	case params.PrivNetParams.Bech32HRP:
+		return &params.PrivNetParams
+	case params.MixNetParams.Bech32HRP:
+		return &params.MixNetParams
+	}
+	return nil
+}
+
+// decodeBech32Address decodes a bech32 encoded address of the passed network.
+func decodeBech32Address(addr string, net *params.Params) (types.Address, error) {
+	_, data, err := bech32.DecodeBech32(addr)
+	if err != nil {
+		return nil, fmt.Errorf("decoded address is of unknown format: %v", err)
+	}
+	if len(data) < 1 {
+		return nil, ErrUnknownAddressType
+	}
+	hash, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("decoded address is of unknown format: %v", err)
+	}
+
+	switch data[0] {
+	case bech32PubKeyHashType:
+		return NewBech32PubKeyHashAddress(hash, net)
+
+	case bech32ScriptHashType:
+		return NewBech32ScriptHashAddress(hash, net)
+
+	default:
+		return nil, ErrUnknownAddressType
+	}
+}
+
+// NewBech32PubKeyHashAddress returns a new secp256k1 pay-to-pubkey-hash
+// address which is encoded with bech32.  pkHash must be 20 bytes.
+func NewBech32PubKeyHashAddress(pkHash []byte, net *params.Params) (*PubKeyHashAddress, error) {
+	addr, err := NewPubKeyHashAddress(pkHash, net, ecc.ECDSA_Secp256k1)
+	if err != nil {
+		return nil, err
+	}
+	addr.bech32 = true
+	return addr, nil
+}
+
+// NewBech32ScriptHashAddress returns a new pay-to-script-hash address which
+// is encoded with bech32.  scriptHash must be 20 bytes.
+func NewBech32ScriptHashAddress(scriptHash []byte, net *params.Params) (*ScriptHashAddress, error) {
+	addr, err := NewAddressScriptHashFromHash(scriptHash, net)
+	if err != nil {
+		return nil, err
+	}
+	addr.bech32 = true
+	return addr, nil
+}
+
+// ToBech32 returns the bech32 encoded form of the passed address.  Only
+// secp256k1 pay-to-pubkey-hash and pay-to-script-hash addresses can be
+// encoded with bech32.
+func ToBech32(addr types.Address) (types.Address, error) {
+	switch addr := addr.(type) {
+	case *PubKeyHashAddress:
+		if addr.EcType() != ecc.ECDSA_Secp256k1 {
+			return nil, fmt.Errorf("only secp256k1 pay-to-pubkey-hash " +
+				"addresses can be encoded with bech32")
+		}
+		return NewBech32PubKeyHashAddress(addr.hash[:], addr.net)
+
+	case *ScriptHashAddress:
+		return NewBech32ScriptHashAddress(addr.hash[:], addr.net)
+	}
+	return nil, ErrUnknownAddressType
+}
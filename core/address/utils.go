@@ -14,6 +14,8 @@ func IsForNetwork(addr types.Address, p *params.Params) bool {
 	switch addr := addr.(type) {
 	case *PubKeyHashAddress:
 		return addr.netID == p.PubKeyHashAddrID
+	case *ScriptHashAddress:
+		return addr.netID == p.ScriptHashAddrID
 	}
 	return false
 }
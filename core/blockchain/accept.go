@@ -85,9 +85,17 @@ func (b *BlockChain) maybeAcceptBlock(block *types.SerializedBlock, flags Behavi
 	// This function should never be called with orphan blocks or the
 	// genesis block.
 
+	// Prune block nodes which are no longer needed before creating a new
+	// node.
+	b.pruner.pruneChainIfNeeded()
+
 	parentsNode := []*blockNode{}
 	for _, pb := range block.Block().Parents {
 		prevHash := pb
+		if b.bd.IsPruned(prevHash) {
+			str := fmt.Sprintf("parents block %s was pruned", prevHash)
+			return ruleError(ErrPrunedParent, str)
+		}
 		prevNode := b.index.LookupNode(prevHash)
 		if prevNode == nil {
 			err := fmt.Errorf("Parents block %s is unknown", prevHash)
@@ -122,10 +130,6 @@ func (b *BlockChain) maybeAcceptBlock(block *types.SerializedBlock, flags Behavi
 		return err
 	}
 
-	// Prune stake nodes which are no longer needed before creating a new
-	// node.
-	b.pruner.pruneChainIfNeeded()
-
 	//dag
 	newOrders := b.bd.AddBlock(newNode)
 	if newOrders == nil || newOrders.Len() == 0 {
@@ -133,6 +137,7 @@ func (b *BlockChain) maybeAcceptBlock(block *types.SerializedBlock, flags Behavi
 	}
 	oldOrders := BlockNodeList{}
 	b.getReorganizeNodes(newNode, block, newOrders, &oldOrders)
+	b.invalidateThresholdCaches(newOrders)
 	b.index.AddNode(newNode)
 	b.index.SetStatusFlags(newNode, statusDataStored)
 	err = b.index.flushToDB(b.bd)
@@ -3,17 +3,22 @@
 package blockchain
 
 import (
+	"bufio"
 	"container/list"
 	"encoding/binary"
 	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/protocol"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/database/statedb"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/common/progresslog"
+	"github.com/Qitmeer/qitmeer/trie"
+	"io"
 	"os"
 	"sort"
 	"sync"
@@ -51,6 +56,7 @@ type BlockChain struct {
 	notifications NotificationCallback
 	sigCache      *txscript.SigCache
 	indexManager  IndexManager
+	pruneTarget   uint64
 
 	// subsidyCache is the cache that provides quick lookup of subsidy
 	// values.
@@ -65,6 +71,14 @@ type BlockChain struct {
 	noVerify      bool
 	noCheckpoints bool
 
+	// utxoSnapshot is the snapshot the utxo set was loaded from, it is nil
+	// when the utxo set was built from all the blocks.
+	utxoSnapshot *utxoSnapshot
+
+	// verifyCheckpointed forces the scripts of the blocks before the latest
+	// checkpoint to be verified too.  It is set while importing blocks.
+	verifyCheckpointed bool
+
 	// These fields are related to the memory block index.  They both have
 	// their own locks, however they are often also protected by the chain
 	// lock to help prevent logic races when blocks are being processed.
@@ -110,6 +124,14 @@ type BlockChain struct {
 	//tx manager
 	txManager TxManager
 
+	// The caches of the threshold states of the deployments, which are
+	// used to detect and activate consensus rule changes.
+	deploymentCaches []thresholdStateCache
+
+	// The trie database of the per-block state commitment, which is nil
+	// when the state commitment is disabled.
+	stateTrieDB *trie.Database
+
 	// block version
 	BlockVersion uint32
 }
@@ -173,6 +195,19 @@ type Config struct {
 
 	// block version
 	BlockVersion uint32
+
+	// PruneTarget is the target size in bytes of the block files.  The
+	// oldest block data is deleted from the database once the block files
+	// exceed it.
+	//
+	// This field can be zero if the block data should not be pruned.
+	PruneTarget uint64
+
+	// StateDB defines the database which houses the state trie of the
+	// per-block state commitment of the utxo set.
+	//
+	// This field can be nil if the state commitment is not desired.
+	StateDB statedb.Database
 }
 
 // orphanBlock represents a block that we don't yet have the parent for.  It
@@ -246,8 +281,10 @@ func New(config *Config) (*BlockChain, error) {
 	if len(par.Checkpoints) > 0 {
 		checkpointsByLayer = make(map[uint64]*params.Checkpoint)
 		for i := range par.Checkpoints {
+			// The genesis block can be checkpointed to pin the
+			// utxo set hash of its snapshot.
 			checkpoint := &par.Checkpoints[i]
-			if checkpoint.Layer <= prevCheckpointLayer {
+			if i > 0 && checkpoint.Layer <= prevCheckpointLayer {
 				return nil, AssertError("blockchain.New " +
 					"checkpoints are not sorted by height")
 			}
@@ -256,6 +293,7 @@ func New(config *Config) (*BlockChain, error) {
 		}
 	}
 
+	bd := &blockdag.BlockDAG{}
 	b := BlockChain{
 		checkpointsByLayer:  checkpointsByLayer,
 		db:                  config.DB,
@@ -264,21 +302,39 @@ func New(config *Config) (*BlockChain, error) {
 		notifications:       config.Notifications,
 		sigCache:            config.SigCache,
 		indexManager:        config.IndexManager,
-		index:               newBlockIndex(config.DB, par),
+		pruneTarget:         config.PruneTarget,
+		index:               newBlockIndex(config.DB, par, bd),
 		orphans:             make(map[hash.Hash]*orphanBlock),
 		prevOrphans:         make(map[hash.Hash][]*orphanBlock),
 		BlockVersion:        config.BlockVersion,
+		deploymentCaches:    newThresholdCaches(uint32(len(par.Deployments[config.BlockVersion]))),
 	}
 	b.subsidyCache = NewSubsidyCache(0, b.params)
+	if config.StateDB != nil {
+		b.stateTrieDB = trie.NewDatabase(config.StateDB)
+	}
 
-	b.bd = &blockdag.BlockDAG{}
-	b.bd.Init(config.DAGType, b.subsidyCache.CalcBlockSubsidy)
+	b.bd = bd
+	b.bd.Init(config.DAGType, b.subsidyCache.CalcBlockSubsidy, b.db)
 	// Initialize the chain state from the passed database.  When the db
 	// does not yet contain any chain state, both it and the chain state
 	// will be initialized to contain only the genesis block.
 	if err := b.initChainState(config.Interrupt); err != nil {
 		return nil, err
 	}
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		b.utxoSnapshot, err = dbFetchUtxoSnapshot(dbTx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if b.stateTrieDB != nil {
+		if err := b.initStateCommitment(); err != nil {
+			return nil, err
+		}
+	}
 
 	// Initialize and catch up all of the currently active optional indexes
 	// as needed.
@@ -290,6 +346,7 @@ func New(config *Config) (*BlockChain, error) {
 	}
 
 	b.pruner = newChainPruner(&b)
+	b.pruner.pruneChain()
 
 	log.Info(fmt.Sprintf("DAG Type:%s", b.bd.GetName()))
 	log.Info("Blockchain database version", "chain", b.dbInfo.version, "compression", b.dbInfo.compVer,
@@ -442,28 +499,52 @@ func (b *BlockChain) initChainState(interrupt <-chan struct{}) error {
 		// Determine how many blocks will be loaded into the index in order to
 		// allocate the right amount as a single alloc versus a whole bunch of
 		// littles ones to reduce pressure on the GC.
-		var block *types.SerializedBlock
 		for i := uint(0); i < uint(state.total); i++ {
 			blockHash := b.bd.GetBlockHash(i)
-			block, err = dbFetchBlockByHash(dbTx, blockHash)
-			if err != nil {
+			// The nodes of the pruned blocks are reloaded on demand.
+			if b.bd.IsPruned(blockHash) {
+				continue
+			}
+			refblock := b.bd.GetBlock(blockHash)
+			var header *types.BlockHeader
+			var parentHashes []*hash.Hash
+			block, err := dbFetchBlockByHash(dbTx, blockHash)
+			if err == nil {
+				header = &block.Block().Header
+				parentHashes = block.Block().Parents
+			} else if database.IsError(err, database.ErrBlockNotFound) {
+				// The block data was pruned from the database, so the
+				// header is loaded from the block index and the parents
+				// from the block DAG.
+				header, err = dbFetchBlockHeader(dbTx, blockHash)
+				if err != nil {
+					return err
+				}
+				if refblock.HasParents() {
+					parentHashes = refblock.GetParents().SortList(false)
+				}
+			} else {
 				return err
 			}
-			if i != 0 && block.Block().Header.Version != b.BlockVersion {
+			if i != 0 && baseBlockVersion(header.Version) != b.BlockVersion {
 				return fmt.Errorf("The dag block is not match current genesis block. you can cleanup your block data base by '--cleanup'.")
 			}
 			parents := []*blockNode{}
-			for _, pb := range block.Block().Parents {
+			for _, pb := range parentHashes {
+				// The children only keep the hash of the pruned parent.
+				if b.bd.IsPruned(pb) {
+					parents = append(parents, &blockNode{hash: *pb})
+					continue
+				}
 				parent := b.index.LookupNode(pb)
 				if parent == nil {
 					return fmt.Errorf("Can't find parent %s", pb.String())
 				}
 				parents = append(parents, parent)
 			}
-			refblock := b.bd.GetBlock(blockHash)
 			//
 			node := &blockNode{}
-			initBlockNode(node, &block.Block().Header, parents)
+			initBlockNode(node, header, parents)
 			b.index.addNode(node)
 			node.status = blockStatus(refblock.GetStatus())
 			node.SetOrder(uint64(refblock.GetOrder()))
@@ -476,6 +557,10 @@ func (b *BlockChain) initChainState(interrupt <-chan struct{}) error {
 		// Set the best chain view to the stored best state.
 		// Load the raw block bytes for the best block.
 		mainTip := b.index.lookupNode(b.bd.GetMainChainTip().GetHash())
+		block, err := dbFetchBlockByHash(dbTx, mainTip.GetHash())
+		if err != nil {
+			return err
+		}
 		// Initialize the state related to the best block.
 		blockSize := uint64(block.Block().SerializeSize())
 		numTxns := uint64(len(block.Block().Transactions))
@@ -691,6 +776,109 @@ func (b *BlockChain) DumpBlockChain(dumpFile string, params *params.Params, orde
 	return nil
 }
 
+// ImportBlockChain reads the blocks of a flat file written by DumpBlockChain
+// and processes them in the DAG order they were written.  The blocks which are
+// already known are skipped.  The scripts of the blocks before the latest
+// checkpoint are only verified when skipScripts is false.  The import stops
+// when the interrupt channel is closed.
+func (b *BlockChain) ImportBlockChain(importFile string, params *params.Params, skipScripts bool, interrupt <-chan struct{}) error {
+	log.Info("Importing the blockchain from a flat file, please wait...")
+
+	progressLogger := progresslog.NewBlockProgressLogger("Imported", log)
+
+	file, err := os.Open(importFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	b.chainLock.Lock()
+	b.verifyCheckpointed = !skipScripts
+	b.chainLock.Unlock()
+	defer func() {
+		b.chainLock.Lock()
+		b.verifyCheckpointed = false
+		b.chainLock.Unlock()
+	}()
+
+	// The blocks are written sequentially after the genesis block.
+	reader := bufio.NewReader(file)
+	var imported, skipped uint64
+	var header [8]byte
+	for order := uint64(1); ; order++ {
+		select {
+		case <-interrupt:
+			return fmt.Errorf("interrupted after importing %d blocks",
+				imported)
+		default:
+		}
+
+		// Read the network ID and the size of the block, the end of the
+		// file is only expected before them.
+		_, err := io.ReadFull(reader, header[:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the block at order %d: %v",
+				order, err)
+		}
+		net := binary.LittleEndian.Uint32(header[:4])
+		if net != uint32(params.Net) {
+			return fmt.Errorf("the block at order %d is for network %v "+
+				"instead of %v", order, protocol.Network(net), params.Net)
+		}
+		size := binary.LittleEndian.Uint32(header[4:])
+		if size > types.MaxBlockPayload {
+			return fmt.Errorf("the block at order %d is %d bytes, which "+
+				"is larger than the max allowed %d bytes", order, size,
+				types.MaxBlockPayload)
+		}
+
+		blB := make([]byte, size)
+		_, err = io.ReadFull(reader, blB)
+		if err != nil {
+			return fmt.Errorf("failed to read the block at order %d: %v",
+				order, err)
+		}
+		block, err := types.NewBlockFromBytes(blB)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize the block at order "+
+				"%d: %v", order, err)
+		}
+
+		exists, err := b.HaveBlock(block.Hash())
+		if err != nil {
+			return err
+		}
+		if exists {
+			skipped++
+			continue
+		}
+
+		// The parents of a block always come first in the DAG order,
+		// so an orphan means the file is not a dump of a block DAG.
+		isOrphan, err := b.ProcessBlock(block, BFNone)
+		if err != nil {
+			return fmt.Errorf("failed to process block %v at order %d: %v",
+				block.Hash(), order, err)
+		}
+		if isOrphan {
+			return fmt.Errorf("block %v at order %d is an orphan",
+				block.Hash(), order)
+		}
+		imported++
+
+		block.SetOrder(order)
+		progressLogger.LogBlockHeight(block)
+	}
+
+	log.Info(fmt.Sprintf("Successfully imported the blockchain (%d blocks, "+
+		"%d already known) from %s.", imported, skipped, importFile))
+
+	return nil
+}
+
 // BlockByHash returns the block from the main chain with the given hash.
 //
 // This function is safe for concurrent access.
@@ -707,6 +895,20 @@ func (b *BlockChain) BlockByHash(hash *hash.Hash) (*types.SerializedBlock, error
 //
 // This function is safe for concurrent access.
 func (b *BlockChain) HeaderByHash(hash *hash.Hash) (types.BlockHeader, error) {
+	// The node of a pruned block doesn't know the order of its parents, so
+	// the header is loaded from the database.
+	if b.bd.IsPruned(hash) {
+		var header *types.BlockHeader
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			header, err = dbFetchBlockHeader(dbTx, hash)
+			return err
+		})
+		if err != nil {
+			return types.BlockHeader{}, err
+		}
+		return *header, nil
+	}
 	node := b.index.LookupNode(hash)
 	if node == nil {
 		return types.BlockHeader{}, fmt.Errorf("block %s is not known", hash)
@@ -715,6 +917,28 @@ func (b *BlockChain) HeaderByHash(hash *hash.Hash) (types.BlockHeader, error) {
 	return node.Header(), nil
 }
 
+// ParentsByHash returns the ordered parent hashes of the block identified by
+// the given hash or an error if it doesn't exist.  The parents are the leaves
+// of the parent root committed to by the header of the block.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ParentsByHash(hash *hash.Hash) ([]*hash.Hash, error) {
+	// The order of the parents of a pruned block is only known from the
+	// block data, unless the block data was pruned as well.
+	if b.bd.IsPruned(hash) {
+		block, err := b.fetchBlockByHash(hash)
+		if err == nil {
+			return block.Block().Parents, nil
+		}
+	}
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return nil, fmt.Errorf("block %s is not known", hash)
+	}
+
+	return node.GetParents(), nil
+}
+
 // FetchBlockByHash searches the internal chain block stores and the database
 // in an attempt to find the requested block.
 //
@@ -1068,6 +1292,18 @@ func (b *BlockChain) connectBlock(node *blockNode, block *types.SerializedBlock,
 		if err != nil {
 			return err
 		}
+
+		// Commit the state after the block when the state commitment is
+		// enabled.  The state after the block of a loaded utxo snapshot
+		// is committed when the snapshot is loaded, and the state before
+		// it is unknown.
+		if b.stateTrieDB != nil && (b.utxoSnapshot == nil ||
+			node.order > b.utxoSnapshot.order) {
+			err = b.dbConnectBlockState(dbTx, node, view)
+			if err != nil {
+				return err
+			}
+		}
 		// Allow the index manager to call each of the currently active
 		// optional indexes with the block being connected so they can
 		// update themselves accordingly.
@@ -1123,6 +1359,12 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *types.SerializedBlo
 		if err != nil {
 			return err
 		}
+
+		// The state after the block doesn't exist anymore.
+		err = dbRemoveBlockReceipt(dbTx, block.Hash())
+		if err != nil {
+			return err
+		}
 		// Allow the index manager to call each of the currently active
 		// optional indexes with the block being disconnected so they
 		// can update themselves accordingly.
@@ -1193,7 +1435,7 @@ func (b *BlockChain) reorganizeChain(detachNodes BlockNodeList, attachNodes *lis
 		// already in the view.
 		var stxos []SpentTxOut
 		view := NewUtxoViewpoint()
-		if !b.index.NodeStatus(n).KnownInvalid() {
+		if !b.index.NodeStatus(n).KnownInvalid() && !b.isInUtxoSnapshot(n) {
 			view.SetBestHash(block.Hash())
 			err = view.fetchInputUtxos(b.db, block, b)
 			if err != nil {
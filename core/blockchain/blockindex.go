@@ -2,6 +2,7 @@
 package blockchain
 
 import (
+	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/types"
@@ -41,6 +42,7 @@ type blockIndex struct {
 	// separate mutex.
 	db     database.DB
 	params *params.Params
+	bd     *blockdag.BlockDAG
 
 	sync.RWMutex
 	index map[hash.Hash]*blockNode
@@ -50,21 +52,96 @@ type blockIndex struct {
 // newBlockIndex returns a new empty instance of a block index.  The index will
 // be dynamically populated as block nodes are loaded from the database and
 // manually added.
-func newBlockIndex(db database.DB, par *params.Params) *blockIndex {
+func newBlockIndex(db database.DB, par *params.Params, bd *blockdag.BlockDAG) *blockIndex {
 	return &blockIndex{
 		db:     db,
 		params: par,
+		bd:     bd,
 		index:  make(map[hash.Hash]*blockNode),
 		dirty:  make(map[*blockNode]struct{}),
 	}
 }
 
 // lookupNode returns the block node identified by the provided hash.  It will
-// return nil if there is no entry for the hash.
+// return nil if there is no entry for the hash.  The node of a pruned block is
+// reloaded from the database.
 //
 // This function MUST be called with the block index lock held (for reads).
 func (bi *blockIndex) lookupNode(hash *hash.Hash) *blockNode {
-	return bi.index[*hash]
+	node, ok := bi.index[*hash]
+	if !ok {
+		return bi.loadPrunedNode(hash)
+	}
+	return node
+}
+
+// loadPrunedNode rebuilds the node of a block which was pruned from memory by
+// the header stored in the database and the block DAG.  The node is not added
+// to the index again, its parents only carry their hashes in the order of the
+// block DAG and its work sum only covers the block itself.
+func (bi *blockIndex) loadPrunedNode(h *hash.Hash) *blockNode {
+	if bi.bd == nil || !bi.bd.IsPruned(h) {
+		return nil
+	}
+	block := bi.bd.GetBlock(h)
+	if block == nil {
+		return nil
+	}
+	var header *types.BlockHeader
+	err := bi.db.View(func(dbTx database.Tx) error {
+		var err error
+		header, err = dbFetchBlockHeader(dbTx, h)
+		return err
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to load pruned block node %s: %v", h, err))
+		return nil
+	}
+	parents := []*blockNode{}
+	if block.HasParents() {
+		for _, ph := range block.GetParents().SortList(false) {
+			parents = append(parents, &blockNode{hash: *ph})
+		}
+	}
+	node := newBlockNode(header, parents)
+	node.status = blockStatus(block.GetStatus())
+	node.SetOrder(uint64(block.GetOrder()))
+	node.SetHeight(block.GetHeight())
+	node.SetLayer(block.GetLayer())
+	return node
+}
+
+// prune removes the nodes of the pruned blocks from the index.  The children
+// which are kept replace their pruned parents with nodes that only carry the
+// hash, so the pruned nodes can be freed.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) prune(hashes []*hash.Hash) {
+	bi.Lock()
+	defer bi.Unlock()
+
+	pruned := make(map[*blockNode]struct{}, len(hashes))
+	for _, h := range hashes {
+		node, ok := bi.index[*h]
+		if !ok {
+			continue
+		}
+		pruned[node] = struct{}{}
+		delete(bi.index, *h)
+		delete(bi.dirty, node)
+	}
+	for node := range pruned {
+		for _, child := range node.children {
+			if _, ok := pruned[child]; ok {
+				continue
+			}
+			for i, parent := range child.parents {
+				if parent == node {
+					child.parents[i] = &blockNode{hash: node.hash}
+				}
+			}
+		}
+	}
 }
 
 // LookupNode returns the block node identified by the provided hash.  It will
@@ -109,7 +186,7 @@ func (bi *blockIndex) HaveBlock(hash *hash.Hash) bool {
 	bi.RLock()
 	_, hasBlock := bi.index[*hash]
 	bi.RUnlock()
-	return hasBlock
+	return hasBlock || (bi.bd != nil && bi.bd.IsPruned(hash))
 }
 
 // NodeStatus returns the status associated with the provided node.
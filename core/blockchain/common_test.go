@@ -0,0 +1,208 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/database/statedb"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// testBlockVersion is the block version of the test networks.
+const testBlockVersion = 8
+
+// testChain is a block chain on a temporary database which builds the blocks
+// extending it.
+type testChain struct {
+	t      *testing.T
+	params *params.Params
+	dbPath string
+	db     database.DB
+	chain  *BlockChain
+
+	// stateDB is the state database of the chain, the state commitment is
+	// disabled when it is nil.
+	stateDB statedb.Database
+
+	// The number of built blocks, which makes their coinbases unique, and
+	// the timestamp of the last built block.
+	built    int64
+	lastTime time.Time
+}
+
+// newTestChain creates a block chain on a new database for the network of the
+// parameters.
+func newTestChain(t *testing.T, par *params.Params) *testChain {
+	dbPath, err := ioutil.TempDir("", "blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.Create("ffldb", dbPath, par.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("Failed to create the database: %v", err)
+	}
+	tc := &testChain{
+		t:        t,
+		params:   par,
+		dbPath:   dbPath,
+		db:       db,
+		lastTime: time.Now().Add(-24 * time.Hour).Truncate(time.Second),
+	}
+	tc.chain = tc.newChain()
+	return tc
+}
+
+// newChain creates the block chain on the database of the test chain.
+func (tc *testChain) newChain() *BlockChain {
+	chain, err := New(&Config{
+		DB:           tc.db,
+		ChainParams:  tc.params,
+		TimeSource:   NewMedianTime(),
+		DAGType:      "phantom",
+		BlockVersion: testBlockVersion,
+		StateDB:      tc.stateDB,
+	})
+	if err != nil {
+		tc.close()
+		tc.t.Fatalf("Failed to create the block chain: %v", err)
+	}
+	return chain
+}
+
+// restart reopens the database and loads the block chain from it, as a node
+// does when it restarts.
+func (tc *testChain) restart() {
+	tc.db.Close()
+	db, err := database.Open("ffldb", tc.dbPath, tc.params.Net)
+	if err != nil {
+		os.RemoveAll(tc.dbPath)
+		tc.t.Fatalf("Failed to open the database: %v", err)
+	}
+	tc.db = db
+	tc.chain = tc.newChain()
+}
+
+// close closes and removes the database.
+func (tc *testChain) close() {
+	tc.db.Close()
+	os.RemoveAll(tc.dbPath)
+}
+
+// newBlock builds a block on the parents holding the transactions after its
+// coinbase, which pays the subsidy to an OP_TRUE output.  The main chain tip
+// is used when there are no parents.  The block doesn't solve its proof of
+// work, so it must be processed with BFNoPoWCheck.
+func (tc *testChain) newBlock(parents []*hash.Hash, txs []*types.Transaction) *types.SerializedBlock {
+	b := tc.chain
+	if len(parents) == 0 {
+		parents = []*hash.Hash{b.bd.GetMainChainTip().GetHash()}
+	}
+	parentsSet := blockdag.NewHashSet()
+	parentsSet.AddList(parents)
+	mainParent := b.bd.GetMainParent(parentsSet)
+	height := uint64(mainParent.GetHeight() + 1)
+	tc.built++
+
+	coinbaseScript, err := txscript.NewScriptBuilder().AddInt64(int64(height)).
+		AddInt64(tc.built).Script()
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(&types.TxInput{
+		PreviousOut: *types.NewOutPoint(&hash.Hash{}, types.MaxPrevOutIndex),
+		Sequence:    types.MaxTxInSequenceNum,
+		SignScript:  coinbaseScript,
+	})
+	subsidy := b.subsidyCache.CalcBlockSubsidy(int64(b.bd.GetBlues(parentsSet)))
+	coinbase.AddTxOut(types.NewTxOutput(uint64(subsidy), []byte{txscript.OP_TRUE}))
+
+	blockTxs := []*types.Tx{types.NewTx(coinbase)}
+	for _, tx := range txs {
+		blockTxs = append(blockTxs, types.NewTx(tx))
+	}
+	// The coinbase commits to the witness root.
+	merkles := merkle.BuildMerkleTreeStore(blockTxs, true)
+	witnessPreimage := append(merkles[len(merkles)-1].Bytes(), coinbaseScript...)
+	coinbase.TxIn[0].PreviousOut.Hash = hash.DoubleHashH(witnessPreimage)
+	blockTxs[0] = types.NewTx(coinbase)
+
+	// The difficulty follows the main parent, which isn't the main chain
+	// tip for the blocks off the main chain.
+	tc.lastTime = tc.lastTime.Add(time.Second)
+	diffInstance := pow.GetInstance(pow.BLAKE2BD, 0, []byte{})
+	diffInstance.SetParams(tc.params.PowConfig)
+	diffInstance.SetMainHeight(int64(height))
+	b.chainLock.Lock()
+	difficulty, err := b.calcNextRequiredDifficulty(
+		b.index.lookupNode(mainParent.GetHash()), tc.lastTime, diffInstance)
+	b.chainLock.Unlock()
+	if err != nil {
+		tc.t.Fatalf("Failed to calculate the difficulty: %v", err)
+	}
+	version, err := b.CalcNextBlockVersion()
+	if err != nil {
+		tc.t.Fatalf("Failed to calculate the block version: %v", err)
+	}
+	// The proof of work isn't checked, so the parameters used to compare
+	// the difficulty to the checkpoints must be set.
+	powInstance := pow.GetInstance(pow.BLAKE2BD, 0, []byte{})
+	powInstance.SetParams(tc.params.PowConfig)
+	merkles = merkle.BuildMerkleTreeStore(blockTxs, false)
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	block := &types.Block{
+		Header: types.BlockHeader{
+			Version:    version,
+			ParentRoot: *paMerkles[len(paMerkles)-1],
+			TxRoot:     *merkles[len(merkles)-1],
+			Timestamp:  tc.lastTime,
+			Difficulty: difficulty,
+			Pow:        powInstance,
+		},
+	}
+	for _, parent := range parents {
+		if err := block.AddParent(parent); err != nil {
+			tc.t.Fatal(err)
+		}
+	}
+	for _, tx := range blockTxs {
+		if err := block.AddTransaction(tx.Transaction()); err != nil {
+			tc.t.Fatal(err)
+		}
+	}
+	return types.NewBlock(block)
+}
+
+// processBlock processes the block without checking its proof of work.
+func (tc *testChain) processBlock(block *types.SerializedBlock) error {
+	isOrphan, err := tc.chain.ProcessBlock(block, BFNoPoWCheck)
+	if err == nil && isOrphan {
+		tc.t.Fatalf("Block %s is an orphan", block.Hash())
+	}
+	return err
+}
+
+// mine extends the main chain by the number of blocks and returns them.
+func (tc *testChain) mine(n int) []*types.SerializedBlock {
+	blocks := make([]*types.SerializedBlock, 0, n)
+	for i := 0; i < n; i++ {
+		block := tc.newBlock(nil, nil)
+		if err := tc.processBlock(block); err != nil {
+			tc.t.Fatalf("Failed to process block %d: %v", i, err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
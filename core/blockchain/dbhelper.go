@@ -545,6 +545,24 @@ func dbFetchBlockByHash(dbTx database.Tx, hash *hash.Hash) (*types.SerializedBlo
 	return block, nil
 }
 
+// dbFetchBlockHeader uses an existing database transaction to retrieve the
+// header of the block for the provided hash.  The header is still available
+// when the block data was pruned from the database.
+func dbFetchBlockHeader(dbTx database.Tx, hash *hash.Hash) (*types.BlockHeader, error) {
+	headerBytes, err := dbTx.FetchBlockHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var header types.BlockHeader
+	err = header.Deserialize(bytes.NewReader(headerBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
 // BlockOrderByHash returns the order of the block with the given hash in the
 // chain.
 //
@@ -45,11 +45,16 @@ func (b *BlockChain) calcEasiestDifficulty(bits uint32, duration time.Duration,
 	// difficulty for a given duration is the largest value possible given
 	// the number of retargets for the duration and starting difficulty
 	// multiplied by the max adjustment factor.
+	// The targets of the hash based algorithms grow as they get easier,
+	// while the difficulties of the cuckoo algorithms shrink.
 	newTarget := pow.CompactToBig(bits)
-
+	easierIsLarger := powInstance.CompareDiff(big.NewInt(0), big.NewInt(1))
 	for durationVal > 0 && powInstance.CompareDiff(newTarget, target) {
-		newTarget.Mul(newTarget, adjustmentFactor)
-		newTarget = powInstance.GetNextDiffBig(adjustmentFactor, newTarget, big.NewInt(0))
+		if easierIsLarger {
+			newTarget.Mul(newTarget, adjustmentFactor)
+		} else {
+			newTarget.Div(newTarget, adjustmentFactor)
+		}
 		durationVal -= maxRetargetTimespan
 	}
 
@@ -307,6 +307,15 @@ const (
 	// block that is either not the current best chain tip or its parent.
 	ErrInvalidTemplateParent
 
+	// ErrPrunedParent indicates that a parent of the block was pruned, so
+	// the block is too far below the tips of the block DAG.
+	ErrPrunedParent
+
+	// ErrUtxoSnapshotMismatch indicates that the block at the order of the
+	// utxo snapshot the utxo set was loaded from is not the block of the
+	// snapshot.
+	ErrUtxoSnapshotMismatch
+
 	// numErrorCodes is the maximum error code number used in tests.
 	numErrorCodes
 
@@ -396,6 +405,8 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrInvalidEarlyFinalState: "ErrInvalidEarlyFinalState",
 	ErrInvalidAncestorBlock:   "ErrInvalidAncestorBlock",
 	ErrInvalidTemplateParent:  "ErrInvalidTemplateParent",
+	ErrPrunedParent:           "ErrPrunedParent",
+	ErrUtxoSnapshotMismatch:   "ErrUtxoSnapshotMismatch",
 	ErrMissingCoinbaseHeight:  "ErrMissingCoinbaseHeight",
 	//cuckoo,begin
 	ErrBadCuckooNonces: "ErrBadCuckooNonces",
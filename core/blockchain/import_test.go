@@ -0,0 +1,182 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// solve returns the block with the nonce solving its proof of work, which the
+// imported blocks are checked for.
+func (tc *testChain) solve(block *types.SerializedBlock) *types.SerializedBlock {
+	header := &block.Block().Header
+	for nonce := uint32(0); nonce < 1<<20; nonce++ {
+		header.Pow = pow.GetInstance(pow.BLAKE2BD, nonce, []byte{})
+		header.Pow.SetParams(tc.params.PowConfig)
+		err := header.Pow.Verify(header.BlockData(), header.BlockHash(),
+			header.Difficulty)
+		if err == nil {
+			return types.NewBlock(block.Block())
+		}
+	}
+	tc.t.Fatalf("No nonce solves the block")
+	return nil
+}
+
+// mineSolved extends the main chain by the number of blocks solving their
+// proof of work and returns them.
+func (tc *testChain) mineSolved(n int) []*types.SerializedBlock {
+	blocks := make([]*types.SerializedBlock, 0, n)
+	for i := 0; i < n; i++ {
+		block := tc.solve(tc.newBlock(nil, nil))
+		if err := tc.processBlock(block); err != nil {
+			tc.t.Fatalf("Failed to process block %d: %v", i, err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// TestImportBlockChain checks that the blocks of a dump are imported in their
+// order, that the scripts of the blocks below the latest checkpoint are only
+// skipped on request, that the blocks not matching a checkpoint are rejected
+// and that an import resumes after the blocks which are already known.
+func TestImportBlockChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dumpFile := filepath.Join(dir, "blocks.dat")
+	partFile := filepath.Join(dir, "part.dat")
+
+	// The source chain doesn't verify the scripts, so it accepts a block
+	// spending the coinbase of the first block with a failing script as
+	// valid.
+	src := newTestChain(t, &params.PrivNetParams)
+	defer src.close()
+	src.chain.noVerify = true
+	blocks := src.mineSolved(int(src.params.CoinbaseMaturity) + 1)
+	coinbase := blocks[0].Transactions()[0]
+	tx := types.NewTransaction()
+	tx.AddTxIn(&types.TxInput{
+		PreviousOut: *types.NewOutPoint(coinbase.Hash(), 0),
+		Sequence:    types.MaxTxInSequenceNum,
+		SignScript:  []byte{txscript.OP_FALSE, txscript.OP_VERIFY},
+	})
+	tx.AddTxOut(types.NewTxOutput(coinbase.Tx.TxOut[0].Amount,
+		[]byte{txscript.OP_TRUE}))
+	badBlock := src.solve(src.newBlock(nil, []*types.Transaction{tx}))
+	if err := src.processBlock(badBlock); err != nil {
+		t.Fatalf("Failed to process the block with the failing script: %v", err)
+	}
+	blocks = append(blocks, badBlock)
+	blocks = append(blocks, src.mineSolved(3)...)
+	if err := src.chain.DumpBlockChain(dumpFile, src.params,
+		uint64(len(blocks))); err != nil {
+		t.Fatalf("Failed to dump the blocks: %v", err)
+	}
+	if err := src.chain.DumpBlockChain(partFile, src.params, 10); err != nil {
+		t.Fatalf("Failed to dump the first blocks: %v", err)
+	}
+	tip := blocks[len(blocks)-1].Hash()
+
+	// checkImported fails the test unless exactly the blocks before the
+	// passed order are known, and the block with the failing script is
+	// invalid when the scripts were verified.
+	checkImported := func(name string, tc *testChain, order int, verified bool) {
+		for i, block := range blocks {
+			have, err := tc.chain.HaveBlock(block.Hash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := i+1 < order; have != want {
+				t.Fatalf("%s: block %d known %v, want %v", name, i+1,
+					have, want)
+			}
+			if !have {
+				continue
+			}
+			node := tc.chain.index.LookupNode(block.Hash())
+			invalid := tc.chain.index.NodeStatus(node).KnownInvalid()
+			if want := verified && block == badBlock; invalid != want {
+				t.Fatalf("%s: block %d invalid %v, want %v", name, i+1,
+					invalid, want)
+			}
+		}
+		if tc.chain.verifyCheckpointed {
+			t.Fatalf("%s: checkpointed blocks are still verified", name)
+		}
+	}
+
+	// The checkpoint on the last block covers the failing script, which is
+	// only found when the scripts aren't skipped.
+	par := params.PrivNetParams
+	par.Checkpoints = []params.Checkpoint{{Layer: uint64(len(blocks)), Hash: tip}}
+	tests := []struct {
+		name        string
+		skipScripts bool
+	}{
+		{"verified scripts", false},
+		{"skipped scripts", true},
+	}
+	for _, test := range tests {
+		tc := newTestChain(t, &par)
+		err := tc.chain.ImportBlockChain(dumpFile, &par, test.skipScripts, nil)
+		if err != nil {
+			tc.close()
+			t.Fatalf("%s: failed to import the blocks: %v", test.name, err)
+		}
+		checkImported(test.name, tc, len(blocks)+1, !test.skipScripts)
+		if best := tc.chain.BestSnapshot(); best.Hash != *tip {
+			t.Errorf("%s: got main chain tip %v, want %v", test.name,
+				best.Hash, tip)
+		}
+		tc.close()
+	}
+
+	// The import resumes after the blocks imported before.
+	tc := newTestChain(t, &par)
+	defer tc.close()
+	if err := tc.chain.ImportBlockChain(partFile, &par, false, nil); err != nil {
+		t.Fatalf("Failed to import the first blocks: %v", err)
+	}
+	checkImported("first blocks", tc, 11, true)
+	if err := tc.chain.ImportBlockChain(dumpFile, &par, false, nil); err != nil {
+		t.Fatalf("Failed to resume the import: %v", err)
+	}
+	checkImported("resumed import", tc, len(blocks)+1, true)
+
+	// A block which doesn't match its checkpoint is rejected along with
+	// the blocks after it.
+	wrong := hash.DoubleHashH([]byte("wrong"))
+	par2 := params.PrivNetParams
+	par2.Checkpoints = []params.Checkpoint{{Layer: 5, Hash: &wrong}}
+	tc2 := newTestChain(t, &par2)
+	defer tc2.close()
+	err = tc2.chain.ImportBlockChain(dumpFile, &par2, false, nil)
+	if err == nil {
+		t.Fatalf("Imported the block not matching its checkpoint")
+	}
+	checkImported("wrong checkpoint", tc2, 5, true)
+
+	// The import stops on interrupt.
+	tc3 := newTestChain(t, &params.PrivNetParams)
+	defer tc3.close()
+	interrupt := make(chan struct{})
+	close(interrupt)
+	err = tc3.chain.ImportBlockChain(dumpFile, &params.PrivNetParams, false,
+		interrupt)
+	if err == nil {
+		t.Fatalf("Interrupted import succeeded")
+	}
+	checkImported("interrupted import", tc3, 1, true)
+}
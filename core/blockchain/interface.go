@@ -23,6 +23,8 @@ type TxPool interface {
 
 	RemoveTransaction(tx *types.Tx, removeRedeemers bool)
 
+	RemoveReorgedTransaction(tx *types.Tx)
+
 	RemoveDoubleSpends(tx *types.Tx)
 
 	RemoveOrphan(txHash *hash.Hash)
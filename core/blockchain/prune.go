@@ -2,6 +2,9 @@
 package blockchain
 
 import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/database"
 	"time"
 )
 
@@ -9,6 +12,10 @@ import (
 // nodes and restore memory to the garbage collector.
 const pruningIntervalInMinutes = 5
 
+// minPruneDepth is the minimum number of layers below the valid tips of the
+// block DAG whose block nodes are always kept in memory.
+const minPruneDepth = 2048
+
 // chainPruner is used to occasionally prune the blockchain of old nodes that
 // can be freed to the garbage collector.
 type chainPruner struct {
@@ -35,4 +42,113 @@ func (c *chainPruner) pruneChainIfNeeded() {
 		return
 	}
 	c.lastNodeInsertTime = now
+	c.pruneChain()
+}
+
+// pruneChain prunes the old block nodes from memory and, when a prune target
+// is configured, the old block data from the database.
+//
+// pruneChain must be called with the chainLock held for writes.
+func (c *chainPruner) pruneChain() {
+	err := c.chain.pruneBlockNodes()
+	if err != nil {
+		log.Warn(fmt.Sprintf("Failed to prune block nodes: %v", err))
+	}
+	if c.chain.pruneTarget == 0 {
+		return
+	}
+	err = c.chain.pruneBlockData()
+	if err != nil {
+		log.Warn(fmt.Sprintf("Failed to prune block data: %v", err))
+	}
+}
+
+// pruneDepth returns the number of layers below the valid tips whose block
+// nodes are kept in memory.  It always covers the nodes which are needed to
+// calculate the difficulty.
+func (b *BlockChain) pruneDepth() uint {
+	depth := uint(2 * b.params.WorkDiffWindowSize * b.params.WorkDiffWindows)
+	if depth < minPruneDepth {
+		depth = minPruneDepth
+	}
+	return depth
+}
+
+// pruneLayer returns the layer below which the blocks are pruned, which is
+// pruneDepth layers below the lowest valid tip.  It returns zero when nothing
+// can be pruned yet.
+func (b *BlockChain) pruneLayer() uint {
+	var minLayer uint
+	for i, h := range b.bd.GetValidTips() {
+		layer := b.bd.GetLayer(h)
+		if i == 0 || layer < minLayer {
+			minLayer = layer
+		}
+	}
+	depth := b.pruneDepth()
+	if minLayer <= depth {
+		return 0
+	}
+	return minLayer - depth
+}
+
+// pruneBlockNodes removes the block nodes far below the valid tips from the
+// block index and the block DAG.  The pruned nodes are reloaded from the
+// database on demand.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) pruneBlockNodes() error {
+	layer := b.pruneLayer()
+	if layer == 0 {
+		return nil
+	}
+
+	// The pruned blocks are reloaded from the database, so they must be
+	// stored with their latest status.
+	err := b.index.flushToDB(b.bd)
+	if err != nil {
+		return err
+	}
+	pruned := b.bd.Prune(layer)
+	if len(pruned) == 0 {
+		return nil
+	}
+	b.index.prune(pruned)
+	log.Debug(fmt.Sprintf("Pruned %d block nodes below layer %d", len(pruned),
+		layer))
+	return nil
+}
+
+// pruneBlockData deletes the oldest block data from the database once the
+// block files exceed the prune target.  The block files are written in the
+// order the blocks arrived, so a file is only deleted when all its blocks are
+// below the prune layer of the block DAG.  The spend journal entries of the
+// pruned blocks are removed too since these blocks can't be disconnected
+// anymore, while the utxo set is kept.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) pruneBlockData() error {
+	layer := b.pruneLayer()
+	if layer == 0 {
+		return nil
+	}
+	// The genesis and the stale tips are not pruned from the block DAG,
+	// but their data is not needed anymore either.
+	canPrune := func(h *hash.Hash) bool {
+		return b.bd.IsPruned(h) ||
+			(b.bd.HasBlock(h) && b.bd.GetLayer(h) < layer)
+	}
+	return b.db.Update(func(dbTx database.Tx) error {
+		pruned, err := dbTx.PruneBlocks(b.pruneTarget, canPrune)
+		if err != nil {
+			return err
+		}
+		for i := range pruned {
+			err := dbRemoveSpendJournalEntry(dbTx, &pruned[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
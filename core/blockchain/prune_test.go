@@ -0,0 +1,95 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"github.com/Qitmeer/qitmeer/params"
+	"testing"
+)
+
+// TestPruneRestart checks that the block nodes far below the tips are pruned
+// from memory, are not loaded into memory again when the chain is restarted
+// and are still available from the database.
+func TestPruneRestart(t *testing.T) {
+	tc := newTestChain(t, &params.PrivNetParams)
+	defer tc.close()
+
+	b := tc.chain
+	blocks := tc.mine(int(b.pruneDepth()) + 64)
+	b.chainLock.Lock()
+	b.pruner.pruneChain()
+	b.chainLock.Unlock()
+
+	layer := b.pruneLayer()
+	if layer == 0 {
+		t.Fatal("Nothing can be pruned")
+	}
+	checkPruned := func(stage string) {
+		b := tc.chain
+		for i, block := range blocks {
+			h := block.Hash()
+			// The blocks are on the layer after their order.
+			want := uint(i+1) < layer
+			if b.bd.IsPruned(h) != want {
+				t.Fatalf("%s: block %d pruned %v, want %v", stage, i+1,
+					b.bd.IsPruned(h), want)
+			}
+			if !want {
+				continue
+			}
+			b.index.RLock()
+			_, inMemory := b.index.index[*h]
+			b.index.RUnlock()
+			if inMemory {
+				t.Fatalf("%s: node of pruned block %d is in memory", stage, i+1)
+			}
+			if !b.index.HaveBlock(h) || !b.bd.HasBlock(h) {
+				t.Fatalf("%s: pruned block %d is unknown", stage, i+1)
+			}
+			if !b.bd.IsOnMainChain(h) || !b.bd.IsBlue(h) {
+				t.Fatalf("%s: pruned block %d left the main chain", stage, i+1)
+			}
+			if order := b.bd.GetBlock(h).GetOrder(); order != uint(i+1) {
+				t.Fatalf("%s: pruned block %d has order %d", stage, i+1, order)
+			}
+			header, err := b.HeaderByHash(h)
+			if err != nil || header.BlockHash() != *h {
+				t.Fatalf("%s: header of pruned block %d: %v", stage, i+1, err)
+			}
+			node := b.index.LookupNode(h)
+			if node == nil || node.GetHeight() != uint(i+1) {
+				t.Fatalf("%s: node of pruned block %d wasn't reloaded", stage,
+					i+1)
+			}
+		}
+		if b.bd.IsPruned(b.params.GenesisHash) {
+			t.Fatalf("%s: the genesis was pruned", stage)
+		}
+		if !b.bd.IsOnMainChain(b.params.GenesisHash) {
+			t.Fatalf("%s: the genesis left the main chain", stage)
+		}
+	}
+	checkPruned("pruned")
+	prunedBlock, err := b.BlockByOrder(1)
+	if err != nil || *prunedBlock.Hash() != *blocks[0].Hash() {
+		t.Fatalf("Failed to fetch the pruned block by order: %v", err)
+	}
+
+	// The pruned nodes stay out of memory after the restart.
+	tc.restart()
+	checkPruned("restarted")
+	tip := tc.chain.bd.GetMainChainTip().GetHash()
+	if *tip != *blocks[len(blocks)-1].Hash() {
+		t.Fatalf("The main chain tip %s changed after the restart", tip)
+	}
+	if tips := tc.chain.bd.GetTips(); tips.Size() != 1 || !tips.Has(tip) {
+		t.Fatalf("Got tips %v after the restart, want only %s",
+			tips.List(), tip)
+	}
+
+	// The chain still grows on its tips.
+	more := tc.mine(2)
+	if *tc.chain.bd.GetMainChainTip().GetHash() != *more[1].Hash() {
+		t.Fatal("The blocks after the restart didn't extend the main chain")
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/database/statedb"
+	"github.com/Qitmeer/qitmeer/trie"
+)
+
+// -----------------------------------------------------------------------------
+// The state commitment is an optional trie of the utxo set whose root is
+// committed in a receipt of every connected block.  The trie maps the same
+// keys to the same values as the utxo set bucket, so a node can prove to a
+// client which holds the receipt of a block whether an output was unspent
+// after the block.
+//
+// The trie nodes are stored in a separate state database, while the receipts
+// are stored in the block database alongside the blocks.  A block is applied
+// to the trie of the block before it in the DAG order.  When that receipt
+// doesn't exist, for example when the state commitment was just enabled, the
+// block gets no receipt, and the trie is rebuilt from the whole utxo set for
+// the main chain tip when the chain is started.
+//
+// The trie nodes of a block are written before the database transaction of the
+// block commits.  So the node can stop with nodes no receipt refers to, which
+// are harmless, or with receipts whose nodes were never written, which are
+// removed when the chain is started.
+//
+// The serialized format for keys and values in the receipt bucket is:
+//   <hash> = <block receipt>
+//
+//   Field           Type               Size
+//   hash            hash.Hash          32 bytes
+//   block receipt   types.BlockReceipt variable
+// -----------------------------------------------------------------------------
+
+// dbFetchBlockReceipt fetches the receipt of the block with the given hash.
+// nil is returned when there is no receipt for the block.
+func dbFetchBlockReceipt(dbTx database.Tx, blockHash *hash.Hash) (*types.BlockReceipt, error) {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.BlockReceiptBucketName)
+	if bucket == nil {
+		return nil, nil
+	}
+	serialized := bucket.Get(blockHash[:])
+	if serialized == nil {
+		return nil, nil
+	}
+	var receipt types.BlockReceipt
+	err := receipt.Deserialize(bytes.NewReader(serialized))
+	if err != nil {
+		return nil, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt block receipt of %s: %v", blockHash, err),
+		}
+	}
+	return &receipt, nil
+}
+
+// dbPutBlockReceipt stores the receipt of the block with the given hash.
+func dbPutBlockReceipt(dbTx database.Tx, blockHash *hash.Hash, receipt *types.BlockReceipt) error {
+	var buf bytes.Buffer
+	err := receipt.Serialize(&buf)
+	if err != nil {
+		return err
+	}
+	bucket := dbTx.Metadata().Bucket(dbnamespace.BlockReceiptBucketName)
+	return bucket.Put(blockHash[:], buf.Bytes())
+}
+
+// dbRemoveBlockReceipt removes the receipt of the block with the given hash.
+// The receipts of the disconnected blocks are always removed, even when the
+// state commitment is disabled, so they never refer to a stale state.
+func dbRemoveBlockReceipt(dbTx database.Tx, blockHash *hash.Hash) error {
+	bucket := dbTx.Metadata().Bucket(dbnamespace.BlockReceiptBucketName)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete(blockHash[:])
+}
+
+// proofList collects the nodes of a Merkle proof in the order from the root,
+// which is the order they are put by the trie, and keeps them by hash so the
+// proof can be verified.
+type proofList struct {
+	nodes [][]byte
+	db    *statedb.MemDatabase
+}
+
+// Put adds a node of the proof.
+//
+// This is part of the statedb.Putter interface implementation.
+func (p *proofList) Put(key []byte, value []byte) error {
+	p.nodes = append(p.nodes, value)
+	return p.db.Put(key, value)
+}
+
+// commitStateTrie writes the nodes of the trie to the state database and
+// returns its root.
+func (b *BlockChain) commitStateTrie(t *trie.Trie) (hash.Hash, error) {
+	root, err := t.Commit(nil)
+	if err != nil {
+		return hash.Hash{}, err
+	}
+	err = b.stateTrieDB.Commit(root, false)
+	if err != nil {
+		return hash.Hash{}, err
+	}
+	return root, nil
+}
+
+// dbBuildStateTrie builds the state trie from the whole utxo set and returns
+// its root.
+func (b *BlockChain) dbBuildStateTrie(dbTx database.Tx) (hash.Hash, error) {
+	t, err := trie.New(hash.Hash{}, b.stateTrieDB)
+	if err != nil {
+		return hash.Hash{}, err
+	}
+	utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+	err = utxoBucket.ForEach(func(k, v []byte) error {
+		// The trie keeps the value, which is only valid during the
+		// transaction.
+		value := make([]byte, len(v))
+		copy(value, v)
+		return t.TryUpdate(k, value)
+	})
+	if err != nil {
+		return hash.Hash{}, err
+	}
+	return b.commitStateTrie(t)
+}
+
+// dbConnectBlockState applies the changes of the utxo view of a block to the
+// state trie and stores the receipt of the block.  It must be called after the
+// utxo view is written to the utxo set.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) dbConnectBlockState(dbTx database.Tx, node *blockNode, view *UtxoViewpoint) error {
+	var prevReceipt *types.BlockReceipt
+	if node.order > 0 {
+		prevHash := b.bd.GetBlockByOrder(uint(node.order - 1))
+		if prevHash != nil {
+			var err error
+			prevReceipt, err = dbFetchBlockReceipt(dbTx, prevHash)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Rebuilding the trie takes too long to be done while a block is
+	// connected, so the trie is only rebuilt when the chain is started.
+	if prevReceipt == nil {
+		log.Debug(fmt.Sprintf("No state commitment for block %s since the "+
+			"block before it has no receipt", node.hash))
+		return nil
+	}
+
+	t, err := trie.New(prevReceipt.StorageRoot, b.stateTrieDB)
+	if err != nil {
+		return err
+	}
+	for outpoint, entry := range view.entries {
+		if entry == nil || !entry.isModified() {
+			continue
+		}
+		key := outpointKey(outpoint)
+		if entry.IsSpent() {
+			err = t.TryDelete(*key)
+		} else {
+			var serialized []byte
+			serialized, err = serializeUtxoEntry(entry)
+			if err == nil {
+				err = t.TryUpdate(*key, serialized)
+			}
+		}
+		// The trie copies the key, so it can be recycled.
+		recycleOutpointKey(key)
+		if err != nil {
+			return err
+		}
+	}
+	root, err := b.commitStateTrie(t)
+	if err != nil {
+		return err
+	}
+
+	receipt := &types.BlockReceipt{
+		BlockNumber: node.order,
+		StorageRoot: root,
+	}
+	return dbPutBlockReceipt(dbTx, &node.hash, receipt)
+}
+
+// dbRemoveUncommittedReceipts removes the receipts of the latest blocks whose
+// state trie nodes are missing from the state database.  The nodes are written
+// in the DAG order, so all the older receipts are valid once one is.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) dbRemoveUncommittedReceipts(dbTx database.Tx) error {
+	for order := b.bd.GetMainChainTip().GetOrder(); ; order-- {
+		blockHash := b.blockHashByOrder(order)
+		if blockHash == nil {
+			return nil
+		}
+		receipt, err := dbFetchBlockReceipt(dbTx, blockHash)
+		if err != nil {
+			return err
+		}
+		if receipt != nil {
+			_, err := trie.New(receipt.StorageRoot, b.stateTrieDB)
+			if err == nil {
+				return nil
+			}
+			if _, ok := err.(*trie.MissingNodeError); !ok {
+				return err
+			}
+			log.Warn(fmt.Sprintf("Removing the receipt of block %s whose "+
+				"state was not committed", blockHash))
+			err = dbRemoveBlockReceipt(dbTx, blockHash)
+			if err != nil {
+				return err
+			}
+		}
+		if order == 0 {
+			return nil
+		}
+	}
+}
+
+// initStateCommitment creates the receipt bucket, removes the receipts whose
+// state was not committed and commits the state of the main chain tip when it
+// has no receipt.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) initStateCommitment() error {
+	return b.db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucketIfNotExists(dbnamespace.BlockReceiptBucketName)
+		if err != nil {
+			return err
+		}
+		err = b.dbRemoveUncommittedReceipts(dbTx)
+		if err != nil {
+			return err
+		}
+
+		// The state before the block of a loaded utxo snapshot is
+		// unknown.
+		tip := b.bd.GetMainChainTip()
+		if b.utxoSnapshot != nil &&
+			uint64(tip.GetOrder()) < b.utxoSnapshot.order {
+			return nil
+		}
+		receipt, err := dbFetchBlockReceipt(dbTx, tip.GetHash())
+		if err != nil || receipt != nil {
+			return err
+		}
+		log.Info("Building the state trie from the utxo set")
+		root, err := b.dbBuildStateTrie(dbTx)
+		if err != nil {
+			return err
+		}
+		return dbPutBlockReceipt(dbTx, tip.GetHash(), &types.BlockReceipt{
+			BlockNumber: uint64(tip.GetOrder()),
+			StorageRoot: root,
+		})
+	})
+}
+
+// StateCommitmentEnabled returns whether the chain maintains the per-block
+// state commitment.
+func (b *BlockChain) StateCommitmentEnabled() bool {
+	return b.stateTrieDB != nil
+}
+
+// BlockReceipt returns the receipt of the block with the given hash, which
+// commits to the state after the block.  nil is returned when the block has no
+// receipt.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) BlockReceipt(blockHash *hash.Hash) (*types.BlockReceipt, error) {
+	var receipt *types.BlockReceipt
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		receipt, err = dbFetchBlockReceipt(dbTx, blockHash)
+		return err
+	})
+	return receipt, err
+}
+
+// StateProof houses the Merkle proof of an output in the state trie committed
+// by a block receipt.
+type StateProof struct {
+	// Receipt is the receipt of the block which commits to the state.
+	Receipt *types.BlockReceipt
+
+	// Key is the key of the output in the state trie.
+	Key []byte
+
+	// Value is the serialized utxo entry of the output, it is nil when
+	// the output was not unspent.
+	Value []byte
+
+	// Nodes are the trie nodes of the proof in the order from the root.
+	Nodes [][]byte
+}
+
+// FetchStateProof returns the Merkle proof of the given output in the state
+// after the block with the given hash.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchStateProof(blockHash *hash.Hash, outpoint types.TxOutPoint) (*StateProof, error) {
+	if b.stateTrieDB == nil {
+		return nil, fmt.Errorf("the state commitment is disabled")
+	}
+	receipt, err := b.BlockReceipt(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	if receipt == nil {
+		return nil, fmt.Errorf("no block receipt for %s", blockHash)
+	}
+
+	t, err := trie.New(receipt.StorageRoot, b.stateTrieDB)
+	if err != nil {
+		return nil, err
+	}
+	key := outpointKey(outpoint)
+	defer recycleOutpointKey(key)
+	proof := &proofList{db: statedb.NewMemDatabase()}
+	err = t.Prove(*key, 0, proof)
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := trie.VerifyProof(receipt.StorageRoot, *key, proof.db)
+	if err != nil {
+		return nil, err
+	}
+	result := &StateProof{
+		Receipt: receipt,
+		Key:     make([]byte, len(*key)),
+		Value:   value,
+		Nodes:   proof.nodes,
+	}
+	copy(result.Key, *key)
+	return result, nil
+}
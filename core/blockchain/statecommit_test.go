@@ -0,0 +1,72 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database/statedb"
+	"github.com/Qitmeer/qitmeer/params"
+	"testing"
+)
+
+// TestStateCommitmentRestart checks that the receipts whose state trie nodes
+// were lost are removed when the chain is started, and that the state of the
+// main chain tip is committed again.
+func TestStateCommitmentRestart(t *testing.T) {
+	tc := newTestChain(t, &params.PrivNetParams)
+	defer tc.close()
+	tc.stateDB = statedb.NewMemDatabase()
+	tc.restart()
+
+	blocks := tc.mine(4)
+	tip := blocks[len(blocks)-1]
+	var receipts []*types.BlockReceipt
+	for i, block := range blocks {
+		receipt, err := tc.chain.BlockReceipt(block.Hash())
+		if err != nil || receipt == nil {
+			t.Fatalf("No receipt for block %d: %v", i+1, err)
+		}
+		if receipt.BlockNumber != uint64(i+1) {
+			t.Fatalf("Receipt of block %d has number %d", i+1,
+				receipt.BlockNumber)
+		}
+		receipts = append(receipts, receipt)
+	}
+	coinbase := types.TxOutPoint{Hash: *tip.Transactions()[0].Hash()}
+	proof, err := tc.chain.FetchStateProof(tip.Hash(), coinbase)
+	if err != nil || proof.Value == nil {
+		t.Fatalf("Failed to prove the coinbase of the tip: %v", err)
+	}
+
+	// The trie nodes of all the blocks are lost, as if the node stopped
+	// before they were written.
+	tc.stateDB = statedb.NewMemDatabase()
+	tc.restart()
+	for i, block := range blocks[:len(blocks)-1] {
+		receipt, err := tc.chain.BlockReceipt(block.Hash())
+		if err != nil || receipt != nil {
+			t.Fatalf("Uncommitted receipt of block %d wasn't removed: %v",
+				i+1, err)
+		}
+	}
+	receipt, err := tc.chain.BlockReceipt(tip.Hash())
+	if err != nil || receipt == nil {
+		t.Fatalf("No receipt for the tip after the restart: %v", err)
+	}
+	if receipt.StorageRoot != receipts[len(receipts)-1].StorageRoot {
+		t.Fatalf("Rebuilt state root %s, want %s", receipt.StorageRoot,
+			receipts[len(receipts)-1].StorageRoot)
+	}
+	proof, err = tc.chain.FetchStateProof(tip.Hash(), coinbase)
+	if err != nil || proof.Value == nil {
+		t.Fatalf("Failed to prove the coinbase of the tip after the "+
+			"restart: %v", err)
+	}
+
+	// The blocks after the restart are committed on the rebuilt trie.
+	more := tc.mine(1)
+	receipt, err = tc.chain.BlockReceipt(more[0].Hash())
+	if err != nil || receipt == nil {
+		t.Fatalf("No receipt for the block after the restart: %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"math"
+)
+
+// ThresholdState define the various threshold states used when voting on
+// consensus changes.
+type ThresholdState byte
+
+// These constants are used to identify specific threshold states.
+const (
+	// ThresholdDefined is the first state for each deployment and is the
+	// state for the genesis block has by definition for all deployments.
+	ThresholdDefined ThresholdState = iota
+
+	// ThresholdStarted is the state for a deployment once its start time
+	// has been reached.
+	ThresholdStarted
+
+	// ThresholdLockedIn is the state for a deployment during the retarget
+	// window after the window in which the voting for it was successful.
+	ThresholdLockedIn
+
+	// ThresholdActive is the state for a deployment for all blocks after a
+	// retarget window in which the deployment was locked in.
+	ThresholdActive
+
+	// ThresholdFailed is the state for a deployment once its expiration
+	// time has been reached and it did not reach the ThresholdLockedIn
+	// state.
+	ThresholdFailed
+)
+
+// thresholdStateStrings is a map of ThresholdState values back to their
+// constant names for pretty printing.
+var thresholdStateStrings = map[ThresholdState]string{
+	ThresholdDefined:  "ThresholdDefined",
+	ThresholdStarted:  "ThresholdStarted",
+	ThresholdLockedIn: "ThresholdLockedIn",
+	ThresholdActive:   "ThresholdActive",
+	ThresholdFailed:   "ThresholdFailed",
+}
+
+// String returns the ThresholdState as a human-readable name.
+func (t ThresholdState) String() string {
+	if s := thresholdStateStrings[t]; s != "" {
+		return s
+	}
+	return fmt.Sprintf("Unknown ThresholdState (%d)", int(t))
+}
+
+// thresholdConditionChecker provides a generic interface that is invoked to
+// determine when a consensus rule change threshold should be changed.
+type thresholdConditionChecker interface {
+	// BeginTime returns the unix timestamp for the median block time after
+	// which voting on a rule change starts (at the next window).
+	BeginTime() uint64
+
+	// EndTime returns the unix timestamp for the median block time after
+	// which an attempted rule change fails if it has not already been
+	// locked in or activated.
+	EndTime() uint64
+
+	// RuleChangeActivationThreshold is the number of blocks for which the
+	// condition must be true in order to lock in a rule change.
+	RuleChangeActivationThreshold() uint32
+
+	// MinerConfirmationWindow is the number of blocks in each threshold
+	// state retarget window.
+	MinerConfirmationWindow() uint32
+
+	// Condition returns whether or not the rule change activation condition
+	// has been met.  This typically involves checking whether or not the
+	// bit associated with the condition is set, but can be more complex as
+	// needed.
+	Condition(*blockNode) (bool, error)
+}
+
+// thresholdStateCache provides a type to cache the threshold states of each
+// threshold window for a set of IDs.  The windows are keyed by the order of
+// their first block.
+type thresholdStateCache struct {
+	entries map[uint]ThresholdState
+}
+
+// Lookup returns the threshold state associated with the given window start
+// order along with a boolean that indicates whether or not it is valid.
+func (c *thresholdStateCache) Lookup(order uint) (ThresholdState, bool) {
+	state, ok := c.entries[order]
+	return state, ok
+}
+
+// Update updates the cache to contain the provided window start order to
+// threshold state mapping.
+func (c *thresholdStateCache) Update(order uint, state ThresholdState) {
+	c.entries[order] = state
+}
+
+// Invalidate removes the threshold states of all the windows which depend on
+// the blocks at or after the given order.
+func (c *thresholdStateCache) Invalidate(order uint) {
+	for start := range c.entries {
+		if start > order {
+			delete(c.entries, start)
+		}
+	}
+}
+
+// newThresholdCaches returns a new array of caches to be used when calculating
+// threshold states.
+func newThresholdCaches(numCaches uint32) []thresholdStateCache {
+	caches := make([]thresholdStateCache, numCaches)
+	for i := 0; i < len(caches); i++ {
+		caches[i] = thresholdStateCache{
+			entries: make(map[uint]ThresholdState),
+		}
+	}
+	return caches
+}
+
+// invalidateThresholdCaches removes the cached threshold states which depend
+// on the blocks whose orders were changed by the block DAG.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) invalidateThresholdCaches(newOrders *list.List) {
+	if len(b.deploymentCaches) == 0 || newOrders.Len() == 0 {
+		return
+	}
+	minOrder := uint(math.MaxUint32)
+	for e := newOrders.Front(); e != nil; e = e.Next() {
+		ib := b.bd.GetBlock(e.Value.(*hash.Hash))
+		if ib != nil && ib.GetOrder() < minOrder {
+			minOrder = ib.GetOrder()
+		}
+	}
+	for i := range b.deploymentCaches {
+		b.deploymentCaches[i].Invalidate(minOrder)
+	}
+}
+
+// blockHashByOrder returns the hash of the block at the given DAG order or nil
+// when there is no such block.  Unlike the block dag, it also returns the main
+// chain tip at its own order.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) blockHashByOrder(order uint) *hash.Hash {
+	h := b.bd.GetBlockByOrder(order)
+	if h != nil {
+		return h
+	}
+	tip := b.bd.GetMainChainTip()
+	if tip != nil && tip.GetOrder() == order {
+		return tip.GetHash()
+	}
+	return nil
+}
+
+// nodeByOrder returns the block node at the given DAG order or an error when
+// there is no such block.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) nodeByOrder(order uint) (*blockNode, error) {
+	h := b.blockHashByOrder(order)
+	if h == nil {
+		return nil, fmt.Errorf("no block at order %d", order)
+	}
+	node := b.index.lookupNode(h)
+	if node == nil {
+		return nil, fmt.Errorf("unable to find block node %s", h)
+	}
+	return node, nil
+}
+
+// thresholdState returns the current rule change threshold state for the block
+// at the given DAG order.  The windows are the ranges of consecutive orders
+// whose length is the miner confirmation window, since the blocks of the DAG
+// have no other total order, and all the blocks of a window share its state.
+// The state of a window is calculated from the blocks of the previous window.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) thresholdState(order uint, checker thresholdConditionChecker, cache *thresholdStateCache) (ThresholdState, error) {
+	// The threshold state for the window that contains the genesis block
+	// is defined by definition.
+	confirmationWindow := uint(checker.MinerConfirmationWindow())
+	if confirmationWindow == 0 || order < confirmationWindow {
+		return ThresholdDefined, nil
+	}
+
+	// Find the first window start order whose state is known, collecting
+	// the windows whose states need to be calculated.
+	state := ThresholdDefined
+	var neededStates []uint
+	for start := order - (order % confirmationWindow); start >= confirmationWindow; start -= confirmationWindow {
+		// Nothing more to do if the state of the window is already
+		// cached.
+		if cachedState, ok := cache.Lookup(start); ok {
+			state = cachedState
+			break
+		}
+
+		// The start and expiration times are based on the median block
+		// time, so calculate it now.
+		prevNode, err := b.nodeByOrder(start - 1)
+		if err != nil {
+			return ThresholdFailed, err
+		}
+		medianTime := prevNode.CalcPastMedianTime(b)
+
+		// The state is simply defined if the start time hasn't
+		// been reached yet.
+		if uint64(medianTime.Unix()) < checker.BeginTime() {
+			cache.Update(start, ThresholdDefined)
+			break
+		}
+
+		// Add this window to the list of windows that need the state
+		// calculated and cached.
+		neededStates = append(neededStates, start)
+	}
+
+	// Since each threshold state depends on the state of the previous
+	// window, iterate starting from the oldest unknown one.
+	for i := len(neededStates) - 1; i >= 0; i-- {
+		start := neededStates[i]
+		prevNode, err := b.nodeByOrder(start - 1)
+		if err != nil {
+			return ThresholdFailed, err
+		}
+
+		switch state {
+		case ThresholdDefined:
+			// The deployment of the rule change fails if it expires
+			// before it is accepted and locked in.
+			medianTime := prevNode.CalcPastMedianTime(b)
+			medianTimeUnix := uint64(medianTime.Unix())
+			if medianTimeUnix >= checker.EndTime() {
+				state = ThresholdFailed
+				break
+			}
+
+			// The state for the rule moves to the started state
+			// once its start time has been reached (and it hasn't
+			// already expired per the above).
+			if medianTimeUnix >= checker.BeginTime() {
+				state = ThresholdStarted
+			}
+
+		case ThresholdStarted:
+			// The deployment of the rule change fails if it expires
+			// before it is accepted and locked in.
+			medianTime := prevNode.CalcPastMedianTime(b)
+			if uint64(medianTime.Unix()) >= checker.EndTime() {
+				state = ThresholdFailed
+				break
+			}
+
+			// Count the number of blocks of the previous window that
+			// voted for the rule change.  The invalid blocks of the
+			// DAG are ordered too, but their votes don't count.
+			var count uint32
+			for o := start - confirmationWindow; o < start; o++ {
+				node, err := b.nodeByOrder(o)
+				if err != nil {
+					return ThresholdFailed, err
+				}
+				if node.GetStatus().KnownInvalid() {
+					continue
+				}
+				condition, err := checker.Condition(node)
+				if err != nil {
+					return ThresholdFailed, err
+				}
+				if condition {
+					count++
+				}
+			}
+
+			// The state is locked in if the number of blocks in the
+			// window that voted for the rule change meets the
+			// activation threshold.
+			if count >= checker.RuleChangeActivationThreshold() {
+				state = ThresholdLockedIn
+			}
+
+		case ThresholdLockedIn:
+			// The new rule becomes active when its previous state
+			// was locked in.
+			state = ThresholdActive
+
+		// Nothing to do if the previous state is active or failed since
+		// they are both terminal states.
+		case ThresholdActive:
+		case ThresholdFailed:
+		}
+
+		// Update the cache to avoid recalculating the state in the
+		// future.
+		cache.Update(start, state)
+	}
+
+	return state, nil
+}
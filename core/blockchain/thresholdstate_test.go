@@ -0,0 +1,110 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"github.com/Qitmeer/qitmeer/params"
+	"math"
+	"testing"
+)
+
+// TestThresholdState checks the state machine of a deployment through the
+// windows of blocks which signal it as soon as it started.
+func TestThresholdState(t *testing.T) {
+	const window = 8
+
+	tests := []struct {
+		name      string
+		threshold uint32
+		// The start and expire times of the deployment relative to the
+		// timestamp of the genesis child, which are one second apart.
+		start, expire int64
+		// The state of each window starting from the genesis.
+		states []ThresholdState
+	}{
+		{
+			name:      "active",
+			threshold: window - 2,
+			start:     0,
+			expire:    math.MaxInt32,
+			states: []ThresholdState{ThresholdDefined, ThresholdStarted,
+				ThresholdLockedIn, ThresholdActive, ThresholdActive},
+		},
+		{
+			name:      "failed",
+			threshold: window + 1,
+			start:     0,
+			expire:    12,
+			states: []ThresholdState{ThresholdDefined, ThresholdStarted,
+				ThresholdStarted, ThresholdFailed, ThresholdFailed},
+		},
+		{
+			name:      "expired before starting",
+			threshold: window - 2,
+			start:     16,
+			expire:    12,
+			states: []ThresholdState{ThresholdDefined, ThresholdDefined,
+				ThresholdDefined, ThresholdFailed, ThresholdFailed},
+		},
+		{
+			name:      "not started",
+			threshold: window - 2,
+			start:     math.MaxInt32,
+			expire:    math.MaxInt32,
+			states: []ThresholdState{ThresholdDefined, ThresholdDefined,
+				ThresholdDefined, ThresholdDefined, ThresholdDefined},
+		},
+	}
+
+	for _, test := range tests {
+		par := params.PrivNetParams
+		par.MinerConfirmationWindow = window
+		par.RuleChangeActivationThreshold = test.threshold
+		par.Deployments = map[uint32][]params.ConsensusDeployment{
+			testBlockVersion: {{
+				Id:        params.DeploymentTestDummy,
+				BitNumber: 28,
+			}},
+		}
+		// The times are set once the timestamps of the blocks are known.
+		tc := newTestChain(t, &par)
+		deployment := &par.Deployments[testBlockVersion][0]
+		first := tc.lastTime.Unix() + 1
+		deployment.StartTime = uint64(first + test.start)
+		deployment.ExpireTime = uint64(first + test.expire)
+		blocks := tc.mine(len(test.states)*window - 1)
+
+		b := tc.chain
+		b.chainLock.Lock()
+		for i, want := range test.states {
+			for order := uint(i * window); order < uint((i+1)*window); order++ {
+				state, err := b.deploymentState(order, params.DeploymentTestDummy)
+				if err != nil {
+					t.Fatalf("%s: order %d: %v", test.name, order, err)
+				}
+				if state != want {
+					t.Errorf("%s: got state %v at order %d, want %v",
+						test.name, state, order, want)
+				}
+			}
+		}
+		b.chainLock.Unlock()
+
+		// The blocks signal the deployment while it is started or locked
+		// in.
+		for i, block := range blocks {
+			state := test.states[(i+1)/window]
+			signal := state == ThresholdStarted || state == ThresholdLockedIn
+			version := block.Block().Header.Version
+			if (version&(1<<28) != 0) != signal {
+				t.Errorf("%s: block %d in state %v has version %x",
+					test.name, i+1, state, version)
+			}
+			if baseBlockVersion(version) != testBlockVersion {
+				t.Errorf("%s: block %d has base version %d", test.name, i+1,
+					baseBlockVersion(version))
+			}
+		}
+		tc.close()
+	}
+}
@@ -575,7 +575,43 @@ func (b *BlockChain) FetchUtxoEntry(outpoint types.TxOutPoint) (*UtxoEntry, erro
 	return entry, nil
 }
 
-func (b *BlockChain) checkUtxoDuplicate(block *types.SerializedBlock, view *UtxoViewpoint) error {
+// ForEachUtxoEntry calls the provided function with every unspent transaction
+// output in the utxo set from the point of view of the end of the main chain.
+// Outputs created by blocks which are known to be invalid are skipped.
+//
+// Iteration stops early when the provided function returns an error, which is
+// then returned to the caller.
+//
+// This function is safe for concurrent access however the entries passed to
+// the callback are NOT.
+func (b *BlockChain) ForEachUtxoEntry(fn func(outpoint types.TxOutPoint, entry *UtxoEntry) error) error {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	return b.db.View(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+		return utxoBucket.ForEach(func(k, v []byte) error {
+			if len(k) <= hash.HashSize || len(v) == 0 {
+				return nil
+			}
+			outpoint := types.TxOutPoint{}
+			copy(outpoint.Hash[:], k[:hash.HashSize])
+			idx, _ := deserializeVLQ(k[hash.HashSize:])
+			outpoint.OutIndex = uint32(idx)
+
+			entry, err := DeserializeUtxoEntry(v)
+			if err != nil {
+				return err
+			}
+			if b.IsInvalidOut(entry) {
+				return nil
+			}
+			return fn(outpoint, entry)
+		})
+	})
+}
+
+func (b *BlockChain) checkUtxoDuplicate(node *blockNode, block *types.SerializedBlock, view *UtxoViewpoint) error {
 	// Fetch utxos for all of the transaction ouputs in this block.
 	// Typically, there will not be any utxos for any of the outputs.
 	fetchSet := make(map[types.TxOutPoint]struct{})
@@ -592,9 +628,14 @@ func (b *BlockChain) checkUtxoDuplicate(block *types.SerializedBlock, view *Utxo
 	}
 
 	// Duplicate transactions are only allowed if the previous transaction
-	// is fully spent.
+	// is fully spent.  The utxo set loaded from a snapshot already holds
+	// the outputs of the blocks it covers.
+	inUtxoSnapshot := b.isInUtxoSnapshot(node)
 	for outpoint := range fetchSet {
 		utxo := view.LookupEntry(outpoint)
+		if inUtxoSnapshot && utxo != nil && utxo.blockHash == node.hash {
+			continue
+		}
 		if utxo != nil && !utxo.IsSpent() {
 			str := fmt.Sprintf("tried to overwrite transaction %v "+
 				"at block %s that is not fully spent",
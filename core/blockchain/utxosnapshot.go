@@ -0,0 +1,636 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"io"
+	"os"
+	"sort"
+)
+
+// -----------------------------------------------------------------------------
+// A utxo snapshot is the utxo set after the block at a given DAG order, so a
+// new node can load it instead of replaying all the blocks before it.  It is
+// only loaded when its hash matches the one pinned in the checkpoint of the
+// block, since the blocks covered by the snapshot are connected without
+// updating the utxo set, and their transactions which spend outputs missing
+// from it can't be fully validated.
+//
+// The serialized format of a snapshot file is:
+//
+//   <network><order><block hash>[<key><value>]...
+//
+//   Field        Type       Size
+//   network      uint32     4 bytes
+//   order        uint64     8 bytes
+//   block hash   hash.Hash  32 bytes
+//   key          varbytes   variable
+//   value        varbytes   variable
+//
+// The entries are the keys and values of the utxo set bucket, so the values
+// use the same compressed encoding, and they are sorted by key.  The hash of
+// the snapshot is the double BLAKE2b-256 hash of the serialized order and
+// block hash, in the format they are stored in the database below, followed by
+// the serialized entries.
+//
+// The snapshot a chain was loaded from is stored in the database metadata
+// under the utxo snapshot key with the format:
+//
+//   <order><block hash>
+//
+//   Field        Type       Size
+//   order        uint64     8 bytes
+//   block hash   hash.Hash  32 bytes
+// -----------------------------------------------------------------------------
+
+const (
+	// utxoSnapshotHeaderSize is the size of the header of a snapshot file.
+	utxoSnapshotHeaderSize = 4 + 8 + hash.HashSize
+
+	// utxoSnapshotBatchSize is the number of entries of a snapshot which
+	// are written to the database in a single transaction.
+	utxoSnapshotBatchSize = 10000
+)
+
+// maxUtxoSnapshotKeySize is the maximum size of a key of a snapshot.
+var maxUtxoSnapshotKeySize = uint32(hash.HashSize + maxUint32VLQSerializeSize)
+
+// utxoSnapshot identifies the block after which the utxo set of a snapshot
+// was taken.
+type utxoSnapshot struct {
+	order uint64
+	hash  hash.Hash
+}
+
+// dbFetchUtxoSnapshot fetches the snapshot the utxo set was loaded from.  nil
+// is returned when the utxo set was not loaded from a snapshot.
+func dbFetchUtxoSnapshot(dbTx database.Tx) (*utxoSnapshot, error) {
+	serialized := dbTx.Metadata().Get(dbnamespace.UtxoSnapshotKeyName)
+	if serialized == nil {
+		return nil, nil
+	}
+	if len(serialized) != 8+hash.HashSize {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt utxo snapshot entry of "+
+				"%d bytes", len(serialized)),
+		}
+	}
+	snapshot := &utxoSnapshot{
+		order: binary.LittleEndian.Uint64(serialized[:8]),
+	}
+	copy(snapshot.hash[:], serialized[8:])
+	return snapshot, nil
+}
+
+// serializeUtxoSnapshot returns the serialized order and block hash of the
+// snapshot.
+func serializeUtxoSnapshot(snapshot *utxoSnapshot) []byte {
+	serialized := make([]byte, 8+hash.HashSize)
+	binary.LittleEndian.PutUint64(serialized[:8], snapshot.order)
+	copy(serialized[8:], snapshot.hash[:])
+	return serialized
+}
+
+// dbPutUtxoSnapshot stores the snapshot the utxo set was loaded from.
+func dbPutUtxoSnapshot(dbTx database.Tx, snapshot *utxoSnapshot) error {
+	return dbTx.Metadata().Put(dbnamespace.UtxoSnapshotKeyName,
+		serializeUtxoSnapshot(snapshot))
+}
+
+// isInUtxoSnapshot returns whether the utxo changes of the block are already
+// included in the snapshot the utxo set was loaded from.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) isInUtxoSnapshot(node *blockNode) bool {
+	return b.utxoSnapshot != nil && node.order <= b.utxoSnapshot.order
+}
+
+// checkUtxoSnapshotBlock ensures the block which is ordered at the order of
+// the loaded utxo snapshot is the block the snapshot was taken after.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) checkUtxoSnapshotBlock(node *blockNode) error {
+	if node.order != b.utxoSnapshot.order ||
+		node.hash.IsEqual(&b.utxoSnapshot.hash) {
+		return nil
+	}
+	str := fmt.Sprintf("block %v at order %d is not block %v of the "+
+		"utxo snapshot", node.hash, node.order, b.utxoSnapshot.hash)
+	return ruleError(ErrUtxoSnapshotMismatch, str)
+}
+
+// checkUtxoSnapshotTransactions performs the checks of checkConnectBlock on the
+// transactions of a block covered by the loaded utxo snapshot.  The utxo set
+// already includes the changes of the block, so the inputs spent by the block
+// or by the blocks after it up to the snapshot are missing.  The checks which
+// need the inputs are only performed on the transactions whose inputs are all
+// available, and the fees of the block are only checked when they are known.
+//
+// The inputs of the checked transactions are spent in the view, which must not
+// be used to update the utxo set afterwards.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) checkUtxoSnapshotTransactions(node *blockNode, block *types.SerializedBlock, utxoView *UtxoViewpoint, runScripts bool, scriptFlags txscript.ScriptFlags) error {
+	transactions := block.Transactions()
+	totalSigOpCost := 0
+	for _, tx := range transactions {
+		lastSigOpCost := totalSigOpCost
+		totalSigOpCost += CountSigOps(tx)
+		if totalSigOpCost < lastSigOpCost || totalSigOpCost > MaxSigOpsPerBlock {
+			str := fmt.Sprintf("block contains too many "+
+				"signature operations - got %v, max %v",
+				totalSigOpCost, MaxSigOpsPerBlock)
+			return ruleError(ErrTooManySigOps, str)
+		}
+	}
+
+	nodeConf := b.bd.GetConfirmations(node.GetHash())
+	prevMedianTime := node.GetMainParent(b).CalcPastMedianTime(b)
+	var totalFees int64
+	for _, tx := range transactions[1:] {
+		available := true
+		for _, txIn := range tx.Transaction().TxIn {
+			entry := utxoView.LookupEntry(txIn.PreviousOut)
+			if entry == nil || entry.IsSpent() {
+				available = false
+				break
+			}
+		}
+		if !available {
+			totalFees = -1
+			continue
+		}
+
+		txFee, err := CheckTransactionInputs(tx, int64(nodeConf),
+			utxoView, b.params, b.bd)
+		if err != nil {
+			return err
+		}
+		if totalFees >= 0 {
+			lastTotalFees := totalFees
+			totalFees += txFee
+			if totalFees < lastTotalFees {
+				return ruleError(ErrBadFees, "total fees for "+
+					"block overflows accumulator")
+			}
+		}
+
+		sequenceLock, err := b.calcSequenceLock(node, tx, utxoView, false)
+		if err != nil {
+			return err
+		}
+		if !SequenceLockActive(sequenceLock, int64(node.GetHeight()),
+			prevMedianTime) {
+			str := fmt.Sprintf("block contains transaction whose " +
+				"input sequence locks are not met")
+			return ruleError(ErrUnfinalizedTx, str)
+		}
+
+		if runScripts {
+			err = ValidateTransactionScripts(tx, utxoView, scriptFlags,
+				b.sigCache)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Spend the inputs so the later transactions of the block
+		// can't spend them again.
+		for _, txIn := range tx.Transaction().TxIn {
+			utxoView.LookupEntry(txIn.PreviousOut).Spend()
+		}
+	}
+	return b.checkBlockSubsidy(block, totalFees)
+}
+
+// utxoSnapshotEntry is an entry of the utxo set which differs between the end
+// of the main chain and the order of a snapshot.  The value is nil when the
+// output doesn't exist at the order of the snapshot.
+type utxoSnapshotEntry struct {
+	key   []byte
+	value []byte
+}
+
+// utxoSnapshotWriter writes the entries of a snapshot and calculates its hash.
+type utxoSnapshotWriter struct {
+	w      io.Writer
+	hasher hash.Hasher
+	count  uint64
+}
+
+// newUtxoSnapshotWriter returns a writer of the entries of the snapshot to w.
+func newUtxoSnapshotWriter(w io.Writer, snapshot *utxoSnapshot) *utxoSnapshotWriter {
+	hasher := hash.GetHasher(hash.Blake2b_256)
+	hasher.Write(serializeUtxoSnapshot(snapshot))
+	return &utxoSnapshotWriter{
+		w:      io.MultiWriter(w, hasher),
+		hasher: hasher,
+	}
+}
+
+// writeEntry writes an entry of the snapshot.
+func (sw *utxoSnapshotWriter) writeEntry(key []byte, value []byte) error {
+	err := s.WriteVarBytes(sw.w, 0, key)
+	if err != nil {
+		return err
+	}
+	sw.count++
+	return s.WriteVarBytes(sw.w, 0, value)
+}
+
+// hash returns the hash of the snapshot with the entries written so far.
+func (sw *utxoSnapshotWriter) hash() hash.Hash {
+	return hash.HashH(sw.hasher.Sum(nil))
+}
+
+// utxoSnapshotDiff returns the entries of the utxo set which differ between the
+// end of the main chain and the given order, sorted by key.  The blocks after
+// the order are disconnected in a view by the spend journal.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) utxoSnapshotDiff(order uint64) ([]utxoSnapshotEntry, error) {
+	view := NewUtxoViewpoint()
+	mainOrder := uint64(b.bd.GetGraphState().GetMainOrder())
+	for o := mainOrder; o > order; o-- {
+		node, err := b.nodeByOrder(uint(o))
+		if err != nil {
+			return nil, err
+		}
+		if b.isInUtxoSnapshot(node) {
+			return nil, fmt.Errorf("the utxo set before order %d was "+
+				"loaded from a snapshot", b.utxoSnapshot.order)
+		}
+		// The blocks which are known to be invalid didn't change the
+		// utxo set.
+		if b.index.NodeStatus(node).KnownInvalid() {
+			continue
+		}
+		block, err := b.fetchBlockByHash(&node.hash)
+		if err != nil {
+			return nil, err
+		}
+		var stxos []SpentTxOut
+		err = b.db.View(func(dbTx database.Tx) error {
+			stxos, err = dbFetchSpendJournalEntry(dbTx, block)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = view.disconnectTransactions(block, stxos)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	diff := make([]utxoSnapshotEntry, 0, len(view.entries))
+	for outpoint, entry := range view.entries {
+		key := outpointKey(outpoint)
+		diffEntry := utxoSnapshotEntry{key: make([]byte, len(*key))}
+		copy(diffEntry.key, *key)
+		recycleOutpointKey(key)
+		if !entry.IsSpent() {
+			value, err := serializeUtxoEntry(entry)
+			if err != nil {
+				return nil, err
+			}
+			diffEntry.value = value
+		}
+		diff = append(diff, diffEntry)
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		return bytes.Compare(diff[i].key, diff[j].key) < 0
+	})
+	return diff, nil
+}
+
+// DumpUtxoSnapshot writes the utxo set after the block at the given order of
+// the main chain to a snapshot file and returns the hash of the snapshot.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DumpUtxoSnapshot(snapshotFile string, order uint64) (*hash.Hash, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	mainOrder := uint64(b.bd.GetGraphState().GetMainOrder())
+	if order > mainOrder {
+		return nil, fmt.Errorf("order %d is after the main order %d",
+			order, mainOrder)
+	}
+	blockHash := b.blockHashByOrder(uint(order))
+	if blockHash == nil {
+		return nil, fmt.Errorf("no block at order %d", order)
+	}
+
+	log.Info(fmt.Sprintf("Writing the utxo set after block %v at order %d "+
+		"to disk, please wait...", blockHash, order))
+
+	diff, err := b.utxoSnapshotDiff(order)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(snapshotFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	var header [utxoSnapshotHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(b.params.Net))
+	binary.LittleEndian.PutUint64(header[4:12], order)
+	copy(header[12:], blockHash[:])
+	_, err = w.Write(header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge the utxo set with the differences, which are both sorted by
+	// key, so the entries are written in order.
+	sw := newUtxoSnapshotWriter(w, &utxoSnapshot{order: order, hash: *blockHash})
+	writeDiff := func(entry *utxoSnapshotEntry) error {
+		if entry.value == nil {
+			return nil
+		}
+		return sw.writeEntry(entry.key, entry.value)
+	}
+	err = b.db.View(func(dbTx database.Tx) error {
+		utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+		err := utxoBucket.ForEach(func(k, v []byte) error {
+			if len(k) <= hash.HashSize || len(v) == 0 {
+				return nil
+			}
+			for len(diff) > 0 && bytes.Compare(diff[0].key, k) <= 0 {
+				entry := &diff[0]
+				diff = diff[1:]
+				if bytes.Equal(entry.key, k) {
+					return writeDiff(entry)
+				}
+				err := writeDiff(entry)
+				if err != nil {
+					return err
+				}
+			}
+			return sw.writeEntry(k, v)
+		})
+		if err != nil {
+			return err
+		}
+		for i := range diff {
+			err := writeDiff(&diff[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotHash := sw.hash()
+	log.Info(fmt.Sprintf("Successfully dumped the utxo set (%d outputs) "+
+		"to %s with hash %v.", sw.count, snapshotFile, snapshotHash))
+	return &snapshotHash, nil
+}
+
+// utxoSnapshotReader reads the entries of a snapshot and calculates its hash.
+type utxoSnapshotReader struct {
+	r      io.Reader
+	hasher hash.Hasher
+}
+
+// newUtxoSnapshotReader returns a reader of the entries of the snapshot from r.
+func newUtxoSnapshotReader(r io.Reader, snapshot *utxoSnapshot) *utxoSnapshotReader {
+	hasher := hash.GetHasher(hash.Blake2b_256)
+	hasher.Write(serializeUtxoSnapshot(snapshot))
+	return &utxoSnapshotReader{
+		r:      io.TeeReader(r, hasher),
+		hasher: hasher,
+	}
+}
+
+// readEntry reads an entry of the snapshot.  io.EOF is returned when there are
+// no more entries.
+func (sr *utxoSnapshotReader) readEntry() ([]byte, []byte, error) {
+	key, err := s.ReadVarBytes(sr.r, 0, maxUtxoSnapshotKeySize, "key")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(key) <= hash.HashSize {
+		return nil, nil, fmt.Errorf("utxo snapshot key of %d bytes is "+
+			"too short", len(key))
+	}
+	value, err := s.ReadVarBytes(sr.r, 0, types.MaxBlockPayload, "value")
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = DeserializeUtxoEntry(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
+
+// hash returns the hash of the snapshot with the entries read so far.
+func (sr *utxoSnapshotReader) hash() hash.Hash {
+	return hash.HashH(sr.hasher.Sum(nil))
+}
+
+// openUtxoSnapshot opens a snapshot file and reads its header.
+func openUtxoSnapshot(snapshotFile string, net protocol.Network) (*os.File, *utxoSnapshot, error) {
+	file, err := os.Open(snapshotFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var header [utxoSnapshotHeaderSize]byte
+	_, err = io.ReadFull(file, header[:])
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if protocol.Network(binary.LittleEndian.Uint32(header[:4])) != net {
+		file.Close()
+		return nil, nil, fmt.Errorf("the utxo snapshot is for network "+
+			"%v instead of %v", protocol.Network(
+			binary.LittleEndian.Uint32(header[:4])), net)
+	}
+	snapshot := &utxoSnapshot{
+		order: binary.LittleEndian.Uint64(header[4:12]),
+	}
+	copy(snapshot.hash[:], header[12:])
+	return file, snapshot, nil
+}
+
+// dbClearUtxoSet removes all the entries of the utxo set.
+func dbClearUtxoSet(utxoBucket database.Bucket) error {
+	var keys [][]byte
+	err := utxoBucket.ForEach(func(k, v []byte) error {
+		key := make([]byte, len(k))
+		copy(key, k)
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = utxoBucket.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadUtxoSnapshot replaces the utxo set of a new chain with the one of a
+// snapshot file.  The hash of the snapshot must match the utxo set hash pinned
+// in the checkpoint of its block.  The blocks up to the block of the snapshot
+// are then connected without updating the utxo set.  The import stops when the
+// interrupt channel is closed.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) LoadUtxoSnapshot(snapshotFile string, interrupt <-chan struct{}) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	file, snapshot, err := openUtxoSnapshot(snapshotFile, b.params.Net)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Nothing to do when the snapshot was already loaded, so the option
+	// can be kept across restarts.
+	if b.utxoSnapshot != nil && *b.utxoSnapshot == *snapshot {
+		log.Info(fmt.Sprintf("The utxo snapshot of block %v is already "+
+			"loaded", snapshot.hash))
+		return nil
+	}
+	if b.bd.GetBlockTotal() > 1 {
+		return fmt.Errorf("a utxo snapshot can only be loaded by a new " +
+			"chain")
+	}
+
+	var checkpoint *params.Checkpoint
+	for i, c := range b.Checkpoints() {
+		if c.Hash.IsEqual(&snapshot.hash) && c.UtxoSetHash != nil {
+			checkpoint = &b.params.Checkpoints[i]
+			break
+		}
+	}
+	if checkpoint == nil {
+		return fmt.Errorf("no checkpoint pins the utxo set hash of "+
+			"block %v", snapshot.hash)
+	}
+
+	log.Info(fmt.Sprintf("Loading the utxo set after block %v at order %d, "+
+		"please wait...", snapshot.hash, snapshot.order))
+
+	// Check the hash of the whole snapshot before anything is written.
+	sr := newUtxoSnapshotReader(bufio.NewReader(file), snapshot)
+	var count uint64
+	for {
+		_, _, err := sr.readEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the utxo snapshot: %v", err)
+		}
+		count++
+	}
+	snapshotHash := sr.hash()
+	if !snapshotHash.IsEqual(checkpoint.UtxoSetHash) {
+		return fmt.Errorf("the utxo snapshot hash %v doesn't match the "+
+			"hash %v of the checkpoint", snapshotHash,
+			checkpoint.UtxoSetHash)
+	}
+
+	// Replace the utxo set.  The snapshot is only stored once all of its
+	// entries are written, so an interrupted load starts over.
+	_, err = file.Seek(utxoSnapshotHeaderSize, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	sr = newUtxoSnapshotReader(bufio.NewReader(file), snapshot)
+	for first, done := true, false; !done; first = false {
+		select {
+		case <-interrupt:
+			return fmt.Errorf("interrupted while loading the utxo " +
+				"snapshot")
+		default:
+		}
+
+		err = b.db.Update(func(dbTx database.Tx) error {
+			utxoBucket := dbTx.Metadata().Bucket(dbnamespace.UtxoSetBucketName)
+			if first {
+				err := dbClearUtxoSet(utxoBucket)
+				if err != nil {
+					return err
+				}
+			}
+			for i := 0; i < utxoSnapshotBatchSize; i++ {
+				key, value, err := sr.readEntry()
+				if err == io.EOF {
+					done = true
+					return dbPutUtxoSnapshot(dbTx, snapshot)
+				}
+				if err != nil {
+					return err
+				}
+				err = utxoBucket.Put(key, value)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	b.utxoSnapshot = snapshot
+
+	// The utxo set is the state after the block of the snapshot, so its
+	// state is committed now for the blocks after it.
+	if b.stateTrieDB != nil {
+		err = b.db.Update(func(dbTx database.Tx) error {
+			root, err := b.dbBuildStateTrie(dbTx)
+			if err != nil {
+				return err
+			}
+			return dbPutBlockReceipt(dbTx, &snapshot.hash, &types.BlockReceipt{
+				BlockNumber: snapshot.order,
+				StorageRoot: root,
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info(fmt.Sprintf("Successfully loaded the utxo set (%d outputs) "+
+		"with hash %v.", count, snapshotHash))
+	return nil
+}
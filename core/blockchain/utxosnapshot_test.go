@@ -0,0 +1,183 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"encoding/binary"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUtxoSnapshotRoundTrip checks that a chain which loads the utxo snapshot
+// dumped by another chain and then processes its blocks ends up with the same
+// utxo set, and that tampered snapshots are refused.
+func TestUtxoSnapshotRoundTrip(t *testing.T) {
+	par := params.PrivNetParams
+	tc := newTestChain(t, &par)
+	defer tc.close()
+
+	// Spend a coinbase before the snapshot, so the chain which loads it
+	// connects a block whose input is missing from its utxo set.
+	blocks := tc.mine(int(par.CoinbaseMaturity) + 2)
+	coinbase := blocks[0].Transactions()[0]
+	spend := types.NewTransaction()
+	spend.AddTxIn(&types.TxInput{
+		PreviousOut: *types.NewOutPoint(coinbase.Hash(), 0),
+		Sequence:    types.MaxTxInSequenceNum,
+	})
+	spend.AddTxOut(types.NewTxOutput(coinbase.Tx.TxOut[0].Amount,
+		[]byte{txscript.OP_TRUE}))
+	block := tc.newBlock(nil, []*types.Transaction{spend})
+	if err := tc.processBlock(block); err != nil {
+		t.Fatalf("Failed to process the spending block: %v", err)
+	}
+	blocks = append(blocks, block)
+	blocks = append(blocks, tc.mine(3)...)
+
+	dir, err := ioutil.TempDir("", "utxosnapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshotFile := filepath.Join(dir, "utxo.snapshot")
+	order := uint64(len(blocks) - 2)
+	snapshotBlock := blocks[order-1]
+	snapshotHash, err := tc.chain.DumpUtxoSnapshot(snapshotFile, order)
+	if err != nil {
+		t.Fatalf("Failed to dump the utxo snapshot: %v", err)
+	}
+	tipOrder := uint64(len(blocks))
+	wantHash, err := tc.chain.DumpUtxoSnapshot(filepath.Join(dir, "tip"),
+		tipOrder)
+	if err != nil {
+		t.Fatalf("Failed to dump the utxo set of the tip: %v", err)
+	}
+
+	par2 := par
+	par2.Checkpoints = []params.Checkpoint{{
+		Layer:       uint64(tc.chain.bd.GetBlock(snapshotBlock.Hash()).GetLayer()),
+		Hash:        snapshotBlock.Hash(),
+		UtxoSetHash: snapshotHash,
+	}}
+
+	// The snapshot is loaded and the blocks it covers are connected.
+	tc2 := newTestChain(t, &par2)
+	defer tc2.close()
+	err = tc2.chain.LoadUtxoSnapshot(snapshotFile, nil)
+	if err != nil {
+		t.Fatalf("Failed to load the utxo snapshot: %v", err)
+	}
+	for i, block := range blocks {
+		if err := tc2.processBlock(block); err != nil {
+			t.Fatalf("Failed to process block %d: %v", i+1, err)
+		}
+		if tc2.chain.index.NodeStatus(tc2.chain.index.LookupNode(block.Hash())).KnownInvalid() {
+			t.Fatalf("Block %d is invalid after loading the snapshot", i+1)
+		}
+	}
+	gotHash, err := tc2.chain.DumpUtxoSnapshot(filepath.Join(dir, "tip2"),
+		tipOrder)
+	if err != nil {
+		t.Fatalf("Failed to dump the utxo set of the loaded chain: %v", err)
+	}
+	if *gotHash != *wantHash {
+		t.Fatalf("The utxo set hash of the loaded chain is %v, want %v",
+			gotHash, wantHash)
+	}
+
+	// The tampered snapshots are refused before the utxo set is changed.
+	serialized, err := ioutil.ReadFile(snapshotFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		tamper func([]byte)
+	}{
+		{
+			name: "order",
+			tamper: func(b []byte) {
+				binary.LittleEndian.PutUint64(b[4:12], order-1)
+			},
+		},
+		{
+			name: "entry",
+			tamper: func(b []byte) {
+				b[len(b)-1] ^= 0x01
+			},
+		},
+		{
+			name: "truncated",
+			tamper: func(b []byte) {
+				copy(b[len(b)-8:], make([]byte, 8))
+			},
+		},
+	}
+	for _, test := range tests {
+		tampered := make([]byte, len(serialized))
+		copy(tampered, serialized)
+		test.tamper(tampered)
+		tamperedFile := filepath.Join(dir, test.name)
+		err := ioutil.WriteFile(tamperedFile, tampered, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tc3 := newTestChain(t, &par2)
+		err = tc3.chain.LoadUtxoSnapshot(tamperedFile, nil)
+		if err == nil {
+			t.Errorf("%s: the tampered utxo snapshot was loaded",
+				test.name)
+		}
+		if tc3.chain.utxoSnapshot != nil {
+			t.Errorf("%s: the tampered utxo snapshot was stored",
+				test.name)
+		}
+		tc3.close()
+	}
+}
+
+// TestUtxoSnapshotCheckpoints checks the utxo set hashes pinned in the
+// checkpoints of the genesis blocks, which a new chain can dump and load.
+func TestUtxoSnapshotCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "utxosnapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, par := range []*params.Params{&params.MainNetParams,
+		&params.TestNetParams, &params.PrivNetParams, &params.MixNetParams} {
+		for _, checkpoint := range par.Checkpoints {
+			if checkpoint.UtxoSetHash == nil ||
+				*checkpoint.Hash != *par.GenesisHash {
+				continue
+			}
+			tc := newTestChain(t, par)
+			snapshotFile := filepath.Join(dir, par.Name)
+			snapshotHash, err := tc.chain.DumpUtxoSnapshot(snapshotFile, 0)
+			if err != nil {
+				t.Fatalf("%s: failed to dump the utxo snapshot: %v",
+					par.Name, err)
+			}
+			if *snapshotHash != *checkpoint.UtxoSetHash {
+				t.Errorf("%s: got utxo set hash %v, want %v", par.Name,
+					snapshotHash, checkpoint.UtxoSetHash)
+			}
+			tc.close()
+
+			tc = newTestChain(t, par)
+			err = tc.chain.LoadUtxoSnapshot(snapshotFile, nil)
+			if err != nil {
+				t.Errorf("%s: failed to load the utxo snapshot: %v",
+					par.Name, err)
+			}
+			tc.close()
+		}
+	}
+}
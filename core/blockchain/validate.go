@@ -21,20 +21,36 @@ import (
 	"time"
 )
 
+// ExpiryPoint returns the point of the block dag the expiry of transactions
+// is compared with for a block at the passed height and layer.  Transactions
+// expire by the height of the block which includes them until the layer expiry
+// activation layer of the network, and by its layer from that layer on.
+func ExpiryPoint(blockHeight, blockLayer uint64, chainParams *params.Params) uint64 {
+	if blockLayer >= chainParams.LayerExpiryActivation {
+		return blockLayer
+	}
+	return blockHeight
+}
+
+// IsExpiredTx returns whether or not the passed transaction is expired
+// according to the expiry point of the block which includes it, as returned by
+// ExpiryPoint.  A transaction expires on the point given by its expiry, so it
+// must be included in a block with a lower expiry point.
+//
 // This function only differs from IsExpired in that it works with a raw wire
 // transaction as opposed to a higher level util transaction.
-func IsExpiredTx(tx *types.Transaction, blockHeight uint64) bool {
+func IsExpiredTx(tx *types.Transaction, expiryPoint uint64) bool {
 	expiry := tx.Expire
-	return expiry != types.NoExpiryValue && blockHeight >= uint64(expiry) //TODO, remove type conversion
+	return expiry != types.NoExpiryValue && expiryPoint >= uint64(expiry)
 }
 
-// IsExpired returns where or not the passed transaction is expired according to
-// the given block height.
+// IsExpired returns whether or not the passed transaction is expired according
+// to the expiry point of the block which includes it.
 //
 // This function only differs from IsExpiredTx in that it works with a higher
 // level util transaction as opposed to a raw wire transaction.
-func IsExpired(tx *types.Tx, blockHeight uint64) bool {
-	return IsExpiredTx(tx.Transaction(), blockHeight)
+func IsExpired(tx *types.Tx, expiryPoint uint64) bool {
+	return IsExpiredTx(tx.Transaction(), expiryPoint)
 }
 
 // checkBlockSanity performs some preliminary checks on a block to ensure it is
@@ -50,42 +66,9 @@ func checkBlockSanity(block *types.SerializedBlock, timeSource MedianTimeSource,
 	if err != nil {
 		return err
 	}
-	// A block must have at least one parent.
-	numPb := len(msgBlock.Parents)
-	if numPb == 0 {
-		return ruleError(ErrNoParents, "block does not contain "+
-			"any parent")
-	}
-
-	// A block must not have more parents than the max block payload or
-	// else it is certainly over the weight limit.
-	if numPb > types.MaxParentsPerBlock {
-		str := fmt.Sprintf("block contains too many parents - "+
-			"got %d, max %d", numPb, types.MaxParentsPerBlock)
-		return ruleError(ErrBlockTooBig, str)
-	}
-	// Build the block parents merkle tree and ensure the calculated merkle
-	// parents root matches the entry in the block header.
-	// This also has the effect of caching all
-	// of the parents hashes in the block to speed up future hash
-	// checks.  The tree here and checks the merkle root
-	// after the following checks, but there is no reason not to check the
-	// merkle root matches here.
-	paMerkles := merkle.BuildParentsMerkleTreeStore(msgBlock.Parents)
-	paMerkleRoot := paMerkles[len(paMerkles)-1]
-	if !header.ParentRoot.IsEqual(paMerkleRoot) {
-		str := fmt.Sprintf("block parents merkle root is invalid - block "+
-			"header indicates %v, but calculated value is %v",
-			&header.ParentRoot, paMerkleRoot)
-		return ruleError(ErrBadParentsMerkleRoot, str)
-	}
-
-	// Repeated parents
-	parentsSet := blockdag.NewHashSet()
-	parentsSet.AddList(msgBlock.Parents)
-	if len(msgBlock.Parents) != parentsSet.Size() {
-		str := fmt.Sprintf("parents:%v", msgBlock.Parents)
-		return ruleError(ErrDuplicateParent, str)
+	err = checkBlockParentsSanity(header, msgBlock.Parents)
+	if err != nil {
+		return err
 	}
 
 	// A block must have at least one regular transaction.
@@ -200,6 +183,63 @@ func checkBlockSanity(block *types.SerializedBlock, timeSource MedianTimeSource,
 	return nil
 }
 
+// checkBlockParentsSanity ensures the ordered parent hashes of a block are the
+// leaves of the parent root committed to by its header.  These checks are
+// context free.
+func checkBlockParentsSanity(header *types.BlockHeader, parents []*hash.Hash) error {
+	// A block must have at least one parent.
+	numPb := len(parents)
+	if numPb == 0 {
+		return ruleError(ErrNoParents, "block does not contain "+
+			"any parent")
+	}
+
+	// A block must not have more parents than the max block payload or
+	// else it is certainly over the weight limit.
+	if numPb > types.MaxParentsPerBlock {
+		str := fmt.Sprintf("block contains too many parents - "+
+			"got %d, max %d", numPb, types.MaxParentsPerBlock)
+		return ruleError(ErrBlockTooBig, str)
+	}
+	// Build the block parents merkle tree and ensure the calculated merkle
+	// parents root matches the entry in the block header.
+	// This also has the effect of caching all
+	// of the parents hashes in the block to speed up future hash
+	// checks.  The tree here and checks the merkle root
+	// after the following checks, but there is no reason not to check the
+	// merkle root matches here.
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	paMerkleRoot := paMerkles[len(paMerkles)-1]
+	if !header.ParentRoot.IsEqual(paMerkleRoot) {
+		str := fmt.Sprintf("block parents merkle root is invalid - block "+
+			"header indicates %v, but calculated value is %v",
+			&header.ParentRoot, paMerkleRoot)
+		return ruleError(ErrBadParentsMerkleRoot, str)
+	}
+
+	// Repeated parents
+	parentsSet := blockdag.NewHashSet()
+	parentsSet.AddList(parents)
+	if len(parents) != parentsSet.Size() {
+		str := fmt.Sprintf("parents:%v", parents)
+		return ruleError(ErrDuplicateParent, str)
+	}
+
+	return nil
+}
+
+// CheckHeaderSanity performs the context free checks on a block header and
+// the ordered parent hashes of its block which are possible without the rest
+// of the block, such as the proof of work.  It allows the headers of a block
+// DAG to be verified before their blocks are downloaded.
+func CheckHeaderSanity(header *types.BlockHeader, parents []*hash.Hash, timeSource MedianTimeSource, chainParams *params.Params) error {
+	err := checkBlockHeaderSanity(header, timeSource, BFNone, chainParams)
+	if err != nil {
+		return err
+	}
+	return checkBlockParentsSanity(header, parents)
+}
+
 // checkBlockHeaderSanity performs some preliminary checks on a block header to
 // ensure it is sane before continuing with processing.  These checks are
 // context free.
@@ -475,6 +515,20 @@ func (b *BlockChain) checkBlockContext(block *types.SerializedBlock, mainParent
 		// previous block.
 		blockHeight := uint64(prevBlock.GetHeight() + 1)
 
+		// The layer of this block is one more than the highest layer
+		// of its parents.  The expiry of transactions is relative to
+		// the layer of the block which includes them once the layer
+		// expiry is active, and to its height before.
+		parents := blockdag.NewHashSet()
+		parents.AddList(block.Block().Parents)
+		parentsLayer, ok := b.bd.GetParentsMaxLayer(parents)
+		if !ok {
+			str := fmt.Sprintf("bad parents:%v", block.Block().Parents)
+			return ruleError(ErrMissingParent, str)
+		}
+		blockLayer := uint64(parentsLayer + 1)
+		expiryPoint := ExpiryPoint(blockHeight, blockLayer, b.params)
+
 		// Ensure all transactions in the block are finalized and are
 		// not expired.
 		for _, tx := range block.Transactions() {
@@ -485,10 +539,10 @@ func (b *BlockChain) checkBlockContext(block *types.SerializedBlock, mainParent
 			}
 
 			// The transaction must not be expired.
-			if IsExpired(tx, blockHeight) {
+			if IsExpired(tx, expiryPoint) {
 				errStr := fmt.Sprintf("block contains expired regular "+
-					"transaction %v (expiration height %d)", tx.Hash(),
-					tx.Transaction().Expire)
+					"transaction %v (expiration %d, block %d)",
+					tx.Hash(), tx.Transaction().Expire, expiryPoint)
 				return ruleError(ErrExpiredTx, errStr)
 			}
 		}
@@ -696,7 +750,18 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 		return ruleError(ErrMissingTxOut, str)
 	}
 
-	err := b.checkUtxoDuplicate(block, utxoView)
+	// The utxo set already includes the changes of the blocks covered by
+	// the utxo snapshot it was loaded from, so only the checks which don't
+	// need the inputs spent by those blocks can be performed.
+	inUtxoSnapshot := b.isInUtxoSnapshot(node)
+	if inUtxoSnapshot {
+		err := b.checkUtxoSnapshotBlock(node)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := b.checkUtxoDuplicate(node, block, utxoView)
 	if err != nil {
 		return err
 	}
@@ -709,10 +774,12 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 	// transactions are included in the merkle root hash and any changes
 	// will therefore be detected by the next checkpoint).  This is a huge
 	// optimization because running the scripts is the most time consuming
-	// portion of block handling.
+	// portion of block handling.  The blocks imported from a file are an
+	// exception unless skipping their scripts was requested.
 	checkpoint := b.LatestCheckpoint()
 	runScripts := !b.noVerify
-	if checkpoint != nil && uint64(node.GetLayer()) <= checkpoint.Layer {
+	if checkpoint != nil && uint64(node.GetLayer()) <= checkpoint.Layer &&
+		!b.verifyCheckpointed {
 		runScripts = false
 	}
 	var scriptFlags txscript.ScriptFlags
@@ -736,6 +803,19 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *types.SerializedB
 		return err
 	}
 
+	if inUtxoSnapshot {
+		err = b.checkUtxoSnapshotTransactions(node, block, utxoView,
+			runScripts, scriptFlags)
+		// The view must not change the utxo set, which already
+		// includes the block.
+		utxoView.Clean()
+		if err != nil {
+			return err
+		}
+		utxoView.SetBestHash(&node.hash)
+		return nil
+	}
+
 	err = b.checkTransactionsAndConnect(node, block, b.subsidyCache, utxoView, stxos)
 	if err != nil {
 		log.Trace("checkTransactionsAndConnect failed", "err", err)
@@ -0,0 +1,107 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blockchain
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"testing"
+)
+
+// TestExpiryActivation checks that the expiry of the transactions in a block
+// is relative to its height below the layer expiry activation layer and to its
+// layer from it, on a block whose layer is above its height.
+func TestExpiryActivation(t *testing.T) {
+	const (
+		// The test block is on the main chain height 23 and the
+		// layer 24.
+		blockHeight = 23
+		blockLayer  = 24
+	)
+
+	tests := []struct {
+		name       string
+		activation uint64
+		expire     uint32
+		expired    bool
+	}{
+		{"height before activation", 1000, blockLayer, false},
+		{"height expired before activation", 1000, blockHeight, true},
+		{"activated on the block layer", blockLayer, blockLayer, true},
+		{"layer after activation", 0, blockLayer + 1, false},
+		{"layer expired after activation", 0, blockLayer, true},
+		{"activated above the block layer", blockLayer + 1, blockLayer,
+			false},
+	}
+
+	for _, test := range tests {
+		par := params.PrivNetParams
+		par.LayerExpiryActivation = test.activation
+		tc := newTestChain(t, &par)
+
+		// The main chain is extended by three blocks, while a block
+		// merging four blocks on the same layer gathers more blues and
+		// becomes the main parent of the test block.
+		blocks := tc.mine(20)
+		tip := blocks[len(blocks)-1].Hash()
+		mainTip := tip
+		for i := 0; i < 3; i++ {
+			block := tc.newBlock([]*hash.Hash{mainTip}, nil)
+			if err := tc.processBlock(block); err != nil {
+				tc.close()
+				t.Fatalf("%s: failed to process block: %v", test.name, err)
+			}
+			mainTip = block.Hash()
+		}
+		var merged []*hash.Hash
+		for i := 0; i < 4; i++ {
+			block := tc.newBlock([]*hash.Hash{tip}, nil)
+			if err := tc.processBlock(block); err != nil {
+				tc.close()
+				t.Fatalf("%s: failed to process block: %v", test.name, err)
+			}
+			merged = append(merged, block.Hash())
+		}
+		merge := tc.newBlock(merged, nil)
+		if err := tc.processBlock(merge); err != nil {
+			tc.close()
+			t.Fatalf("%s: failed to process the merge block: %v",
+				test.name, err)
+		}
+
+		// The transaction spends the mature coinbase of the first block.
+		coinbase := blocks[0].Transactions()[0]
+		tx := types.NewTransaction()
+		tx.AddTxIn(&types.TxInput{
+			PreviousOut: *types.NewOutPoint(coinbase.Hash(), 0),
+			Sequence:    types.MaxTxInSequenceNum,
+			SignScript:  []byte{},
+		})
+		tx.AddTxOut(types.NewTxOutput(coinbase.Tx.TxOut[0].Amount-1000,
+			[]byte{txscript.OP_TRUE}))
+		tx.Expire = test.expire
+		block := tc.newBlock([]*hash.Hash{mainTip, merge.Hash()},
+			[]*types.Transaction{tx})
+		err := tc.processBlock(block)
+		if test.expired {
+			rerr, ok := err.(RuleError)
+			if !ok || rerr.ErrorCode != ErrExpiredTx {
+				t.Errorf("%s: got error %v, want %v", test.name, err,
+					ErrExpiredTx)
+			}
+		} else if err != nil {
+			t.Errorf("%s: failed to process the block: %v", test.name, err)
+		} else {
+			node := tc.chain.index.LookupNode(block.Hash())
+			layer := tc.chain.bd.GetBlock(block.Hash()).GetLayer()
+			if node.GetHeight() != blockHeight || layer != blockLayer {
+				t.Errorf("%s: block is on height %d and layer %d, "+
+					"want %d and %d", test.name, node.GetHeight(), layer,
+					blockHeight, blockLayer)
+			}
+		}
+		tc.close()
+	}
+}
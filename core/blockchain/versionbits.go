@@ -0,0 +1,245 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+const (
+	// vbTopBits defines the bits to set in the version to signal that the
+	// version bits scheme is being used.
+	vbTopBits = 0x20000000
+
+	// vbTopMask is the bitmask to use to determine whether or not the
+	// version bits scheme is in use.
+	vbTopMask = 0xe0000000
+
+	// vbBaseVersionMask is the bitmask of the base block version which
+	// identifies the network.  The deployments can't use these bits.
+	vbBaseVersionMask = 0xff
+
+	// vbNumBits is the total number of bits available for use with the
+	// version bits scheme.
+	vbNumBits = 29
+)
+
+// baseBlockVersion returns the block version without the bits which signal the
+// deployments.
+func baseBlockVersion(version uint32) uint32 {
+	if version&vbTopMask != vbTopBits {
+		return version
+	}
+	return version & vbBaseVersionMask
+}
+
+// deploymentChecker provides a thresholdConditionChecker which can be used to
+// test a specific deployment rule.  This is required for properly detecting
+// and activating consensus rule changes.
+type deploymentChecker struct {
+	deployment *params.ConsensusDeployment
+	chain      *BlockChain
+}
+
+// Ensure the deploymentChecker type implements the thresholdConditionChecker
+// interface.
+var _ thresholdConditionChecker = deploymentChecker{}
+
+// BeginTime returns the unix timestamp for the median block time after which
+// voting on a rule change starts (at the next window).
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) BeginTime() uint64 {
+	return c.deployment.StartTime
+}
+
+// EndTime returns the unix timestamp for the median block time after which an
+// attempted rule change fails if it has not already been locked in or
+// activated.
+//
+// This implementation returns the value defined by the specific deployment the
+// checker is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) EndTime() uint64 {
+	return c.deployment.ExpireTime
+}
+
+// RuleChangeActivationThreshold is the number of blocks for which the condition
+// must be true in order to lock in a rule change.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) RuleChangeActivationThreshold() uint32 {
+	return c.chain.params.RuleChangeActivationThreshold
+}
+
+// MinerConfirmationWindow is the number of blocks in each threshold state
+// retarget window.
+//
+// This implementation returns the value defined by the chain params the checker
+// is associated with.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) MinerConfirmationWindow() uint32 {
+	return c.chain.params.MinerConfirmationWindow
+}
+
+// Condition returns true when the specific bit defined by the deployment
+// associated with the checker is set.
+//
+// This is part of the thresholdConditionChecker interface implementation.
+func (c deploymentChecker) Condition(node *blockNode) (bool, error) {
+	conditionMask := uint32(1) << c.deployment.BitNumber
+	version := node.blockVersion
+	return (version&vbTopMask == vbTopBits) && (version&conditionMask != 0),
+		nil
+}
+
+// deployments returns the consensus rule change deployments of the base block
+// version of the chain.
+func (b *BlockChain) deployments() []params.ConsensusDeployment {
+	return b.params.Deployments[b.BlockVersion]
+}
+
+// nextBlockOrder returns the DAG order of the next block, which follows the
+// main chain tip.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) nextBlockOrder() uint {
+	return b.bd.GetGraphState().GetMainOrder() + 1
+}
+
+// deploymentState returns the current rule change threshold state of the
+// given deployment for the block at the given order.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) deploymentState(order uint, deploymentID string) (ThresholdState, error) {
+	deployments := b.deployments()
+	for i := range deployments {
+		if deployments[i].Id != deploymentID {
+			continue
+		}
+		checker := deploymentChecker{deployment: &deployments[i], chain: b}
+		return b.thresholdState(order, checker, &b.deploymentCaches[i])
+	}
+	return ThresholdFailed, DeploymentError(deploymentID)
+}
+
+// ThresholdState returns the current rule change threshold state of the given
+// deployment ID for the block AFTER the main chain tip.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ThresholdState(deploymentID string) (ThresholdState, error) {
+	b.chainLock.Lock()
+	state, err := b.deploymentState(b.nextBlockOrder(), deploymentID)
+	b.chainLock.Unlock()
+
+	return state, err
+}
+
+// IsDeploymentActive returns true if the target deploymentID is active, and
+// false otherwise.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsDeploymentActive(deploymentID string) (bool, error) {
+	b.chainLock.Lock()
+	state, err := b.deploymentState(b.nextBlockOrder(), deploymentID)
+	b.chainLock.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	return state == ThresholdActive, nil
+}
+
+// calcNextBlockVersion calculates the expected version of the block after the
+// main chain tip based on the state of started and locked in rule change
+// deployments.  The base block version of the chain is kept in the low bits.
+//
+// This function differs from the exported CalcNextBlockVersion in that the
+// exported version uses the current best chain as the previous block node
+// while this function accepts any block order.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) calcNextBlockVersion(order uint) (uint32, error) {
+	// Set the appropriate bits for each actively defined rule deployment
+	// that is either in the process of being voted on, or locked in for the
+	// activation at the next threshold window change.
+	expectedVersion := uint32(0)
+	deployments := b.deployments()
+	for i := range deployments {
+		deployment := &deployments[i]
+		if deployment.BitNumber >= vbNumBits ||
+			uint32(1)<<deployment.BitNumber&vbBaseVersionMask != 0 {
+			continue
+		}
+		cache := &b.deploymentCaches[i]
+		checker := deploymentChecker{deployment: deployment, chain: b}
+		state, err := b.thresholdState(order, checker, cache)
+		if err != nil {
+			return 0, err
+		}
+		if state == ThresholdStarted || state == ThresholdLockedIn {
+			expectedVersion |= uint32(1) << deployment.BitNumber
+		}
+	}
+	// The version bits scheme is only signaled when voting, so the blocks
+	// keep their base version otherwise.  It can't be signaled either if
+	// the base version doesn't fit in its bits.
+	if expectedVersion == 0 || b.BlockVersion&^vbBaseVersionMask != 0 {
+		return b.BlockVersion, nil
+	}
+	return vbTopBits | expectedVersion | b.BlockVersion, nil
+}
+
+// CalcNextBlockVersion calculates the expected version of the block after the
+// end of the current best chain based on the state of started and locked in
+// rule change deployments.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcNextBlockVersion() (uint32, error) {
+	b.chainLock.Lock()
+	version, err := b.calcNextBlockVersion(b.nextBlockOrder())
+	b.chainLock.Unlock()
+	return version, err
+}
+
+// DeploymentInfo houses the details of a consensus rule change deployment and
+// its threshold state.
+type DeploymentInfo struct {
+	Deployment params.ConsensusDeployment
+	State      ThresholdState
+}
+
+// DeploymentsInfo returns the details of all the consensus rule change
+// deployments of the chain for the block after the main chain tip.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DeploymentsInfo() ([]DeploymentInfo, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	order := b.nextBlockOrder()
+	deployments := b.deployments()
+	result := make([]DeploymentInfo, 0, len(deployments))
+	for i := range deployments {
+		state, err := b.deploymentState(order, deployments[i].Id)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, DeploymentInfo{
+			Deployment: deployments[i],
+			State:      state,
+		})
+	}
+	return result, nil
+}
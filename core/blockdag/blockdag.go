@@ -160,6 +160,12 @@ type BlockDAG struct {
 
 	//
 	calcWeight CalcWeight
+
+	// The database which is used to reload the pruned blocks
+	db database.DB
+
+	// The blocks which were pruned from memory
+	pruned map[hash.Hash]prunedBlock
 }
 
 // Acquire the name of DAG instance
@@ -173,7 +179,9 @@ func (bd *BlockDAG) GetInstance() IBlockDAG {
 }
 
 // Initialize self, the function to be invoked at the beginning
-func (bd *BlockDAG) Init(dagType string, calcWeight CalcWeight) IBlockDAG {
+func (bd *BlockDAG) Init(dagType string, calcWeight CalcWeight, db database.DB) IBlockDAG {
+	bd.db = db
+	bd.pruned = map[hash.Hash]prunedBlock{}
 	bd.instance = NewBlockDAG(dagType)
 	bd.instance.Init(bd)
 
@@ -273,12 +281,19 @@ func (bd *BlockDAG) isDAG(parents []*hash.Hash) bool {
 
 // Is there a block in DAG?
 func (bd *BlockDAG) hasBlock(h *hash.Hash) bool {
-	return bd.getBlock(h) != nil
+	if h == nil {
+		return false
+	}
+	_, ok := bd.blocks[*h]
+	return ok || bd.isPruned(h)
 }
 
 // Is there a block in DAG?
 func (bd *BlockDAG) HasBlock(h *hash.Hash) bool {
-	return bd.GetBlock(h) != nil
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	return bd.hasBlock(h)
 }
 
 // Is there some block in DAG?
@@ -306,7 +321,7 @@ func (bd *BlockDAG) getBlock(h *hash.Hash) IBlock {
 	}
 	block, ok := bd.blocks[*h]
 	if !ok {
-		return nil
+		return bd.loadPrunedBlock(h)
 	}
 	return block
 }
@@ -509,6 +524,11 @@ func (bd *BlockDAG) LocateBlocks(gs *GraphState, maxHashes uint) []*hash.Hash {
 	if gs.IsExcellent(bd.getGraphState()) {
 		return nil
 	}
+	for k := range gs.GetTips().GetMap() {
+		if bd.isPruned(&k) {
+			return bd.locateBlocksByOrder(gs, maxHashes)
+		}
+	}
 	queue := []IBlock{}
 	fs := NewHashSet()
 	tips := bd.getValidTips()
@@ -523,7 +543,8 @@ func (bd *BlockDAG) LocateBlocks(gs *GraphState, maxHashes uint) []*hash.Hash {
 		if fs.Has(cur.GetHash()) {
 			continue
 		}
-		if gs.GetTips().Has(cur.GetHash()) || cur.GetHash().IsEqual(&bd.genesis) {
+		if gs.GetTips().Has(cur.GetHash()) || cur.GetHash().IsEqual(&bd.genesis) ||
+			bd.isPruned(cur.GetHash()) {
 			continue
 		}
 		needRec := true
@@ -539,14 +560,11 @@ func (bd *BlockDAG) LocateBlocks(gs *GraphState, maxHashes uint) []*hash.Hash {
 		if needRec {
 			fs.AddPair(cur.GetHash(), cur)
 			if cur.HasParents() {
-				for _, v := range cur.GetParents().GetMap() {
-					value := v.(IBlock)
-					ib := value
-					if fs.Has(ib.GetHash()) {
+				for k := range cur.GetParents().GetMap() {
+					if fs.Has(&k) {
 						continue
 					}
-					queue = append(queue, ib)
-
+					queue = append(queue, bd.getBlock(&k))
 				}
 			}
 		}
@@ -588,6 +606,36 @@ func (bd *BlockDAG) LocateBlocks(gs *GraphState, maxHashes uint) []*hash.Hash {
 	return result
 }
 
+// Locate the blocks by order when the graph state refers to the pruned blocks,
+// they are too old to search the DAG from the tips.
+func (bd *BlockDAG) locateBlocksByOrder(gs *GraphState, maxHashes uint) []*hash.Hash {
+	var startOrder uint
+	found := false
+	for k := range gs.GetTips().GetMap() {
+		ib := bd.getBlock(&k)
+		if ib == nil || !ib.IsOrdered() {
+			continue
+		}
+		if !found || ib.GetOrder() < startOrder {
+			startOrder = ib.GetOrder()
+			found = true
+		}
+	}
+	result := []*hash.Hash{}
+	mainOrder := bd.getMainChainTip().GetOrder()
+	for i := startOrder + 1; i <= mainOrder; i++ {
+		if maxHashes > 0 && uint(len(result)) >= maxHashes {
+			break
+		}
+		h := bd.order[i]
+		if h == nil || gs.GetTips().Has(h) {
+			continue
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
 // Judging whether block is the virtual tip that it have not future set.
 func isVirtualTip(bs *HashSet, futureSet *HashSet, anticone *HashSet, children *HashSet) bool {
 	for k := range children.GetMap() {
@@ -603,7 +651,8 @@ func isVirtualTip(bs *HashSet, futureSet *HashSet, anticone *HashSet, children *
 
 // This function is used to GetAnticone recursion
 func (bd *BlockDAG) recAnticone(bs *HashSet, futureSet *HashSet, anticone *HashSet, h *hash.Hash) {
-	if bs.Has(h) {
+	// The pruned blocks are deep in the past of all the tips.
+	if bs.Has(h) || bd.isPruned(h) {
 		return
 	}
 	node := bd.getBlock(h)
@@ -694,6 +743,11 @@ func (bd *BlockDAG) GetConfirmations(h *hash.Hash) uint {
 	if bd.isOnMainChain(h) {
 		return mainTip.GetHeight() - block.GetHeight()
 	}
+	// The children of a pruned block are unknown, so it is approximated by
+	// the height of the block.
+	if bd.isPruned(h) {
+		return 1 + mainTip.GetHeight() - block.GetHeight()
+	}
 	if !block.HasChildren() {
 		return 0
 	}
@@ -945,11 +999,11 @@ func (bd *BlockDAG) IsHourglass(h *hash.Hash) bool {
 		if !cur.HasParents() {
 			continue
 		}
-		for _,v:=range cur.GetParents().GetMap() {
-			ib:=v.(IBlock)
-			if queueSet.Has(ib.GetHash()) {
+		for k:=range cur.GetParents().GetMap() {
+			if queueSet.Has(&k) {
 				continue
 			}
+			ib:=bd.getBlock(&k)
 			queue=append(queue,ib)
 			queueSet.Add(ib.GetHash())
 		}
@@ -148,7 +148,9 @@ func (ph *Phantom) getKChain(pb *PhantomBlock) *KChain {
 		result.blocks.AddPair(curPb.GetHash(), curPb)
 		result.miniLayer = curPb.GetLayer()
 		blueCount += curPb.blueDiffAnticone.Size()
-		if blueCount > ph.anticoneSize || curPb.mainParent == nil {
+		// The pruned blocks are too deep to color the new blocks.
+		if blueCount > ph.anticoneSize || curPb.mainParent == nil ||
+			ph.bd.isPruned(curPb.mainParent) {
 			break
 		}
 		curPb = ph.getBlock(curPb.mainParent)
@@ -173,7 +175,7 @@ func (ph *Phantom) coloringRule(kc *KChain, pb *PhantomBlock) bool {
 		if kc.blocks.Has(curPb.GetHash()) {
 			return true
 		}
-		if curPb.mainParent == nil {
+		if curPb.mainParent == nil || ph.bd.isPruned(curPb.mainParent) {
 			break
 		}
 		curPb = ph.getBlock(curPb.mainParent)
@@ -314,6 +316,13 @@ func (ph *Phantom) getIntersectionPathWithMainChain(pb *PhantomBlock) (*hash.Has
 		if curPb.mainParent == nil {
 			break
 		}
+		// The pruned main parent is not loaded when it is on the main
+		// chain.
+		if ph.bd.isPruned(curPb.mainParent) &&
+			ph.mainChain.blocks.Has(curPb.mainParent) {
+			intersection = curPb.mainParent
+			break
+		}
 		curPb = ph.getBlock(curPb.mainParent)
 	}
 	return intersection, result
@@ -328,7 +337,7 @@ func (ph *Phantom) rollBackMainChain(intersection *hash.Hash) {
 		}
 		ph.mainChain.blocks.Remove(curPb.GetHash())
 
-		if curPb.mainParent == nil {
+		if curPb.mainParent == nil || curPb.mainParent.IsEqual(intersection) {
 			break
 		}
 		curPb = ph.getBlock(curPb.mainParent)
@@ -421,19 +430,33 @@ func (ph *Phantom) GetDiffBlueSet() *HashSet {
 		return nil
 	}
 	ph.UpdateVirtualBlockOrder()
+	result := ph.getMainChainBlueSet()
+	for k, pb := range ph.bd.pruned {
+		if pb.flags&prunedBlue != 0 && pb.flags&prunedMainChain == 0 {
+			h := k
+			result.Add(&h)
+		}
+	}
+
+	if ph.virtualBlock.GetOrder() != MaxBlockOrder {
+		result.AddSet(ph.virtualBlock.blueDiffAnticone)
+	}
+	return result
+}
+
+// Return the blue blocks in the diff anticones of the main chain blocks which
+// are kept in memory. The walk stops at the first pruned main chain block, the
+// blocks in its past were pruned before.
+func (ph *Phantom) getMainChainBlueSet() *HashSet {
 	result := NewHashSet()
 	curPb := ph.getBlock(ph.mainChain.tip)
 	for {
 		result.AddSet(curPb.blueDiffAnticone)
-		if curPb.mainParent == nil {
+		if curPb.mainParent == nil || ph.bd.isPruned(curPb.mainParent) {
 			break
 		}
 		curPb = ph.getBlock(curPb.mainParent)
 	}
-
-	if ph.virtualBlock.GetOrder() != MaxBlockOrder {
-		result.AddSet(ph.virtualBlock.blueDiffAnticone)
-	}
 	return result
 }
 
@@ -452,6 +475,9 @@ func (ph *Phantom) GetBlockByOrder(order uint) *hash.Hash {
 
 // Query whether a given block is on the main chain.
 func (ph *Phantom) IsOnMainChain(b IBlock) bool {
+	if ph.bd.isPruned(b.GetHash()) {
+		return ph.mainChain.blocks.Has(b.GetHash())
+	}
 	for cur := ph.getBlock(ph.mainChain.tip); cur != nil; cur = ph.getBlock(cur.mainParent) {
 		if cur.GetHash().IsEqual(b.GetHash()) {
 			return true
@@ -462,6 +488,10 @@ func (ph *Phantom) IsOnMainChain(b IBlock) bool {
 		if cur.mainParent == nil {
 			break
 		}
+		// The main chain below is pruned except the genesis.
+		if ph.bd.isPruned(cur.mainParent) {
+			return ph.mainChain.blocks.Has(b.GetHash())
+		}
 	}
 	return false
 }
@@ -549,6 +579,13 @@ func (ph *Phantom) Load(dbTx database.Tx) error {
 
 	ph.mainChain.genesis = ph.bd.GetGenesisHash()
 
+	// The pruned blocks are not loaded into memory again.
+	pruned, err := dbFetchPrunedBlocks(dbTx)
+	if err != nil {
+		return err
+	}
+	ph.bd.pruned = pruned
+
 	for i := uint(0); i < ph.bd.blockTotal; i++ {
 		block := Block{id: i}
 		ib := ph.CreateBlock(&block)
@@ -559,11 +596,31 @@ func (ph *Phantom) Load(dbTx database.Tx) error {
 		if i == 0 && !ib.GetHash().IsEqual(ph.bd.GetGenesisHash()) {
 			return fmt.Errorf("genesis data mismatch")
 		}
+		if pb, ok := ph.bd.pruned[block.hash]; ok {
+			ph.bd.blockids[block.GetID()] = block.GetHash()
+			ph.bd.order[ib.GetOrder()] = ib.GetHash()
+			if pb.flags&prunedMainChain != 0 {
+				ph.mainChain.blocks.Add(block.GetHash())
+			}
+			if ib.HasParents() {
+				for k := range ib.GetParents().GetMap() {
+					if parent, ok := ph.bd.blocks[k]; ok {
+						parent.AddChild(newPrunedChild(ib))
+					}
+				}
+			}
+			continue
+		}
 		// Make up for missing
 		if ib.HasParents() {
 			parentsSet := NewHashSet()
 			for k := range ib.GetParents().GetMap() {
 				parentHash := k
+				// The children only keep the hash of the pruned parent.
+				if ph.bd.isPruned(&parentHash) {
+					parentsSet.Add(&parentHash)
+					continue
+				}
 				parent := ph.bd.getBlock(&parentHash)
 				parentsSet.AddPair(&parentHash, parent)
 				parent.AddChild(&block)
@@ -587,10 +644,12 @@ func (ph *Phantom) Load(dbTx database.Tx) error {
 
 	for cur := ph.getBlock(ph.mainChain.tip); cur != nil; cur = ph.getBlock(cur.mainParent) {
 		ph.mainChain.blocks.Add(cur.GetHash())
-		if cur.mainParent == nil {
+		if cur.mainParent == nil || ph.bd.isPruned(cur.mainParent) {
 			break
 		}
 	}
+	// The genesis is below the pruned blocks.
+	ph.mainChain.blocks.Add(ph.mainChain.genesis)
 	return nil
 }
 
@@ -624,6 +683,9 @@ func (ph *Phantom) GetBlues(parents *HashSet) uint {
 }
 
 func (ph *Phantom) IsBlue(h *hash.Hash) bool {
+	if pb, ok := ph.bd.pruned[*h]; ok {
+		return pb.flags&prunedBlue != 0
+	}
 	b := ph.getBlock(h)
 	if b == nil {
 		return false
@@ -642,6 +704,10 @@ func (ph *Phantom) IsBlue(h *hash.Hash) bool {
 		if cur.mainParent == nil {
 			break
 		}
+		// The main chain below is pruned except the genesis.
+		if ph.bd.isPruned(cur.mainParent) {
+			return ph.mainChain.blocks.Has(b.GetHash())
+		}
 	}
 	return false
 }
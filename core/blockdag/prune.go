@@ -0,0 +1,193 @@
+package blockdag
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/database"
+)
+
+// The blocks pruned from memory are also stored in the database, so they are
+// not loaded into memory again at startup.
+//
+// The serialized format for keys and values in the pruned block bucket is:
+//   <hash> = <block id><flags>
+//
+//   Field      Type       Size
+//   hash       hash.Hash  32 bytes
+//   block id   uint32     4 bytes
+//   flags      byte       1 byte
+
+const (
+	// prunedBlue is set when the pruned block is blue.
+	prunedBlue byte = 1 << iota
+
+	// prunedMainChain is set when the pruned block is on the main chain.
+	prunedMainChain
+)
+
+// prunedBlock is what is kept in memory of a block which was pruned, the blocks
+// far below the tips are final, so their color doesn't change anymore.
+type prunedBlock struct {
+	id    uint
+	flags byte
+}
+
+// newPrunedChild returns the bare block which stands for the pruned block in
+// the children of its parents.
+func newPrunedChild(ib IBlock) *Block {
+	return &Block{hash: *ib.GetHash(), layer: ib.GetLayer(), order: ib.GetOrder()}
+}
+
+// dbPutPrunedBlocks stores the pruned blocks.
+func dbPutPrunedBlocks(dbTx database.Tx, blocks map[hash.Hash]prunedBlock) error {
+	bucket, err := dbTx.Metadata().CreateBucketIfNotExists(dbnamespace.PrunedBlockBucketName)
+	if err != nil {
+		return err
+	}
+	for h, pb := range blocks {
+		var serialized [5]byte
+		dbnamespace.ByteOrder.PutUint32(serialized[:4], uint32(pb.id))
+		serialized[4] = pb.flags
+		err := bucket.Put(h[:], serialized[:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dbFetchPrunedBlocks loads the pruned blocks.
+func dbFetchPrunedBlocks(dbTx database.Tx) (map[hash.Hash]prunedBlock, error) {
+	result := map[hash.Hash]prunedBlock{}
+	bucket := dbTx.Metadata().Bucket(dbnamespace.PrunedBlockBucketName)
+	if bucket == nil {
+		return result, nil
+	}
+	err := bucket.ForEach(func(k, v []byte) error {
+		if len(k) != hash.HashSize || len(v) != 5 {
+			return fmt.Errorf("corrupt pruned block %x", k)
+		}
+		var h hash.Hash
+		copy(h[:], k)
+		result[h] = prunedBlock{
+			id:    uint(dbnamespace.ByteOrder.Uint32(v[:4])),
+			flags: v[4],
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Is the block pruned from memory?
+func (bd *BlockDAG) isPruned(h *hash.Hash) bool {
+	_, ok := bd.pruned[*h]
+	return ok
+}
+
+// IsPruned returns whether the block was pruned from memory.
+func (bd *BlockDAG) IsPruned(h *hash.Hash) bool {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	return bd.isPruned(h)
+}
+
+// Reload a pruned block from the database. The block is not kept in memory,
+// so it doesn't know its children and its parents set only holds the hashes.
+func (bd *BlockDAG) loadPrunedBlock(h *hash.Hash) IBlock {
+	pb, ok := bd.pruned[*h]
+	if !ok || bd.db == nil {
+		return nil
+	}
+	ib := bd.instance.CreateBlock(&Block{id: pb.id})
+	err := bd.db.View(func(dbTx database.Tx) error {
+		return DBGetDAGBlock(dbTx, ib)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to load pruned block %s: %v", h, err))
+		return nil
+	}
+	return ib
+}
+
+// Prune removes the blocks below the layer from memory and returns their
+// hashes. The genesis, the tips and the blocks that are not ordered yet are
+// always kept. The pruned blocks can still be acquired by hash, id and order,
+// they will be loaded from the database on demand. Whether they are blue and
+// on the main chain is kept in memory.
+func (bd *BlockDAG) Prune(layer uint) []*hash.Hash {
+	bd.stateLock.Lock()
+	defer bd.stateLock.Unlock()
+
+	if bd.db == nil {
+		return nil
+	}
+	// The other DAG types keep references to the blocks.
+	ph, ok := bd.instance.(*Phantom)
+	if !ok {
+		return nil
+	}
+	blueSet := ph.getMainChainBlueSet()
+	records := map[hash.Hash]prunedBlock{}
+	result := []*hash.Hash{}
+	for h, ib := range bd.blocks {
+		if ib.GetLayer() >= layer || !ib.IsOrdered() ||
+			h.IsEqual(&bd.genesis) || bd.tips.Has(&h) {
+			continue
+		}
+		prunedHash := h
+		// The maps of order and id shouldn't keep the block alive.
+		if bd.blockids[ib.GetID()] != nil {
+			bd.blockids[ib.GetID()] = &prunedHash
+		}
+		if oh := bd.order[ib.GetOrder()]; oh != nil && oh.IsEqual(&h) {
+			bd.order[ib.GetOrder()] = &prunedHash
+		}
+		// The children only keep the hash of the pruned parent.
+		if ib.HasChildren() {
+			for k := range ib.GetChildren().GetMap() {
+				child, ok := bd.blocks[k]
+				if !ok {
+					continue
+				}
+				child.GetParents().Add(&prunedHash)
+				if pb, ok := child.(*PhantomBlock); ok &&
+					pb.mainParent != nil && pb.mainParent.IsEqual(&h) {
+					pb.mainParent = &prunedHash
+				}
+			}
+			ib.GetChildren().Clean()
+		}
+		// The parents which are kept, like the genesis, only keep the
+		// hash of the pruned child.
+		if ib.HasParents() {
+			for k := range ib.GetParents().GetMap() {
+				if parent, ok := bd.blocks[k]; ok {
+					parent.AddChild(newPrunedChild(ib))
+				}
+			}
+			ib.GetParents().Clean()
+		}
+		record := prunedBlock{id: ib.GetID()}
+		if ph.mainChain.blocks.Has(&h) {
+			record.flags |= prunedMainChain | prunedBlue
+		} else if blueSet.Has(&h) {
+			record.flags |= prunedBlue
+		}
+		delete(bd.blocks, h)
+		bd.pruned[h] = record
+		records[h] = record
+		result = append(result, &prunedHash)
+	}
+	if len(records) == 0 {
+		return result
+	}
+	err := bd.db.Update(func(dbTx database.Tx) error {
+		return dbPutPrunedBlocks(dbTx, records)
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to store the pruned blocks: %v", err))
+	}
+	return result
+}
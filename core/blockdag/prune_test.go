@@ -0,0 +1,135 @@
+package blockdag
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/dbnamespace"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_Prune(t *testing.T) {
+	ibd, tbMap := InitBlockDAG(phantom, "PH_fig4-blocks")
+	if ibd == nil {
+		t.FailNow()
+	}
+	dbPath, err := ioutil.TempDir("", "blockdag_prune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", dbPath, protocol.MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The pruned blocks are reloaded from the database.
+	bd.db = db
+	err = db.Update(func(dbTx database.Tx) error {
+		_, err := dbTx.Metadata().CreateBucketIfNotExists(dbnamespace.BlockIndexBucketName)
+		if err != nil {
+			return err
+		}
+		for _, ib := range bd.blocks {
+			err := DBPutDAGBlock(dbTx, ib)
+			if err != nil {
+				return err
+			}
+		}
+		return DBPutDAGInfo(dbTx, &bd)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orders := map[hash.Hash]uint{}
+	onMainChain := map[hash.Hash]bool{}
+	blue := map[hash.Hash]bool{}
+	for _, h := range tbMap {
+		orders[*h] = bd.GetBlock(h).GetOrder()
+		onMainChain[*h] = bd.IsOnMainChain(h)
+		blue[*h] = bd.IsBlue(h)
+	}
+	blueSet := bd.instance.(*Phantom).GetDiffBlueSet()
+
+	pruned := bd.Prune(3)
+	if len(pruned) == 0 {
+		t.Fatal("No block was pruned")
+	}
+	for _, h := range pruned {
+		tag := getBlockTag(h, tbMap)
+		if _, ok := bd.blocks[*h]; ok {
+			t.Fatalf("Pruned block %s is still in memory", tag)
+		}
+		if !bd.HasBlock(h) || !bd.IsPruned(h) {
+			t.Fatalf("Pruned block %s is unknown", tag)
+		}
+		ib := bd.GetBlock(h)
+		if ib == nil || ib.GetOrder() != orders[*h] || ib.GetLayer() >= 3 {
+			t.Fatalf("Pruned block %s was not reloaded", tag)
+		}
+		if !bd.GetBlockHash(ib.GetID()).IsEqual(h) {
+			t.Fatalf("Pruned block %s lost its id", tag)
+		}
+		if bd.IsOnMainChain(h) != onMainChain[*h] {
+			t.Fatalf("Pruned block %s changed the main chain", tag)
+		}
+		if bd.IsBlue(h) != blue[*h] {
+			t.Fatalf("Pruned block %s changed its color", tag)
+		}
+	}
+	if !bd.instance.(*Phantom).GetDiffBlueSet().IsEqual(blueSet) {
+		t.Fatal("The pruned blocks changed the blue set")
+	}
+	if bd.IsPruned(bd.GetGenesisHash()) {
+		t.Fatal("The genesis was pruned")
+	}
+	for k := range bd.GetTips().GetMap() {
+		if bd.IsPruned(&k) {
+			t.Fatalf("The tip %s was pruned", getBlockTag(&k, tbMap))
+		}
+	}
+
+	// The pruned blocks are not loaded into memory again.
+	reloaded := &BlockDAG{}
+	reloaded.Init(phantom, CalcBlockWeight, db)
+	err = db.View(func(dbTx database.Tx) error {
+		return reloaded.Load(dbTx, bd.GetBlockTotal(), bd.GetGenesisHash())
+	})
+	if err != nil {
+		t.Fatalf("Failed to reload the DAG: %v", err)
+	}
+	if len(reloaded.blocks)+len(pruned) != len(tbMap) {
+		t.Fatalf("Reloaded %d blocks, want %d", len(reloaded.blocks),
+			len(tbMap)-len(pruned))
+	}
+	for _, h := range tbMap {
+		tag := getBlockTag(h, tbMap)
+		if reloaded.IsPruned(h) != bd.IsPruned(h) {
+			t.Fatalf("Block %s wasn't reloaded as pruned", tag)
+		}
+		if reloaded.GetBlock(h).GetOrder() != orders[*h] {
+			t.Fatalf("Block %s changed its order after reload", tag)
+		}
+		if reloaded.IsOnMainChain(h) != onMainChain[*h] {
+			t.Fatalf("Block %s changed the main chain after reload", tag)
+		}
+		if reloaded.IsBlue(h) != blue[*h] {
+			t.Fatalf("Block %s changed its color after reload", tag)
+		}
+	}
+	if !reloaded.GetMainChainTip().GetHash().IsEqual(bd.GetMainChainTip().GetHash()) {
+		t.Fatal("The main chain tip changed after reload")
+	}
+
+	// The DAG still grows on its tips.
+	block := buildBlock("Z", bd.GetTips().SortList(false), &tbMap)
+	l := bd.AddBlock(block)
+	if l == nil || l.Len() == 0 {
+		t.Fatal("Failed to add a block after pruning")
+	}
+}
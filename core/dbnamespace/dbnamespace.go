@@ -48,6 +48,10 @@ var (
 	// chain state.
 	ChainStateKeyName = []byte("chainstate")
 
+	// UtxoSnapshotKeyName is the name of the db key used to store the
+	// snapshot the utxo set was loaded from.
+	UtxoSnapshotKeyName = []byte("utxosnapshot")
+
 	// SpendJournalBucketName is the name of the db bucket used to house
 	// transactions outputs that are spent in each block.
 	SpendJournalBucketName = []byte("spendjournal")
@@ -67,4 +71,12 @@ var (
 	// DagInfoBucketName is the name of the db bucket used to house the
 	// dag information
 	DagInfoBucketName = []byte("daginfo")
+
+	// BlockReceiptBucketName is the name of the db bucket used to house the
+	// block hash -> block receipt mapping of the state commitment.
+	BlockReceiptBucketName = []byte("blockreceipts")
+
+	// PrunedBlockBucketName is the name of the db bucket used to house the
+	// block hash -> block id mapping of the blocks pruned from memory.
+	PrunedBlockBucketName = []byte("prunedblocks")
 )
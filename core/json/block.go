@@ -50,3 +50,37 @@ type GetBlockHeaderVerboseResult struct {
 	Time          int64     `json:"time"`
 	PowResult     PowResult `json:"pow"`
 }
+
+// DeploymentInfoResult models a consensus rule change deployment of the
+// getdeploymentinfo command.
+type DeploymentInfoResult struct {
+	Id         string `json:"id"`
+	Bit        uint8  `json:"bit"`
+	StartTime  uint64 `json:"startTime"`
+	ExpireTime uint64 `json:"expireTime"`
+	Status     string `json:"status"`
+}
+
+// GetDeploymentInfoResult models the data from the getdeploymentinfo command.
+type GetDeploymentInfoResult struct {
+	Threshold   uint32                 `json:"threshold"`
+	Window      uint32                 `json:"window"`
+	Deployments []DeploymentInfoResult `json:"deployments"`
+}
+
+// BlockReceiptResult models the data from the getblockreceipt command.
+type BlockReceiptResult struct {
+	Hash      string `json:"hash"`
+	Order     uint64 `json:"order"`
+	StateRoot string `json:"stateRoot"`
+}
+
+// GetStateProofResult models the data from the getstateproof command.
+type GetStateProofResult struct {
+	BlockHash string   `json:"blockhash"`
+	Order     uint64   `json:"order"`
+	StateRoot string   `json:"stateRoot"`
+	Key       string   `json:"key"`
+	Value     string   `json:"value,omitempty"`
+	Proof     []string `json:"proof"`
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package json
+
+// BlockNtfn models the data of the newBlocks subscription which is sent when
+// a block was accepted into the block DAG.
+type BlockNtfn struct {
+	Hash       string   `json:"hash"`
+	Height     uint64   `json:"height"`
+	Version    uint32   `json:"version"`
+	Parents    []string `json:"parents"`
+	TxRoot     string   `json:"txRoot"`
+	StateRoot  string   `json:"stateRoot"`
+	Difficulty uint32   `json:"difficulty"`
+	Time       int64    `json:"time"`
+	TxCount    int      `json:"txcount"`
+}
+
+// BlockOrderNtfn models the data of the blockOrders subscription which is
+// sent when a block was connected to or disconnected from the DAG order.
+type BlockOrderNtfn struct {
+	Hash      string `json:"hash"`
+	Order     uint64 `json:"order"`
+	Connected bool   `json:"connected"`
+}
+
+// AddressTxNtfn models the data of the addressTransactions subscription which
+// is sent for the transactions paying to or spending from the watched
+// addresses.  The block hash and order are only set for transactions which
+// were connected with a block.
+type AddressTxNtfn struct {
+	Tx        TxRawResult `json:"tx"`
+	BlockHash string      `json:"blockhash,omitempty"`
+	Order     uint64      `json:"order,omitempty"`
+}
@@ -132,3 +132,34 @@ type PrevOut struct {
 	Addresses []string `json:"addresses,omitempty"`
 	Value     float64  `json:"value"`
 }
+
+// GetAddressBalanceResult models the data from the getAddressBalance command.
+type GetAddressBalanceResult struct {
+	Address  string  `json:"address"`
+	Balance  float64 `json:"balance"`
+	Received float64 `json:"received"`
+	Sent     float64 `json:"sent"`
+}
+
+// AddressUtxoResult models an unspent output returned by the getAddressUtxos
+// command.
+type AddressUtxoResult struct {
+	TxId          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Amount        float64 `json:"amount"`
+	BlockHash     string  `json:"blockhash"`
+	Confirmations int64   `json:"confirmations"`
+	Coinbase      bool    `json:"coinbase"`
+}
+
+// AddressDeltaResult models a balance change returned by the
+// getAddressDeltas command.
+type AddressDeltaResult struct {
+	TxId      string  `json:"txid"`
+	Index     uint32  `json:"index"`
+	Input     bool    `json:"input"`
+	Amount    float64 `json:"amount"`
+	BlockHash string  `json:"blockhash"`
+	Order     uint32  `json:"order"`
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package json
+
+// GetBalanceResult models the data from the getbalance command.
+type GetBalanceResult struct {
+	Total     float64 `json:"total"`
+	Spendable float64 `json:"spendable"`
+	Immature  float64 `json:"immature"`
+	Pending   float64 `json:"pending"`
+}
+
+// ListUnspentResult models a successful response from the listunspent
+// command.
+type ListUnspentResult struct {
+	TxId          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	Address       string  `json:"address"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	Amount        float64 `json:"amount"`
+	Confirmations int64   `json:"confirmations"`
+	Coinbase      bool    `json:"coinbase"`
+	Spendable     bool    `json:"spendable"`
+	RedeemScript  string  `json:"redeemScript,omitempty"`
+}
+
+// CreateMultiSigResult models the data returned from the createmultisig and
+// addmultisigaddress commands.
+type CreateMultiSigResult struct {
+	Address      string `json:"address"`
+	RedeemScript string `json:"redeemScript"`
+}
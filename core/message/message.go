@@ -67,6 +67,10 @@ const (
 	CmdCFilter      = "cfilter"
 	CmdCFHeaders    = "cfheaders"
 	CmdCFTypes      = "cftypes"
+	CmdFilterAdd    = "filteradd"
+	CmdFilterClear  = "filterclear"
+	CmdFilterLoad   = "filterload"
+	CmdMerkleBlock  = "merkleblock"
 )
 
 // Message is an interface that describes a qitmeer message.  A type that
@@ -121,32 +125,33 @@ func makeEmptyMessage(command string) (Message, error) {
 		msg = &MsgGetMiningState{}
 	case CmdGraphState:
 		msg = &MsgGraphState{}
+	case CmdGetCFilter:
+		msg = &MsgGetCFilter{}
+	case CmdGetCFHeaders:
+		msg = &MsgGetCFHeaders{}
+	case CmdCFilter:
+		msg = &MsgCFilter{}
+	case CmdCFHeaders:
+		msg = &MsgCFHeaders{}
+	case CmdFilterAdd:
+		msg = &MsgFilterAdd{}
+	case CmdFilterClear:
+		msg = &MsgFilterClear{}
+	case CmdFilterLoad:
+		msg = &MsgFilterLoad{}
+	case CmdMerkleBlock:
+		msg = &MsgMerkleBlock{}
+	case CmdMemPool:
+		msg = &MsgMemPool{}
+	case CmdFeeFilter:
+		msg = &MsgFeeFilter{}
+	case CmdSendHeaders:
+		msg = &MsgSendHeaders{}
 	/*
 
-		case CmdMemPool:
-			msg = &MsgMemPool{}
-
-		case CmdSendHeaders:
-			msg = &MsgSendHeaders{}
-
-		case CmdFeeFilter:
-			msg = &MsgFeeFilter{}
-
-		case CmdGetCFilter:
-			msg = &MsgGetCFilter{}
-
-		case CmdGetCFHeaders:
-			msg = &MsgGetCFHeaders{}
-
 		case CmdGetCFTypes:
 			msg = &MsgGetCFTypes{}
 
-		case CmdCFilter:
-			msg = &MsgCFilter{}
-
-		case CmdCFHeaders:
-			msg = &MsgCFHeaders{}
-
 		case CmdCFTypes:
 			msg = &MsgCFTypes{}
 	*/
@@ -184,7 +189,7 @@ func readMessageHeader(r io.Reader) (int, *messageHeader, error) {
 	s.ReadElements(hr, &hdr.magic, &command, &hdr.length, &hdr.checksum)
 
 	// Strip trailing zeros from command string.
-	hdr.command = string(bytes.TrimRight(command[:], string(0)))
+	hdr.command = string(bytes.TrimRight(command[:], string(rune(0))))
 
 	return n, &hdr, nil
 }
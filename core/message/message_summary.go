@@ -67,6 +67,35 @@ func Summary(msg Message) string {
 	case *MsgHeaders:
 		return msg.String()
 
+	case *MsgGetCFilter:
+		return fmt.Sprintf("hash %s, type %s", msg.BlockHash, msg.FilterType)
+
+	case *MsgCFilter:
+		return fmt.Sprintf("hash %s, type %s, size %d", msg.BlockHash,
+			msg.FilterType, len(msg.Data))
+
+	case *MsgGetCFHeaders:
+		return fmt.Sprintf("locators %d, stop %s, type %s",
+			len(msg.BlockLocatorHashes), msg.HashStop, msg.FilterType)
+
+	case *MsgCFHeaders:
+		return fmt.Sprintf("stop %s, type %s, headers %d", msg.StopHash,
+			msg.FilterType, len(msg.HeaderHashes))
+
+	case *MsgFilterAdd:
+		return fmt.Sprintf("data size %d", len(msg.Data))
+
+	case *MsgFilterClear:
+		// No summary.
+
+	case *MsgFilterLoad:
+		return fmt.Sprintf("size %d, hashfuncs %d, tweak %d, flags %d",
+			len(msg.Filter), msg.HashFuncs, msg.Tweak, msg.Flags)
+
+	case *MsgMerkleBlock:
+		return fmt.Sprintf("hash %s, %d tx, %d hashes", msg.Header.BlockHash(),
+			msg.Transactions, len(msg.Hashes))
+
 	case *MsgReject:
 		// Ensure the variable length strings don't contain any
 		// characters which are even remotely dangerous such as HTML
@@ -0,0 +1,165 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+)
+
+// MaxCFHeadersPerMsg is the maximum number of committed filter headers that
+// can be in a single cfheaders message.
+const MaxCFHeadersPerMsg = 2000
+
+// MsgCFHeaders implements the Message interface and represents a cfheaders
+// message.  It is used to deliver committed filter header information in
+// response to a getcfheaders message (MsgGetCFHeaders). The maximum number of
+// committed filter headers per message is currently 2000. See MsgGetCFHeaders
+// for details on requesting the headers.
+//
+// The filter headers are in the DAG order along with the hashes of their
+// blocks, and each of them commits to the previous one.  The first of them
+// commits to PrevFilterHeader, so the filter headers can be checked against
+// the filters of their blocks.
+type MsgCFHeaders struct {
+	StopHash         hash.Hash
+	FilterType       FilterType
+	PrevFilterHeader hash.Hash
+	BlockHashes      []*hash.Hash
+	HeaderHashes     []*hash.Hash
+}
+
+// AddCFHeader adds a new committed filter header of the block with the given
+// hash to the message.
+func (msg *MsgCFHeaders) AddCFHeader(blockHash *hash.Hash, headerHash *hash.Hash) error {
+	if len(msg.HeaderHashes)+1 > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many block headers in message [max %v]",
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.AddCFHeader", str)
+	}
+
+	msg.BlockHashes = append(msg.BlockHashes, blockHash)
+	msg.HeaderHashes = append(msg.HeaderHashes, headerHash)
+	return nil
+}
+
+// Decode decodes r using the protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) Decode(r io.Reader, pver uint32) error {
+	if pver < protocol.NodeCFVersion {
+		str := fmt.Sprintf("cfheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCFHeaders.Decode", str)
+	}
+	var filterType uint8
+	err := s.ReadElements(r, &msg.StopHash, &filterType,
+		&msg.PrevFilterHeader)
+	if err != nil {
+		return err
+	}
+	msg.FilterType = FilterType(filterType)
+
+	// Read number of filter headers.
+	count, err := s.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max committed filter headers per message.
+	if count > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many committed filter headers for "+
+			"message [count %v, max %v]", count,
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.Decode", str)
+	}
+
+	// Create a contiguous slice of hashes to deserialize into in order to
+	// reduce the number of allocations.
+	hashes := make([]hash.Hash, 2*count)
+	msg.BlockHashes = make([]*hash.Hash, 0, count)
+	msg.HeaderHashes = make([]*hash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		blockHash, cfh := &hashes[2*i], &hashes[2*i+1]
+		err := s.ReadElements(r, blockHash, cfh)
+		if err != nil {
+			return err
+		}
+		msg.AddCFHeader(blockHash, cfh)
+	}
+
+	return nil
+}
+
+// Encode encodes the receiver to w using the protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) Encode(w io.Writer, pver uint32) error {
+	if pver < protocol.NodeCFVersion {
+		str := fmt.Sprintf("cfheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCFHeaders.Encode", str)
+	}
+	// Limit to max committed headers per message.
+	count := len(msg.HeaderHashes)
+	if len(msg.BlockHashes) != count {
+		str := fmt.Sprintf("%d block hashes for %d committed filter "+
+			"headers", len(msg.BlockHashes), count)
+		return messageError("MsgCFHeaders.Encode", str)
+	}
+	if count > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many committed filter headers for "+
+			"message [count %v, max %v]", count,
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.Encode", str)
+	}
+
+	err := s.WriteElements(w, &msg.StopHash, uint8(msg.FilterType),
+		&msg.PrevFilterHeader)
+	if err != nil {
+		return err
+	}
+
+	err = s.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for i, cfh := range msg.HeaderHashes {
+		err := s.WriteElements(w, msg.BlockHashes[i], cfh)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFHeaders) Command() string {
+	return CmdCFHeaders
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) MaxPayloadLength(pver uint32) uint32 {
+	// Stop hash + filter type + previous filter header + num headers
+	// (varInt) + ((block hash + header) size * max headers).
+	return hash.HashSize + 1 + hash.HashSize + MaxVarIntPayload +
+		(MaxCFHeadersPerMsg * 2 * hash.HashSize)
+}
+
+// NewMsgCFHeaders returns a new cfheaders message that conforms to the Message
+// interface. See MsgCFHeaders for details.
+func NewMsgCFHeaders() *MsgCFHeaders {
+	return &MsgCFHeaders{
+		BlockHashes:  make([]*hash.Hash, 0, MaxCFHeadersPerMsg),
+		HeaderHashes: make([]*hash.Hash, 0, MaxCFHeadersPerMsg),
+	}
+}
@@ -7,7 +7,9 @@ package message
 
 import (
 	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/protocol"
 	s "github.com/Qitmeer/qitmeer/core/serialization"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"io"
@@ -22,13 +24,24 @@ const MaxBlockHeadersPerMsg = 2000
 // to a getheaders message (MsgGetHeaders).  The maximum number of block headers
 // per message is currently 2000.  See MsgGetHeaders for details on requesting
 // the headers.
+//
+// Since protocol version HeaderParentsVersion every header is followed by the
+// ordered parent hashes of the block, which commit to the parent root of the
+// header.  Parents has one entry for every header.
 type MsgHeaders struct {
 	Headers []*types.BlockHeader
+	Parents [][]*hash.Hash
 	GS      *blockdag.GraphState
 }
 
 // AddBlockHeader adds a new block header to the message.
 func (msg *MsgHeaders) AddBlockHeader(bh *types.BlockHeader) error {
+	return msg.AddBlockHeaderWithParents(bh, nil)
+}
+
+// AddBlockHeaderWithParents adds a new block header along with the parent
+// hashes of the block to the message.
+func (msg *MsgHeaders) AddBlockHeaderWithParents(bh *types.BlockHeader, parents []*hash.Hash) error {
 	if len(msg.Headers)+1 > MaxBlockHeadersPerMsg {
 		str := fmt.Sprintf("too many block headers in message [max %v]",
 			MaxBlockHeadersPerMsg)
@@ -36,6 +49,7 @@ func (msg *MsgHeaders) AddBlockHeader(bh *types.BlockHeader) error {
 	}
 
 	msg.Headers = append(msg.Headers, bh)
+	msg.Parents = append(msg.Parents, parents)
 	return nil
 }
 
@@ -65,18 +79,38 @@ func (msg *MsgHeaders) Decode(r io.Reader, pver uint32) error {
 			return err
 		}
 
-		txCount, err := s.ReadVarInt(r, pver)
+		count, err := s.ReadVarInt(r, pver)
 		if err != nil {
 			return err
 		}
 
-		// Ensure the transaction count is zero for headers.
-		if txCount > 0 {
-			str := fmt.Sprintf("block headers may not contain "+
-				"transactions [count %v]", txCount)
+		if pver < protocol.HeaderParentsVersion {
+			// Ensure the transaction count is zero for headers.
+			if count > 0 {
+				str := fmt.Sprintf("block headers may not contain "+
+					"transactions [count %v]", count)
+				return messageError("MsgHeaders.BtcDecode", str)
+			}
+			msg.AddBlockHeader(bh)
+			continue
+		}
+
+		// Limit to max parents per block.
+		if count > types.MaxParentsPerBlock {
+			str := fmt.Sprintf("too many parents for block header "+
+				"[count %v, max %v]", count, types.MaxParentsPerBlock)
 			return messageError("MsgHeaders.BtcDecode", str)
 		}
-		msg.AddBlockHeader(bh)
+		var parents []*hash.Hash
+		for j := uint64(0); j < count; j++ {
+			var parent hash.Hash
+			err := s.ReadElements(r, &parent)
+			if err != nil {
+				return err
+			}
+			parents = append(parents, &parent)
+		}
+		msg.AddBlockHeaderWithParents(bh, parents)
 	}
 	msg.GS = blockdag.NewGraphState()
 	err = msg.GS.Decode(r, pver)
@@ -102,19 +136,38 @@ func (msg *MsgHeaders) Encode(w io.Writer, pver uint32) error {
 		return err
 	}
 
-	for _, bh := range msg.Headers {
+	for i, bh := range msg.Headers {
 		err := bh.Serialize(w)
 		if err != nil {
 			return err
 		}
-		// The wire protocol encoding always includes a 0 for the number
-		// of transactions on header messages.  This is really just an
-		// artifact of the way the original implementation serializes
-		// block headers, but it is required.
-		err = s.WriteVarInt(w, pver, 0)
+		if pver < protocol.HeaderParentsVersion {
+			// The wire protocol encoding always includes a 0 for
+			// the number of transactions on header messages.  This
+			// is really just an artifact of the way the original
+			// implementation serializes block headers, but it is
+			// required.
+			err = s.WriteVarInt(w, pver, 0)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var parents []*hash.Hash
+		if i < len(msg.Parents) {
+			parents = msg.Parents[i]
+		}
+		err = s.WriteVarInt(w, pver, uint64(len(parents)))
 		if err != nil {
 			return err
 		}
+		for _, parent := range parents {
+			err = s.WriteElements(w, parent)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	err = msg.GS.Encode(w, pver)
@@ -133,10 +186,17 @@ func (msg *MsgHeaders) Command() string {
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgHeaders) MaxPayloadLength(pver uint32) uint32 {
-	// Num headers (varInt) + max allowed headers (header length + 1 byte
-	// for the number of transactions which is always 0).
-	return MaxVarIntPayload + ((types.MaxBlockHeaderPayload+1)*
-		MaxBlockHeadersPerMsg + msg.GS.MaxPayloadLength())
+	if pver < protocol.HeaderParentsVersion {
+		// Num headers (varInt) + max allowed headers (header length +
+		// 1 byte for the number of transactions which is always 0).
+		return MaxVarIntPayload + ((types.MaxBlockHeaderPayload+1)*
+			MaxBlockHeadersPerMsg + msg.GS.MaxPayloadLength())
+	}
+	// Num headers (varInt) + max allowed headers (header length + num
+	// parents (varInt) + max allowed parents).
+	return MaxVarIntPayload + ((types.MaxBlockHeaderPayload+MaxVarIntPayload+
+		types.MaxParentsPerBlock*hash.HashSize)*MaxBlockHeadersPerMsg +
+		msg.GS.MaxPayloadLength())
 }
 
 func (msg *MsgHeaders) String() string {
@@ -148,6 +208,7 @@ func (msg *MsgHeaders) String() string {
 func NewMsgHeaders(gs *blockdag.GraphState) *MsgHeaders {
 	return &MsgHeaders{
 		Headers: make([]*types.BlockHeader, 0, MaxBlockHeadersPerMsg),
+		Parents: make([][]*hash.Hash, 0, MaxBlockHeadersPerMsg),
 		GS:      gs,
 	}
 }
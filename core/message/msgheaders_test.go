@@ -0,0 +1,285 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+	"reflect"
+	"testing"
+)
+
+// testHeadersGraphState returns the graph state of a node with only the
+// genesis block, which is sent along with the headers.
+func testHeadersGraphState() *blockdag.GraphState {
+	gs := blockdag.NewGraphState()
+	gs.GetTips().Add(params.MainNetParams.GenesisHash)
+	gs.SetTotal(1)
+	return gs
+}
+
+// TestHeaders tests the MsgHeaders API.
+func TestHeaders(t *testing.T) {
+	pver := protocol.ProtocolVersion
+	msg := NewMsgHeaders(testHeadersGraphState())
+
+	// Ensure the command is expected value.
+	wantCmd := "headers"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgHeaders: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version,
+	// which carries the parents of every header.
+	gsPayload := msg.GS.MaxPayloadLength()
+	wantPayload := uint32(MaxVarIntPayload + (types.MaxBlockHeaderPayload+
+		MaxVarIntPayload+types.MaxParentsPerBlock*hash.HashSize)*
+		MaxBlockHeadersPerMsg + gsPayload)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure max payload is expected value before HeaderParentsVersion.
+	oldPver := protocol.HeaderParentsVersion - 1
+	wantPayload = uint32(MaxVarIntPayload + (types.MaxBlockHeaderPayload+1)*
+		MaxBlockHeadersPerMsg + gsPayload)
+	maxPayload = msg.MaxPayloadLength(oldPver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", oldPver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure headers are added properly along with their parents.
+	bh := &params.MainNetParams.GenesisBlock.Header
+	parents := []*hash.Hash{params.MainNetParams.GenesisHash}
+	msg.AddBlockHeaderWithParents(bh, parents)
+	if !reflect.DeepEqual(msg.Headers[0], bh) {
+		t.Errorf("AddBlockHeaderWithParents: wrong header - got %v, "+
+			"want %v", headerHashes(msg.Headers...), headerHashes(bh))
+	}
+	if !reflect.DeepEqual(msg.Parents[0], parents) {
+		t.Errorf("AddBlockHeaderWithParents: wrong parents - got %v, "+
+			"want %v", msg.Parents[0], parents)
+	}
+	msg.AddBlockHeader(bh)
+	if len(msg.Parents) != 2 || msg.Parents[1] != nil {
+		t.Errorf("AddBlockHeader: got parents %v, want none",
+			msg.Parents[1:])
+	}
+
+	// Ensure adding more than the max allowed headers per message returns
+	// error.
+	var err error
+	for i := len(msg.Headers); i < MaxBlockHeadersPerMsg+1; i++ {
+		err = msg.AddBlockHeader(bh)
+	}
+	if reflect.TypeOf(err) != reflect.TypeOf(&MessageError{}) {
+		t.Errorf("AddBlockHeader: expected error on too many headers " +
+			"not received")
+	}
+	if len(msg.Headers) != len(msg.Parents) {
+		t.Errorf("Got %d headers and %d parents", len(msg.Headers),
+			len(msg.Parents))
+	}
+}
+
+// headerHashes returns the hashes of the passed headers for the error
+// messages.
+func headerHashes(headers ...*types.BlockHeader) []hash.Hash {
+	hashes := make([]hash.Hash, 0, len(headers))
+	for _, bh := range headers {
+		hashes = append(hashes, bh.BlockHash())
+	}
+	return hashes
+}
+
+// TestHeadersWire tests the MsgHeaders wire encode and decode for the protocol
+// versions around HeaderParentsVersion, with and without parents.
+func TestHeadersWire(t *testing.T) {
+	bh := &params.MainNetParams.GenesisBlock.Header
+	parents := []*hash.Hash{
+		params.MainNetParams.GenesisHash,
+		params.TestNetParams.GenesisHash,
+	}
+
+	// newMsg returns a headers message of the passed headers and parents.
+	newMsg := func(parents ...[]*hash.Hash) *MsgHeaders {
+		msg := NewMsgHeaders(testHeadersGraphState())
+		for _, p := range parents {
+			msg.AddBlockHeaderWithParents(bh, p)
+		}
+		return msg
+	}
+
+	tests := []struct {
+		name string
+		in   *MsgHeaders // Message to encode
+		out  *MsgHeaders // Expected decoded message
+		pver uint32      // Protocol version for wire encoding
+	}{
+		{
+			"no headers",
+			newMsg(),
+			newMsg(),
+			protocol.ProtocolVersion,
+		},
+		{
+			"with parents",
+			newMsg(parents, parents[:1]),
+			newMsg(parents, parents[:1]),
+			protocol.ProtocolVersion,
+		},
+		{
+			"with parents at HeaderParentsVersion",
+			newMsg(parents),
+			newMsg(parents),
+			protocol.HeaderParentsVersion,
+		},
+		{
+			"without parents at HeaderParentsVersion",
+			newMsg(nil),
+			newMsg(nil),
+			protocol.HeaderParentsVersion,
+		},
+		{
+			// The parents aren't sent before HeaderParentsVersion.
+			"with parents before HeaderParentsVersion",
+			newMsg(parents, parents),
+			newMsg(nil, nil),
+			protocol.HeaderParentsVersion - 1,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for _, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.Encode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("%s: encode error %v", test.name, err)
+			continue
+		}
+
+		// Decode the message from wire format.
+		msg := &MsgHeaders{}
+		err = msg.Decode(bytes.NewReader(buf.Bytes()), test.pver)
+		if err != nil {
+			t.Errorf("%s: decode error %v", test.name, err)
+			continue
+		}
+		checkHeaders(t, test.name, msg, test.out)
+
+		// The message is read back through the message framing.
+		buf.Reset()
+		err = WriteMessage(&buf, test.in, test.pver, protocol.MainNet)
+		if err != nil {
+			t.Errorf("%s: WriteMessage error %v", test.name, err)
+			continue
+		}
+		read, _, err := ReadMessage(&buf, test.pver, protocol.MainNet)
+		if err != nil {
+			t.Errorf("%s: ReadMessage error %v", test.name, err)
+			continue
+		}
+		checkHeaders(t, test.name, read.(*MsgHeaders), test.out)
+	}
+}
+
+// checkHeaders fails the test unless the decoded headers message has the
+// headers, parents and graph state of the expected one.
+func checkHeaders(t *testing.T, name string, msg, want *MsgHeaders) {
+	if len(msg.Headers) != len(want.Headers) ||
+		len(msg.Parents) != len(want.Parents) {
+		t.Errorf("%s: got %d headers with %d parents, want %d with %d",
+			name, len(msg.Headers), len(msg.Parents), len(want.Headers),
+			len(want.Parents))
+		return
+	}
+	for i := range msg.Headers {
+		if msg.Headers[i].BlockHash() != want.Headers[i].BlockHash() {
+			t.Errorf("%s: header %d got %v, want %v", name, i,
+				headerHashes(msg.Headers[i]), headerHashes(want.Headers[i]))
+		}
+		if !reflect.DeepEqual(msg.Parents[i], want.Parents[i]) {
+			t.Errorf("%s: parents %d got %v, want %v", name, i,
+				msg.Parents[i], want.Parents[i])
+		}
+	}
+	if !msg.GS.IsEqual(want.GS) {
+		t.Errorf("%s: got graph state %v, want %v", name, msg.GS.String(),
+			want.GS.String())
+	}
+}
+
+// TestHeadersWireErrors performs negative tests against wire decode of
+// MsgHeaders to confirm error paths work correctly.
+func TestHeadersWireErrors(t *testing.T) {
+	bh := &params.MainNetParams.GenesisBlock.Header
+	var header bytes.Buffer
+	if err := bh.Serialize(&header); err != nil {
+		t.Fatal(err)
+	}
+
+	// encoded returns the encoding of a headers message of one header
+	// followed by the passed count.
+	encoded := func(pver uint32, count uint64) []byte {
+		var buf bytes.Buffer
+		s.WriteVarInt(&buf, pver, 1)
+		buf.Write(header.Bytes())
+		s.WriteVarInt(&buf, pver, count)
+		return buf.Bytes()
+	}
+
+	// A message with too many headers.
+	var tooMany bytes.Buffer
+	s.WriteVarInt(&tooMany, protocol.ProtocolVersion,
+		MaxBlockHeadersPerMsg+1)
+
+	tests := []struct {
+		name string
+		buf  []byte // Wire encoding
+		pver uint32 // Protocol version for wire encoding
+	}{
+		{"too many headers", tooMany.Bytes(), protocol.ProtocolVersion},
+		{"transactions before HeaderParentsVersion",
+			encoded(protocol.HeaderParentsVersion-1, 1),
+			protocol.HeaderParentsVersion - 1},
+		{"too many parents", encoded(protocol.ProtocolVersion,
+			types.MaxParentsPerBlock+1), protocol.ProtocolVersion},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for _, test := range tests {
+		var msg MsgHeaders
+		err := msg.Decode(bytes.NewReader(test.buf), test.pver)
+		if _, ok := err.(*MessageError); !ok {
+			t.Errorf("%s: got error %v, want MessageError", test.name,
+				err)
+		}
+	}
+
+	// Encoding more than the max allowed headers per message fails.
+	msg := NewMsgHeaders(testHeadersGraphState())
+	for i := 0; i < MaxBlockHeadersPerMsg+1; i++ {
+		msg.Headers = append(msg.Headers, bh)
+	}
+	var buf bytes.Buffer
+	err := msg.Encode(&buf, protocol.ProtocolVersion)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("Encode of too many headers got error %v, want "+
+			"MessageError", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"testing"
+)
+
+func TestMemPool(t *testing.T) {
+	pver := protocol.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "mempool"
+	msg := NewMsgMemPool()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgMemPool: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(0)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.Encode(&buf, pver)
+	if err != nil {
+		t.Errorf("encode of MsgMemPool failed %v err <%v>", msg, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("encode of MsgMemPool wrote %d bytes", buf.Len())
+	}
+
+	// Older protocol versions should fail encode since message didn't
+	// exist yet.
+	oldPver := protocol.FeeFilterVersion - 1
+	err = msg.Encode(&buf, oldPver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("encode of MsgMemPool succeeded when it shouldn't "+
+			"have %v err <%v>", msg, err)
+	}
+
+	// Test decode with latest protocol version.
+	readmsg := NewMsgMemPool()
+	err = readmsg.Decode(&buf, pver)
+	if err != nil {
+		t.Errorf("decode of MsgMemPool failed [%v] err <%v>", buf, err)
+	}
+
+	// Older protocol versions should fail decode since message didn't
+	// exist yet.
+	err = readmsg.Decode(&buf, oldPver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("decode of MsgMemPool succeeded when it shouldn't "+
+			"have %v err <%v>", msg, err)
+	}
+}
+
+// TestMemPoolWire tests the MsgMemPool wire encode and decode through the
+// message framing for the protocol versions around FeeFilterVersion.
+func TestMemPoolWire(t *testing.T) {
+	tests := []struct {
+		pver  uint32 // Protocol version for wire encoding
+		valid bool   // Whether the message exists at the version
+	}{
+		{protocol.ProtocolVersion, true},
+		{protocol.FeeFilterVersion, true},
+		{protocol.FeeFilterVersion - 1, false},
+	}
+
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := WriteMessage(&buf, NewMsgMemPool(), test.pver,
+			protocol.MainNet)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("WriteMessage #%d succeeded when it "+
+					"shouldn't have", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("WriteMessage #%d error %v", i, err)
+			continue
+		}
+
+		msg, _, err := ReadMessage(&buf, test.pver, protocol.MainNet)
+		if err != nil {
+			t.Errorf("ReadMessage #%d error %v", i, err)
+			continue
+		}
+		if _, ok := msg.(*MsgMemPool); !ok {
+			t.Errorf("ReadMessage #%d got %T, want *MsgMemPool", i, msg)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package message
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"testing"
+)
+
+// TestSendHeaders tests the MsgSendHeaders API against the latest protocol
+// version.
+func TestSendHeaders(t *testing.T) {
+	pver := protocol.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "sendheaders"
+	msg := NewMsgSendHeaders()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgSendHeaders: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(0)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.Encode(&buf, pver)
+	if err != nil {
+		t.Errorf("encode of MsgSendHeaders failed %v err <%v>", msg,
+			err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("encode of MsgSendHeaders wrote %d bytes", buf.Len())
+	}
+
+	// Older protocol versions should fail encode since message didn't
+	// exist yet.
+	oldPver := protocol.SendHeadersVersion - 1
+	err = msg.Encode(&buf, oldPver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("encode of MsgSendHeaders succeeded when it "+
+			"shouldn't have %v err <%v>", msg, err)
+	}
+
+	// Test decode with latest protocol version.
+	readmsg := NewMsgSendHeaders()
+	err = readmsg.Decode(&buf, pver)
+	if err != nil {
+		t.Errorf("decode of MsgSendHeaders failed [%v] err <%v>", buf,
+			err)
+	}
+
+	// Older protocol versions should fail decode since message didn't
+	// exist yet.
+	err = readmsg.Decode(&buf, oldPver)
+	if _, ok := err.(*MessageError); !ok {
+		t.Errorf("decode of MsgSendHeaders succeeded when it "+
+			"shouldn't have %v err <%v>", msg, err)
+	}
+}
+
+// TestSendHeadersWire tests the MsgSendHeaders wire encode and decode through
+// the message framing for the protocol versions around SendHeadersVersion.
+func TestSendHeadersWire(t *testing.T) {
+	tests := []struct {
+		pver  uint32 // Protocol version for wire encoding
+		valid bool   // Whether the message exists at the version
+	}{
+		{protocol.ProtocolVersion, true},
+		{protocol.SendHeadersVersion, true},
+		{protocol.SendHeadersVersion - 1, false},
+	}
+
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := WriteMessage(&buf, NewMsgSendHeaders(), test.pver,
+			protocol.MainNet)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("WriteMessage #%d succeeded when it "+
+					"shouldn't have", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("WriteMessage #%d error %v", i, err)
+			continue
+		}
+
+		msg, _, err := ReadMessage(&buf, test.pver, protocol.MainNet)
+		if err != nil {
+			t.Errorf("ReadMessage #%d error %v", i, err)
+			continue
+		}
+		if _, ok := msg.(*MsgSendHeaders); !ok {
+			t.Errorf("ReadMessage #%d got %T, want *MsgSendHeaders", i,
+				msg)
+		}
+	}
+}
@@ -16,7 +16,28 @@ const (
 	InitialProcotolVersion uint32 = 12
 
 	// ProtocolVersion is the latest protocol version this package supports.
-	ProtocolVersion uint32 = 12
+	ProtocolVersion uint32 = 16
+
+	// HeaderParentsVersion is the protocol version which added the parent
+	// hashes of every block to the headers message, so that light nodes
+	// can build the block DAG from headers alone.
+	HeaderParentsVersion uint32 = 13
+
+	// FeeFilterVersion is the protocol version which added the mempool and
+	// feefilter messages, so that peers can request the inventory of the
+	// transaction pool and advertise the minimum fee of the transactions
+	// they want to be relayed.
+	FeeFilterVersion uint32 = 14
+
+	// SendHeadersVersion is the protocol version which added the
+	// sendheaders message, so that peers can ask for new blocks to be
+	// announced by their headers instead of inventory vectors.
+	SendHeadersVersion uint32 = 15
+
+	// NodeCFVersion is the protocol version which added the getcfilter,
+	// cfilter, getcfheaders and cfheaders messages, so that light clients
+	// can fetch the committed filters of nodes advertising the CF service.
+	NodeCFVersion uint32 = 16
 )
 
 // Network represents which qitmeer network a message belongs to.
@@ -0,0 +1,480 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package psbt implements a container for partially signed transactions,
+// modelled after BIP174.  A packet carries an unsigned transaction along with
+// the outputs spent by its inputs, the redeem scripts of pay-to-script-hash
+// outputs and the signatures collected so far, so the parties to a multisig
+// transaction can sign it independently before it is finalized and extracted.
+package psbt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"io"
+)
+
+// magic is the prefix of every serialized packet: "psbt" followed by 0xff.
+var magic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// maxPsbtSize is the maximum size of a serialized packet.
+const maxPsbtSize = types.MaxBlockPayload * 4
+
+// The key types of the global map.
+const (
+	globalUnsignedTxType = 0x00
+)
+
+// The key types of the input maps.
+const (
+	inPrevOutType         = 0x00
+	inPartialSigType      = 0x02
+	inSighashType         = 0x03
+	inRedeemScriptType    = 0x04
+	inFinalSignScriptType = 0x07
+)
+
+// The key types of the output maps.
+const (
+	outRedeemScriptType = 0x00
+)
+
+var (
+	// ErrInvalidMagic is returned when a packet doesn't start with the
+	// magic bytes.
+	ErrInvalidMagic = errors.New("invalid psbt magic bytes")
+
+	// ErrInvalidPsbtFormat is returned when a packet is malformed.
+	ErrInvalidPsbtFormat = errors.New("invalid psbt serialization format")
+
+	// ErrDuplicateKey is returned when a key appears twice in a map.
+	ErrDuplicateKey = errors.New("duplicate key in psbt map")
+
+	// ErrInvalidUnsignedTx is returned when the transaction of a packet has
+	// signature scripts.
+	ErrInvalidUnsignedTx = errors.New("psbt transaction must be unsigned")
+
+	// ErrInputIndex is returned when an input index is out of range.
+	ErrInputIndex = errors.New("psbt input index out of range")
+
+	// ErrMismatchedTx is returned when packets of different transactions
+	// are combined.
+	ErrMismatchedTx = errors.New("psbt packets are for different transactions")
+
+	// ErrInvalidRedeemScript is returned when a redeem script doesn't hash
+	// to the script hash of the output spent by the input.
+	ErrInvalidRedeemScript = errors.New("redeem script doesn't match the " +
+		"spent output")
+
+	// ErrIncomplete is returned when a transaction is extracted from a
+	// packet with inputs which aren't finalized.
+	ErrIncomplete = errors.New("psbt is not finalized")
+)
+
+// Unknown is a key-value pair of a type this package doesn't know.  It is kept
+// so the packet can be passed on unchanged.
+type Unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+// PartialSig is a signature of an input along with the public key it verifies
+// against.
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// PInput holds what is known about an input of the transaction.
+type PInput struct {
+	// PrevOut is the output spent by the input.
+	PrevOut *types.TxOutput
+
+	// PartialSigs are the signatures of the input collected so far.
+	PartialSigs []*PartialSig
+
+	// SighashType is the signature hash type to sign the input with.  Zero
+	// means txscript.SigHashAll.
+	SighashType txscript.SigHashType
+
+	// RedeemScript is the script hashed by a pay-to-script-hash output.
+	RedeemScript []byte
+
+	// FinalSignScript is the signature script of the finalized input.
+	FinalSignScript []byte
+
+	Unknowns []*Unknown
+}
+
+// POutput holds what is known about an output of the transaction.
+type POutput struct {
+	// RedeemScript is the script hashed by a pay-to-script-hash output.
+	RedeemScript []byte
+
+	Unknowns []*Unknown
+}
+
+// Packet is a partially signed transaction.
+type Packet struct {
+	UnsignedTx *types.Transaction
+	Inputs     []PInput
+	Outputs    []POutput
+	Unknowns   []*Unknown
+}
+
+// New returns a packet for the passed transaction, which must be unsigned.
+func New(tx *types.Transaction) (*Packet, error) {
+	for _, txIn := range tx.TxIn {
+		if len(txIn.SignScript) != 0 {
+			return nil, ErrInvalidUnsignedTx
+		}
+	}
+	return &Packet{
+		UnsignedTx: tx,
+		Inputs:     make([]PInput, len(tx.TxIn)),
+		Outputs:    make([]POutput, len(tx.TxOut)),
+	}, nil
+}
+
+// readKeyValue reads a key-value pair of a map.  A nil key is returned for the
+// separator which ends the map.
+func readKeyValue(r io.Reader) ([]byte, []byte, error) {
+	key, err := s.ReadVarBytes(r, 0, maxPsbtSize, "psbt key")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(key) == 0 {
+		return nil, nil, nil
+	}
+	value, err := s.ReadVarBytes(r, 0, maxPsbtSize, "psbt value")
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
+
+// writeKeyValue writes a key-value pair of a map.
+func writeKeyValue(w io.Writer, key []byte, value []byte) error {
+	if err := s.WriteVarBytes(w, 0, key); err != nil {
+		return err
+	}
+	return s.WriteVarBytes(w, 0, value)
+}
+
+// Parse reads a packet from its binary serialization, or from its base64
+// encoding when b64 is set.
+func Parse(data []byte, b64 bool) (*Packet, error) {
+	if b64 {
+		decoded, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+	if len(data) > maxPsbtSize {
+		return nil, ErrInvalidPsbtFormat
+	}
+	if !bytes.HasPrefix(data, magic) {
+		return nil, ErrInvalidMagic
+	}
+	r := bytes.NewReader(data[len(magic):])
+
+	p := &Packet{}
+	seen := make(map[string]struct{})
+	for {
+		key, value, err := readKeyValue(r)
+		if err != nil {
+			return nil, ErrInvalidPsbtFormat
+		}
+		if key == nil {
+			break
+		}
+		if _, ok := seen[string(key)]; ok {
+			return nil, ErrDuplicateKey
+		}
+		seen[string(key)] = struct{}{}
+
+		switch {
+		case key[0] == globalUnsignedTxType && len(key) == 1:
+			tx := types.NewTransaction()
+			err := tx.Deserialize(bytes.NewReader(value))
+			if err != nil {
+				return nil, err
+			}
+			p.UnsignedTx = tx
+		default:
+			p.Unknowns = append(p.Unknowns, &Unknown{key, value})
+		}
+	}
+	if p.UnsignedTx == nil {
+		return nil, ErrInvalidPsbtFormat
+	}
+	for _, txIn := range p.UnsignedTx.TxIn {
+		if len(txIn.SignScript) != 0 {
+			return nil, ErrInvalidUnsignedTx
+		}
+	}
+
+	p.Inputs = make([]PInput, len(p.UnsignedTx.TxIn))
+	for i := range p.Inputs {
+		if err := p.Inputs[i].parse(r); err != nil {
+			return nil, err
+		}
+	}
+	p.Outputs = make([]POutput, len(p.UnsignedTx.TxOut))
+	for i := range p.Outputs {
+		if err := p.Outputs[i].parse(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.Len() != 0 {
+		return nil, ErrInvalidPsbtFormat
+	}
+	return p, nil
+}
+
+// parse reads the map of an input.
+func (pi *PInput) parse(r io.Reader) error {
+	seen := make(map[string]struct{})
+	for {
+		key, value, err := readKeyValue(r)
+		if err != nil {
+			return ErrInvalidPsbtFormat
+		}
+		if key == nil {
+			return nil
+		}
+		if _, ok := seen[string(key)]; ok {
+			return ErrDuplicateKey
+		}
+		seen[string(key)] = struct{}{}
+
+		switch {
+		case key[0] == inPrevOutType && len(key) == 1:
+			if len(value) < 8 {
+				return ErrInvalidPsbtFormat
+			}
+			pkScript, err := s.ReadVarBytes(bytes.NewReader(value[8:]),
+				0, maxPsbtSize, "pkscript")
+			if err != nil {
+				return ErrInvalidPsbtFormat
+			}
+			amount := binary.LittleEndian.Uint64(value[:8])
+			pi.PrevOut = types.NewTxOutput(amount, pkScript)
+		case key[0] == inPartialSigType:
+			pi.PartialSigs = append(pi.PartialSigs, &PartialSig{
+				PubKey:    key[1:],
+				Signature: value,
+			})
+		case key[0] == inSighashType && len(key) == 1:
+			if len(value) != 4 {
+				return ErrInvalidPsbtFormat
+			}
+			pi.SighashType = txscript.SigHashType(
+				binary.LittleEndian.Uint32(value))
+		case key[0] == inRedeemScriptType && len(key) == 1:
+			pi.RedeemScript = value
+		case key[0] == inFinalSignScriptType && len(key) == 1:
+			pi.FinalSignScript = value
+		default:
+			pi.Unknowns = append(pi.Unknowns, &Unknown{key, value})
+		}
+	}
+}
+
+// serialize writes the map of an input.
+func (pi *PInput) serialize(w io.Writer) error {
+	if pi.PrevOut != nil {
+		var value bytes.Buffer
+		var amount [8]byte
+		binary.LittleEndian.PutUint64(amount[:], pi.PrevOut.Amount)
+		value.Write(amount[:])
+		if err := s.WriteVarBytes(&value, 0, pi.PrevOut.PkScript); err != nil {
+			return err
+		}
+		err := writeKeyValue(w, []byte{inPrevOutType}, value.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	if pi.FinalSignScript == nil {
+		for _, sig := range pi.PartialSigs {
+			key := append([]byte{inPartialSigType}, sig.PubKey...)
+			if err := writeKeyValue(w, key, sig.Signature); err != nil {
+				return err
+			}
+		}
+		if pi.SighashType != 0 {
+			var value [4]byte
+			binary.LittleEndian.PutUint32(value[:], uint32(pi.SighashType))
+			err := writeKeyValue(w, []byte{inSighashType}, value[:])
+			if err != nil {
+				return err
+			}
+		}
+		if pi.RedeemScript != nil {
+			err := writeKeyValue(w, []byte{inRedeemScriptType},
+				pi.RedeemScript)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err := writeKeyValue(w, []byte{inFinalSignScriptType},
+			pi.FinalSignScript)
+		if err != nil {
+			return err
+		}
+	}
+	for _, u := range pi.Unknowns {
+		if err := writeKeyValue(w, u.Key, u.Value); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0x00})
+	return err
+}
+
+// parse reads the map of an output.
+func (po *POutput) parse(r io.Reader) error {
+	seen := make(map[string]struct{})
+	for {
+		key, value, err := readKeyValue(r)
+		if err != nil {
+			return ErrInvalidPsbtFormat
+		}
+		if key == nil {
+			return nil
+		}
+		if _, ok := seen[string(key)]; ok {
+			return ErrDuplicateKey
+		}
+		seen[string(key)] = struct{}{}
+
+		switch {
+		case key[0] == outRedeemScriptType && len(key) == 1:
+			po.RedeemScript = value
+		default:
+			po.Unknowns = append(po.Unknowns, &Unknown{key, value})
+		}
+	}
+}
+
+// serialize writes the map of an output.
+func (po *POutput) serialize(w io.Writer) error {
+	if po.RedeemScript != nil {
+		err := writeKeyValue(w, []byte{outRedeemScriptType}, po.RedeemScript)
+		if err != nil {
+			return err
+		}
+	}
+	for _, u := range po.Unknowns {
+		if err := writeKeyValue(w, u.Key, u.Value); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0x00})
+	return err
+}
+
+// Serialize returns the binary serialization of the packet.
+func (p *Packet) Serialize() ([]byte, error) {
+	var w bytes.Buffer
+	w.Write(magic)
+
+	serializedTx, err := p.UnsignedTx.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	err = writeKeyValue(&w, []byte{globalUnsignedTxType}, serializedTx)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range p.Unknowns {
+		if err := writeKeyValue(&w, u.Key, u.Value); err != nil {
+			return nil, err
+		}
+	}
+	w.WriteByte(0x00)
+
+	for i := range p.Inputs {
+		if err := p.Inputs[i].serialize(&w); err != nil {
+			return nil, err
+		}
+	}
+	for i := range p.Outputs {
+		if err := p.Outputs[i].serialize(&w); err != nil {
+			return nil, err
+		}
+	}
+	return w.Bytes(), nil
+}
+
+// B64Encode returns the base64 encoding of the serialized packet, which is
+// how packets are passed around.
+func (p *Packet) B64Encode() (string, error) {
+	serialized, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(serialized), nil
+}
+
+// AddInPrevOut records the output spent by the input at the passed index.
+func (p *Packet) AddInPrevOut(inIndex int, prevOut *types.TxOutput) error {
+	if inIndex < 0 || inIndex >= len(p.Inputs) {
+		return ErrInputIndex
+	}
+	p.Inputs[inIndex].PrevOut = prevOut
+	return nil
+}
+
+// AddInRedeemScript records the redeem script of the pay-to-script-hash
+// output spent by the input at the passed index.  The output must be known
+// already, so the script can be checked against its hash.
+func (p *Packet) AddInRedeemScript(inIndex int, redeemScript []byte) error {
+	if inIndex < 0 || inIndex >= len(p.Inputs) {
+		return ErrInputIndex
+	}
+	prevOut := p.Inputs[inIndex].PrevOut
+	if prevOut == nil {
+		return fmt.Errorf("the output spent by input %d is unknown", inIndex)
+	}
+	scriptHash, err := txscript.GetScriptHashFromP2SHScript(prevOut.PkScript)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(scriptHash, hash.Hash160(redeemScript)) {
+		return ErrInvalidRedeemScript
+	}
+	p.Inputs[inIndex].RedeemScript = redeemScript
+	return nil
+}
+
+// AddInSighashType sets the signature hash type to sign the input at the
+// passed index with.
+func (p *Packet) AddInSighashType(inIndex int, hashType txscript.SigHashType) error {
+	if inIndex < 0 || inIndex >= len(p.Inputs) {
+		return ErrInputIndex
+	}
+	p.Inputs[inIndex].SighashType = hashType
+	return nil
+}
+
+// IsComplete returns whether all the inputs of the packet are finalized.
+func (p *Packet) IsComplete() bool {
+	for i := range p.Inputs {
+		if p.Inputs[i].FinalSignScript == nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"testing"
+)
+
+// testKey returns a private key and its compressed public key derived from the
+// passed byte.
+func testKey(b byte) (ecc.PrivateKey, []byte) {
+	privKey, pubKey := ecc.Secp256k1.PrivKeyFromBytes(bytes.Repeat([]byte{b}, 32))
+	return privKey, pubKey.SerializeCompressed()
+}
+
+// testPacket returns a packet spending a pay-to-pubkey-hash output of the
+// first key and a 2-of-3 multisig pay-to-script-hash output of the other keys.
+func testPacket(t *testing.T, keys [][]byte) (*Packet, [][]byte) {
+	p2pkh, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(hash.Hash160(keys[0])).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redeemScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_2).
+		AddData(keys[1]).AddData(keys[2]).AddData(keys[3]).
+		AddOp(txscript.OP_3).AddOp(txscript.OP_CHECKMULTISIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2sh, err := txscript.PayToScriptHashScript(hash.Hash160(redeemScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := types.NewTransaction()
+	tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{1}, 0), nil))
+	tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.Hash{2}, 1), nil))
+	tx.AddTxOut(types.NewTxOutput(1500, p2pkh))
+	p, err := New(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddInPrevOut(0, types.NewTxOutput(1000, p2pkh)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AddInPrevOut(1, types.NewTxOutput(1000, p2sh)); err != nil {
+		t.Fatal(err)
+	}
+	return p, [][]byte{p2pkh, p2sh, redeemScript}
+}
+
+// roundTrip serializes and parses the passed packet.
+func roundTrip(t *testing.T, p *Packet) *Packet {
+	encoded, err := p.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse([]byte(encoded), true)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	serialized, _ := p.Serialize()
+	reserialized, _ := parsed.Serialize()
+	if !bytes.Equal(serialized, reserialized) {
+		t.Fatalf("packet changed by serialization round trip")
+	}
+	return parsed
+}
+
+func TestMultisigWorkflow(t *testing.T) {
+	var privKeys []ecc.PrivateKey
+	var pubKeys [][]byte
+	for i := byte(1); i <= 4; i++ {
+		privKey, pubKey := testKey(i)
+		privKeys = append(privKeys, privKey)
+		pubKeys = append(pubKeys, pubKey)
+	}
+	p, scripts := testPacket(t, pubKeys)
+
+	if err := p.AddInRedeemScript(0, scripts[2]); err != ErrInvalidRedeemScript {
+		t.Fatalf("AddInRedeemScript on p2pkh input: got %v", err)
+	}
+	if err := p.AddInRedeemScript(1, scripts[2]); err != nil {
+		t.Fatal(err)
+	}
+	p = roundTrip(t, p)
+
+	// Each party signs its own copy of the packet.
+	signers := []int{0, 1, 3}
+	packets := make([]*Packet, len(signers))
+	for i, signer := range signers {
+		packet := roundTrip(t, p)
+		signed, err := Sign(packet, privKeys[signer])
+		if err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		if signed != 1 {
+			t.Fatalf("key %d signed %d inputs, want 1", signer, signed)
+		}
+		packets[i] = roundTrip(t, packet)
+	}
+
+	// The multisig input lacks a signature until all packets are combined.
+	partial, err := Combine(packets[0], packets[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete, err := Finalize(partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete || partial.Inputs[0].FinalSignScript == nil {
+		t.Fatalf("unexpected finalization of partially signed packet")
+	}
+	if _, err := Extract(partial); err != ErrIncomplete {
+		t.Fatalf("Extract of incomplete packet: got %v", err)
+	}
+
+	combined, err := Combine(roundTrip(t, partial), packets[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	complete, err = Finalize(combined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete {
+		t.Fatalf("combined packet isn't complete")
+	}
+	tx, err := Extract(roundTrip(t, combined))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, pkScript := range scripts[:2] {
+		vm, err := txscript.NewEngine(pkScript, tx, i,
+			txscript.ScriptBip16, txscript.DefaultScriptVersion, nil)
+		if err != nil {
+			t.Fatalf("NewEngine input %d: %v", i, err)
+		}
+		if err := vm.Execute(); err != nil {
+			t.Fatalf("input %d doesn't verify: %v", i, err)
+		}
+	}
+}
+
+func TestCombineMismatchedTx(t *testing.T) {
+	_, pubKey := testKey(1)
+	p, _ := testPacket(t, [][]byte{pubKey, pubKey, pubKey, pubKey})
+	other, _ := testPacket(t, [][]byte{pubKey, pubKey, pubKey, pubKey})
+	other.UnsignedTx.LockTime = 1
+	if _, err := Combine(p, other); err != ErrMismatchedTx {
+		t.Fatalf("Combine: got %v, want %v", err, ErrMismatchedTx)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, pubKey := testKey(1)
+	p, _ := testPacket(t, [][]byte{pubKey, pubKey, pubKey, pubKey})
+	serialized, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(serialized[1:], false); err != ErrInvalidMagic {
+		t.Fatalf("Parse without magic: got %v", err)
+	}
+	if _, err := Parse(serialized[:len(serialized)-1], false); err != ErrInvalidPsbtFormat {
+		t.Fatalf("Parse of truncated packet: got %v", err)
+	}
+
+	p.UnsignedTx.TxIn[0].SignScript = []byte{0x51}
+	if _, err := New(p.UnsignedTx); err != ErrInvalidUnsignedTx {
+		t.Fatalf("New with signed input: got %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+)
+
+// signScript returns the script the input at the passed index is signed
+// against, which is the redeem script when it spends a pay-to-script-hash
+// output, and the class of that script.
+func (p *Packet) signScript(inIndex int) ([]byte, txscript.ScriptClass, error) {
+	pInput := &p.Inputs[inIndex]
+	if pInput.PrevOut == nil {
+		return nil, txscript.NonStandardTy, fmt.Errorf("the output spent "+
+			"by input %d is unknown", inIndex)
+	}
+	script := pInput.PrevOut.PkScript
+	class := txscript.GetScriptClass(txscript.DefaultScriptVersion, script)
+	if class == txscript.ScriptHashTy {
+		if pInput.RedeemScript == nil {
+			return nil, class, fmt.Errorf("the redeem script of input "+
+				"%d is unknown", inIndex)
+		}
+		script = pInput.RedeemScript
+		class = txscript.GetScriptClass(txscript.DefaultScriptVersion,
+			script)
+	}
+	return script, class, nil
+}
+
+// scriptPubKeys returns the public keys the passed script requires
+// signatures of, in the order of the script.  For a pay-to-pubkey-hash script
+// the passed public key is returned if it matches the hash.
+func scriptPubKeys(script []byte, class txscript.ScriptClass,
+	pubKey []byte) ([][]byte, error) {
+
+	pushes, err := txscript.PushedData(script)
+	if err != nil {
+		return nil, err
+	}
+	switch class {
+	case txscript.PubKeyHashTy:
+		if len(pushes) == 1 && bytes.Equal(pushes[0], hash.Hash160(pubKey)) {
+			return [][]byte{pubKey}, nil
+		}
+		return nil, nil
+	case txscript.PubKeyTy, txscript.MultiSigTy:
+		return pushes, nil
+	default:
+		return nil, fmt.Errorf("can't sign %v scripts", class)
+	}
+}
+
+// hasPubKey returns whether the passed list of public keys includes pubKey.
+func hasPubKey(pubKeys [][]byte, pubKey []byte) bool {
+	for _, k := range pubKeys {
+		if bytes.Equal(k, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// partialSig returns the signature of the input by the passed public key, or
+// nil when it hasn't signed.
+func (pi *PInput) partialSig(pubKey []byte) []byte {
+	for _, sig := range pi.PartialSigs {
+		if bytes.Equal(sig.PubKey, pubKey) {
+			return sig.Signature
+		}
+	}
+	return nil
+}
+
+// Sign adds the signatures of the passed private key to the inputs of the
+// packet which require it, and returns the number of inputs signed.  Inputs
+// which are finalized, or which spend outputs or redeem scripts unknown to the
+// packet, are skipped.
+func Sign(p *Packet, privKey ecc.PrivateKey) (int, error) {
+	pubX, pubY := privKey.Public()
+	pubKey := ecc.Secp256k1.NewPublicKey(pubX, pubY).SerializeCompressed()
+
+	signed := 0
+	for i := range p.Inputs {
+		pInput := &p.Inputs[i]
+		if pInput.FinalSignScript != nil || pInput.PrevOut == nil {
+			continue
+		}
+		// The inputs which can't be signed yet, or which spend scripts
+		// this package can't sign, are left to the other signers.
+		script, class, err := p.signScript(i)
+		if err != nil {
+			continue
+		}
+		pubKeys, err := scriptPubKeys(script, class, pubKey)
+		if err != nil {
+			continue
+		}
+		if !hasPubKey(pubKeys, pubKey) || pInput.partialSig(pubKey) != nil {
+			continue
+		}
+
+		hashType := pInput.SighashType
+		if hashType == 0 {
+			hashType = txscript.SigHashAll
+		}
+		sig, err := txscript.RawTxInSignature(p.UnsignedTx, i, script,
+			hashType, privKey)
+		if err != nil {
+			return signed, err
+		}
+		pInput.PartialSigs = append(pInput.PartialSigs, &PartialSig{
+			PubKey:    pubKey,
+			Signature: sig,
+		})
+		signed++
+	}
+	return signed, nil
+}
+
+// Combine merges the signatures and other data of the passed packets, which
+// must be for the same transaction, into a new packet.
+func Combine(packets ...*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, fmt.Errorf("no packets to combine")
+	}
+	txHash := packets[0].UnsignedTx.TxHash()
+	combined, err := New(packets[0].UnsignedTx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packets {
+		if p.UnsignedTx.TxHash() != txHash {
+			return nil, ErrMismatchedTx
+		}
+		combined.Unknowns = mergeUnknowns(combined.Unknowns, p.Unknowns)
+		for i := range p.Inputs {
+			combined.Inputs[i].merge(&p.Inputs[i])
+		}
+		for i := range p.Outputs {
+			out, pOut := &combined.Outputs[i], &p.Outputs[i]
+			if out.RedeemScript == nil {
+				out.RedeemScript = pOut.RedeemScript
+			}
+			out.Unknowns = mergeUnknowns(out.Unknowns, pOut.Unknowns)
+		}
+	}
+	return combined, nil
+}
+
+// merge adds the data of the passed input which is missing from the input.
+func (pi *PInput) merge(other *PInput) {
+	if pi.PrevOut == nil {
+		pi.PrevOut = other.PrevOut
+	}
+	if pi.SighashType == 0 {
+		pi.SighashType = other.SighashType
+	}
+	if pi.RedeemScript == nil {
+		pi.RedeemScript = other.RedeemScript
+	}
+	if pi.FinalSignScript == nil {
+		pi.FinalSignScript = other.FinalSignScript
+	}
+	for _, sig := range other.PartialSigs {
+		if pi.partialSig(sig.PubKey) == nil {
+			pi.PartialSigs = append(pi.PartialSigs, sig)
+		}
+	}
+	pi.Unknowns = mergeUnknowns(pi.Unknowns, other.Unknowns)
+}
+
+// mergeUnknowns adds the key-value pairs of others which unknowns doesn't have.
+func mergeUnknowns(unknowns, others []*Unknown) []*Unknown {
+	for _, other := range others {
+		found := false
+		for _, u := range unknowns {
+			if bytes.Equal(u.Key, other.Key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknowns = append(unknowns, other)
+		}
+	}
+	return unknowns
+}
+
+// Finalize builds the signature scripts of the inputs of the packet which
+// have all the signatures they require, and drops the data which is no longer
+// needed from them.  It returns whether all the inputs are finalized.
+func Finalize(p *Packet) (bool, error) {
+	for i := range p.Inputs {
+		pInput := &p.Inputs[i]
+		if pInput.FinalSignScript != nil || pInput.PrevOut == nil {
+			continue
+		}
+		signScript, err := p.finalSignScript(i)
+		if err != nil {
+			return false, err
+		}
+		if signScript == nil {
+			continue
+		}
+		pInput.FinalSignScript = signScript
+		pInput.PartialSigs = nil
+		pInput.SighashType = 0
+		pInput.RedeemScript = nil
+	}
+	return p.IsComplete(), nil
+}
+
+// finalSignScript returns the signature script of the input at the passed
+// index, or nil when it doesn't have the signatures it requires yet.
+func (p *Packet) finalSignScript(inIndex int) ([]byte, error) {
+	pInput := &p.Inputs[inIndex]
+	script, class, err := p.signScript(inIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := txscript.NewScriptBuilder()
+	switch class {
+	case txscript.PubKeyHashTy:
+		pushes, err := txscript.PushedData(script)
+		if err != nil {
+			return nil, err
+		}
+		signed := false
+		for _, sig := range pInput.PartialSigs {
+			if bytes.Equal(pushes[0], hash.Hash160(sig.PubKey)) {
+				builder.AddData(sig.Signature).AddData(sig.PubKey)
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return nil, nil
+		}
+
+	case txscript.PubKeyTy, txscript.MultiSigTy:
+		nRequired := 1
+		if class == txscript.MultiSigTy {
+			required, _, err := txscript.GetMultisigMandN(script)
+			if err != nil {
+				return nil, err
+			}
+			nRequired = int(required)
+		}
+		pubKeys, err := txscript.PushedData(script)
+		if err != nil {
+			return nil, err
+		}
+		// The signatures must be in the order of the public keys of
+		// the script.
+		signed := 0
+		for _, pubKey := range pubKeys {
+			sig := pInput.partialSig(pubKey)
+			if sig == nil {
+				continue
+			}
+			builder.AddData(sig)
+			signed++
+			if signed == nRequired {
+				break
+			}
+		}
+		if signed < nRequired {
+			return nil, nil
+		}
+
+	default:
+		return nil, fmt.Errorf("can't finalize input %d spending a %v "+
+			"script", inIndex, class)
+	}
+
+	if pInput.RedeemScript != nil {
+		builder.AddData(pInput.RedeemScript)
+	}
+	return builder.Script()
+}
+
+// Extract returns the signed transaction of a finalized packet.
+func Extract(p *Packet) (*types.Transaction, error) {
+	if !p.IsComplete() {
+		return nil, ErrIncomplete
+	}
+	serializedTx, err := p.UnsignedTx.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	tx := types.NewTransaction()
+	if err := tx.Deserialize(bytes.NewReader(serializedTx)); err != nil {
+		return nil, err
+	}
+	for i, txIn := range tx.TxIn {
+		txIn.SignScript = p.Inputs[i].FinalSignScript
+	}
+	return tx, nil
+}
@@ -2,7 +2,15 @@
 
 package types
 
-import "github.com/Qitmeer/qitmeer/common/hash"
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+)
+
+// maxReceiptEvents is the maximum number of events a block receipt can hold.
+const maxReceiptEvents = MaxBlockPayload / 8
 
 // block execution event, ex. validator change event
 type Event []byte
@@ -27,3 +35,49 @@ type BlockReceipt struct {
 	// fast warp sync by tracking validator set changes trustlessly
 	Events []Event
 }
+
+// Serialize encodes the block receipt to w using a format that is suitable
+// for long-term storage such as a database.
+func (r *BlockReceipt) Serialize(w io.Writer) error {
+	err := s.WriteElements(w, r.BlockNumber, &r.StorageRoot, &r.ChangeRoot)
+	if err != nil {
+		return err
+	}
+	err = s.WriteVarInt(w, 0, uint64(len(r.Events)))
+	if err != nil {
+		return err
+	}
+	for _, event := range r.Events {
+		err = s.WriteVarBytes(w, 0, event)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deserialize decodes a block receipt from r into the receiver using a format
+// that is suitable for long-term storage such as a database.
+func (r *BlockReceipt) Deserialize(rd io.Reader) error {
+	err := s.ReadElements(rd, &r.BlockNumber, &r.StorageRoot, &r.ChangeRoot)
+	if err != nil {
+		return err
+	}
+	count, err := s.ReadVarInt(rd, 0)
+	if err != nil {
+		return err
+	}
+	if count > maxReceiptEvents {
+		return fmt.Errorf("too many events in block receipt: %d, max %d",
+			count, maxReceiptEvents)
+	}
+	r.Events = make([]Event, 0, count)
+	for i := uint64(0); i < count; i++ {
+		event, err := s.ReadVarBytes(rd, 0, MaxBlockPayload, "event")
+		if err != nil {
+			return err
+		}
+		r.Events = append(r.Events, event)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"reflect"
+	"testing"
+)
+
+func Test_BlockReceiptSerialize(t *testing.T) {
+	receipt := BlockReceipt{
+		BlockNumber: 1024,
+		StorageRoot: hash.MustHexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421"),
+		Events:      []Event{{0x01, 0x02}, {0x03}},
+	}
+	var buf bytes.Buffer
+	err := receipt.Serialize(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result BlockReceipt
+	err = result.Deserialize(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(receipt, result) {
+		t.Fatalf("got %v, want %v", result, receipt)
+	}
+
+	// A receipt without events.
+	receipt.Events = []Event{}
+	buf.Reset()
+	if err := receipt.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := result.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(receipt, result) {
+		t.Fatalf("got %v, want %v", result, receipt)
+	}
+}
@@ -1036,6 +1036,11 @@ type TxDesc struct {
 
 	// FeePerKB is the fee the transaction pays in meer per 1000 bytes.
 	FeePerKB int64
+
+	// PackageFeePerKB is the fee per 1000 bytes the transaction pays along
+	// with its unconfirmed descendants in the source pool, when that is
+	// higher than its own fee per 1000 bytes.
+	PackageFeePerKB int64
 }
 
 // TxLoc holds locator data for the offset and length of where a transaction is
@@ -20,6 +20,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -59,6 +61,11 @@ const (
 	//  [4:8]  File offset (4 bytes)
 	//  [8:12] Block length (4 bytes)
 	blockLocSize = 12
+
+	// prunedBlockFileNum is the block file number stored in the block
+	// location of the blocks whose block file was deleted by pruning.  The
+	// block index keeps the header of these blocks.
+	prunedBlockFileNum = ^uint32(0)
 )
 
 var (
@@ -311,6 +318,31 @@ func (s *blockStore) deleteFile(fileNum uint32) error {
 	return nil
 }
 
+// closeFile closes the block file for the passed flat file number when it is
+// open for reads so the file can be deleted.
+func (s *blockStore) closeFile(fileNum uint32) {
+	s.obfMutex.Lock()
+	defer s.obfMutex.Unlock()
+
+	blockFile, ok := s.openBlockFiles[fileNum]
+	if !ok {
+		return
+	}
+	s.lruMutex.Lock()
+	if elem, ok := s.fileNumToLRUElem[fileNum]; ok {
+		s.openBlocksLRU.Remove(elem)
+		delete(s.fileNumToLRUElem, fileNum)
+	}
+	s.lruMutex.Unlock()
+
+	// Close the file under the write lock for the file in case any readers
+	// are currently reading from it.
+	blockFile.Lock()
+	_ = blockFile.file.Close()
+	blockFile.Unlock()
+	delete(s.openBlockFiles, fileNum)
+}
+
 // blockFile attempts to return an existing file handle for the passed flat file
 // number if it is already open as well as marking it as most recently used.  It
 // will also open the file when it's not already open subject to the rules
@@ -716,27 +748,38 @@ func (s *blockStore) handleRollback(oldBlockFileNum, oldBlockOffset uint32) {
 }
 
 // scanBlockFiles searches the database directory for all flat block files to
-// find the end of the most recent file.  This position is considered the
-// current write cursor which is also stored in the metadata.  Thus, it is used
-// to detect unexpected shutdowns in the middle of writes so the block files
-// can be reconciled.
-func scanBlockFiles(dbPath string) (int, uint32) {
-	lastFile := -1
-	fileLen := uint32(0)
-	for i := 0; ; i++ {
-		filePath := blockFilePath(dbPath, uint32(i))
-		st, err := os.Stat(filePath)
-		if err != nil {
-			break
+// find the first file and the end of the most recent file.  The first file is
+// only non-zero when the oldest files were deleted by pruning.  The end of the
+// most recent file is considered the current write cursor which is also stored
+// in the metadata.  Thus, it is used to detect unexpected shutdowns in the
+// middle of writes so the block files can be reconciled.
+func scanBlockFiles(dbPath string) (int, int, uint32) {
+	firstFile, lastFile := -1, -1
+	files, _ := filepath.Glob(filepath.Join(dbPath, "*.fdb"))
+	for _, file := range files {
+		fileNum, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(file), ".fdb"))
+		if err != nil || fileNum < 0 {
+			continue
+		}
+		if firstFile == -1 || fileNum < firstFile {
+			firstFile = fileNum
 		}
-		lastFile = i
+		if fileNum > lastFile {
+			lastFile = fileNum
+		}
+	}
 
-		fileLen = uint32(st.Size())
+	fileLen := uint32(0)
+	if lastFile != -1 {
+		st, err := os.Stat(blockFilePath(dbPath, uint32(lastFile)))
+		if err == nil {
+			fileLen = uint32(st.Size())
+		}
 	}
 
-	dblog.Trace("Scan found latest block file ", "lastFile", lastFile,
-		"length", fileLen)
-	return lastFile, fileLen
+	dblog.Trace("Scan found latest block file ", "firstFile", firstFile,
+		"lastFile", lastFile, "length", fileLen)
+	return firstFile, lastFile, fileLen
 }
 
 // newBlockStore returns a new block store with the current block file number
@@ -745,7 +788,7 @@ func newBlockStore(basePath string, network protocol.Network) *blockStore {
 	// Look for the end of the latest block to file to determine what the
 	// write cursor position is from the viewpoing of the block files on
 	// disk.
-	fileNum, fileOff := scanBlockFiles(basePath)
+	_, fileNum, fileOff := scanBlockFiles(basePath)
 	if fileNum == -1 {
 		fileNum = 0
 		fileOff = 0
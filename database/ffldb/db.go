@@ -1134,7 +1134,12 @@ func (tx *transaction) hasBlock(hash *hash.Hash) bool {
 		return true
 	}
 
-	return tx.hasKey(bucketizedKey(blockIdxBucketID, hash[:]))
+	// The blocks that were pruned only keep their header.
+	blockRow := tx.fetchKey(bucketizedKey(blockIdxBucketID, hash[:]))
+	if blockRow == nil {
+		return false
+	}
+	return deserializeBlockLoc(blockRow).blockFileNum != prunedBlockFileNum
 }
 
 // StoreBlock stores the provided block into the database.  There are no checks
@@ -1240,6 +1245,23 @@ func (tx *transaction) fetchBlockRow(hash *hash.Hash) ([]byte, error) {
 	return blockRow, nil
 }
 
+// fetchBlockLocation fetches the location of the block data for the provided
+// hash.  It will return ErrBlockNotFound if there is no entry or the block data
+// was pruned.
+func (tx *transaction) fetchBlockLocation(hash *hash.Hash) (blockLocation, error) {
+	blockRow, err := tx.fetchBlockRow(hash)
+	if err != nil {
+		return blockLocation{}, err
+	}
+	location := deserializeBlockLoc(blockRow)
+	if location.blockFileNum == prunedBlockFileNum {
+		str := fmt.Sprintf("block %s was pruned", hash)
+		return blockLocation{}, makeDbErr(database.ErrBlockNotFound, str, nil)
+	}
+
+	return location, nil
+}
+
 // FetchBlockHeader returns the raw serialized bytes for the block header
 // identified by the given hash.  The raw bytes are in the format returned by
 // Serialize on a wire.BlockHeader.
@@ -1364,11 +1386,10 @@ func (tx *transaction) FetchBlock(hash *hash.Hash) ([]byte, error) {
 	}
 
 	// Lookup the location of the block in the files from the block index.
-	blockRow, err := tx.fetchBlockRow(hash)
+	location, err := tx.fetchBlockLocation(hash)
 	if err != nil {
 		return nil, err
 	}
-	location := deserializeBlockLoc(blockRow)
 
 	// Read the block from the appropriate location.  The function also
 	// performs a checksum over the data to detect data corruption.
@@ -1495,11 +1516,10 @@ func (tx *transaction) FetchBlockRegion(region *database.BlockRegion) ([]byte, e
 	}
 
 	// Lookup the location of the block in the files from the block index.
-	blockRow, err := tx.fetchBlockRow(region.Hash)
+	location, err := tx.fetchBlockLocation(region.Hash)
 	if err != nil {
 		return nil, err
 	}
-	location := deserializeBlockLoc(blockRow)
 
 	// Ensure the region is within the bounds of the block.
 	endOffset := region.Offset + region.Len
@@ -1593,11 +1613,10 @@ func (tx *transaction) FetchBlockRegions(regions []database.BlockRegion) ([][]by
 
 		// Lookup the location of the block in the files from the block
 		// index.
-		blockRow, err := tx.fetchBlockRow(region.Hash)
+		location, err := tx.fetchBlockLocation(region.Hash)
 		if err != nil {
 			return nil, err
 		}
-		location := deserializeBlockLoc(blockRow)
 
 		// Ensure the region is within the bounds of the block.
 		endOffset := region.Offset + region.Len
@@ -1629,6 +1648,137 @@ func (tx *transaction) FetchBlockRegions(regions []database.BlockRegion) ([][]by
 	return blockRegions, nil
 }
 
+// PruneBlocks deletes the oldest block files until the size of the remaining
+// block files is no more than the target size in bytes.  The most recent block
+// file is never deleted, and the deletion stops at the first file holding a
+// block which canPrune refuses.  The block index rows of the blocks in the
+// deleted files are marked as pruned and only keep the block headers.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrTxNotWritable if attempted against a read-only transaction
+//   - ErrTxClosed if the transaction has already been closed
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) PruneBlocks(targetSize uint64, canPrune func(*hash.Hash) bool) ([]hash.Hash, error) {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return nil, err
+	}
+
+	// Ensure the transaction is writable.
+	if !tx.writable {
+		str := "prune blocks requires a writable database transaction"
+		return nil, makeDbErr(database.ErrTxNotWritable, str, nil)
+	}
+
+	maxSize := uint64(tx.db.store.maxBlockFileSize)
+	if targetSize < maxSize {
+		str := fmt.Sprintf("prune target size %d is less than the max "+
+			"size %d of a single block file", targetSize, maxSize)
+		return nil, makeDbErr(database.ErrInvalid, str, nil)
+	}
+
+	// Nothing to do when there is at most one block file.  The files
+	// before the last one are assumed to be of max size.
+	first, last, lastFileSize := scanBlockFiles(tx.db.store.basePath)
+	if first == last {
+		return nil, nil
+	}
+	totalSize := uint64(lastFileSize) + maxSize*uint64(last-first)
+	if totalSize <= targetSize {
+		return nil, nil
+	}
+
+	// Group the blocks of the files which could be deleted by file.  The
+	// files are written in the order the blocks arrived, which is not the
+	// order of the blocks in the DAG, so every block of a file is checked
+	// before the file is deleted.
+	fileBlocks := make(map[uint32][]hash.Hash)
+	fileRows := make(map[uint32][][]byte)
+	cursor := tx.blockIdxBucket.Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		blockRow := cursor.Value()
+		location := deserializeBlockLoc(blockRow)
+		if location.blockFileNum == prunedBlockFileNum ||
+			location.blockFileNum >= uint32(last) {
+			continue
+		}
+		var blockHash hash.Hash
+		copy(blockHash[:], cursor.Key())
+		fileNum := location.blockFileNum
+		fileBlocks[fileNum] = append(fileBlocks[fileNum], blockHash)
+		prunedLoc := blockLocation{blockFileNum: prunedBlockFileNum}
+		fileRows[fileNum] = append(fileRows[fileNum], serializeBlockRow(
+			prunedLoc, blockRow[blockLocSize:blockLocSize+blockHdrSize]))
+	}
+
+	// Delete the oldest files, but never the last one which is currently
+	// written to.
+	var prunedHashes []hash.Hash
+	var prunedRows [][]byte
+	deletedFiles := 0
+	for i := uint32(first); i < uint32(last); i++ {
+		prunable := true
+		for j := range fileBlocks[i] {
+			if !canPrune(&fileBlocks[i][j]) {
+				prunable = false
+				break
+			}
+		}
+		if !prunable {
+			break
+		}
+
+		tx.db.store.closeFile(i)
+		if err := tx.db.store.deleteFileFunc(i); err != nil {
+			return nil, err
+		}
+		deletedFiles++
+		prunedHashes = append(prunedHashes, fileBlocks[i]...)
+		prunedRows = append(prunedRows, fileRows[i]...)
+
+		totalSize -= maxSize
+		if totalSize <= targetSize {
+			break
+		}
+	}
+
+	// Mark the block index rows of the blocks in the deleted files as
+	// pruned.  The rows are updated after the iteration since the cursor
+	// shouldn't be used while the bucket is modified.
+	for i := range prunedHashes {
+		err := tx.blockIdxBucket.Put(prunedHashes[i][:], prunedRows[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if deletedFiles > 0 {
+		dblog.Info("Pruned block files", "files", deletedFiles,
+			"blocks", len(prunedHashes), "size", totalSize)
+	}
+	return prunedHashes, nil
+}
+
+// BeenPruned returns whether or not any block files were deleted by
+// PruneBlocks.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrTxClosed if the transaction has already been closed
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) BeenPruned() (bool, error) {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return false, err
+	}
+
+	// The block files are numbered from zero, so the first file only
+	// doesn't exist when it was pruned.
+	first, _, _ := scanBlockFiles(tx.db.store.basePath)
+	return first > 0, nil
+}
+
 // close marks the transaction closed then releases any pending data, the
 // underlying snapshot, the transaction read lock, and the write lock when the
 // transaction is writable.
@@ -391,6 +391,32 @@ type Tx interface {
 	// implementations.
 	FetchBlockRegions(regions []BlockRegion) ([][]byte, error)
 
+	// PruneBlocks deletes the oldest block files until the size of the
+	// remaining block files is no more than the provided target size in
+	// bytes.  The most recent block file is never deleted, and no file
+	// holding a block which canPrune refuses is deleted.  The headers of
+	// the deleted blocks are kept, so FetchBlockHeader(s) still returns
+	// them while HasBlock and the functions which fetch the block data
+	// treat the blocks as not existing.  The hashes of the deleted blocks
+	// are returned.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrTxNotWritable if attempted against a read-only transaction
+	//   - ErrTxClosed if the transaction has already been closed
+	//
+	// NOTE: The block files are deleted immediately, so the deletion is
+	// not undone by rolling back the transaction.
+	PruneBlocks(targetSize uint64, canPrune func(*hash.Hash) bool) ([]hash.Hash, error)
+
+	// BeenPruned returns whether or not any block files were deleted by
+	// PruneBlocks.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrTxClosed if the transaction has already been closed
+	BeenPruned() (bool, error)
+
 	// ******************************************************************
 	// Methods related to both atomic metadata storage and block storage.
 	// ******************************************************************
@@ -5,12 +5,13 @@ import (
 	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
-	"github.com/Qitmeer/qitmeer/node/notify"
 	"github.com/Qitmeer/qitmeer/p2p/peerserver"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/rpc"
 	"github.com/Qitmeer/qitmeer/services/acct"
 	"github.com/Qitmeer/qitmeer/services/blkmgr"
+	"github.com/Qitmeer/qitmeer/services/blockstats"
+	"github.com/Qitmeer/qitmeer/services/cf"
 	"github.com/Qitmeer/qitmeer/services/common"
 	"github.com/Qitmeer/qitmeer/services/index"
 	"github.com/Qitmeer/qitmeer/services/mempool"
@@ -25,11 +26,15 @@ type QitmeerFull struct {
 	// under node
 	node *Node
 	// msg notifier
-	nfManager notify.Notify
+	nfManager *notifymgr.NotifyMgr
 	// database
 	db database.DB
 	// account/wallet service
 	acctmanager *acct.AccountManager
+	// committed filter service
+	cfService *cf.CFService
+	// block stats service
+	statsService *blockstats.BlockStatsService
 	// block manager handles all incoming blocks.
 	blockManager *blkmgr.BlockManager
 	// tx manager
@@ -37,6 +42,8 @@ type QitmeerFull struct {
 
 	// miner service
 	cpuMiner *miner.CPUMiner
+	// stratum server of the external miners
+	stratumServer *miner.StratumServer
 
 	// clock time service
 	timeSource blockchain.MedianTimeSource
@@ -52,20 +59,30 @@ func (qm *QitmeerFull) Start(server *peerserver.PeerServer) error {
 		qm.cpuMiner.Start()
 	}
 
+	qm.nfManager.Start()
 	qm.blockManager.Start()
 	qm.txManager.Start()
-	return nil
+	if qm.stratumServer != nil {
+		qm.stratumServer.Start()
+	}
+	return qm.acctmanager.Start()
 }
 
 func (qm *QitmeerFull) Stop() error {
 	log.Debug("Stopping Qitmeer full node service")
 
+	if qm.stratumServer != nil {
+		qm.stratumServer.Stop()
+	}
+
 	log.Info("try stop bm")
 
 	qm.blockManager.Stop()
 	qm.blockManager.WaitForStop()
 
 	qm.txManager.Stop()
+	qm.acctmanager.Stop()
+	qm.nfManager.Stop()
 
 	log.Info("try stop cpu miner")
 	// Stop the CPU miner if needed.
@@ -81,22 +98,22 @@ func (qm *QitmeerFull) APIs() []rpc.API {
 	apis = append(apis, qm.cpuMiner.APIs()...)
 	apis = append(apis, qm.blockManager.API())
 	apis = append(apis, qm.txManager.APIs()...)
+	if qm.cfService != nil {
+		apis = append(apis, qm.cfService.APIs()...)
+	}
+	if qm.statsService != nil {
+		apis = append(apis, qm.statsService.APIs()...)
+	}
+	apis = append(apis, qm.nfManager.APIs()...)
 	apis = append(apis, qm.API())
 	return apis
 }
 func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
-
-	// account manager
-	acctmgr, err := acct.New()
-	if err != nil {
-		return nil, err
-	}
 	qm := QitmeerFull{
-		node:        node,
-		db:          node.DB,
-		acctmanager: acctmgr,
-		timeSource:  blockchain.NewMedianTime(),
-		sigCache:    txscript.NewSigCache(node.Config.SigCacheMaxSize),
+		node:       node,
+		db:         node.DB,
+		timeSource: blockchain.NewMedianTime(),
+		sigCache:   txscript.NewSigCache(node.Config.SigCacheMaxSize),
 	}
 	// Create the transaction and address indexes if needed.
 	var indexes []index.Indexer
@@ -104,6 +121,7 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
 
 	var txIndex *index.TxIndex
 	var addrIndex *index.AddrIndex
+	var addrUtxoIndex *index.AddrUtxoIndex
 	if cfg.TxIndex || cfg.AddrIndex {
 		if !cfg.TxIndex {
 			log.Info("Transaction index enabled because it " +
@@ -118,7 +136,27 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
 	if cfg.AddrIndex {
 		log.Info("Address index is enabled")
 		addrIndex = index.NewAddrIndex(qm.db, node.Params)
-		indexes = append(indexes, addrIndex)
+		addrUtxoIndex = index.NewAddrUtxoIndex(qm.db, node.Params)
+		indexes = append(indexes, addrIndex, addrUtxoIndex)
+	}
+	var cfIndex *index.CfIndex
+	if cfg.CFIndex {
+		log.Info("Committed filter index is enabled")
+		cfIndex = index.NewCfIndex(qm.db)
+		indexes = append(indexes, cfIndex)
+		qm.cfService = cf.New(cfIndex)
+	}
+	var spendIndex *index.SpendIndex
+	if cfg.SpendIndex {
+		log.Info("Spend index is enabled")
+		spendIndex = index.NewSpendIndex(qm.db)
+		indexes = append(indexes, spendIndex)
+	}
+	var statsIndex *index.BlockStatsIndex
+	if cfg.StatsIndex {
+		log.Info("Block stats index is enabled")
+		statsIndex = index.NewBlockStatsIndex(qm.db)
+		indexes = append(indexes, statsIndex)
 	}
 	// index-manager
 	var indexManager blockchain.IndexManager
@@ -126,7 +164,7 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
 		indexManager = index.NewManager(qm.db, indexes, node.Params)
 	}
 
-	qm.nfManager = &notifymgr.NotifyMgr{Server: node.peerServer, RpcServer: node.rpcServer}
+	qm.nfManager = notifymgr.New(node.peerServer, node.rpcServer, node.Params)
 
 	// block-manager
 	bm, err := blkmgr.NewBlockManager(qm.nfManager, indexManager, node.DB, qm.timeSource, qm.sigCache, node.Config, node.Params,
@@ -135,18 +173,31 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
 		return nil, err
 	}
 	qm.blockManager = bm
+	bm.Subscribe(qm.nfManager.HandleChainNotification)
+	if statsIndex != nil {
+		qm.statsService = blockstats.New(statsIndex, bm.GetChain())
+	}
 
 	// txmanager
-	tm, err := tx.NewTxManager(bm, txIndex, addrIndex, cfg, qm.nfManager, qm.sigCache, node.DB)
+	tm, err := tx.NewTxManager(bm, txIndex, addrIndex, addrUtxoIndex, spendIndex, cfg, qm.nfManager, qm.sigCache, node.DB)
 	if err != nil {
 		return nil, err
 	}
 	qm.txManager = tm
 	bm.GetChain().SetTxManager(tm)
+
+	// account manager
+	acctmgr, err := acct.New(bm, cfg, node.Params, qm.nfManager)
+	if err != nil {
+		return nil, err
+	}
+	qm.acctmanager = acctmgr
+
 	// prepare peerServer
 	node.peerServer.BlockManager = bm
 	node.peerServer.TimeSource = qm.timeSource
 	node.peerServer.TxMemPool = qm.txManager.MemPool().(*mempool.TxPool)
+	node.peerServer.CfIndex = cfIndex
 
 	// Cpu Miner
 	// Create the mining policy based on the configuration options.
@@ -168,6 +219,17 @@ func newQitmeerFullNode(node *Node) (*QitmeerFull, error) {
 
 	qm.cpuMiner = miner.NewCPUMiner(cfg, node.Params, &policy, qm.sigCache,
 		qm.txManager.MemPool().(*mempool.TxPool), qm.timeSource, qm.blockManager, defaultNumWorkers)
+	bm.Subscribe(qm.cpuMiner.HandleChainNotification)
+	qm.nfManager.GbtNotifier = qm.cpuMiner
+
+	if len(cfg.StratumListeners) > 0 {
+		qm.stratumServer, err = miner.NewStratumServer(qm.cpuMiner,
+			cfg.StratumListeners, cfg.StratumDiff, cfg.StratumPass)
+		if err != nil {
+			return nil, err
+		}
+		bm.Subscribe(qm.stratumServer.HandleChainNotification)
+	}
 
 	return &qm, nil
 }
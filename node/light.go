@@ -3,9 +3,11 @@ package node
 
 import (
 	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/database"
 	"github.com/Qitmeer/qitmeer/p2p/peerserver"
 	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/light"
 )
 
 // QitmeerLight implements the qitmeer light node service.
@@ -13,26 +15,47 @@ type QitmeerLight struct {
 	// database
 	db     database.DB
 	config *config.Config
+
+	// header chain of the block DAG
+	headerChain *light.HeaderChain
+	// sync manager downloads the headers from the peers
+	syncManager *light.SyncManager
+
+	// clock time service
+	timeSource blockchain.MedianTimeSource
 }
 
 func (light *QitmeerLight) Start(server *peerserver.PeerServer) error {
 	log.Debug("Starting Qitmeer light node service")
+	light.syncManager.Start()
 	return nil
 }
 
 func (light *QitmeerLight) Stop() error {
 	log.Debug("Stopping Qitmeer light node service")
-	return nil
+	return light.syncManager.Stop()
 }
 
 func (light *QitmeerLight) APIs() []rpc.API {
-	return []rpc.API{}
+	return []rpc.API{light.syncManager.API()}
 }
 
 func newQitmeerLight(n *Node) (*QitmeerLight, error) {
-	light := QitmeerLight{
-		config: n.Config,
-		db:     n.DB,
+	ql := QitmeerLight{
+		config:     n.Config,
+		db:         n.DB,
+		timeSource: blockchain.NewMedianTime(),
 	}
-	return &light, nil
+	hc, err := light.NewHeaderChain(n.DB, n.Config.DAGType, ql.timeSource, n.Params)
+	if err != nil {
+		return nil, err
+	}
+	ql.headerChain = hc
+	ql.syncManager = light.NewSyncManager(hc, n.Config.MaxPeers)
+
+	// prepare peerServer
+	n.peerServer.LightManager = ql.syncManager
+	n.peerServer.TimeSource = ql.timeSource
+
+	return &ql, nil
 }
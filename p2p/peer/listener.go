@@ -90,13 +90,38 @@ type MessageListeners struct {
 	// OnGraphState
 	OnGraphState func(p *Peer, msg *message.MsgGraphState)
 
-	/*
-		// OnSendHeaders is invoked when a peer receives a sendheaders message.
-		OnSendHeaders func(p *Peer, msg *message.MsgSendHeaders)
+	// OnGetCFilter is invoked when a peer receives a getcfilter wire
+	// message.
+	OnGetCFilter func(p *Peer, msg *message.MsgGetCFilter)
+
+	// OnGetCFHeaders is invoked when a peer receives a getcfheaders
+	// wire message.
+	OnGetCFHeaders func(p *Peer, msg *message.MsgGetCFHeaders)
+
+	// OnFilterAdd is invoked when a peer receives a filteradd wire message.
+	OnFilterAdd func(p *Peer, msg *message.MsgFilterAdd)
+
+	// OnFilterClear is invoked when a peer receives a filterclear wire
+	// message.
+	OnFilterClear func(p *Peer, msg *message.MsgFilterClear)
+
+	// OnFilterLoad is invoked when a peer receives a filterload wire
+	// message.
+	OnFilterLoad func(p *Peer, msg *message.MsgFilterLoad)
+
+	// OnHeaders is invoked when a peer receives a headers wire message.
+	OnHeaders func(p *Peer, msg *message.MsgHeaders)
 
-		// OnMemPool is invoked when a peer receives a mempool wire message.
-		OnMemPool func(p *Peer, msg *message.MsgMemPool)
+	// OnMemPool is invoked when a peer receives a mempool wire message.
+	OnMemPool func(p *Peer, msg *message.MsgMemPool)
 
+	// OnFeeFilter is invoked when a peer receives a feefilter wire message.
+	OnFeeFilter func(p *Peer, msg *message.MsgFeeFilter)
+
+	// OnSendHeaders is invoked when a peer receives a sendheaders message.
+	OnSendHeaders func(p *Peer, msg *message.MsgSendHeaders)
+
+	/*
 		// OnCFilter is invoked when a peer receives a cfilter wire message.
 		OnCFilter func(p *Peer, msg *message.MsgCFilter)
 
@@ -107,22 +132,8 @@ type MessageListeners struct {
 		// OnCFTypes is invoked when a peer receives a cftypes wire message.
 		OnCFTypes func(p *Peer, msg *message.MsgCFTypes)
 
-		// OnHeaders is invoked when a peer receives a headers wire message.
-		OnHeaders func(p *Peer, msg *message.MsgHeaders)
-
-		// OnGetCFilter is invoked when a peer receives a getcfilter wire
-		// message.
-		OnGetCFilter func(p *Peer, msg *message.MsgGetCFilter)
-
-		// OnGetCFHeaders is invoked when a peer receives a getcfheaders
-		// wire message.
-		OnGetCFHeaders func(p *Peer, msg *message.MsgGetCFHeaders)
-
 		// OnGetCFTypes is invoked when a peer receives a getcftypes wire
 		// message.
 		OnGetCFTypes func(p *Peer, msg *message.MsgGetCFTypes)
-
-		// OnFeeFilter is invoked when a peer receives a feefilter wire message.
-		OnFeeFilter func(p *Peer, msg *message.MsgFeeFilter)
 	*/
 }
@@ -152,27 +152,56 @@ out:
 			if p.cfg.Listeners.OnGetHeaders != nil {
 				p.cfg.Listeners.OnGetHeaders(p, msg)
 			}
-		/*
-			case *message.MsgMemPool:
-				if p.cfg.Listeners.OnMemPool != nil {
-					p.cfg.Listeners.OnMemPool(p, msg)
-				}
 
-			case *message.MsgHeaders:
-				if p.cfg.Listeners.OnHeaders != nil {
-					p.cfg.Listeners.OnHeaders(p, msg)
-				}
+		case *message.MsgGetCFilter:
+			if p.cfg.Listeners.OnGetCFilter != nil {
+				p.cfg.Listeners.OnGetCFilter(p, msg)
+			}
 
-			case *message.MsgGetCFilter:
-				if p.cfg.Listeners.OnGetCFilter != nil {
-					p.cfg.Listeners.OnGetCFilter(p, msg)
-				}
+		case *message.MsgGetCFHeaders:
+			if p.cfg.Listeners.OnGetCFHeaders != nil {
+				p.cfg.Listeners.OnGetCFHeaders(p, msg)
+			}
 
-			case *message.MsgGetCFHeaders:
-				if p.cfg.Listeners.OnGetCFHeaders != nil {
-					p.cfg.Listeners.OnGetCFHeaders(p, msg)
-				}
+		case *message.MsgFilterAdd:
+			if p.cfg.Listeners.OnFilterAdd != nil {
+				p.cfg.Listeners.OnFilterAdd(p, msg)
+			}
+
+		case *message.MsgFilterClear:
+			if p.cfg.Listeners.OnFilterClear != nil {
+				p.cfg.Listeners.OnFilterClear(p, msg)
+			}
+
+		case *message.MsgFilterLoad:
+			if p.cfg.Listeners.OnFilterLoad != nil {
+				p.cfg.Listeners.OnFilterLoad(p, msg)
+			}
+
+		case *message.MsgHeaders:
+			if p.cfg.Listeners.OnHeaders != nil {
+				p.cfg.Listeners.OnHeaders(p, msg)
+			}
 
+		case *message.MsgMemPool:
+			if p.cfg.Listeners.OnMemPool != nil {
+				p.cfg.Listeners.OnMemPool(p, msg)
+			}
+
+		case *message.MsgFeeFilter:
+			if p.cfg.Listeners.OnFeeFilter != nil {
+				p.cfg.Listeners.OnFeeFilter(p, msg)
+			}
+
+		case *message.MsgSendHeaders:
+			p.flagsMtx.Lock()
+			p.sendHeadersPreferred = true
+			p.flagsMtx.Unlock()
+
+			if p.cfg.Listeners.OnSendHeaders != nil {
+				p.cfg.Listeners.OnSendHeaders(p, msg)
+			}
+		/*
 			case *message.MsgGetCFTypes:
 				if p.cfg.Listeners.OnGetCFTypes != nil {
 					p.cfg.Listeners.OnGetCFTypes(p, msg)
@@ -192,20 +221,6 @@ out:
 				if p.cfg.Listeners.OnCFTypes != nil {
 					p.cfg.Listeners.OnCFTypes(p, msg)
 				}
-
-			case *message.MsgFeeFilter:
-				if p.cfg.Listeners.OnFeeFilter != nil {
-					p.cfg.Listeners.OnFeeFilter(p, msg)
-				}
-
-			case *message.MsgSendHeaders:
-				p.flagsMtx.Lock()
-				p.sendHeadersPreferred = true
-				p.flagsMtx.Unlock()
-
-				if p.cfg.Listeners.OnSendHeaders != nil {
-					p.cfg.Listeners.OnSendHeaders(p, msg)
-				}
 		*/
 		case *message.MsgReject:
 			if p.cfg.Listeners.OnReject != nil {
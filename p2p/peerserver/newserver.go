@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/Qitmeer/qitmeer/common/network"
 	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/protocol"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/p2p/addmgr"
@@ -17,6 +18,17 @@ import (
 func NewPeerServer(cfg *config.Config, chainParams *params.Params) (*PeerServer, error) {
 
 	services := defaultServices
+	if cfg.NoPeerBloomFilters {
+		services &^= protocol.Bloom
+	}
+	if !cfg.CFIndex {
+		services &^= protocol.CF
+	}
+	if cfg.LightNode {
+		// A light node keeps no blocks, so it can't serve any of the
+		// services of a full node.
+		services = protocol.Light
+	}
 
 	s := PeerServer{
 		services:    services,
@@ -15,6 +15,7 @@ import (
 	"github.com/Qitmeer/qitmeer/p2p/addmgr"
 	"github.com/Qitmeer/qitmeer/p2p/peer"
 	"github.com/satori/go.uuid"
+	"sync/atomic"
 	"time"
 )
 
@@ -70,7 +71,7 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *message.MsgVersion) *message.
 		// Advertise the local address when the server accepts incoming
 		// connections and it believes itself to be close to the best
 		// known tip.
-		if !sp.server.cfg.DisableListen && sp.server.BlockManager.IsCurrent() {
+		if !sp.server.cfg.DisableListen && sp.server.isCurrent() {
 			// Get address that best matches.
 			lna := addrManager.GetBestLocalAddress(remoteAddr)
 			if addmgr.IsRoutable(lna) {
@@ -93,13 +94,35 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *message.MsgVersion) *message.
 	// Choose whether or not to relay transactions.
 	sp.setDisableRelayTx(msg.DisableRelayTx)
 
+	// Ask the peer to announce new blocks by their headers, which carry
+	// the parents needed to connect them to the block DAG.
+	if p.ProtocolVersion() >= protocol.SendHeadersVersion {
+		p.QueueMessage(message.NewMsgSendHeaders(), nil)
+	}
+
+	// Advertise the minimum fee of the transactions to be relayed to us and
+	// request the transactions in the memory pool of outbound peers, so
+	// the pool is filled without waiting for new transactions.  The minimum
+	// fee is advertised again by the peer handler when it changes.
+	if !sp.server.cfg.BlocksOnly && sp.server.LightManager == nil &&
+		p.ProtocolVersion() >= protocol.FeeFilterVersion {
+		sp.pushFeeFilter()
+		if !isInbound {
+			p.QueueMessage(message.NewMsgMemPool(), nil)
+		}
+	}
+
 	// Add the remote peer time as a sample for creating an offset against
 	// the local clock to keep the network time in sync.
 	sp.server.TimeSource.AddTimeSample(p.Addr(), msg.Timestamp)
 
 	// Signal the block manager this peer is a new sync candidate.
-	log.Trace("OnVersion -> NewPeer send to blkMgr msgChan", "peer", sp.syncPeer)
-	sp.server.BlockManager.NewPeer(sp.syncPeer)
+	if sp.server.LightManager != nil {
+		sp.server.LightManager.NewPeer(sp.syncPeer)
+	} else {
+		log.Trace("OnVersion -> NewPeer send to blkMgr msgChan", "peer", sp.syncPeer)
+		sp.server.BlockManager.NewPeer(sp.syncPeer)
+	}
 
 	// Add valid peer to the server.
 	sp.server.AddPeer(sp)
@@ -288,30 +311,277 @@ func (sp *serverPeer) OnGetHeaders(p *peer.Peer, msg *message.MsgGetHeaders) {
 		hashSlice = chain.LocateBlocks(msg.GS, message.MaxBlockHeadersPerMsg)
 	}
 	hsLen := len(hashSlice)
+	headersMsg := message.NewMsgHeaders(chain.BestSnapshot().GraphState)
 	if hsLen == 0 {
 		log.Trace(fmt.Sprintf("Sorry, there are not these blocks for %s", p.String()))
+		// Peers which sync by headers learn from an empty headers
+		// message that there are no more.
+		if p.ProtocolVersion() >= protocol.SendHeadersVersion {
+			p.QueueMessage(headersMsg, nil)
+		}
 		return
 	}
 
-	headersMsg := message.NewMsgHeaders(chain.BestSnapshot().GraphState)
 	for i := 0; i < hsLen; i++ {
 		blockHead, err := chain.HeaderByHash(hashSlice[i])
 		if err != nil {
 			log.Trace(fmt.Sprintf("Sorry, there are not these blocks %s for %s", hashSlice[i].String(), p.String()))
 			return
 		}
-		headersMsg.AddBlockHeader(&blockHead)
+		parents, err := chain.ParentsByHash(hashSlice[i])
+		if err != nil {
+			log.Trace(fmt.Sprintf("Sorry, there are not these blocks %s for %s", hashSlice[i].String(), p.String()))
+			return
+		}
+		headersMsg.AddBlockHeaderWithParents(&blockHead, parents)
 	}
 	if len(headersMsg.Headers) > 0 {
 		p.QueueMessage(headersMsg, nil)
 	}
 }
 
+// OnHeaders is invoked when a peer receives a headers message.  The headers
+// are passed down to the light sync manager which connects them to the header
+// chain of a light node, or to the block manager which downloads their blocks.
+func (sp *serverPeer) OnHeaders(p *peer.Peer, msg *message.MsgHeaders) {
+	if sp.server.LightManager != nil {
+		sp.server.LightManager.QueueHeaders(msg, sp.syncPeer)
+		return
+	}
+	sp.server.BlockManager.QueueHeaders(msg, sp.syncPeer)
+}
+
+// OnNotFound is invoked when a peer receives a notfound message.  It is passed
+// down to the block manager, which requests the blocks the peer doesn't have
+// from other peers.
+func (sp *serverPeer) OnNotFound(p *peer.Peer, msg *message.MsgNotFound) {
+	if sp.server.LightManager != nil {
+		return
+	}
+	sp.server.BlockManager.QueueNotFound(msg, sp.syncPeer)
+}
+
+// OnGetCFilter is invoked when a peer receives a getcfilter wire message.
+func (sp *serverPeer) OnGetCFilter(p *peer.Peer, msg *message.MsgGetCFilter) {
+	// Ignore getcfilter requests if the committed filter index is not
+	// maintained, the node is not in sync or the peer predates the
+	// committed filter messages.
+	cfIndex := sp.server.CfIndex
+	if cfIndex == nil || !sp.server.BlockManager.IsCurrent() ||
+		p.ProtocolVersion() < protocol.NodeCFVersion {
+		return
+	}
+	if msg.FilterType != message.GCSFilterRegular {
+		log.Debug(fmt.Sprintf("Peer %s requested unknown filter type %v",
+			p.String(), msg.FilterType))
+		return
+	}
+
+	filterBytes, err := cfIndex.FilterByBlockHash(&msg.BlockHash)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to fetch committed filter of %s: %v",
+			msg.BlockHash.String(), err))
+		return
+	}
+	if filterBytes == nil {
+		log.Trace(fmt.Sprintf("Sorry, there is no filter for block %s for %s",
+			msg.BlockHash.String(), p.String()))
+		return
+	}
+
+	filterMsg := message.NewMsgCFilter(&msg.BlockHash, msg.FilterType, filterBytes)
+	p.QueueMessage(filterMsg, nil)
+}
+
+// OnGetCFHeaders is invoked when a peer receives a getcfheaders wire message.
+// The filter headers are returned in DAG order with the hashes of their
+// blocks, starting after the latest known block of the locator and ending at
+// the stop hash, along with the filter header the first of them commits to.
+func (sp *serverPeer) OnGetCFHeaders(p *peer.Peer, msg *message.MsgGetCFHeaders) {
+	// Ignore getcfheaders requests if the committed filter index is not
+	// maintained, the node is not in sync or the peer predates the
+	// committed filter messages.
+	cfIndex := sp.server.CfIndex
+	if cfIndex == nil || !sp.server.BlockManager.IsCurrent() ||
+		p.ProtocolVersion() < protocol.NodeCFVersion {
+		return
+	}
+	if msg.FilterType != message.GCSFilterRegular {
+		log.Debug(fmt.Sprintf("Peer %s requested unknown filter type %v",
+			p.String(), msg.FilterType))
+		return
+	}
+
+	// Start after the locator block with the highest order, or at the
+	// genesis block if none of the locator blocks are known.
+	bd := sp.server.BlockManager.GetChain().BlockDAG()
+	startOrder := uint32(0)
+	for _, h := range msg.BlockLocatorHashes {
+		block := bd.GetBlock(h)
+		if block == nil {
+			continue
+		}
+		if order := uint32(block.GetOrder()) + 1; order > startOrder {
+			startOrder = order
+		}
+	}
+
+	var stopHash *hash.Hash
+	if !msg.HashStop.IsEqual(&hash.ZeroHash) {
+		stopHash = &msg.HashStop
+	}
+	blockHashes, headers, prevHeader, err := cfIndex.FilterHeadersByOrder(
+		startOrder, stopHash, message.MaxCFHeadersPerMsg)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to fetch committed filter headers: %v", err))
+		return
+	}
+	if len(headers) == 0 {
+		log.Trace(fmt.Sprintf("Sorry, there are no filter headers for %s", p.String()))
+		return
+	}
+
+	headersMsg := message.NewMsgCFHeaders()
+	headersMsg.FilterType = msg.FilterType
+	headersMsg.StopHash = *blockHashes[len(blockHashes)-1]
+	headersMsg.PrevFilterHeader = *prevHeader
+	for i, header := range headers {
+		headersMsg.AddCFHeader(blockHashes[i], header)
+	}
+	p.QueueMessage(headersMsg, nil)
+}
+
+// OnFilterAdd is invoked when a peer receives a filteradd wire message and is
+// used by remote peers to add data to an already loaded bloom filter.  The
+// peer will be disconnected if a filter is not loaded when this message is
+// received or the server is not configured to allow bloom filters.
+func (sp *serverPeer) OnFilterAdd(p *peer.Peer, msg *message.MsgFilterAdd) {
+	// Disconnect and ban the peer if the node does not support bloom
+	// filters.
+	if !sp.enforceNodeBloomFlag(msg.Command()) {
+		return
+	}
+
+	if !sp.filter.IsLoaded() {
+		log.Debug(fmt.Sprintf("%s sent a filteradd request with no filter "+
+			"loaded -- disconnecting", p))
+		sp.addBanScore(100, 0, msg.Command())
+		p.Disconnect()
+		return
+	}
+
+	sp.filter.Add(msg.Data)
+}
+
+// OnFilterClear is invoked when a peer receives a filterclear wire message and
+// is used by remote peers to clear an already loaded bloom filter.  The peer
+// will be disconnected if a filter is not loaded when this message is received
+// or the server is not configured to allow bloom filters.
+func (sp *serverPeer) OnFilterClear(p *peer.Peer, msg *message.MsgFilterClear) {
+	// Disconnect and ban the peer if the node does not support bloom
+	// filters.
+	if !sp.enforceNodeBloomFlag(msg.Command()) {
+		return
+	}
+
+	if !sp.filter.IsLoaded() {
+		log.Debug(fmt.Sprintf("%s sent a filterclear request with no "+
+			"filter loaded -- disconnecting", p))
+		sp.addBanScore(100, 0, msg.Command())
+		p.Disconnect()
+		return
+	}
+
+	sp.filter.Unload()
+}
+
+// OnFilterLoad is invoked when a peer receives a filterload wire message and it
+// is used to load a bloom filter that should be used for delivering merkle
+// blocks and associated transactions that match the filter.  The peer will be
+// disconnected if the server is not configured to allow bloom filters.
+func (sp *serverPeer) OnFilterLoad(p *peer.Peer, msg *message.MsgFilterLoad) {
+	// Disconnect and ban the peer if the node does not support bloom
+	// filters.
+	if !sp.enforceNodeBloomFlag(msg.Command()) {
+		return
+	}
+
+	sp.setDisableRelayTx(false)
+
+	sp.filter.Reload(msg)
+}
+
+// OnMemPool is invoked when a peer receives a mempool wire message.  It
+// replies with an inv message of the transactions in the memory pool which
+// match the bloom filter and the fee filter of the peer.  The reply is sent
+// even when it is empty, since the peer waits for it.
+func (sp *serverPeer) OnMemPool(p *peer.Peer, msg *message.MsgMemPool) {
+	// Only respond with the transactions once per connection, since the
+	// peer only needs them to fill its pool after connecting.  This helps
+	// prevent flooding.
+	if sp.mempoolSent {
+		log.Trace("Ignoring mempool which already sent", "peer", sp.Peer)
+		return
+	}
+	sp.mempoolSent = true
+
+	// Generate inventory message with the available transactions in the
+	// transaction memory pool.  Limit it to the max allowed inventory per
+	// message.  The NewMsgInvSizeHint function automatically limits the
+	// passed hint to the maximum allowed, so it's safe to pass it without
+	// double checking it here.
+	txDescs := sp.server.TxMemPool.TxDescs()
+	invMsg := message.NewMsgInvSizeHint(uint(len(txDescs)))
+	feeFilter := sp.relayFeeFilter()
+	for _, txDesc := range txDescs {
+		if txDesc.FeePerKB < feeFilter {
+			continue
+		}
+		// Either add all transactions when there is no bloom filter,
+		// or only the transactions that match the filter when there is
+		// one.
+		if sp.filter.IsLoaded() && !sp.filter.MatchTxAndUpdate(txDesc.Tx) {
+			continue
+		}
+		iv := message.NewInvVect(message.InvTypeTx, txDesc.Tx.Hash())
+		p.AddKnownInventory(iv)
+		invMsg.AddInvVect(iv)
+		if len(invMsg.InvList)+1 > message.MaxInvPerMsg {
+			break
+		}
+	}
+	invMsg.GS = p.GetGraphState()
+	p.QueueMessage(invMsg, nil)
+}
+
+// OnFeeFilter is invoked when a peer receives a feefilter wire message and is
+// used by remote peers to request that no transactions which have a fee rate
+// lower than provided value are inventoried to them.  The peer will be
+// disconnected if an invalid fee filter value is provided.
+func (sp *serverPeer) OnFeeFilter(p *peer.Peer, msg *message.MsgFeeFilter) {
+	// Check that the passed minimum fee is a valid amount.
+	if msg.MinFee < 0 || msg.MinFee > types.MaxAmount {
+		log.Debug(fmt.Sprintf("Peer %v sent an invalid feefilter '%v' -- "+
+			"disconnecting", p, types.Amount(msg.MinFee)))
+		p.Disconnect()
+		return
+	}
+
+	atomic.StoreInt64(&sp.feeFilter, msg.MinFee)
+}
+
 // OnInv is invoked when a peer receives an inv  message and is used to
 // examine the inventory being advertised by the remote peer and react
 // accordingly.  We pass the message down to blockmanager which will call
 // QueueMessage with any appropriate responses.
 func (sp *serverPeer) OnInv(p *peer.Peer, msg *message.MsgInv) {
+	// A light node only learns about new blocks from the inventory.
+	if sp.server.LightManager != nil {
+		if len(msg.InvList) > 0 {
+			sp.server.LightManager.QueueInv(msg, sp.syncPeer)
+		}
+		return
+	}
 	if !sp.server.cfg.BlocksOnly {
 		if len(msg.InvList) > 0 {
 			sp.server.BlockManager.QueueInv(msg, sp.syncPeer)
@@ -382,6 +652,8 @@ func (sp *serverPeer) OnGetData(p *peer.Peer, msg *message.MsgGetData) {
 			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan)
 		case message.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan)
+		case message.InvTypeFilteredBlock:
+			err = sp.server.pushMerkleBlockMsg(sp, &iv.Hash, c, waitChan)
 		default:
 			log.Warn("Unknown type in inventory request", "type", iv.Type)
 			continue
@@ -0,0 +1,209 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package peerserver
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/p2p/connmgr"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/mempool"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testGraphState returns the graph state of a privnet node with only the
+// genesis block.
+func testGraphState() *blockdag.GraphState {
+	gs := blockdag.NewGraphState()
+	gs.GetTips().Add(params.PrivNetParams.GenesisHash)
+	gs.SetTotal(1)
+	return gs
+}
+
+// newTestPeer connects an outbound peer to a remote node over the loopback
+// interface, and returns it once the handshake completed.  The remote node
+// only answers the handshake, and sends the inventories it receives to invs
+// until the connection is closed.
+func newTestPeer(t *testing.T, invs chan<- *message.MsgInv) *peer.Peer {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	network := params.PrivNetParams.Net
+	pver := protocol.ProtocolVersion
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err := message.ReadMessage(conn, pver, network); err != nil {
+			return
+		}
+		version := message.NewMsgVersion(&types.NetAddress{},
+			&types.NetAddress{}, 1, testGraphState())
+		if err := message.WriteMessage(conn, version, pver, network); err != nil {
+			return
+		}
+		if err := message.WriteMessage(conn, message.NewMsgVerAck(), pver,
+			network); err != nil {
+			return
+		}
+		for {
+			msg, _, err := message.ReadMessage(conn, pver, network)
+			if err != nil {
+				return
+			}
+			if inv, ok := msg.(*message.MsgInv); ok {
+				invs <- inv
+			}
+		}
+	}()
+
+	verAck := make(chan struct{}, 1)
+	p, err := peer.NewOutboundPeer(&peer.Config{
+		NewestGS: func() (*blockdag.GraphState, error) {
+			return testGraphState(), nil
+		},
+		ChainParams: &params.PrivNetParams,
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *message.MsgVerAck) {
+				verAck <- struct{}{}
+			},
+		},
+	}, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	connReq := &connmgr.ConnReq{}
+	connReq.SetConn(conn)
+	p.AssociateConnection(connReq)
+	select {
+	case <-verAck:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Peer didn't complete the handshake")
+	}
+	return p
+}
+
+// newTestMemPool returns a pool holding a transaction for each of the fees.
+// The transactions are the same size, so their fee rates are ordered as the
+// fees.
+func newTestMemPool(fees ...int64) (*mempool.TxPool, []*mempool.TxDesc) {
+	pool := mempool.New(&mempool.Config{ChainParams: &params.PrivNetParams})
+	descs := make([]*mempool.TxDesc, 0, len(fees))
+	for i, fee := range fees {
+		prev := hash.DoubleHashH([]byte{byte(i)})
+		mtx := types.NewTransaction()
+		mtx.AddTxIn(types.NewTxInput(types.NewOutPoint(&prev, 0), []byte{}))
+		mtx.AddTxOut(types.NewTxOutput(1e8, []byte{txscript.OP_TRUE}))
+		tx := types.NewTx(mtx)
+		pool.AddTransaction(blockchain.NewUtxoViewpoint(), tx, 1, fee)
+		desc, err := pool.FetchTxDesc(tx.Hash())
+		if err != nil {
+			panic(err)
+		}
+		descs = append(descs, desc)
+	}
+	return pool, descs
+}
+
+// TestOnMemPool checks that the mempool request is answered once with the
+// transactions paying at least the fee rate of the fee filter of the peer.
+func TestOnMemPool(t *testing.T) {
+	pool, descs := newTestMemPool(1000, 5000, 10000)
+	invs := make(chan *message.MsgInv, 1)
+	sp := newServerPeer(&PeerServer{TxMemPool: pool}, false)
+	sp.Peer = newTestPeer(t, invs)
+	defer sp.Disconnect()
+
+	// The fee filter is the fee rate of the middle transaction.
+	feeFilter := descs[1].FeePerKB
+	sp.OnFeeFilter(sp.Peer, message.NewMsgFeeFilter(feeFilter))
+	if got := sp.relayFeeFilter(); got != feeFilter {
+		t.Fatalf("Got fee filter %d, want %d", got, feeFilter)
+	}
+
+	sp.OnMemPool(sp.Peer, message.NewMsgMemPool())
+	var inv *message.MsgInv
+	select {
+	case inv = <-invs:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No inventory was sent for the mempool request")
+	}
+	want := map[hash.Hash]bool{
+		*descs[1].Tx.Hash(): true,
+		*descs[2].Tx.Hash(): true,
+	}
+	if len(inv.InvList) != len(want) {
+		t.Fatalf("Got %d transactions, want %d", len(inv.InvList), len(want))
+	}
+	for _, iv := range inv.InvList {
+		if iv.Type != message.InvTypeTx || !want[iv.Hash] {
+			t.Fatalf("Got unexpected inventory %v", iv)
+		}
+	}
+
+	// The mempool is only sent once per connection.
+	sp.OnMemPool(sp.Peer, message.NewMsgMemPool())
+	select {
+	case inv := <-invs:
+		t.Fatalf("Got inventory of %d transactions for the second "+
+			"mempool request", len(inv.InvList))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestOnFeeFilter checks that the valid fee filters are set, and that the peers
+// sending an invalid fee filter are disconnected.
+func TestOnFeeFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		minFee int64
+		valid  bool
+	}{
+		{"zero", 0, true},
+		{"max amount", types.MaxAmount, true},
+		{"negative", -1, false},
+		{"above max amount", types.MaxAmount + 1, false},
+	}
+	for _, test := range tests {
+		sp := newServerPeer(&PeerServer{}, false)
+		sp.Peer = newTestPeer(t, make(chan *message.MsgInv, 1))
+		atomic.StoreInt64(&sp.feeFilter, 1000)
+
+		sp.OnFeeFilter(sp.Peer, message.NewMsgFeeFilter(test.minFee))
+		if test.valid {
+			if got := sp.relayFeeFilter(); got != test.minFee {
+				t.Errorf("%s: got fee filter %d, want %d", test.name,
+					got, test.minFee)
+			}
+			if !sp.Connected() {
+				t.Errorf("%s: peer was disconnected", test.name)
+			}
+		} else {
+			if got := sp.relayFeeFilter(); got != 1000 {
+				t.Errorf("%s: fee filter changed to %d", test.name, got)
+			}
+			if sp.Connected() {
+				t.Errorf("%s: peer wasn't disconnected", test.name)
+			}
+		}
+		sp.Disconnect()
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/Qitmeer/qitmeer/core/message"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/log"
+	"github.com/Qitmeer/qitmeer/services/mempool"
 )
 
 // handleRelayInvMsg deals with relaying inventory to peers that are not already
@@ -12,6 +13,7 @@ import (
 func (s *PeerServer) handleRelayInvMsg(state *peerState, msg relayMsg) {
 	log.Trace("handleRelayInvMsg", "msg", msg)
 	var gs *blockdag.GraphState
+	var txDesc *mempool.TxDesc
 	state.forAllPeers(func(sp *serverPeer) {
 		if !sp.Connected() {
 			return
@@ -26,8 +28,14 @@ func (s *PeerServer) handleRelayInvMsg(state *peerState, msg relayMsg) {
 					" is not a block header")
 				return
 			}
-			msgHeaders := message.NewMsgHeaders(s.BlockManager.GetChain().BestSnapshot().GraphState)
-			if err := msgHeaders.AddBlockHeader(&blockHeader); err != nil {
+			chain := s.BlockManager.GetChain()
+			parents, err := chain.ParentsByHash(&msg.invVect.Hash)
+			if err != nil {
+				log.Warn("Failed to fetch block parents", "error", err)
+				return
+			}
+			msgHeaders := message.NewMsgHeaders(chain.BestSnapshot().GraphState)
+			if err := msgHeaders.AddBlockHeaderWithParents(&blockHeader, parents); err != nil {
 				log.Error("Failed to add block header", "error", err)
 				return
 			}
@@ -41,6 +49,39 @@ func (s *PeerServer) handleRelayInvMsg(state *peerState, msg relayMsg) {
 			if sp.relayTxDisabled() {
 				return
 			}
+
+			// Don't relay the transaction if its fee rate is lower
+			// than the fee filter of the peer.  The fee rate is only
+			// looked up once the first peer with a filter needs it.
+			if feeFilter := sp.relayFeeFilter(); feeFilter > 0 {
+				if txDesc == nil {
+					var err error
+					txDesc, err = s.TxMemPool.FetchTxDesc(&msg.invVect.Hash)
+					if err != nil {
+						log.Trace("Unable to fetch tx for fee filter",
+							"tx hash", msg.invVect.Hash, "error", err)
+						return
+					}
+				}
+				if txDesc.FeePerKB < feeFilter {
+					return
+				}
+			}
+
+			// Don't relay the transaction if there is a bloom
+			// filter loaded and the transaction doesn't match it.
+			if sp.filter.IsLoaded() {
+				tx, ok := msg.data.(*types.Tx)
+				if !ok {
+					log.Warn("Underlying data for tx inv " +
+						"relay is not a transaction")
+					return
+				}
+
+				if !sp.filter.MatchTxAndUpdate(tx) {
+					return
+				}
+			}
 		}
 
 		// Either queue the inventory to be relayed immediately or with
@@ -20,6 +20,8 @@ import (
 	"github.com/Qitmeer/qitmeer/p2p/peer"
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/services/blkmgr"
+	"github.com/Qitmeer/qitmeer/services/index"
+	"github.com/Qitmeer/qitmeer/services/light"
 	"github.com/Qitmeer/qitmeer/services/mempool"
 	"github.com/Qitmeer/qitmeer/version"
 	"github.com/satori/go.uuid"
@@ -32,7 +34,7 @@ import (
 
 const (
 	// the default services supported by the node
-	defaultServices = protocol.Full | protocol.CF
+	defaultServices = protocol.Full | protocol.Bloom | protocol.CF
 
 	// the default services that are required to be supported
 	defaultRequiredServices = protocol.Full
@@ -51,6 +53,10 @@ const (
 
 	// connection timeout setting
 	defaultConnectTimeout = time.Second * 30
+
+	// feeFilterInterval is the interval at which the minimum fee rate of
+	// the memory pool is advertised again to the peers when it changed.
+	feeFilterInterval = time.Minute
 )
 
 var (
@@ -99,6 +105,8 @@ type PeerServer struct {
 	TimeSource   blockchain.MedianTimeSource
 	BlockManager *blkmgr.BlockManager
 	TxMemPool    *mempool.TxPool
+	CfIndex      *index.CfIndex
+	LightManager *light.SyncManager
 
 	services protocol.ServiceFlag
 }
@@ -148,6 +156,9 @@ func (s *PeerServer) outboundPeerConnected(c *connmgr.ConnReq) {
 
 // newPeerConfig returns the configuration for the given serverPeer.
 func newPeerConfig(sp *serverPeer) *peer.Config {
+	if sp.server.LightManager != nil {
+		return newLightPeerConfig(sp)
+	}
 
 	return &peer.Config{
 		Listeners: peer.MessageListeners{
@@ -158,17 +169,22 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnWrite:          sp.OnWrite,
 			OnGetBlocks:      sp.OnGetBlocks,
 			OnGetHeaders:     sp.OnGetHeaders,
+			OnHeaders:        sp.OnHeaders,
 			OnBlock:          sp.OnBlock,
 			OnGetData:        sp.OnGetData,
+			OnNotFound:       sp.OnNotFound,
 			OnInv:            sp.OnInv,
 			OnGetMiningState: sp.OnGetMiningState,
 			OnMiningState:    sp.OnMiningState,
 			OnTx:             sp.OnTx,
 			OnGraphState:     sp.OnGraphState,
-			//OnMemPool:        sp.OnMemPool,
-			//OnHeaders:        sp.OnHeaders,
-			//OnGetCFilter:     sp.OnGetCFilter,
-			//OnGetCFHeaders:   sp.OnGetCFHeaders,
+			OnGetCFilter:     sp.OnGetCFilter,
+			OnGetCFHeaders:   sp.OnGetCFHeaders,
+			OnFilterAdd:      sp.OnFilterAdd,
+			OnFilterClear:    sp.OnFilterClear,
+			OnFilterLoad:     sp.OnFilterLoad,
+			OnMemPool:        sp.OnMemPool,
+			OnFeeFilter:      sp.OnFeeFilter,
 			//OnGetCFTypes:     sp.OnGetCFTypes,
 		},
 		NewestGS:         sp.newestGS,
@@ -182,6 +198,30 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 	}
 }
 
+// newLightPeerConfig returns the configuration for the given serverPeer of a
+// light node, which only syncs the headers of the block DAG from its peers.
+func newLightPeerConfig(sp *serverPeer) *peer.Config {
+	return &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVersion: sp.OnVersion,
+			OnGetAddr: sp.OnGetAddr,
+			OnAddr:    sp.OnAddr,
+			OnRead:    sp.OnRead,
+			OnWrite:   sp.OnWrite,
+			OnInv:     sp.OnInv,
+			OnHeaders: sp.OnHeaders,
+		},
+		NewestGS:         sp.newestGS,
+		HostToNetAddress: sp.server.addrManager.HostToNetAddress,
+		UserAgentName:    userAgentName,
+		UserAgentVersion: userAgentVersion,
+		ChainParams:      sp.server.chainParams,
+		Services:         sp.server.services,
+		DisableRelayTx:   true,
+		ProtocolVersion:  maxProtocolVersion,
+	}
+}
+
 // isWhitelisted returns whether the IP address is included in the whitelisted
 // networks and IPs.
 func isWhitelisted(cfg *config.Config, addr net.Addr) bool {
@@ -284,6 +324,9 @@ func (p *PeerServer) Stop() error {
 // newestBlock returns the current best block hash and height using the format
 // required by the configuration for the peer package.
 func (sp *serverPeer) newestGS() (*blockdag.GraphState, error) {
+	if sp.server.LightManager != nil {
+		return sp.server.LightManager.Chain().GraphState(), nil
+	}
 	best := sp.server.BlockManager.GetChain().BestSnapshot()
 	return best.GraphState, nil
 }
@@ -314,8 +357,12 @@ func (s *PeerServer) peerDoneHandler(sp *serverPeer) {
 
 	// Only tell block manager we are gone if we ever told it we existed.
 	if sp.VersionKnown() && !sp.connReq.Ban {
-		log.Trace("peerDoneHandler send blkmgr donePeerMsg ")
-		s.BlockManager.DonePeer(sp.syncPeer)
+		if s.LightManager != nil {
+			s.LightManager.DonePeer(sp.syncPeer)
+		} else {
+			log.Trace("peerDoneHandler send blkmgr donePeerMsg ")
+			s.BlockManager.DonePeer(sp.syncPeer)
+		}
 	}
 	close(sp.quit)
 	log.Trace("stop peerDoneHandler")
@@ -351,6 +398,9 @@ func (s *PeerServer) peerHandler() {
 	}
 	go s.connManager.Start()
 
+	feeFilterTicker := time.NewTicker(feeFilterInterval)
+	defer feeFilterTicker.Stop()
+
 out:
 	for {
 		select {
@@ -379,6 +429,12 @@ out:
 		case qmsg := <-s.query:
 			s.handleQuery(state, qmsg)
 
+		// Advertise the changed minimum fee rate of the memory pool.
+		case <-feeFilterTicker.C:
+			state.forAllPeers(func(sp *serverPeer) {
+				sp.pushFeeFilter()
+			})
+
 		case <-s.quit:
 			// Disconnect all peers on server shutdown.
 			state.forAllPeers(func(sp *serverPeer) {
@@ -421,6 +477,15 @@ func (s *PeerServer) BroadcastMessage(msg message.Message, exclPeers ...*serverP
 	s.broadcast <- bmsg
 }
 
+// isCurrent returns whether or not the node believes it is synced with the
+// connected peers.
+func (s *PeerServer) isCurrent() bool {
+	if s.LightManager != nil {
+		return s.LightManager.IsCurrent()
+	}
+	return s.BlockManager.IsCurrent()
+}
+
 // Dial connects to the address on the named network.
 func (s *PeerServer) Dial(network, addr string) (net.Conn, error) {
 	return net.DialTimeout(network, addr, defaultConnectTimeout)
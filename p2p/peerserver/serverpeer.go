@@ -6,19 +6,27 @@
 package peerserver
 
 import (
+	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/protocol"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/p2p/addmgr"
 	"github.com/Qitmeer/qitmeer/p2p/connmgr"
 	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"github.com/Qitmeer/qitmeer/services/bloom"
 	"sync"
+	"sync/atomic"
 )
 
 // serverPeer extends the peer to maintain state shared by the p2p server and
 // the blockmanager.
 type serverPeer struct {
+	// The following variables must only be used atomically
+	feeFilter     int64
+	sentFeeFilter int64
+
 	*peer.Peer
 
 	connReq        *connmgr.ConnReq
@@ -27,6 +35,7 @@ type serverPeer struct {
 	relayMtx       sync.Mutex
 	disableRelayTx bool
 	isWhitelisted  bool
+	filter         *bloom.Filter
 	requestQueue   []*message.InvVect
 	requestedTxns  map[hash.Hash]struct{}
 	knownAddresses map[string]struct{}
@@ -37,6 +46,10 @@ type serverPeer struct {
 	// request.  It is used to prevent more than one response per connection.
 	addrsSent bool
 
+	// mempoolSent tracks whether or not the peer has responded to a mempool
+	// request.  It is used to prevent more than one response per connection.
+	mempoolSent bool
+
 	// The following chans are used to sync blockmanager and server.
 	syncPeer *peer.ServerPeer
 }
@@ -47,6 +60,7 @@ func newServerPeer(s *PeerServer, isPersistent bool) *serverPeer {
 	return &serverPeer{
 		server:         s,
 		persistent:     isPersistent,
+		filter:         bloom.LoadFilter(nil),
 		knownAddresses: make(map[string]struct{}),
 		quit:           make(chan struct{}),
 		syncPeer: &peer.ServerPeer{
@@ -109,6 +123,34 @@ func (sp *serverPeer) relayTxDisabled() bool {
 	return isDisabled
 }
 
+// relayFeeFilter returns the minimum fee in atoms per 1000 bytes of the
+// transactions the peer wants to be announced, it is zero when the peer didn't
+// send a feefilter message.
+// It is safe for concurrent access.
+func (sp *serverPeer) relayFeeFilter() int64 {
+	return atomic.LoadInt64(&sp.feeFilter)
+}
+
+// pushFeeFilter sends a feefilter message advertising the minimum fee rate of
+// the memory pool to the peer, unless the one last sent is within a quarter of
+// it.  The minimum fee rate is raised while the pool is full and decays
+// afterwards, so it is advertised again as it changes.
+// It is safe for concurrent access.
+func (sp *serverPeer) pushFeeFilter() {
+	if sp.server.cfg.BlocksOnly || sp.server.LightManager != nil ||
+		sp.ProtocolVersion() < protocol.FeeFilterVersion {
+		return
+	}
+	minFee := int64(sp.server.TxMemPool.MinRelayTxFee())
+	sentFee := atomic.LoadInt64(&sp.sentFeeFilter)
+	if minFee == sentFee ||
+		(sentFee > 0 && minFee*4 > sentFee*3 && minFee*3 < sentFee*4) {
+		return
+	}
+	atomic.StoreInt64(&sp.sentFeeFilter, minFee)
+	sp.QueueMessage(message.NewMsgFeeFilter(minFee), nil)
+}
+
 // IsTxRelayDisabled returns whether or not the peer has disabled transaction
 // relay.
 func (sp *serverPeer) IsTxRelayDisabled() bool {
@@ -120,3 +162,19 @@ func (sp *serverPeer) IsTxRelayDisabled() bool {
 func (sp *serverPeer) BanScore() uint32 {
 	return sp.banScore.Int()
 }
+
+// enforceNodeBloomFlag disconnects and bans the peer if the server is not
+// configured to allow bloom filters, since the peer is intentionally violating
+// the protocol by ignoring the services the server advertised.
+func (sp *serverPeer) enforceNodeBloomFlag(cmd string) bool {
+	if sp.server.services&protocol.Bloom != protocol.Bloom {
+		// Disconnect the peer regardless of the banning state.
+		log.Debug(fmt.Sprintf("%s sent an unsupported %s request -- "+
+			"disconnecting", sp, cmd))
+		sp.addBanScore(100, 0, cmd)
+		sp.Disconnect()
+		return false
+	}
+
+	return true
+}
@@ -45,8 +45,12 @@ var CPUMinerThreads = 1
 // documentation for blockchain.IsCheckpointCandidate for details on the
 // selection criteria.
 type Checkpoint struct {
-	Layer  uint64
-	Hash   *hash.Hash
+	Layer uint64
+	Hash  *hash.Hash
+
+	// UtxoSetHash is the hash of the utxo snapshot after the block of the
+	// checkpoint, it is nil when no snapshot can be loaded for the block.
+	UtxoSetHash *hash.Hash
 }
 
 // DNSSeed identifies a DNS seed.
@@ -62,8 +66,12 @@ type DNSSeed struct {
 // ConsensusDeployment defines details related to a specific consensus rule
 // change that is voted in.  This is part of BIP0009.
 type ConsensusDeployment struct {
+	// Id is the unique identifier of the deployment.
+	Id string
+
 	// BitNumber defines the specific bit number within the block version
-	// this particular soft-fork deployment refers to.
+	// this particular soft-fork deployment refers to.  The bits 0-7 hold
+	// the base block version, so it must be between 8 and 28.
 	BitNumber uint8
 
 	// StartTime is the median block time after which voting on the
@@ -75,6 +83,13 @@ type ConsensusDeployment struct {
 	ExpireTime uint64
 }
 
+// Constants that define the deployment IDs.
+const (
+	// DeploymentTestDummy defines the rule change deployment ID for testing
+	// purposes.
+	DeploymentTestDummy = "testdummy"
+)
+
 // Params defines a qitmeer network by its parameters.  These parameters may be
 // used by qitmeer applications to differentiate networks as well as addresses
 // and keys for one network from those intended for use on another network.
@@ -210,11 +225,18 @@ type Params struct {
 	// state retarget window.
 	//
 	// Deployments define the specific consensus rule changes to be voted
-	// on.
+	// on.  They are keyed by the base block version of the network.
 	RuleChangeActivationThreshold uint32
 	MinerConfirmationWindow       uint32
 	Deployments                   map[uint32][]ConsensusDeployment
 
+	// LayerExpiryActivation is the layer of the block dag from which the
+	// expiry of transactions is relative to the layer of the block which
+	// includes them instead of its height.  It must be above the layer of
+	// the existing blocks of the network, whose transactions were accepted
+	// with the height based expiry.
+	LayerExpiryActivation uint64
+
 	// Mempool parameters
 	RelayNonStdTxs bool
 
@@ -222,6 +244,10 @@ type Params struct {
 	// for any given address encoded as a string.
 	NetworkAddressPrefix string
 
+	// Bech32HRP is the human-readable part of the bech32 encoded addresses
+	// of the network.
+	Bech32HRP string
+
 	// Address encoding magics
 	PubKeyAddrID     [2]byte // First 2 bytes of a P2PK address
 	PubKeyHashAddrID [2]byte // First 2 bytes of P2PKH address
@@ -326,3 +352,15 @@ func hexMustDecode(hexStr string) []byte {
 	}
 	return b
 }
+
+// newHashFromStr converts the passed big-endian hex string into a hash.Hash.
+// It only differs from the one available in hash in that it panics on an
+// error since it will only (and must only) be called with hard-coded, and
+// therefore known good, hashes.
+func newHashFromStr(hexStr string) *hash.Hash {
+	h, err := hash.NewHashFromStr(hexStr)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
@@ -74,10 +74,21 @@ var MainNetParams = Params{
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints: []Checkpoint{},
 
-	Deployments: map[uint32][]ConsensusDeployment{},
+	// Consensus rule change deployments.
+	RuleChangeActivationThreshold: 1916, // 95% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016,
+	Deployments:                   map[uint32][]ConsensusDeployment{},
+
+	// Transactions expire by the height of the blocks below this layer,
+	// and by their layer from it.  The blocks of the main network were
+	// accepted with the height based expiry, so it only changes at a layer
+	// the network hasn't reached yet: at 5 minutes per block from the
+	// genesis of 2019-07-01, layer 790000 is around January 1, 2027.
+	LayerExpiryActivation: 790000,
 
 	// Address encoding magics
 	NetworkAddressPrefix: "N",
+	Bech32HRP:            "meer",
 	PubKeyAddrID:         [2]byte{0x0c, 0x3e}, // starts with Nk
 	PubKeyHashAddrID:     [2]byte{0x0c, 0x41}, // starts with Nm
 	PKHEdwardsAddrID:     [2]byte{0x0c, 0x30}, // starts with Ne
@@ -76,11 +76,27 @@ var MixNetParams = Params{
 	Checkpoints: []Checkpoint{},
 
 	// Consensus rule change deployments.
-	//
-	Deployments: map[uint32][]ConsensusDeployment{},
+	RuleChangeActivationThreshold: 1512, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016,
+	Deployments: map[uint32][]ConsensusDeployment{
+		16: {{
+			Id:         DeploymentTestDummy,
+			BitNumber:  28,
+			StartTime:  1798761600, // January 1, 2027 UTC
+			ExpireTime: 1830297599, // December 31, 2027 UTC
+		}},
+	},
+
+	// Transactions expire by the height of the blocks below this layer,
+	// and by their layer from it.  The mix network activates it at a layer
+	// it hasn't reached, which keeps the height based expiry of its
+	// existing blocks: at 1 minute per block from the genesis of
+	// 2019-01-17, layer 4190000 is around January 1, 2027.
+	LayerExpiryActivation: 4190000,
 
 	// Address encoding magics
 	NetworkAddressPrefix: "X",
+	Bech32HRP:            "xmeer",
 	PubKeyAddrID:         [2]byte{0x11, 0x6e}, // starts with Xx
 	PubKeyHashAddrID:     [2]byte{0x11, 0x53}, // starts with Xm
 	PKHEdwardsAddrID:     [2]byte{0x11, 0x3c}, // starts with Xc
@@ -10,6 +10,7 @@ import (
 	"github.com/Qitmeer/qitmeer/common"
 	"github.com/Qitmeer/qitmeer/core/protocol"
 	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"math"
 	"math/big"
 	"time"
 )
@@ -89,10 +90,23 @@ var PrivNetParams = Params{
 	Checkpoints: nil,
 
 	// Consensus rule change deployments.
-	Deployments: map[uint32][]ConsensusDeployment{},
+	RuleChangeActivationThreshold: 108, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       144,
+	Deployments: map[uint32][]ConsensusDeployment{
+		8: {{
+			Id:         DeploymentTestDummy,
+			BitNumber:  28,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		}},
+	},
+
+	// Transactions expire by the layer of the blocks from the genesis.
+	LayerExpiryActivation: 0,
 
 	// Address encoding magics
 	NetworkAddressPrefix: "R",
+	Bech32HRP:            "rmeer",
 	PubKeyAddrID:         [2]byte{0x0d, 0xef}, // starts with Rk
 	PubKeyHashAddrID:     [2]byte{0x0d, 0xf1}, // starts with Rm
 	PKHEdwardsAddrID:     [2]byte{0x0d, 0xdf}, // starts with Re
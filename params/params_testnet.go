@@ -75,14 +75,33 @@ var TestNetParams = Params{
 	CoinbaseMaturity: 720, // coinbase required 720 * 30 = 6 hours before repent
 
 	// Checkpoints ordered from oldest to newest.
-	Checkpoints: []Checkpoint{},
+	Checkpoints: []Checkpoint{
+		{0, &testNetGenesisHash, newHashFromStr("d39bf092cbfe02dcd9a0d5ea47277d2a66d859cb752b284bf5855648faa85ee3")},
+	},
 
 	// Consensus rule change deployments.
-	//
-	Deployments: map[uint32][]ConsensusDeployment{},
+	RuleChangeActivationThreshold: 1512, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016,
+	Deployments: map[uint32][]ConsensusDeployment{
+		8: {{
+			Id:         DeploymentTestDummy,
+			BitNumber:  28,
+			StartTime:  1798761600, // January 1, 2027 UTC
+			ExpireTime: 1830297599, // December 31, 2027 UTC
+		}},
+	},
+
+	// Transactions expire by the height of the blocks below this layer,
+	// and by their layer from it.  The activation is past the current
+	// layer of the test network, so its existing blocks keep the height
+	// based expiry: at 30 seconds per block from the genesis of 2019-01-17,
+	// layer 8370000 is around January 1, 2027 along with the deployments
+	// above.
+	LayerExpiryActivation: 8370000,
 
 	// Address encoding magics
 	NetworkAddressPrefix: "T",
+	Bech32HRP:            "tmeer",
 	PubKeyAddrID:         [2]byte{0x0f, 0x0f}, // starts with Tk
 	PubKeyHashAddrID:     [2]byte{0x0f, 0x12}, // starts with Tm
 	PKHEdwardsAddrID:     [2]byte{0x0f, 0x01}, // starts with Te
@@ -101,6 +101,32 @@ func qitmeerdMain(nodeChan chan<- *node.Node) error {
 
 		return nil
 	}
+	if cfg.DropCFIndex {
+		if err := index.DropCfIndex(db, interrupt); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return err
+		}
+
+		return nil
+	}
+
+	if cfg.DropSpendIndex {
+		if err := index.DropSpendIndex(db, interrupt); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return err
+		}
+
+		return nil
+	}
+
+	if cfg.DropStatsIndex {
+		if err := index.DropBlockStatsIndex(db, interrupt); err != nil {
+			log.Error(fmt.Sprintf("%v", err))
+			return err
+		}
+
+		return nil
+	}
 
 	// Cleanup the block database
 	if cfg.Cleanup {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer/common/encode/base58"
 	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/address"
 	"github.com/Qitmeer/qitmeer/params"
 )
 
@@ -78,3 +79,25 @@ func EcPubKeyToAddressSTDO(version []byte, pubkey string) {
 	address := base58.QitmeerCheckEncode(h, version[:])
 	fmt.Printf("%s\n", address)
 }
+
+// AddressToBech32 returns the bech32 encoding of a pay-to-pubkey-hash or
+// pay-to-script-hash address.
+func AddressToBech32(addr string) (string, error) {
+	decoded, err := address.DecodeAddress(addr)
+	if err != nil {
+		return "", err
+	}
+	b, err := address.ToBech32(decoded)
+	if err != nil {
+		return "", err
+	}
+	return b.Encode(), nil
+}
+
+func AddressToBech32STDO(addr string) {
+	b, err := AddressToBech32(addr)
+	if err != nil {
+		ErrExit(err)
+	}
+	fmt.Printf("%s\n", b)
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package qx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/marshal"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/psbt"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// PsbtCreate returns a partially signed transaction for an unsigned raw
+// transaction.
+func PsbtCreate(rawTxStr string) (string, error) {
+	if len(rawTxStr)%2 != 0 {
+		return "", fmt.Errorf("invaild raw transaction : %s", rawTxStr)
+	}
+	serializedTx, err := hex.DecodeString(rawTxStr)
+	if err != nil {
+		return "", err
+	}
+	tx := types.NewTransaction()
+	err = tx.Deserialize(bytes.NewReader(serializedTx))
+	if err != nil {
+		return "", err
+	}
+	p, err := psbt.New(tx)
+	if err != nil {
+		return "", err
+	}
+	return p.B64Encode()
+}
+
+// PsbtUpdate adds the outputs spent by the inputs of a partially signed
+// transaction, and the redeem scripts of the pay-to-script-hash ones.
+func PsbtUpdate(psbtStr string, prevOuts PsbtPrevOutsFlag,
+	redeemScripts PsbtRedeemScriptsFlag) (string, error) {
+	p, err := psbt.Parse([]byte(psbtStr), true)
+	if err != nil {
+		return "", err
+	}
+	for _, prevOut := range prevOuts.prevOuts {
+		err := p.AddInPrevOut(prevOut.index,
+			types.NewTxOutput(prevOut.amount, prevOut.pkScript))
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, redeemScript := range redeemScripts.redeemScripts {
+		err := p.AddInRedeemScript(redeemScript.index, redeemScript.script)
+		if err != nil {
+			return "", err
+		}
+	}
+	return p.B64Encode()
+}
+
+// PsbtSign signs the inputs of a partially signed transaction which require
+// a signature of the private key.
+func PsbtSign(privkeyStr string, psbtStr string) (string, error) {
+	privkeyByte, err := hex.DecodeString(privkeyStr)
+	if err != nil {
+		return "", err
+	}
+	if len(privkeyByte) != 32 {
+		return "", fmt.Errorf("invaid ec private key bytes: %d", len(privkeyByte))
+	}
+	privateKey, _ := ecc.Secp256k1.PrivKeyFromBytes(privkeyByte)
+
+	p, err := psbt.Parse([]byte(psbtStr), true)
+	if err != nil {
+		return "", err
+	}
+	signed, err := psbt.Sign(p, privateKey)
+	if err != nil {
+		return "", err
+	}
+	if signed == 0 {
+		return "", fmt.Errorf("the private key can't sign any input")
+	}
+	return p.B64Encode()
+}
+
+// PsbtCombine merges the signatures of partially signed transactions of the
+// same transaction.
+func PsbtCombine(psbtStrs []string) (string, error) {
+	packets := make([]*psbt.Packet, 0, len(psbtStrs))
+	for _, psbtStr := range psbtStrs {
+		p, err := psbt.Parse([]byte(psbtStr), true)
+		if err != nil {
+			return "", err
+		}
+		packets = append(packets, p)
+	}
+	combined, err := psbt.Combine(packets...)
+	if err != nil {
+		return "", err
+	}
+	return combined.B64Encode()
+}
+
+// PsbtFinalize builds the signature scripts of the inputs of a partially
+// signed transaction which have all their signatures.  The signed raw
+// transaction is returned when extract is set and all the inputs are
+// finalized.
+func PsbtFinalize(psbtStr string, extract bool) (string, error) {
+	p, err := psbt.Parse([]byte(psbtStr), true)
+	if err != nil {
+		return "", err
+	}
+	complete, err := psbt.Finalize(p)
+	if err != nil {
+		return "", err
+	}
+	if !extract {
+		return p.B64Encode()
+	}
+	if !complete {
+		return "", fmt.Errorf("the transaction lacks signatures")
+	}
+	tx, err := psbt.Extract(p)
+	if err != nil {
+		return "", err
+	}
+	return marshal.MessageToHex(&message.MsgTx{Tx: tx})
+}
+
+func PsbtDecode(network string, psbtStr string) {
+	var param *params.Params
+	switch network {
+	case "mainnet":
+		param = &params.MainNetParams
+	case "testnet":
+		param = &params.TestNetParams
+	case "privnet":
+		param = &params.PrivNetParams
+	case "mixnet":
+		param = &params.MixNetParams
+	}
+	p, err := psbt.Parse([]byte(psbtStr), true)
+	if err != nil {
+		ErrExit(err)
+	}
+
+	tx := p.UnsignedTx
+	inputs := make([]json.OrderedResult, len(p.Inputs))
+	for i, pInput := range p.Inputs {
+		input := json.OrderedResult{}
+		if pInput.PrevOut != nil {
+			input = append(input, json.KV{Key: "prevout", Val: json.OrderedResult{
+				{Key: "amount", Val: pInput.PrevOut.Amount},
+				{Key: "pkscript", Val: hex.EncodeToString(pInput.PrevOut.PkScript)},
+			}})
+		}
+		if len(pInput.PartialSigs) > 0 {
+			sigs := json.OrderedResult{}
+			for _, sig := range pInput.PartialSigs {
+				sigs = append(sigs, json.KV{Key: hex.EncodeToString(sig.PubKey),
+					Val: hex.EncodeToString(sig.Signature)})
+			}
+			input = append(input, json.KV{Key: "partialsigs", Val: sigs})
+		}
+		if pInput.SighashType != 0 {
+			input = append(input, json.KV{Key: "sighash", Val: pInput.SighashType})
+		}
+		if pInput.RedeemScript != nil {
+			input = append(input, json.KV{Key: "redeemscript",
+				Val: hex.EncodeToString(pInput.RedeemScript)})
+		}
+		if pInput.FinalSignScript != nil {
+			input = append(input, json.KV{Key: "finalsignscript",
+				Val: hex.EncodeToString(pInput.FinalSignScript)})
+		}
+		inputs[i] = input
+	}
+
+	jsonPsbt := &json.OrderedResult{
+		{Key: "tx", Val: json.OrderedResult{
+			{Key: "txid", Val: tx.TxHash().String()},
+			{Key: "version", Val: int32(tx.Version)},
+			{Key: "locktime", Val: tx.LockTime},
+			{Key: "expire", Val: tx.Expire},
+			{Key: "vin", Val: marshal.MarshJsonVin(tx)},
+			{Key: "vout", Val: marshal.MarshJsonVout(tx, nil, param)},
+		}},
+		{Key: "inputs", Val: inputs},
+		{Key: "complete", Val: p.IsComplete()},
+	}
+	marshaledPsbt, err := jsonPsbt.MarshalJSON()
+	if err != nil {
+		ErrExit(err)
+	}
+
+	fmt.Printf("%s", marshaledPsbt)
+}
+
+func PsbtCreateSTDO(rawTxStr string) {
+	psbtStr, err := PsbtCreate(rawTxStr)
+	if err != nil {
+		ErrExit(err)
+	}
+	fmt.Printf("%s\n", psbtStr)
+}
+
+func PsbtUpdateSTDO(psbtStr string, prevOuts PsbtPrevOutsFlag,
+	redeemScripts PsbtRedeemScriptsFlag) {
+	psbtStr, err := PsbtUpdate(psbtStr, prevOuts, redeemScripts)
+	if err != nil {
+		ErrExit(err)
+	}
+	fmt.Printf("%s\n", psbtStr)
+}
+
+func PsbtSignSTDO(privkeyStr string, psbtStr string) {
+	psbtStr, err := PsbtSign(privkeyStr, psbtStr)
+	if err != nil {
+		ErrExit(err)
+	}
+	fmt.Printf("%s\n", psbtStr)
+}
+
+func PsbtCombineSTDO(psbtStrs []string) {
+	psbtStr, err := PsbtCombine(psbtStrs)
+	if err != nil {
+		ErrExit(err)
+	}
+	fmt.Printf("%s\n", psbtStr)
+}
+
+func PsbtFinalizeSTDO(psbtStr string, extract bool) {
+	result, err := PsbtFinalize(psbtStr, extract)
+	if err != nil {
+		ErrExit(err)
+	}
+	fmt.Printf("%s\n", result)
+}
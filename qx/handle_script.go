@@ -0,0 +1,109 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package qx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+// MultisigNew returns the redeem script of a multisig requiring nRequired
+// signatures of the passed public keys.
+func MultisigNew(nRequired int, pubkeys []string) (string, error) {
+	// Standard multisig scripts encode the number of keys as a small
+	// integer.
+	if len(pubkeys) > 16 {
+		return "", fmt.Errorf("too many public keys %d, the maximum is 16",
+			len(pubkeys))
+	}
+	if nRequired < 1 || nRequired > len(pubkeys) {
+		return "", fmt.Errorf("invalid number of required signatures %d "+
+			"for %d public keys", nRequired, len(pubkeys))
+	}
+	keys := make([]*address.SecpPubKeyAddress, 0, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		data, err := hex.DecodeString(pubkey)
+		if err != nil {
+			return "", err
+		}
+		key, err := address.NewSecpPubKeyAddress(data, &params.MainNetParams)
+		if err != nil {
+			return "", fmt.Errorf("invalid public key %s: %v", pubkey, err)
+		}
+		keys = append(keys, key)
+	}
+	script, err := txscript.MultiSigScript(keys, nRequired)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(script), nil
+}
+
+func ScriptDecode(network string, scriptStr string) {
+	var param *params.Params
+	switch network {
+	case "mainnet":
+		param = &params.MainNetParams
+	case "testnet":
+		param = &params.TestNetParams
+	case "privnet":
+		param = &params.PrivNetParams
+	case "mixnet":
+		param = &params.MixNetParams
+	}
+	script, err := hex.DecodeString(scriptStr)
+	if err != nil {
+		ErrExit(err)
+	}
+	// The disassembled string will contain [error] inline if the script
+	// doesn't fully parse, so ignore the error here.
+	disbuf, _ := txscript.DisasmString(script)
+	scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(script, param)
+	addresses := make([]string, len(addrs))
+	for i, addr := range addrs {
+		addresses[i] = addr.Encode()
+	}
+
+	jsonScript := json.OrderedResult{
+		{Key: "asm", Val: disbuf},
+		{Key: "type", Val: scriptClass.String()},
+		{Key: "reqSigs", Val: reqSigs},
+		{Key: "addresses", Val: addresses},
+	}
+	// Scripts which are pay-to-script-hash already can't be wrapped again.
+	if scriptClass != txscript.ScriptHashTy {
+		p2sh, err := address.NewAddressScriptHashFromHash(hash.Hash160(script), param)
+		if err != nil {
+			ErrExit(err)
+		}
+		jsonScript = append(jsonScript, json.KV{Key: "p2sh", Val: p2sh.Encode()})
+	}
+	marshaledScript, err := jsonScript.MarshalJSON()
+	if err != nil {
+		ErrExit(err)
+	}
+
+	fmt.Printf("%s", marshaledScript)
+}
+
+func MultisigNewSTDO(nRequired int, pubkeys []string) {
+	script, err := MultisigNew(nRequired, pubkeys)
+	if err != nil {
+		ErrExit(err)
+	}
+	fmt.Printf("%s\n", script)
+}
+
+func ScriptToAddressSTDO(version string, script string) {
+	addr, err := EcScriptKeyToAddress(version, script)
+	if err != nil {
+		ErrExit(err)
+	}
+	fmt.Printf("%s\n", addr)
+}
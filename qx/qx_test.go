@@ -93,3 +93,43 @@ func TestCreateMixParamsSciptToHashAddress(t *testing.T) {
 	}
 
 }
+
+func TestMultisigNew(t *testing.T) {
+	pubkeys := []string{
+		"022ccb5fba6fda20c35949d53b6fa6b3b21a2cf725d535d95e2389210f3dae63e1",
+		"034f355bdcb7cc0af728ef3cceb9615d90684bb5b2ca5f859ab0f0b704075871aa",
+		"02466d7fcae563e5cb09a0d1870bb580344804617879a14949cf22285f1bae3f27",
+	}
+	s, err := MultisigNew(2, pubkeys)
+	assert.NoError(t, err)
+	assert.Equal(t, s, "5221022ccb5fba6fda20c35949d53b6fa6b3b21a2cf725d535d95e2389210f3dae63e121034f355bdcb7cc0af728ef3cceb9615d90684bb5b2ca5f859ab0f0b704075871aa2102466d7fcae563e5cb09a0d1870bb580344804617879a14949cf22285f1bae3f2753ae")
+	a, _ := EcScriptKeyToAddress("privnet", s)
+	assert.Equal(t, a, "RSXQ71L1S3s6iYsqwqJnJErDzd77BZuA4oP")
+
+	_, err = MultisigNew(4, pubkeys)
+	assert.Error(t, err)
+	_, err = MultisigNew(1, []string{"02addd"})
+	assert.Error(t, err)
+}
+
+func TestAddressToBech32(t *testing.T) {
+	s, err := AddressToBech32("RmMJas2Z9wGeXC7y5BMMuwoQ4G3rmCP7ZsG")
+	assert.NoError(t, err)
+	assert.Contains(t, s, "rmeer1")
+	b, err := AddressToBech32(s)
+	assert.NoError(t, err)
+	assert.Equal(t, s, b)
+	p, err := AddressToBech32("RSXQ71L1S3s6iYsqwqJnJErDzd77BZuA4oP")
+	assert.NoError(t, err)
+	assert.NotEqual(t, s, p)
+}
+
+func TestTxEncodeBech32(t *testing.T) {
+	inputs := map[string]uint32{"25517e3b3759365e80a164a3d4d2db2462c5d6888e4bd874c5fbfbb6fb130b41": 0}
+	b, err := AddressToBech32("Tmeyuj8ZBaQC8F47wNKxDmYAWUFti3XMrLb")
+	assert.NoError(t, err)
+	rs, err := TxEncode(1, 0, inputs, map[string]uint64{b: 2083509771})
+	assert.NoError(t, err)
+	expected, _ := TxEncode(1, 0, inputs, map[string]uint64{"Tmeyuj8ZBaQC8F47wNKxDmYAWUFti3XMrLb": 2083509771})
+	assert.Equal(t, rs, expected)
+}
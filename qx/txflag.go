@@ -128,3 +128,87 @@ func (of *TxOutputsFlag) Set(s string) error {
 		target, amount})
 	return nil
 }
+
+type PsbtPrevOutsFlag struct {
+	prevOuts []psbtPrevOut
+}
+type PsbtRedeemScriptsFlag struct {
+	redeemScripts []psbtRedeemScript
+}
+
+type psbtPrevOut struct {
+	index    int
+	amount   uint64
+	pkScript []byte
+}
+type psbtRedeemScript struct {
+	index  int
+	script []byte
+}
+
+func (o psbtPrevOut) String() string {
+	return fmt.Sprintf("%d:%d:%x", o.index, o.amount, o.pkScript)
+}
+func (r psbtRedeemScript) String() string {
+	return fmt.Sprintf("%d:%x", r.index, r.script)
+}
+
+func (pf PsbtPrevOutsFlag) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("{")
+	for _, o := range pf.prevOuts {
+		buffer.WriteString(o.String())
+	}
+	buffer.WriteString("}")
+	return buffer.String()
+}
+
+func (rf PsbtRedeemScriptsFlag) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("{")
+	for _, r := range rf.redeemScripts {
+		buffer.WriteString(r.String())
+	}
+	buffer.WriteString("}")
+	return buffer.String()
+}
+
+func (pf *PsbtPrevOutsFlag) Set(s string) error {
+	prevOut := strings.Split(s, ":")
+	if len(prevOut) != 3 {
+		return fmt.Errorf("error to parse psbt prevout : %s", s)
+	}
+	index, err := strconv.ParseUint(prevOut[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	amount, err := strconv.ParseUint(prevOut[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	pkScript, err := hex.DecodeString(prevOut[2])
+	if err != nil {
+		return err
+	}
+	pf.prevOuts = append(pf.prevOuts, psbtPrevOut{
+		int(index), amount, pkScript})
+	return nil
+}
+
+func (rf *PsbtRedeemScriptsFlag) Set(s string) error {
+	redeemScript := strings.Split(s, ":")
+	if len(redeemScript) != 2 {
+		return fmt.Errorf("error to parse psbt redeem script : %s", s)
+	}
+	index, err := strconv.ParseUint(redeemScript[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	script, err := hex.DecodeString(redeemScript[1])
+	if err != nil {
+		return err
+	}
+	rf.redeemScripts = append(rf.redeemScripts, psbtRedeemScript{
+		int(index), script})
+	return nil
+}
@@ -46,6 +46,7 @@ type RpcServer struct {
 
 	authsha                [sha256.Size]byte
 	numClients             int32
+	numWebsockets          int32
 	statusLines            map[int]string
 	requestProcessShutdown chan struct{}
 }
@@ -161,6 +162,12 @@ func (s *RpcServer) startHTTP(listenAddrs []string) error {
 		ReadTimeout: time.Second * rpcAuthTimeoutSeconds,
 	}
 	rpcServeMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// Websocket clients are served on the same endpoint.
+		if isWebsocketRequest(r) {
+			s.websocketHandler(w, r)
+			return
+		}
+
 		w.Header().Set("Connection", "close")
 		w.Header().Set("Content-Type", "application/json")
 		r.Close = true
@@ -318,6 +325,14 @@ func (s *RpcServer) ServeSingleRequest(ctx context.Context, codec ServerCodec, o
 	s.serveRequest(ctx, codec, true, options)
 }
 
+// ServeCodec reads incoming requests from codec, calls the appropriate callback and writes the
+// response back using the given codec. It will block until the codec is closed or the server is
+// stopped. In either case the codec is closed, which cancels all active subscriptions.
+func (s *RpcServer) ServeCodec(ctx context.Context, codec ServerCodec, options CodecOption) {
+	defer codec.Close()
+	s.serveRequest(ctx, codec, false, options)
+}
+
 // serveRequest will reads requests from the codec, calls the RPC callback and
 // writes the response to the given codec.
 //
@@ -0,0 +1,107 @@
+// Copyright (c) 2017-2019 The qitmeer developers
+//
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// The parts code inspired by
+// https://github.com/ethereum/go-ethereum/rpc
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Qitmeer/qitmeer/log"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// websocketJSONCodec is a custom JSON codec with payload size enforcement and
+// special number parsing.
+var websocketJSONCodec = websocket.Codec{
+	// Marshal is the stock JSON marshaller used by the websocket library too.
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		msg, err := json.Marshal(v)
+		return msg, websocket.TextFrame, err
+	},
+	// Unmarshal is a specialized unmarshaller to properly convert numbers.
+	Unmarshal: func(msg []byte, payloadType byte, v interface{}) error {
+		dec := json.NewDecoder(bytes.NewReader(msg))
+		dec.UseNumber()
+
+		return dec.Decode(v)
+	},
+}
+
+// isWebsocketRequest returns whether the request asks to upgrade the
+// connection to the websocket protocol.
+func isWebsocketRequest(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// limitWebsockets returns true and responds with a 503 service unavailable if
+// adding another websocket client would exceed the maximum allowed websocket
+// clients.
+//
+// This function is safe for concurrent access.
+func (s *RpcServer) limitWebsockets(w http.ResponseWriter, remoteAddr string) bool {
+	if int(atomic.LoadInt32(&s.numWebsockets)+1) > s.config.RPCMaxWebsockets {
+		log.Info("Max websocket clients exceeded", "max", s.config.RPCMaxWebsockets,
+			"client", remoteAddr)
+		http.Error(w, "503 Too busy.  Try again later.",
+			http.StatusServiceUnavailable)
+		return true
+	}
+	return false
+}
+
+// websocketHandler upgrades the request to a websocket connection which serves
+// RPC calls and subscriptions until the client disconnects.
+func (s *RpcServer) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.run) != 1 { // server stopped
+		return
+	}
+	if s.limitWebsockets(w, r.RemoteAddr) {
+		return
+	}
+	_, err := s.checkAuth(r, true)
+	if err != nil {
+		jsonAuthFail(w)
+		return
+	}
+
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, "remote", r.RemoteAddr)
+	ctx = context.WithValue(ctx, "scheme", "ws")
+	ctx = context.WithValue(ctx, "local", r.Host)
+
+	atomic.AddInt32(&s.numWebsockets, 1)
+	defer atomic.AddInt32(&s.numWebsockets, -1)
+
+	// The origin is not checked since the clients are authenticated and
+	// usually are not browsers.
+	wsServer := websocket.Server{
+		Handler: func(conn *websocket.Conn) {
+			// Clear the read deadline set by the http server for
+			// the handshake, a websocket connection stays open.
+			conn.SetReadDeadline(time.Time{})
+			conn.MaxPayloadBytes = maxRequestContentLength
+
+			log.Debug("New websocket client", "remote", r.RemoteAddr)
+			encoder := func(v interface{}) error {
+				return websocketJSONCodec.Send(conn, v)
+			}
+			decoder := func(v interface{}) error {
+				return websocketJSONCodec.Receive(conn, v)
+			}
+			s.ServeCodec(ctx, NewCodec(conn, encoder, decoder),
+				OptionMethodInvocation|OptionSubscriptions)
+			log.Debug("Websocket client disconnected", "remote", r.RemoteAddr)
+		},
+	}
+	wsServer.ServeHTTP(w, r)
+}
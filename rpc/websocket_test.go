@@ -0,0 +1,237 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/Qitmeer/qitmeer/config"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testService is served to the websocket clients of the tests.  Its ticks
+// subscription notifies the client until it disconnects, and is passed to
+// the test so it can wait for the disconnect.
+type testService struct {
+	subs chan *Notifier
+}
+
+func (s *testService) Echo(str string) string {
+	return str
+}
+
+func (s *testService) Ticks(ctx context.Context) (*Subscription, error) {
+	notifier, supported := NotifierFromContext(ctx)
+	if !supported {
+		return nil, ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	// The notifications are dropped until the subscription is activated
+	// once its id is sent, so they are repeated.
+	go func() {
+		for {
+			select {
+			case <-notifier.Closed():
+				return
+			case <-time.After(10 * time.Millisecond):
+				notifier.Notify(sub.ID, "tick")
+			}
+		}
+	}()
+	s.subs <- notifier
+	return sub, nil
+}
+
+// newTestWebsocketServer returns a running rpc server serving the test
+// service to the websocket clients of the returned url.
+func newTestWebsocketServer(t *testing.T, maxWebsockets int) (*RpcServer, *testService, *httptest.Server) {
+	s, err := NewRPCServer(&config.Config{
+		RPCUser:          "user",
+		RPCPass:          "pass",
+		RPCMaxWebsockets: maxWebsockets,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &testService{subs: make(chan *Notifier, 1)}
+	if err := s.RegisterService("test", svc); err != nil {
+		t.Fatal(err)
+	}
+	s.run = 1
+	return s, svc, httptest.NewServer(http.HandlerFunc(s.websocketHandler))
+}
+
+// dialTestWebsocket connects to the server with the passed credentials.
+func dialTestWebsocket(server *httptest.Server, user, pass string) (*websocket.Conn, error) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	cfg, err := websocket.NewConfig(url, server.URL)
+	if err != nil {
+		return nil, err
+	}
+	if user != "" {
+		cfg.Header.Set("Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(user+":"+pass)))
+	}
+	return websocket.DialConfig(cfg)
+}
+
+// testMessage is a response or a notification read by the client.
+type testMessage struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Params struct {
+		Subscription string `json:"subscription"`
+		Result       string `json:"result"`
+	} `json:"params"`
+	Error *jsonError `json:"error"`
+}
+
+// call sends the request and returns the response, skipping the
+// notifications.
+func call(t *testing.T, conn *websocket.Conn, id int, method string, params ...interface{}) *testMessage {
+	req := map[string]interface{}{
+		"jsonrpc": jsonrpcVersion,
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	}
+	if err := websocket.JSON.Send(conn, req); err != nil {
+		t.Fatalf("Failed to send %s: %v", method, err)
+	}
+	for {
+		var msg testMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("Failed to receive the response of %s: %v", method, err)
+		}
+		if msg.Method == "" {
+			return &msg
+		}
+	}
+}
+
+// waitWebsockets fails the test unless the number of websocket clients drops
+// to the passed number.
+func waitWebsockets(t *testing.T, s *RpcServer, want int32) {
+	for i := 0; atomic.LoadInt32(&s.numWebsockets) != want; i++ {
+		if i == 500 {
+			t.Fatalf("Got %d websocket clients, want %d",
+				atomic.LoadInt32(&s.numWebsockets), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestWebsocketAuth checks that only the clients with the rpc credentials
+// are upgraded to a websocket connection.
+func TestWebsocketAuth(t *testing.T) {
+	s, _, server := newTestWebsocketServer(t, 1)
+	defer server.Close()
+
+	tests := []struct {
+		name, user, pass string
+	}{
+		{"no credentials", "", ""},
+		{"wrong user", "resu", "pass"},
+		{"wrong password", "user", "ssap"},
+	}
+	for _, test := range tests {
+		conn, err := dialTestWebsocket(server, test.user, test.pass)
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s: websocket connection was accepted", test.name)
+		}
+	}
+	if n := atomic.LoadInt32(&s.numWebsockets); n != 0 {
+		t.Fatalf("Rejected clients were counted: %d", n)
+	}
+
+	conn, err := dialTestWebsocket(server, "user", "pass")
+	if err != nil {
+		t.Fatalf("Failed to connect with the credentials: %v", err)
+	}
+	defer conn.Close()
+	resp := call(t, conn, 1, "test_echo", "hello")
+	if resp.Error != nil || string(resp.Result) != `"hello"` {
+		t.Fatalf("Got result %s, error %v", resp.Result, resp.Error)
+	}
+}
+
+// TestWebsocketLimit checks that the clients beyond RPCMaxWebsockets are
+// rejected until another client disconnects.
+func TestWebsocketLimit(t *testing.T) {
+	s, _, server := newTestWebsocketServer(t, 2)
+	defer server.Close()
+
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := dialTestWebsocket(server, "user", "pass")
+		if err != nil {
+			t.Fatalf("Failed to connect client %d: %v", i, err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitWebsockets(t, s, 2)
+	if conn, err := dialTestWebsocket(server, "user", "pass"); err == nil {
+		conn.Close()
+		t.Fatalf("Client beyond the limit was accepted")
+	}
+
+	// The requests of the connected clients are still served.
+	resp := call(t, conns[1], 1, "test_echo", "busy")
+	if resp.Error != nil || string(resp.Result) != `"busy"` {
+		t.Fatalf("Got result %s, error %v", resp.Result, resp.Error)
+	}
+
+	conns[0].Close()
+	waitWebsockets(t, s, 1)
+	conn, err := dialTestWebsocket(server, "user", "pass")
+	if err != nil {
+		t.Fatalf("Failed to connect after a client disconnected: %v", err)
+	}
+	conn.Close()
+}
+
+// TestWebsocketSubscription checks that the notifications of a subscription
+// are delivered to its client, and that the subscription is closed when the
+// client disconnects.
+func TestWebsocketSubscription(t *testing.T) {
+	s, svc, server := newTestWebsocketServer(t, 1)
+	defer server.Close()
+
+	conn, err := dialTestWebsocket(server, "user", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := call(t, conn, 1, "test_subscribe", "ticks")
+	var id string
+	if resp.Error != nil || json.Unmarshal(resp.Result, &id) != nil {
+		t.Fatalf("Failed to subscribe: %s, %v", resp.Result, resp.Error)
+	}
+	notifier := <-svc.subs
+
+	var msg testMessage
+	if err := websocket.JSON.Receive(conn, &msg); err != nil {
+		t.Fatalf("Failed to receive the notification: %v", err)
+	}
+	if msg.Method != "test"+notificationMethodSuffix ||
+		msg.Params.Subscription != id || msg.Params.Result != "tick" {
+		t.Fatalf("Got notification %+v of subscription %s", msg, id)
+	}
+
+	conn.Close()
+	select {
+	case <-notifier.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Subscription wasn't closed when the client disconnected")
+	}
+	waitWebsockets(t, s, 0)
+}
@@ -112,6 +112,35 @@ function get_mempool(){
   get_result "$data"
 }
 
+function get_mempool_info(){
+  local data='{"jsonrpc":"2.0","method":"getMempoolInfo","params":[],"id":1}'
+  get_result "$data"
+}
+
+function get_mempool_removal(){
+  local tx_hash=$1
+  local data='{"jsonrpc":"2.0","method":"getMempoolRemoval","params":["'$tx_hash'"],"id":1}'
+  get_result "$data"
+}
+
+function estimate_fee(){
+  local num_blocks=$1
+  local data='{"jsonrpc":"2.0","method":"estimateFee","params":['$num_blocks'],"id":1}'
+  get_result "$data"
+}
+
+function estimate_smart_fee(){
+  local conf_target=$1
+  local data='{"jsonrpc":"2.0","method":"estimateSmartFee","params":['$conf_target'],"id":1}'
+  get_result "$data"
+}
+
+function get_mempool_entry(){
+  local tx_hash=$1
+  local data='{"jsonrpc":"2.0","method":"getMempoolEntry","params":["'$tx_hash'"],"id":1}'
+  get_result "$data"
+}
+
 # return block by hash
 #   func (s *PublicBlockChainAPI) GetBlockByHash(ctx context.Context, blockHash common.Hash, fullTx bool) (map[string]interface{}, error)
 function get_block_by_hash(){
@@ -158,6 +187,68 @@ function get_utxo() {
   get_result "$data"
 }
 
+function get_spending_tx() {
+  local tx_hash=$1
+  local vout=$2
+  local data='{"jsonrpc":"2.0","method":"getSpendingTx","params":["'$tx_hash'",'$vout'],"id":1}'
+  get_result "$data"
+}
+
+function get_address_balance() {
+  local address=$1
+  local data='{"jsonrpc":"2.0","method":"getAddressBalance","params":["'$address'"],"id":1}'
+  get_result "$data"
+}
+
+function get_address_utxos() {
+  local address=$1
+  local skip=$2
+  local count=$3
+  if [ "$skip" == "" ]; then
+    skip=0
+  fi
+  if [ "$count" == "" ]; then
+    count=100
+  fi
+  local data='{"jsonrpc":"2.0","method":"getAddressUtxos","params":["'$address'",'$skip','$count'],"id":1}'
+  get_result "$data"
+}
+
+function get_address_deltas() {
+  local address=$1
+  local start=$2
+  local end=$3
+  local skip=$4
+  local count=$5
+  if [ "$start" == "" ]; then
+    start=0
+  fi
+  if [ "$end" == "" ]; then
+    end=null
+  fi
+  if [ "$skip" == "" ]; then
+    skip=0
+  fi
+  if [ "$count" == "" ]; then
+    count=100
+  fi
+  local data='{"jsonrpc":"2.0","method":"getAddressDeltas","params":["'$address'",'$start','$end','$skip','$count'],"id":1}'
+  get_result "$data"
+}
+
+function get_block_stats() {
+  local hash_or_order=$1
+  local data='{"jsonrpc":"2.0","method":"getBlockStats","params":["'$hash_or_order'"],"id":1}'
+  get_result "$data"
+}
+
+function get_block_stats_by_range() {
+  local start=$1
+  local end=$2
+  local data='{"jsonrpc":"2.0","method":"getBlockStatsByRange","params":['$start','$end'],"id":1}'
+  get_result "$data"
+}
+
 function tx_sign(){
    local private_key=$1
    local raw_tx=$2
@@ -189,6 +280,81 @@ function send_raw_tx(){
   get_result "$data"
 }
 
+function bump_fee(){
+  local tx_hash=$1
+  local change_vout=$2
+  local fee_rate=$3
+  if [ "$fee_rate" == "" ]; then
+    fee_rate="null"
+  fi
+  local data='{"jsonrpc":"2.0","method":"bumpFee","params":["'$tx_hash'",'$change_vout','$fee_rate'],"id":1}'
+  get_result "$data"
+}
+
+function create_psbt(){
+  local input=$1
+  local data='{"jsonrpc":"2.0","method":"createPsbt","params":['$input'],"id":1}'
+  get_result "$data"
+}
+
+function update_psbt(){
+  local psbt=$1
+  local redeem_scripts=$2
+  if [ "$redeem_scripts" == "" ]; then
+    redeem_scripts="null"
+  fi
+  local data='{"jsonrpc":"2.0","method":"updatePsbt","params":["'$psbt'",'$redeem_scripts'],"id":1}'
+  get_result "$data"
+}
+
+function sign_psbt(){
+  local private_key=$1
+  local psbt=$2
+  local data='{"jsonrpc":"2.0","method":"test_signPsbt","params":["'$private_key'","'$psbt'"],"id":1}'
+  get_result "$data"
+}
+
+function combine_psbt(){
+  local psbts=""
+  for psbt in $@; do
+    psbts=$psbts',"'$psbt'"'
+  done
+  local data='{"jsonrpc":"2.0","method":"combinePsbt","params":[['${psbts:1}']],"id":1}'
+  get_result "$data"
+}
+
+function finalize_psbt(){
+  local psbt=$1
+  local extract=$2
+  if [ "$extract" == "" ]; then
+    extract="true"
+  fi
+  local data='{"jsonrpc":"2.0","method":"finalizePsbt","params":["'$psbt'",'$extract'],"id":1}'
+  get_result "$data"
+}
+
+function create_multisig(){
+  local required=$1
+  shift
+  local keys=""
+  for key in $@; do
+    keys=$keys',"'$key'"'
+  done
+  local data='{"jsonrpc":"2.0","method":"createMultisig","params":['$required',['${keys:1}']],"id":1}'
+  get_result "$data"
+}
+
+function add_multisig_address(){
+  local required=$1
+  shift
+  local keys=""
+  for key in $@; do
+    keys=$keys',"'$key'"'
+  done
+  local data='{"jsonrpc":"2.0","method":"addMultisigAddress","params":['$required',['${keys:1}']],"id":1}'
+  get_result "$data"
+}
+
 function generate() {
   local count=$1
   local powtype=$2
@@ -213,7 +379,13 @@ function is_on_mainchain(){
 
 function get_block_template(){
   local capabilities=$1
-  local data='{"jsonrpc":"2.0","method":"getBlockTemplate","params":[["'$capabilities'"]],"id":1}'
+  local longpollid=$2
+  if [ "$longpollid" == "" ]; then
+    longpollid=null
+  else
+    longpollid='"'$longpollid'"'
+  fi
+  local data='{"jsonrpc":"2.0","method":"getBlockTemplate","params":[["'$capabilities'"],'$longpollid'],"id":1}'
   get_result "$data"
 }
 
@@ -291,6 +463,25 @@ function is_blue(){
   get_result "$data"
 }
 
+function get_deployment_info(){
+  local data='{"jsonrpc":"2.0","method":"getDeploymentInfo","params":[],"id":1}'
+  get_result "$data"
+}
+
+function get_block_receipt(){
+  local block_hash=$1
+  local data='{"jsonrpc":"2.0","method":"getBlockReceipt","params":["'$block_hash'"],"id":1}'
+  get_result "$data"
+}
+
+function get_state_proof(){
+  local block_hash=$1
+  local txid=$2
+  local vout=$3
+  local data='{"jsonrpc":"2.0","method":"getStateProof","params":["'$block_hash'","'$txid'",'$vout'],"id":1}'
+  get_result "$data"
+}
+
 function get_result(){
   local proto="https"
   if [ $notls -eq 1 ]; then
@@ -356,16 +547,40 @@ function usage(){
   echo "  weight <hash>"
   echo "  orphanstotal"
   echo "  isblue <hash>   ;return [0:not blue;  1：blue  2：Cannot confirm]"
+  echo "  deployments"
+  echo "  receipt <hash>"
+  echo "  stateproof <hash> <txid> <vout>"
+  echo "  blockstats <num|hash>"
+  echo "  blockstatsrange <start> <end>"
   echo "tx     :"
   echo "  tx <hash>"
   echo "  createRawTx"
   echo "  txSign <rawTx>"
   echo "  sendRawTx <signedRawTx>"
+  echo "  bumpfee <tx_id> <change_vout> <fee_rate,optional>"
   echo "  getrawtxs <address>"
+  echo "  createpsbt <inputs> <amounts>"
+  echo "  updatepsbt <psbt> <redeem_scripts,optional>"
+  echo "  signpsbt <private_key> <psbt>"
+  echo "  combinepsbt <psbt> <psbt> ..."
+  echo "  finalizepsbt <psbt> <extract,optional>"
+  echo "  createmultisig <required> <pubkey> <pubkey> ..."
+  echo "  addmultisigaddress <required> <pubkey|address> <pubkey|address> ..."
+  echo "mempool:"
+  echo "  mempool <type> <verbose>"
+  echo "  mempoolentry <tx_id>"
+  echo "  mempoolinfo"
+  echo "  mempoolremoval <tx_id>"
+  echo "  estimatefee <num_blocks>"
+  echo "  estimatesmartfee <conf_target>"
   echo "utxo   :"
   echo "  getutxo <tx_id> <index> <include_mempool,default=true>"
+  echo "  getspendingtx <tx_id> <index>"
+  echo "  getaddressbalance <address>"
+  echo "  getaddressutxos <address> <skip,default=0> <count,default=100>"
+  echo "  getaddressdeltas <address> <start_order,default=0> <end_order> <skip,default=0> <count,default=100>"
   echo "miner  :"
-  echo "  template"
+  echo "  template <capabilities> <longpollid>"
   echo "  generate <num>"
 }
 
@@ -619,7 +834,7 @@ elif [ "$1" == "main" ]; then
 
 elif [ "$1" == "template" ]; then
     shift
-    get_block_template $1 | jq .
+    get_block_template $@ | jq .
 
 elif [ "$1" == "mainHeight" ]; then
     shift
@@ -633,10 +848,30 @@ elif [ "$1" == "blockrange" ]; then
   shift
   get_blockhash_range $@
 
+elif [ "$1" == "blockstats" ]; then
+  shift
+  get_block_stats $@|jq .
+
+elif [ "$1" == "blockstatsrange" ]; then
+  shift
+  get_block_stats_by_range $@|jq .
+
 elif [ "$1" == "isblue" ]; then
   shift
   is_blue $@
 
+elif [ "$1" == "deployments" ]; then
+  shift
+  get_deployment_info|jq .
+
+elif [ "$1" == "receipt" ]; then
+  shift
+  get_block_receipt $1|jq .
+
+elif [ "$1" == "stateproof" ]; then
+  shift
+  get_state_proof $@|jq .
+
 elif [ "$1" == "nodeinfo" ]; then
   shift
   get_node_info | jq .
@@ -674,6 +909,38 @@ elif [ "$1" == "sendRawTx" ]; then
   shift
   send_raw_tx $@
 
+elif [ "$1" == "bumpfee" ]; then
+  shift
+  bump_fee $@|jq .
+
+elif [ "$1" == "createpsbt" ]; then
+  shift
+  create_psbt $@
+
+elif [ "$1" == "updatepsbt" ]; then
+  shift
+  update_psbt $@
+
+elif [ "$1" == "signpsbt" ]; then
+  shift
+  sign_psbt $@
+
+elif [ "$1" == "combinepsbt" ]; then
+  shift
+  combine_psbt $@
+
+elif [ "$1" == "finalizepsbt" ]; then
+  shift
+  finalize_psbt $@|jq .
+
+elif [ "$1" == "createmultisig" ]; then
+  shift
+  create_multisig $@|jq .
+
+elif [ "$1" == "addmultisigaddress" ]; then
+  shift
+  add_multisig_address $@|jq .
+
 elif [ "$1" == "getrawtxs" ]; then
   shift
   get_rawtxs $@
@@ -688,6 +955,26 @@ elif [ "$1" == "mempool" ]; then
   shift
   get_mempool $@|jq .
 
+elif [ "$1" == "mempoolinfo" ]; then
+  shift
+  get_mempool_info|jq .
+
+elif [ "$1" == "mempoolentry" ]; then
+  shift
+  get_mempool_entry $@|jq .
+
+elif [ "$1" == "mempoolremoval" ]; then
+  shift
+  get_mempool_removal $@|jq .
+
+elif [ "$1" == "estimatefee" ]; then
+  shift
+  estimate_fee $@|jq .
+
+elif [ "$1" == "estimatesmartfee" ]; then
+  shift
+  estimate_smart_fee $@|jq .
+
 
 elif [ "$1" == "txSign" ]; then
   shift
@@ -699,6 +986,18 @@ elif [ "$1" == "txSign" ]; then
 elif [ "$1" == "getutxo" ]; then
   shift
   get_utxo $@|jq .
+elif [ "$1" == "getspendingtx" ]; then
+  shift
+  get_spending_tx $@|jq .
+elif [ "$1" == "getaddressbalance" ]; then
+  shift
+  get_address_balance $@|jq .
+elif [ "$1" == "getaddressutxos" ]; then
+  shift
+  get_address_utxos $@|jq .
+elif [ "$1" == "getaddressdeltas" ]; then
+  shift
+  get_address_deltas $@|jq .
 
 ## Accounts
 elif [ "$1" == "newaccount" ]; then
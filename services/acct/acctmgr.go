@@ -1,35 +1,749 @@
 package acct
 
 import (
-	"github.com/Qitmeer/qitmeer/log"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/bip32"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/node/notify"
+	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/blkmgr"
+	"github.com/Qitmeer/qitmeer/wallet"
+	"sort"
+	"sync"
+	"time"
 )
 
+var (
+	// ErrWalletLocked is returned by the wallet RPCs until the wallet is
+	// unlocked with its passphrase.
+	ErrWalletLocked = errors.New("the wallet is locked (unlock it with walletPassphrase)")
+
+	// ErrInsufficientFunds is returned when the spendable outputs of the
+	// wallet do not cover the requested amount plus fee.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// walletKey is a key derived from the wallet account together with the
+// pay-to-pubkey-hash script it controls.  Multisig addresses added to the
+// wallet are watched with a walletKey without private key, which holds the
+// redeem script of the pay-to-script-hash script instead.
+type walletKey struct {
+	index        uint32
+	privKey      ecc.PrivateKey
+	addr         types.Address
+	pkScript     []byte
+	redeemScript []byte
+}
+
+// watchOnly returns whether the wallet can't sign for the key on its own.
+func (k *walletKey) watchOnly() bool {
+	return k.privKey == nil
+}
+
+// walletUtxo is an unspent output paying to one of the wallet keys.
+type walletUtxo struct {
+	amount    uint64
+	blockHash hash.Hash
+	coinbase  bool
+	key       *walletKey
+}
+
+// walletChain is the state of the block chain the wallet tracks its outputs
+// with.
+type walletChain interface {
+	FetchUtxoEntry(outpoint types.TxOutPoint) (*blockchain.UtxoEntry, error)
+	ForEachUtxoEntry(fn func(outpoint types.TxOutPoint, entry *blockchain.UtxoEntry) error) error
+	GetConfirmations(h *hash.Hash) uint
+	IsBlue(h *hash.Hash) bool
+	HaveTransaction(h *hash.Hash) bool
+}
+
+// blkmgrChain is the wallet chain of the block manager.
+type blkmgrChain struct {
+	bm *blkmgr.BlockManager
+}
+
+func (c *blkmgrChain) FetchUtxoEntry(outpoint types.TxOutPoint) (*blockchain.UtxoEntry, error) {
+	return c.bm.GetChain().FetchUtxoEntry(outpoint)
+}
+
+func (c *blkmgrChain) ForEachUtxoEntry(fn func(outpoint types.TxOutPoint, entry *blockchain.UtxoEntry) error) error {
+	return c.bm.GetChain().ForEachUtxoEntry(fn)
+}
+
+func (c *blkmgrChain) GetConfirmations(h *hash.Hash) uint {
+	return c.bm.GetChain().BlockDAG().GetConfirmations(h)
+}
+
+func (c *blkmgrChain) IsBlue(h *hash.Hash) bool {
+	return c.bm.GetChain().BlockDAG().IsBlue(h)
+}
+
+func (c *blkmgrChain) HaveTransaction(h *hash.Hash) bool {
+	return c.bm.GetChain().GetTxManager().MemPool().HaveTransaction(h)
+}
+
 // account manager communicate with various backends for signing transactions.
 type AccountManager struct {
+	cfg    *config.Config
+	params *params.Params
+	bm     *blkmgr.BlockManager
+	ntmgr  notify.Notify
+	chain  walletChain
+
+	lock    sync.RWMutex
+	wallet  *walletFile
+	account *bip32.Key
+	keys    []*walletKey
+	scripts map[string]*walletKey
+	utxos   map[types.TxOutPoint]*walletUtxo
+
+	// pending records the outputs spent by transactions which were sent by
+	// the wallet but are not in a block yet.
+	pending map[types.TxOutPoint]hash.Hash
+
+	// lockTimer locks the wallet again when it was unlocked with a timeout.
+	lockTimer *time.Timer
 }
 
 func (a *AccountManager) Start() error {
 	log.Debug("Starting account manager")
+	a.bm.Subscribe(a.handleNotifyMsg)
 	return nil
 }
 
 func (a *AccountManager) Stop() error {
 	log.Debug("Stopping account manager")
+	a.lockWallet()
 	return nil
 }
 
-func (a AccountManager) APIs() []rpc.API {
+func (a *AccountManager) APIs() []rpc.API {
 	return []rpc.API{
 		{
 			NameSpace: rpc.DefaultServiceNameSpace,
-			Service:   NewPublicAccountManagerAPI(&a),
+			Service:   NewPublicAccountManagerAPI(a),
 			Public:    true,
 		},
 	}
 }
 
-func New() (*AccountManager, error) {
-	a := AccountManager{}
+// unlocked returns whether the wallet is unlocked.
+//
+// This function MUST be called with the lock held.
+func (a *AccountManager) unlocked() bool {
+	return a.account != nil
+}
+
+// unlockWallet unlocks the wallet with the passphrase, and rescans the utxo
+// set for its outputs.  The wallet is locked again after the timeout, unless
+// it is zero.  The passphrase of a wallet which is already unlocked is checked
+// and its timeout is reset.
+func (a *AccountManager) unlockWallet(pass string, timeout time.Duration) error {
+	a.lock.Lock()
+	wasUnlocked := a.unlocked()
+	if wasUnlocked {
+		if _, err := decryptSeed(&a.wallet.Crypto, pass); err != nil {
+			a.lock.Unlock()
+			return err
+		}
+	} else if err := a.loadWallet(pass); err != nil {
+		a.resetWallet()
+		a.lock.Unlock()
+		return err
+	}
+	if a.lockTimer != nil {
+		a.lockTimer.Stop()
+		a.lockTimer = nil
+	}
+	if timeout > 0 {
+		a.lockTimer = time.AfterFunc(timeout, a.lockWallet)
+	}
+	a.lock.Unlock()
+
+	if wasUnlocked {
+		return nil
+	}
+	return a.rescan()
+}
+
+// lockWallet locks the wallet, dropping its keys and outputs from memory.
+func (a *AccountManager) lockWallet() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.lockTimer != nil {
+		a.lockTimer.Stop()
+		a.lockTimer = nil
+	}
+	if a.unlocked() {
+		log.Info("Wallet locked")
+	}
+	a.resetWallet()
+}
+
+// resetWallet drops the unlocked wallet.
+//
+// This function MUST be called with the lock held (for writes).
+func (a *AccountManager) resetWallet() {
+	a.wallet = nil
+	a.account = nil
+	a.keys = nil
+	a.scripts = make(map[string]*walletKey)
+	a.utxos = make(map[types.TxOutPoint]*walletUtxo)
+	a.pending = make(map[types.TxOutPoint]hash.Hash)
+}
+
+// loadWallet unlocks the wallet file with the passphrase, or creates a new
+// wallet from a fresh random seed when the file does not exist yet, and
+// derives all of the keys which have been handed out so far.
+//
+// This function MUST be called with the lock held (for writes).
+func (a *AccountManager) loadWallet(pass string) error {
+	w, err := loadWalletFile(a.cfg.WalletFile)
+	if err != nil {
+		return err
+	}
+
+	var seed []byte
+	if w == nil {
+		path := wallet.QitmeerRootDerivationPath
+		if a.cfg.WalletPath != "" {
+			path, err = wallet.ParseDerivationPath(a.cfg.WalletPath)
+			if err != nil {
+				return err
+			}
+		}
+		seed, err = bip32.NewSeed()
+		if err != nil {
+			return err
+		}
+		c, err := encryptSeed(seed, pass)
+		if err != nil {
+			return err
+		}
+		w = &walletFile{
+			Version:   walletFileVersion,
+			Path:      path.String(),
+			NextIndex: 1,
+			Crypto:    *c,
+		}
+		if err := writeWalletFile(a.cfg.WalletFile, w); err != nil {
+			return err
+		}
+		log.Info("Created new wallet", "file", a.cfg.WalletFile, "path", w.Path)
+	} else {
+		seed, err = decryptSeed(&w.Crypto, pass)
+		if err != nil {
+			return err
+		}
+		if a.cfg.WalletPath != "" {
+			path, err := wallet.ParseDerivationPath(a.cfg.WalletPath)
+			if err != nil {
+				return err
+			}
+			if path.String() != w.Path {
+				return fmt.Errorf("wallet derivation path %s does not match "+
+					"the path %s of the wallet file", path, w.Path)
+			}
+		}
+	}
+
+	path, err := wallet.ParseDerivationPath(w.Path)
+	if err != nil {
+		return err
+	}
+	key, err := bip32.NewMasterKey2(seed, bip32.Bip32Version{
+		PrivKeyVersion: a.params.HDPrivateKeyID[:],
+		PubKeyVersion:  a.params.HDPublicKeyID[:],
+	})
+	if err != nil {
+		return err
+	}
+	for _, idx := range path {
+		key, err = key.NewChildKey(idx)
+		if err != nil {
+			return err
+		}
+	}
+	a.wallet = w
+	a.account = key
+
+	for i := uint32(0); i < w.NextIndex; i++ {
+		if _, err := a.deriveKey(i); err != nil {
+			return err
+		}
+	}
+	for _, s := range w.Multisig {
+		redeemScript, err := hex.DecodeString(s)
+		if err != nil {
+			return fmt.Errorf("invalid multisig redeem script %s: %v", s, err)
+		}
+		if _, err := a.watchScript(redeemScript); err != nil {
+			return err
+		}
+	}
+	log.Info("Loaded wallet", "file", a.cfg.WalletFile, "path", w.Path,
+		"addresses", len(a.keys), "multisig", len(w.Multisig))
+	return nil
+}
+
+// deriveKey derives the key at the given index of the wallet account and
+// starts watching its script.
+//
+// This function MUST be called with the lock held (for writes).
+func (a *AccountManager) deriveKey(index uint32) (*walletKey, error) {
+	child, err := a.account.NewChildKey(index)
+	if err != nil {
+		return nil, err
+	}
+	privKey, pubKey := ecc.Secp256k1.PrivKeyFromBytes(child.Key)
+	h160 := hash.Hash160(pubKey.SerializeCompressed())
+	addr, err := address.NewPubKeyHashAddress(h160, a.params, ecc.ECDSA_Secp256k1)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	k := &walletKey{
+		index:    index,
+		privKey:  privKey,
+		addr:     addr,
+		pkScript: pkScript,
+	}
+	a.keys = append(a.keys, k)
+	a.scripts[string(pkScript)] = k
+	return k, nil
+}
+
+// newAddress hands out the next address of the wallet account and persists
+// the new address index.
+func (a *AccountManager) newAddress() (types.Address, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.unlocked() {
+		return nil, ErrWalletLocked
+	}
+	k, err := a.deriveKey(a.wallet.NextIndex)
+	if err != nil {
+		return nil, err
+	}
+	a.wallet.NextIndex++
+	if err := writeWalletFile(a.cfg.WalletFile, a.wallet); err != nil {
+		return nil, err
+	}
+	return k.addr, nil
+}
+
+// watchScript starts watching the pay-to-script-hash script of the passed
+// redeem script.
+//
+// This function MUST be called with the lock held (for writes).
+func (a *AccountManager) watchScript(redeemScript []byte) (*walletKey, error) {
+	addr, err := address.NewAddressScriptHashFromHash(hash.Hash160(redeemScript),
+		a.params)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	k := &walletKey{
+		addr:         addr,
+		pkScript:     pkScript,
+		redeemScript: redeemScript,
+	}
+	a.scripts[string(pkScript)] = k
+	return k, nil
+}
+
+// addMultisigAddress adds the multisig requiring nRequired signatures of the
+// passed keys to the wallet and persists its redeem script.  The keys are
+// either hex-encoded public keys or addresses of the wallet.  The wallet is
+// rescanned for the outputs the new address already received.
+func (a *AccountManager) addMultisigAddress(nRequired int, keys []string) (*walletKey, error) {
+	a.lock.Lock()
+	if !a.unlocked() {
+		a.lock.Unlock()
+		return nil, ErrWalletLocked
+	}
+	pubKeys := make([]*address.SecpPubKeyAddress, 0, len(keys))
+	for _, key := range keys {
+		pubKey, err := a.lookupPubKey(key)
+		if err != nil {
+			a.lock.Unlock()
+			return nil, err
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	redeemScript, err := txscript.MultiSigScript(pubKeys, nRequired)
+	if err != nil {
+		a.lock.Unlock()
+		return nil, err
+	}
+	pkScript, err := txscript.PayToScriptHashScript(hash.Hash160(redeemScript))
+	if err != nil {
+		a.lock.Unlock()
+		return nil, err
+	}
+	if k, ok := a.scripts[string(pkScript)]; ok {
+		a.lock.Unlock()
+		return k, nil
+	}
+	k, err := a.watchScript(redeemScript)
+	if err != nil {
+		a.lock.Unlock()
+		return nil, err
+	}
+	a.wallet.Multisig = append(a.wallet.Multisig, hex.EncodeToString(redeemScript))
+	err = writeWalletFile(a.cfg.WalletFile, a.wallet)
+	a.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return k, a.rescan()
+}
+
+// lookupPubKey returns the public key of the passed hex-encoded public key
+// or wallet address.
+//
+// This function MUST be called with the lock held.
+func (a *AccountManager) lookupPubKey(key string) (*address.SecpPubKeyAddress, error) {
+	if serializedPubKey, err := hex.DecodeString(key); err == nil {
+		return address.NewSecpPubKeyAddress(serializedPubKey, a.params)
+	}
+	// Wallet addresses are matched by hash, whatever their encoding.
+	if addr, err := address.DecodeAddress(key); err == nil {
+		if _, ok := addr.(*address.PubKeyHashAddress); ok {
+			for _, k := range a.keys {
+				if *k.addr.Hash160() != *addr.Hash160() {
+					continue
+				}
+				pubX, pubY := k.privKey.Public()
+				pubKey := ecc.Secp256k1.NewPublicKey(pubX, pubY)
+				return address.NewSecpPubKeyCompressedAddress(pubKey, a.params)
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s is neither a public key nor an address of "+
+		"the wallet", key)
+}
+
+// rescan rebuilds the wallet outputs from the utxo set of the block chain.
+func (a *AccountManager) rescan() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.unlocked() {
+		return nil
+	}
+	a.utxos = make(map[types.TxOutPoint]*walletUtxo)
+	err := a.chain.ForEachUtxoEntry(func(outpoint types.TxOutPoint,
+		entry *blockchain.UtxoEntry) error {
+		k, ok := a.scripts[string(entry.PkScript())]
+		if !ok {
+			return nil
+		}
+		a.utxos[outpoint] = &walletUtxo{
+			amount:    entry.Amount(),
+			blockHash: *entry.BlockHash(),
+			coinbase:  entry.IsCoinBase(),
+			key:       k,
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("Wallet rescan finished", "utxos", len(a.utxos))
+	return nil
+}
+
+// handleNotifyMsg keeps the wallet outputs in sync with the block chain.
+func (a *AccountManager) handleNotifyMsg(notification *blockchain.Notification) {
+	switch notification.Type {
+	case blockchain.BlockConnected:
+		blockSlice, ok := notification.Data.([]*types.SerializedBlock)
+		if !ok {
+			return
+		}
+		for _, block := range blockSlice {
+			a.connectBlock(block)
+		}
+
+	case blockchain.BlockDisconnected:
+		block, ok := notification.Data.(*types.SerializedBlock)
+		if !ok {
+			return
+		}
+		a.disconnectBlock(block)
+	}
+}
+
+// connectBlock removes the wallet outputs spent by the block and adds the
+// outputs it pays to the wallet.  The transactions of a block can be invalid
+// in the DAG order, in which case its outputs are not in the utxo set and its
+// inputs stay unspent, so both are checked against the utxo set like rescan
+// does.
+func (a *AccountManager) connectBlock(block *types.SerializedBlock) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.unlocked() {
+		return
+	}
+	for _, tx := range block.Transactions() {
+		if !tx.Tx.IsCoinBase() {
+			for _, txIn := range tx.Tx.TxIn {
+				if _, ok := a.utxos[txIn.PreviousOut]; !ok {
+					continue
+				}
+				entry, err := a.chain.FetchUtxoEntry(txIn.PreviousOut)
+				if err != nil {
+					log.Warn("Failed to fetch wallet output",
+						"outpoint", txIn.PreviousOut, "error", err)
+					continue
+				}
+				if entry != nil {
+					continue
+				}
+				delete(a.utxos, txIn.PreviousOut)
+				delete(a.pending, txIn.PreviousOut)
+			}
+		}
+		for i, txOut := range tx.Tx.TxOut {
+			k, ok := a.scripts[string(txOut.PkScript)]
+			if !ok {
+				continue
+			}
+			outpoint := types.NewOutPoint(tx.Hash(), uint32(i))
+			entry, err := a.chain.FetchUtxoEntry(*outpoint)
+			if err != nil {
+				log.Warn("Failed to fetch wallet output",
+					"outpoint", outpoint, "error", err)
+				continue
+			}
+			if entry == nil {
+				continue
+			}
+			a.utxos[*outpoint] = &walletUtxo{
+				amount:    entry.Amount(),
+				blockHash: *entry.BlockHash(),
+				coinbase:  entry.IsCoinBase(),
+				key:       k,
+			}
+		}
+	}
+}
+
+// disconnectBlock drops the wallet outputs created by the block and restores
+// the outputs it spent which are unspent again.
+func (a *AccountManager) disconnectBlock(block *types.SerializedBlock) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.unlocked() {
+		return
+	}
+	for _, tx := range block.Transactions() {
+		for i := range tx.Tx.TxOut {
+			delete(a.utxos, *types.NewOutPoint(tx.Hash(), uint32(i)))
+		}
+		if tx.Tx.IsCoinBase() {
+			continue
+		}
+		for _, txIn := range tx.Tx.TxIn {
+			entry, err := a.chain.FetchUtxoEntry(txIn.PreviousOut)
+			if err != nil || entry == nil {
+				continue
+			}
+			k, ok := a.scripts[string(entry.PkScript())]
+			if !ok {
+				continue
+			}
+			a.utxos[txIn.PreviousOut] = &walletUtxo{
+				amount:    entry.Amount(),
+				blockHash: *entry.BlockHash(),
+				coinbase:  entry.IsCoinBase(),
+				key:       k,
+			}
+		}
+	}
+}
+
+// confirmations returns the number of confirmations of an output and
+// whether it may be spent according to the coinbase maturity rule.
+func (a *AccountManager) confirmations(u *walletUtxo) (uint, bool) {
+	// Outputs of the genesis ledger do not belong to any block.
+	if u.blockHash.IsEqual(&hash.ZeroHash) {
+		return 0, true
+	}
+	confs := a.chain.GetConfirmations(&u.blockHash)
+	if !u.coinbase {
+		return confs, true
+	}
+	return confs, confs >= uint(a.params.CoinbaseMaturity) && a.chain.IsBlue(&u.blockHash)
+}
+
+// isPending returns whether the output is spent by a wallet transaction which
+// is still waiting in the memory pool.
+//
+// This function MUST be called with the lock held.
+func (a *AccountManager) isPending(outpoint types.TxOutPoint) bool {
+	txh, ok := a.pending[outpoint]
+	if !ok {
+		return false
+	}
+	return a.chain.HaveTransaction(&txh)
+}
+
+// spendCandidate is a spendable wallet output considered by coin selection.
+type spendCandidate struct {
+	outpoint types.TxOutPoint
+	utxo     *walletUtxo
+}
+
+// sendToAddress builds, signs and submits a transaction paying amount atoms
+// to the address.  The change is paid back to the wallet key of the first
+// spent output.
+func (a *AccountManager) sendToAddress(addr types.Address, amount uint64) (*types.Tx, error) {
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if !a.unlocked() {
+		return nil, ErrWalletLocked
+	}
+	// Spend the largest outputs first so the transaction stays small.
+	var candidates []spendCandidate
+	for outpoint, u := range a.utxos {
+		if u.key.watchOnly() || a.isPending(outpoint) {
+			continue
+		}
+		if _, spendable := a.confirmations(u); !spendable {
+			continue
+		}
+		candidates = append(candidates, spendCandidate{outpoint, u})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].utxo.amount > candidates[j].utxo.amount
+	})
+
+	var fee uint64
+	for {
+		var selected []spendCandidate
+		var total uint64
+		for _, c := range candidates {
+			if total >= amount+fee {
+				break
+			}
+			selected = append(selected, c)
+			total += c.utxo.amount
+		}
+		if total < amount+fee {
+			return nil, ErrInsufficientFunds
+		}
+
+		mtx := types.NewTransaction()
+		for _, c := range selected {
+			mtx.AddTxIn(types.NewTxInput(&c.outpoint, nil))
+		}
+		mtx.AddTxOut(types.NewTxOutput(amount, pkScript))
+		if change := total - amount - fee; change > 0 {
+			mtx.AddTxOut(types.NewTxOutput(change, selected[0].utxo.key.pkScript))
+		}
+		if err := a.signTransaction(mtx, selected); err != nil {
+			return nil, err
+		}
+
+		// Retry with the fee required by the final size of the signed
+		// transaction until it is covered.
+		required := uint64(a.requiredFee(mtx.SerializeSize()))
+		if fee >= required {
+			tx := types.NewTx(mtx)
+			for _, c := range selected {
+				a.pending[c.outpoint] = *tx.Hash()
+			}
+			return tx, nil
+		}
+		fee = required
+	}
+}
+
+// releasePending makes the outputs spent by a wallet transaction which was
+// rejected spendable again.
+func (a *AccountManager) releasePending(tx *types.Tx) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, txIn := range tx.Tx.TxIn {
+		delete(a.pending, txIn.PreviousOut)
+	}
+}
+
+// signTransaction signs every input of the transaction with the wallet key
+// of the output it spends.
+func (a *AccountManager) signTransaction(mtx *types.Transaction, selected []spendCandidate) error {
+	var kdb txscript.KeyClosure = func(addr types.Address) (ecc.PrivateKey, bool, error) {
+		for _, c := range selected {
+			if c.utxo.key.addr.Encode() == addr.Encode() {
+				return c.utxo.key.privKey, true, nil // compressed is true
+			}
+		}
+		return nil, false, fmt.Errorf("no key for address %s", addr.Encode())
+	}
+	for i, c := range selected {
+		sigScript, err := txscript.SignTxOutput(a.params, mtx, i, c.utxo.key.pkScript,
+			txscript.SigHashAll, kdb, nil, nil, ecc.ECDSA_Secp256k1)
+		if err != nil {
+			return err
+		}
+		mtx.TxIn[i].SignScript = sigScript
+	}
+	return nil
+}
+
+// requiredFee returns the fee needed for a transaction of the passed size
+// to be relayed with the configured minimum transaction fee.
+func (a *AccountManager) requiredFee(serializedSize int) int64 {
+	minFee := (int64(serializedSize) * a.cfg.MinTxFee) / 1000
+	if minFee == 0 && a.cfg.MinTxFee > 0 {
+		minFee = a.cfg.MinTxFee
+	}
+	return minFee
+}
+
+// New returns the account manager.  The wallet stays locked until it is
+// unlocked, or created when there is no wallet file yet, with the
+// walletPassphrase RPC.
+func New(bm *blkmgr.BlockManager, cfg *config.Config, par *params.Params,
+	ntmgr notify.Notify) (*AccountManager, error) {
+	a := AccountManager{
+		cfg:     cfg,
+		params:  par,
+		bm:      bm,
+		ntmgr:   ntmgr,
+		chain:   &blkmgrChain{bm},
+		scripts: make(map[string]*walletKey),
+		utxos:   make(map[types.TxOutPoint]*walletUtxo),
+		pending: make(map[types.TxOutPoint]hash.Hash),
+	}
 	return &a, nil
 }
@@ -0,0 +1,596 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package acct
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testChain is a wallet chain whose utxo set is changed by the blocks the
+// tests connect and disconnect.
+type testChain struct {
+	view  *blockchain.UtxoViewpoint
+	spent map[types.TxOutPoint]*blockchain.UtxoEntry
+	confs map[hash.Hash]uint
+	blue  map[hash.Hash]bool
+	pool  map[hash.Hash]bool
+	built int64
+}
+
+func newTestChain() *testChain {
+	return &testChain{
+		view:  blockchain.NewUtxoViewpoint(),
+		spent: make(map[types.TxOutPoint]*blockchain.UtxoEntry),
+		confs: make(map[hash.Hash]uint),
+		blue:  make(map[hash.Hash]bool),
+		pool:  make(map[hash.Hash]bool),
+	}
+}
+
+func (c *testChain) FetchUtxoEntry(outpoint types.TxOutPoint) (*blockchain.UtxoEntry, error) {
+	return c.view.LookupEntry(outpoint), nil
+}
+
+func (c *testChain) ForEachUtxoEntry(fn func(outpoint types.TxOutPoint, entry *blockchain.UtxoEntry) error) error {
+	for outpoint, entry := range c.view.Entries() {
+		if err := fn(outpoint, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *testChain) GetConfirmations(h *hash.Hash) uint {
+	return c.confs[*h]
+}
+
+func (c *testChain) IsBlue(h *hash.Hash) bool {
+	return c.blue[*h]
+}
+
+func (c *testChain) HaveTransaction(h *hash.Hash) bool {
+	return c.pool[*h]
+}
+
+// block returns a block holding the transactions, the first of which is its
+// coinbase, with the passed number of confirmations.
+func (c *testChain) block(confs uint, txs ...*types.Transaction) *types.SerializedBlock {
+	c.built++
+	header := params.PrivNetParams.GenesisBlock.Header
+	header.Timestamp = header.Timestamp.Add(time.Duration(c.built) *
+		time.Second)
+	block := types.NewBlock(&types.Block{Header: header, Transactions: txs})
+	c.confs[*block.Hash()] = confs
+	c.blue[*block.Hash()] = true
+	return block
+}
+
+// connect applies the transactions of a valid block to the utxo set.  The
+// transactions of the invalid blocks aren't applied.
+func (c *testChain) connect(block *types.SerializedBlock) {
+	for _, tx := range block.Transactions() {
+		if !tx.Tx.IsCoinBase() {
+			for _, txIn := range tx.Tx.TxIn {
+				c.spent[txIn.PreviousOut] = c.view.LookupEntry(txIn.PreviousOut)
+				c.view.RemoveEntry(txIn.PreviousOut)
+			}
+		}
+		c.view.AddTxOuts(tx, block.Hash())
+	}
+}
+
+// disconnect reverts the transactions of a valid block from the utxo set.
+func (c *testChain) disconnect(block *types.SerializedBlock) {
+	txs := block.Transactions()
+	for i := len(txs) - 1; i >= 0; i-- {
+		tx := txs[i]
+		for j := range tx.Tx.TxOut {
+			c.view.RemoveEntry(*types.NewOutPoint(tx.Hash(), uint32(j)))
+		}
+		if tx.Tx.IsCoinBase() {
+			continue
+		}
+		for _, txIn := range tx.Tx.TxIn {
+			c.view.Entries()[txIn.PreviousOut] = c.spent[txIn.PreviousOut]
+		}
+	}
+}
+
+// testCoinbase returns a coinbase paying the amounts to the scripts, which is
+// unique to the passed height.
+func testCoinbase(height int, amounts []uint64, pkScripts ...[]byte) *types.Transaction {
+	tx := types.NewTransaction()
+	tx.AddTxIn(&types.TxInput{
+		PreviousOut: *types.NewOutPoint(&hash.ZeroHash, math.MaxUint32),
+		Sequence:    types.MaxTxInSequenceNum,
+		SignScript:  []byte{byte(height), byte(height >> 8)},
+	})
+	for i, amount := range amounts {
+		tx.AddTxOut(types.NewTxOutput(amount, pkScripts[i]))
+	}
+	return tx
+}
+
+// testSpend returns a transaction spending the outpoints, which pays the
+// amounts to the scripts.
+func testSpend(prevOuts []types.TxOutPoint, amounts []uint64, pkScripts ...[]byte) *types.Transaction {
+	tx := types.NewTransaction()
+	for i := range prevOuts {
+		tx.AddTxIn(types.NewTxInput(&prevOuts[i], []byte{}))
+	}
+	for i, amount := range amounts {
+		tx.AddTxOut(types.NewTxOutput(amount, pkScripts[i]))
+	}
+	return tx
+}
+
+// outPoint returns the outpoint of the passed output of a transaction.
+func outPoint(tx *types.Transaction, i uint32) types.TxOutPoint {
+	txHash := tx.TxHash()
+	return *types.NewOutPoint(&txHash, i)
+}
+
+// foreignAddr returns a privnet address which isn't in the wallet and its
+// public key script.
+func foreignAddr(t *testing.T) (types.Address, []byte) {
+	addr, err := address.NewPubKeyHashAddress(make([]byte, 20),
+		&params.PrivNetParams, ecc.ECDSA_Secp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr, pkScript
+}
+
+// newTestAccountManager returns an account manager on a test chain, whose
+// wallet file doesn't exist yet and is removed by the returned function.
+func newTestAccountManager(t *testing.T) (*AccountManager, *testChain, func()) {
+	dir, err := ioutil.TempDir("", "acct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		WalletFile: filepath.Join(dir, "wallet.json"),
+		MinTxFee:   1e5,
+	}
+	a, err := New(nil, cfg, &params.PrivNetParams, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	chain := newTestChain()
+	a.chain = chain
+	return a, chain, func() {
+		a.lockWallet()
+		os.RemoveAll(dir)
+	}
+}
+
+// walletUtxos returns the amounts of the wallet outputs by outpoint.
+func walletUtxos(a *AccountManager) map[types.TxOutPoint]uint64 {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	utxos := make(map[types.TxOutPoint]uint64)
+	for outpoint, u := range a.utxos {
+		utxos[outpoint] = u.amount
+	}
+	return utxos
+}
+
+// TestWalletLocked checks that the wallet is created and unlocked with its
+// passphrase only, and that the locked wallet refuses the wallet operations.
+func TestWalletLocked(t *testing.T) {
+	a, chain, remove := newTestAccountManager(t)
+	defer remove()
+	api := NewPublicAccountManagerAPI(a)
+	_, foreignScript := foreignAddr(t)
+	dest, _ := foreignAddr(t)
+
+	checkLocked := func(name string) {
+		if _, err := a.newAddress(); err != ErrWalletLocked {
+			t.Errorf("%s: new address: got error %v", name, err)
+		}
+		if _, err := a.sendToAddress(dest, 1e8); err != ErrWalletLocked {
+			t.Errorf("%s: send: got error %v", name, err)
+		}
+		if _, err := a.addMultisigAddress(1, []string{dest.Encode()}); err != ErrWalletLocked {
+			t.Errorf("%s: multisig: got error %v", name, err)
+		}
+		if _, err := api.GetBalance(); err != ErrWalletLocked {
+			t.Errorf("%s: balance: got error %v", name, err)
+		}
+		if _, err := api.ListUnspent(nil); err != ErrWalletLocked {
+			t.Errorf("%s: unspent outputs: got error %v", name, err)
+		}
+		if _, err := api.GetNewAddress(nil); err != ErrWalletLocked {
+			t.Errorf("%s: new address RPC: got error %v", name, err)
+		}
+		if utxos := walletUtxos(a); len(utxos) != 0 {
+			t.Errorf("%s: locked wallet has %d outputs", name, len(utxos))
+		}
+	}
+	checkLocked("new wallet")
+
+	// The wallet file is created by the first unlock.
+	if _, err := api.WalletPassphrase("", nil); err == nil {
+		t.Fatalf("Unlocked the wallet with an empty passphrase")
+	}
+	if err := a.unlockWallet("pass", 0); err != nil {
+		t.Fatalf("Failed to create the wallet: %v", err)
+	}
+	if _, err := os.Stat(a.cfg.WalletFile); err != nil {
+		t.Fatalf("Wallet file wasn't created: %v", err)
+	}
+	addr, err := a.newAddress()
+	if err != nil {
+		t.Fatalf("Failed to get a new address: %v", err)
+	}
+
+	// An output paying to the new address is found by the rescan of the
+	// next unlock.
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.connect(chain.block(1, testCoinbase(1, []uint64{1e8, 2e8},
+		pkScript, foreignScript)))
+
+	a.lockWallet()
+	checkLocked("locked wallet")
+	if err := a.unlockWallet("Pass", 0); err != ErrDecrypt {
+		t.Fatalf("Unlock with a wrong passphrase: got error %v", err)
+	}
+	if _, err := api.WalletPassphrase("Pass", nil); err == nil {
+		t.Fatalf("Unlocked the wallet by RPC with a wrong passphrase")
+	}
+	checkLocked("wrong passphrase")
+
+	if _, err := api.WalletPassphrase("pass", nil); err != nil {
+		t.Fatalf("Failed to unlock the wallet: %v", err)
+	}
+	a.lock.RLock()
+	numKeys := len(a.keys)
+	a.lock.RUnlock()
+	if numKeys != 2 {
+		t.Fatalf("Unlocked wallet has %d keys, want 2", numKeys)
+	}
+	if utxos := walletUtxos(a); len(utxos) != 1 {
+		t.Fatalf("Rescan found %d outputs, want 1", len(utxos))
+	}
+
+	// The passphrase is checked when the wallet is unlocked already.
+	if err := a.unlockWallet("Pass", 0); err != ErrDecrypt {
+		t.Fatalf("Unlock of the unlocked wallet with a wrong passphrase: "+
+			"got error %v", err)
+	}
+	if _, err := a.newAddress(); err != nil {
+		t.Fatalf("Wrong passphrase locked the wallet: %v", err)
+	}
+
+	if _, err := api.WalletLock(); err != nil {
+		t.Fatal(err)
+	}
+	checkLocked("locked by RPC")
+
+	// The wallet is locked again after the timeout.
+	if err := a.unlockWallet("pass", 10*time.Millisecond); err != nil {
+		t.Fatalf("Failed to unlock the wallet: %v", err)
+	}
+	for i := 0; ; i++ {
+		a.lock.RLock()
+		unlocked := a.unlocked()
+		a.lock.RUnlock()
+		if !unlocked {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Wallet wasn't locked after the timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkLocked("timeout")
+}
+
+// TestWalletConnectDisconnect checks that the wallet outputs follow the
+// blocks connected and disconnected, where the invalid blocks neither spend
+// nor pay any wallet output, and that disconnecting the blocks from the last
+// one restores the outputs.
+func TestWalletConnectDisconnect(t *testing.T) {
+	a, chain, remove := newTestAccountManager(t)
+	defer remove()
+	_, foreignScript := foreignAddr(t)
+
+	if err := a.unlockWallet("pass", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.newAddress(); err != nil {
+		t.Fatal(err)
+	}
+	script0, script1 := a.keys[0].pkScript, a.keys[1].pkScript
+
+	type step struct {
+		name  string
+		block *types.SerializedBlock
+		valid bool
+		// utxos are the wallet outputs once the block is connected.
+		utxos map[types.TxOutPoint]uint64
+	}
+	var steps []step
+
+	cb1 := testCoinbase(1, []uint64{1e8, 2e8, 3e8}, script0, foreignScript,
+		script1)
+	steps = append(steps, step{
+		name:  "coinbase",
+		block: chain.block(1, cb1),
+		valid: true,
+		utxos: map[types.TxOutPoint]uint64{
+			outPoint(cb1, 0): 1e8,
+			outPoint(cb1, 2): 3e8,
+		},
+	})
+
+	// The invalid block neither spends the wallet output nor pays one.
+	invalidTx := testSpend([]types.TxOutPoint{outPoint(cb1, 0)},
+		[]uint64{1e8 - 1000}, script1)
+	steps = append(steps, step{
+		name: "invalid block",
+		block: chain.block(1, testCoinbase(2, []uint64{1e8}, script0),
+			invalidTx),
+		valid: false,
+		utxos: steps[0].utxos,
+	})
+
+	tx3 := testSpend([]types.TxOutPoint{outPoint(cb1, 0)},
+		[]uint64{4e7, 6e7 - 1000}, foreignScript, script1)
+	cb3 := testCoinbase(3, []uint64{1e8}, foreignScript)
+	steps = append(steps, step{
+		name:  "spend and change",
+		block: chain.block(1, cb3, tx3),
+		valid: true,
+		utxos: map[types.TxOutPoint]uint64{
+			outPoint(cb1, 2): 3e8,
+			outPoint(tx3, 1): 6e7 - 1000,
+		},
+	})
+
+	// The output paid and spent in the same block is never unspent.
+	tx4 := testSpend([]types.TxOutPoint{outPoint(cb1, 2)}, []uint64{3e8 - 1000},
+		script0)
+	tx4b := testSpend([]types.TxOutPoint{outPoint(tx4, 0)},
+		[]uint64{3e8 - 2000}, foreignScript)
+	steps = append(steps, step{
+		name: "spent in the block",
+		block: chain.block(1, testCoinbase(4, []uint64{1e8}, foreignScript),
+			tx4, tx4b),
+		valid: true,
+		utxos: map[types.TxOutPoint]uint64{
+			outPoint(tx3, 1): 6e7 - 1000,
+		},
+	})
+
+	snapshots := []map[types.TxOutPoint]uint64{walletUtxos(a)}
+	for i, s := range steps {
+		if s.valid {
+			chain.connect(s.block)
+		}
+		// The first block is notified like the chain does.
+		if i == 0 {
+			a.handleNotifyMsg(&blockchain.Notification{
+				Type: blockchain.BlockConnected,
+				Data: []*types.SerializedBlock{s.block},
+			})
+		} else {
+			a.connectBlock(s.block)
+		}
+		got := walletUtxos(a)
+		if !reflect.DeepEqual(got, s.utxos) {
+			t.Fatalf("%s: got outputs %v, want %v", s.name, got, s.utxos)
+		}
+		snapshots = append(snapshots, got)
+	}
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		s := steps[i]
+		if s.valid {
+			chain.disconnect(s.block)
+		}
+		if i == 0 {
+			a.handleNotifyMsg(&blockchain.Notification{
+				Type: blockchain.BlockDisconnected,
+				Data: s.block,
+			})
+		} else {
+			a.disconnectBlock(s.block)
+		}
+		if got := walletUtxos(a); !reflect.DeepEqual(got, snapshots[i]) {
+			t.Fatalf("%s: disconnect left outputs %v, want %v", s.name,
+				got, snapshots[i])
+		}
+	}
+
+	// The blocks connected to a locked wallet are ignored, and found by
+	// the rescan of the next unlock.
+	a.lockWallet()
+	chain.connect(steps[0].block)
+	a.connectBlock(steps[0].block)
+	if err := a.unlockWallet("pass", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := walletUtxos(a); !reflect.DeepEqual(got, steps[0].utxos) {
+		t.Fatalf("Rescan: got outputs %v, want %v", got, steps[0].utxos)
+	}
+}
+
+// TestSendToAddress checks that the wallet spends its largest spendable
+// outputs first, pays the change back to the key of the largest one, covers
+// the fee of the signed transaction and doesn't spend the outputs of its
+// transactions waiting in the memory pool again.
+func TestSendToAddress(t *testing.T) {
+	a, chain, remove := newTestAccountManager(t)
+	defer remove()
+	dest, destScript := foreignAddr(t)
+
+	if err := a.unlockWallet("pass", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.newAddress(); err != nil {
+		t.Fatal(err)
+	}
+	key0, key1 := a.keys[0], a.keys[1]
+	multisig, err := a.addMultisigAddress(1, []string{key0.addr.Encode()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	maturity := uint(params.PrivNetParams.CoinbaseMaturity)
+	immature := testCoinbase(1, []uint64{10e8}, key0.pkScript)
+	chain.connect(chain.block(maturity-1, immature))
+	mature := testCoinbase(2, []uint64{1e8}, key0.pkScript)
+	chain.connect(chain.block(maturity, mature))
+	funding := testSpend([]types.TxOutPoint{outPoint(immature, 0)},
+		[]uint64{3e8, 5e8, 20e8}, key0.pkScript, key1.pkScript,
+		multisig.pkScript)
+	chain.connect(chain.block(1, testCoinbase(3, []uint64{1e8},
+		destScript), funding))
+	if err := a.rescan(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTx := func(name string, tx *types.Tx, amount uint64,
+		spent map[types.TxOutPoint]uint64, change []byte) {
+
+		var total uint64
+		for i, txIn := range tx.Tx.TxIn {
+			amount, ok := spent[txIn.PreviousOut]
+			if !ok {
+				t.Errorf("%s: spent output %v", name, txIn.PreviousOut)
+				return
+			}
+			total += amount
+			entry := chain.view.LookupEntry(txIn.PreviousOut)
+			vm, err := txscript.NewEngine(entry.PkScript(), tx.Tx, i,
+				txscript.ScriptBip16, txscript.DefaultScriptVersion, nil)
+			if err != nil {
+				t.Fatalf("%s: input %d: %v", name, i, err)
+			}
+			if err := vm.Execute(); err != nil {
+				t.Errorf("%s: input %d doesn't verify: %v", name, i, err)
+			}
+		}
+		if len(tx.Tx.TxIn) != len(spent) {
+			t.Errorf("%s: spent %d outputs, want %d", name,
+				len(tx.Tx.TxIn), len(spent))
+		}
+		if len(tx.Tx.TxOut) != 2 {
+			t.Errorf("%s: got %d outputs, want 2", name, len(tx.Tx.TxOut))
+			return
+		}
+		out, changeOut := tx.Tx.TxOut[0], tx.Tx.TxOut[1]
+		if out.Amount != amount || string(out.PkScript) != string(destScript) {
+			t.Errorf("%s: paid %d to %x, want %d to %x", name, out.Amount,
+				out.PkScript, amount, destScript)
+		}
+		if string(changeOut.PkScript) != string(change) {
+			t.Errorf("%s: change paid to %x, want %x", name,
+				changeOut.PkScript, change)
+		}
+		fee := total - amount - changeOut.Amount
+		required := uint64(a.requiredFee(tx.Tx.SerializeSize()))
+		if fee < required || fee > 2*required {
+			t.Errorf("%s: paid fee %d, required %d", name, fee, required)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		amount uint64
+		spent  map[types.TxOutPoint]uint64
+		change []byte
+		err    error
+	}{
+		{
+			name:   "largest output",
+			amount: 4e8,
+			spent:  map[types.TxOutPoint]uint64{outPoint(funding, 1): 5e8},
+			change: key1.pkScript,
+		},
+		{
+			name:   "fee of the largest output",
+			amount: 5e8 - 1000,
+			spent: map[types.TxOutPoint]uint64{
+				outPoint(funding, 1): 5e8,
+				outPoint(funding, 0): 3e8,
+			},
+			change: key1.pkScript,
+		},
+		{
+			name:   "mature coinbase",
+			amount: 8e8,
+			spent: map[types.TxOutPoint]uint64{
+				outPoint(funding, 1): 5e8,
+				outPoint(funding, 0): 3e8,
+				outPoint(mature, 0):  1e8,
+			},
+			change: key1.pkScript,
+		},
+		{
+			name:   "insufficient funds",
+			amount: 9e8,
+			err:    ErrInsufficientFunds,
+		},
+	}
+	for _, test := range tests {
+		tx, err := a.sendToAddress(dest, test.amount)
+		if err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil {
+			checkTx(test.name, tx, test.amount, test.spent, test.change)
+			a.releasePending(tx)
+		}
+	}
+
+	// The outputs spent by a transaction in the memory pool are pending
+	// until it is released.
+	tx, err := a.sendToAddress(dest, 4e8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.pool[*tx.Hash()] = true
+	tx2, err := a.sendToAddress(dest, 2e8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTx("pending", tx2, 2e8,
+		map[types.TxOutPoint]uint64{outPoint(funding, 0): 3e8}, key0.pkScript)
+	chain.pool[*tx2.Hash()] = true
+	if _, err := a.sendToAddress(dest, 1e8); err != ErrInsufficientFunds {
+		t.Fatalf("Spending pending outputs: got error %v", err)
+	}
+	a.releasePending(tx)
+	tx3, err := a.sendToAddress(dest, 4e8)
+	if err != nil {
+		t.Fatalf("Released outputs aren't spendable: %v", err)
+	}
+	checkTx("released", tx3, 4e8,
+		map[types.TxOutPoint]uint64{outPoint(funding, 1): 5e8}, key1.pkScript)
+}
@@ -1,16 +1,201 @@
 package acct
 
-// PublicEthereumAPI provides an API to access Ethereum full node-related
-// information.
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/mempool"
+	"sort"
+	"time"
+)
+
+// PublicAccountManagerAPI provides the wallet RPCs of the account manager.
 type PublicAccountManagerAPI struct {
 	a *AccountManager
 }
 
-// NewPublicEthereumAPI creates a new Ethereum protocol API for full nodes.
+// NewPublicAccountManagerAPI creates the wallet API of the account manager.
 func NewPublicAccountManagerAPI(a *AccountManager) *PublicAccountManagerAPI {
 	return &PublicAccountManagerAPI{a}
 }
 
-func (api *PublicAccountManagerAPI) GetBalance() int32 {
-	return 0
+// WalletPassphrase unlocks the wallet with its passphrase, or creates the
+// wallet file encrypted with the passphrase when it does not exist yet.  The
+// wallet is locked again after timeout seconds, unless the timeout is missing
+// or zero.
+func (api *PublicAccountManagerAPI) WalletPassphrase(passphrase string, timeout *uint) (interface{}, error) {
+	if passphrase == "" {
+		return nil, rpc.RpcInvalidError("Empty passphrase")
+	}
+	var lockAfter time.Duration
+	if timeout != nil {
+		lockAfter = time.Duration(*timeout) * time.Second
+	}
+	err := api.a.unlockWallet(passphrase, lockAfter)
+	if err == ErrDecrypt {
+		return nil, rpc.RpcInvalidError("The wallet passphrase entered was incorrect")
+	}
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to unlock wallet")
+	}
+	return nil, nil
+}
+
+// WalletLock locks the wallet, which drops its keys from memory.
+func (api *PublicAccountManagerAPI) WalletLock() (interface{}, error) {
+	api.a.lockWallet()
+	return nil, nil
+}
+
+// GetBalance returns the total, spendable and immature balance of the wallet
+// in coins.  Outputs spent by wallet transactions which are not in a block yet
+// are reported as pending.  Outputs of watched multisig addresses are not
+// included.
+func (api *PublicAccountManagerAPI) GetBalance() (interface{}, error) {
+	a := api.a
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if !a.unlocked() {
+		return nil, ErrWalletLocked
+	}
+	var total, spendable, immature, pending uint64
+	for outpoint, u := range a.utxos {
+		if u.key.watchOnly() {
+			continue
+		}
+		total += u.amount
+		if a.isPending(outpoint) {
+			pending += u.amount
+			continue
+		}
+		if _, ok := a.confirmations(u); ok {
+			spendable += u.amount
+		} else {
+			immature += u.amount
+		}
+	}
+	return json.GetBalanceResult{
+		Total:     types.Amount(total).ToCoin(),
+		Spendable: types.Amount(spendable).ToCoin(),
+		Immature:  types.Amount(immature).ToCoin(),
+		Pending:   types.Amount(pending).ToCoin(),
+	}, nil
+}
+
+// ListUnspent returns the unspent outputs of the wallet which have at least
+// minConf confirmations, ordered by confirmations.
+func (api *PublicAccountManagerAPI) ListUnspent(minConf *int64) (interface{}, error) {
+	a := api.a
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if !a.unlocked() {
+		return nil, ErrWalletLocked
+	}
+	result := []json.ListUnspentResult{}
+	for outpoint, u := range a.utxos {
+		confs, spendable := a.confirmations(u)
+		if minConf != nil && int64(confs) < *minConf {
+			continue
+		}
+		result = append(result, json.ListUnspentResult{
+			TxId:          outpoint.Hash.String(),
+			Vout:          outpoint.OutIndex,
+			Address:       u.key.addr.Encode(),
+			ScriptPubKey:  hex.EncodeToString(u.key.pkScript),
+			Amount:        types.Amount(u.amount).ToCoin(),
+			Confirmations: int64(confs),
+			Coinbase:      u.coinbase,
+			Spendable:     spendable && !u.key.watchOnly() && !a.isPending(outpoint),
+			RedeemScript:  hex.EncodeToString(u.key.redeemScript),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Confirmations > result[j].Confirmations
+	})
+	return result, nil
+}
+
+// GetNewAddress returns a new address of the wallet account, which is
+// encoded with bech32 if requested.
+func (api *PublicAccountManagerAPI) GetNewAddress(bech32 *bool) (interface{}, error) {
+	addr, err := api.a.newAddress()
+	if err == ErrWalletLocked {
+		return nil, err
+	}
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to derive new address")
+	}
+	if bech32 != nil && *bech32 {
+		addr, err = address.ToBech32(addr)
+		if err != nil {
+			return nil, rpc.RpcInternalError(err.Error(), "Failed to encode address")
+		}
+	}
+	return addr.Encode(), nil
+}
+
+// AddMultisigAddress adds a multisig address requiring nRequired signatures of
+// the passed keys to the wallet, and returns it with its redeem script.  The
+// keys are either hex-encoded public keys or addresses of the wallet.  The
+// outputs of the address are watched, but must be spent with a partially
+// signed transaction.
+func (api *PublicAccountManagerAPI) AddMultisigAddress(nRequired int, keys []string) (interface{}, error) {
+	// Standard multisig scripts encode the number of keys as a small
+	// integer.
+	if len(keys) == 0 || len(keys) > 16 {
+		return nil, rpc.RpcInvalidError("Number of keys %d must be "+
+			"between 1 and 16", len(keys))
+	}
+	if nRequired < 1 || nRequired > len(keys) {
+		return nil, rpc.RpcInvalidError("Invalid number of required "+
+			"signatures %d for %d keys", nRequired, len(keys))
+	}
+	k, err := api.a.addMultisigAddress(nRequired, keys)
+	if err == ErrWalletLocked {
+		return nil, err
+	}
+	if err != nil {
+		return nil, rpc.RpcAddressKeyError("Failed to add multisig address: %v", err)
+	}
+	return json.CreateMultiSigResult{
+		Address:      k.addr.Encode(),
+		RedeemScript: hex.EncodeToString(k.redeemScript),
+	}, nil
+}
+
+// SendToAddress pays amount atoms from the spendable wallet outputs to the
+// address and returns the hash of the transaction.
+func (api *PublicAccountManagerAPI) SendToAddress(addr string, amount uint64) (interface{}, error) {
+	a := api.a
+	if amount == 0 || amount > types.MaxAmount {
+		return nil, rpc.RpcInvalidError("Invalid amount %d", amount)
+	}
+	dest, err := address.DecodeAddress(addr)
+	if err != nil {
+		return nil, rpc.RpcAddressKeyError("Could not decode address: %v", err)
+	}
+	if !address.IsForNetwork(dest, a.params) {
+		return nil, rpc.RpcAddressKeyError("Wrong network: %v", addr)
+	}
+
+	tx, err := a.sendToAddress(dest, amount)
+	if err != nil {
+		return nil, err
+	}
+	acceptedTxs, err := a.bm.ProcessTransaction(tx, false, false, false)
+	if err != nil {
+		a.releasePending(tx)
+		if _, ok := err.(mempool.RuleError); ok {
+			return nil, rpc.RpcRuleError("Rejected transaction %v: %v", tx.Hash(), err)
+		}
+		return nil, fmt.Errorf("failed to process transaction %v: %v", tx.Hash(), err)
+	}
+	a.ntmgr.AnnounceNewTransactions(acceptedTxs)
+
+	return tx.Hash().String(), nil
 }
@@ -0,0 +1,216 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package acct
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// walletFileVersion is the current version of the wallet file format.
+	walletFileVersion = 1
+
+	// scrypt parameters used to derive the encryption key of the wallet
+	// seed from the passphrase.
+	scryptR     = 8
+	scryptDKLen = 32
+
+	walletCipher = "aes-128-ctr"
+	walletKDF    = "scrypt"
+)
+
+var (
+	// scryptN and scryptP are the scrypt cost parameters of the new wallet
+	// files.  The files record the parameters they were encrypted with, so
+	// the tests lower them to run fast.
+	scryptN = 1 << 18
+	scryptP = 1
+)
+
+var (
+	// ErrDecrypt is returned when the wallet file can not be unlocked with
+	// the given passphrase.
+	ErrDecrypt = errors.New("could not decrypt wallet with given passphrase")
+)
+
+// walletFile is the on-disk representation of the wallet.  Only the seed is
+// encrypted, everything else is needed to rebuild the watched keys without
+// any further input.
+//
+// The seed is encrypted with AES-128-CTR using the first half of the scrypt
+// derived key, the second half is used to authenticate the cipher text:
+//
+//	mac = sha256(derivedKey[16:32] || ciphertext)
+type walletFile struct {
+	Version int `json:"version"`
+
+	// Path is the BIP32 derivation path of the account.
+	Path string `json:"path"`
+
+	// NextIndex is the index of the next address to hand out.  All of the
+	// addresses below it are watched by the account manager.
+	NextIndex uint32 `json:"nextindex"`
+
+	// Multisig are the hex-encoded redeem scripts of the multisig addresses
+	// watched by the wallet.
+	Multisig []string `json:"multisig,omitempty"`
+
+	Crypto cryptoJSON `json:"crypto"`
+}
+
+type cryptoJSON struct {
+	Cipher       string       `json:"cipher"`
+	CipherText   string       `json:"ciphertext"`
+	CipherParams cipherParams `json:"cipherparams"`
+	KDF          string       `json:"kdf"`
+	KDFParams    kdfParams    `json:"kdfparams"`
+	MAC          string       `json:"mac"`
+}
+
+type cipherParams struct {
+	IV string `json:"iv"`
+}
+
+type kdfParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// encryptSeed encrypts the wallet seed with the passphrase.
+func encryptSeed(seed []byte, pass string) (*cryptoJSON, error) {
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(pass), salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	cipherText, err := aesCTRXOR(derivedKey[:16], seed, iv)
+	if err != nil {
+		return nil, err
+	}
+	mac := sha256.Sum256(append(append([]byte{}, derivedKey[16:32]...), cipherText...))
+
+	return &cryptoJSON{
+		Cipher:       walletCipher,
+		CipherText:   hex.EncodeToString(cipherText),
+		CipherParams: cipherParams{IV: hex.EncodeToString(iv)},
+		KDF:          walletKDF,
+		KDFParams: kdfParams{
+			N:     scryptN,
+			R:     scryptR,
+			P:     scryptP,
+			DKLen: scryptDKLen,
+			Salt:  hex.EncodeToString(salt),
+		},
+		MAC: hex.EncodeToString(mac[:]),
+	}, nil
+}
+
+// decryptSeed returns the wallet seed, or ErrDecrypt if the passphrase does
+// not match.
+func decryptSeed(c *cryptoJSON, pass string) ([]byte, error) {
+	if c.Cipher != walletCipher {
+		return nil, fmt.Errorf("cipher not supported: %v", c.Cipher)
+	}
+	if c.KDF != walletKDF {
+		return nil, fmt.Errorf("kdf not supported: %v", c.KDF)
+	}
+	mac, err := hex.DecodeString(c.MAC)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(c.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(c.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(c.KDFParams.Salt)
+	if err != nil {
+		return nil, err
+	}
+	p := c.KDFParams
+	derivedKey, err := scrypt.Key([]byte(pass), salt, p.N, p.R, p.P, p.DKLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(derivedKey) < 32 {
+		return nil, fmt.Errorf("invalid derived key length %d", len(derivedKey))
+	}
+	calculatedMAC := sha256.Sum256(append(append([]byte{}, derivedKey[16:32]...), cipherText...))
+	if !bytes.Equal(calculatedMAC[:], mac) {
+		return nil, ErrDecrypt
+	}
+	return aesCTRXOR(derivedKey[:16], cipherText, iv)
+}
+
+func aesCTRXOR(key, inText, iv []byte) ([]byte, error) {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	stream := cipher.NewCTR(aesBlock, iv)
+	outText := make([]byte, len(inText))
+	stream.XORKeyStream(outText, inText)
+	return outText, nil
+}
+
+// loadWalletFile reads the wallet file at the given path.  A nil wallet and
+// no error are returned when the file does not exist.
+func loadWalletFile(path string) (*walletFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var w walletFile
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("failed to parse wallet file %s: %v", path, err)
+	}
+	if w.Version != walletFileVersion {
+		return nil, fmt.Errorf("unsupported wallet file version %d", w.Version)
+	}
+	return &w, nil
+}
+
+// writeWalletFile atomically replaces the wallet file at the given path.
+func writeWalletFile(path string, w *walletFile) error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package acct
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func init() {
+	// Keep the tests fast, the wallet files record the parameters they
+	// were encrypted with.
+	scryptN = 1 << 4
+	scryptP = 1
+}
+
+// TestSeedEncryptDecrypt checks that the seed is only decrypted with the
+// passphrase it was encrypted with, and that the tampered or unsupported
+// encryptions are refused.
+func TestSeedEncryptDecrypt(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x5a, 0x01}, 32)
+	c, err := encryptSeed(seed, "pass")
+	if err != nil {
+		t.Fatalf("Failed to encrypt the seed: %v", err)
+	}
+	if c.KDFParams.N != scryptN || c.KDFParams.P != scryptP {
+		t.Fatalf("Got scrypt parameters %+v", c.KDFParams)
+	}
+	got, err := decryptSeed(c, "pass")
+	if err != nil {
+		t.Fatalf("Failed to decrypt the seed: %v", err)
+	}
+	if !bytes.Equal(got, seed) {
+		t.Fatalf("Got seed %x, want %x", got, seed)
+	}
+
+	// Each encryption uses its own salt and iv.
+	c2, err := encryptSeed(seed, "pass")
+	if err != nil {
+		t.Fatalf("Failed to encrypt the seed: %v", err)
+	}
+	if c2.CipherText == c.CipherText || c2.KDFParams.Salt == c.KDFParams.Salt {
+		t.Fatalf("Encryptions of the same seed are equal")
+	}
+
+	flipped := func(s string) string {
+		b, _ := hex.DecodeString(s)
+		b[0] ^= 1
+		return hex.EncodeToString(b)
+	}
+	tests := []struct {
+		name   string
+		pass   string
+		modify func(c *cryptoJSON)
+		err    error
+	}{
+		{"wrong passphrase", "Pass", func(*cryptoJSON) {}, ErrDecrypt},
+		{"empty passphrase", "", func(*cryptoJSON) {}, ErrDecrypt},
+		{"tampered cipher text", "pass", func(c *cryptoJSON) {
+			c.CipherText = flipped(c.CipherText)
+		}, ErrDecrypt},
+		{"tampered mac", "pass", func(c *cryptoJSON) {
+			c.MAC = flipped(c.MAC)
+		}, ErrDecrypt},
+		{"tampered salt", "pass", func(c *cryptoJSON) {
+			c.KDFParams.Salt = flipped(c.KDFParams.Salt)
+		}, ErrDecrypt},
+		{"unsupported cipher", "pass", func(c *cryptoJSON) {
+			c.Cipher = "aes-128-cbc"
+		}, nil},
+		{"unsupported kdf", "pass", func(c *cryptoJSON) {
+			c.KDF = "pbkdf2"
+		}, nil},
+		{"invalid iv", "pass", func(c *cryptoJSON) {
+			c.CipherParams.IV = "zz"
+		}, nil},
+	}
+	for _, test := range tests {
+		modified := *c
+		test.modify(&modified)
+		got, err := decryptSeed(&modified, test.pass)
+		if err == nil {
+			t.Errorf("%s: decrypted seed %x", test.name, got)
+			continue
+		}
+		if test.err != nil && err != test.err {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+		}
+		if test.err == nil && err == ErrDecrypt {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
+
+// TestWalletFile checks that the wallet files are read as they are written,
+// and that the missing files and the unknown versions are told apart.
+func TestWalletFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wallet", "wallet.json")
+
+	w, err := loadWalletFile(path)
+	if err != nil || w != nil {
+		t.Fatalf("Missing wallet file: got %v, %v", w, err)
+	}
+
+	c, err := encryptSeed(make([]byte, 32), "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &walletFile{
+		Version:   walletFileVersion,
+		Path:      "m/44'/223'/0'/0",
+		NextIndex: 3,
+		Multisig:  []string{"5121"},
+		Crypto:    *c,
+	}
+	if err := writeWalletFile(path, want); err != nil {
+		t.Fatalf("Failed to write the wallet file: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Temporary wallet file was left: %v", err)
+	}
+	got, err := loadWalletFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load the wallet file: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Got wallet file %+v, want %+v", got, want)
+	}
+
+	want.Version = walletFileVersion + 1
+	if err := writeWalletFile(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadWalletFile(path); err == nil {
+		t.Fatalf("Loaded wallet file of version %d", want.Version)
+	}
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadWalletFile(path); err == nil {
+		t.Fatalf("Loaded malformed wallet file")
+	}
+}
@@ -289,3 +289,89 @@ func (api *PublicBlockAPI) IsBlue(h hash.Hash) (interface{}, error) {
 	}
 	return 0, nil
 }
+
+// GetDeploymentInfo returns the threshold states of the consensus rule change
+// deployments for the next block.
+func (api *PublicBlockAPI) GetDeploymentInfo() (interface{}, error) {
+	infos, err := api.bm.chain.DeploymentsInfo()
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to calculate the deployment states")
+	}
+	result := json.GetDeploymentInfoResult{
+		Threshold:   api.bm.params.RuleChangeActivationThreshold,
+		Window:      api.bm.params.MinerConfirmationWindow,
+		Deployments: make([]json.DeploymentInfoResult, 0, len(infos)),
+	}
+	for _, info := range infos {
+		var status string
+		switch info.State {
+		case blockchain.ThresholdDefined:
+			status = "defined"
+		case blockchain.ThresholdStarted:
+			status = "started"
+		case blockchain.ThresholdLockedIn:
+			status = "lockedin"
+		case blockchain.ThresholdActive:
+			status = "active"
+		case blockchain.ThresholdFailed:
+			status = "failed"
+		default:
+			status = info.State.String()
+		}
+		result.Deployments = append(result.Deployments, json.DeploymentInfoResult{
+			Id:         info.Deployment.Id,
+			Bit:        info.Deployment.BitNumber,
+			StartTime:  info.Deployment.StartTime,
+			ExpireTime: info.Deployment.ExpireTime,
+			Status:     status,
+		})
+	}
+	return result, nil
+}
+
+// GetBlockReceipt returns the receipt of a block, which commits to the state
+// of the utxo set after the block.
+func (api *PublicBlockAPI) GetBlockReceipt(h hash.Hash) (interface{}, error) {
+	if !api.bm.chain.StateCommitmentEnabled() {
+		return nil, rpc.RpcInvalidError("The state commitment is disabled, run with --statecommit")
+	}
+	receipt, err := api.bm.chain.BlockReceipt(&h)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to fetch block receipt")
+	}
+	if receipt == nil {
+		return nil, rpc.RpcInvalidError("No block receipt for block %s", h.String())
+	}
+	return json.BlockReceiptResult{
+		Hash:      h.String(),
+		Order:     receipt.BlockNumber,
+		StateRoot: receipt.StorageRoot.String(),
+	}, nil
+}
+
+// GetStateProof returns the Merkle proof of a transaction output in the state
+// of the utxo set after a block.  The value is omitted when the output was not
+// unspent, then the proof shows its absence.
+func (api *PublicBlockAPI) GetStateProof(h hash.Hash, txid hash.Hash, vout uint32) (interface{}, error) {
+	if !api.bm.chain.StateCommitmentEnabled() {
+		return nil, rpc.RpcInvalidError("The state commitment is disabled, run with --statecommit")
+	}
+	proof, err := api.bm.chain.FetchStateProof(&h, *types.NewOutPoint(&txid, vout))
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to fetch state proof")
+	}
+	result := json.GetStateProofResult{
+		BlockHash: h.String(),
+		Order:     proof.Receipt.BlockNumber,
+		StateRoot: proof.Receipt.StorageRoot.String(),
+		Key:       hex.EncodeToString(proof.Key),
+		Proof:     make([]string, 0, len(proof.Nodes)),
+	}
+	if proof.Value != nil {
+		result.Value = hex.EncodeToString(proof.Value)
+	}
+	for _, node := range proof.Nodes {
+		result.Proof = append(result.Proof, hex.EncodeToString(node))
+	}
+	return result, nil
+}
@@ -12,10 +12,12 @@ import (
 	"github.com/Qitmeer/qitmeer/core/message"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/database/statedb"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"github.com/Qitmeer/qitmeer/node/notify"
 	"github.com/Qitmeer/qitmeer/p2p/peer"
 	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/common"
 	"github.com/Qitmeer/qitmeer/services/common/progresslog"
 	"sync"
 	"sync/atomic"
@@ -45,6 +47,10 @@ type BlockManager struct {
 
 	chain *blockchain.BlockChain
 
+	// stateDB houses the state trie of the per-block state commitment,
+	// it is nil when the state commitment is disabled.
+	stateDB statedb.Database
+
 	rejectedTxns        map[hash.Hash]struct{}
 	requestedTxns       map[hash.Hash]struct{}
 	requestedEverTxns   map[hash.Hash]uint8
@@ -59,11 +65,14 @@ type BlockManager struct {
 	wg   sync.WaitGroup
 	quit chan struct{}
 
-	// The following fields are used for headers-first mode.
+	// The following fields are used for headers-first mode.  The header
+	// list holds the verified headers whose blocks are not known yet in
+	// the order the peers sent them, which is a topological order.
 	headersFirstMode bool
+	headersRequested bool
+	moreHeaders      bool
 	headerList       *list.List
-	startHeader      *list.Element
-	nextCheckpoint   *params.Checkpoint
+	headerIndex      map[hash.Hash]*list.Element
 
 	//block template cache
 	cachedCurrentTemplate *types.BlockTemplate
@@ -75,6 +84,11 @@ type BlockManager struct {
 	syncGS    *blockdag.GraphState
 
 	lastProgressTime time.Time
+
+	// subscribers are the chain notification callbacks registered by other
+	// services through Subscribe.
+	subscribersMtx sync.RWMutex
+	subscribers    []blockchain.NotificationCallback
 }
 
 // NewBlockManager returns a new block manager.
@@ -96,37 +110,62 @@ func NewBlockManager(ntmgr notify.Notify, indexManager blockchain.IndexManager,
 		progressLogger:      progresslog.NewBlockProgressLogger("Processed", log),
 		msgChan:             make(chan interface{}, cfg.MaxPeers*3),
 		headerList:          list.New(),
+		headerIndex:         make(map[hash.Hash]*list.Element),
 		quit:                make(chan struct{}),
 	}
 
-	// Create a new block chain instance with the appropriate configuration.
+	// Open the state database when the state commitment is enabled.
 	var err error
+	if cfg.StateCommit {
+		bm.stateDB, err = common.LoadStateDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The node closes after importing the blockchain, so the imported
+	// blocks are neither relayed nor notified.
+	notifications := bm.handleNotifyMsg
+	if cfg.ImportBlockchain != "" {
+		notifications = nil
+	}
+
+	// Create a new block chain instance with the appropriate configuration.
 	bm.chain, err = blockchain.New(&blockchain.Config{
 		DB:            db,
 		Interrupt:     interrupt,
 		ChainParams:   par,
 		TimeSource:    timeSource,
-		Notifications: bm.handleNotifyMsg,
+		Notifications: notifications,
 		SigCache:      sigCache,
 		IndexManager:  indexManager,
 		DAGType:       cfg.DAGType,
 		BlockVersion:  blockVersion,
+		PruneTarget:   cfg.Prune * 1024 * 1024,
+		StateDB:       bm.stateDB,
 	})
 	if err != nil {
+		if bm.stateDB != nil {
+			bm.stateDB.Close()
+		}
 		return nil, err
 	}
 	best := bm.chain.BestSnapshot()
 	bm.chain.DisableCheckpoints(cfg.DisableCheckpoints)
-	if !cfg.DisableCheckpoints {
-		// Initialize the next checkpoint based on the current height.
-		bm.nextCheckpoint = bm.findNextHeaderCheckpoint(uint64(best.GraphState.GetMainHeight()))
-		if bm.nextCheckpoint != nil {
-			bm.resetHeaderState(&best.Hash, uint64(best.GraphState.GetMainHeight()))
-		}
-	} else {
+	if cfg.DisableCheckpoints {
 		log.Info("Checkpoints are disabled")
 	}
 
+	if cfg.LoadUtxoSnapshot != "" {
+		err = bm.chain.LoadUtxoSnapshot(cfg.LoadUtxoSnapshot, interrupt)
+		if err != nil {
+			if bm.stateDB != nil {
+				bm.stateDB.Close()
+			}
+			return nil, err
+		}
+	}
+
 	if cfg.DumpBlockchain != "" {
 		err = bm.chain.DumpBlockChain(cfg.DumpBlockchain, par, uint64(best.GraphState.GetTotal())-1)
 		if err != nil {
@@ -136,6 +175,35 @@ func NewBlockManager(ntmgr notify.Notify, indexManager blockchain.IndexManager,
 		return nil, fmt.Errorf("closing after dumping blockchain")
 	}
 
+	if cfg.ImportBlockchain != "" {
+		err = bm.chain.ImportBlockChain(cfg.ImportBlockchain, par,
+			cfg.ImportSkipScripts, interrupt)
+		if bm.stateDB != nil {
+			bm.stateDB.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("closing after importing blockchain")
+	}
+
+	if cfg.DumpUtxoSnapshot != "" {
+		order := cfg.UtxoSnapshotOrder
+		if order == 0 {
+			order = uint64(best.GraphState.GetMainOrder())
+		}
+		_, err = bm.chain.DumpUtxoSnapshot(cfg.DumpUtxoSnapshot, order)
+		if bm.stateDB != nil {
+			bm.stateDB.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("closing after dumping utxo snapshot")
+	}
+
 	bm.syncGSMtx.Lock()
 	bm.syncGS = best.GraphState
 	bm.syncGSMtx.Unlock()
@@ -145,6 +213,8 @@ func NewBlockManager(ntmgr notify.Notify, indexManager blockchain.IndexManager,
 // handleNotifyMsg handles notifications from blockchain.  It does things such
 // as request orphan block parents and relay accepted blocks to connected peers.
 func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
+	defer b.notifySubscribers(notification)
+
 	switch notification.Type {
 	// A block has been accepted into the block chain.  Relay it to other peers
 	// and possibly notify RPC clients with the winning tickets.
@@ -256,7 +326,7 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 				// Remove the transaction and all transactions
 				// that depend on it if it wasn't accepted into
 				// the transaction pool.
-				b.chain.GetTxManager().MemPool().RemoveTransaction(tx, true)
+				b.chain.GetTxManager().MemPool().RemoveReorgedTransaction(tx)
 			}
 		}
 
@@ -289,6 +359,25 @@ func (b *BlockManager) handleNotifyMsg(notification *blockchain.Notification) {
 	}
 }
 
+// Subscribe registers a callback which is invoked with every notification
+// sent by the block chain, after the block manager has handled it.
+func (b *BlockManager) Subscribe(callback blockchain.NotificationCallback) {
+	b.subscribersMtx.Lock()
+	b.subscribers = append(b.subscribers, callback)
+	b.subscribersMtx.Unlock()
+}
+
+// notifySubscribers forwards a block chain notification to all of the
+// registered subscribers.
+func (b *BlockManager) notifySubscribers(notification *blockchain.Notification) {
+	b.subscribersMtx.RLock()
+	defer b.subscribersMtx.RUnlock()
+
+	for _, callback := range b.subscribers {
+		callback(notification)
+	}
+}
+
 // current returns true if we believe we are synced with our peers, false if we
 // still have blocks to check
 func (b *BlockManager) current() bool {
@@ -339,6 +428,9 @@ func (b *BlockManager) Stop() error {
 func (b *BlockManager) WaitForStop() {
 	log.Info("Wait For Block manager stop ...")
 	b.wg.Wait()
+	if b.stateDB != nil {
+		b.stateDB.Close()
+	}
 	log.Info("Block manager stopped")
 }
 
@@ -360,56 +452,6 @@ func (b *BlockManager) limitMap(m map[hash.Hash]struct{}, limit int) {
 	}
 }
 
-// fetchHeaderBlocks creates and sends a request to the syncPeer for the next
-// list of blocks to be downloaded based on the current list of headers.
-func (b *BlockManager) fetchHeaderBlocks() {
-	// Nothing to do if there is no start header.
-	if b.startHeader == nil {
-		log.Warn("fetchHeaderBlocks called with no start header")
-		return
-	}
-
-	// Build up a getdata request for the list of blocks the headers
-	// describe.  The size hint will be limited to wire.MaxInvPerMsg by
-	// the function, so no need to double check it here.
-	gdmsg := message.NewMsgGetDataSizeHint(uint(b.headerList.Len()))
-	numRequested := 0
-	for e := b.startHeader; e != nil; e = e.Next() {
-		node, ok := e.Value.(*headerNode)
-		if !ok {
-			log.Warn("Header list node type is not a headerNode")
-			continue
-		}
-
-		iv := message.NewInvVect(message.InvTypeBlock, node.hash)
-		haveInv, err := b.haveInventory(iv)
-		if err != nil {
-			log.Warn("Unexpected failure when checking for "+
-				"existing inventory during header block fetch",
-				"error", err)
-			continue
-		}
-		if !haveInv {
-			b.requestedBlocks[*node.hash] = struct{}{}
-			b.requestedEverBlocks[*node.hash] = 0
-			b.syncPeer.RequestedBlocks[*node.hash] = struct{}{}
-			err = gdmsg.AddInvVect(iv)
-			if err != nil {
-				log.Warn("Failed to add invvect while fetching block headers",
-					"error", err)
-			}
-			numRequested++
-		}
-		b.startHeader = e.Next()
-		if numRequested >= message.MaxInvPerMsg {
-			break
-		}
-	}
-	if len(gdmsg.InvList) > 0 {
-		b.syncPeer.QueueMessage(gdmsg, nil)
-	}
-}
-
 // haveInventory returns whether or not the inventory represented by the passed
 // inventory vector is known.  This includes checking all of the various places
 // inventory can be when it is in different states such as blocks that are part
@@ -448,58 +490,11 @@ func (b *BlockManager) haveInventory(invVect *message.InvVect) (bool, error) {
 	return true, nil
 }
 
-// findNextHeaderCheckpoint returns the next checkpoint after the passed layer.
-// It returns nil when there is not one either because the height is already
-// later than the final checkpoint or some other reason such as disabled
-// checkpoints.
-func (b *BlockManager) findNextHeaderCheckpoint(layer uint64) *params.Checkpoint {
-	// There is no next checkpoint if checkpoints are disabled or there are
-	// none for this current network.
-	if b.config.DisableCheckpoints {
-		return nil
-	}
-	checkpoints := b.params.Checkpoints
-	if len(checkpoints) == 0 {
-		return nil
-	}
-
-	// There is no next checkpoint if the height is already after the final
-	// checkpoint.
-	finalCheckpoint := &checkpoints[len(checkpoints)-1]
-	if layer >= finalCheckpoint.Layer {
-		return nil
-	}
-
-	// Find the next checkpoint.
-	nextCheckpoint := finalCheckpoint
-	for i := len(checkpoints) - 2; i >= 0; i-- {
-		if layer >= checkpoints[i].Layer {
-			break
-		}
-		nextCheckpoint = &checkpoints[i]
-	}
-	return nextCheckpoint
-}
-
-// resetHeaderState sets the headers-first mode state to values appropriate for
-// syncing from a new peer.
-func (b *BlockManager) resetHeaderState(newestHash *hash.Hash, newestHeight uint64) {
-	b.headersFirstMode = false
-	b.headerList.Init()
-	b.startHeader = nil
-
-	// When there is a next checkpoint, add an entry for the latest known
-	// block into the header pool.  This allows the next downloaded header
-	// to prove it links to the chain properly.
-	if b.nextCheckpoint != nil {
-		node := headerNode{height: newestHeight, hash: newestHash}
-		b.headerList.PushBack(&node)
-	}
-}
-
 func (b *BlockManager) blockHandler() {
 	stallTicker := time.NewTicker(StallSampleInterval)
 	defer stallTicker.Stop()
+	downloadTicker := time.NewTicker(blockRequestCheckInterval)
+	defer downloadTicker.Stop()
 
 out:
 	for {
@@ -518,6 +513,12 @@ out:
 			case *invMsg:
 				log.Trace("blkmgr msgChan invMsg", "msg", msg)
 				b.handleInvMsg(msg)
+			case *headersMsg:
+				log.Trace("blkmgr msgChan headersMsg", "msg", msg)
+				b.handleHeadersMsg(msg)
+			case *notFoundMsg:
+				log.Trace("blkmgr msgChan notFoundMsg", "msg", msg)
+				b.handleNotFoundMsg(msg)
 			case *donePeerMsg:
 				log.Trace("blkmgr msgChan donePeerMsg", "msg", msg)
 				b.handleDonePeerMsg(msg.peer)
@@ -567,17 +568,6 @@ out:
 					b.chain.GetTxManager().MemPool().PruneExpiredTx()
 				}
 
-				// Allow any clients performing long polling via the
-				// getblocktemplate RPC to be notified when the new block causes
-				// their old block template to become stale.
-				// TODO, re-impl the client notify by subscript/publish
-				/*
-					rpcServer := b.rpcServer
-					if rpcServer != nil {
-						rpcServer.gbtWorkState.NotifyBlockConnected(msg.block.Hash())
-					}
-				*/
-
 				msg.reply <- processBlockResponse{
 					isOrphan: isOrphan,
 					err:      nil,
@@ -629,6 +619,9 @@ out:
 		case <-stallTicker.C:
 			b.handleStallSample()
 
+		case <-downloadTicker.C:
+			b.handleBlockRequestTimeouts()
+
 		case <-b.quit:
 			log.Trace("blkmgr quit received, break out")
 			break out
@@ -796,6 +789,44 @@ func (b *BlockManager) QueueInv(inv *message.MsgInv, sp *peer.ServerPeer) {
 	b.msgChan <- &invMsg{inv: inv, peer: sp}
 }
 
+// headersMsg packages a headers message and the peer it came from together
+// so the block handler has access to that information.
+type headersMsg struct {
+	headers *message.MsgHeaders
+	peer    *peer.ServerPeer
+}
+
+// QueueHeaders adds the passed headers message and peer to the block handling
+// queue.
+func (b *BlockManager) QueueHeaders(headers *message.MsgHeaders, sp *peer.ServerPeer) {
+	// No channel handling here because peers do not need to block on
+	// headers messages.
+	if atomic.LoadInt32(&b.shutdown) != 0 {
+		return
+	}
+
+	b.msgChan <- &headersMsg{headers: headers, peer: sp}
+}
+
+// notFoundMsg packages a notfound message and the peer it came from together
+// so the block handler has access to that information.
+type notFoundMsg struct {
+	notFound *message.MsgNotFound
+	peer     *peer.ServerPeer
+}
+
+// QueueNotFound adds the passed notfound message and peer to the block handling
+// queue.
+func (b *BlockManager) QueueNotFound(notFound *message.MsgNotFound, sp *peer.ServerPeer) {
+	// No channel handling here because peers do not need to block on
+	// notfound messages.
+	if atomic.LoadInt32(&b.shutdown) != 0 {
+		return
+	}
+
+	b.msgChan <- &notFoundMsg{notFound: notFound, peer: sp}
+}
+
 // tipGenerationResponse is a response sent to the reply channel of a
 // tipGenerationMsg query.
 type tipGenerationResponse struct {
@@ -963,8 +994,8 @@ func (b *BlockManager) clearRequestedState(sp *peer.ServerPeer) {
 
 // updateSyncPeer choose a new sync peer to replace the current one. If
 // dcSyncPeer is true, this method will also disconnect the current sync peer.
-// If we are in header first mode, any header state related to prefetching is
-// also reset in preparation for the next sync peer.
+// Any header state related to prefetching is also reset in preparation for the
+// next sync peer.
 func (b *BlockManager) updateSyncPeer(dcSyncPeer bool) {
 	log.Debug(fmt.Sprintf("Updating sync peer, no progress for: %v",
 		time.Since(b.lastProgressTime)))
@@ -975,10 +1006,7 @@ func (b *BlockManager) updateSyncPeer(dcSyncPeer bool) {
 	}
 
 	// Reset any header state before we choose our next active sync peer.
-	if b.headersFirstMode {
-		best := b.chain.BestSnapshot()
-		b.resetHeaderState(&best.Hash, uint64(best.GraphState.GetMainHeight()))
-	}
+	b.resetHeaderState()
 
 	b.syncPeer = nil
 	b.startSync()
@@ -988,10 +1016,3 @@ func (b *BlockManager) updateSyncPeer(dcSyncPeer bool) {
 func (b *BlockManager) ChainParams() *params.Params {
 	return b.params
 }
-
-// headerNode is used as a node in a list of headers that are linked together
-// between checkpoints.
-type headerNode struct {
-	height uint64
-	hash   *hash.Hash
-}
@@ -0,0 +1,287 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package blkmgr
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"time"
+)
+
+const (
+	// blockDownloadWindow is the number of headers at the front of the
+	// header list whose blocks are downloaded.  The blocks are processed
+	// in the order of the header list, so the window bounds the number of
+	// blocks which wait for the blocks before them.
+	blockDownloadWindow = 1024
+
+	// maxInFlightPerPeer is the maximum number of blocks which are
+	// requested from a single peer at once.
+	maxInFlightPerPeer = 128
+
+	// blockRequestTimeout is the time after which a block which was not
+	// received is requested from another peer.
+	blockRequestTimeout = 30 * time.Second
+
+	// blockRequestCheckInterval is the interval at which the requests of
+	// the blocks are checked for timeouts.
+	blockRequestCheckInterval = 5 * time.Second
+)
+
+// downloadPeers returns the peers the blocks of the header list are requested
+// from.  These are the full nodes which know more blocks than the block DAG,
+// the sync peer first.  When a preferred peer is passed, which is the peer that
+// announced the headers, it is the only one.
+func (b *BlockManager) downloadPeers(preferred *peer.ServerPeer) []*peer.ServerPeer {
+	if preferred != nil {
+		return []*peer.ServerPeer{preferred}
+	}
+	peers := []*peer.ServerPeer{}
+	if b.syncPeer != nil {
+		peers = append(peers, b.syncPeer)
+	}
+	best := b.chain.BestSnapshot()
+	for _, sp := range b.peers {
+		if sp == b.syncPeer || !sp.Connected() ||
+			sp.Services()&protocol.Full != protocol.Full {
+			continue
+		}
+		if !sp.LastGS().IsExcellent(best.GraphState) {
+			continue
+		}
+		peers = append(peers, sp)
+	}
+	return peers
+}
+
+// fetchHeaderBlocks creates and sends requests for the blocks of the headers
+// in the download window which are not requested yet.
+func (b *BlockManager) fetchHeaderBlocks(preferred *peer.ServerPeer) {
+	peers := b.downloadPeers(preferred)
+	if len(peers) == 0 {
+		return
+	}
+	for sp, gdmsg := range b.assignHeaderBlocks(peers) {
+		log.Trace(fmt.Sprintf("Requesting %d blocks from %s",
+			len(gdmsg.InvList), sp))
+		sp.QueueMessage(gdmsg, nil)
+	}
+}
+
+// assignHeaderBlocks assigns the blocks of the headers in the download window
+// which are not requested yet to the passed peers and returns the requests to
+// send.  The blocks are split across the peers in turn, each of which has at
+// most maxInFlightPerPeer blocks in flight.
+func (b *BlockManager) assignHeaderBlocks(peers []*peer.ServerPeer) map[*peer.ServerPeer]*message.MsgGetData {
+	requests := make(map[*peer.ServerPeer]*message.MsgGetData)
+	next := 0
+	e := b.headerList.Front()
+	for i := 0; e != nil && i < blockDownloadWindow; i++ {
+		node := e.Value.(*headerNode)
+		e = e.Next()
+		if node.peer != nil || node.block != nil {
+			continue
+		}
+		if _, exists := b.requestedBlocks[*node.hash]; exists {
+			continue
+		}
+
+		iv := message.NewInvVect(message.InvTypeBlock, node.hash)
+		haveInv, err := b.haveInventory(iv)
+		if err != nil {
+			log.Warn("Unexpected failure when checking for "+
+				"existing inventory during header block fetch",
+				"error", err)
+			continue
+		}
+		if haveInv {
+			b.removeHeader(node.hash)
+			continue
+		}
+
+		// Choose the next peer with room for more blocks, skipping the
+		// peers which failed to send the block before unless there are
+		// no others.
+		var sp *peer.ServerPeer
+		for _, skipFailed := range []bool{true, false} {
+			for j := 0; j < len(peers) && sp == nil; j++ {
+				candidate := peers[(next+j)%len(peers)]
+				if len(candidate.RequestedBlocks) >= maxInFlightPerPeer {
+					continue
+				}
+				if skipFailed && node.hasFailed(candidate) {
+					continue
+				}
+				sp = candidate
+				next = (next + j + 1) % len(peers)
+			}
+			if sp != nil {
+				break
+			}
+		}
+		if sp == nil {
+			// All of the download peers are busy.
+			break
+		}
+
+		gdmsg, exists := requests[sp]
+		if !exists {
+			gdmsg = message.NewMsgGetDataSizeHint(maxInFlightPerPeer)
+			requests[sp] = gdmsg
+		}
+		err = gdmsg.AddInvVect(iv)
+		if err != nil {
+			log.Warn("Failed to add invvect while fetching block headers",
+				"error", err)
+			continue
+		}
+		node.peer = sp
+		node.requestTime = time.Now()
+		b.requestedBlocks[*node.hash] = struct{}{}
+		b.requestedEverBlocks[*node.hash] = 0
+		sp.RequestedBlocks[*node.hash] = struct{}{}
+	}
+	return requests
+}
+
+// hasFailed returns whether the passed peer failed to send the block of the
+// header node before.
+func (node *headerNode) hasFailed(sp *peer.ServerPeer) bool {
+	for _, failed := range node.failedPeers {
+		if failed == sp {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelBlockRequest cancels the request of the block of the header node, so
+// the block is requested again from another peer.
+func (b *BlockManager) cancelBlockRequest(node *headerNode) {
+	sp := node.peer
+	delete(sp.RequestedBlocks, *node.hash)
+	delete(b.requestedBlocks, *node.hash)
+	node.failedPeers = append(node.failedPeers, sp)
+	node.peer = nil
+}
+
+// handleBlockRequestTimeouts requests the blocks of the header list which were
+// not received in time from other peers.
+func (b *BlockManager) handleBlockRequestTimeouts() {
+	var numCanceled int
+	now := time.Now()
+	e := b.headerList.Front()
+	for i := 0; e != nil && i < blockDownloadWindow; e, i = e.Next(), i+1 {
+		node := e.Value.(*headerNode)
+		if node.peer == nil || node.block != nil ||
+			now.Sub(node.requestTime) < blockRequestTimeout {
+			continue
+		}
+		log.Debug(fmt.Sprintf("Request of block %s from %s timed out",
+			node.hash, node.peer))
+		b.cancelBlockRequest(node)
+		numCanceled++
+	}
+	if numCanceled > 0 {
+		b.fetchHeaderBlocks(nil)
+	}
+}
+
+// forgetFailedPeer removes the passed peer from the peers which failed to send
+// the block of the header node.
+func (node *headerNode) forgetFailedPeer(sp *peer.ServerPeer) {
+	failedPeers := node.failedPeers[:0]
+	for _, failed := range node.failedPeers {
+		if failed != sp {
+			failedPeers = append(failedPeers, failed)
+		}
+	}
+	// Clear the removed pointers so the peers can be freed.
+	for i := len(failedPeers); i < len(node.failedPeers); i++ {
+		node.failedPeers[i] = nil
+	}
+	if len(failedPeers) == 0 {
+		failedPeers = nil
+	}
+	node.failedPeers = failedPeers
+}
+
+// cancelPeerBlockRequests cancels the requests of the blocks of the header list
+// which the passed disconnected peer didn't send, and forgets the peer as one
+// which failed to send blocks, so the header list doesn't keep it alive.
+func (b *BlockManager) cancelPeerBlockRequests(sp *peer.ServerPeer) {
+	for e := b.headerList.Front(); e != nil; e = e.Next() {
+		node := e.Value.(*headerNode)
+		if node.peer == sp && node.block == nil {
+			b.cancelBlockRequest(node)
+		}
+		node.forgetFailedPeer(sp)
+	}
+}
+
+// popDownloadedHeaders removes the header nodes at the front of the header list
+// whose blocks are downloaded and returns them in the order of the list.
+func (b *BlockManager) popDownloadedHeaders() []*headerNode {
+	var nodes []*headerNode
+	for e := b.headerList.Front(); e != nil; e = b.headerList.Front() {
+		node := e.Value.(*headerNode)
+		if node.block == nil {
+			break
+		}
+		b.removeHeader(node.hash)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// processHeaderBlocks processes the downloaded blocks at the front of the
+// header list.  The blocks are processed in the order of their headers, which
+// is a topological order, so no block is processed before its parents.
+func (b *BlockManager) processHeaderBlocks() {
+	for _, node := range b.popDownloadedHeaders() {
+		b.processBlock(node.block, node.peer)
+	}
+}
+
+// handleNotFoundMsg handles notfound messages from all peers.  The blocks of
+// the header list which the peer doesn't have are requested from other peers.
+func (b *BlockManager) handleNotFoundMsg(nmsg *notFoundMsg) {
+	sp, exists := b.peers[nmsg.peer.Peer]
+	if !exists {
+		log.Warn(fmt.Sprintf("Received notfound message from unknown peer %s", nmsg.peer))
+		return
+	}
+
+	var numCanceled int
+	for _, iv := range nmsg.notFound.InvList {
+		switch iv.Type {
+		case message.InvTypeBlock:
+			e, exists := b.headerIndex[iv.Hash]
+			if exists {
+				node := e.Value.(*headerNode)
+				if node.peer == sp && node.block == nil {
+					b.cancelBlockRequest(node)
+					numCanceled++
+				}
+				continue
+			}
+			if _, exists := sp.RequestedBlocks[iv.Hash]; exists {
+				delete(sp.RequestedBlocks, iv.Hash)
+				delete(b.requestedBlocks, iv.Hash)
+			}
+		case message.InvTypeTx:
+			if _, exists := sp.RequestedTxns[iv.Hash]; exists {
+				delete(sp.RequestedTxns, iv.Hash)
+				delete(b.requestedTxns, iv.Hash)
+			}
+		}
+	}
+	if numCanceled > 0 {
+		log.Debug(fmt.Sprintf("Peer %s doesn't have %d requested blocks",
+			sp, numCanceled))
+		b.fetchHeaderBlocks(nil)
+	}
+}
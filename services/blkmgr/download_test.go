@@ -0,0 +1,252 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blkmgr
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestBlockManager returns a block manager on a new privnet chain, whose
+// database is removed by the returned function.
+func newTestBlockManager(t *testing.T) (*BlockManager, func()) {
+	dbPath, err := ioutil.TempDir("", "blkmgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	par := &params.PrivNetParams
+	db, err := database.Create("ffldb", dbPath, par.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("Failed to create the database: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  par,
+		TimeSource:   blockchain.NewMedianTime(),
+		DAGType:      "phantom",
+		BlockVersion: 8,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("Failed to create the block chain: %v", err)
+	}
+	b := &BlockManager{
+		params:              par,
+		chain:               chain,
+		requestedBlocks:     make(map[hash.Hash]struct{}),
+		requestedEverBlocks: make(map[hash.Hash]uint8),
+		peers:               make(map[*peer.Peer]*peer.ServerPeer),
+		headerList:          list.New(),
+		headerIndex:         make(map[hash.Hash]*list.Element),
+	}
+	return b, teardown
+}
+
+// newTestPeers returns the number of peers which aren't connected, so the
+// messages queued to them are dropped.
+func newTestPeers(t *testing.T, n int) []*peer.ServerPeer {
+	peers := make([]*peer.ServerPeer, 0, n)
+	for i := 0; i < n; i++ {
+		p, err := peer.NewOutboundPeer(&peer.Config{
+			ChainParams: &params.PrivNetParams,
+		}, fmt.Sprintf("127.0.0.1:%d", 18130+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, &peer.ServerPeer{
+			Peer:            p,
+			RequestedBlocks: make(map[hash.Hash]struct{}),
+			RequestedTxns:   make(map[hash.Hash]struct{}),
+		})
+	}
+	return peers
+}
+
+// addTestHeaders appends the number of headers of unknown blocks to the header
+// list and returns their nodes.
+func addTestHeaders(b *BlockManager, n int) []*headerNode {
+	nodes := make([]*headerNode, 0, n)
+	for i := 0; i < n; i++ {
+		blockHash := hash.HashH([]byte(fmt.Sprintf("block %d %d",
+			b.headerList.Len(), i)))
+		b.addHeader(&blockHash, nil)
+		nodes = append(nodes, b.headerList.Back().Value.(*headerNode))
+	}
+	return nodes
+}
+
+// TestAssignHeaderBlocks checks that the blocks of the header list are split
+// across the download peers in turn, without exceeding the blocks in flight
+// of a peer and avoiding the peers which failed to send a block.
+func TestAssignHeaderBlocks(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+	peers := newTestPeers(t, 3)
+
+	nodes := addTestHeaders(b, 10)
+	requests := b.assignHeaderBlocks(peers)
+	for i, node := range nodes {
+		want := peers[i%len(peers)]
+		if node.peer != want {
+			t.Fatalf("Block %d was assigned to %s, want %s", i, node.peer,
+				want)
+		}
+		if _, ok := want.RequestedBlocks[*node.hash]; !ok {
+			t.Fatalf("Block %d isn't in flight from its peer", i)
+		}
+		if _, ok := b.requestedBlocks[*node.hash]; !ok {
+			t.Fatalf("Block %d isn't requested", i)
+		}
+	}
+	for i, sp := range peers {
+		want := len(nodes) / len(peers)
+		if i < len(nodes)%len(peers) {
+			want++
+		}
+		if got := len(requests[sp].InvList); got != want {
+			t.Errorf("Requested %d blocks from peer %d, want %d", got, i,
+				want)
+		}
+	}
+
+	// The requested blocks aren't requested again.
+	if requests := b.assignHeaderBlocks(peers); len(requests) != 0 {
+		t.Fatalf("Requested the blocks again from %d peers", len(requests))
+	}
+
+	// The peers which failed to send a block are avoided, unless there
+	// are no others, and the busy peers are skipped.
+	nodes = addTestHeaders(b, 2)
+	nodes[0].failedPeers = []*peer.ServerPeer{peers[0], peers[1]}
+	nodes[1].failedPeers = []*peer.ServerPeer{peers[0], peers[1]}
+	for i := len(peers[2].RequestedBlocks); i < maxInFlightPerPeer-1; i++ {
+		peers[2].RequestedBlocks[hash.HashH([]byte{byte(i)})] = struct{}{}
+	}
+	b.assignHeaderBlocks(peers)
+	if nodes[0].peer != peers[2] {
+		t.Errorf("Block of failed peers was assigned to %s, want %s",
+			nodes[0].peer, peers[2])
+	}
+	if nodes[1].peer != peers[0] && nodes[1].peer != peers[1] {
+		t.Errorf("Block was assigned to %s while the other peers are "+
+			"busy", nodes[1].peer)
+	}
+	for i := len(peers[0].RequestedBlocks); i < maxInFlightPerPeer; i++ {
+		peers[0].RequestedBlocks[hash.HashH([]byte{0, byte(i)})] = struct{}{}
+	}
+	for i := len(peers[1].RequestedBlocks); i < maxInFlightPerPeer; i++ {
+		peers[1].RequestedBlocks[hash.HashH([]byte{1, byte(i)})] = struct{}{}
+	}
+	nodes = addTestHeaders(b, 1)
+	if requests := b.assignHeaderBlocks(peers); len(requests) != 0 ||
+		nodes[0].peer != nil {
+		t.Errorf("Block was assigned while all the peers are busy")
+	}
+}
+
+// TestBlockRequestTimeout checks that the blocks which weren't received in
+// time are requested from another peer.
+func TestBlockRequestTimeout(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+	peers := newTestPeers(t, 2)
+	b.syncPeer = peers[1]
+
+	nodes := addTestHeaders(b, 2)
+	b.assignHeaderBlocks(peers[:1])
+	nodes[0].requestTime = time.Now().Add(-blockRequestTimeout - time.Second)
+
+	b.handleBlockRequestTimeouts()
+	if nodes[0].peer != peers[1] {
+		t.Fatalf("Timed out block was assigned to %s, want %s",
+			nodes[0].peer, peers[1])
+	}
+	if _, ok := peers[0].RequestedBlocks[*nodes[0].hash]; ok {
+		t.Fatalf("Timed out block is still in flight from its peer")
+	}
+	if !nodes[0].hasFailed(peers[0]) {
+		t.Fatalf("The peer which timed out didn't fail")
+	}
+	if nodes[1].peer != peers[0] || nodes[1].hasFailed(peers[0]) {
+		t.Fatalf("The block which didn't time out was reassigned")
+	}
+}
+
+// TestCancelPeerBlockRequests checks that the blocks which a disconnected peer
+// didn't send are requested again and that the peer is forgotten.
+func TestCancelPeerBlockRequests(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+	peers := newTestPeers(t, 2)
+
+	nodes := addTestHeaders(b, 3)
+	b.assignHeaderBlocks(peers[:1])
+	nodes[1].block = types.NewBlock(params.PrivNetParams.GenesisBlock)
+	nodes[2].failedPeers = []*peer.ServerPeer{peers[0], peers[1]}
+
+	b.cancelPeerBlockRequests(peers[0])
+	if nodes[0].peer != nil || len(nodes[0].failedPeers) != 0 {
+		t.Errorf("Request of the missing block wasn't canceled")
+	}
+	if _, ok := b.requestedBlocks[*nodes[0].hash]; ok {
+		t.Errorf("The missing block is still requested")
+	}
+	if nodes[1].peer != peers[0] {
+		t.Errorf("The peer of the received block was reset")
+	}
+	if len(nodes[2].failedPeers) != 1 || nodes[2].failedPeers[0] != peers[1] {
+		t.Errorf("Got failed peers %v, want %v", nodes[2].failedPeers,
+			peers[1:])
+	}
+	if removed := nodes[2].failedPeers[:2][1]; removed != nil {
+		t.Errorf("The removed failed peer %s is still referenced", removed)
+	}
+}
+
+// TestPopDownloadedHeaders checks that the downloaded blocks are only processed
+// in the order of the header list.
+func TestPopDownloadedHeaders(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+
+	nodes := addTestHeaders(b, 4)
+	nodes[1].block = types.NewBlock(params.PrivNetParams.GenesisBlock)
+	nodes[2].block = types.NewBlock(params.PrivNetParams.GenesisBlock)
+	if popped := b.popDownloadedHeaders(); len(popped) != 0 {
+		t.Fatalf("Popped %d headers before the first block", len(popped))
+	}
+
+	nodes[0].block = types.NewBlock(params.PrivNetParams.GenesisBlock)
+	popped := b.popDownloadedHeaders()
+	if len(popped) != 3 {
+		t.Fatalf("Popped %d headers, want 3", len(popped))
+	}
+	for i, node := range popped {
+		if node != nodes[i] {
+			t.Errorf("Popped header %s at %d, want %s", node.hash, i,
+				nodes[i].hash)
+		}
+		if _, ok := b.headerIndex[*node.hash]; ok {
+			t.Errorf("Popped header %d is still indexed", i)
+		}
+	}
+	if b.headerList.Len() != 1 || b.headerList.Front().Value != nodes[3] {
+		t.Errorf("The header without a block isn't left in the list")
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package blkmgr
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"time"
+)
+
+const (
+	// maxQueuedHeaders is the number of headers in the header list above
+	// which no more headers are requested until their blocks are
+	// downloaded.
+	maxQueuedHeaders = 2 * message.MaxBlockHeadersPerMsg
+)
+
+// headerNode is used as a node in the list of headers whose blocks are to be
+// downloaded.  The peer is the one the block is requested from, or the one
+// which sent it once the block is downloaded.
+type headerNode struct {
+	hash        *hash.Hash
+	parents     []*hash.Hash
+	block       *types.SerializedBlock
+	peer        *peer.ServerPeer
+	requestTime time.Time
+
+	// failedPeers are the peers which didn't send the block in time.
+	failedPeers []*peer.ServerPeer
+}
+
+// handleHeadersMsg handles headers messages from all peers.  Every header is
+// checked for sanity and proof of work and must connect to the block DAG or to
+// the headers received before it.  The blocks of the accepted headers are then
+// requested, from the sync peer when in headers-first mode or from the
+// announcing peer otherwise.
+func (b *BlockManager) handleHeadersMsg(hmsg *headersMsg) {
+	sp, exists := b.peers[hmsg.peer.Peer]
+	if !exists {
+		log.Warn(fmt.Sprintf("Received headers message from unknown peer %s", hmsg.peer))
+		return
+	}
+	msg := hmsg.headers
+	if msg.GS != nil {
+		sp.UpdateLastGS(msg.GS)
+	}
+
+	isSyncPeer := sp == b.syncPeer
+	if isSyncPeer {
+		b.headersRequested = false
+	} else if !b.current() {
+		// Ignore announcements from peers that aren't the sync peer if
+		// we are not current.
+		return
+	}
+
+	connected := true
+	numAdded := 0
+	for i, header := range msg.Headers {
+		if i >= len(msg.Parents) || len(msg.Parents[i]) == 0 {
+			log.Debug(fmt.Sprintf("Received header without parents from %s", sp))
+			connected = false
+			break
+		}
+		blockHash := header.BlockHash()
+		sp.AddKnownInventory(message.NewInvVect(message.InvTypeBlock, &blockHash))
+
+		// Skip the headers of known blocks.
+		if _, exists := b.headerIndex[blockHash]; exists {
+			continue
+		}
+		haveBlock, err := b.chain.HaveBlock(&blockHash)
+		if err != nil {
+			log.Warn("Unexpected failure when checking for existing block "+
+				"during headers message processing", "error", err)
+			continue
+		}
+		if haveBlock {
+			continue
+		}
+
+		parents := msg.Parents[i]
+		err = blockchain.CheckHeaderSanity(header, parents, b.chain.TimeSource(), b.params)
+		if err != nil {
+			log.Info(fmt.Sprintf("Rejected header %v from %s: %v",
+				blockHash, sp, err))
+			sp.Disconnect()
+			return
+		}
+
+		// The remaining headers can't connect either if this one doesn't,
+		// since the headers are sent in topological order.
+		if !b.headerConnects(parents) {
+			log.Debug(fmt.Sprintf("Received header %s from %s which doesn't "+
+				"connect", blockHash, sp))
+			connected = false
+			break
+		}
+		b.addHeader(&blockHash, parents)
+		numAdded++
+	}
+	if numAdded > 0 {
+		log.Debug(fmt.Sprintf("Received %d new headers from %s", numAdded, sp))
+	}
+
+	if !b.headersFirstMode {
+		// Request the headers of the missing parents from the peer that
+		// announced a header which doesn't connect.
+		if !connected {
+			b.requestHeaders(sp)
+		}
+		b.fetchHeaderBlocks(sp)
+		return
+	}
+
+	if isSyncPeer {
+		b.lastProgressTime = time.Now()
+		b.moreHeaders = connected && len(msg.Headers) >= message.MaxBlockHeadersPerMsg
+		if !connected {
+			b.requestHeaders(sp)
+		}
+		b.syncHeadersFirst()
+	}
+}
+
+// headerConnects returns whether all of the passed parents are either blocks
+// of the block DAG or headers of the header list.
+func (b *BlockManager) headerConnects(parents []*hash.Hash) bool {
+	for _, parent := range parents {
+		if _, exists := b.headerIndex[*parent]; exists {
+			continue
+		}
+		if !b.chain.BlockDAG().HasBlock(parent) {
+			return false
+		}
+	}
+	return true
+}
+
+// addHeader appends a verified header to the header list.
+func (b *BlockManager) addHeader(blockHash *hash.Hash, parents []*hash.Hash) {
+	e := b.headerList.PushBack(&headerNode{hash: blockHash, parents: parents})
+	b.headerIndex[*blockHash] = e
+}
+
+// removeHeader removes the header of the passed block from the header list.
+// It returns whether the header was in the list.
+func (b *BlockManager) removeHeader(blockHash *hash.Hash) bool {
+	e, exists := b.headerIndex[*blockHash]
+	if !exists {
+		return false
+	}
+	b.headerList.Remove(e)
+	delete(b.headerIndex, *blockHash)
+	return true
+}
+
+// headerGraphState returns the graph state of the block DAG extended by the
+// headers of the header list.  It is sent with the getheaders requests, so the
+// peer only locates the blocks after the known headers.
+func (b *BlockManager) headerGraphState() *blockdag.GraphState {
+	gs := b.chain.BestSnapshot().GraphState.Clone()
+	if b.headerList.Len() == 0 {
+		return gs
+	}
+	tips := gs.GetTips().Clone()
+	for e := b.headerList.Front(); e != nil; e = e.Next() {
+		node := e.Value.(*headerNode)
+		for _, parent := range node.parents {
+			tips.Remove(parent)
+		}
+		tips.Add(node.hash)
+	}
+	gs.SetTips(tips)
+	gs.SetTotal(gs.GetTotal() + uint(b.headerList.Len()))
+	return gs
+}
+
+// requestHeaders sends a getheaders request for the headers after the known
+// ones to the passed peer.
+func (b *BlockManager) requestHeaders(sp *peer.ServerPeer) {
+	err := sp.PushGetHeadersMsg(b.headerGraphState(), nil)
+	if err != nil {
+		log.Warn("Failed to push getheadersmsg", "peer", sp, "error", err)
+		return
+	}
+	if sp == b.syncPeer {
+		b.headersRequested = true
+	}
+}
+
+// syncHeadersFirst continues the headers-first sync from the sync peer.  More
+// blocks are requested from the download peers while their windows have room,
+// and more headers from the sync peer when the header list is getting short.
+// Headers-first mode ends once all of the blocks the sync peer knows about are
+// downloaded.
+func (b *BlockManager) syncHeadersFirst() {
+	sp := b.syncPeer
+	if !b.headersFirstMode || sp == nil {
+		return
+	}
+
+	b.fetchHeaderBlocks(nil)
+	if b.headersRequested {
+		return
+	}
+	if b.moreHeaders {
+		if b.headerList.Len() < maxQueuedHeaders {
+			b.requestHeaders(sp)
+		}
+		return
+	}
+	if b.headerList.Len() > 0 || len(sp.RequestedBlocks) > 0 {
+		return
+	}
+
+	// The peer may have learned about new blocks in the meantime.
+	best := b.chain.BestSnapshot()
+	if sp.LastGS().IsExcellent(best.GraphState) {
+		b.requestHeaders(sp)
+		return
+	}
+	b.headersFirstMode = false
+	log.Info(fmt.Sprintf("Headers-first sync to state %s from peer %s completed",
+		best.GraphState.String(), sp))
+}
+
+// resetHeaderState sets the headers-first mode state to values appropriate for
+// syncing from a new peer.  The verified headers are kept along with the
+// requests of their blocks.
+func (b *BlockManager) resetHeaderState() {
+	b.headersFirstMode = false
+	b.headersRequested = false
+	b.moreHeaders = false
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package blkmgr
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/params"
+	"testing"
+	"time"
+)
+
+// newTestHeader returns a header on the passed parents which solves its proof
+// of work at the minimum privnet difficulty.  The seed makes the headers on the
+// same parents unique.
+func newTestHeader(t *testing.T, parents []*hash.Hash, seed byte) *types.BlockHeader {
+	par := &params.PrivNetParams
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	header := &types.BlockHeader{
+		Version:    8,
+		ParentRoot: *paMerkles[len(paMerkles)-1],
+		TxRoot:     hash.HashH([]byte{seed}),
+		Timestamp:  par.GenesisBlock.Header.Timestamp.Add(time.Duration(seed+1) * time.Second),
+		Difficulty: par.PowConfig.Blake2bdPowLimitBits,
+	}
+	for nonce := uint32(0); nonce < 1<<20; nonce++ {
+		header.Pow = pow.GetInstance(pow.BLAKE2BD, nonce, []byte{})
+		header.Pow.SetParams(par.PowConfig)
+		err := header.Pow.Verify(header.BlockData(), header.BlockHash(),
+			header.Difficulty)
+		if err == nil {
+			return header
+		}
+	}
+	t.Fatalf("No nonce solves the header")
+	return nil
+}
+
+// newTestHeadersMsg returns a headers message of the passed headers, each one
+// followed by its parents.
+func newTestHeadersMsg(headers []*types.BlockHeader, parents [][]*hash.Hash) *message.MsgHeaders {
+	msg := message.NewMsgHeaders(nil)
+	for i, header := range headers {
+		msg.AddBlockHeaderWithParents(header, parents[i])
+	}
+	return msg
+}
+
+// TestHandleHeadersMsg checks that the headers from the sync peer which connect
+// to the block DAG are added to the header list and their blocks requested,
+// that the headers which don't connect are requested again, and that the peers
+// sending insane headers are disconnected.
+func TestHandleHeadersMsg(t *testing.T) {
+	b, teardown := newTestBlockManager(t)
+	defer teardown()
+	peers := newTestPeers(t, 3)
+	for _, sp := range peers {
+		b.peers[sp.Peer] = sp
+	}
+	syncPeer := peers[0]
+	b.syncPeer = syncPeer
+	b.headersFirstMode = true
+
+	genesis := params.PrivNetParams.GenesisHash
+	h1 := newTestHeader(t, []*hash.Hash{genesis}, 1)
+	hash1 := h1.BlockHash()
+	h2 := newTestHeader(t, []*hash.Hash{&hash1}, 2)
+	hash2 := h2.BlockHash()
+
+	// The headers extending the genesis are added in their order and the
+	// blocks are requested from the sync peer.
+	b.headersRequested = true
+	b.handleHeadersMsg(&headersMsg{
+		headers: newTestHeadersMsg([]*types.BlockHeader{h1, h2},
+			[][]*hash.Hash{{genesis}, {&hash1}}),
+		peer: syncPeer,
+	})
+	if b.headerList.Len() != 2 {
+		t.Fatalf("Got %d headers in the list, want 2", b.headerList.Len())
+	}
+	e := b.headerList.Front()
+	for i, want := range []*hash.Hash{&hash1, &hash2} {
+		node := e.Value.(*headerNode)
+		e = e.Next()
+		if !node.hash.IsEqual(want) {
+			t.Fatalf("Got header %v at %d, want %v", node.hash, i, want)
+		}
+		if node.peer != syncPeer {
+			t.Fatalf("Block %d was requested from %s, want the sync "+
+				"peer", i, node.peer)
+		}
+	}
+	if b.headersRequested || b.moreHeaders {
+		t.Fatalf("More headers are requested after the last headers")
+	}
+
+	// The known headers aren't added again.
+	b.handleHeadersMsg(&headersMsg{
+		headers: newTestHeadersMsg([]*types.BlockHeader{h1},
+			[][]*hash.Hash{{genesis}}),
+		peer: syncPeer,
+	})
+	if b.headerList.Len() != 2 {
+		t.Fatalf("Got %d headers in the list after the known header, "+
+			"want 2", b.headerList.Len())
+	}
+
+	// The headers which don't connect, or which came without parents, are
+	// dropped and the missing headers are requested from the sync peer.
+	unknown := hash.HashH([]byte("unknown"))
+	h3 := newTestHeader(t, []*hash.Hash{&unknown}, 3)
+	h4 := newTestHeader(t, []*hash.Hash{&hash2}, 4)
+	tests := []struct {
+		name    string
+		headers *message.MsgHeaders
+	}{
+		{"unknown parent", newTestHeadersMsg([]*types.BlockHeader{h3},
+			[][]*hash.Hash{{&unknown}})},
+		{"without parents", newTestHeadersMsg([]*types.BlockHeader{h4},
+			[][]*hash.Hash{nil})},
+	}
+	for _, test := range tests {
+		b.headersRequested = false
+		b.handleHeadersMsg(&headersMsg{headers: test.headers, peer: syncPeer})
+		if b.headerList.Len() != 2 {
+			t.Fatalf("%s: got %d headers in the list, want 2", test.name,
+				b.headerList.Len())
+		}
+		if !b.headersRequested {
+			t.Fatalf("%s: the missing headers weren't requested",
+				test.name)
+		}
+	}
+
+	// The announcements of other peers are ignored while the block DAG
+	// isn't current.
+	b.handleHeadersMsg(&headersMsg{
+		headers: newTestHeadersMsg([]*types.BlockHeader{h4},
+			[][]*hash.Hash{{&hash2}}),
+		peer: peers[1],
+	})
+	if b.headerList.Len() != 2 {
+		t.Fatalf("Added the header announced by another peer")
+	}
+
+	// A header whose parent root doesn't commit to its parents is
+	// rejected and its peer disconnected.
+	b.handleHeadersMsg(&headersMsg{
+		headers: newTestHeadersMsg([]*types.BlockHeader{h4},
+			[][]*hash.Hash{{&hash1}}),
+		peer: syncPeer,
+	})
+	if _, exists := b.headerIndex[h4.BlockHash()]; exists {
+		t.Fatalf("Added the header with the wrong parents")
+	}
+	disconnected := make(chan struct{})
+	go func() {
+		syncPeer.WaitForDisconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatalf("The peer sending the header with the wrong parents " +
+			"wasn't disconnected")
+	}
+}
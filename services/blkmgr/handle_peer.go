@@ -56,11 +56,16 @@ func (b *BlockManager) handleDonePeerMsg(sp *peer.ServerPeer) {
 
 	b.clearRequestedState(sp)
 
+	// Request the blocks of the listed headers which the peer didn't send
+	// from the other peers.
+	b.cancelPeerBlockRequests(sp)
+
 	if b.syncPeer == sp {
 		// Update the sync peer. The server has already disconnected the
 		// peer before signaling to the sync manager.
 		b.updateSyncPeer(false)
 	}
+	b.fetchHeaderBlocks(nil)
 }
 
 // isSyncCandidate returns whether or not the peer is a candidate to consider
@@ -150,30 +155,25 @@ func (b *BlockManager) startSync() {
 
 		log.Info(fmt.Sprintf("Syncing to state %s from peer %s cur graph state:%s", bestPeer.LastGS().String(), bestPeer.Addr(), best.GraphState.String()))
 
-		// When the current height is less than a known checkpoint we
-		// can use block headers to learn about which blocks comprise
-		// the chain up to the checkpoint and perform less validation
-		// for them.  This is possible since each header contains the
-		// hash of the previous header and a merkle root.  Therefore if
-		// we validate all of the received headers link together
-		// properly and the checkpoint hashes match, we can be sure the
-		// hashes for the blocks in between are accurate.  Further, once
-		// the full blocks are downloaded, the merkle root is computed
-		// and compared against the value in the header which proves the
-		// full block hasn't been tampered with.
-		//
-		// Once we have passed the final checkpoint, or checkpoints are
-		// disabled, use standard inv messages learn about the blocks
-		// and fully validate them.  Finally, regression test mode does
-		// not support the headers-first approach so do normal block
-		// downloads when in regression test mode.
-		err := bestPeer.PushGetBlocksMsg(best.GraphState, nil)
-		if err != nil {
-			log.Error(fmt.Sprintf("Failed to push getblocksmsg for the "+
-				"latest GS: %v", err))
-			return
-		}
+		// Peers which send the parents along with the headers serve a
+		// headers-first sync: the headers of the missing blocks are
+		// downloaded and checked for proof of work first, then their
+		// blocks are requested in the order of the headers.  Older peers
+		// announce the missing blocks by inventory.
 		b.syncPeer = bestPeer
+		if bestPeer.ProtocolVersion() >= protocol.HeaderParentsVersion {
+			b.resetHeaderState()
+			b.headersFirstMode = true
+			b.requestHeaders(bestPeer)
+		} else {
+			err := bestPeer.PushGetBlocksMsg(best.GraphState, nil)
+			if err != nil {
+				log.Error(fmt.Sprintf("Failed to push getblocksmsg for the "+
+					"latest GS: %v", err))
+				b.syncPeer = nil
+				return
+			}
+		}
 		// Reset the last progress time now that we have a non-nil
 		// syncPeer to avoid instantly detecting it as stalled in the
 		// event the progress time hasn't been updated recently.
@@ -0,0 +1,178 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+// Package blockstats provides the RPC service of the block statistics index
+// which serves the fees, transaction counts and sizes of the blocks.
+package blockstats
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/index"
+	"strconv"
+)
+
+// maxRangeBlocks is the maximum number of blocks whose statistics are
+// returned by a range query.
+const maxRangeBlocks = 1000
+
+// BlockStatsService serves the statistics of the blocks.
+type BlockStatsService struct {
+	statsIndex *index.BlockStatsIndex
+	chain      *blockchain.BlockChain
+}
+
+// New returns a new block statistics service backed by the given index.
+func New(statsIndex *index.BlockStatsIndex, chain *blockchain.BlockChain) *BlockStatsService {
+	return &BlockStatsService{statsIndex: statsIndex, chain: chain}
+}
+
+func (s *BlockStatsService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			NameSpace: rpc.DefaultServiceNameSpace,
+			Service:   NewPublicBlockStatsAPI(s),
+			Public:    true,
+		},
+	}
+}
+
+// blockStats returns the statistics of a block as a json object, which only
+// has the passed fields when they are given.
+func (s *BlockStatsService) blockStats(h *hash.Hash, fields *[]string) (json.OrderedResult, error) {
+	stats, err := s.statsIndex.BlockStats(h)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to fetch block stats")
+	}
+	if stats == nil {
+		return nil, rpc.RpcInvalidError("No block stats for block %s", h)
+	}
+	order := s.chain.BlockDAG().GetBlock(h).GetOrder()
+
+	var avgFee, avgFeeRate uint64
+	if stats.Txs > 1 {
+		avgFee = stats.TotalFee / uint64(stats.Txs-1)
+		avgFeeRate = stats.TotalFee * 1000 / stats.TotalSize
+	}
+	percentiles := make([]uint64, len(stats.FeeRatePercentiles))
+	copy(percentiles, stats.FeeRatePercentiles[:])
+
+	result := json.OrderedResult{
+		{Key: "hash", Val: h.String()},
+		{Key: "order", Val: order},
+		{Key: "txsvalid", Val: stats.Valid},
+		{Key: "size", Val: stats.Size},
+		{Key: "txs", Val: stats.Txs},
+		{Key: "ins", Val: stats.Inputs},
+		{Key: "outs", Val: stats.Outputs},
+		{Key: "totalsize", Val: stats.TotalSize},
+		{Key: "totalout", Val: stats.TotalOut},
+		{Key: "subsidy", Val: stats.Subsidy},
+		{Key: "totalfee", Val: stats.TotalFee},
+		{Key: "minfee", Val: stats.MinFee},
+		{Key: "maxfee", Val: stats.MaxFee},
+		{Key: "avgfee", Val: avgFee},
+		{Key: "minfeerate", Val: stats.MinFeeRate},
+		{Key: "maxfeerate", Val: stats.MaxFeeRate},
+		{Key: "avgfeerate", Val: avgFeeRate},
+		{Key: "feeratepercentiles", Val: percentiles},
+	}
+	if fields == nil || len(*fields) == 0 {
+		return result, nil
+	}
+
+	selected := make(map[string]struct{}, len(*fields))
+	for _, field := range *fields {
+		found := false
+		for _, kv := range result {
+			if kv.Key == field {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, rpc.RpcInvalidError("Invalid selected field %s", field)
+		}
+		selected[field] = struct{}{}
+	}
+	filtered := make(json.OrderedResult, 0, len(selected))
+	for _, kv := range result {
+		if _, ok := selected[kv.Key]; ok {
+			filtered = append(filtered, kv)
+		}
+	}
+	return filtered, nil
+}
+
+type PublicBlockStatsAPI struct {
+	s *BlockStatsService
+}
+
+func NewPublicBlockStatsAPI(s *BlockStatsService) *PublicBlockStatsAPI {
+	return &PublicBlockStatsAPI{s}
+}
+
+// GetBlockStats returns the statistics of a block, given by its hash or its
+// order.  The fee rates are in atoms/kB, and the sizes, outputs and fees
+// don't include the coinbase.  Only the passed fields are returned when
+// they are given.
+func (api *PublicBlockStatsAPI) GetBlockStats(hashOrOrder string, fields *[]string) (interface{}, error) {
+	var h *hash.Hash
+	if len(hashOrOrder) == hash.MaxHashStringSize {
+		var err error
+		h, err = hash.NewHashFromStr(hashOrOrder)
+		if err != nil {
+			return nil, rpc.RpcDecodeHexError(hashOrOrder)
+		}
+		if api.s.chain.BlockDAG().GetBlock(h) == nil {
+			return nil, rpc.RpcInvalidError("Unknown block %s", hashOrOrder)
+		}
+	} else {
+		order, err := strconv.ParseUint(hashOrOrder, 10, 64)
+		if err != nil {
+			return nil, rpc.RpcInvalidError("Invalid block hash or "+
+				"order %s", hashOrOrder)
+		}
+		h, err = api.s.chain.BlockHashByOrder(order)
+		if err != nil {
+			return nil, rpc.RpcInvalidError("No block at order %d", order)
+		}
+	}
+	return api.s.blockStats(h, fields)
+}
+
+// GetBlockStatsByRange returns the statistics of the blocks from the start
+// order to the end order, inclusive.  Only the passed fields are returned
+// when they are given.
+func (api *PublicBlockStatsAPI) GetBlockStatsByRange(start uint, end uint, fields *[]string) (interface{}, error) {
+	if start > end {
+		return nil, rpc.RpcInvalidError("start order %d is after end "+
+			"order %d", start, end)
+	}
+	if end-start >= maxRangeBlocks {
+		return nil, rpc.RpcInvalidError("range of %d blocks exceeds the "+
+			"maximum of %d", end-start+1, maxRangeBlocks)
+	}
+	mainOrder := api.s.chain.BestSnapshot().GraphState.GetMainOrder()
+	if start > mainOrder {
+		return nil, rpc.RpcInvalidError("start order %d is after the "+
+			"last order %d", start, mainOrder)
+	}
+	if end > mainOrder {
+		end = mainOrder
+	}
+	results := make([]json.OrderedResult, 0, end-start+1)
+	for order := start; order <= end; order++ {
+		h, err := api.s.chain.BlockHashByOrder(uint64(order))
+		if err != nil {
+			return nil, rpc.RpcInvalidError("No block at order %d", order)
+		}
+		stats, err := api.s.blockStats(h, fields)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, stats)
+	}
+	return results, nil
+}
@@ -0,0 +1,396 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Copyright (c) 2015-2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"testing"
+)
+
+// TestFilterLarge ensures a maximum sized filter can be created.
+func TestFilterLarge(t *testing.T) {
+	f := NewFilter(100000000, 0, 0.01, message.BloomUpdateNone)
+	if len(f.msgFilterLoad.Filter) > message.MaxFilterLoadFilterSize {
+		t.Errorf("TestFilterLarge test failed: %d > %d",
+			len(f.msgFilterLoad.Filter), message.MaxFilterLoadFilterSize)
+	}
+}
+
+// TestFilterLoad ensures loading and unloading of a filter pass.
+func TestFilterLoad(t *testing.T) {
+	merkle := message.MsgFilterLoad{}
+
+	f := LoadFilter(&merkle)
+	if !f.IsLoaded() {
+		t.Errorf("TestFilterLoad IsLoaded test failed: want %v got %v",
+			true, !f.IsLoaded())
+		return
+	}
+	f.Unload()
+	if f.IsLoaded() {
+		t.Errorf("TestFilterLoad IsLoaded test failed: want %v got %v",
+			f.IsLoaded(), false)
+		return
+	}
+}
+
+// serializeFilter returns the serialized filterload message of the filter.
+func serializeFilter(t *testing.T, f *Filter) []byte {
+	var buf bytes.Buffer
+	err := f.MsgFilterLoad().Encode(&buf, protocol.ProtocolVersion)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestFilterInsert ensures inserting data into the filter causes that data
+// to be matched and the resulting serialized MsgFilterLoad is the expected
+// value.
+func TestFilterInsert(t *testing.T) {
+	var tests = []struct {
+		hex    string
+		insert bool
+	}{
+		{"99108ad8ed9bb6274d3980bab5a85c048f0950c8", true},
+		{"19108ad8ed9bb6274d3980bab5a85c048f0950c8", false},
+		{"b5a2c786d9ef4658287ced5914b37a1b4aa32eee", true},
+		{"b9300670b4c5366e95b2699e8b18bc75e5f729c5", true},
+	}
+
+	f := NewFilter(3, 0, 0.01, message.BloomUpdateAll)
+
+	for i, test := range tests {
+		data, err := hex.DecodeString(test.hex)
+		if err != nil {
+			t.Errorf("TestFilterInsert DecodeString failed: %v\n", err)
+			return
+		}
+		if test.insert {
+			f.Add(data)
+		}
+
+		result := f.Matches(data)
+		if test.insert != result {
+			t.Errorf("TestFilterInsert Matches test #%d failure: got %v want %v\n",
+				i, result, test.insert)
+			return
+		}
+	}
+
+	want, err := hex.DecodeString("03614e9b050000000000000001")
+	if err != nil {
+		t.Errorf("TestFilterInsert DecodeString failed: %v\n", err)
+		return
+	}
+
+	got := serializeFilter(t, f)
+	if !bytes.Equal(got, want) {
+		t.Errorf("TestFilterInsert failure: got %x want %x\n", got, want)
+		return
+	}
+}
+
+// TestFilterFPRange checks that new filters made with out of range
+// false positive targets result in either max or min false positive rates.
+func TestFilterFPRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		hash   string
+		want   string
+		filter *Filter
+	}{
+		{
+			name:   "fprates > 1 should be clipped at 1",
+			hash:   "02981fa052f0481dbc5868f4fc2166035a10f27a03cfd2de67326471df5bc041",
+			want:   "00000000000000000001",
+			filter: NewFilter(1, 0, 20.9999999769, message.BloomUpdateAll),
+		},
+		{
+			name:   "fprates less than 1e-9 should be clipped at min",
+			hash:   "02981fa052f0481dbc5868f4fc2166035a10f27a03cfd2de67326471df5bc041",
+			want:   "0566d97a91a91b0000000000000001",
+			filter: NewFilter(1, 0, 0, message.BloomUpdateAll),
+		},
+		{
+			name:   "negative fprates should be clipped at min",
+			hash:   "02981fa052f0481dbc5868f4fc2166035a10f27a03cfd2de67326471df5bc041",
+			want:   "0566d97a91a91b0000000000000001",
+			filter: NewFilter(1, 0, -1, message.BloomUpdateAll),
+		},
+	}
+
+	for _, test := range tests {
+		// Convert test input to appropriate types.
+		h, err := hash.NewHashFromStr(test.hash)
+		if err != nil {
+			t.Errorf("NewHashFromStr unexpected error: %v", err)
+			continue
+		}
+		want, err := hex.DecodeString(test.want)
+		if err != nil {
+			t.Errorf("DecodeString unexpected error: %v\n", err)
+			continue
+		}
+
+		// Add the test hash to the bloom filter and ensure the
+		// filter serializes to the expected bytes.
+		f := test.filter
+		f.AddHash(h)
+		got := serializeFilter(t, f)
+		if !bytes.Equal(got, want) {
+			t.Errorf("serialized filter mismatch: got %x want %x\n",
+				got, want)
+			continue
+		}
+	}
+}
+
+// TestFilterInsertWithTweak ensures inserting data into the filter with a tweak
+// causes that data to be matched and the resulting serialized MsgFilterLoad is
+// the expected value.
+func TestFilterInsertWithTweak(t *testing.T) {
+	var tests = []struct {
+		hex    string
+		insert bool
+	}{
+		{"99108ad8ed9bb6274d3980bab5a85c048f0950c8", true},
+		{"19108ad8ed9bb6274d3980bab5a85c048f0950c8", false},
+		{"b5a2c786d9ef4658287ced5914b37a1b4aa32eee", true},
+		{"b9300670b4c5366e95b2699e8b18bc75e5f729c5", true},
+	}
+
+	f := NewFilter(3, 2147483649, 0.01, message.BloomUpdateAll)
+
+	for i, test := range tests {
+		data, err := hex.DecodeString(test.hex)
+		if err != nil {
+			t.Errorf("TestFilterInsertWithTweak DecodeString failed: %v\n", err)
+			return
+		}
+		if test.insert {
+			f.Add(data)
+		}
+
+		result := f.Matches(data)
+		if test.insert != result {
+			t.Errorf("TestFilterInsertWithTweak Matches test #%d failure: got %v want %v\n",
+				i, result, test.insert)
+			return
+		}
+	}
+
+	want, err := hex.DecodeString("03ce4299050000000100008001")
+	if err != nil {
+		t.Errorf("TestFilterInsertWithTweak DecodeString failed: %v\n", err)
+		return
+	}
+	got := serializeFilter(t, f)
+	if !bytes.Equal(got, want) {
+		t.Errorf("TestFilterInsertWithTweak failure: got %x want %x\n",
+			got, want)
+		return
+	}
+}
+
+// TestFilterOutPoint ensures added outpoints are matched, and other outpoints
+// of the same transaction are not.
+func TestFilterOutPoint(t *testing.T) {
+	h, err := hash.NewHashFromStr("90c122d70786e899529d71dbeba91ba216982fb6ba58f3bdaab65e73b7e9260b")
+	if err != nil {
+		t.Fatalf("NewHashFromStr: %v", err)
+	}
+	f := NewFilter(10, 0, 0.000001, message.BloomUpdateAll)
+	f.AddOutPoint(types.NewOutPoint(h, 0))
+	if !f.MatchesOutPoint(types.NewOutPoint(h, 0)) {
+		t.Errorf("filter didn't match added outpoint")
+	}
+	if f.MatchesOutPoint(types.NewOutPoint(h, 1)) {
+		t.Errorf("filter matched outpoint that wasn't added")
+	}
+}
+
+// TestFilterReload ensures the underlying data gets updated as expected.
+func TestFilterReload(t *testing.T) {
+	f := NewFilter(10, 0, 0.000001, message.BloomUpdateAll)
+
+	bFilter := LoadFilter(f.MsgFilterLoad())
+	if bFilter.MsgFilterLoad() == nil {
+		t.Errorf("TestFilterReload LoadFilter test failed")
+		return
+	}
+	bFilter.Reload(nil)
+
+	if bFilter.MsgFilterLoad() != nil {
+		t.Errorf("TestFilterReload Reload test failed")
+	}
+}
+
+// testPkScripts returns a pay-to-pubkey-hash and a pay-to-pubkey script, and
+// the data they push.
+func testPkScripts() (p2pkh, p2pk, pkHash, pubKey []byte) {
+	pkHash = bytes.Repeat([]byte{0x11}, 20)
+	pubKey = append([]byte{0x02}, bytes.Repeat([]byte{0x22}, 32)...)
+	p2pkh = append([]byte{txscript.OP_DUP, txscript.OP_HASH160,
+		txscript.OP_DATA_20}, pkHash...)
+	p2pkh = append(p2pkh, txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG)
+	p2pk = append([]byte{txscript.OP_DATA_33}, pubKey...)
+	p2pk = append(p2pk, txscript.OP_CHECKSIG)
+	return
+}
+
+// newTestTx returns a transaction spending the passed outpoint to the passed
+// output scripts.
+func newTestTx(prevOut *types.TxOutPoint, signScript []byte, pkScripts ...[]byte) *types.Tx {
+	mtx := types.NewTransaction()
+	mtx.AddTxIn(types.NewTxInput(prevOut, signScript))
+	for _, pkScript := range pkScripts {
+		mtx.AddTxOut(types.NewTxOutput(1000, pkScript))
+	}
+	return types.NewTx(mtx)
+}
+
+// TestFilterMatchTx ensures transactions are matched by their hash, the data
+// pushed by their scripts and the outpoints they spend.
+func TestFilterMatchTx(t *testing.T) {
+	p2pkh, _, pkHash, _ := testPkScripts()
+	prevHash, err := hash.NewHashFromStr("0b26e9b7735eb6aabdf358bab62f9816a21ba9ebdb719d5299e88607d722c190")
+	if err != nil {
+		t.Fatalf("NewHashFromStr: %v", err)
+	}
+	sigData := bytes.Repeat([]byte{0x33}, 71)
+	signScript := append([]byte{txscript.OP_DATA_71}, sigData...)
+	tx := newTestTx(types.NewOutPoint(prevHash, 1), signScript, p2pkh)
+
+	tests := []struct {
+		name  string
+		add   func(f *Filter)
+		match bool
+	}{
+		{
+			name:  "tx hash",
+			add:   func(f *Filter) { f.AddHash(tx.Hash()) },
+			match: true,
+		},
+		{
+			name:  "output script data",
+			add:   func(f *Filter) { f.Add(pkHash) },
+			match: true,
+		},
+		{
+			name:  "spent outpoint",
+			add:   func(f *Filter) { f.AddOutPoint(types.NewOutPoint(prevHash, 1)) },
+			match: true,
+		},
+		{
+			name:  "signature script data",
+			add:   func(f *Filter) { f.Add(sigData) },
+			match: true,
+		},
+		{
+			name:  "other outpoint",
+			add:   func(f *Filter) { f.AddOutPoint(types.NewOutPoint(prevHash, 0)) },
+			match: false,
+		},
+		{
+			name:  "other data",
+			add:   func(f *Filter) { f.Add(bytes.Repeat([]byte{0x44}, 20)) },
+			match: false,
+		},
+	}
+	for _, test := range tests {
+		f := NewFilter(10, 0, 0.000001, message.BloomUpdateAll)
+		test.add(f)
+		if got := f.MatchTxAndUpdate(tx); got != test.match {
+			t.Errorf("%s: got match %v, want %v", test.name, got,
+				test.match)
+		}
+	}
+}
+
+// TestFilterUpdate ensures the outpoints of matched outputs are added to the
+// filter according to its update flags, so the transactions spending them
+// are matched as well.
+func TestFilterUpdate(t *testing.T) {
+	p2pkh, p2pk, pkHash, pubKey := testPkScripts()
+	prevHash, err := hash.NewHashFromStr("0b26e9b7735eb6aabdf358bab62f9816a21ba9ebdb719d5299e88607d722c190")
+	if err != nil {
+		t.Fatalf("NewHashFromStr: %v", err)
+	}
+	tx := newTestTx(types.NewOutPoint(prevHash, 0), nil, p2pkh, p2pk)
+	spendP2PKH := newTestTx(types.NewOutPoint(tx.Hash(), 0), nil)
+	spendP2PK := newTestTx(types.NewOutPoint(tx.Hash(), 1), nil)
+
+	tests := []struct {
+		name       string
+		flags      message.BloomUpdateType
+		wantP2PKH  bool
+		wantP2PK   bool
+		addPubKey  bool
+		addPKHash  bool
+		wantOrigin bool
+	}{
+		{
+			name:       "update none",
+			flags:      message.BloomUpdateNone,
+			addPKHash:  true,
+			addPubKey:  true,
+			wantOrigin: true,
+		},
+		{
+			name:       "update all",
+			flags:      message.BloomUpdateAll,
+			addPKHash:  true,
+			addPubKey:  true,
+			wantOrigin: true,
+			wantP2PKH:  true,
+			wantP2PK:   true,
+		},
+		{
+			name:       "update pubkey only",
+			flags:      message.BloomUpdateP2PubkeyOnly,
+			addPKHash:  true,
+			addPubKey:  true,
+			wantOrigin: true,
+			wantP2PK:   true,
+		},
+		{
+			name:       "update all with pkhash only",
+			flags:      message.BloomUpdateAll,
+			addPKHash:  true,
+			wantOrigin: true,
+			wantP2PKH:  true,
+		},
+	}
+	for _, test := range tests {
+		f := NewFilter(10, 0, 0.000001, test.flags)
+		if test.addPKHash {
+			f.Add(pkHash)
+		}
+		if test.addPubKey {
+			f.Add(pubKey)
+		}
+		if got := f.MatchTxAndUpdate(tx); got != test.wantOrigin {
+			t.Errorf("%s: got origin match %v, want %v", test.name, got,
+				test.wantOrigin)
+		}
+		if got := f.MatchTxAndUpdate(spendP2PKH); got != test.wantP2PKH {
+			t.Errorf("%s: got p2pkh spend match %v, want %v", test.name,
+				got, test.wantP2PKH)
+		}
+		if got := f.MatchTxAndUpdate(spendP2PK); got != test.wantP2PK {
+			t.Errorf("%s: got p2pk spend match %v, want %v", test.name,
+				got, test.wantP2PK)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Copyright (c) 2015-2016 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"reflect"
+	"testing"
+)
+
+// partialMerkleTree walks the partial merkle tree of a merkle block to
+// compute its root and the hashes of the matched transactions.
+type partialMerkleTree struct {
+	msg     *message.MsgMerkleBlock
+	bitPos  uint32
+	hashPos int
+	matched []*hash.Hash
+}
+
+func (p *partialMerkleTree) width(height uint32) uint32 {
+	return (p.msg.Transactions + (1 << height) - 1) >> height
+}
+
+func (p *partialMerkleTree) traverse(height, pos uint32) *hash.Hash {
+	bit := p.msg.Flags[p.bitPos/8]>>(p.bitPos%8)&1 == 1
+	p.bitPos++
+	if height == 0 || !bit {
+		h := p.msg.Hashes[p.hashPos]
+		p.hashPos++
+		if height == 0 && bit {
+			p.matched = append(p.matched, h)
+		}
+		return h
+	}
+	left := p.traverse(height-1, pos*2)
+	right := left
+	if pos*2+1 < p.width(height-1) {
+		right = p.traverse(height-1, pos*2+1)
+	}
+	return merkle.HashMerkleBranches(left, right)
+}
+
+// extractMatches returns the merkle root of the merkle block and the hashes
+// of its matched transactions.
+func extractMatches(msg *message.MsgMerkleBlock) (*hash.Hash, []*hash.Hash) {
+	p := &partialMerkleTree{msg: msg}
+	height := uint32(0)
+	for p.width(height) > 1 {
+		height++
+	}
+	return p.traverse(height, 0), p.matched
+}
+
+// TestMerkleBlock ensures the merkle blocks only hold the matched
+// transactions and commit to the merkle root of the block.
+func TestMerkleBlock(t *testing.T) {
+	p2pkh, _, pkHash, _ := testPkScripts()
+
+	block := &types.Block{Header: types.BlockHeader{Pow: &pow.Blake2bd{}}}
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(types.NewTxInput(types.NewOutPoint(&hash.ZeroHash,
+		types.MaxPrevOutIndex), []byte{0x51}))
+	coinbase.AddTxOut(types.NewTxOutput(5000, []byte{0x51}))
+	block.AddTransaction(coinbase)
+	for i := 0; i < 6; i++ {
+		prevHash := hash.HashH([]byte{byte(i)})
+		pkScript := []byte{0x51}
+		if i == 2 || i == 5 {
+			pkScript = p2pkh
+		}
+		tx := newTestTx(types.NewOutPoint(&prevHash, 0), nil, pkScript)
+		block.AddTransaction(tx.Tx)
+	}
+	sblock := types.NewBlock(block)
+	merkles := merkle.BuildMerkleTreeStore(sblock.Transactions(), false)
+	root := merkles[len(merkles)-1]
+
+	tests := []struct {
+		name    string
+		data    [][]byte
+		matched []uint32
+	}{
+		{name: "no match"},
+		{name: "coinbase", data: [][]byte{sblock.Transactions()[0].Hash()[:]}, matched: []uint32{0}},
+		{name: "scripts", data: [][]byte{pkHash}, matched: []uint32{3, 6}},
+	}
+	for _, test := range tests {
+		f := NewFilter(10, 0, 0.000001, message.BloomUpdateNone)
+		for _, data := range test.data {
+			f.Add(data)
+		}
+		msg, matched := NewMerkleBlock(sblock, f)
+		if !reflect.DeepEqual(matched, test.matched) {
+			t.Errorf("%s: got matched %v, want %v", test.name, matched,
+				test.matched)
+			continue
+		}
+		if msg.Transactions != uint32(len(sblock.Transactions())) {
+			t.Errorf("%s: got %d transactions, want %d", test.name,
+				msg.Transactions, len(sblock.Transactions()))
+		}
+		gotRoot, gotHashes := extractMatches(msg)
+		if !gotRoot.IsEqual(root) {
+			t.Errorf("%s: got merkle root %v, want %v", test.name, gotRoot,
+				root)
+		}
+		if len(gotHashes) != len(test.matched) {
+			t.Errorf("%s: got %d matched hashes, want %d", test.name,
+				len(gotHashes), len(test.matched))
+			continue
+		}
+		for i, idx := range test.matched {
+			if !gotHashes[i].IsEqual(sblock.Transactions()[idx].Hash()) {
+				t.Errorf("%s: got matched hash %v, want %v", test.name,
+					gotHashes[i], sblock.Transactions()[idx].Hash())
+			}
+		}
+	}
+}
@@ -1 +1,72 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+// Package cf provides the RPC service of the committed filter index which
+// lets light clients fetch compact block filters.
 package cf
+
+import (
+	"encoding/hex"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/index"
+)
+
+// CFService serves the committed filters of the blocks.
+type CFService struct {
+	cfIndex *index.CfIndex
+}
+
+// New returns a new committed filter service backed by the given index.
+func New(cfIndex *index.CfIndex) *CFService {
+	return &CFService{cfIndex: cfIndex}
+}
+
+func (s *CFService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			NameSpace: rpc.DefaultServiceNameSpace,
+			Service:   NewPublicCFAPI(s),
+			Public:    true,
+		},
+	}
+}
+
+type PublicCFAPI struct {
+	s *CFService
+}
+
+func NewPublicCFAPI(s *CFService) *PublicCFAPI {
+	return &PublicCFAPI{s}
+}
+
+// GetCFilter returns the serialized regular committed filter of a block.
+func (api *PublicCFAPI) GetCFilter(blockHash string) (interface{}, error) {
+	h, err := hash.NewHashFromStr(blockHash)
+	if err != nil {
+		return nil, rpc.RpcDecodeHexError(blockHash)
+	}
+	filterBytes, err := api.s.cfIndex.FilterByBlockHash(h)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to fetch committed filter")
+	}
+	if filterBytes == nil {
+		return nil, rpc.RpcInvalidError("No committed filter for block %s", blockHash)
+	}
+	return hex.EncodeToString(filterBytes), nil
+}
+
+// GetCFilterHeader returns the regular committed filter header of a block.
+func (api *PublicCFAPI) GetCFilterHeader(blockHash string) (interface{}, error) {
+	h, err := hash.NewHashFromStr(blockHash)
+	if err != nil {
+		return nil, rpc.RpcDecodeHexError(blockHash)
+	}
+	header, err := api.s.cfIndex.FilterHeaderByBlockHash(h)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Failed to fetch committed filter header")
+	}
+	if header == nil {
+		return nil, rpc.RpcInvalidError("No committed filter header for block %s", blockHash)
+	}
+	return header.String(), nil
+}
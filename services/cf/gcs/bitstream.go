@@ -0,0 +1,67 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package gcs
+
+import "io"
+
+// bitWriter appends bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	bytes []byte
+	// free is the number of unused bits in the last byte.
+	free uint8
+}
+
+// writeBit appends a single bit.
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.bytes = append(w.bytes, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.bytes[len(w.bytes)-1] |= 1 << w.free
+	}
+}
+
+// writeBits appends the count least significant bits of data, most
+// significant of them first.
+func (w *bitWriter) writeBits(data uint64, count uint8) {
+	for count > 0 {
+		count--
+		w.writeBit(data&(1<<count) != 0)
+	}
+}
+
+// bitReader reads bits from a byte slice, most significant bit first.
+type bitReader struct {
+	bytes []byte
+	// pos is the index of the next bit to read.
+	pos uint64
+}
+
+// readBit returns the next bit or io.EOF when all of the bits were read.
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= uint64(len(r.bytes))*8 {
+		return false, io.EOF
+	}
+	bit := r.bytes[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+// readBits returns the next count bits as the least significant bits of the
+// result.
+func (r *bitReader) readBits(count uint8) (uint64, error) {
+	var v uint64
+	for i := uint8(0); i < count; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
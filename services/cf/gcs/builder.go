@@ -0,0 +1,95 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package gcs
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+)
+
+const (
+	// DefaultP is the default collision probability (2^-19)
+	DefaultP = 19
+
+	// DefaultM is the default value used for the hash range.
+	DefaultM uint64 = 784931
+)
+
+// DeriveKey is a utility function that derives a key from a hash.Hash by
+// truncating the bytes of the hash to the appopriate key size.
+func DeriveKey(keyHash *hash.Hash) [KeySize]byte {
+	var key [KeySize]byte
+	copy(key[:], keyHash[:KeySize])
+	return key
+}
+
+// BuildBasicFilter builds a basic GCS filter from a block.  A basic GCS filter
+// will contain all the previous output scripts spent by inputs within a block,
+// as well as the data pushes within all the outputs created within a block.
+// The scripts of the spent outputs are passed in by the caller since they are
+// not part of the block.
+func BuildBasicFilter(block *types.Block, prevOutScripts [][]byte) (*Filter, error) {
+	blockHash := block.BlockHash()
+	key := DeriveKey(&blockHash)
+
+	// Every script is only added once, no matter how many times it is
+	// used within the block.
+	seen := make(map[string]struct{})
+	var data [][]byte
+	addScript := func(script []byte) {
+		if len(script) == 0 {
+			return
+		}
+		if _, ok := seen[string(script)]; ok {
+			return
+		}
+		seen[string(script)] = struct{}{}
+		data = append(data, script)
+	}
+
+	for _, tx := range block.Transactions {
+		for _, txOut := range tx.TxOut {
+			// Provably unspendable data carrier outputs can never be
+			// spent, so there is no reason to match them.
+			if len(txOut.PkScript) > 0 && txOut.PkScript[0] == txscript.OP_RETURN {
+				continue
+			}
+			addScript(txOut.PkScript)
+		}
+	}
+	for _, script := range prevOutScripts {
+		addScript(script)
+	}
+
+	return BuildGCSFilter(DefaultP, DefaultM, key, data)
+}
+
+// GetFilterHash returns the double blake2b hash of the filter.
+func GetFilterHash(filter *Filter) (hash.Hash, error) {
+	filterData, err := filter.NBytes()
+	if err != nil {
+		return hash.Hash{}, err
+	}
+	return hash.DoubleHashH(filterData), nil
+}
+
+// MakeHeaderForFilter makes a filter chain header for a filter, given the
+// filter and the previous filter chain header.  The filters are chained by
+// the DAG order of their blocks.
+func MakeHeaderForFilter(filter *Filter, prevHeader hash.Hash) (hash.Hash, error) {
+	filterHash, err := GetFilterHash(filter)
+	if err != nil {
+		return hash.Hash{}, err
+	}
+	return MakeHeaderForFilterHash(filterHash, prevHeader), nil
+}
+
+// MakeHeaderForFilterHash makes a filter chain header from the hash of a
+// filter and the previous filter chain header.
+func MakeHeaderForFilterHash(filterHash hash.Hash, prevHeader hash.Hash) hash.Hash {
+	filterTip := make([]byte, 2*hash.HashSize)
+	copy(filterTip, filterHash[:])
+	copy(filterTip[hash.HashSize:], prevHeader[:])
+	return hash.DoubleHashH(filterTip)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package gcs
+
+import (
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"testing"
+)
+
+// TestBuildBasicFilter checks that the basic filter of a block holds the
+// output scripts of the block and the scripts of the outputs it spends, but
+// not its data carrier outputs.
+func TestBuildBasicFilter(t *testing.T) {
+	p2pkh := []byte{txscript.OP_DUP, txscript.OP_HASH160, txscript.OP_DATA_20,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a,
+		0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14,
+		txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG}
+	p2sh := []byte{txscript.OP_HASH160, txscript.OP_DATA_20,
+		0x14, 0x13, 0x12, 0x11, 0x10, 0x0f, 0x0e, 0x0d, 0x0c, 0x0b,
+		0x0a, 0x09, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01,
+		txscript.OP_EQUAL}
+	nullData := []byte{txscript.OP_RETURN, txscript.OP_DATA_4, 0xde, 0xad,
+		0xbe, 0xef}
+	spent := []byte{txscript.OP_TRUE}
+
+	coinbase := types.NewTransaction()
+	coinbase.AddTxOut(types.NewTxOutput(100, p2pkh))
+	coinbase.AddTxOut(types.NewTxOutput(0, nullData))
+	tx := types.NewTransaction()
+	tx.AddTxOut(types.NewTxOutput(10, p2sh))
+	tx.AddTxOut(types.NewTxOutput(10, p2pkh))
+	block := &types.Block{
+		Header:       types.BlockHeader{Pow: &pow.Blake2bd{}},
+		Transactions: []*types.Transaction{coinbase, tx},
+	}
+
+	filter, err := BuildBasicFilter(block, [][]byte{spent, nil, p2sh})
+	if err != nil {
+		t.Fatalf("BuildBasicFilter: %v", err)
+	}
+	// Every script is only added once, and empty scripts are skipped.
+	if filter.N() != 3 {
+		t.Errorf("got N %d, want 3", filter.N())
+	}
+
+	blockHash := block.BlockHash()
+	key := DeriveKey(&blockHash)
+	for _, script := range [][]byte{p2pkh, p2sh, spent} {
+		match, err := filter.Match(key, script)
+		if err != nil {
+			t.Fatalf("Match: %v", err)
+		}
+		if !match {
+			t.Errorf("filter didn't match script %x", script)
+		}
+	}
+	match, err := filter.Match(key, nullData)
+	if err != nil {
+		t.Fatalf("Match: %v", err)
+	}
+	if match {
+		t.Errorf("filter matched data carrier script %x", nullData)
+	}
+}
+
+// TestFilterHeaderChain checks that the filter headers commit to both the
+// filter and the previous header.
+func TestFilterHeaderChain(t *testing.T) {
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, testKey, contents)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	filterHash, err := GetFilterHash(filter)
+	if err != nil {
+		t.Fatalf("GetFilterHash: %v", err)
+	}
+	header, err := MakeHeaderForFilter(filter, filterHash)
+	if err != nil {
+		t.Fatalf("MakeHeaderForFilter: %v", err)
+	}
+	if header != MakeHeaderForFilterHash(filterHash, filterHash) {
+		t.Errorf("MakeHeaderForFilter and MakeHeaderForFilterHash differ")
+	}
+	if header == MakeHeaderForFilterHash(filterHash, header) {
+		t.Errorf("header doesn't commit to the previous header")
+	}
+	empty, err := BuildGCSFilter(DefaultP, DefaultM, testKey, nil)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	emptyHeader, err := MakeHeaderForFilter(empty, filterHash)
+	if err != nil {
+		t.Fatalf("MakeHeaderForFilter: %v", err)
+	}
+	if header == emptyHeader {
+		t.Errorf("header doesn't commit to the filter")
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+// Package gcs implements the Golomb-coded sets used as compact block filters.
+package gcs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// KeySize is the size of the byte array required for key material for the
+// SipHash keyed hash function.
+const KeySize = 16
+
+var (
+	// ErrNTooBig signifies that the filter can't handle N items.
+	ErrNTooBig = errors.New("N is too big to fit in uint32")
+
+	// ErrPTooBig signifies that the filter can't handle `1/2**P`
+	// collision probability.
+	ErrPTooBig = errors.New("P is too big to fit in uint64")
+)
+
+// Filter describes an immutable filter that can be built from a set of data
+// elements, serialized, deserialized, and queried in a thread-safe manner. The
+// serialized form is compressed as a Golomb Coded Set (GCS), but does not
+// include N or P to allow the user to encode the metadata separately if
+// necessary. The hash function used is SipHash, a keyed function; the key used
+// in building the filter is required in order to match filter values and is
+// not included in the serialized form.
+type Filter struct {
+	n          uint32
+	p          uint8
+	modulusNP  uint64
+	filterData []byte
+}
+
+// fastReduction maps a 64-bit hash uniformly onto the range [0, N*M) without
+// a division, by taking the high 64 bits of the 128-bit product.
+func fastReduction(v, nm uint64) uint64 {
+	hi, _ := bits.Mul64(v, nm)
+	return hi
+}
+
+// hashValue returns the value the data element is mapped to in a filter with
+// the given modulus.
+func hashValue(k0, k1 uint64, modulusNP uint64, data []byte) uint64 {
+	return fastReduction(sipHash(k0, k1, data), modulusNP)
+}
+
+// BuildGCSFilter builds a new GCS filter with the collision probability of
+// `1/(2**P)`, key `key`, and including every `[]byte` in `data` as a member of
+// the set.
+func BuildGCSFilter(P uint8, M uint64, key [KeySize]byte, data [][]byte) (*Filter, error) {
+	// Some initial parameter checks: make sure we have data from which to
+	// build the filter, and make sure our parameters will fit the hash
+	// function we're using.
+	if uint64(len(data)) >= (1 << 32) {
+		return nil, ErrNTooBig
+	}
+	if P > 32 {
+		return nil, ErrPTooBig
+	}
+
+	f := Filter{
+		n: uint32(len(data)),
+		p: P,
+	}
+	f.modulusNP = uint64(f.n) * M
+
+	// Nothing to encode for an empty set.
+	if f.n == 0 {
+		return &f, nil
+	}
+
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+
+	// Map every data element to a value in [0, N*M) and sort them so the
+	// differences between consecutive values can be golomb-rice coded.
+	values := make([]uint64, 0, len(data))
+	for _, d := range data {
+		values = append(values, hashValue(k0, k1, f.modulusNP, d))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	var w bitWriter
+	var lastValue uint64
+	for _, v := range values {
+		delta := v - lastValue
+		lastValue = v
+
+		// The quotient is written in unary followed by a zero bit and
+		// the remainder is written as P bits.
+		for q := delta >> f.p; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, f.p)
+	}
+	f.filterData = w.bytes
+	return &f, nil
+}
+
+// FromBytes deserializes a GCS filter from a known N, P, and serialized
+// filter as returned by Bytes().
+func FromBytes(N uint32, P uint8, M uint64, d []byte) (*Filter, error) {
+	if P > 32 {
+		return nil, ErrPTooBig
+	}
+	f := &Filter{
+		n:          N,
+		p:          P,
+		modulusNP:  uint64(N) * M,
+		filterData: make([]byte, len(d)),
+	}
+	copy(f.filterData, d)
+	return f, nil
+}
+
+// FromNBytes deserializes a GCS filter from a known P, and serialized N and
+// filter as returned by NBytes().
+func FromNBytes(P uint8, M uint64, d []byte) (*Filter, error) {
+	r := bytes.NewReader(d)
+	n, err := s.ReadVarInt(r, protocol.ProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	if n >= (1 << 32) {
+		return nil, ErrNTooBig
+	}
+	return FromBytes(uint32(n), P, M, d[len(d)-r.Len():])
+}
+
+// Bytes returns the serialized format of the GCS filter, which does not
+// include N or P (returned by separate methods) or the key used by SipHash.
+func (f *Filter) Bytes() []byte {
+	filterData := make([]byte, len(f.filterData))
+	copy(filterData, f.filterData)
+	return filterData
+}
+
+// NBytes returns the serialized format of the GCS filter with N, which does
+// not include P (returned by a separate method) or the key used by SipHash.
+func (f *Filter) NBytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(s.VarIntSerializeSize(uint64(f.n)) + len(f.filterData))
+
+	err := s.WriteVarInt(&buf, protocol.ProtocolVersion, uint64(f.n))
+	if err != nil {
+		return nil, err
+	}
+	_, err = buf.Write(f.filterData)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// P returns the filter's collision probability as a negative power of 2 (that
+// is, a collision probability of `1/2**20` is represented as 20).
+func (f *Filter) P() uint8 {
+	return f.p
+}
+
+// N returns the size of the data set used to build the filter.
+func (f *Filter) N() uint32 {
+	return f.n
+}
+
+// readFullUint64 reads the next value of the golomb-rice coded set.
+func (f *Filter) readFullUint64(r *bitReader) (uint64, error) {
+	// The quotient is coded in unary terminated by a zero bit.
+	var quotient uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		quotient++
+	}
+	remainder, err := r.readBits(f.p)
+	if err != nil {
+		return 0, err
+	}
+	return quotient<<f.p | remainder, nil
+}
+
+// Match checks whether a []byte value is likely (within collision probability)
+// to be a member of the set represented by the filter.
+func (f *Filter) Match(key [KeySize]byte, data []byte) (bool, error) {
+	return f.MatchAny(key, [][]byte{data})
+}
+
+// MatchAny returns checks whether any []byte value is likely (within collision
+// probability) to be a member of the set represented by the filter faster than
+// calling Match() for each value individually.
+func (f *Filter) MatchAny(key [KeySize]byte, data [][]byte) (bool, error) {
+	if f.n == 0 || len(data) == 0 {
+		return false, nil
+	}
+
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+
+	values := make([]uint64, 0, len(data))
+	for _, d := range data {
+		values = append(values, hashValue(k0, k1, f.modulusNP, d))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	// Walk both sorted sets in a zipper fashion until a common value is
+	// found or one of them runs out.
+	r := bitReader{bytes: f.filterData}
+	var filterValue uint64
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := f.readFullUint64(&r)
+		if err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		filterValue += delta
+
+		for len(values) > 0 && values[0] < filterValue {
+			values = values[1:]
+		}
+		if len(values) == 0 {
+			return false, nil
+		}
+		if values[0] == filterValue {
+			return true, nil
+		}
+	}
+	return false, nil
+}
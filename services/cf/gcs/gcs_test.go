@@ -0,0 +1,275 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+var (
+	// testKey is the key used to build and match the test filters.
+	testKey = [KeySize]byte{0x4c, 0xb1, 0xab, 0x12, 0x57, 0x62, 0x1e, 0x41,
+		0x3b, 0x8b, 0x0e, 0x26, 0x64, 0x8d, 0x4a, 0x15}
+
+	// contents are the members of the test filters.
+	contents = [][]byte{
+		[]byte("Alex"),
+		[]byte("Bob"),
+		[]byte("Charlie"),
+		[]byte("Dick"),
+		[]byte("Ed"),
+		[]byte("Frank"),
+		[]byte("George"),
+		[]byte("Harry"),
+		[]byte("Ilya"),
+		[]byte("John"),
+		[]byte("Kevin"),
+		[]byte("Larry"),
+		[]byte("Michael"),
+		[]byte("Nate"),
+		[]byte("Owen"),
+		[]byte("Paul"),
+		[]byte("Quentin"),
+	}
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %s: %v", s, err)
+	}
+	return b
+}
+
+// TestSipHash checks the SipHash-2-4 reference vectors, with the key 00..0f
+// and the messages 00..n-1.
+func TestSipHash(t *testing.T) {
+	want := []uint64{
+		0x726fdb47dd0e0e31,
+		0x74f839c593dc67fd,
+		0x0d6c8009d9a94f5a,
+		0x85676696d7fb7e2d,
+		0xcf2794e0277187b7,
+		0x18765564cd99a68d,
+		0xcbc9466e58fee3ce,
+		0xab0200f58b01d137,
+		0x93f5f5799a932462,
+		0x9e0082df0ba9e4b0,
+	}
+	var key [KeySize]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+	msg := make([]byte, len(want))
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	for i, w := range want {
+		if got := sipHash(k0, k1, msg[:i]); got != w {
+			t.Errorf("sipHash of %d bytes: got %x, want %x", i, got, w)
+		}
+	}
+}
+
+// TestGCSFilterVectors checks the serialized filters against known answers.
+// The first vector is the basic filter of the testnet genesis block of BIP158,
+// which only holds the script of the coinbase output.
+func TestGCSFilterVectors(t *testing.T) {
+	tests := []struct {
+		name string
+		p    uint8
+		m    uint64
+		key  []byte
+		data [][]byte
+		want string
+	}{
+		{
+			name: "bip158 testnet genesis",
+			p:    DefaultP,
+			m:    DefaultM,
+			key: mustDecodeHex(t, "43497fd7f826957108f4a30fd9cec3ae"+
+				"ba79972084e90ead01ea330900000000"),
+			data: [][]byte{mustDecodeHex(t, "4104678afdb0fe5548271967f1a671"+
+				"30b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f3"+
+				"5504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac")},
+			want: "019dfca8",
+		},
+		{
+			name: "contents",
+			p:    DefaultP,
+			m:    DefaultM,
+			key:  testKey[:],
+			data: contents,
+			want: "11056ff79e6c2994ba5d91402f327f807097c5c571f8d212511a" +
+				"8237f005331346102b41967f35ef488406c38a88",
+		},
+		{
+			name: "contents with p 10",
+			p:    10,
+			m:    1 << 10,
+			key:  testKey[:],
+			data: contents,
+			want: "1103aa2c6f420aaf8015bf398464b6b421bd0451b3ef51822f80",
+		},
+		{
+			name: "empty",
+			p:    DefaultP,
+			m:    DefaultM,
+			key:  testKey[:],
+			want: "00",
+		},
+	}
+
+	for _, test := range tests {
+		var key [KeySize]byte
+		copy(key[:], test.key)
+		filter, err := BuildGCSFilter(test.p, test.m, key, test.data)
+		if err != nil {
+			t.Errorf("%s: BuildGCSFilter: %v", test.name, err)
+			continue
+		}
+		got, err := filter.NBytes()
+		if err != nil {
+			t.Errorf("%s: NBytes: %v", test.name, err)
+			continue
+		}
+		if hex.EncodeToString(got) != test.want {
+			t.Errorf("%s: got filter %x, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+// TestGCSFilterCopy checks that the serialized filters are deserialized to
+// the same filters.
+func TestGCSFilterCopy(t *testing.T) {
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, testKey, contents)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	filter2, err := FromBytes(filter.N(), DefaultP, DefaultM, filter.Bytes())
+	if err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+	nBytes, err := filter.NBytes()
+	if err != nil {
+		t.Fatalf("NBytes: %v", err)
+	}
+	filter3, err := FromNBytes(DefaultP, DefaultM, nBytes)
+	if err != nil {
+		t.Fatalf("FromNBytes: %v", err)
+	}
+	for _, f := range []*Filter{filter2, filter3} {
+		if f.N() != filter.N() || f.P() != filter.P() ||
+			f.modulusNP != filter.modulusNP {
+			t.Errorf("got N %d P %d modulus %d, want N %d P %d modulus %d",
+				f.N(), f.P(), f.modulusNP, filter.N(), filter.P(),
+				filter.modulusNP)
+		}
+		if !bytes.Equal(f.Bytes(), filter.Bytes()) {
+			t.Errorf("got filter data %x, want %x", f.Bytes(), filter.Bytes())
+		}
+	}
+}
+
+// TestGCSFilterMetadata checks the parameters reported by a filter and the
+// limits of the parameters.
+func TestGCSFilterMetadata(t *testing.T) {
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, testKey, contents)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	if filter.P() != DefaultP {
+		t.Errorf("got P %d, want %d", filter.P(), DefaultP)
+	}
+	if filter.N() != uint32(len(contents)) {
+		t.Errorf("got N %d, want %d", filter.N(), len(contents))
+	}
+	if _, err := BuildGCSFilter(33, DefaultM, testKey, contents); err != ErrPTooBig {
+		t.Errorf("BuildGCSFilter with P 33: got error %v, want %v", err,
+			ErrPTooBig)
+	}
+	if _, err := FromBytes(1, 33, DefaultM, nil); err != ErrPTooBig {
+		t.Errorf("FromBytes with P 33: got error %v, want %v", err,
+			ErrPTooBig)
+	}
+}
+
+// TestGCSFilterMatch checks that the members of a filter are matched and that
+// values which aren't members are not.
+func TestGCSFilterMatch(t *testing.T) {
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, testKey, contents)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	for _, member := range contents {
+		match, err := filter.Match(testKey, member)
+		if err != nil {
+			t.Fatalf("Match %s: %v", member, err)
+		}
+		if !match {
+			t.Errorf("filter didn't match member %s", member)
+		}
+	}
+	for _, value := range []string{"Nates", "Quentins", "Zed", ""} {
+		match, err := filter.Match(testKey, []byte(value))
+		if err != nil {
+			t.Fatalf("Match %s: %v", value, err)
+		}
+		if match {
+			t.Errorf("filter matched non-member %q", value)
+		}
+	}
+
+	// The members are not matched with another key.
+	otherKey := testKey
+	otherKey[0] ^= 0xff
+	match, err := filter.MatchAny(otherKey, contents)
+	if err != nil {
+		t.Fatalf("MatchAny: %v", err)
+	}
+	if match {
+		t.Errorf("filter matched with another key")
+	}
+}
+
+// TestGCSFilterMatchAny checks that a set of values is matched when any of
+// them is a member of the filter.
+func TestGCSFilterMatchAny(t *testing.T) {
+	filter, err := BuildGCSFilter(DefaultP, DefaultM, testKey, contents)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	nonMembers := [][]byte{[]byte("Nates"), []byte("Quentins"), []byte("Zed")}
+	match, err := filter.MatchAny(testKey, nonMembers)
+	if err != nil {
+		t.Fatalf("MatchAny: %v", err)
+	}
+	if match {
+		t.Errorf("filter matched non-members")
+	}
+	match, err = filter.MatchAny(testKey, append(nonMembers, []byte("Nate")))
+	if err != nil {
+		t.Fatalf("MatchAny: %v", err)
+	}
+	if !match {
+		t.Errorf("filter didn't match a member")
+	}
+
+	// An empty filter doesn't match anything.
+	empty, err := BuildGCSFilter(DefaultP, DefaultM, testKey, nil)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: %v", err)
+	}
+	match, err = empty.MatchAny(testKey, contents)
+	if err != nil {
+		t.Fatalf("MatchAny: %v", err)
+	}
+	if match {
+		t.Errorf("empty filter matched")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/Qitmeer/qitmeer/config"
 	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/database/statedb"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/params"
 	"os"
@@ -15,6 +16,16 @@ const (
 	// database type is appended to this value to form the full block
 	// database name.
 	blockDbNamePrefix = "blocks"
+
+	// stateDbName is the name of the database which houses the state trie
+	// of the per-block state commitment.
+	stateDbName = "statetrie"
+
+	// stateDbCache is the size in MiB of the cache of the state database.
+	stateDbCache = 16
+
+	// stateDbHandles is the number of file handles of the state database.
+	stateDbHandles = 16
 )
 
 // loadBlockDB loads (or creates when needed) the block database taking into
@@ -58,6 +69,24 @@ func blockDbPath(dbType string, cfg *config.Config) string {
 	return dbPath
 }
 
+// LoadStateDB loads (or creates when needed) the database which houses the
+// state trie of the per-block state commitment.
+func LoadStateDB(cfg *config.Config) (statedb.Database, error) {
+	dbPath := filepath.Join(cfg.DataDir, stateDbName)
+
+	log.Info("Loading state database", "dbPath", dbPath)
+	err := os.MkdirAll(cfg.DataDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	db, err := statedb.NewLDBDatabase(dbPath, stateDbCache, stateDbHandles)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("State database loaded")
+	return db, nil
+}
+
 // removeBlockDB removes the existing database
 func removeBlockDB(dbPath string) error {
 	// Remove the old database if it already exists.
@@ -86,5 +115,10 @@ func CleanupBlockDB(cfg *config.Config) {
 	if err != nil {
 		log.Error(err.Error())
 	}
+	// The state trie is useless without the blocks.
+	err = removeBlockDB(filepath.Join(cfg.DataDir, stateDbName))
+	if err != nil {
+		log.Error(err.Error())
+	}
 	log.Info("Finished cleanup")
 }
@@ -32,8 +32,12 @@ const (
 	defaultBlockMinSize      = 0
 	defaultBlockMaxSize      = 375000
 	defaultMaxRPCClients     = 10
+	defaultMaxRPCWebsockets  = 25
 	defaultMaxPeers          = 125
 	defaultMiningStateSync   = false
+	defaultStratumDiff       = 1
+	defaultWalletFilename    = "wallet.json"
+	minPruneTargetSize       = 1536
 )
 const (
 	defaultSigCacheMaxSize = 100000
@@ -69,13 +73,16 @@ func LoadConfig() (*config.Config, []string, error) {
 		RPCKey:            defaultRPCKeyFile,
 		RPCCert:           defaultRPCCertFile,
 		RPCMaxClients:     defaultMaxRPCClients,
+		RPCMaxWebsockets:  defaultMaxRPCWebsockets,
 		Generate:          defaultGenerate,
 		MaxPeers:          defaultMaxPeers,
 		MinTxFee:          mempool.DefaultMinRelayTxFee,
+		MaxMempool:        mempool.DefaultMaxPoolSize,
 		BlockMinSize:      defaultBlockMinSize,
 		BlockMaxSize:      defaultBlockMaxSize,
 		SigCacheMaxSize:   defaultSigCacheMaxSize,
 		MiningStateSync:   defaultMiningStateSync,
+		StratumDiff:       defaultStratumDiff,
 		DAGType:           defaultDAGType,
 	}
 
@@ -258,6 +265,14 @@ func LoadConfig() (*config.Config, []string, error) {
 	cfg.DataDir = util.CleanAndExpandPath(cfg.DataDir)
 	cfg.DataDir = filepath.Join(cfg.DataDir, params.ActiveNetParams.Name)
 
+	// The wallet file lives in the per network data directory unless an
+	// explicit path is given.
+	if cfg.WalletFile == "" {
+		cfg.WalletFile = filepath.Join(cfg.DataDir, defaultWalletFilename)
+	} else {
+		cfg.WalletFile = util.CleanAndExpandPath(cfg.WalletFile)
+	}
+
 	// Set logging file if presented
 	if !cfg.NoFileLogging {
 		// Append the network type to the log directory so it is "namespaced"
@@ -315,6 +330,76 @@ func LoadConfig() (*config.Config, []string, error) {
 		return nil, nil, err
 	}
 
+	// --cfindex and --dropcfindex do not mix.
+	if cfg.CFIndex && cfg.DropCFIndex {
+		err := fmt.Errorf("%s: the --cfindex and --dropcfindex "+
+			"options may not be activated at the same time",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --spendindex and --dropspendindex do not mix.
+	if cfg.SpendIndex && cfg.DropSpendIndex {
+		err := fmt.Errorf("%s: the --spendindex and --dropspendindex "+
+			"options may not be activated at the same time",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --blockstatsindex and --dropblockstatsindex do not mix.
+	if cfg.StatsIndex && cfg.DropStatsIndex {
+		err := fmt.Errorf("%s: the --blockstatsindex and "+
+			"--dropblockstatsindex options may not be activated at "+
+			"the same time", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The prune target must leave room for a few block files.
+	if cfg.Prune != 0 && cfg.Prune < minPruneTargetSize {
+		err := fmt.Errorf("%s: the minimum value for --prune is %d MiB",
+			funcName, minPruneTargetSize)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --prune and the indexes do not mix, they need the block data to
+	// catch up.
+	if cfg.Prune != 0 && (cfg.TxIndex || cfg.AddrIndex || cfg.CFIndex ||
+		cfg.SpendIndex || cfg.StatsIndex) {
+		err := fmt.Errorf("%s: the --prune option may not be activated "+
+			"at the same time as --txindex, --addrindex, --cfindex, "+
+			"--spendindex or --blockstatsindex because these indexes "+
+			"rely on the block data", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The blockchain can't be dumped and imported at the same time.
+	if cfg.DumpBlockchain != "" && cfg.ImportBlockchain != "" {
+		err := fmt.Errorf("%s: the --dumpblockchain and --importblockchain "+
+			"options can't be used together", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The hash of a utxo snapshot is pinned in the checkpoints.
+	if cfg.LoadUtxoSnapshot != "" && cfg.DisableCheckpoints {
+		err := fmt.Errorf("%s: the --loadutxosnapshot option can't be "+
+			"used with --nocheckpoints", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Check mining addresses are valid and saved parsed versions.
 	for _, strAddr := range cfg.MiningAddrs {
 		addr, err := address.DecodeAddress(strAddr)
@@ -378,6 +463,25 @@ func LoadConfig() (*config.Config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Ensure there is at least one mining address to pay the blocks mined
+	// by the Stratum miners to, and that their share difficulty is at least
+	// the minimum difficulty.
+	if len(cfg.StratumListeners) > 0 && len(cfg.MiningAddrs) == 0 {
+		str := "%s: the stratumlisten option is set, but there are no " +
+			"mining addresses specified "
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.StratumDiff < 1 {
+		str := "%s: the stratumdiff option of %v is less than 1"
+		err := fmt.Errorf(str, funcName, cfg.StratumDiff)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Warn about missing config file only after all other configuration is
 	// done.  This prevents the warning on help messages and invalid
 	// options.  Note this should go directly before the return.
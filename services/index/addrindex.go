@@ -982,8 +982,13 @@ func NewAddrIndex(db database.DB, chainParams *params.Params) *AddrIndex {
 	}
 }
 
-// DropAddrIndex drops the address index from the provided database if it
-// exists.
+// DropAddrIndex drops the address index and the address utxo index maintained
+// alongside it from the provided database if they exist.
 func DropAddrIndex(db database.DB, interrupt <-chan struct{}) error {
+	err := dropIndex(db, addrUtxoIndexKey, addrUtxoIndexName, interrupt)
+	if err != nil {
+		return err
+	}
+
 	return dropIndex(db, addrIndexKey, addrIndexName, interrupt)
 }
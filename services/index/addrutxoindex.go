@@ -0,0 +1,617 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+)
+
+const (
+	// addrUtxoIndexName is the human-readable name for the index.
+	addrUtxoIndexName = "address utxo index"
+
+	// addrUtxoValueSize is the size of the fixed part of a serialized
+	// unspent output entry, which is followed by the public key script.
+	addrUtxoValueSize = 8 + hash.HashSize + 1
+
+	// addrDeltaKeySize is the size of a serialized delta key.
+	addrDeltaKeySize = addrKeySize + 4 + 4 + 1 + 4
+
+	// addrDeltaValueSize is the size of a serialized delta entry.
+	addrDeltaValueSize = 8 + hash.HashSize
+)
+
+var (
+	// addrUtxoIndexKey is the key of the address utxo index and the db
+	// bucket used to house it.
+	addrUtxoIndexKey = []byte("addrutxoidx")
+
+	// addrUtxoBucketName is the name of the sub-bucket used to house the
+	// unspent outputs of each address.
+	addrUtxoBucketName = []byte("utxo")
+
+	// addrBalanceBucketName is the name of the sub-bucket used to house the
+	// amounts received and sent by each address.
+	addrBalanceBucketName = []byte("balance")
+
+	// addrDeltaBucketName is the name of the sub-bucket used to house the
+	// changes of the balance of each address.
+	addrDeltaBucketName = []byte("delta")
+
+	// addrUtxoBlockBucketName is the name of the sub-bucket used to house
+	// the order each indexed block was connected at.
+	addrUtxoBlockBucketName = []byte("block")
+
+	// deltaKeyOrder is the byte order used for the numeric fields of the
+	// delta keys, so the cursor iterates them in the order of the blocks.
+	deltaKeyOrder = binary.BigEndian
+)
+
+// -----------------------------------------------------------------------------
+// The address utxo index consists of the unspent outputs, the balance and the
+// balance changes of every address paid by the transactions of the blocks
+// connected to the chain.  Only the outputs paying a single address are
+// indexed, and nothing is indexed for blocks whose transactions are invalid.
+// A DAG reorder disconnects the affected blocks and connects them again, and
+// every entry added when connecting a block is removed when disconnecting it.
+//
+// There are four sub-buckets in the index bucket.
+//
+// The serialized format for the keys and values in the utxo bucket is:
+//
+//   <addr key><txhash><output index> = <amount><block hash><coinbase><pkscript>
+//
+//   Field           Type              Size
+//   addr key        [addrKeySize]byte 21 bytes
+//   txhash          hash.Hash         32 bytes
+//   output index    uint32            4 bytes
+//   -----
+//   Total: 57 bytes
+//
+//   Field           Type              Size
+//   amount          uint64            8 bytes
+//   block hash      hash.Hash         32 bytes
+//   coinbase        bool              1 byte
+//   pkscript        []byte            variable
+//
+// The serialized format for the keys and values in the balance bucket is:
+//
+//   <addr key> = <received><sent>
+//
+//   Field           Type              Size
+//   received        uint64            8 bytes
+//   sent            uint64            8 bytes
+//   -----
+//   Total: 16 bytes
+//
+// The serialized format for the keys and values in the delta bucket is, with
+// the numeric fields of the key in big endian:
+//
+//   <addr key><order><tx index><input><index> = <amount><txhash>
+//
+//   Field           Type              Size
+//   addr key        [addrKeySize]byte 21 bytes
+//   order           uint32            4 bytes
+//   tx index        uint32            4 bytes
+//   input           bool              1 byte
+//   index           uint32            4 bytes
+//   -----
+//   Total: 34 bytes
+//
+//   Field           Type              Size
+//   amount          int64             8 bytes
+//   txhash          hash.Hash         32 bytes
+//   -----
+//   Total: 40 bytes
+//
+// The serialized format for the keys and values in the block bucket is:
+//
+//   <block hash> = <order>
+//
+//   Field           Type              Size
+//   block hash      hash.Hash         32 bytes
+//   order           uint32            4 bytes
+// -----------------------------------------------------------------------------
+
+// AddrUtxo describes an unspent output of an address.
+type AddrUtxo struct {
+	OutPoint   types.TxOutPoint
+	Amount     uint64
+	PkScript   []byte
+	BlockHash  hash.Hash
+	IsCoinBase bool
+}
+
+// AddrDelta describes a change of the balance of an address.  Input deltas
+// spend the output of the input index of the transaction, and are negative.
+type AddrDelta struct {
+	Order   uint32
+	TxHash  hash.Hash
+	Index   uint32
+	IsInput bool
+	Amount  int64
+}
+
+// addrBalance tracks the amounts received and sent by an address.
+type addrBalance struct {
+	received uint64
+	sent     uint64
+}
+
+// addrUtxoKey returns the utxo bucket key of the passed output of an address.
+func addrUtxoKey(addrKey [addrKeySize]byte, outpoint *types.TxOutPoint) []byte {
+	key := make([]byte, addrKeySize+hash.HashSize+4)
+	copy(key, addrKey[:])
+	copy(key[addrKeySize:], outpoint.Hash[:])
+	byteOrder.PutUint32(key[addrKeySize+hash.HashSize:], outpoint.OutIndex)
+	return key
+}
+
+// serializeAddrUtxo returns the serialized utxo bucket value of the passed
+// unspent output.
+func serializeAddrUtxo(amount uint64, blockHash *hash.Hash, isCoinBase bool, pkScript []byte) []byte {
+	serialized := make([]byte, addrUtxoValueSize+len(pkScript))
+	byteOrder.PutUint64(serialized, amount)
+	copy(serialized[8:], blockHash[:])
+	if isCoinBase {
+		serialized[8+hash.HashSize] = 1
+	}
+	copy(serialized[addrUtxoValueSize:], pkScript)
+	return serialized
+}
+
+// addrDeltaKey returns the delta bucket key of the passed balance change of
+// an address.
+func addrDeltaKey(addrKey [addrKeySize]byte, order uint32, txIdx int, isInput bool, index int) []byte {
+	key := make([]byte, addrDeltaKeySize)
+	copy(key, addrKey[:])
+	deltaKeyOrder.PutUint32(key[addrKeySize:], order)
+	deltaKeyOrder.PutUint32(key[addrKeySize+4:], uint32(txIdx))
+	if isInput {
+		key[addrKeySize+8] = 1
+	}
+	deltaKeyOrder.PutUint32(key[addrKeySize+9:], uint32(index))
+	return key
+}
+
+// AddrUtxoIndex implements an address utxo index, which tracks the unspent
+// outputs and the balance of every address.  It is maintained alongside the
+// address index.
+type AddrUtxoIndex struct {
+	db          database.DB
+	chainParams *params.Params
+}
+
+// Ensure the AddrUtxoIndex type implements the Indexer interface.
+var _ Indexer = (*AddrUtxoIndex)(nil)
+
+// Ensure the AddrUtxoIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*AddrUtxoIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *AddrUtxoIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) Key() []byte {
+	return addrUtxoIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) Name() string {
+	return addrUtxoIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the address
+// utxo index and its sub-buckets.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) Create(dbTx database.Tx) error {
+	bucket, err := dbTx.Metadata().CreateBucket(addrUtxoIndexKey)
+	if err != nil {
+		return err
+	}
+	for _, name := range [][]byte{addrUtxoBucketName, addrBalanceBucketName,
+		addrDeltaBucketName, addrUtxoBlockBucketName} {
+		if _, err := bucket.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addrKeyForScript returns the key of the address the passed public key
+// script pays to.  Scripts which don't pay a single supported address aren't
+// indexed.
+func (idx *AddrUtxoIndex) addrKeyForScript(pkScript []byte) ([addrKeySize]byte, bool) {
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript,
+		idx.chainParams)
+	if err != nil || len(addrs) != 1 || class == txscript.MultiSigTy {
+		return [addrKeySize]byte{}, false
+	}
+	addrKey, err := addrToKey(addrs[0], idx.chainParams)
+	if err != nil {
+		return [addrKeySize]byte{}, false
+	}
+	return addrKey, true
+}
+
+// indexBlock adds or removes, when connect is false, the entries of the
+// outputs created and spent by the transactions of the passed block, which
+// was connected at the passed order.
+func (idx *AddrUtxoIndex) indexBlock(dbTx database.Tx, block *types.SerializedBlock,
+	stxos []blockchain.SpentTxOut, order uint32, connect bool) error {
+	bucket := dbTx.Metadata().Bucket(addrUtxoIndexKey)
+	utxoBucket := bucket.Bucket(addrUtxoBucketName)
+	deltaBucket := bucket.Bucket(addrDeltaBucketName)
+
+	balances := make(map[[addrKeySize]byte]*addrBalance)
+	addBalance := func(addrKey [addrKeySize]byte, received, sent uint64) {
+		balance := balances[addrKey]
+		if balance == nil {
+			balance = &addrBalance{}
+			balances[addrKey] = balance
+		}
+		balance.received += received
+		balance.sent += sent
+	}
+
+	// Find the spent outputs of the inputs of each transaction.
+	txns := block.Transactions()
+	stxoStart := make([]int, len(txns))
+	numStxos := 0
+	for txIdx, tx := range txns {
+		stxoStart[txIdx] = numStxos
+		if !tx.Transaction().IsCoinBase() {
+			numStxos += len(tx.Transaction().TxIn)
+		}
+	}
+	if numStxos != len(stxos) {
+		return AssertError(fmt.Sprintf("block %s spends %d outputs, but "+
+			"%d spent outputs were provided", block.Hash(), numStxos,
+			len(stxos)))
+	}
+
+	indexInputs := func(txIdx int, tx *types.Tx) error {
+		for inIdx, txIn := range tx.Transaction().TxIn {
+			stxo := &stxos[stxoStart[txIdx]+inIdx]
+			addrKey, ok := idx.addrKeyForScript(stxo.PkScript)
+			if !ok {
+				continue
+			}
+			addBalance(addrKey, 0, stxo.Amount)
+
+			utxoKey := addrUtxoKey(addrKey, &txIn.PreviousOut)
+			deltaKey := addrDeltaKey(addrKey, order, txIdx, true, inIdx)
+			if !connect {
+				err := utxoBucket.Put(utxoKey, serializeAddrUtxo(
+					stxo.Amount, &stxo.BlockHash, stxo.IsCoinBase,
+					stxo.PkScript))
+				if err != nil {
+					return err
+				}
+				if err := deltaBucket.Delete(deltaKey); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := utxoBucket.Delete(utxoKey); err != nil {
+				return err
+			}
+			var delta [addrDeltaValueSize]byte
+			byteOrder.PutUint64(delta[:], uint64(-int64(stxo.Amount)))
+			copy(delta[8:], tx.Hash()[:])
+			if err := deltaBucket.Put(deltaKey, delta[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	indexOutputs := func(txIdx int, tx *types.Tx) error {
+		msgTx := tx.Transaction()
+		for outIdx, txOut := range msgTx.TxOut {
+			addrKey, ok := idx.addrKeyForScript(txOut.PkScript)
+			if !ok {
+				continue
+			}
+			addBalance(addrKey, txOut.Amount, 0)
+
+			outpoint := types.TxOutPoint{Hash: *tx.Hash(), OutIndex: uint32(outIdx)}
+			utxoKey := addrUtxoKey(addrKey, &outpoint)
+			deltaKey := addrDeltaKey(addrKey, order, txIdx, false, outIdx)
+			if !connect {
+				if err := utxoBucket.Delete(utxoKey); err != nil {
+					return err
+				}
+				if err := deltaBucket.Delete(deltaKey); err != nil {
+					return err
+				}
+				continue
+			}
+			err := utxoBucket.Put(utxoKey, serializeAddrUtxo(txOut.Amount,
+				block.Hash(), msgTx.IsCoinBase(), txOut.PkScript))
+			if err != nil {
+				return err
+			}
+			var delta [addrDeltaValueSize]byte
+			byteOrder.PutUint64(delta[:], txOut.Amount)
+			copy(delta[8:], tx.Hash()[:])
+			if err := deltaBucket.Put(deltaKey, delta[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The transactions are undone in reverse order when disconnecting, so
+	// the outputs created and spent in the block are removed again.
+	for i := range txns {
+		txIdx := i
+		if !connect {
+			txIdx = len(txns) - 1 - i
+		}
+		tx := txns[txIdx]
+		isCoinBase := tx.Transaction().IsCoinBase()
+		if connect && !isCoinBase {
+			if err := indexInputs(txIdx, tx); err != nil {
+				return err
+			}
+		}
+		if err := indexOutputs(txIdx, tx); err != nil {
+			return err
+		}
+		if !connect && !isCoinBase {
+			if err := indexInputs(txIdx, tx); err != nil {
+				return err
+			}
+		}
+	}
+
+	balanceBucket := bucket.Bucket(addrBalanceBucketName)
+	for addrKey, change := range balances {
+		var balance addrBalance
+		serialized := balanceBucket.Get(addrKey[:])
+		if len(serialized) == 16 {
+			balance.received = byteOrder.Uint64(serialized)
+			balance.sent = byteOrder.Uint64(serialized[8:])
+		}
+		if connect {
+			balance.received += change.received
+			balance.sent += change.sent
+		} else {
+			balance.received -= change.received
+			balance.sent -= change.sent
+		}
+		if balance.received == 0 && balance.sent == 0 {
+			if err := balanceBucket.Delete(addrKey[:]); err != nil {
+				return err
+			}
+			continue
+		}
+		var updated [16]byte
+		byteOrder.PutUint64(updated[:], balance.received)
+		byteOrder.PutUint64(updated[8:], balance.sent)
+		if err := balanceBucket.Put(addrKey[:], updated[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer adds the outputs created by the
+// transactions in the block to the unspent outputs of their addresses, and
+// removes the outputs they spend.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	// Nothing is indexed for the blocks whose transactions are invalid.
+	if isConnectedInvalid(block, stxos) {
+		return nil
+	}
+
+	order := uint32(block.Order())
+	err := idx.indexBlock(dbTx, block, stxos, order, true)
+	if err != nil {
+		return err
+	}
+
+	// Remember the order the block was connected at, since it might be
+	// different once the block is disconnected by a reorder of the DAG.
+	var serializedOrder [4]byte
+	byteOrder.PutUint32(serializedOrder[:], order)
+	blockBucket := dbTx.Metadata().Bucket(addrUtxoIndexKey).
+		Bucket(addrUtxoBlockBucketName)
+	return blockBucket.Put(block.Hash()[:], serializedOrder[:])
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the entries added
+// when the block was connected, and restores the outputs spent by it.
+//
+// This is part of the Indexer interface.
+func (idx *AddrUtxoIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	blockBucket := dbTx.Metadata().Bucket(addrUtxoIndexKey).
+		Bucket(addrUtxoBlockBucketName)
+	serializedOrder := blockBucket.Get(block.Hash()[:])
+	// Nothing was indexed for the block when its transactions were
+	// invalid.
+	if serializedOrder == nil {
+		return nil
+	}
+	order := byteOrder.Uint32(serializedOrder)
+	err := idx.indexBlock(dbTx, block, stxos, order, false)
+	if err != nil {
+		return err
+	}
+	return blockBucket.Delete(block.Hash()[:])
+}
+
+// Balance returns the total amounts received and sent by the passed address.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) Balance(addr types.Address) (uint64, uint64, error) {
+	addrKey, err := addrToKey(addr, idx.chainParams)
+	if err != nil {
+		return 0, 0, err
+	}
+	var received, sent uint64
+	err = idx.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Bucket(addrUtxoIndexKey).
+			Bucket(addrBalanceBucketName).Get(addrKey[:])
+		if len(serialized) == 16 {
+			received = byteOrder.Uint64(serialized)
+			sent = byteOrder.Uint64(serialized[8:])
+		}
+		return nil
+	})
+	return received, sent, err
+}
+
+// Utxos returns up to count unspent outputs of the passed address after
+// skipping the first skip ones.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) Utxos(addr types.Address, skip, count uint32) ([]*AddrUtxo, error) {
+	addrKey, err := addrToKey(addr, idx.chainParams)
+	if err != nil {
+		return nil, err
+	}
+	var utxos []*AddrUtxo
+	err = idx.db.View(func(dbTx database.Tx) error {
+		cursor := dbTx.Metadata().Bucket(addrUtxoIndexKey).
+			Bucket(addrUtxoBucketName).Cursor()
+		for ok := cursor.Seek(addrKey[:]); ok &&
+			uint32(len(utxos)) < count; ok = cursor.Next() {
+			key, serialized := cursor.Key(), cursor.Value()
+			if !bytes.HasPrefix(key, addrKey[:]) {
+				break
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if len(key) != addrKeySize+hash.HashSize+4 ||
+				len(serialized) < addrUtxoValueSize {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt address "+
+						"utxo entry for %s", addr.Encode()),
+				}
+			}
+			utxo := &AddrUtxo{
+				Amount:     byteOrder.Uint64(serialized),
+				IsCoinBase: serialized[8+hash.HashSize] == 1,
+				PkScript:   append([]byte(nil), serialized[addrUtxoValueSize:]...),
+			}
+			copy(utxo.OutPoint.Hash[:], key[addrKeySize:])
+			utxo.OutPoint.OutIndex = byteOrder.Uint32(key[addrKeySize+hash.HashSize:])
+			copy(utxo.BlockHash[:], serialized[8:])
+			utxos = append(utxos, utxo)
+		}
+		return nil
+	})
+	return utxos, err
+}
+
+// Deltas returns up to count balance changes of the passed address in the
+// blocks from startOrder to endOrder, inclusive, after skipping the first
+// skip ones.
+//
+// This function is safe for concurrent access.
+func (idx *AddrUtxoIndex) Deltas(addr types.Address, startOrder, endOrder uint32,
+	skip, count uint32) ([]*AddrDelta, error) {
+	addrKey, err := addrToKey(addr, idx.chainParams)
+	if err != nil {
+		return nil, err
+	}
+	var deltas []*AddrDelta
+	err = idx.db.View(func(dbTx database.Tx) error {
+		cursor := dbTx.Metadata().Bucket(addrUtxoIndexKey).
+			Bucket(addrDeltaBucketName).Cursor()
+		seek := make([]byte, addrKeySize+4)
+		copy(seek, addrKey[:])
+		deltaKeyOrder.PutUint32(seek[addrKeySize:], startOrder)
+		for ok := cursor.Seek(seek); ok &&
+			uint32(len(deltas)) < count; ok = cursor.Next() {
+			key, serialized := cursor.Key(), cursor.Value()
+			if !bytes.HasPrefix(key, addrKey[:]) {
+				break
+			}
+			if len(key) != addrDeltaKeySize ||
+				len(serialized) != addrDeltaValueSize {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt address "+
+						"delta entry for %s", addr.Encode()),
+				}
+			}
+			order := deltaKeyOrder.Uint32(key[addrKeySize:])
+			if order > endOrder {
+				break
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			delta := &AddrDelta{
+				Order:   order,
+				IsInput: key[addrKeySize+8] == 1,
+				Index:   deltaKeyOrder.Uint32(key[addrKeySize+9:]),
+				Amount:  int64(byteOrder.Uint64(serialized)),
+			}
+			copy(delta.TxHash[:], serialized[8:])
+			deltas = append(deltas, delta)
+		}
+		return nil
+	})
+	return deltas, err
+}
+
+// NewAddrUtxoIndex returns a new instance of an indexer that is used to
+// create a mapping of all addresses to their unspent outputs and balances.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewAddrUtxoIndex(db database.DB, chainParams *params.Params) *AddrUtxoIndex {
+	return &AddrUtxoIndex{
+		db:          db,
+		chainParams: chainParams,
+	}
+}
+
+// DropAddrUtxoIndex drops the address utxo index from the provided database
+// if it exists.
+func DropAddrUtxoIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, addrUtxoIndexKey, addrUtxoIndexName, interrupt)
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package index
+
+import (
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/params"
+	"reflect"
+	"testing"
+)
+
+// newTestAddrUtxoIndex creates an address utxo index on a temporary database,
+// which is removed by the returned function.
+func newTestAddrUtxoIndex(t *testing.T) (*AddrUtxoIndex, func()) {
+	db, remove := newTestDB(t)
+	idx := NewAddrUtxoIndex(db, &params.PrivNetParams)
+	if err := db.Update(idx.Create); err != nil {
+		remove()
+		t.Fatalf("Failed to create the index: %v", err)
+	}
+	return idx, remove
+}
+
+// dumpAddrUtxoIndex returns all the entries of the index by bucket and key.
+func dumpAddrUtxoIndex(t *testing.T, idx *AddrUtxoIndex) map[string]string {
+	entries := make(map[string]string)
+	err := idx.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(addrUtxoIndexKey)
+		for _, name := range [][]byte{addrUtxoBucketName,
+			addrBalanceBucketName, addrDeltaBucketName,
+			addrUtxoBlockBucketName} {
+			err := bucket.Bucket(name).ForEach(func(k, v []byte) error {
+				entries[string(name)+"/"+string(k)] = string(v)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// connectAddrUtxo connects the block to the index at the passed order.
+func connectAddrUtxo(t *testing.T, idx *AddrUtxoIndex, block *types.SerializedBlock,
+	order uint64, stxos []blockchain.SpentTxOut) {
+	block.SetOrder(order)
+	err := idx.db.Update(func(dbTx database.Tx) error {
+		return idx.ConnectBlock(dbTx, block, stxos)
+	})
+	if err != nil {
+		t.Fatalf("Failed to connect block %s: %v", block.Hash(), err)
+	}
+}
+
+// disconnectAddrUtxo disconnects the block from the index.
+func disconnectAddrUtxo(t *testing.T, idx *AddrUtxoIndex, block *types.SerializedBlock,
+	stxos []blockchain.SpentTxOut) {
+	err := idx.db.Update(func(dbTx database.Tx) error {
+		return idx.DisconnectBlock(dbTx, block, stxos)
+	})
+	if err != nil {
+		t.Fatalf("Failed to disconnect block %s: %v", block.Hash(), err)
+	}
+}
+
+// checkBalance fails the test when the address didn't receive and send the
+// passed amounts or doesn't have the passed number of unspent outputs.
+func checkBalance(t *testing.T, idx *AddrUtxoIndex, name string, addr types.Address,
+	received, sent uint64, numUtxos int) {
+	gotReceived, gotSent, err := idx.Balance(addr)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if gotReceived != received || gotSent != sent {
+		t.Errorf("%s: got received %d and sent %d, want %d and %d", name,
+			gotReceived, gotSent, received, sent)
+	}
+	utxos, err := idx.Utxos(addr, 0, 100)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if len(utxos) != numUtxos {
+		t.Errorf("%s: got %d unspent outputs, want %d", name, len(utxos),
+			numUtxos)
+	}
+}
+
+// TestAddrUtxoIndexConnectDisconnect checks that disconnecting the blocks
+// from the last one restores the index to its state before each of them was
+// connected, including blocks only holding their coinbase, blocks spending
+// outputs created in them and blocks whose transactions are invalid.
+func TestAddrUtxoIndexConnectDisconnect(t *testing.T) {
+	idx, remove := newTestAddrUtxoIndex(t)
+	defer remove()
+	addrA, scriptA := testAddr(t, 1)
+	addrB, scriptB := testAddr(t, 2)
+
+	tb := newTestBlocks()
+	type connected struct {
+		block *types.SerializedBlock
+		stxos []blockchain.SpentTxOut
+	}
+	var blocks []connected
+	add := func(block *types.SerializedBlock, stxos []blockchain.SpentTxOut) {
+		blocks = append(blocks, connected{block, stxos})
+	}
+
+	// A block only holding its coinbase spends nothing.
+	cb1 := tb.coinbase(testOutput{100, scriptA})
+	add(tb.block(t, cb1))
+
+	tx2 := tb.spend([]types.TxOutPoint{outPoint(cb1, 0)},
+		testOutput{60, scriptB}, testOutput{40, scriptA})
+	add(tb.block(t, tb.coinbase(testOutput{50, scriptB}), tx2))
+
+	// The second transaction spends an output of the first one.
+	tx3 := tb.spend([]types.TxOutPoint{outPoint(tx2, 0)},
+		testOutput{60, scriptA})
+	tx3b := tb.spend([]types.TxOutPoint{outPoint(tx3, 0)},
+		testOutput{55, scriptB})
+	add(tb.block(t, tb.coinbase(testOutput{10, scriptA}), tx3, tx3b))
+
+	// The transactions of the last block are invalid, so it is connected
+	// without the outputs it spends.
+	tx4 := tb.spend([]types.TxOutPoint{outPoint(tx2, 1)},
+		testOutput{40, scriptB})
+	block4, _ := tb.block(t, tb.coinbase(testOutput{10, scriptB}), tx4)
+	add(block4, nil)
+
+	snapshots := []map[string]string{dumpAddrUtxoIndex(t, idx)}
+	for i, b := range blocks {
+		connectAddrUtxo(t, idx, b.block, uint64(i+1), b.stxos)
+		snapshots = append(snapshots, dumpAddrUtxoIndex(t, idx))
+	}
+	checkBalance(t, idx, "address A", addrA, 100+40+10+60, 100+60, 2)
+	checkBalance(t, idx, "address B", addrB, 50+60+55, 60, 2)
+	if !reflect.DeepEqual(snapshots[len(snapshots)-1],
+		snapshots[len(snapshots)-2]) {
+		t.Errorf("Block with invalid transactions was indexed")
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		disconnectAddrUtxo(t, idx, blocks[i].block, blocks[i].stxos)
+		if got := dumpAddrUtxoIndex(t, idx); !reflect.DeepEqual(got,
+			snapshots[i]) {
+			t.Fatalf("Disconnecting block %d left %d entries, want %d",
+				i+1, len(got), len(snapshots[i]))
+		}
+	}
+	if len(snapshots[0]) != 0 {
+		t.Fatalf("Empty index has %d entries", len(snapshots[0]))
+	}
+}
+
+// TestAddrUtxoIndexReorder checks that the blocks disconnected by a reorder of
+// the DAG and connected again in the new order are indexed by their new order,
+// where a double spend which was invalid becomes valid and the other way
+// around, and that reverting the reorder restores the index.
+func TestAddrUtxoIndexReorder(t *testing.T) {
+	idx, remove := newTestAddrUtxoIndex(t)
+	defer remove()
+	addrA, scriptA := testAddr(t, 1)
+	addrC, scriptC := testAddr(t, 3)
+	addrD, scriptD := testAddr(t, 4)
+
+	tb := newTestBlocks()
+	cb := tb.coinbase(testOutput{100, scriptA})
+	base, baseStxos := tb.block(t, cb)
+	spent := []types.TxOutPoint{outPoint(cb, 0)}
+	blockX, stxosX := tb.block(t, tb.coinbase(testOutput{5, scriptC}),
+		tb.spend(spent, testOutput{90, scriptC}))
+	blockY, stxosY := tb.block(t, tb.coinbase(testOutput{5, scriptD}),
+		tb.spend(spent, testOutput{80, scriptD}))
+
+	connectAddrUtxo(t, idx, base, 1, baseStxos)
+	baseSnapshot := dumpAddrUtxoIndex(t, idx)
+
+	// In the old order X spends the output first, so Y is invalid.
+	connectAddrUtxo(t, idx, blockX, 2, stxosX)
+	connectAddrUtxo(t, idx, blockY, 3, nil)
+	oldSnapshot := dumpAddrUtxoIndex(t, idx)
+	checkBalance(t, idx, "old order address A", addrA, 100, 100, 0)
+	checkBalance(t, idx, "old order address C", addrC, 95, 0, 2)
+	checkBalance(t, idx, "old order address D", addrD, 0, 0, 0)
+
+	// The reorder disconnects the blocks from the last one, and connects
+	// them in the new order, where Y spends the output first.
+	disconnectAddrUtxo(t, idx, blockY, nil)
+	disconnectAddrUtxo(t, idx, blockX, stxosX)
+	if got := dumpAddrUtxoIndex(t, idx); !reflect.DeepEqual(got, baseSnapshot) {
+		t.Fatalf("Disconnecting the old order left %d entries, want %d",
+			len(got), len(baseSnapshot))
+	}
+	connectAddrUtxo(t, idx, blockY, 2, stxosY)
+	connectAddrUtxo(t, idx, blockX, 3, nil)
+	checkBalance(t, idx, "new order address A", addrA, 100, 100, 0)
+	checkBalance(t, idx, "new order address C", addrC, 0, 0, 0)
+	checkBalance(t, idx, "new order address D", addrD, 85, 0, 2)
+	deltas, err := idx.Deltas(addrD, 0, 10, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, delta := range deltas {
+		if delta.Order != 2 {
+			t.Errorf("Got delta at order %d, want 2", delta.Order)
+		}
+	}
+
+	// Reverting the reorder restores the index of the old order.
+	disconnectAddrUtxo(t, idx, blockX, nil)
+	disconnectAddrUtxo(t, idx, blockY, stxosY)
+	if got := dumpAddrUtxoIndex(t, idx); !reflect.DeepEqual(got, baseSnapshot) {
+		t.Fatalf("Disconnecting the new order left %d entries, want %d",
+			len(got), len(baseSnapshot))
+	}
+	connectAddrUtxo(t, idx, blockX, 2, stxosX)
+	connectAddrUtxo(t, idx, blockY, 3, nil)
+	if got := dumpAddrUtxoIndex(t, idx); !reflect.DeepEqual(got, oldSnapshot) {
+		t.Fatalf("Reverting the reorder left %d entries, want %d", len(got),
+			len(oldSnapshot))
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"sort"
+)
+
+const (
+	// blockStatsIndexName is the human-readable name for the index.
+	blockStatsIndexName = "block stats index"
+
+	// blockStatsSize is the size of a serialized block stats entry.
+	blockStatsSize = 1 + 4*4 + 8*(9+len(FeeRatePercentiles))
+)
+
+var (
+	// blockStatsIndexKey is the key of the block stats index and the db
+	// bucket used to house it.
+	blockStatsIndexKey = []byte("blockstatsidx")
+
+	// FeeRatePercentiles are the percentiles of the fee rates, weighted by
+	// the size of the transactions, which are recorded for each block.
+	FeeRatePercentiles = [5]int{10, 25, 50, 75, 90}
+)
+
+// -----------------------------------------------------------------------------
+// The block stats index consists of the statistics of every block connected
+// to the chain, keyed by the block hash.  The fees and the subsidy are only
+// known for blocks whose transactions are valid, and are zero otherwise.
+//
+// The serialized format for the keys and values in the block stats bucket is:
+//
+//   <block hash> = <valid><size><txs><inputs><outputs><total size><total out>
+//                  <subsidy><total fee><min fee><max fee><min fee rate>
+//                  <max fee rate><fee rate percentiles>
+//
+//   Field                Type              Size
+//   valid                bool              1 byte
+//   size                 uint32            4 bytes
+//   txs                  uint32            4 bytes
+//   inputs               uint32            4 bytes
+//   outputs              uint32            4 bytes
+//   total size           uint64            8 bytes
+//   total out            uint64            8 bytes
+//   subsidy              uint64            8 bytes
+//   total fee            uint64            8 bytes
+//   min fee              uint64            8 bytes
+//   max fee              uint64            8 bytes
+//   min fee rate         uint64            8 bytes
+//   max fee rate         uint64            8 bytes
+//   fee rate percentiles [5]uint64         40 bytes
+//   -----
+//   Total: 129 bytes
+// -----------------------------------------------------------------------------
+
+// BlockStats describes the statistics of a block.  The sizes, outputs and
+// fees don't include the coinbase, and the fee rates are in atoms/kB.
+type BlockStats struct {
+	Valid              bool
+	Size               uint32
+	Txs                uint32
+	Inputs             uint32
+	Outputs            uint32
+	TotalSize          uint64
+	TotalOut           uint64
+	Subsidy            uint64
+	TotalFee           uint64
+	MinFee             uint64
+	MaxFee             uint64
+	MinFeeRate         uint64
+	MaxFeeRate         uint64
+	FeeRatePercentiles [len(FeeRatePercentiles)]uint64
+}
+
+// serialize returns the serialized block stats entry.
+func (stats *BlockStats) serialize() []byte {
+	serialized := make([]byte, blockStatsSize)
+	if stats.Valid {
+		serialized[0] = 1
+	}
+	offset := 1
+	for _, v := range []uint32{stats.Size, stats.Txs, stats.Inputs, stats.Outputs} {
+		byteOrder.PutUint32(serialized[offset:], v)
+		offset += 4
+	}
+	values := []uint64{stats.TotalSize, stats.TotalOut, stats.Subsidy,
+		stats.TotalFee, stats.MinFee, stats.MaxFee, stats.MinFeeRate,
+		stats.MaxFeeRate}
+	values = append(values, stats.FeeRatePercentiles[:]...)
+	for _, v := range values {
+		byteOrder.PutUint64(serialized[offset:], v)
+		offset += 8
+	}
+	return serialized
+}
+
+// deserialize decodes the passed serialized block stats entry.
+func (stats *BlockStats) deserialize(serialized []byte) error {
+	if len(serialized) != blockStatsSize {
+		return errDeserialize(fmt.Sprintf("unexpected block stats size "+
+			"%d", len(serialized)))
+	}
+	stats.Valid = serialized[0] == 1
+	offset := 1
+	for _, v := range []*uint32{&stats.Size, &stats.Txs, &stats.Inputs, &stats.Outputs} {
+		*v = byteOrder.Uint32(serialized[offset:])
+		offset += 4
+	}
+	values := []*uint64{&stats.TotalSize, &stats.TotalOut, &stats.Subsidy,
+		&stats.TotalFee, &stats.MinFee, &stats.MaxFee, &stats.MinFeeRate,
+		&stats.MaxFeeRate}
+	for i := range stats.FeeRatePercentiles {
+		values = append(values, &stats.FeeRatePercentiles[i])
+	}
+	for _, v := range values {
+		*v = byteOrder.Uint64(serialized[offset:])
+		offset += 8
+	}
+	return nil
+}
+
+// txFeeRate is the fee rate and the size of a transaction, used to calculate
+// the fee rate percentiles of a block.
+type txFeeRate struct {
+	feeRate uint64
+	size    uint64
+}
+
+// calcBlockStats returns the statistics of the passed block.  The fees are
+// calculated from the passed spent outputs when valid is set.
+func calcBlockStats(block *types.SerializedBlock, stxos []blockchain.SpentTxOut, valid bool) (*BlockStats, error) {
+	txns := block.Transactions()
+	stats := &BlockStats{
+		Valid: valid,
+		Size:  uint32(block.Block().SerializeSize()),
+		Txs:   uint32(len(txns)),
+	}
+
+	var feeRates []txFeeRate
+	stxoIdx := 0
+	for _, tx := range txns {
+		msgTx := tx.Transaction()
+		stats.Outputs += uint32(len(msgTx.TxOut))
+		if msgTx.IsCoinBase() {
+			continue
+		}
+		stats.Inputs += uint32(len(msgTx.TxIn))
+		size := uint64(msgTx.SerializeSize())
+		stats.TotalSize += size
+		var totalOut uint64
+		for _, txOut := range msgTx.TxOut {
+			totalOut += txOut.Amount
+		}
+		stats.TotalOut += totalOut
+		if !valid {
+			continue
+		}
+
+		var totalIn uint64
+		for range msgTx.TxIn {
+			if stxoIdx >= len(stxos) {
+				return nil, AssertError(fmt.Sprintf("missing spent "+
+					"outputs of block %s", block.Hash()))
+			}
+			totalIn += stxos[stxoIdx].Amount
+			stxoIdx++
+		}
+		if totalIn < totalOut {
+			return nil, AssertError(fmt.Sprintf("transaction %s of "+
+				"block %s spends more than its inputs", tx.Hash(),
+				block.Hash()))
+		}
+		fee := totalIn - totalOut
+		feeRate := fee * 1000 / size
+		if len(feeRates) == 0 || fee < stats.MinFee {
+			stats.MinFee = fee
+		}
+		if fee > stats.MaxFee {
+			stats.MaxFee = fee
+		}
+		if len(feeRates) == 0 || feeRate < stats.MinFeeRate {
+			stats.MinFeeRate = feeRate
+		}
+		if feeRate > stats.MaxFeeRate {
+			stats.MaxFeeRate = feeRate
+		}
+		stats.TotalFee += fee
+		feeRates = append(feeRates, txFeeRate{feeRate: feeRate, size: size})
+	}
+
+	if valid {
+		var coinbaseOut uint64
+		for _, txOut := range txns[0].Transaction().TxOut {
+			coinbaseOut += txOut.Amount
+		}
+		if coinbaseOut > stats.TotalFee {
+			stats.Subsidy = coinbaseOut - stats.TotalFee
+		}
+	}
+
+	// The percentiles are the fee rates paid by the transactions at the
+	// percentiles of the total size, ordered by fee rate.
+	if len(feeRates) > 0 {
+		sort.Slice(feeRates, func(i, j int) bool {
+			return feeRates[i].feeRate < feeRates[j].feeRate
+		})
+		var cumulative uint64
+		next := 0
+		for _, txFeeRate := range feeRates {
+			cumulative += txFeeRate.size
+			for next < len(FeeRatePercentiles) &&
+				cumulative*100 >= stats.TotalSize*uint64(FeeRatePercentiles[next]) {
+				stats.FeeRatePercentiles[next] = txFeeRate.feeRate
+				next++
+			}
+		}
+	}
+	return stats, nil
+}
+
+// BlockStatsIndex implements a block statistics index, which records the
+// fees, the transaction counts and the sizes of every block.
+type BlockStatsIndex struct {
+	db database.DB
+}
+
+// Ensure the BlockStatsIndex type implements the Indexer interface.
+var _ Indexer = (*BlockStatsIndex)(nil)
+
+// Ensure the BlockStatsIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*BlockStatsIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *BlockStatsIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *BlockStatsIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *BlockStatsIndex) Key() []byte {
+	return blockStatsIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *BlockStatsIndex) Name() string {
+	return blockStatsIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the block
+// stats index.
+//
+// This is part of the Indexer interface.
+func (idx *BlockStatsIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(blockStatsIndexKey)
+	return err
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer records the statistics of the
+// block.
+//
+// This is part of the Indexer interface.
+func (idx *BlockStatsIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	stats, err := calcBlockStats(block, stxos,
+		!isConnectedInvalid(block, stxos))
+	if err != nil {
+		return err
+	}
+	bucket := dbTx.Metadata().Bucket(blockStatsIndexKey)
+	return bucket.Put(block.Hash()[:], stats.serialize())
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the statistics of
+// the block, which are recorded again if it's connected at another order.
+//
+// This is part of the Indexer interface.
+func (idx *BlockStatsIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	bucket := dbTx.Metadata().Bucket(blockStatsIndexKey)
+	return bucket.Delete(block.Hash()[:])
+}
+
+// BlockStats returns the statistics of the passed block, or nil when the
+// block isn't indexed.
+//
+// This function is safe for concurrent access.
+func (idx *BlockStatsIndex) BlockStats(h *hash.Hash) (*BlockStats, error) {
+	var stats *BlockStats
+	err := idx.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Bucket(blockStatsIndexKey).Get(h[:])
+		if serialized == nil {
+			return nil
+		}
+		stats = &BlockStats{}
+		err := stats.deserialize(serialized)
+		if err != nil {
+			return database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("corrupt block stats entry "+
+					"for %s: %v", h, err),
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// NewBlockStatsIndex returns a new instance of an indexer that is used to
+// create a mapping of the hashes of all blocks to their statistics.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewBlockStatsIndex(db database.DB) *BlockStatsIndex {
+	return &BlockStatsIndex{db: db}
+}
+
+// DropBlockStatsIndex drops the block stats index from the provided database
+// if it exists.
+func DropBlockStatsIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, blockStatsIndexKey, blockStatsIndexName, interrupt)
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package index
+
+import (
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"testing"
+)
+
+// TestCalcBlockStats checks the statistics of blocks, including the fees and
+// the subsidy paid by their coinbase and the fee rate percentiles weighted by
+// the size of the transactions.
+func TestCalcBlockStats(t *testing.T) {
+	_, script := testAddr(t, 1)
+	tb := newTestBlocks()
+	const funding = 1e8
+	var fundingOuts []testOutput
+	for i := 0; i < 8; i++ {
+		fundingOuts = append(fundingOuts, testOutput{funding, script})
+	}
+	fundingTx := tb.coinbase(fundingOuts...)
+	tb.block(t, fundingTx)
+
+	// spend returns a transaction spending a funding output, which pays
+	// the fee and the rest of the output to the number of outputs.
+	spend := func(i uint32, fee uint64, numOuts int) *types.Transaction {
+		var outs []testOutput
+		amount := (funding - fee) / uint64(numOuts)
+		for j := 0; j < numOuts; j++ {
+			outs = append(outs, testOutput{amount, script})
+		}
+		outs[0].amount += funding - fee - amount*uint64(numOuts)
+		return tb.spend([]types.TxOutPoint{outPoint(fundingTx, i)},
+			outs...)
+	}
+	feeRate := func(tx *types.Transaction, fee uint64) uint64 {
+		return fee * 1000 / uint64(tx.SerializeSize())
+	}
+	const subsidy = 5e9
+
+	// The transactions of the same size, from the lowest fee rate.
+	txA, txB := spend(0, 1000, 1), spend(1, 2000, 1)
+	txC, txD := spend(2, 4000, 1), spend(3, 8000, 1)
+	size := uint64(txA.SerializeSize())
+	cbEqual := tb.coinbase(testOutput{subsidy + 15000, script})
+	equalBlock, equalStxos := tb.block(t, cbEqual, txD, txB, txA, txC)
+
+	// The small transaction pays the lowest fee rate, but it's below the
+	// first percentile of the total size.
+	txSmall, txLarge := spend(4, 100, 1), spend(5, 100000, 30)
+	smallSize := uint64(txSmall.SerializeSize())
+	largeSize := uint64(txLarge.SerializeSize())
+	if smallSize*100 >= (smallSize+largeSize)*10 {
+		t.Fatalf("Small transaction has %d of %d bytes", smallSize,
+			smallSize+largeSize)
+	}
+	cbWeighted := tb.coinbase(testOutput{subsidy, script},
+		testOutput{100100, script})
+	weightedBlock, weightedStxos := tb.block(t, cbWeighted, txSmall, txLarge)
+
+	cbOnly := tb.coinbase(testOutput{subsidy, script})
+	coinbaseBlock, _ := tb.block(t, cbOnly)
+
+	tests := []struct {
+		name  string
+		block *types.SerializedBlock
+		stxos []blockchain.SpentTxOut
+		valid bool
+		want  BlockStats
+	}{
+		{
+			name:  "equal sizes",
+			block: equalBlock,
+			stxos: equalStxos,
+			valid: true,
+			want: BlockStats{
+				Valid:      true,
+				Txs:        5,
+				Inputs:     4,
+				Outputs:    5,
+				TotalSize:  4 * size,
+				TotalOut:   4*funding - 15000,
+				Subsidy:    subsidy,
+				TotalFee:   15000,
+				MinFee:     1000,
+				MaxFee:     8000,
+				MinFeeRate: feeRate(txA, 1000),
+				MaxFeeRate: feeRate(txD, 8000),
+				FeeRatePercentiles: [len(FeeRatePercentiles)]uint64{
+					feeRate(txA, 1000), feeRate(txA, 1000),
+					feeRate(txB, 2000), feeRate(txC, 4000),
+					feeRate(txD, 8000),
+				},
+			},
+		},
+		{
+			name:  "weighted by size",
+			block: weightedBlock,
+			stxos: weightedStxos,
+			valid: true,
+			want: BlockStats{
+				Valid:      true,
+				Txs:        3,
+				Inputs:     2,
+				Outputs:    2 + 1 + 30,
+				TotalSize:  smallSize + largeSize,
+				TotalOut:   2*funding - 100100,
+				Subsidy:    subsidy,
+				TotalFee:   100100,
+				MinFee:     100,
+				MaxFee:     100000,
+				MinFeeRate: feeRate(txSmall, 100),
+				MaxFeeRate: feeRate(txLarge, 100000),
+				FeeRatePercentiles: [len(FeeRatePercentiles)]uint64{
+					feeRate(txLarge, 100000), feeRate(txLarge, 100000),
+					feeRate(txLarge, 100000), feeRate(txLarge, 100000),
+					feeRate(txLarge, 100000),
+				},
+			},
+		},
+		{
+			name:  "invalid transactions",
+			block: equalBlock,
+			valid: false,
+			want: BlockStats{
+				Txs:       5,
+				Inputs:    4,
+				Outputs:   5,
+				TotalSize: 4 * size,
+				TotalOut:  4*funding - 15000,
+			},
+		},
+		{
+			name:  "coinbase only",
+			block: coinbaseBlock,
+			valid: true,
+			want: BlockStats{
+				Valid:   true,
+				Txs:     1,
+				Outputs: 1,
+				Subsidy: subsidy,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		stats, err := calcBlockStats(test.block, test.stxos, test.valid)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		test.want.Size = uint32(test.block.Block().SerializeSize())
+		if *stats != test.want {
+			t.Errorf("%s: got stats %+v, want %+v", test.name, *stats,
+				test.want)
+		}
+
+		// The statistics are recorded as they are calculated.
+		var decoded BlockStats
+		if err := decoded.deserialize(stats.serialize()); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if decoded != *stats {
+			t.Errorf("%s: got decoded stats %+v, want %+v", test.name,
+				decoded, *stats)
+		}
+	}
+
+	// The spent outputs must match the inputs of the block.
+	_, err := calcBlockStats(equalBlock, equalStxos[:3], true)
+	if _, ok := err.(AssertError); !ok {
+		t.Errorf("Missing spent outputs: got error %v", err)
+	}
+	overspent := append([]blockchain.SpentTxOut(nil), equalStxos...)
+	overspent[0].Amount = 100
+	_, err = calcBlockStats(equalBlock, overspent, true)
+	if _, ok := err.(AssertError); !ok {
+		t.Errorf("Spending more than the inputs: got error %v", err)
+	}
+}
+
+// TestBlockStatsIndexValid checks that the blocks connected without the
+// outputs they spend are recorded as invalid, while the blocks only holding
+// their coinbase are valid.
+func TestBlockStatsIndexValid(t *testing.T) {
+	db, remove := newTestDB(t)
+	defer remove()
+	idx := NewBlockStatsIndex(db)
+	if err := db.Update(idx.Create); err != nil {
+		t.Fatalf("Failed to create the index: %v", err)
+	}
+	_, script := testAddr(t, 1)
+	tb := newTestBlocks()
+	cb := tb.coinbase(testOutput{1e8, script})
+	coinbaseBlock, _ := tb.block(t, cb)
+	spendBlock, stxos := tb.block(t, tb.coinbase(testOutput{1e8, script}),
+		tb.spend([]types.TxOutPoint{outPoint(cb, 0)},
+			testOutput{1e8 - 1000, script}))
+
+	tests := []struct {
+		name  string
+		block *types.SerializedBlock
+		stxos []blockchain.SpentTxOut
+		valid bool
+	}{
+		{"coinbase only", coinbaseBlock, nil, true},
+		{"valid transactions", spendBlock, stxos, true},
+		{"invalid transactions", spendBlock, nil, false},
+	}
+	for _, test := range tests {
+		err := db.Update(func(dbTx database.Tx) error {
+			return idx.ConnectBlock(dbTx, test.block, test.stxos)
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to connect the block: %v", test.name, err)
+		}
+		stats, err := idx.BlockStats(test.block.Hash())
+		if err != nil || stats == nil {
+			t.Fatalf("%s: block wasn't indexed: %v", test.name, err)
+		}
+		if stats.Valid != test.valid {
+			t.Errorf("%s: got valid %v, want %v", test.name, stats.Valid,
+				test.valid)
+		}
+		err = db.Update(func(dbTx database.Tx) error {
+			return idx.DisconnectBlock(dbTx, test.block, test.stxos)
+		})
+		if err != nil {
+			t.Fatalf("%s: failed to disconnect the block: %v", test.name,
+				err)
+		}
+		if stats, err := idx.BlockStats(test.block.Hash()); err != nil ||
+			stats != nil {
+			t.Fatalf("%s: block is still indexed: %v", test.name, err)
+		}
+	}
+}
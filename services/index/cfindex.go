@@ -0,0 +1,371 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2017 The btcsuite developers
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"errors"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/services/cf/gcs"
+)
+
+const (
+	// cfIndexName is the human-readable name for the index.
+	cfIndexName = "committed filter index"
+)
+
+var (
+	// cfIndexParentBucketKey is the name of the parent bucket used to house
+	// the index. The rest of the buckets live below this bucket.
+	cfIndexParentBucketKey = []byte("cfindexparentbucket")
+
+	// cfFilterBucketName is the name of the db bucket used to house the
+	// block hash -> regular filter mapping.
+	cfFilterBucketName = []byte("cf0byhashidx")
+
+	// cfHeaderBucketName is the name of the db bucket used to house the
+	// block hash -> regular filter header mapping.
+	cfHeaderBucketName = []byte("cf0headerbyhashidx")
+
+	// cfOrderBucketName is the name of the db bucket used to house the
+	// block order -> block hash mapping of the filter header chain.
+	cfOrderBucketName = []byte("cf0hashbyorderidx")
+
+	// errNoCFilterEntry is an error that indicates a requested entry does
+	// not exist in the committed filter index.
+	errNoCFilterEntry = errors.New("no entry in the committed filter index")
+)
+
+// -----------------------------------------------------------------------------
+// The committed filter index consists of a regular GCS filter and a filter
+// header for every block in the DAG order.  The filter headers commit to the
+// filter of the block and the filter header of the previous block in the DAG
+// order, which is the order they are served in, so a client that trusts a
+// single filter header can verify all of the filters up to that block.  The
+// filter header of the genesis block commits to the zero hash.  The blocks
+// whose order changes when the DAG is reordered are disconnected and connected
+// again in the new order, which chains their filter headers again.
+//
+// There are three buckets below the parent bucket of the index.  The first
+// maps the hash of each block to its serialized filter, the second maps the
+// hash of each block to its filter header and the third maps the order of each
+// block to its hash so the previous filter header can be found when a block
+// is connected and the filter headers can be served in the DAG order.
+//
+// The serialized format for keys and values in the filter bucket is:
+//   <hash> = <N><filter data>
+//
+//   Field           Type              Size
+//   hash            hash.Hash         32 bytes
+//   N               VLQ               variable
+//   filter data     []byte            variable
+//
+// The serialized format for keys and values in the filter header bucket is:
+//   <hash> = <filter header>
+//
+//   Field           Type              Size
+//   hash            hash.Hash         32 bytes
+//   filter header   hash.Hash         32 bytes
+//   -----
+//   Total: 64 bytes
+//
+// The serialized format for keys and values in the order bucket is:
+//   <order> = <hash>
+//
+//   Field           Type              Size
+//   order           uint32            4 bytes
+//   hash            hash.Hash         32 bytes
+//   -----
+//   Total: 36 bytes
+// -----------------------------------------------------------------------------
+
+// dbFetchCFIndexEntry retrieves a filter or filter header entry of the block
+// with the given hash from the passed bucket.
+func dbFetchCFIndexEntry(dbTx database.Tx, bucketName []byte, h *hash.Hash) ([]byte, error) {
+	bucket := dbTx.Metadata().Bucket(cfIndexParentBucketKey).Bucket(bucketName)
+	entry := bucket.Get(h[:])
+	if entry == nil {
+		return nil, errNoCFilterEntry
+	}
+	result := make([]byte, len(entry))
+	copy(result, entry)
+	return result, nil
+}
+
+// dbFetchCFHashByOrder returns the hash of the block with the given order in
+// the filter header chain.
+func dbFetchCFHashByOrder(dbTx database.Tx, order uint32) (*hash.Hash, error) {
+	var serializedOrder [4]byte
+	byteOrder.PutUint32(serializedOrder[:], order)
+
+	bucket := dbTx.Metadata().Bucket(cfIndexParentBucketKey).Bucket(cfOrderBucketName)
+	serializedHash := bucket.Get(serializedOrder[:])
+	if serializedHash == nil {
+		return nil, errNoCFilterEntry
+	}
+	var h hash.Hash
+	copy(h[:], serializedHash)
+	return &h, nil
+}
+
+// dbFetchCFPrevHeader returns the filter header the filter header of the block
+// with the given order commits to, which is the filter header of the previous
+// block in the DAG order or the zero hash for the genesis block.
+func dbFetchCFPrevHeader(dbTx database.Tx, order uint32) (*hash.Hash, error) {
+	if order == 0 {
+		return &hash.ZeroHash, nil
+	}
+	prevHash, err := dbFetchCFHashByOrder(dbTx, order-1)
+	if err != nil {
+		return nil, err
+	}
+	serializedHeader, err := dbFetchCFIndexEntry(dbTx, cfHeaderBucketName, prevHash)
+	if err != nil {
+		return nil, err
+	}
+	return hash.NewHash(serializedHeader)
+}
+
+// dbStoreCFIndexEntries stores the filter and filter header of a block and
+// adds it to the filter header chain.
+func dbStoreCFIndexEntries(dbTx database.Tx, h *hash.Hash, order uint32, filter []byte, header *hash.Hash) error {
+	parent := dbTx.Metadata().Bucket(cfIndexParentBucketKey)
+	if err := parent.Bucket(cfFilterBucketName).Put(h[:], filter); err != nil {
+		return err
+	}
+	if err := parent.Bucket(cfHeaderBucketName).Put(h[:], header[:]); err != nil {
+		return err
+	}
+
+	var serializedOrder [4]byte
+	byteOrder.PutUint32(serializedOrder[:], order)
+	return parent.Bucket(cfOrderBucketName).Put(serializedOrder[:], h[:])
+}
+
+// dbDeleteCFIndexEntries removes the filter and filter header of a block and
+// removes it from the filter header chain.  The order is only released when
+// it still belongs to the block.
+func dbDeleteCFIndexEntries(dbTx database.Tx, h *hash.Hash, order uint32) error {
+	parent := dbTx.Metadata().Bucket(cfIndexParentBucketKey)
+	if err := parent.Bucket(cfFilterBucketName).Delete(h[:]); err != nil {
+		return err
+	}
+	if err := parent.Bucket(cfHeaderBucketName).Delete(h[:]); err != nil {
+		return err
+	}
+
+	var serializedOrder [4]byte
+	byteOrder.PutUint32(serializedOrder[:], order)
+	orderBucket := parent.Bucket(cfOrderBucketName)
+	if !bytes.Equal(orderBucket.Get(serializedOrder[:]), h[:]) {
+		return nil
+	}
+	return orderBucket.Delete(serializedOrder[:])
+}
+
+// CfIndex implements a committed filter (cf) by hash index.
+type CfIndex struct {
+	db database.DB
+}
+
+// Ensure the CfIndex type implements the Indexer interface.
+var _ Indexer = (*CfIndex)(nil)
+
+// Ensure the CfIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*CfIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *CfIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init initializes the hash-based cf index. This is part of the Indexer
+// interface.
+func (idx *CfIndex) Init() error {
+	return nil // Nothing to do.
+}
+
+// Key returns the database key to use for the index as a byte slice. This is
+// part of the Indexer interface.
+func (idx *CfIndex) Key() []byte {
+	return cfIndexParentBucketKey
+}
+
+// Name returns the human-readable name of the index. This is part of the
+// Indexer interface.
+func (idx *CfIndex) Name() string {
+	return cfIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs to
+// be created for the first time. It creates the buckets for the filter index
+// below the parent bucket.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Create(dbTx database.Tx) error {
+	meta := dbTx.Metadata()
+	cfIndexParentBucket, err := meta.CreateBucket(cfIndexParentBucketKey)
+	if err != nil {
+		return err
+	}
+	for _, bucketName := range [][]byte{cfFilterBucketName,
+		cfHeaderBucketName, cfOrderBucketName} {
+		if _, err := cfIndexParentBucket.CreateBucket(bucketName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain. This indexer adds the filter of the block and
+// extends the filter header chain with it.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	prevScripts := make([][]byte, 0, len(stxos))
+	for _, stxo := range stxos {
+		prevScripts = append(prevScripts, stxo.PkScript)
+	}
+	filter, err := gcs.BuildBasicFilter(block.Block(), prevScripts)
+	if err != nil {
+		return err
+	}
+	filterBytes, err := filter.NBytes()
+	if err != nil {
+		return err
+	}
+
+	// The filter header commits to the filter header of the previous block
+	// in the DAG order, and the one of the genesis block to the zero hash.
+	order := uint32(block.Order())
+	prevHeader, err := dbFetchCFPrevHeader(dbTx, order)
+	if err != nil {
+		return err
+	}
+	header, err := gcs.MakeHeaderForFilter(filter, *prevHeader)
+	if err != nil {
+		return err
+	}
+	return dbStoreCFIndexEntries(dbTx, block.Hash(), order, filterBytes, &header)
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the filter and the
+// filter header of the block.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	return dbDeleteCFIndexEntries(dbTx, block.Hash(), uint32(block.Order()))
+}
+
+// FilterByBlockHash returns the serialized regular filter of the block with
+// the given hash.  When there is no entry for the provided hash, nil will be
+// returned for both the filter and the error.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterByBlockHash(h *hash.Hash) ([]byte, error) {
+	var filter []byte
+	err := idx.db.View(func(dbTx database.Tx) error {
+		var err error
+		filter, err = dbFetchCFIndexEntry(dbTx, cfFilterBucketName, h)
+		return err
+	})
+	if err == errNoCFilterEntry {
+		return nil, nil
+	}
+	return filter, err
+}
+
+// FilterHeaderByBlockHash returns the regular filter header of the block with
+// the given hash.  When there is no entry for the provided hash, nil will be
+// returned for both the header and the error.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterHeaderByBlockHash(h *hash.Hash) (*hash.Hash, error) {
+	var header *hash.Hash
+	err := idx.db.View(func(dbTx database.Tx) error {
+		serializedHeader, err := dbFetchCFIndexEntry(dbTx, cfHeaderBucketName, h)
+		if err != nil {
+			return err
+		}
+		header, err = hash.NewHash(serializedHeader)
+		return err
+	})
+	if err == errNoCFilterEntry {
+		return nil, nil
+	}
+	return header, err
+}
+
+// FilterHeadersByOrder returns the block hashes and regular filter headers of
+// up to maxHeaders blocks starting at the given order, along with the filter
+// header the first of them commits to.  The result stops after the block with
+// the stop hash, if it is reached.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterHeadersByOrder(startOrder uint32, stopHash *hash.Hash,
+	maxHeaders int) ([]*hash.Hash, []*hash.Hash, *hash.Hash, error) {
+	var blockHashes, headers []*hash.Hash
+	var prevHeader *hash.Hash
+	err := idx.db.View(func(dbTx database.Tx) error {
+		var err error
+		prevHeader, err = dbFetchCFPrevHeader(dbTx, startOrder)
+		if err != nil {
+			return err
+		}
+		for order := startOrder; len(headers) < maxHeaders; order++ {
+			h, err := dbFetchCFHashByOrder(dbTx, order)
+			if err == errNoCFilterEntry {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			serializedHeader, err := dbFetchCFIndexEntry(dbTx, cfHeaderBucketName, h)
+			if err != nil {
+				return err
+			}
+			header, err := hash.NewHash(serializedHeader)
+			if err != nil {
+				return err
+			}
+			blockHashes = append(blockHashes, h)
+			headers = append(headers, header)
+			if stopHash != nil && h.IsEqual(stopHash) {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err == errNoCFilterEntry {
+		return nil, nil, nil, nil
+	}
+	return blockHashes, headers, prevHeader, err
+}
+
+// NewCfIndex returns a new instance of an indexer that is used to create a
+// mapping of the hashes of all blocks in the blockchain to their respective
+// committed filters.
+//
+// It implements the Indexer interface which plugs into the IndexManager that
+// in turn is used by the blockchain package. This allows the index to be
+// seamlessly maintained along with the chain.
+func NewCfIndex(db database.DB) *CfIndex {
+	return &CfIndex{db: db}
+}
+
+// DropCfIndex drops the CF index from the provided database if exists.
+func DropCfIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, cfIndexParentBucketKey, cfIndexName, interrupt)
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package index
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/services/cf/gcs"
+	"testing"
+)
+
+// serveCFHeaders returns the cfheaders message served for the filter headers
+// from the start order up to the stop hash, as it is read by the peer.
+func serveCFHeaders(t *testing.T, idx *CfIndex, startOrder uint32, stopHash *hash.Hash) *message.MsgCFHeaders {
+	blockHashes, headers, prevHeader, err := idx.FilterHeadersByOrder(
+		startOrder, stopHash, message.MaxCFHeadersPerMsg)
+	if err != nil {
+		t.Fatalf("Failed to fetch the filter headers: %v", err)
+	}
+	if len(headers) == 0 {
+		t.Fatalf("No filter headers from order %d", startOrder)
+	}
+	msg := message.NewMsgCFHeaders()
+	msg.FilterType = message.GCSFilterRegular
+	msg.StopHash = *blockHashes[len(blockHashes)-1]
+	msg.PrevFilterHeader = *prevHeader
+	for i, header := range headers {
+		msg.AddCFHeader(blockHashes[i], header)
+	}
+
+	var buf bytes.Buffer
+	if err := msg.Encode(&buf, protocol.NodeCFVersion); err != nil {
+		t.Fatalf("Failed to encode the filter headers: %v", err)
+	}
+	var read message.MsgCFHeaders
+	if err := read.Decode(&buf, protocol.NodeCFVersion); err != nil {
+		t.Fatalf("Failed to decode the filter headers: %v", err)
+	}
+	return &read
+}
+
+// checkCFHeaders fails the test unless the filter headers of the message are
+// the chain of the filters of their blocks, starting at the passed header.
+func checkCFHeaders(t *testing.T, name string, idx *CfIndex, msg *message.MsgCFHeaders,
+	prevHeader hash.Hash, blocks []*types.SerializedBlock) {
+	if msg.PrevFilterHeader != prevHeader {
+		t.Fatalf("%s: got previous filter header %s, want %s", name,
+			msg.PrevFilterHeader, prevHeader)
+	}
+	if len(msg.BlockHashes) != len(blocks) {
+		t.Fatalf("%s: got %d filter headers, want %d", name,
+			len(msg.BlockHashes), len(blocks))
+	}
+	header := msg.PrevFilterHeader
+	for i, blockHash := range msg.BlockHashes {
+		if !blockHash.IsEqual(blocks[i].Hash()) {
+			t.Fatalf("%s: got block %s at %d, want %s", name, blockHash,
+				i, blocks[i].Hash())
+		}
+		filterBytes, err := idx.FilterByBlockHash(blockHash)
+		if err != nil || filterBytes == nil {
+			t.Fatalf("%s: no filter for block %s: %v", name, blockHash, err)
+		}
+		filter, err := gcs.FromNBytes(gcs.DefaultP, gcs.DefaultM, filterBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, err = gcs.MakeHeaderForFilter(filter, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !msg.HeaderHashes[i].IsEqual(&header) {
+			t.Fatalf("%s: got filter header %s of block %s, want %s",
+				name, msg.HeaderHashes[i], blockHash, header)
+		}
+	}
+	if !msg.StopHash.IsEqual(blocks[len(blocks)-1].Hash()) {
+		t.Fatalf("%s: got stop hash %s, want %s", name, msg.StopHash,
+			blocks[len(blocks)-1].Hash())
+	}
+}
+
+// TestCfIndexServedHeaders checks that the filter headers are served in the
+// DAG order they are chained in, so a client can rebuild them from the filters
+// of the blocks of the message, also after the DAG is reordered.
+func TestCfIndexServedHeaders(t *testing.T) {
+	db, remove := newTestDB(t)
+	defer remove()
+	idx := NewCfIndex(db)
+	if err := db.Update(idx.Create); err != nil {
+		t.Fatalf("Failed to create the index: %v", err)
+	}
+	connect := func(block *types.SerializedBlock, order uint64,
+		stxos []blockchain.SpentTxOut) {
+		block.SetOrder(order)
+		err := db.Update(func(dbTx database.Tx) error {
+			return idx.ConnectBlock(dbTx, block, stxos)
+		})
+		if err != nil {
+			t.Fatalf("Failed to connect block %s: %v", block.Hash(), err)
+		}
+	}
+	disconnect := func(block *types.SerializedBlock, stxos []blockchain.SpentTxOut) {
+		err := db.Update(func(dbTx database.Tx) error {
+			return idx.DisconnectBlock(dbTx, block, stxos)
+		})
+		if err != nil {
+			t.Fatalf("Failed to disconnect block %s: %v", block.Hash(), err)
+		}
+	}
+
+	_, scriptA := testAddr(t, 1)
+	_, scriptB := testAddr(t, 2)
+	tb := newTestBlocks()
+	var blocks []*types.SerializedBlock
+	var stxos [][]blockchain.SpentTxOut
+	add := func(block *types.SerializedBlock, blockStxos []blockchain.SpentTxOut) {
+		blocks = append(blocks, block)
+		stxos = append(stxos, blockStxos)
+	}
+	cb := tb.coinbase(testOutput{100, scriptA}, testOutput{100, scriptB})
+	add(tb.block(t, cb))
+	add(tb.block(t, tb.coinbase(testOutput{50, scriptB}),
+		tb.spend([]types.TxOutPoint{outPoint(cb, 0)}, testOutput{90, scriptB})))
+	add(tb.block(t, tb.coinbase(testOutput{50, scriptA})))
+	add(tb.block(t, tb.coinbase(testOutput{50, scriptA}),
+		tb.spend([]types.TxOutPoint{outPoint(cb, 1)}, testOutput{90, scriptA})))
+	add(tb.block(t, tb.coinbase(testOutput{50, scriptB})))
+	for i, block := range blocks {
+		connect(block, uint64(i), stxos[i])
+	}
+
+	msg := serveCFHeaders(t, idx, 0, nil)
+	checkCFHeaders(t, "all headers", idx, msg, hash.ZeroHash, blocks)
+
+	// The next message continues the chain of the previous one.
+	first := serveCFHeaders(t, idx, 0, blocks[1].Hash())
+	checkCFHeaders(t, "first headers", idx, first, hash.ZeroHash, blocks[:2])
+	next := serveCFHeaders(t, idx, 2, blocks[3].Hash())
+	checkCFHeaders(t, "next headers", idx, next, *first.HeaderHashes[1],
+		blocks[2:4])
+
+	// The reorder connects the last blocks again in the new order, which
+	// chains their filter headers again.
+	for i := len(blocks) - 1; i >= 2; i-- {
+		disconnect(blocks[i], stxos[i])
+	}
+	reordered := []*types.SerializedBlock{blocks[0], blocks[1], blocks[4],
+		blocks[2], blocks[3]}
+	connect(blocks[4], 2, stxos[4])
+	connect(blocks[2], 3, stxos[2])
+	connect(blocks[3], 4, stxos[3])
+	msg = serveCFHeaders(t, idx, 2, nil)
+	checkCFHeaders(t, "reordered headers", idx, msg, *first.HeaderHashes[1],
+		reordered[2:])
+	header, err := idx.FilterHeaderByBlockHash(blocks[3].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.IsEqual(next.HeaderHashes[1]) {
+		t.Fatalf("Filter header of a reordered block wasn't chained again")
+	}
+}
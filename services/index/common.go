@@ -91,6 +91,25 @@ func isDeserializeErr(err error) bool {
 	return ok
 }
 
+// countSpentOutputs returns the number of outputs spent by the transactions of
+// the passed block.
+func countSpentOutputs(block *types.SerializedBlock) int {
+	// Exclude the coinbase transaction since it can't spend anything.
+	var numSpent int
+	for _, tx := range block.Transactions()[1:] {
+		numSpent += len(tx.Transaction().TxIn)
+	}
+	return numSpent
+}
+
+// isConnectedInvalid returns whether the passed block was connected with
+// invalid transactions, which the chain connects without the outputs they
+// spend.  A block only holding its coinbase spends nothing, so it is taken to
+// be valid.
+func isConnectedInvalid(block *types.SerializedBlock, stxos []blockchain.SpentTxOut) bool {
+	return len(stxos) == 0 && countSpentOutputs(block) > 0
+}
+
 // internalBucket is an abstraction over a database bucket.  It is used to make
 // the code easier to test since it allows mock objects in the tests to only
 // implement these functions instead of everything a database.Bucket supports.
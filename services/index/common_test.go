@@ -0,0 +1,146 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package index
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestDB creates a database in a temporary directory, which is removed
+// along with the database by the returned function.
+func newTestDB(t *testing.T) (database.DB, func()) {
+	dbPath, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.Create("ffldb", dbPath, params.PrivNetParams.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("Failed to create the database: %v", err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+}
+
+// testAddr returns the pay-to-pubkey-hash address of the privnet with the
+// passed hash byte and its public key script.
+func testAddr(t *testing.T, b byte) (types.Address, []byte) {
+	pkHash := make([]byte, 20)
+	pkHash[0] = b
+	addr, err := address.NewPubKeyHashAddress(pkHash, &params.PrivNetParams,
+		ecc.ECDSA_Secp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr, pkScript
+}
+
+// testOutput is an output paid by a test transaction.
+type testOutput struct {
+	amount   uint64
+	pkScript []byte
+}
+
+// testBlocks builds the test blocks, whose transactions spend the outputs of
+// the previous test blocks.
+type testBlocks struct {
+	built int64
+	// stxos are the spent outputs by outpoint of the outputs of the built
+	// transactions.
+	stxos map[types.TxOutPoint]blockchain.SpentTxOut
+}
+
+func newTestBlocks() *testBlocks {
+	return &testBlocks{stxos: make(map[types.TxOutPoint]blockchain.SpentTxOut)}
+}
+
+// coinbase returns a coinbase paying the outputs, which is unique to the
+// block it is built for.
+func (tb *testBlocks) coinbase(outs ...testOutput) *types.Transaction {
+	tx := types.NewTransaction()
+	tx.AddTxIn(&types.TxInput{
+		PreviousOut: *types.NewOutPoint(&hash.ZeroHash, math.MaxUint32),
+		Sequence:    types.MaxTxInSequenceNum,
+		SignScript:  []byte{byte(tb.built), byte(tb.built >> 8)},
+	})
+	for _, out := range outs {
+		tx.AddTxOut(types.NewTxOutput(out.amount, out.pkScript))
+	}
+	return tx
+}
+
+// outPoint returns the outpoint of the passed output of a transaction.
+func outPoint(tx *types.Transaction, i uint32) types.TxOutPoint {
+	txHash := tx.TxHash()
+	return *types.NewOutPoint(&txHash, i)
+}
+
+// spend returns a transaction spending the outpoints, which pays the outputs.
+func (tb *testBlocks) spend(prevOuts []types.TxOutPoint, outs ...testOutput) *types.Transaction {
+	tx := types.NewTransaction()
+	for i := range prevOuts {
+		tx.AddTxIn(&types.TxInput{
+			PreviousOut: prevOuts[i],
+			Sequence:    types.MaxTxInSequenceNum,
+			SignScript:  []byte{},
+		})
+	}
+	for _, out := range outs {
+		tx.AddTxOut(types.NewTxOutput(out.amount, out.pkScript))
+	}
+	return tx
+}
+
+// block returns a block holding the transactions, the first of which is its
+// coinbase, along with the outputs it spends.  The outputs it pays can be spent
+// by the blocks built after it.
+func (tb *testBlocks) block(t *testing.T, txs ...*types.Transaction) (*types.SerializedBlock, []blockchain.SpentTxOut) {
+	tb.built++
+	header := params.PrivNetParams.GenesisBlock.Header
+	header.Timestamp = header.Timestamp.Add(time.Duration(tb.built) *
+		time.Second)
+	block := types.NewBlock(&types.Block{Header: header, Transactions: txs})
+
+	var stxos []blockchain.SpentTxOut
+	for _, tx := range block.Transactions() {
+		msgTx := tx.Transaction()
+		if !msgTx.IsCoinBase() {
+			for _, txIn := range msgTx.TxIn {
+				stxo, ok := tb.stxos[txIn.PreviousOut]
+				if !ok {
+					t.Fatalf("Unknown output %v", txIn.PreviousOut)
+				}
+				stxos = append(stxos, stxo)
+			}
+		}
+		for i, txOut := range msgTx.TxOut {
+			tb.stxos[*types.NewOutPoint(tx.Hash(), uint32(i))] =
+				blockchain.SpentTxOut{
+					Amount:     txOut.Amount,
+					PkScript:   txOut.PkScript,
+					BlockHash:  *block.Hash(),
+					IsCoinBase: msgTx.IsCoinBase(),
+				}
+		}
+	}
+	return block, stxos
+}
@@ -83,8 +83,9 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 		if err := indexer.Init(); err != nil {
 			return err
 		}
-		if indexer.Name() == txIndexName {
-			indexer.(*TxIndex).chain = chain
+		switch indexer := indexer.(type) {
+		case *TxIndex:
+			indexer.chain = chain
 		}
 	}
 
@@ -183,6 +184,23 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 		return nil
 	}
 
+	// The indexes are caught up from the block data, which was deleted when
+	// the database was pruned.
+	var pruned bool
+	err = m.db.View(func(dbTx database.Tx) error {
+		var err error
+		pruned, err = dbTx.BeenPruned()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if pruned {
+		return fmt.Errorf("the indexes can't be caught up from order %d "+
+			"because the block data was pruned, the database must be "+
+			"rebuilt to enable them", lowestOrder+1)
+	}
+
 	// Create a progress logger for the indexing process below.
 	progressLogger := progresslog.NewBlockProgressLogger("Indexed", log.Root())
 
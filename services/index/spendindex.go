@@ -0,0 +1,252 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+)
+
+const (
+	// spendIndexName is the human-readable name for the index.
+	spendIndexName = "spend index"
+
+	// spendKeySize is the size of a serialized outpoint key.
+	spendKeySize = hash.HashSize + 4
+
+	// spendValueSize is the size of a serialized spend index entry.
+	spendValueSize = hash.HashSize + 4 + hash.HashSize
+)
+
+var (
+	// spendIndexKey is the key of the spend index and the db bucket used
+	// to house it.
+	spendIndexKey = []byte("spendidx")
+)
+
+// -----------------------------------------------------------------------------
+// The spend index consists of an entry for every output spent by the
+// transactions of the blocks connected to the chain, which maps the outpoint
+// to the transaction input spending it.
+//
+// The serialized format for the keys and values in the spend index bucket is:
+//
+//   <outpoint> = <spending txhash><input index><block hash>
+//
+//   Field           Type              Size
+//   txhash          hash.Hash    32 bytes
+//   output index    uint32            4 bytes
+//   -----
+//   Total: 36 bytes
+//
+//   Field           Type              Size
+//   spending txhash hash.Hash    32 bytes
+//   input index     uint32            4 bytes
+//   block hash      hash.Hash    32 bytes
+//   -----
+//   Total: 68 bytes
+// -----------------------------------------------------------------------------
+
+// SpendEntry describes the transaction input which spends an output.
+type SpendEntry struct {
+	TxHash    hash.Hash
+	InIndex   uint32
+	BlockHash hash.Hash
+}
+
+// spendKey returns the spend index key of the passed outpoint.
+func spendKey(outpoint *types.TxOutPoint) [spendKeySize]byte {
+	var key [spendKeySize]byte
+	copy(key[:], outpoint.Hash[:])
+	byteOrder.PutUint32(key[hash.HashSize:], outpoint.OutIndex)
+	return key
+}
+
+// dbPutSpendEntry uses an existing database transaction to store the input
+// spending the passed outpoint.
+func dbPutSpendEntry(bucket internalBucket, outpoint *types.TxOutPoint, entry *SpendEntry) error {
+	var serialized [spendValueSize]byte
+	copy(serialized[:], entry.TxHash[:])
+	byteOrder.PutUint32(serialized[hash.HashSize:], entry.InIndex)
+	copy(serialized[hash.HashSize+4:], entry.BlockHash[:])
+	key := spendKey(outpoint)
+	return bucket.Put(key[:], serialized[:])
+}
+
+// dbFetchSpendEntry uses an existing database transaction to fetch the input
+// spending the passed outpoint.  nil is returned when the outpoint isn't
+// spent by any indexed transaction.
+func dbFetchSpendEntry(dbTx database.Tx, outpoint *types.TxOutPoint) (*SpendEntry, error) {
+	key := spendKey(outpoint)
+	serialized := dbTx.Metadata().Bucket(spendIndexKey).Get(key[:])
+	if serialized == nil {
+		return nil, nil
+	}
+	if len(serialized) != spendValueSize {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt spend index entry for %v",
+				outpoint),
+		}
+	}
+	var entry SpendEntry
+	copy(entry.TxHash[:], serialized[:hash.HashSize])
+	entry.InIndex = byteOrder.Uint32(serialized[hash.HashSize:])
+	copy(entry.BlockHash[:], serialized[hash.HashSize+4:])
+	return &entry, nil
+}
+
+// SpendIndex implements a spent outpoint index, which maps every output spent
+// by the transactions in the chain to the input spending it.
+type SpendIndex struct {
+	db database.DB
+}
+
+// Ensure the SpendIndex type implements the Indexer interface.
+var _ Indexer = (*SpendIndex)(nil)
+
+// Ensure the SpendIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*SpendIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *SpendIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) Key() []byte {
+	return spendIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) Name() string {
+	return spendIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the spend
+// index.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(spendIndexKey)
+	return err
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer adds an entry for each output
+// spent by the transactions in the block.  The spent outputs are empty when
+// the transactions of the block weren't applied, and nothing is indexed then.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) ConnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	if len(stxos) == 0 {
+		return nil
+	}
+	bucket := dbTx.Metadata().Bucket(spendIndexKey)
+	index := 0
+	for _, tx := range block.Transactions() {
+		msgTx := tx.Transaction()
+		// Coinbases do not reference any inputs.
+		if msgTx.IsCoinBase() {
+			continue
+		}
+		for inIndex, txIn := range msgTx.TxIn {
+			if index >= len(stxos) {
+				return nil
+			}
+			index++
+			entry := SpendEntry{
+				TxHash:    *tx.Hash(),
+				InIndex:   uint32(inIndex),
+				BlockHash: *block.Hash(),
+			}
+			err := dbPutSpendEntry(bucket, &txIn.PreviousOut, &entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the entries of the
+// outputs spent by the transactions in the block.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) DisconnectBlock(dbTx database.Tx, block *types.SerializedBlock, stxos []blockchain.SpentTxOut) error {
+	if len(stxos) == 0 {
+		return nil
+	}
+	bucket := dbTx.Metadata().Bucket(spendIndexKey)
+	index := 0
+	for _, tx := range block.Transactions() {
+		msgTx := tx.Transaction()
+		if msgTx.IsCoinBase() {
+			continue
+		}
+		for _, txIn := range msgTx.TxIn {
+			if index >= len(stxos) {
+				return nil
+			}
+			index++
+			key := spendKey(&txIn.PreviousOut)
+			if err := bucket.Delete(key[:]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SpendingTx returns the transaction input which spends the passed outpoint
+// in the chain, or nil when the outpoint isn't spent by any indexed block.
+//
+// This function is safe for concurrent access.
+func (idx *SpendIndex) SpendingTx(outpoint *types.TxOutPoint) (*SpendEntry, error) {
+	var entry *SpendEntry
+	err := idx.db.View(func(dbTx database.Tx) error {
+		var err error
+		entry, err = dbFetchSpendEntry(dbTx, outpoint)
+		return err
+	})
+	return entry, err
+}
+
+// NewSpendIndex returns a new instance of an indexer that is used to create a
+// mapping of all spent outpoints to the inputs spending them.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewSpendIndex(db database.DB) *SpendIndex {
+	return &SpendIndex{db: db}
+}
+
+// DropSpendIndex drops the spend index from the provided database if it
+// exists.
+func DropSpendIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, spendIndexKey, spendIndexName, interrupt)
+}
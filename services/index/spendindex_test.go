@@ -0,0 +1,99 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package index
+
+import (
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	"testing"
+)
+
+// TestSpendIndex checks that the inputs of the connected blocks are indexed by
+// the outpoints they spend, that nothing is indexed or removed for the blocks
+// whose transactions weren't applied, and that the entries of the disconnected
+// blocks are removed.
+func TestSpendIndex(t *testing.T) {
+	db, remove := newTestDB(t)
+	defer remove()
+	idx := NewSpendIndex(db)
+	if err := db.Update(idx.Create); err != nil {
+		t.Fatalf("Failed to create the index: %v", err)
+	}
+	connect := func(block *types.SerializedBlock, stxos []blockchain.SpentTxOut) {
+		err := db.Update(func(dbTx database.Tx) error {
+			return idx.ConnectBlock(dbTx, block, stxos)
+		})
+		if err != nil {
+			t.Fatalf("Failed to connect block %s: %v", block.Hash(), err)
+		}
+	}
+	disconnect := func(block *types.SerializedBlock, stxos []blockchain.SpentTxOut) {
+		err := db.Update(func(dbTx database.Tx) error {
+			return idx.DisconnectBlock(dbTx, block, stxos)
+		})
+		if err != nil {
+			t.Fatalf("Failed to disconnect block %s: %v", block.Hash(), err)
+		}
+	}
+
+	// checkSpent fails the test unless the outpoints are spent by the
+	// inputs of the transaction in the block, in their order, or aren't
+	// spent when no transaction is passed.
+	checkSpent := func(name string, prevOuts []types.TxOutPoint,
+		tx *types.Transaction, block *types.SerializedBlock) {
+		for i := range prevOuts {
+			entry, err := idx.SpendingTx(&prevOuts[i])
+			if err != nil {
+				t.Fatalf("%s: failed to fetch the spend of %v: %v", name,
+					prevOuts[i], err)
+			}
+			if tx == nil {
+				if entry != nil {
+					t.Fatalf("%s: %v is spent by %v", name, prevOuts[i],
+						entry.TxHash)
+				}
+				continue
+			}
+			want := SpendEntry{
+				TxHash:    tx.TxHash(),
+				InIndex:   uint32(i),
+				BlockHash: *block.Hash(),
+			}
+			if entry == nil || *entry != want {
+				t.Fatalf("%s: got spend %+v of %v, want %+v", name, entry,
+					prevOuts[i], want)
+			}
+		}
+	}
+
+	_, scriptA := testAddr(t, 1)
+	_, scriptB := testAddr(t, 2)
+	tb := newTestBlocks()
+	cb := tb.coinbase(testOutput{100, scriptA}, testOutput{100, scriptB})
+	block1, stxos1 := tb.block(t, cb)
+	prevOuts := []types.TxOutPoint{outPoint(cb, 0), outPoint(cb, 1)}
+	spend := tb.spend(prevOuts, testOutput{190, scriptA})
+	block2, stxos2 := tb.block(t, tb.coinbase(testOutput{50, scriptB}), spend)
+	connect(block1, stxos1)
+	connect(block2, stxos2)
+	checkSpent("connected", prevOuts, spend, block2)
+	checkSpent("unspent", []types.TxOutPoint{outPoint(spend, 0)}, nil, nil)
+
+	// The invalid block double spending the coinbase has no spent outputs,
+	// so the spends of the valid block are left alone.
+	doubleSpend := tb.spend(prevOuts[:1], testOutput{90, scriptB})
+	invalid, _ := tb.block(t, tb.coinbase(testOutput{50, scriptA}),
+		doubleSpend)
+	connect(invalid, nil)
+	checkSpent("invalid block connected", prevOuts, spend, block2)
+	disconnect(invalid, nil)
+	checkSpent("invalid block disconnected", prevOuts, spend, block2)
+
+	disconnect(block2, stxos2)
+	checkSpent("disconnected", prevOuts, nil, nil)
+
+	// The outputs are spent again once the block is reconnected.
+	connect(block2, stxos2)
+	checkSpent("reconnected", prevOuts, spend, block2)
+}
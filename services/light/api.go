@@ -0,0 +1,122 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package light
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"strconv"
+)
+
+func (sm *SyncManager) API() rpc.API {
+	return rpc.API{
+		NameSpace: rpc.DefaultServiceNameSpace,
+		Service:   NewPublicLightAPI(sm),
+		Public:    true,
+	}
+}
+
+// PublicLightAPI provides the subset of the block RPCs which can be served
+// from the headers kept by a light node.
+type PublicLightAPI struct {
+	sm *SyncManager
+}
+
+func NewPublicLightAPI(sm *SyncManager) *PublicLightAPI {
+	return &PublicLightAPI{sm}
+}
+
+// Return the hash of the block with the given order
+func (api *PublicLightAPI) GetBlockhash(order uint) (string, error) {
+	blockHash := api.sm.chain.BlockDAG().GetBlockByOrder(order)
+	if blockHash == nil {
+		return "", fmt.Errorf("no block at order %d exists", order)
+	}
+	return blockHash.String(), nil
+}
+
+func (api *PublicLightAPI) GetBestBlockHash() (interface{}, error) {
+	return api.sm.chain.BlockDAG().GetMainChainTip().GetHash().String(), nil
+}
+
+func (api *PublicLightAPI) GetBlockCount() (interface{}, error) {
+	return api.sm.chain.GraphState().GetMainOrder() + 1, nil
+}
+
+func (api *PublicLightAPI) GetBlockTotal() (interface{}, error) {
+	return api.sm.chain.GraphState().GetTotal(), nil
+}
+
+// GetBlockHeader implements the getblockheader command.
+func (api *PublicLightAPI) GetBlockHeader(hash hash.Hash, verbose bool) (interface{}, error) {
+	blockHeader, err := api.sm.chain.HeaderByHash(&hash)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), fmt.Sprintf("Block not found: %v", hash))
+	}
+
+	// When the verbose flag isn't set, simply return the serialized block
+	// header as a hex-encoded string.
+	if !verbose {
+		var headerBuf bytes.Buffer
+		err := blockHeader.Serialize(&headerBuf)
+		if err != nil {
+			context := "Failed to serialize block header"
+			return nil, rpc.RpcInternalError(err.Error(), context)
+		}
+		return hex.EncodeToString(headerBuf.Bytes()), nil
+	}
+	bd := api.sm.chain.BlockDAG()
+	blockHeaderReply := json.GetBlockHeaderVerboseResult{
+		Hash:          hash.String(),
+		Confirmations: int64(bd.GetConfirmations(&hash)),
+		Version:       int32(blockHeader.Version),
+		ParentRoot:    blockHeader.ParentRoot.String(),
+		TxRoot:        blockHeader.TxRoot.String(),
+		StateRoot:     blockHeader.StateRoot.String(),
+		Difficulty:    blockHeader.Difficulty,
+		Layer:         uint32(bd.GetLayer(&hash)),
+		Time:          blockHeader.Timestamp.Unix(),
+		PowResult:     blockHeader.Pow.GetPowResult(),
+	}
+	return blockHeaderReply, nil
+}
+
+// Query whether a given block is on the main chain.
+// Note that some DAG protocols may not support this feature.
+func (api *PublicLightAPI) IsOnMainChain(h hash.Hash) (interface{}, error) {
+	if !api.sm.chain.HaveHeader(&h) {
+		return nil, rpc.RpcInternalError(fmt.Errorf("no block").Error(), fmt.Sprintf("Block not found: %v", h))
+	}
+	isOn := api.sm.chain.BlockDAG().IsOnMainChain(&h)
+
+	return strconv.FormatBool(isOn), nil
+}
+
+// Return the current height of DAG main chain
+func (api *PublicLightAPI) GetMainChainHeight() (interface{}, error) {
+	return strconv.FormatUint(uint64(api.sm.chain.BlockDAG().GetMainChainTip().GetHeight()), 10), nil
+}
+
+// IsBlue:0:not blue;  1：blue  2：Cannot confirm
+func (api *PublicLightAPI) IsBlue(h hash.Hash) (interface{}, error) {
+	bd := api.sm.chain.BlockDAG()
+	if bd.GetBlock(&h) == nil {
+		return 2, rpc.RpcInternalError(fmt.Errorf("no block").Error(), fmt.Sprintf("Block not found: %s", h.String()))
+	}
+	if bd.GetConfirmations(&h) == 0 {
+		return 2, nil
+	}
+	if bd.IsBlue(&h) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Return whether the light node has all the headers known to its peers
+func (api *PublicLightAPI) IsCurrent() (interface{}, error) {
+	return api.sm.IsCurrent(), nil
+}
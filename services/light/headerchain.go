@@ -0,0 +1,322 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package light
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	s "github.com/Qitmeer/qitmeer/core/serialization"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/database"
+	"github.com/Qitmeer/qitmeer/params"
+	"io"
+	"sync"
+)
+
+var (
+	// headerBucketName is the name of the db bucket used to house the
+	// block hash -> header and parents mapping of the light node.
+	headerBucketName = []byte("lightheaderidx")
+
+	// headerSeqBucketName is the name of the db bucket used to house the
+	// sequence -> block hash mapping of the light node.  The sequence is
+	// the order the headers were connected in, so the block DAG can be
+	// rebuilt in the same way on startup.
+	headerSeqBucketName = []byte("lightheaderseqidx")
+
+	// byteOrder is the preferred byte order used for serializing numeric
+	// fields for storage in the database.
+	byteOrder = binary.BigEndian
+)
+
+// -----------------------------------------------------------------------------
+// The light node keeps no blocks.  Every connected header is stored together
+// with the ordered parent hashes of its block, which are needed to connect the
+// header to the block DAG and are committed to by the parent root of the
+// header.
+//
+// The serialized format for keys and values in the header bucket is:
+//   <hash> = <header><N><parent hashes>
+//
+//   Field           Type              Size
+//   hash            hash.Hash         32 bytes
+//   header          types.BlockHeader variable
+//   N               VLQ               variable
+//   parent hashes   []hash.Hash       N * 32 bytes
+//
+// The serialized format for keys and values in the sequence bucket is:
+//   <seq> = <hash>
+//
+//   Field           Type              Size
+//   seq             uint32            4 bytes
+//   hash            hash.Hash         32 bytes
+//   -----
+//   Total: 36 bytes
+// -----------------------------------------------------------------------------
+
+// headerNode represents a header connected to the block DAG of the light
+// node.  It implements the blockdag.IBlockData interface.
+type headerNode struct {
+	hash    hash.Hash
+	header  *types.BlockHeader
+	parents []*hash.Hash
+}
+
+// GetHash returns the hash of the block.
+func (node *headerNode) GetHash() *hash.Hash {
+	return &node.hash
+}
+
+// GetParents returns the ordered parent hashes of the block.
+func (node *headerNode) GetParents() []*hash.Hash {
+	return node.parents
+}
+
+// GetTimestamp returns the timestamp of the block.
+func (node *headerNode) GetTimestamp() int64 {
+	return node.header.Timestamp.Unix()
+}
+
+// GetWeight returns the weight of the block based on its proof of work.
+func (node *headerNode) GetWeight() uint64 {
+	return uint64(pow.CalcWork(node.header.Difficulty, node.header.Pow.GetPowType()).BitLen())
+}
+
+// serializeHeaderEntry returns the serialized header entry of a node.
+func serializeHeaderEntry(node *headerNode) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := node.header.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	err := s.WriteVarInt(&buf, protocol.ProtocolVersion, uint64(len(node.parents)))
+	if err != nil {
+		return nil, err
+	}
+	for _, parent := range node.parents {
+		if _, err := buf.Write(parent[:]); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// deserializeHeaderEntry decodes a header entry of the block with the given
+// hash.
+func deserializeHeaderEntry(h *hash.Hash, serialized []byte) (*headerNode, error) {
+	r := bytes.NewReader(serialized)
+	var header types.BlockHeader
+	if err := header.Deserialize(r); err != nil {
+		return nil, err
+	}
+	count, err := s.ReadVarInt(r, protocol.ProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	if count > types.MaxParentsPerBlock {
+		return nil, fmt.Errorf("corrupt header entry for %v: %d parents", h, count)
+	}
+	parents := make([]*hash.Hash, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var parent hash.Hash
+		if _, err := io.ReadFull(r, parent[:]); err != nil {
+			return nil, err
+		}
+		parents = append(parents, &parent)
+	}
+	return &headerNode{hash: *h, header: &header, parents: parents}, nil
+}
+
+// dbFetchHeaderNode retrieves the header entry of the block with the given
+// hash.  A nil node is returned when there is no entry for the hash.
+func dbFetchHeaderNode(dbTx database.Tx, h *hash.Hash) (*headerNode, error) {
+	serialized := dbTx.Metadata().Bucket(headerBucketName).Get(h[:])
+	if serialized == nil {
+		return nil, nil
+	}
+	return deserializeHeaderEntry(h, serialized)
+}
+
+// dbFetchHashBySeq returns the hash of the header connected with the given
+// sequence or nil when there is none.
+func dbFetchHashBySeq(dbTx database.Tx, seq uint32) *hash.Hash {
+	var serializedSeq [4]byte
+	byteOrder.PutUint32(serializedSeq[:], seq)
+	serializedHash := dbTx.Metadata().Bucket(headerSeqBucketName).Get(serializedSeq[:])
+	if serializedHash == nil {
+		return nil
+	}
+	var h hash.Hash
+	copy(h[:], serializedHash)
+	return &h
+}
+
+// dbPutHeaderNode stores the header entry of a node with the given sequence.
+func dbPutHeaderNode(dbTx database.Tx, seq uint32, node *headerNode) error {
+	serialized, err := serializeHeaderEntry(node)
+	if err != nil {
+		return err
+	}
+	meta := dbTx.Metadata()
+	if err := meta.Bucket(headerBucketName).Put(node.hash[:], serialized); err != nil {
+		return err
+	}
+	var serializedSeq [4]byte
+	byteOrder.PutUint32(serializedSeq[:], seq)
+	return meta.Bucket(headerSeqBucketName).Put(serializedSeq[:], node.hash[:])
+}
+
+// HeaderChain keeps the headers of all blocks known to the light node and the
+// block DAG built from them.  Headers are only connected after their proof of
+// work and their parents have been verified.
+type HeaderChain struct {
+	db          database.DB
+	params      *params.Params
+	timeSource  blockchain.MedianTimeSource
+	bd          *blockdag.BlockDAG
+	lock        sync.RWMutex
+	headerTotal uint32
+}
+
+// NewHeaderChain returns a header chain which loads the headers stored in the
+// database into a new block DAG of the given type.  The genesis header of the
+// network is stored when the database has no headers yet.
+func NewHeaderChain(db database.DB, dagType string, timeSource blockchain.MedianTimeSource,
+	par *params.Params) (*HeaderChain, error) {
+	subsidyCache := blockchain.NewSubsidyCache(0, par)
+	hc := HeaderChain{
+		db:         db,
+		params:     par,
+		timeSource: timeSource,
+		bd:         &blockdag.BlockDAG{},
+	}
+	hc.bd.Init(dagType, subsidyCache.CalcBlockSubsidy, db)
+
+	err := db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if _, err := meta.CreateBucketIfNotExists(headerBucketName); err != nil {
+			return err
+		}
+		if _, err := meta.CreateBucketIfNotExists(headerSeqBucketName); err != nil {
+			return err
+		}
+
+		// Rebuild the block DAG from the stored headers.
+		for seq := uint32(0); ; seq++ {
+			h := dbFetchHashBySeq(dbTx, seq)
+			if h == nil {
+				break
+			}
+			node, err := dbFetchHeaderNode(dbTx, h)
+			if err != nil {
+				return err
+			}
+			if node == nil {
+				return fmt.Errorf("missing header entry for %v", h)
+			}
+			hc.bd.AddBlock(node)
+			if !hc.bd.HasBlock(h) {
+				return fmt.Errorf("unable to connect stored header %v", h)
+			}
+			hc.headerTotal++
+		}
+		if hc.headerTotal > 0 {
+			return nil
+		}
+
+		genesis := &headerNode{
+			hash:   *par.GenesisHash,
+			header: &par.GenesisBlock.Header,
+		}
+		hc.bd.AddBlock(genesis)
+		hc.headerTotal++
+		return dbPutHeaderNode(dbTx, 0, genesis)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info(fmt.Sprintf("Loaded %d block headers", hc.headerTotal))
+	return &hc, nil
+}
+
+// BlockDAG returns the block DAG built from the headers.
+func (hc *HeaderChain) BlockDAG() *blockdag.BlockDAG {
+	return hc.bd
+}
+
+// GraphState returns the current graph state of the block DAG.
+func (hc *HeaderChain) GraphState() *blockdag.GraphState {
+	return hc.bd.GetGraphState()
+}
+
+// HaveHeader returns whether or not the header of the block with the given
+// hash is connected.
+func (hc *HeaderChain) HaveHeader(h *hash.Hash) bool {
+	return hc.bd.HasBlock(h)
+}
+
+// HeaderByHash returns the header of the block with the given hash.
+//
+// This function is safe for concurrent access.
+func (hc *HeaderChain) HeaderByHash(h *hash.Hash) (*types.BlockHeader, error) {
+	var node *headerNode
+	err := hc.db.View(func(dbTx database.Tx) error {
+		var err error
+		node, err = dbFetchHeaderNode(dbTx, h)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fmt.Errorf("block %s is not known", h)
+	}
+	return node.header, nil
+}
+
+// ProcessHeader verifies a header with the ordered parent hashes of its block
+// and connects it to the block DAG.  It returns whether or not the header was
+// connected.  Headers which are already known are ignored and headers with
+// unknown parents are rejected with ErrMissingParent, since peers send
+// headers in topological order.
+//
+// This function is safe for concurrent access.
+func (hc *HeaderChain) ProcessHeader(header *types.BlockHeader, parents []*hash.Hash) (bool, error) {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	h := header.BlockHash()
+	if hc.bd.HasBlock(&h) {
+		return false, nil
+	}
+	if err := blockchain.CheckHeaderSanity(header, parents, hc.timeSource, hc.params); err != nil {
+		return false, err
+	}
+	for _, parent := range parents {
+		if !hc.bd.HasBlock(parent) {
+			str := fmt.Sprintf("parent %v of header %v is not known",
+				parent, h)
+			return false, blockchain.RuleError{ErrorCode: blockchain.ErrMissingParent, Description: str}
+		}
+	}
+
+	node := &headerNode{hash: h, header: header, parents: parents}
+	hc.bd.AddBlock(node)
+	if !hc.bd.HasBlock(&h) {
+		str := fmt.Sprintf("header %v can not be connected to the block DAG", h)
+		return false, blockchain.RuleError{ErrorCode: blockchain.ErrInvalidAncestorBlock, Description: str}
+	}
+	err := hc.db.Update(func(dbTx database.Tx) error {
+		return dbPutHeaderNode(dbTx, hc.headerTotal, node)
+	})
+	if err != nil {
+		return false, err
+	}
+	hc.headerTotal++
+	return true, nil
+}
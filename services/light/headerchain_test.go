@@ -0,0 +1,200 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package light
+
+import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/merkle"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/params"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// testHeaders builds the privnet headers, which solve their proof of work.
+type testHeaders struct {
+	built int64
+}
+
+// header returns a header whose parent root commits to the parents, and
+// which solves its proof of work.
+func (th *testHeaders) header(t *testing.T, parents []*hash.Hash) *types.BlockHeader {
+	th.built++
+	paMerkles := merkle.BuildParentsMerkleTreeStore(parents)
+	header := &types.BlockHeader{
+		Version:    8,
+		ParentRoot: *paMerkles[len(paMerkles)-1],
+		Timestamp: params.PrivNetParams.GenesisBlock.Header.Timestamp.Add(
+			time.Duration(th.built) * time.Second),
+		Difficulty: params.PrivNetParams.PowConfig.Blake2bdPowLimitBits,
+	}
+	for nonce := uint32(0); nonce < 1000; nonce++ {
+		header.Pow = pow.GetInstance(pow.BLAKE2BD, nonce, []byte{})
+		header.Pow.SetParams(params.PrivNetParams.PowConfig)
+		err := header.Pow.Verify(header.BlockData(), header.BlockHash(),
+			header.Difficulty)
+		if err == nil {
+			return header
+		}
+	}
+	t.Fatalf("No nonce solves the header")
+	return nil
+}
+
+// newTestHeaderChain creates a header chain on a new privnet database, whose
+// directory is removed by the returned function once the database is closed.
+func newTestHeaderChain(t *testing.T) (*HeaderChain, string, func()) {
+	dbPath, err := ioutil.TempDir("", "light")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.Create("ffldb", dbPath, params.PrivNetParams.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("Failed to create the database: %v", err)
+	}
+	hc, err := NewHeaderChain(db, "phantom", blockchain.NewMedianTime(),
+		&params.PrivNetParams)
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dbPath)
+		t.Fatalf("Failed to create the header chain: %v", err)
+	}
+	return hc, dbPath, func() {
+		os.RemoveAll(dbPath)
+	}
+}
+
+// checkRuleError fails the test unless the error is a rule error with the
+// passed code.
+func checkRuleError(t *testing.T, name string, err error, code blockchain.ErrorCode) {
+	rerr, ok := err.(blockchain.RuleError)
+	if !ok || rerr.ErrorCode != code {
+		t.Errorf("%s: got error %v, want %v", name, err, code)
+	}
+}
+
+// TestProcessHeader checks that the header chain connects the headers whose
+// parents are known and match their parent root, and that it loads them
+// again on restart.
+func TestProcessHeader(t *testing.T) {
+	hc, dbPath, remove := newTestHeaderChain(t)
+	defer remove()
+	defer func() {
+		hc.db.Close()
+	}()
+	genesis := params.PrivNetParams.GenesisHash
+	th := &testHeaders{}
+
+	header1 := th.header(t, []*hash.Hash{genesis})
+	h1 := header1.BlockHash()
+	connected, err := hc.ProcessHeader(header1, []*hash.Hash{genesis})
+	if err != nil || !connected {
+		t.Fatalf("Valid header: got connected %v, error %v", connected, err)
+	}
+	if !hc.HaveHeader(&h1) {
+		t.Fatalf("Connected header isn't known")
+	}
+	stored, err := hc.HeaderByHash(&h1)
+	if err != nil || stored.BlockHash() != h1 {
+		t.Fatalf("Connected header wasn't stored: %v", err)
+	}
+
+	// A known header is ignored.
+	connected, err = hc.ProcessHeader(header1, []*hash.Hash{genesis})
+	if err != nil || connected {
+		t.Fatalf("Known header: got connected %v, error %v", connected, err)
+	}
+
+	unknown := hash.DoubleHashH([]byte("unknown"))
+	header2 := th.header(t, []*hash.Hash{&h1})
+	unsolved := *th.header(t, []*hash.Hash{&h1})
+	unsolved.Difficulty = 0x1d00ffff
+	tests := []struct {
+		name    string
+		header  *types.BlockHeader
+		parents []*hash.Hash
+		code    blockchain.ErrorCode
+	}{
+		{
+			name:    "missing parent",
+			header:  th.header(t, []*hash.Hash{&h1, &unknown}),
+			parents: []*hash.Hash{&h1, &unknown},
+			code:    blockchain.ErrMissingParent,
+		},
+		{
+			name:    "parents not committed to",
+			header:  header2,
+			parents: []*hash.Hash{genesis},
+			code:    blockchain.ErrBadParentsMerkleRoot,
+		},
+		{
+			name:    "no parents",
+			header:  th.header(t, nil),
+			parents: nil,
+			code:    blockchain.ErrNoParents,
+		},
+		{
+			name:    "duplicate parent",
+			header:  th.header(t, []*hash.Hash{&h1, &h1}),
+			parents: []*hash.Hash{&h1, &h1},
+			code:    blockchain.ErrDuplicateParent,
+		},
+	}
+	for _, test := range tests {
+		connected, err := hc.ProcessHeader(test.header, test.parents)
+		if connected {
+			t.Errorf("%s: header was connected", test.name)
+		}
+		checkRuleError(t, test.name, err, test.code)
+		h := test.header.BlockHash()
+		if hc.HaveHeader(&h) {
+			t.Errorf("%s: rejected header is known", test.name)
+		}
+	}
+
+	// A header which doesn't solve its proof of work is rejected.
+	connected, err = hc.ProcessHeader(&unsolved, []*hash.Hash{&h1})
+	if err == nil || connected {
+		t.Errorf("Header not solving its proof of work: got connected %v, "+
+			"error %v", connected, err)
+	}
+
+	// The header rejected with the wrong parents is connected with the
+	// parents it commits to.
+	connected, err = hc.ProcessHeader(header2, []*hash.Hash{&h1})
+	if err != nil || !connected {
+		t.Fatalf("Header on connected header: got connected %v, error %v",
+			connected, err)
+	}
+	gs := hc.GraphState()
+	if gs.GetTotal() != 3 || gs.GetMainHeight() != 2 {
+		t.Fatalf("Got graph state %s, want 3 blocks of main height 2", gs)
+	}
+
+	// The headers are loaded in the order they were connected on restart.
+	hc.db.Close()
+	db, err := database.Open("ffldb", dbPath, params.PrivNetParams.Net)
+	if err != nil {
+		t.Fatalf("Failed to open the database: %v", err)
+	}
+	hc, err = NewHeaderChain(db, "phantom", blockchain.NewMedianTime(),
+		&params.PrivNetParams)
+	if err != nil {
+		db.Close()
+		t.Fatalf("Failed to load the header chain: %v", err)
+	}
+	if !hc.GraphState().IsEqual(gs) {
+		t.Fatalf("Got graph state %s on restart, want %s", hc.GraphState(), gs)
+	}
+	h2 := header2.BlockHash()
+	if !hc.HaveHeader(&h2) {
+		t.Fatalf("Connected header wasn't loaded on restart")
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package light
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/protocol"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"sync"
+	"sync/atomic"
+)
+
+// maxHeaderRetries is the number of headers messages in a row which do not
+// connect to the header chain a peer may send before it is disconnected.
+const maxHeaderRetries = 3
+
+// SyncManager downloads the headers of the block DAG from full node peers
+// and connects them to the header chain of the light node.
+type SyncManager struct {
+	started  int32
+	shutdown int32
+
+	chain *HeaderChain
+
+	peers    map[*peer.Peer]*peer.ServerPeer
+	syncPeer *peer.ServerPeer
+	msgChan  chan interface{}
+
+	// headerRetries is the number of headers messages in a row of each
+	// peer which did not connect to the header chain.
+	headerRetries map[*peer.Peer]int
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// NewSyncManager returns a new light node sync manager.
+// Use Start to begin processing asynchronous header and inv updates.
+func NewSyncManager(chain *HeaderChain, maxPeers int) *SyncManager {
+	return &SyncManager{
+		chain:         chain,
+		peers:         make(map[*peer.Peer]*peer.ServerPeer),
+		msgChan:       make(chan interface{}, maxPeers*3),
+		headerRetries: make(map[*peer.Peer]int),
+		quit:          make(chan struct{}),
+	}
+}
+
+// Chain returns the header chain synced by the manager.
+func (sm *SyncManager) Chain() *HeaderChain {
+	return sm.chain
+}
+
+// Start begins the core sync handler which processes header and inv
+// messages.
+func (sm *SyncManager) Start() {
+	// Already started?
+	if atomic.AddInt32(&sm.started, 1) != 1 {
+		return
+	}
+
+	log.Trace("Starting light sync manager")
+	sm.wg.Add(1)
+	go sm.syncHandler()
+}
+
+// Stop gracefully shuts down the sync manager by stopping all asynchronous
+// handlers and waiting for them to finish.
+func (sm *SyncManager) Stop() error {
+	if atomic.AddInt32(&sm.shutdown, 1) != 1 {
+		log.Warn("Light sync manager is already in the process of " +
+			"shutting down")
+		return nil
+	}
+	log.Info("Light sync manager shutting down")
+	close(sm.quit)
+	sm.wg.Wait()
+	return nil
+}
+
+// syncHandler is the main handler for the sync manager.  It must be run as a
+// goroutine.  It processes header and inv messages in a separate goroutine
+// from the peer handlers so the headers are connected in the order they
+// arrive.
+func (sm *SyncManager) syncHandler() {
+out:
+	for {
+		select {
+		case m := <-sm.msgChan:
+			switch msg := m.(type) {
+			case *newPeerMsg:
+				sm.handleNewPeerMsg(msg.peer)
+			case *headersMsg:
+				sm.handleHeadersMsg(msg)
+			case *invMsg:
+				sm.handleInvMsg(msg)
+			case *donePeerMsg:
+				sm.handleDonePeerMsg(msg.peer)
+			case isCurrentMsg:
+				msg.reply <- sm.current()
+			default:
+				log.Warn(fmt.Sprintf("Invalid message type in light "+
+					"sync handler: %T", msg))
+			}
+
+		case <-sm.quit:
+			break out
+		}
+	}
+
+	sm.wg.Done()
+	log.Trace("Light sync handler done")
+}
+
+// isSyncCandidate returns whether or not the peer is a candidate to consider
+// syncing from.  Only full nodes which send the parents with the headers are
+// able to serve the light node.
+func (sm *SyncManager) isSyncCandidate(sp *peer.ServerPeer) bool {
+	return sp.Services()&protocol.Full == protocol.Full &&
+		sp.ProtocolVersion() >= protocol.HeaderParentsVersion
+}
+
+// current returns whether or not the light node believes it has all the
+// headers known to its sync peer.
+func (sm *SyncManager) current() bool {
+	if sm.syncPeer == nil {
+		return true
+	}
+	return !sm.syncPeer.LastGS().IsExcellent(sm.chain.GraphState())
+}
+
+// startSync chooses the best candidate among the available peers and requests
+// the missing headers from it.  When syncing is already running, it simply
+// returns.
+func (sm *SyncManager) startSync() {
+	if sm.syncPeer != nil {
+		return
+	}
+
+	gs := sm.chain.GraphState()
+	var bestPeer *peer.ServerPeer
+	for _, sp := range sm.peers {
+		if !sp.SyncCandidate || !sp.LastGS().IsExcellent(gs) {
+			continue
+		}
+		if bestPeer == nil || sp.LastGS().IsExcellent(bestPeer.LastGS()) {
+			bestPeer = sp
+		}
+	}
+	if bestPeer == nil {
+		log.Trace("No sync peer candidates available")
+		return
+	}
+
+	log.Info(fmt.Sprintf("Syncing headers to state %s from peer %s cur graph state:%s",
+		bestPeer.LastGS().String(), bestPeer.Addr(), gs.String()))
+	sm.syncPeer = bestPeer
+	sm.requestHeaders(bestPeer)
+}
+
+// requestHeaders asks the peer for the headers of all blocks which are not in
+// the graph state of the light node.
+func (sm *SyncManager) requestHeaders(sp *peer.ServerPeer) {
+	err := sp.PushGetHeadersMsg(sm.chain.GraphState(), nil)
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to push getheadermsg for the "+
+			"latest GS: %v", err))
+	}
+}
+
+// handleNewPeerMsg deals with new peers that have signalled they may be
+// considered as a sync peer.  It also starts syncing if needed.
+func (sm *SyncManager) handleNewPeerMsg(sp *peer.ServerPeer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	log.Info(fmt.Sprintf("New valid peer: %s,user-agent:%s", sp, sp.UserAgent()))
+	sp.SyncCandidate = sm.isSyncCandidate(sp)
+	sm.peers[sp.Peer] = sp
+	if sp.SyncCandidate {
+		sm.startSync()
+	}
+}
+
+// handleDonePeerMsg deals with peers that have signalled they are done.  In
+// the case where it was the current sync peer, it attempts to select a new
+// one.
+func (sm *SyncManager) handleDonePeerMsg(sp *peer.ServerPeer) {
+	if _, exists := sm.peers[sp.Peer]; !exists {
+		log.Warn(fmt.Sprintf("Received done peer message for unknown peer %s", sp))
+		return
+	}
+	delete(sm.peers, sp.Peer)
+	delete(sm.headerRetries, sp.Peer)
+	log.Info("Lost peer", "peer", sp)
+
+	if sm.syncPeer == sp {
+		sm.syncPeer = nil
+		sm.startSync()
+	}
+}
+
+// handleHeadersMsg connects the headers sent by a peer to the header chain.
+// Peers which send invalid headers, or keep sending headers which do not
+// connect to the header chain, are disconnected.
+func (sm *SyncManager) handleHeadersMsg(hmsg *headersMsg) {
+	sp := hmsg.peer
+	if _, exists := sm.peers[sp.Peer]; !exists {
+		log.Warn(fmt.Sprintf("Received headers message from unknown peer %s", sp))
+		return
+	}
+	msg := hmsg.headers
+	if msg.GS != nil {
+		sp.UpdateLastGS(msg.GS)
+	}
+
+	var connected int
+	var missingParent bool
+	for i, header := range msg.Headers {
+		if i >= len(msg.Parents) || len(msg.Parents[i]) == 0 {
+			log.Debug(fmt.Sprintf("Peer %s sent headers without parents", sp))
+			break
+		}
+		isNew, err := sm.chain.ProcessHeader(header, msg.Parents[i])
+		if err != nil {
+			if rerr, ok := err.(blockchain.RuleError); ok &&
+				rerr.ErrorCode == blockchain.ErrMissingParent {
+				// The headers do not connect to the known
+				// DAG, so request them again below.
+				log.Debug(fmt.Sprintf("Headers from peer %s do not connect: %v", sp, err))
+				missingParent = true
+				break
+			}
+			log.Info(fmt.Sprintf("Rejected header %v from %s: %v",
+				header.BlockHash(), sp, err))
+			sp.Disconnect()
+			return
+		}
+		if isNew {
+			connected++
+		}
+	}
+	if connected > 0 {
+		log.Debug(fmt.Sprintf("Connected %d headers from %s, graph state:%s",
+			connected, sp, sm.chain.GraphState().String()))
+		delete(sm.headerRetries, sp.Peer)
+	} else if missingParent {
+		sm.headerRetries[sp.Peer]++
+		if sm.headerRetries[sp.Peer] > maxHeaderRetries {
+			log.Info(fmt.Sprintf("Peer %s keeps sending headers which "+
+				"do not connect -- disconnecting", sp))
+			sp.SyncCandidate = false
+			if sm.syncPeer == sp {
+				sm.syncPeer = nil
+				sm.startSync()
+			}
+			sp.Disconnect()
+			return
+		}
+	}
+
+	if sp != sm.syncPeer {
+		if sm.syncPeer == nil && sp.SyncCandidate {
+			sm.startSync()
+		}
+		return
+	}
+	// Keep asking the sync peer while it knows more blocks.
+	if sp.LastGS().IsExcellent(sm.chain.GraphState()) {
+		sm.requestHeaders(sp)
+		return
+	}
+	log.Info(fmt.Sprintf("Headers synced to graph state %s",
+		sm.chain.GraphState().String()))
+	sm.syncPeer = nil
+}
+
+// handleInvMsg requests the headers of blocks announced by a peer which are
+// not known yet.
+func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
+	sp := imsg.peer
+	if _, exists := sm.peers[sp.Peer]; !exists {
+		log.Warn(fmt.Sprintf("Received inv message from unknown peer %s", sp))
+		return
+	}
+	if imsg.inv.GS != nil {
+		sp.UpdateLastGS(imsg.inv.GS)
+	}
+	for _, iv := range imsg.inv.InvList {
+		if iv.Type != message.InvTypeBlock {
+			continue
+		}
+		sp.AddKnownInventory(iv)
+		if sm.chain.HaveHeader(&iv.Hash) {
+			continue
+		}
+		if sm.syncPeer == nil && sp.SyncCandidate {
+			sm.syncPeer = sp
+		}
+		if sm.syncPeer != nil {
+			sm.requestHeaders(sm.syncPeer)
+		}
+		return
+	}
+}
+
+// newPeerMsg signifies a newly connected peer to the sync handler.
+type newPeerMsg struct {
+	peer *peer.ServerPeer
+}
+
+// NewPeer informs the sync manager of a newly active peer.
+func (sm *SyncManager) NewPeer(sp *peer.ServerPeer) {
+	// Ignore if we are shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+	sm.msgChan <- &newPeerMsg{peer: sp}
+}
+
+// donePeerMsg signifies a newly disconnected peer to the sync handler.
+type donePeerMsg struct {
+	peer *peer.ServerPeer
+}
+
+// DonePeer informs the sync manager that a peer has disconnected.
+func (sm *SyncManager) DonePeer(sp *peer.ServerPeer) {
+	// Ignore if we are shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+	sm.msgChan <- &donePeerMsg{peer: sp}
+}
+
+// headersMsg packages a headers message and the peer it came from together
+// so the sync handler has access to that information.
+type headersMsg struct {
+	headers *message.MsgHeaders
+	peer    *peer.ServerPeer
+}
+
+// QueueHeaders adds the passed headers message and peer to the sync handling
+// queue.
+func (sm *SyncManager) QueueHeaders(headers *message.MsgHeaders, sp *peer.ServerPeer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+	sm.msgChan <- &headersMsg{headers: headers, peer: sp}
+}
+
+// invMsg packages an inv message and the peer it came from together so the
+// sync handler has access to that information.
+type invMsg struct {
+	inv  *message.MsgInv
+	peer *peer.ServerPeer
+}
+
+// QueueInv adds the passed inv message and peer to the sync handling queue.
+func (sm *SyncManager) QueueInv(inv *message.MsgInv, sp *peer.ServerPeer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+	sm.msgChan <- &invMsg{inv: inv, peer: sp}
+}
+
+// isCurrentMsg is a message type to be sent across the message channel for
+// requesting whether or not the sync manager believes it is synced with the
+// connected peers.
+type isCurrentMsg struct {
+	reply chan bool
+}
+
+// IsCurrent returns whether or not the sync manager believes it has all the
+// headers known to the connected peers.  It returns false once the sync
+// manager is stopped.
+func (sm *SyncManager) IsCurrent() bool {
+	reply := make(chan bool, 1)
+	select {
+	case sm.msgChan <- isCurrentMsg{reply: reply}:
+	case <-sm.quit:
+		return false
+	}
+	select {
+	case current := <-reply:
+		return current
+	case <-sm.quit:
+		return false
+	}
+}
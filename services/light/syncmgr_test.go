@@ -0,0 +1,130 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package light
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/p2p/peer"
+	"github.com/Qitmeer/qitmeer/params"
+	"testing"
+	"time"
+)
+
+// newTestSyncPeer returns a sync candidate which isn't connected, so the
+// messages queued to it are dropped, and which knows more blocks than the
+// light node.
+func newTestSyncPeer(t *testing.T, sm *SyncManager, port int) *peer.ServerPeer {
+	p, err := peer.NewOutboundPeer(&peer.Config{
+		ChainParams: &params.PrivNetParams,
+	}, fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := &peer.ServerPeer{
+		Peer:            p,
+		RequestedBlocks: make(map[hash.Hash]struct{}),
+		RequestedTxns:   make(map[hash.Hash]struct{}),
+		SyncCandidate:   true,
+	}
+	gs := blockdag.NewGraphState()
+	gs.SetMainOrder(100)
+	sp.UpdateLastGS(gs)
+	sm.peers[sp.Peer] = sp
+	return sp
+}
+
+// TestHeadersRetryBound checks that a sync peer which keeps sending headers
+// that do not connect to the header chain is only asked for them again a
+// limited number of times, and is replaced by another sync candidate.
+func TestHeadersRetryBound(t *testing.T) {
+	hc, _, remove := newTestHeaderChain(t)
+	defer remove()
+	defer func() {
+		hc.db.Close()
+	}()
+	sm := NewSyncManager(hc, 8)
+	sp := newTestSyncPeer(t, sm, 18230)
+	other := newTestSyncPeer(t, sm, 18231)
+	sm.syncPeer = sp
+	th := &testHeaders{}
+
+	unknown := hash.DoubleHashH([]byte("unknown"))
+	orphanMsg := message.NewMsgHeaders(sp.LastGS())
+	orphanMsg.AddBlockHeaderWithParents(th.header(t,
+		[]*hash.Hash{&unknown}), []*hash.Hash{&unknown})
+
+	// The retries are counted until the peer sends headers which connect.
+	for i := 0; i < maxHeaderRetries; i++ {
+		sm.handleHeadersMsg(&headersMsg{headers: orphanMsg, peer: sp})
+	}
+	if sm.headerRetries[sp.Peer] != maxHeaderRetries || sm.syncPeer != sp {
+		t.Fatalf("Got %d retries of sync peer %v, want %d of %v",
+			sm.headerRetries[sp.Peer], sm.syncPeer, maxHeaderRetries, sp)
+	}
+	genesis := params.PrivNetParams.GenesisHash
+	connectingMsg := message.NewMsgHeaders(sp.LastGS())
+	connectingMsg.AddBlockHeaderWithParents(th.header(t,
+		[]*hash.Hash{genesis}), []*hash.Hash{genesis})
+	connectingMsg.AddBlockHeaderWithParents(orphanMsg.Headers[0],
+		orphanMsg.Parents[0])
+	sm.handleHeadersMsg(&headersMsg{headers: connectingMsg, peer: sp})
+	if retries, ok := sm.headerRetries[sp.Peer]; ok {
+		t.Fatalf("Connected headers left %d retries", retries)
+	}
+
+	for i := 0; i < maxHeaderRetries; i++ {
+		sm.handleHeadersMsg(&headersMsg{headers: orphanMsg, peer: sp})
+		if sm.syncPeer != sp {
+			t.Fatalf("Sync peer was replaced after %d retries", i+1)
+		}
+	}
+	sm.handleHeadersMsg(&headersMsg{headers: orphanMsg, peer: sp})
+	if sp.SyncCandidate {
+		t.Fatalf("Peer is still a sync candidate after %d retries",
+			maxHeaderRetries+1)
+	}
+	if sm.syncPeer != other {
+		t.Fatalf("Got sync peer %v, want %v", sm.syncPeer, other)
+	}
+
+	// The retries of a peer which is done are forgotten.
+	sm.handleDonePeerMsg(sp)
+	if _, ok := sm.headerRetries[sp.Peer]; ok {
+		t.Fatalf("Retries of a done peer weren't forgotten")
+	}
+}
+
+// TestIsCurrentAfterStop checks that the sync state can be queried while the
+// sync manager runs, and doesn't block once it is stopped.
+func TestIsCurrentAfterStop(t *testing.T) {
+	hc, _, remove := newTestHeaderChain(t)
+	defer remove()
+	defer func() {
+		hc.db.Close()
+	}()
+	sm := NewSyncManager(hc, 1)
+	sm.Start()
+	if !sm.IsCurrent() {
+		t.Fatalf("Sync manager without sync peer isn't current")
+	}
+	sm.Stop()
+
+	// The queries are sent until the message queue is full.
+	for i := 0; i < 2*cap(sm.msgChan); i++ {
+		current := make(chan bool)
+		go func() {
+			current <- sm.IsCurrent()
+		}()
+		select {
+		case c := <-current:
+			if c {
+				t.Fatalf("Stopped sync manager is current")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("IsCurrent blocked after the sync manager stopped")
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// txAncestors returns all of the unconfirmed ancestors of the given
+// transaction.  Given transactions A, B, and C where C spends B and B spends A,
+// A and B are considered ancestors of C.
+//
+// The cache is optional and serves as an optimization to avoid visiting
+// transactions we've already determined ancestors of.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txAncestors(tx *types.Tx, cache map[hash.Hash]*TxDesc) map[hash.Hash]*TxDesc {
+	if cache == nil {
+		cache = make(map[hash.Hash]*TxDesc)
+	}
+	for _, txIn := range tx.Transaction().TxIn {
+		parent, exists := mp.pool[txIn.PreviousOut.Hash]
+		if !exists {
+			continue
+		}
+		if _, exists := cache[txIn.PreviousOut.Hash]; exists {
+			continue
+		}
+		cache[txIn.PreviousOut.Hash] = parent
+		mp.txAncestors(parent.Tx, cache)
+	}
+	return cache
+}
+
+// txDescendants returns all of the unconfirmed descendants of the given
+// transaction.  Given transactions A, B, and C where C spends B and B spends A,
+// B and C are considered descendants of A.
+//
+// The cache is optional and serves as an optimization to avoid visiting
+// transactions we've already determined descendants of.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txDescendants(tx *types.Tx, cache map[hash.Hash]*TxDesc) map[hash.Hash]*TxDesc {
+	if cache == nil {
+		cache = make(map[hash.Hash]*TxDesc)
+	}
+	prevOut := types.TxOutPoint{Hash: *tx.Hash()}
+	for txOutIdx := range tx.Transaction().TxOut {
+		prevOut.OutIndex = uint32(txOutIdx)
+		spender, exists := mp.outpoints[prevOut]
+		if !exists {
+			continue
+		}
+		if _, exists := cache[*spender.Hash()]; exists {
+			continue
+		}
+		child, exists := mp.pool[*spender.Hash()]
+		if !exists {
+			continue
+		}
+		cache[*spender.Hash()] = child
+		mp.txDescendants(spender, cache)
+	}
+	return cache
+}
+
+// updateAncestorStats recomputes the number, size and fee of the passed
+// transaction along with its unconfirmed ancestors.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) updateAncestorStats(txDesc *TxDesc) {
+	txDesc.AncestorCount = 1
+	txDesc.AncestorSize = int64(txDesc.Tx.Transaction().SerializeSize())
+	txDesc.AncestorFee = txDesc.Fee
+	for _, ancestor := range mp.txAncestors(txDesc.Tx, nil) {
+		txDesc.AncestorCount++
+		txDesc.AncestorSize += int64(ancestor.Tx.Transaction().SerializeSize())
+		txDesc.AncestorFee += ancestor.Fee
+	}
+}
+
+// updateDescendantStats recomputes the number, size and fee of the passed
+// transaction along with the transactions depending on it, and the fee rate
+// the package pays.  The package fee rate is what mining sorts by, so a child
+// paying a high fee gets its parents mined along with it, and what the
+// eviction queue of the full pool is ordered by.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) updateDescendantStats(txDesc *TxDesc) {
+	txDesc.DescendantCount = 1
+	txDesc.DescendantSize = int64(txDesc.Tx.Transaction().SerializeSize())
+	txDesc.DescendantFee = txDesc.Fee
+	for _, descendant := range mp.txDescendants(txDesc.Tx, nil) {
+		txDesc.DescendantCount++
+		txDesc.DescendantSize += int64(descendant.Tx.Transaction().SerializeSize())
+		txDesc.DescendantFee += descendant.Fee
+	}
+
+	txDesc.PackageFeePerKB = txDesc.FeePerKB
+	packageFeePerKB := txDesc.DescendantFee * 1000 / txDesc.DescendantSize
+	if packageFeePerKB > txDesc.PackageFeePerKB {
+		txDesc.PackageFeePerKB = packageFeePerKB
+	}
+	heap.Fix(&mp.evictionQueue, txDesc.evictionIndex)
+}
+
+// updatePackages recomputes the package statistics of the passed ancestors and
+// descendants of a transaction which was added to or removed from the pool.
+// The descendants of the transaction change the descendant statistics of its
+// ancestors and vice versa.  Transactions which are no longer in the pool are
+// skipped.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) updatePackages(ancestors, descendants map[hash.Hash]*TxDesc) {
+	for h, txDesc := range ancestors {
+		if _, exists := mp.pool[h]; exists {
+			mp.updateDescendantStats(txDesc)
+		}
+	}
+	for h, txDesc := range descendants {
+		if _, exists := mp.pool[h]; exists {
+			mp.updateAncestorStats(txDesc)
+			mp.updateDescendantStats(txDesc)
+		}
+	}
+}
+
+// checkPackageLimits ensures that accepting the passed transaction doesn't
+// create chains of unconfirmed transactions in the pool longer than the
+// ancestor and descendant limits.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) checkPackageLimits(tx *types.Tx) error {
+	ancestors := mp.txAncestors(tx, nil)
+	if len(ancestors)+1 > maxAncestorCount {
+		str := fmt.Sprintf("transaction %v has too many unconfirmed "+
+			"ancestors: %d > %d", tx.Hash(), len(ancestors)+1,
+			maxAncestorCount)
+		return txRuleError(message.RejectNonstandard, str)
+	}
+	for h, ancestor := range ancestors {
+		if ancestor.DescendantCount+1 > maxDescendantCount {
+			str := fmt.Sprintf("transaction %v would exceed the "+
+				"descendant limit of unconfirmed transaction %v: "+
+				"%d > %d", tx.Hash(), h, ancestor.DescendantCount+1,
+				maxDescendantCount)
+			return txRuleError(message.RejectNonstandard, str)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"testing"
+)
+
+// TestCheckPackageLimits checks that the chains of unconfirmed transactions
+// are limited to maxAncestorCount ancestors and maxDescendantCount
+// descendants.
+func TestCheckPackageLimits(t *testing.T) {
+	// A chain of transactions each spending the previous one.
+	mp := newTestPool(Policy{})
+	tip := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 1, 1e6,
+		MaxRBFSequence)
+	for i := 1; i < maxAncestorCount; i++ {
+		addTestTx(mp, tip, 1000)
+		tip = newTestTx(outPoints(tip, 0), 1, 1e6, MaxRBFSequence)
+	}
+	if err := mp.checkPackageLimits(tip); err != nil {
+		t.Fatalf("Chain within the ancestor limit was refused: %v", err)
+	}
+	addTestTx(mp, tip, 1000)
+	tx := newTestTx(outPoints(tip, 0), 1, 1e6, MaxRBFSequence)
+	checkRejectCode(t, "ancestor limit", mp.checkPackageLimits(tx),
+		message.RejectNonstandard)
+
+	// A transaction with children spending each of its outputs.
+	mp = newTestPool(Policy{})
+	parent := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)},
+		maxDescendantCount, 1e6, MaxRBFSequence)
+	addTestTx(mp, parent, 1000)
+	for i := uint32(1); i < maxDescendantCount-1; i++ {
+		addTestTx(mp, newTestTx(outPoints(parent, i), 1, 1e6,
+			MaxRBFSequence), 1000)
+	}
+	tx = newTestTx(outPoints(parent, 0), 1, 1e6, MaxRBFSequence)
+	if err := mp.checkPackageLimits(tx); err != nil {
+		t.Fatalf("Children within the descendant limit were refused: %v",
+			err)
+	}
+	addTestTx(mp, tx, 1000)
+	tx = newTestTx(outPoints(parent, maxDescendantCount-1), 1, 1e6,
+		MaxRBFSequence)
+	checkRejectCode(t, "descendant limit", mp.checkPackageLimits(tx),
+		message.RejectNonstandard)
+}
+
+// TestPackageStats checks that the package statistics of the related
+// transactions are updated when a transaction is added to or removed from the
+// pool, so a child paying a high fee raises the package fee rate of its
+// parent.
+func TestPackageStats(t *testing.T) {
+	mp := newTestPool(Policy{})
+	parent := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 1, 1e6,
+		MaxRBFSequence)
+	parentDesc := addTestTx(mp, parent, 1000)
+	parentSize := int64(parent.Transaction().SerializeSize())
+	if parentDesc.PackageFeePerKB != parentDesc.FeePerKB {
+		t.Fatalf("Package fee rate of a single transaction is %d, want %d",
+			parentDesc.PackageFeePerKB, parentDesc.FeePerKB)
+	}
+
+	child := newTestTx(outPoints(parent, 0), 1, 1e6, MaxRBFSequence)
+	childDesc := addTestTx(mp, child, 20000)
+	childSize := int64(child.Transaction().SerializeSize())
+	if parentDesc.DescendantCount != 2 ||
+		parentDesc.DescendantSize != parentSize+childSize ||
+		parentDesc.DescendantFee != 21000 {
+		t.Fatalf("Got descendant stats %d/%d/%d of the parent, want "+
+			"%d/%d/%d", parentDesc.DescendantCount,
+			parentDesc.DescendantSize, parentDesc.DescendantFee, 2,
+			parentSize+childSize, 21000)
+	}
+	wantFeePerKB := int64(21000 * 1000 / (parentSize + childSize))
+	if parentDesc.PackageFeePerKB != wantFeePerKB {
+		t.Fatalf("Got package fee rate %d of the parent, want %d",
+			parentDesc.PackageFeePerKB, wantFeePerKB)
+	}
+	if childDesc.AncestorCount != 2 ||
+		childDesc.AncestorSize != parentSize+childSize ||
+		childDesc.AncestorFee != 21000 {
+		t.Fatalf("Got ancestor stats %d/%d/%d of the child, want "+
+			"%d/%d/%d", childDesc.AncestorCount, childDesc.AncestorSize,
+			childDesc.AncestorFee, 2, parentSize+childSize, 21000)
+	}
+	if childDesc.PackageFeePerKB != childDesc.FeePerKB {
+		t.Fatalf("Package fee rate of the child is %d, want %d",
+			childDesc.PackageFeePerKB, childDesc.FeePerKB)
+	}
+
+	// The parent no longer benefits from the removed child.
+	mp.removeTransaction(child, false, RemovalReasonEvicted)
+	if parentDesc.DescendantCount != 1 || parentDesc.DescendantFee != 1000 ||
+		parentDesc.PackageFeePerKB != parentDesc.FeePerKB {
+		t.Fatalf("Got descendant stats %d/%d and package fee rate %d "+
+			"after removing the child", parentDesc.DescendantCount,
+			parentDesc.DescendantFee, parentDesc.PackageFeePerKB)
+	}
+
+	// A child left in the pool by a mined parent has no ancestors.
+	childDesc = addTestTx(mp, child, 20000)
+	mp.removeTransaction(parent, false, RemovalReasonMined)
+	if childDesc.AncestorCount != 1 || childDesc.AncestorSize != childSize ||
+		childDesc.AncestorFee != 20000 {
+		t.Fatalf("Got ancestor stats %d/%d/%d of the child after "+
+			"removing the parent", childDesc.AncestorCount,
+			childDesc.AncestorSize, childDesc.AncestorFee)
+	}
+}
@@ -1,6 +1,8 @@
 package mempool
 
 import (
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/json"
 	"github.com/Qitmeer/qitmeer/log"
 	"github.com/Qitmeer/qitmeer/rpc"
 	"sort"
@@ -35,3 +37,98 @@ func (api *PublicMempoolAPI) GetMempool(txType *string, verbose bool) (interface
 	sort.Strings(hashStrings)
 	return hashStrings, nil
 }
+
+// GetMempoolEntry returns the fee and package statistics of the passed
+// transaction in the mempool, and whether it can be replaced.
+func (api *PublicMempoolAPI) GetMempoolEntry(txHash hash.Hash) (interface{}, error) {
+	txDesc, err := api.txPool.FetchTxDesc(&txHash)
+	if err != nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	reply := json.OrderedResult{
+		{Key: "size", Val: txDesc.Tx.Tx.SerializeSize()},
+		{Key: "fee", Val: txDesc.Fee},
+		{Key: "feeperkb", Val: txDesc.FeePerKB},
+		{Key: "time", Val: txDesc.Added.Unix()},
+		{Key: "height", Val: txDesc.Height},
+		{Key: "ancestorcount", Val: txDesc.AncestorCount},
+		{Key: "ancestorsize", Val: txDesc.AncestorSize},
+		{Key: "ancestorfees", Val: txDesc.AncestorFee},
+		{Key: "descendantcount", Val: txDesc.DescendantCount},
+		{Key: "descendantsize", Val: txDesc.DescendantSize},
+		{Key: "descendantfees", Val: txDesc.DescendantFee},
+		{Key: "packagefeeperkb", Val: txDesc.PackageFeePerKB},
+		{Key: "bip125-replaceable", Val: api.txPool.IsReplaceable(&txHash)},
+	}
+	return reply, nil
+}
+
+// GetMempoolInfo returns the number and total size of the transactions in the
+// mempool, its maximum size and the minimum fee rate in atoms/kB to enter it.
+func (api *PublicMempoolAPI) GetMempoolInfo() (interface{}, error) {
+	count, size := api.txPool.Size()
+	reply := json.OrderedResult{
+		{Key: "size", Val: count},
+		{Key: "bytes", Val: size},
+		{Key: "maxmempool", Val: api.txPool.cfg.Policy.MaxPoolSize},
+		{Key: "mempoolminfee", Val: int64(api.txPool.MinRelayTxFee())},
+		{Key: "minrelaytxfee", Val: int64(api.txPool.cfg.Policy.MinRelayTxFee)},
+	}
+	return reply, nil
+}
+
+// EstimateFee returns the fee rate in atoms/kB a transaction needs to pay to
+// be confirmed within the passed number of blocks in DAG order.
+func (api *PublicMempoolAPI) EstimateFee(numBlocks uint32) (interface{}, error) {
+	if api.txPool.cfg.FeeEstimator == nil {
+		return nil, rpc.RpcInternalError("Fee estimation disabled",
+			"Configuration")
+	}
+	feeRate, err := api.txPool.cfg.FeeEstimator.EstimateFee(numBlocks)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return feeRate.ToAtomPerKb(), nil
+}
+
+// EstimateSmartFee returns the fee rate in atoms/kB a transaction needs to pay
+// to be confirmed within the passed number of blocks in DAG order, and the
+// number of blocks the estimate is for.  When there isn't enough data for the
+// target, the estimate for the nearest longer target is returned.  The fee
+// rate is never lower than the minimum fee rate to enter the mempool.
+func (api *PublicMempoolAPI) EstimateSmartFee(confTarget uint32) (interface{}, error) {
+	if api.txPool.cfg.FeeEstimator == nil {
+		return nil, rpc.RpcInternalError("Fee estimation disabled",
+			"Configuration")
+	}
+	feeRate, blocks, err := api.txPool.cfg.FeeEstimator.EstimateSmartFee(confTarget)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	feePerKb := feeRate.ToAtomPerKb()
+	if minFee := int64(api.txPool.MinRelayTxFee()); feePerKb < minFee {
+		feePerKb = minFee
+	}
+	reply := json.OrderedResult{
+		{Key: "feerate", Val: feePerKb},
+		{Key: "blocks", Val: blocks},
+	}
+	return reply, nil
+}
+
+// GetMempoolRemoval returns why and when the passed transaction left the
+// mempool: it was mined, expired, conflicted with another transaction, was
+// evicted from the full mempool or was in a block which was disconnected and
+// failed to be accepted back.
+func (api *PublicMempoolAPI) GetMempoolRemoval(txHash hash.Hash) (interface{}, error) {
+	removal, err := api.txPool.FetchRemovedTx(&txHash)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	reply := json.OrderedResult{
+		{Key: "txid", Val: txHash.String()},
+		{Key: "reason", Val: removal.Reason.String()},
+		{Key: "time", Val: removal.Time.Unix()},
+	}
+	return reply, nil
+}
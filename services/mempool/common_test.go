@@ -0,0 +1,147 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"encoding/binary"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"testing"
+	"time"
+)
+
+const (
+	// testFundingAmount is the amount of the outputs the test transactions
+	// spend outside of the pool.
+	testFundingAmount = 1e8
+
+	// testFundingCount is the number of those outputs.
+	testFundingCount = 64
+)
+
+// testPkScript is the script paid by the test transactions, which is spent
+// without a signature.
+var testPkScript = []byte{txscript.OP_TRUE}
+
+// testFundingTxs are the confirmed transactions paying the outputs spent by
+// the test transactions outside of the pool, by hash.
+var testFundingTxs = func() map[hash.Hash]*types.Tx {
+	txs := make(map[hash.Hash]*types.Tx)
+	for i := uint32(0); i < testFundingCount; i++ {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], i)
+		mtx := types.NewTransaction()
+		mtx.AddTxIn(&types.TxInput{
+			PreviousOut: *types.NewOutPoint(&hash.ZeroHash, i),
+			SignScript:  b[:],
+			Sequence:    types.MaxTxInSequenceNum,
+		})
+		mtx.AddTxOut(types.NewTxOutput(testFundingAmount, testPkScript))
+		tx := types.NewTx(mtx)
+		txs[*tx.Hash()] = tx
+	}
+	return txs
+}()
+
+// testFundingOrder lists the hashes of the funding transactions by index.
+var testFundingOrder = func() []hash.Hash {
+	order := make([]hash.Hash, testFundingCount)
+	for h, tx := range testFundingTxs {
+		i := tx.Tx.TxIn[0].PreviousOut.OutIndex
+		order[i] = h
+	}
+	return order
+}()
+
+// newTestPool returns an empty pool with the passed policy on a chain which
+// has the outputs of the funding transactions, whose minimum relay fee
+// defaults to DefaultMinRelayTxFee.  Non-standard transactions are accepted
+// so the test transactions can spend their outputs without signatures.
+func newTestPool(policy Policy) *TxPool {
+	if policy.MinRelayTxFee == 0 {
+		policy.MinRelayTxFee = types.Amount(DefaultMinRelayTxFee)
+	}
+	policy.AcceptNonStd = true
+	policy.DisableRelayPriority = true
+	policy.MaxTxVersion = uint16(types.TxVersion)
+	policy.MaxSigOpsPerTx = blockchain.MaxSigOpsPerBlock / 5
+	policy.StandardVerifyFlags = func() (txscript.ScriptFlags, error) {
+		return BaseStandardVerifyFlags, nil
+	}
+	return New(&Config{
+		Policy:      policy,
+		ChainParams: &params.PrivNetParams,
+		FetchUtxoView: func(tx *types.Tx) (*blockchain.UtxoViewpoint, error) {
+			view := blockchain.NewUtxoViewpoint()
+			for _, txIn := range tx.Tx.TxIn {
+				prevOut := txIn.PreviousOut
+				if fundingTx, ok := testFundingTxs[prevOut.Hash]; ok {
+					view.AddTxOut(fundingTx, prevOut.OutIndex,
+						&hash.ZeroHash)
+				}
+			}
+			return view, nil
+		},
+		BestHeight:     func() uint64 { return 100 },
+		BestLayer:      func() uint64 { return 100 },
+		PastMedianTime: func() time.Time { return time.Now() },
+		CalcSequenceLock: func(*types.Tx, *blockchain.UtxoViewpoint) (*blockchain.SequenceLock, error) {
+			return &blockchain.SequenceLock{BlockHeight: -1, Time: -1}, nil
+		},
+	})
+}
+
+// confirmedOutPoint returns the outpoint of a funding transaction, which isn't
+// spent by the pool.
+func confirmedOutPoint(i uint32) types.TxOutPoint {
+	return *types.NewOutPoint(&testFundingOrder[i], 0)
+}
+
+// outPoints returns the outpoints of the passed outputs of a transaction.
+func outPoints(tx *types.Tx, indexes ...uint32) []types.TxOutPoint {
+	prevOuts := make([]types.TxOutPoint, 0, len(indexes))
+	for _, i := range indexes {
+		prevOuts = append(prevOuts, *types.NewOutPoint(tx.Hash(), i))
+	}
+	return prevOuts
+}
+
+// newTestTx returns a transaction spending the passed outpoints with the
+// passed sequence, which pays the amount to each of its outputs.
+func newTestTx(prevOuts []types.TxOutPoint, numOutputs int, amount uint64,
+	sequence uint32) *types.Tx {
+	mtx := types.NewTransaction()
+	for i := range prevOuts {
+		mtx.AddTxIn(&types.TxInput{
+			PreviousOut: prevOuts[i],
+			SignScript:  []byte{},
+			Sequence:    sequence,
+		})
+	}
+	for i := 0; i < numOutputs; i++ {
+		mtx.AddTxOut(types.NewTxOutput(amount, testPkScript))
+	}
+	return types.NewTx(mtx)
+}
+
+// addTestTx adds the passed transaction paying the fee to the pool without
+// validating it, and returns its descriptor.
+func addTestTx(mp *TxPool, tx *types.Tx, fee int64) *TxDesc {
+	mp.addTransaction(blockchain.NewUtxoViewpoint(), tx, 1, fee)
+	return mp.pool[*tx.Hash()]
+}
+
+// checkRejectCode fails the test when the error isn't a rule error with the
+// passed reject code.
+func checkRejectCode(t *testing.T, name string, err error,
+	want message.RejectCode) {
+	code, ok := extractRejectCode(err)
+	if err == nil || !ok || code != want {
+		t.Errorf("%s: got error %v, want reject code %v", name, err, want)
+	}
+}
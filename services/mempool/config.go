@@ -48,6 +48,10 @@ type Config struct {
 	// the current best chain.
 	BestHeight func() uint64
 
+	// BestLayer defines the function to use to access the layer of the
+	// tips of the block dag.
+	BestLayer func() uint64
+
 	// PastMedianTime defines the function to use in order to access the
 	// median time calculated from the point-of-view of the current chain
 	// tip within the best chain.
@@ -74,6 +78,10 @@ type Config struct {
 	// This can be nil if the address index is not enabled.
 	ExistsAddrIndex *index.ExistsAddrIndex
 
+	// FeeEstimator provides a feeEstimator. If it is not nil, the mempool
+	// records all new transactions it observes into the feeEstimator.
+	FeeEstimator *FeeEstimator
+
 	// block dag
 	BD *blockdag.BlockDAG
 }
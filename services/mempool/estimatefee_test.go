@@ -0,0 +1,555 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"bytes"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// newTestFeeEstimator creates a feeEstimator with some different parameters
+// for testing purposes.
+func newTestFeeEstimator(binSize, maxReplacements, maxRollback uint32) *FeeEstimator {
+	return &FeeEstimator{
+		maxRollback:         maxRollback,
+		lastKnownOrder:      0,
+		binSize:             int32(binSize),
+		minRegisteredBlocks: 0,
+		maxReplacements:     int32(maxReplacements),
+		observed:            make(map[hash.Hash]*observedTransaction),
+		dropped:             make([]*registeredBlock, 0, maxRollback),
+	}
+}
+
+// lastBlock is a linked list of the block hashes which have been
+// processed by the test FeeEstimator.
+type lastBlock struct {
+	hash *hash.Hash
+	prev *lastBlock
+}
+
+// estimateFeeTester interacts with the FeeEstimator to keep track
+// of its expected state.
+type estimateFeeTester struct {
+	ef      *FeeEstimator
+	t       *testing.T
+	version uint32
+	order   int32
+	blocks  int64
+	last    *lastBlock
+}
+
+func (eft *estimateFeeTester) testTx(fee types.Amount) *TxDesc {
+	eft.version++
+	return &TxDesc{
+		TxDesc: types.TxDesc{
+			Tx:     types.NewTx(&types.Transaction{Version: eft.version}),
+			Height: int64(eft.order),
+			Fee:    int64(fee),
+		},
+		StartingPriority: 0,
+	}
+}
+
+func expectedFeePerByte(t *TxDesc) AtomPerByte {
+	size := uint32(t.Tx.Tx.SerializeSize())
+	return NewAtomPerByte(types.Amount(t.Fee), size)
+}
+
+// newTestBlock returns a block of the passed transactions with a hash which
+// differs from the blocks returned before.
+func (eft *estimateFeeTester) newTestBlock(txs []*types.Transaction) *types.SerializedBlock {
+	eft.blocks++
+	header := params.PrivNetParams.GenesisBlock.Header
+	header.Timestamp = header.Timestamp.Add(time.Duration(eft.blocks) *
+		time.Second)
+	return types.NewBlock(&types.Block{Header: header, Transactions: txs})
+}
+
+// registerBlock registers the passed block as the next one in DAG order.
+func (eft *estimateFeeTester) registerBlock(block *types.SerializedBlock) {
+	eft.order++
+	eft.last = &lastBlock{block.Hash(), eft.last}
+	eft.ef.RegisterBlock(block)
+}
+
+func (eft *estimateFeeTester) newBlock(txs []*types.Transaction) *types.SerializedBlock {
+	block := eft.newTestBlock(txs)
+	eft.registerBlock(block)
+	return block
+}
+
+func (eft *estimateFeeTester) rollback() {
+	if eft.last == nil {
+		return
+	}
+
+	err := eft.ef.Rollback(eft.last.hash)
+
+	if err != nil {
+		eft.t.Errorf("Could not rollback: %v", err)
+	}
+
+	eft.order--
+	eft.last = eft.last.prev
+}
+
+// TestEstimateFee tests basic functionality in the FeeEstimator.
+func TestEstimateFee(t *testing.T) {
+	ef := newTestFeeEstimator(5, 3, 1)
+	eft := estimateFeeTester{ef: ef, t: t}
+
+	// Try with no txs and get zero for all queries.
+	expected := AtomPerByte(0.0)
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f when estimator is empty; got %f", expected, estimated)
+		}
+	}
+
+	// Now insert a tx.
+	tx := eft.testTx(1000000)
+	ef.ObserveTransaction(tx)
+
+	// Expected should still be zero because this is still in the mempool.
+	expected = AtomPerByte(0.0)
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f when estimator has one tx in mempool; got %f", expected, estimated)
+		}
+	}
+
+	// Change minRegisteredBlocks to make sure that works. Error return
+	// value expected.
+	ef.minRegisteredBlocks = 1
+	expected = AtomPerByte(-1.0)
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f before any blocks have been registered; got %f", expected, estimated)
+		}
+	}
+
+	// Record a block with the new tx.
+	eft.newBlock([]*types.Transaction{tx.Tx.Tx})
+	expected = expectedFeePerByte(tx)
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f when one tx is binned; got %f", expected, estimated)
+		}
+	}
+
+	// Roll back the last block; this was an unlikely event, so we
+	// get an error again.
+	eft.rollback()
+	expected = AtomPerByte(-1.0)
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f after rolling back block; got %f", expected, estimated)
+		}
+	}
+
+	// Record an empty block and then a block with the new tx.
+	// This test was made because of a bug that only appeared when there
+	// were no transactions in the first bin.
+	eft.newBlock([]*types.Transaction{})
+	eft.newBlock([]*types.Transaction{tx.Tx.Tx})
+	expected = expectedFeePerByte(tx)
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f when one tx in the second bin; got %f", expected, estimated)
+		}
+	}
+
+	// Create some more transactions.
+	txA := eft.testTx(500000)
+	txB := eft.testTx(2000000)
+	txC := eft.testTx(4000000)
+	ef.ObserveTransaction(txA)
+	ef.ObserveTransaction(txB)
+	ef.ObserveTransaction(txC)
+
+	// Record 7 empty blocks.
+	for i := 0; i < 7; i++ {
+		eft.newBlock([]*types.Transaction{})
+	}
+
+	// Mine the first tx.
+	eft.newBlock([]*types.Transaction{txA.Tx.Tx})
+
+	// Now the estimated amount should depend on the value
+	// of the argument to estimate fee.
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+		if i > 2 {
+			expected = expectedFeePerByte(txA)
+		} else {
+			expected = expectedFeePerByte(tx)
+		}
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f on round %d; got %f", expected, i, estimated)
+		}
+	}
+
+	// Record 5 more empty blocks.
+	for i := 0; i < 5; i++ {
+		eft.newBlock([]*types.Transaction{})
+	}
+
+	// Mine the next tx.
+	eft.newBlock([]*types.Transaction{txB.Tx.Tx})
+
+	// Now the estimated amount should depend on the value
+	// of the argument to estimate fee.
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+		if i <= 2 {
+			expected = expectedFeePerByte(txB)
+		} else if i <= 8 {
+			expected = expectedFeePerByte(tx)
+		} else {
+			expected = expectedFeePerByte(txA)
+		}
+
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f on round %d; got %f", expected, i, estimated)
+		}
+	}
+
+	// Record 9 more empty blocks.
+	for i := 0; i < 10; i++ {
+		eft.newBlock([]*types.Transaction{})
+	}
+
+	// Mine txC.
+	eft.newBlock([]*types.Transaction{txC.Tx.Tx})
+
+	// This should have no effect on the outcome because too
+	// many blocks have been mined for txC to be recorded.
+	for i := uint32(1); i <= estimateFeeDepth; i++ {
+		estimated, _ := ef.EstimateFee(i)
+		if i <= 2 {
+			expected = expectedFeePerByte(txC)
+		} else if i <= 8 {
+			expected = expectedFeePerByte(txB)
+		} else if i <= 8+6 {
+			expected = expectedFeePerByte(tx)
+		} else {
+			expected = expectedFeePerByte(txA)
+		}
+
+		if estimated != expected {
+			t.Errorf("Estimate fee error: expected %f on round %d; got %f", expected, i, estimated)
+		}
+	}
+}
+
+func (eft *estimateFeeTester) estimates() [estimateFeeDepth]AtomPerByte {
+
+	// Generate estimates
+	var estimates [estimateFeeDepth]AtomPerByte
+	for i := 0; i < estimateFeeDepth; i++ {
+		estimates[i], _ = eft.ef.EstimateFee(uint32(i + 1))
+	}
+
+	// Check that all estimated fee results go in descending order.
+	for i := 1; i < estimateFeeDepth; i++ {
+		if estimates[i] > estimates[i-1] {
+			eft.t.Error("Estimates not in descending order; got ",
+				estimates[i], " for estimate ", i, " and ", estimates[i-1], " for ", (i - 1))
+			panic("invalid state.")
+		}
+	}
+
+	return estimates
+}
+
+func (eft *estimateFeeTester) round(txHistory [][]*TxDesc,
+	estimateHistory [][estimateFeeDepth]AtomPerByte,
+	txPerRound, txPerBlock uint32) ([][]*TxDesc, [][estimateFeeDepth]AtomPerByte) {
+
+	// generate new txs.
+	var newTxs []*TxDesc
+	for i := uint32(0); i < txPerRound; i++ {
+		newTx := eft.testTx(types.Amount(rand.Intn(1000000)))
+		eft.ef.ObserveTransaction(newTx)
+		newTxs = append(newTxs, newTx)
+	}
+
+	// Generate mempool.
+	mempool := make(map[*observedTransaction]*TxDesc)
+	for _, h := range txHistory {
+		for _, t := range h {
+			if o, exists := eft.ef.observed[*t.Tx.Hash()]; exists && o.mined == UnminedLayer {
+				mempool[o] = t
+			}
+		}
+	}
+
+	// generate new block, with no duplicates.
+	i := uint32(0)
+	newBlockList := make([]*types.Transaction, 0, txPerBlock)
+	for _, t := range mempool {
+		newBlockList = append(newBlockList, t.Tx.Tx)
+		i++
+
+		if i == txPerBlock {
+			break
+		}
+	}
+
+	// Register a new block.
+	eft.newBlock(newBlockList)
+
+	// return results.
+	estimates := eft.estimates()
+
+	// Return results
+	return append(txHistory, newTxs), append(estimateHistory, estimates)
+}
+
+// TestEstimateFeeRollback tests the rollback function, which undoes the
+// effect of a adding a new block.
+func TestEstimateFeeRollback(t *testing.T) {
+	txPerRound := uint32(7)
+	txPerBlock := uint32(5)
+	binSize := uint32(6)
+	maxReplacements := uint32(4)
+	stepsBack := 2
+	rounds := 30
+
+	eft := estimateFeeTester{ef: newTestFeeEstimator(binSize,
+		maxReplacements, uint32(stepsBack)), t: t}
+	var txHistory [][]*TxDesc
+	estimateHistory := [][estimateFeeDepth]AtomPerByte{eft.estimates()}
+
+	for round := 0; round < rounds; round++ {
+		// Go forward a few rounds.
+		for step := 0; step <= stepsBack; step++ {
+			txHistory, estimateHistory =
+				eft.round(txHistory, estimateHistory, txPerRound, txPerBlock)
+		}
+
+		// Now go back.
+		for step := 0; step < stepsBack; step++ {
+			eft.rollback()
+
+			// After rolling back, we should have the same estimated
+			// fees as before.
+			expected := estimateHistory[len(estimateHistory)-step-2]
+			estimates := eft.estimates()
+
+			// Ensure that these are both the same.
+			for i := 0; i < estimateFeeDepth; i++ {
+				if expected[i] != estimates[i] {
+					t.Errorf("Rollback value mismatch. Expected %f, got %f. ",
+						expected[i], estimates[i])
+					return
+				}
+			}
+		}
+
+		// Erase history.
+		txHistory = txHistory[0 : len(txHistory)-stepsBack]
+		estimateHistory = estimateHistory[0 : len(estimateHistory)-stepsBack]
+	}
+}
+
+// TestEstimateFeeDAGReorder checks the changes of the DAG order, which
+// disconnect the blocks after the fork point from the last one and connect
+// them again in the new order.  The estimates after the blocks are
+// disconnected are the same as before they were registered, and the
+// transactions mined by the blocks of the new order are binned by their new
+// orders.
+func TestEstimateFeeDAGReorder(t *testing.T) {
+	const forkBlocks = 3
+
+	eft := estimateFeeTester{ef: newTestFeeEstimator(6, 4, forkBlocks),
+		t: t}
+	var txHistory [][]*TxDesc
+	estimateHistory := [][estimateFeeDepth]AtomPerByte{eft.estimates()}
+	for round := 0; round < 6; round++ {
+		txHistory, estimateHistory = eft.round(txHistory,
+			estimateHistory, 7, 5)
+	}
+	before := eft.estimates()
+	forkOrder := eft.order
+
+	// Register the blocks of the old order, each mining one of the
+	// transactions observed before the fork.
+	var pending []*TxDesc
+	for _, txs := range txHistory {
+		for _, tx := range txs {
+			o := eft.ef.observed[*tx.Tx.Hash()]
+			if o != nil && o.mined == UnminedLayer {
+				pending = append(pending, tx)
+			}
+		}
+	}
+	if len(pending) < forkBlocks {
+		t.Fatalf("Only %d transactions are left to mine", len(pending))
+	}
+	var blocks []*types.SerializedBlock
+	for i := 0; i < forkBlocks; i++ {
+		block := eft.newTestBlock([]*types.Transaction{pending[i].Tx.Tx})
+		eft.registerBlock(block)
+		blocks = append(blocks, block)
+	}
+
+	// The blocks are disconnected from the last one.
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if err := eft.ef.Rollback(blocks[i].Hash()); err != nil {
+			t.Fatalf("Failed to roll back block %d: %v", i, err)
+		}
+		eft.order--
+		eft.last = eft.last.prev
+	}
+	if eft.order != forkOrder || eft.ef.lastKnownOrder != forkOrder {
+		t.Fatalf("Got order %d after disconnecting the blocks, want %d",
+			eft.ef.lastKnownOrder, forkOrder)
+	}
+	if got := eft.estimates(); got != before {
+		t.Fatalf("Got estimates %v after disconnecting the blocks, want %v",
+			got, before)
+	}
+	for i := 0; i < forkBlocks; i++ {
+		o := eft.ef.observed[*pending[i].Tx.Hash()]
+		if o.mined != UnminedLayer {
+			t.Fatalf("Transaction %d is still mined at %d", i, o.mined)
+		}
+	}
+
+	// The blocks are connected again in the reverse order, so the
+	// transactions are mined at the orders of the new DAG order.
+	for i := len(blocks) - 1; i >= 0; i-- {
+		eft.registerBlock(blocks[i])
+		o := eft.ef.observed[*pending[i].Tx.Hash()]
+		if o.mined != eft.order {
+			t.Fatalf("Transaction %d is mined at %d, want %d", i,
+				o.mined, eft.order)
+		}
+	}
+
+	// Disconnecting a block which isn't the last one rolls back the ones
+	// registered after it, so the blocks left to disconnect are no longer
+	// known.
+	if err := eft.ef.Rollback(blocks[len(blocks)-1].Hash()); err != nil {
+		t.Fatalf("Failed to roll back the first block of the new "+
+			"order: %v", err)
+	}
+	if eft.ef.lastKnownOrder != forkOrder {
+		t.Fatalf("Got order %d after disconnecting the first block, "+
+			"want %d", eft.ef.lastKnownOrder, forkOrder)
+	}
+	if err := eft.ef.Rollback(blocks[0].Hash()); err == nil {
+		t.Fatalf("Rolled back a block which was already disconnected")
+	}
+	if got := eft.estimates(); got != before {
+		t.Fatalf("Got estimates %v after disconnecting the new order, "+
+			"want %v", got, before)
+	}
+}
+
+// TestEstimateFeeDeepReorder checks that a transaction whose block was
+// disconnected by a change of the DAG order deeper than the rollbacks kept by
+// the fee estimator isn't binned again when the block is connected again.
+func TestEstimateFeeDeepReorder(t *testing.T) {
+	eft := estimateFeeTester{ef: newTestFeeEstimator(6, 4, 1), t: t}
+	tx := eft.testTx(1000000)
+	eft.ef.ObserveTransaction(tx)
+	block := eft.newBlock([]*types.Transaction{tx.Tx.Tx})
+	eft.newBlock([]*types.Transaction{})
+	estimates := eft.estimates()
+
+	if err := eft.ef.Rollback(block.Hash()); err == nil {
+		t.Fatalf("Rolled back a block deeper than the maximum rollback")
+	}
+	eft.registerBlock(block)
+	if got := eft.estimates(); got != estimates {
+		t.Fatalf("Got estimates %v after connecting the block again, "+
+			"want %v", got, estimates)
+	}
+	binned := 0
+	for _, bin := range eft.ef.bin {
+		binned += len(bin)
+	}
+	if binned != 1 {
+		t.Fatalf("Transaction was binned %d times", binned)
+	}
+}
+
+func (eft *estimateFeeTester) checkSaveAndRestore(
+	previousEstimates [estimateFeeDepth]AtomPerByte) {
+
+	// Get the save state.
+	save := eft.ef.Save()
+
+	// Save and restore database.
+	var err error
+	eft.ef, err = RestoreFeeEstimator(save)
+	if err != nil {
+		eft.t.Fatalf("Could not restore database: %s", err)
+	}
+
+	// Save again and check that it matches the previous one.
+	redo := eft.ef.Save()
+	if !bytes.Equal(save, redo) {
+		eft.t.Fatalf("Restored states do not match: %v %v", save, redo)
+	}
+
+	// Check that the results match.
+	newEstimates := eft.estimates()
+
+	for i, prev := range previousEstimates {
+		if prev != newEstimates[i] {
+			eft.t.Error("Mismatch in estimate ", i, " after restore; got ",
+				newEstimates[i], " but expected ", prev)
+		}
+	}
+}
+
+// TestDatabase tests saving and restoring to a []byte.
+func TestDatabase(t *testing.T) {
+	txPerRound := uint32(7)
+	txPerBlock := uint32(5)
+	binSize := uint32(6)
+	maxReplacements := uint32(4)
+	rounds := 8
+
+	eft := estimateFeeTester{ef: newTestFeeEstimator(binSize,
+		maxReplacements, uint32(rounds)+1), t: t}
+	var txHistory [][]*TxDesc
+	estimateHistory := [][estimateFeeDepth]AtomPerByte{eft.estimates()}
+
+	for round := 0; round < rounds; round++ {
+		eft.checkSaveAndRestore(estimateHistory[len(estimateHistory)-1])
+
+		// Go forward one step.
+		txHistory, estimateHistory =
+			eft.round(txHistory, estimateHistory, txPerRound, txPerBlock)
+	}
+
+	// Reverse the process and try again.
+	for round := 1; round <= rounds; round++ {
+		eft.rollback()
+		eft.checkSaveAndRestore(estimateHistory[len(estimateHistory)-round-1])
+	}
+}
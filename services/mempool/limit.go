@@ -0,0 +1,145 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/log"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultMaxPoolSize is the default maximum size of the transactions
+	// in the mempool in megabytes.
+	DefaultMaxPoolSize = 300
+
+	// minFeeRateHalfLife is the time in which the minimum fee rate raised
+	// by evicting transactions from the full pool decays by half.
+	minFeeRateHalfLife = 12 * time.Hour
+)
+
+// evictionQueue is a min-heap of the transactions in the pool ordered by the
+// package fee rate used for mining, so the package to evict first from the
+// full pool is on top.  Each transaction tracks its index in the queue, so it
+// can be fixed up when its package fee rate changes and removed when it leaves
+// the pool.
+type evictionQueue []*TxDesc
+
+// Len returns the number of transactions in the queue.  It is part of the
+// heap.Interface implementation.
+func (eq evictionQueue) Len() int {
+	return len(eq)
+}
+
+// Less returns whether the transaction with index i pays a lower package fee
+// rate than the one with index j.  It is part of the heap.Interface
+// implementation.
+func (eq evictionQueue) Less(i, j int) bool {
+	return eq[i].PackageFeePerKB < eq[j].PackageFeePerKB
+}
+
+// Swap swaps the transactions at the passed indices in the queue.  It is part
+// of the heap.Interface implementation.
+func (eq evictionQueue) Swap(i, j int) {
+	eq[i], eq[j] = eq[j], eq[i]
+	eq[i].evictionIndex = i
+	eq[j].evictionIndex = j
+}
+
+// Push pushes the passed transaction onto the queue.  It is part of the
+// heap.Interface implementation.
+func (eq *evictionQueue) Push(x interface{}) {
+	txDesc := x.(*TxDesc)
+	txDesc.evictionIndex = len(*eq)
+	*eq = append(*eq, txDesc)
+}
+
+// Pop removes the last transaction of the queue and returns it.  It is part of
+// the heap.Interface implementation.
+func (eq *evictionQueue) Pop() interface{} {
+	n := len(*eq)
+	txDesc := (*eq)[n-1]
+	(*eq)[n-1] = nil
+	*eq = (*eq)[:n-1]
+	txDesc.evictionIndex = -1
+	return txDesc
+}
+
+// limitPoolSize evicts the packages paying the lowest fee rates until the
+// total size of the transactions in the pool is within the maximum pool size.
+// A package is a transaction along with its descendants, and is ranked by the
+// package fee rate used for mining, so parents paid for by their children are
+// kept.  The minimum fee rate for entering the pool is raised above the fee
+// rate of the evicted packages, so they are not accepted again right away.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) limitPoolSize() {
+	maxSize := mp.cfg.Policy.MaxPoolSize
+	if maxSize <= 0 {
+		return
+	}
+	for mp.totalSize > maxSize && len(mp.evictionQueue) > 0 {
+		worst := mp.evictionQueue[0]
+		minFeeRate := worst.PackageFeePerKB + int64(mp.cfg.Policy.MinRelayTxFee)
+		if minFeeRate > mp.decayedMinFeeRate() {
+			mp.minFeeRate = minFeeRate
+			mp.minFeeUpdated = time.Now().Unix()
+		}
+
+		log.Debug(fmt.Sprintf("Evicting transaction %v (package fee rate "+
+			"%v/kB) and its %d descendants from the full mempool",
+			worst.Tx.Hash(), worst.PackageFeePerKB, worst.DescendantCount-1))
+		mp.removeTransaction(worst.Tx, true, RemovalReasonEvicted)
+	}
+}
+
+// decayedMinFeeRate returns the minimum fee rate raised by evicting
+// transactions from the full pool, halved for every minFeeRateHalfLife since it
+// was raised.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) decayedMinFeeRate() int64 {
+	if mp.minFeeRate == 0 {
+		return 0
+	}
+	elapsed := time.Since(time.Unix(mp.minFeeUpdated, 0))
+	halvings := float64(elapsed) / float64(minFeeRateHalfLife)
+	return int64(float64(mp.minFeeRate) / math.Pow(2, halvings))
+}
+
+// minRelayTxFee returns the minimum fee rate in atoms/kB transactions must
+// pay to be accepted into the pool.  It is the configured minimum relay fee,
+// or the minimum fee rate raised by evicting transactions when the pool is
+// full, whichever is higher.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) minRelayTxFee() types.Amount {
+	minFeeRate := types.Amount(mp.decayedMinFeeRate())
+	if minFeeRate > mp.cfg.Policy.MinRelayTxFee {
+		return minFeeRate
+	}
+	return mp.cfg.Policy.MinRelayTxFee
+}
+
+// MinRelayTxFee returns the minimum fee rate in atoms/kB transactions must pay
+// to be accepted into the pool, which is raised while the pool is full.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) MinRelayTxFee() types.Amount {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+	return mp.minRelayTxFee()
+}
+
+// Size returns the number of transactions in the pool and their total
+// serialized size.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Size() (int, int64) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+	return len(mp.pool), mp.totalSize
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TestLimitPoolSize checks that the packages paying the lowest fee rates are
+// evicted from the full pool and that the minimum fee rate is raised above
+// them.
+func TestLimitPoolSize(t *testing.T) {
+	spend := func(i uint32, fee int64) *types.Tx {
+		return newTestTx([]types.TxOutPoint{confirmedOutPoint(i)}, 1,
+			uint64(testFundingAmount-fee), types.MaxTxInSequenceNum)
+	}
+	txA := spend(0, 2000)
+	txSize := int64(txA.Transaction().SerializeSize())
+	mp := newTestPool(Policy{MaxPoolSize: 3 * txSize})
+
+	// The parent pays the lowest fee rate, but its child pays for it.
+	txB := spend(1, 5000)
+	parent := spend(2, 700)
+	child := newTestTx(outPoints(parent, 0),
+		1, uint64(testFundingAmount-700-20000), types.MaxTxInSequenceNum)
+	for _, tx := range []*types.Tx{txA, txB, parent, child} {
+		if _, err := mp.ProcessTransaction(tx, false, false, true); err != nil {
+			t.Fatalf("Failed to accept transaction %v: %v", tx.Hash(), err)
+		}
+	}
+	if mp.IsTransactionInPool(txA.Hash()) {
+		t.Fatalf("The package paying the lowest fee rate wasn't evicted")
+	}
+	for _, tx := range []*types.Tx{txB, parent, child} {
+		if !mp.IsTransactionInPool(tx.Hash()) {
+			t.Fatalf("Transaction %v was evicted", tx.Hash())
+		}
+	}
+	removed, err := mp.FetchRemovedTx(txA.Hash())
+	if err != nil || removed.Reason != RemovalReasonEvicted {
+		t.Fatalf("Eviction wasn't recorded: %v", err)
+	}
+	if count, size := mp.Size(); count != 3 || size != 3*txSize {
+		t.Fatalf("Got pool size %d/%d, want 3/%d", count, size, 3*txSize)
+	}
+
+	// The evicted fee rate can't enter the pool again, and transactions
+	// paying more than it are evicted right away if they pay the least.
+	wantMinFee := 2000*1000/txSize + DefaultMinRelayTxFee
+	if mp.minFeeRate != wantMinFee {
+		t.Fatalf("Got minimum fee rate %d, want %d", mp.minFeeRate,
+			wantMinFee)
+	}
+	_, err = mp.ProcessTransaction(spend(3, 2000), false, false, true)
+	checkRejectCode(t, "below minimum fee rate", err,
+		message.RejectInsufficientFee)
+	_, err = mp.ProcessTransaction(spend(4, 3000), false, false, true)
+	checkRejectCode(t, "lowest fee rate", err, message.RejectInsufficientFee)
+	if count, _ := mp.Size(); count != 3 {
+		t.Fatalf("Got %d transactions after the rejections, want 3", count)
+	}
+}
+
+// TestEvictionQueue checks that the eviction queue stays ordered by package
+// fee rate as transactions enter and leave the pool.
+func TestEvictionQueue(t *testing.T) {
+	mp := newTestPool(Policy{})
+	rng := rand.New(rand.NewSource(1))
+	var txns []*types.Tx
+	for i := uint32(0); i < 32; i++ {
+		tx := newTestTx([]types.TxOutPoint{confirmedOutPoint(i)}, 2, 1e6,
+			types.MaxTxInSequenceNum)
+		addTestTx(mp, tx, 1000+rng.Int63n(100000))
+		txns = append(txns, tx)
+		if i%2 == 1 {
+			child := newTestTx(outPoints(txns[rng.Intn(len(txns))],
+				1), 1, 1e6, types.MaxTxInSequenceNum)
+			if mp.outpoints[child.Tx.TxIn[0].PreviousOut] == nil {
+				addTestTx(mp, child, 1000+rng.Int63n(100000))
+			}
+		}
+	}
+	for i := 0; i < 8; i++ {
+		mp.removeTransaction(txns[rng.Intn(len(txns))], true,
+			RemovalReasonEvicted)
+	}
+
+	if len(mp.evictionQueue) != len(mp.pool) {
+		t.Fatalf("Eviction queue has %d transactions, pool has %d",
+			len(mp.evictionQueue), len(mp.pool))
+	}
+	for len(mp.evictionQueue) > 0 {
+		worst := mp.evictionQueue[0]
+		for _, txDesc := range mp.pool {
+			if txDesc.PackageFeePerKB < worst.PackageFeePerKB {
+				t.Fatalf("Evicting package fee rate %d before %d",
+					worst.PackageFeePerKB, txDesc.PackageFeePerKB)
+			}
+		}
+		mp.removeTransaction(worst.Tx, true, RemovalReasonEvicted)
+	}
+}
+
+// TestMinFeeRateDecay checks that the minimum fee rate raised by evicting
+// transactions halves every minFeeRateHalfLife down to the minimum relay fee.
+func TestMinFeeRateDecay(t *testing.T) {
+	mp := newTestPool(Policy{})
+	now := time.Now()
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    int64
+	}{
+		{"raised", 0, 80000},
+		{"one half life", minFeeRateHalfLife, 40000},
+		{"two half lives", 2 * minFeeRateHalfLife, 20000},
+		{"decayed to the minimum relay fee", 4 * minFeeRateHalfLife,
+			DefaultMinRelayTxFee},
+	}
+	for _, test := range tests {
+		mp.minFeeRate = 80000
+		mp.minFeeUpdated = now.Add(-test.elapsed).Unix()
+		got := int64(mp.MinRelayTxFee())
+		// The decay is measured from the start of the second it was
+		// raised in.
+		if got > test.want || got < test.want*99/100 {
+			t.Errorf("%s: got minimum fee rate %d, want %d", test.name,
+				got, test.want)
+		}
+	}
+}
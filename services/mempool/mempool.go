@@ -6,6 +6,7 @@
 package mempool
 
 import (
+	"container/heap"
 	"container/list"
 	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
@@ -33,6 +34,25 @@ type TxPool struct {
 	orphansByPrev map[hash.Hash]map[hash.Hash]*types.Tx
 	outpoints     map[types.TxOutPoint]*types.Tx
 
+	// totalSize is the total serialized size of the transactions in the
+	// pool.
+	totalSize int64
+
+	// evictionQueue orders the transactions in the pool by package fee
+	// rate for evicting them when the pool is full.
+	evictionQueue evictionQueue
+
+	// minFeeRate is the minimum fee rate in atoms/kB raised by evicting
+	// transactions from the full pool, as of the unix time minFeeUpdated.
+	// It decays over time.
+	minFeeRate    int64
+	minFeeUpdated int64
+
+	// removed records why the transactions which left the pool were
+	// removed, in the order of removedOrder.
+	removed      map[hash.Hash]*RemovedTx
+	removedOrder []hash.Hash
+
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
 }
@@ -46,6 +66,7 @@ func New(cfg *Config) *TxPool {
 		orphans:       make(map[hash.Hash]*types.Tx),
 		orphansByPrev: make(map[hash.Hash]map[hash.Hash]*types.Tx),
 		outpoints:     make(map[types.TxOutPoint]*types.Tx),
+		removed:       make(map[hash.Hash]*RemovedTx),
 	}
 }
 
@@ -57,10 +78,28 @@ type TxDesc struct {
 	// StartingPriority is the priority of the transaction when it was added
 	// to the pool.
 	StartingPriority float64
+
+	// AncestorCount, AncestorSize and AncestorFee are the number, total
+	// serialized size and total fee of the transaction along with its
+	// unconfirmed ancestors in the pool.
+	AncestorCount int
+	AncestorSize  int64
+	AncestorFee   int64
+
+	// DescendantCount, DescendantSize and DescendantFee are the number,
+	// total serialized size and total fee of the transaction along with
+	// the transactions in the pool which depend on it.
+	DescendantCount int
+	DescendantSize  int64
+	DescendantFee   int64
+
+	// evictionIndex is the index of the transaction in the eviction queue
+	// of the pool, or -1 when it is not in the pool.
+	evictionIndex int
 }
 
-// TxDescs returns a slice of descriptors for all the transactions in the pool.
-// The descriptors are to be treated as read only.
+// TxDescs returns a slice of copies of the descriptors for all the
+// transactions in the pool.
 //
 // This function is safe for concurrent access.
 func (mp *TxPool) TxDescs() []*TxDesc {
@@ -68,7 +107,8 @@ func (mp *TxPool) TxDescs() []*TxDesc {
 	descs := make([]*TxDesc, len(mp.pool))
 	i := 0
 	for _, desc := range mp.pool {
-		descs[i] = desc
+		descCopy := *desc
+		descs[i] = &descCopy
 		i++
 	}
 	mp.mtx.RUnlock()
@@ -77,22 +117,20 @@ func (mp *TxPool) TxDescs() []*TxDesc {
 }
 
 // removeTransaction is the internal function which implements the public
-// RemoveTransaction.  See the comment for RemoveTransaction for more details.
+// RemoveTransaction.  The removal of the transaction and its redeemers is
+// recorded with the passed reason.  See the comment for RemoveTransaction for
+// more details.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *TxPool) removeTransaction(theTx *types.Tx, removeRedeemers bool) {
-	log.Trace(fmt.Sprintf("Removing transaction %v", theTx.Hash()))
+func (mp *TxPool) removeTransaction(theTx *types.Tx, removeRedeemers bool,
+	reason RemovalReason) {
+	log.Trace(fmt.Sprintf("Removing transaction %v (%v)", theTx.Hash(),
+		reason))
 
 	tx := theTx.Transaction()
 	txHash := theTx.Hash()
 	if removeRedeemers {
-		// Remove any transactions which rely on this one.
-		for i := uint32(0); i < uint32(len(tx.TxOut)); i++ {
-			outpoint := types.NewOutPoint(txHash, i)
-			if txRedeemer, exists := mp.outpoints[*outpoint]; exists {
-				mp.removeTransaction(txRedeemer, true)
-			}
-		}
+		mp.removeRedeemers(theTx, reason)
 	}
 
 	// Remove the transaction if needed.
@@ -103,26 +141,72 @@ func (mp *TxPool) removeTransaction(theTx *types.Tx, removeRedeemers bool) {
 		if mp.cfg.AddrIndex != nil {
 			mp.cfg.AddrIndex.RemoveUnconfirmedTx(txHash)
 		}
-		// Mark the referenced outpoints as unspent by the pool.
 
+		// The package statistics of the related transactions are
+		// updated once the transaction is gone.
+		ancestors := mp.txAncestors(theTx, nil)
+		descendants := mp.txDescendants(theTx, nil)
+
+		// Mark the referenced outpoints as unspent by the pool.
 		for _, txIn := range txDesc.Tx.Transaction().TxIn {
 			delete(mp.outpoints, txIn.PreviousOut)
 		}
 		delete(mp.pool, *txHash)
+		heap.Remove(&mp.evictionQueue, txDesc.evictionIndex)
+		mp.totalSize -= int64(tx.SerializeSize())
+		mp.updatePackages(ancestors, descendants)
+		mp.recordRemoval(txHash, reason)
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 	}
 }
 
+// removeRedeemers removes the transactions which redeem outputs of the passed
+// transaction from the mempool recursively, recording their removal with the
+// passed reason.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) removeRedeemers(theTx *types.Tx, reason RemovalReason) {
+	txHash := theTx.Hash()
+	for i := uint32(0); i < uint32(len(theTx.Transaction().TxOut)); i++ {
+		outpoint := types.NewOutPoint(txHash, i)
+		if txRedeemer, exists := mp.outpoints[*outpoint]; exists {
+			mp.removeTransaction(txRedeemer, true, reason)
+		}
+	}
+}
+
 // RemoveTransaction removes the passed transaction from the mempool. When the
 // removeRedeemers flag is set, any transactions that redeem outputs from the
 // removed transaction will also be removed recursively from the mempool, as
 // they would otherwise become orphans.
 //
+// The transaction is removed because it was included in a block, so it is
+// recorded as mined, while its redeemers are recorded as conflicted.
+//
 // This function is safe for concurrent access.
 func (mp *TxPool) RemoveTransaction(tx *types.Tx, removeRedeemers bool) {
 	// Protect concurrent access.
 	mp.mtx.Lock()
-	mp.removeTransaction(tx, removeRedeemers)
+	if removeRedeemers {
+		mp.removeRedeemers(tx, RemovalReasonConflicted)
+	}
+	mp.removeTransaction(tx, false, RemovalReasonMined)
+	mp.mtx.Unlock()
+}
+
+// RemoveReorgedTransaction removes the passed transaction and the transactions
+// which redeem its outputs from the mempool recursively.  The transaction was in a
+// block disconnected from the main chain and wasn't accepted back into the
+// pool, so its removal is recorded along with the one of its redeemers as
+// caused by the reorganization, which replaces the earlier record of it being
+// mined.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) RemoveReorgedTransaction(tx *types.Tx) {
+	// Protect concurrent access.
+	mp.mtx.Lock()
+	mp.removeTransaction(tx, true, RemovalReasonReorg)
+	mp.recordRemoval(tx.Hash(), RemovalReasonReorg)
 	mp.mtx.Unlock()
 }
 
@@ -139,7 +223,8 @@ func (mp *TxPool) RemoveDoubleSpends(tx *types.Tx) {
 	for _, txIn := range tx.Transaction().TxIn {
 		if txRedeemer, ok := mp.outpoints[txIn.PreviousOut]; ok {
 			if !txRedeemer.Hash().IsEqual(tx.Hash()) {
-				mp.removeTransaction(txRedeemer, true)
+				mp.removeTransaction(txRedeemer, true,
+					RemovalReasonConflicted)
 			}
 		}
 	}
@@ -157,7 +242,7 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 	// Add the transaction to the pool and mark the referenced outpoints
 	// as spent by the pool.
 	msgTx := tx.Transaction()
-	mp.pool[*tx.Hash()] = &TxDesc{
+	txDesc := &TxDesc{
 		TxDesc: types.TxDesc{
 			Tx:       tx,
 			Added:    time.Now(),
@@ -167,9 +252,19 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 		},
 		StartingPriority: CalcPriority(msgTx, utxoView, height, mp.cfg.BD),
 	}
+	mp.pool[*tx.Hash()] = txDesc
+	heap.Push(&mp.evictionQueue, txDesc)
+	mp.totalSize += int64(msgTx.SerializeSize())
 	for _, txIn := range msgTx.TxIn {
 		mp.outpoints[txIn.PreviousOut] = tx
 	}
+
+	// Update the package statistics of the transaction and the ones it is
+	// related to.
+	ancestors := mp.txAncestors(tx, nil)
+	descendants := mp.txDescendants(tx, nil)
+	descendants[*tx.Hash()] = txDesc
+	mp.updatePackages(ancestors, descendants)
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -180,6 +275,11 @@ func (mp *TxPool) addTransaction(utxoView *blockchain.UtxoViewpoint,
 	if mp.cfg.ExistsAddrIndex != nil {
 		mp.cfg.ExistsAddrIndex.AddUnconfirmedTx(msgTx)
 	}
+
+	// Record this tx for fee estimation if enabled.
+	if mp.cfg.FeeEstimator != nil {
+		mp.cfg.FeeEstimator.ObserveTransaction(txDesc)
+	}
 }
 
 //Call addTransaction
@@ -239,9 +339,12 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 	nextBlockHeight := mp.cfg.BestHeight() + 1
 
 	// Don't accept transactions that will be expired as of the next block.
-	if blockchain.IsExpired(tx, nextBlockHeight) {
-		str := fmt.Sprintf("transaction %v expired at height %d",
-			txHash, msgTx.Expire)
+	// The next block is on the layer after the current tips.
+	nextExpiryPoint := blockchain.ExpiryPoint(nextBlockHeight,
+		mp.cfg.BestLayer()+1, mp.cfg.ChainParams)
+	if blockchain.IsExpired(tx, nextExpiryPoint) {
+		str := fmt.Sprintf("transaction %v expired at %d", txHash,
+			msgTx.Expire)
 		return nil, txRuleError(message.RejectInvalid, str)
 	}
 	// Don't allow non-standard transactions if the mempool config forbids
@@ -273,7 +376,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 	// at this point.  There is a more in-depth check that happens later
 	// after fetching the referenced transaction inputs from the main chain
 	// which examines the actual spend data and prevents double spends.
-	err = mp.checkPoolDoubleSpend(tx)
+	isReplacement, err := mp.checkPoolDoubleSpend(tx)
 	if err != nil {
 		return nil, err
 	}
@@ -396,8 +499,7 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 
 	// Don't allow transactions with fees too low to get into a mined block.
 	serializedSize := int64(msgTx.SerializeSize())
-	minFee := calcMinRequiredTxRelayFee(serializedSize,
-		mp.cfg.Policy.MinRelayTxFee)
+	minFee := calcMinRequiredTxRelayFee(serializedSize, mp.minRelayTxFee())
 	if txFee < minFee {
 		str := fmt.Sprintf("transaction %v has %v fees which "+
 			"is under the required amount of %v", txHash,
@@ -462,6 +564,23 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		}
 	}
 
+	// If the transaction has any conflicts, and we've made it this far,
+	// then we're processing a potential replacement.
+	var conflicts map[hash.Hash]*TxDesc
+	if isReplacement {
+		conflicts, err = mp.validateReplacement(tx, txFee)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Don't allow the transaction to grow the chains of unconfirmed
+	// transactions in the pool beyond the limits.
+	err = mp.checkPackageLimits(tx)
+	if err != nil {
+		return nil, err
+	}
+
 	// Verify crypto signatures for each input and reject the transaction if
 	// any don't verify.
 	flags, err := mp.cfg.Policy.StandardVerifyFlags()
@@ -477,9 +596,31 @@ func (mp *TxPool) maybeAcceptTransaction(tx *types.Tx, isNew, rateLimit, allowHi
 		return nil, err
 	}
 
+	// Now that we've deemed the transaction as valid, we can add it to the
+	// mempool.  If it ended up replacing any transactions, we'll remove them
+	// first.
+	for _, conflict := range conflicts {
+		log.Debug(fmt.Sprintf("Replacing transaction %v (fee rate %v/kB) "+
+			"with %v (fee rate %v/kB)", conflict.Tx.Hash(),
+			conflict.FeePerKB, txHash, txFee*1000/serializedSize))
+
+		// The conflict set already includes the descendants of each
+		// one, so the redeemers don't need to be removed by this call.
+		mp.removeTransaction(conflict.Tx, false, RemovalReasonConflicted)
+	}
+
 	// Add to transaction pool.
 	mp.addTransaction(utxoView, tx, nextBlockHeight, txFee)
 
+	// Evict the packages paying the lowest fee rates if the pool grew
+	// beyond its maximum size, which may include the transaction itself.
+	mp.limitPoolSize()
+	if !mp.isTransactionInPool(txHash) {
+		str := fmt.Sprintf("transaction %v has too low a fee rate to "+
+			"enter the full mempool", txHash)
+		return nil, txRuleError(message.RejectInsufficientFee, str)
+	}
+
 	log.Debug("Accepted transaction", "txHash", txHash, "pool size", len(mp.pool))
 
 	return nil, nil
@@ -821,6 +962,40 @@ func (mp *TxPool) FetchTransaction(txHash *hash.Hash) (*types.Tx, error) {
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
+// FetchTxDesc returns a copy of the descriptor of the requested transaction
+// from the transaction pool.  This only fetches from the main transaction pool
+// and does not include orphans.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchTxDesc(txHash *hash.Hash) (*TxDesc, error) {
+	// Protect concurrent access.
+	mp.mtx.RLock()
+	txDesc, exists := mp.pool[*txHash]
+	var descCopy TxDesc
+	if exists {
+		descCopy = *txDesc
+	}
+	mp.mtx.RUnlock()
+
+	if exists {
+		return &descCopy, nil
+	}
+
+	return nil, fmt.Errorf("transaction is not in the pool")
+}
+
+// FetchSpender returns the transaction in the pool which spends the passed
+// outpoint, or nil when no transaction in the pool spends it.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchSpender(outpoint *types.TxOutPoint) *types.Tx {
+	mp.mtx.RLock()
+	spender := mp.outpoints[*outpoint]
+	mp.mtx.RUnlock()
+
+	return spender
+}
+
 // HaveAllTransactions returns whether or not all of the passed transaction
 // hashes exist in the mempool.
 //
@@ -927,7 +1102,8 @@ func (mp *TxPool) MiningDescs() []*types.TxDesc {
 	descs := make([]*types.TxDesc, len(mp.pool))
 	i := 0
 	for _, desc := range mp.pool {
-		descs[i] = &desc.TxDesc
+		descCopy := desc.TxDesc
+		descs[i] = &descCopy
 		i++
 	}
 	mp.mtx.RUnlock()
@@ -940,13 +1116,14 @@ func (mp *TxPool) MiningDescs() []*types.TxDesc {
 //
 // This function MUST be called with the mempool lock held (for writes).
 func (mp *TxPool) pruneExpiredTx() {
-	nextBlockHeight := mp.cfg.BestHeight() + 1
+	nextExpiryPoint := blockchain.ExpiryPoint(mp.cfg.BestHeight()+1,
+		mp.cfg.BestLayer()+1, mp.cfg.ChainParams)
 
 	for _, tx := range mp.pool {
-		if blockchain.IsExpired(tx.Tx, nextBlockHeight) {
+		if blockchain.IsExpired(tx.Tx, nextExpiryPoint) {
 			log.Debug(fmt.Sprintf("Pruning expired transaction %v from the mempool",
 				tx.Tx.Hash()))
-			mp.removeTransaction(tx.Tx, true)
+			mp.removeTransaction(tx.Tx, true, RemovalReasonExpired)
 		}
 	}
 }
@@ -0,0 +1,122 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/params"
+	"testing"
+)
+
+// TestExpiryActivation checks that the transactions are expired by the height
+// of the next block below the layer expiry activation layer and by its layer
+// from it, both when they are accepted and when the pool is pruned.
+func TestExpiryActivation(t *testing.T) {
+	// The next block is on the height 101 and the layer 111.
+	const (
+		bestHeight = 100
+		bestLayer  = 110
+	)
+
+	expiringTx := func(i uint32, expire uint32) *types.Tx {
+		tx := newTestTx([]types.TxOutPoint{confirmedOutPoint(i)}, 1,
+			testFundingAmount-10000, types.MaxTxInSequenceNum)
+		tx.Tx.Expire = expire
+		return types.NewTx(tx.Tx)
+	}
+
+	tests := []struct {
+		name       string
+		activation uint64
+		expire     uint32
+		expired    bool
+	}{
+		{"height before activation", 1000, bestHeight + 5, false},
+		{"height expired before activation", 1000, bestHeight + 1, true},
+		{"activated on the next layer", bestLayer + 1, bestHeight + 5, true},
+		{"layer after activation", 0, bestLayer + 2, false},
+		{"layer expired after activation", 0, bestLayer + 1, true},
+	}
+
+	for i, test := range tests {
+		par := params.PrivNetParams
+		par.LayerExpiryActivation = test.activation
+		mp := newTestPool(Policy{})
+		mp.cfg.ChainParams = &par
+		mp.cfg.BestHeight = func() uint64 { return bestHeight }
+		mp.cfg.BestLayer = func() uint64 { return bestLayer }
+
+		tx := expiringTx(uint32(i), test.expire)
+		_, err := mp.ProcessTransaction(tx, false, false, true)
+		if test.expired {
+			checkRejectCode(t, test.name, err, message.RejectInvalid)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to accept the transaction: %v", test.name,
+				err)
+		}
+	}
+
+	// A transaction accepted by the height of the next block is pruned
+	// once the layer expiry activates.
+	par := params.PrivNetParams
+	par.LayerExpiryActivation = 1000
+	mp := newTestPool(Policy{})
+	mp.cfg.ChainParams = &par
+	mp.cfg.BestHeight = func() uint64 { return bestHeight }
+	mp.cfg.BestLayer = func() uint64 { return bestLayer }
+	tx := expiringTx(0, bestHeight+5)
+	if _, err := mp.ProcessTransaction(tx, false, false, true); err != nil {
+		t.Fatalf("Failed to accept the transaction: %v", err)
+	}
+	mp.PruneExpiredTx()
+	if !mp.IsTransactionInPool(tx.Hash()) {
+		t.Fatalf("Transaction was pruned before the activation")
+	}
+	par.LayerExpiryActivation = bestLayer + 1
+	mp.PruneExpiredTx()
+	if mp.IsTransactionInPool(tx.Hash()) {
+		t.Fatalf("Expired transaction wasn't pruned after the activation")
+	}
+	removed, err := mp.FetchRemovedTx(tx.Hash())
+	if err != nil || removed.Reason != RemovalReasonExpired {
+		t.Fatalf("Expiry wasn't recorded: %v", err)
+	}
+}
+
+// TestRemoveReorgedTransaction checks that a transaction of a disconnected
+// block which isn't accepted back is recorded as removed by the reorganization
+// instead of mined, along with the transactions redeeming its outputs.
+func TestRemoveReorgedTransaction(t *testing.T) {
+	mp := newTestPool(Policy{})
+	parent := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 1,
+		testFundingAmount-1000, types.MaxTxInSequenceNum)
+	child := newTestTx(outPoints(parent, 0), 1, testFundingAmount-2000,
+		types.MaxTxInSequenceNum)
+	addTestTx(mp, parent, 1000)
+	addTestTx(mp, child, 1000)
+
+	// The parent is mined, and its block disconnected later on.
+	mp.RemoveTransaction(parent, false)
+	removed, err := mp.FetchRemovedTx(parent.Hash())
+	if err != nil || removed.Reason != RemovalReasonMined {
+		t.Fatalf("Mined transaction wasn't recorded as mined: %v", err)
+	}
+	mp.RemoveReorgedTransaction(parent)
+	if mp.IsTransactionInPool(child.Hash()) {
+		t.Fatalf("Redeemer of the reorged transaction is in the pool")
+	}
+	for _, tx := range []*types.Tx{parent, child} {
+		removed, err := mp.FetchRemovedTx(tx.Hash())
+		if err != nil {
+			t.Fatalf("Removal of %v wasn't recorded: %v", tx.Hash(), err)
+		}
+		if removed.Reason != RemovalReasonReorg {
+			t.Errorf("Removal of %v recorded as %v, want %v", tx.Hash(),
+				removed.Reason, RemovalReasonReorg)
+		}
+	}
+}
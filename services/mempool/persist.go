@@ -0,0 +1,154 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/log"
+	"io"
+	"os"
+	"sort"
+)
+
+// dumpVersion is the version of the format of the files the pool is dumped to.
+const dumpVersion = 1
+
+// Dump writes the transactions in the pool to the passed file, so they can be
+// loaded again after a restart.  The file starts with the format version and
+// the number of transactions, followed by each serialized transaction prefixed
+// with its length.  The parents of each transaction precede it.  It returns
+// the number of transactions written.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Dump(path string) (int, error) {
+	mp.mtx.RLock()
+	descs := make([]*TxDesc, 0, len(mp.pool))
+	for _, txDesc := range mp.pool {
+		descs = append(descs, txDesc)
+	}
+	// Every transaction has more ancestors in the pool than its parents.
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].AncestorCount < descs[j].AncestorCount
+	})
+	txns := make([]*types.Tx, len(descs))
+	for i, txDesc := range descs {
+		txns[i] = txDesc.Tx
+	}
+	mp.mtx.RUnlock()
+
+	// Write to a temporary file first, so a crash doesn't leave a
+	// truncated dump behind.
+	tmpPath := path + ".new"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(file)
+	var header [12]byte
+	binary.LittleEndian.PutUint32(header[:4], dumpVersion)
+	binary.LittleEndian.PutUint64(header[4:], uint64(len(txns)))
+	_, err = w.Write(header[:])
+	for _, tx := range txns {
+		if err != nil {
+			break
+		}
+		var serializedTx []byte
+		serializedTx, err = tx.Tx.Serialize()
+		if err != nil {
+			break
+		}
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(serializedTx)))
+		if _, err = w.Write(size[:]); err != nil {
+			break
+		}
+		_, err = w.Write(serializedTx)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	return len(txns), os.Rename(tmpPath, path)
+}
+
+// Load reads the transactions dumped to the passed file and processes them
+// like new transactions, so the ones which were mined or became invalid in the
+// meantime are dropped.  It returns the transactions in the pool once loaded
+// and the number of transactions in the file.  A missing file is not an error.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load(path string) ([]*types.Tx, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, err
+	}
+	version := binary.LittleEndian.Uint32(header[:4])
+	if version != dumpVersion {
+		return nil, 0, fmt.Errorf("unsupported mempool dump version %d",
+			version)
+	}
+	count := binary.LittleEndian.Uint64(header[4:])
+
+	var acceptedTxs []*types.Tx
+	for i := uint64(0); i < count; i++ {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return acceptedTxs, int(count), err
+		}
+		txSize := binary.LittleEndian.Uint32(size[:])
+		if txSize > types.MaxBlockPayload {
+			return acceptedTxs, int(count), fmt.Errorf("dumped "+
+				"transaction size %d exceeds the maximum of %d",
+				txSize, types.MaxBlockPayload)
+		}
+		serializedTx := make([]byte, txSize)
+		if _, err := io.ReadFull(r, serializedTx); err != nil {
+			return acceptedTxs, int(count), err
+		}
+		msgTx := types.NewTransaction()
+		err := msgTx.Deserialize(bytes.NewReader(serializedTx))
+		if err != nil {
+			return acceptedTxs, int(count), err
+		}
+
+		tx := types.NewTx(msgTx)
+		txs, err := mp.ProcessTransaction(tx, false, false, true)
+		if err != nil {
+			log.Debug(fmt.Sprintf("Dropping dumped transaction %v: %v",
+				tx.Hash(), err))
+			continue
+		}
+		acceptedTxs = append(acceptedTxs, txs...)
+	}
+
+	// Transactions accepted early may have been evicted by later ones when
+	// the pool is full.
+	loadedTxs := acceptedTxs[:0]
+	for _, tx := range acceptedTxs {
+		if mp.IsTransactionInPool(tx.Hash()) {
+			loadedTxs = append(loadedTxs, tx)
+		}
+	}
+	return loadedTxs, int(count), nil
+}
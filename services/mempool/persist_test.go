@@ -0,0 +1,98 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"encoding/binary"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDumpLoad checks that the transactions dumped by a pool are loaded by
+// another one, with the parents preceding their children.
+func TestDumpLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mempool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mempool.dat")
+
+	// A missing dump is not an error.
+	mp := newTestPool(Policy{})
+	txns, count, err := mp.Load(path)
+	if err != nil || len(txns) != 0 || count != 0 {
+		t.Fatalf("Loading a missing dump returned %d/%d transactions: %v",
+			len(txns), count, err)
+	}
+
+	// A chain of transactions along with an unrelated one.
+	var dumped []*types.Tx
+	tx := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 2,
+		testFundingAmount/2-1000, types.MaxTxInSequenceNum)
+	for i := 0; i < 4; i++ {
+		if _, err := mp.ProcessTransaction(tx, false, false, true); err != nil {
+			t.Fatalf("Failed to accept transaction %d: %v", i, err)
+		}
+		dumped = append(dumped, tx)
+		amount := tx.Tx.TxOut[0].Amount - 1000
+		tx = newTestTx(outPoints(tx, 0), 1, amount,
+			types.MaxTxInSequenceNum)
+	}
+	tx = newTestTx([]types.TxOutPoint{confirmedOutPoint(1)}, 1,
+		testFundingAmount-1000, types.MaxTxInSequenceNum)
+	if _, err := mp.ProcessTransaction(tx, false, false, true); err != nil {
+		t.Fatalf("Failed to accept the unrelated transaction: %v", err)
+	}
+	dumped = append(dumped, tx)
+
+	n, err := mp.Dump(path)
+	if err != nil || n != len(dumped) {
+		t.Fatalf("Dumped %d transactions, want %d: %v", n, len(dumped), err)
+	}
+
+	// The transactions are accepted again in an order their parents are
+	// found in, and with the same package statistics.
+	mp2 := newTestPool(Policy{})
+	txns, count, err = mp2.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load the dump: %v", err)
+	}
+	if len(txns) != len(dumped) || count != len(dumped) {
+		t.Fatalf("Loaded %d of %d transactions, want %d", len(txns), count,
+			len(dumped))
+	}
+	if len(mp2.orphans) != 0 {
+		t.Fatalf("Loaded %d orphans", len(mp2.orphans))
+	}
+	for _, tx := range dumped {
+		want, _ := mp.FetchTxDesc(tx.Hash())
+		got, err := mp2.FetchTxDesc(tx.Hash())
+		if err != nil {
+			t.Fatalf("Transaction %v wasn't loaded", tx.Hash())
+		}
+		if got.Fee != want.Fee || got.AncestorCount != want.AncestorCount ||
+			got.DescendantCount != want.DescendantCount ||
+			got.PackageFeePerKB != want.PackageFeePerKB {
+			t.Errorf("Loaded transaction %v has different stats",
+				tx.Hash())
+		}
+	}
+
+	// The dumps of other versions are refused.
+	serialized, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(serialized[:4], dumpVersion+1)
+	if err := ioutil.WriteFile(path, serialized, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := newTestPool(Policy{}).Load(path); err == nil {
+		t.Fatalf("Loaded a dump of an unsupported version")
+	}
+}
@@ -93,6 +93,24 @@ const (
 	// MinHighPriority is the minimum priority value that allows a
 	// transaction to be considered high priority.
 	MinHighPriority = types.AtomsPerCoin * 144.0 / 250
+
+	// MaxRBFSequence is the maximum sequence number an input can use to
+	// signal that the transaction spending it can be replaced by a
+	// transaction paying a higher fee.
+	MaxRBFSequence = 0xfffffffd
+
+	// MaxReplacementEvictions is the maximum number of transactions that
+	// can be evicted from the mempool when accepting a replacement
+	// transaction.
+	MaxReplacementEvictions = 100
+
+	// maxAncestorCount is the maximum number of unconfirmed ancestors a
+	// transaction in the mempool can have, including itself.
+	maxAncestorCount = 25
+
+	// maxDescendantCount is the maximum number of unconfirmed descendants
+	// a transaction in the mempool can have, including itself.
+	maxDescendantCount = 25
 )
 
 // Policy houses the policy (configuration parameters) which is used to
@@ -134,6 +152,16 @@ type Policy struct {
 	// MinRelayTxFee defines the minimum transaction fee in AtomQitmeer/kB
 	MinRelayTxFee types.Amount
 
+	// RejectReplacement defines whether to reject transactions which
+	// double spend transactions in the mempool, even when they signal
+	// replaceability and pay a higher fee.
+	RejectReplacement bool
+
+	// MaxPoolSize is the maximum total size in bytes of the transactions
+	// in the mempool.  The transactions paying the lowest fee rates are
+	// evicted when it is exceeded.  Zero means no limit.
+	MaxPoolSize int64
+
 	// StandardVerifyFlags defines the function to retrieve the flags to
 	// use for verifying scripts for the block after the current best block.
 	// It must set the verification flags properly depending on the result
@@ -0,0 +1,101 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"time"
+)
+
+// maxRemovedTxs is the maximum number of transactions the pool remembers the
+// removal of.  The oldest records are dropped first.
+const maxRemovedTxs = 10000
+
+// RemovalReason describes why a transaction left the pool.
+type RemovalReason int
+
+const (
+	// RemovalReasonMined indicates the transaction was included in a block.
+	RemovalReasonMined RemovalReason = iota
+
+	// RemovalReasonExpired indicates the transaction, or one of its
+	// ancestors in the pool, expired before it was included in a block.
+	RemovalReasonExpired
+
+	// RemovalReasonConflicted indicates the transaction, or one of its
+	// ancestors in the pool, double spent a transaction in a block or was
+	// replaced by a transaction paying a higher fee.
+	RemovalReasonConflicted
+
+	// RemovalReasonEvicted indicates the transaction was evicted from the
+	// full pool for paying a low fee rate.
+	RemovalReasonEvicted
+
+	// RemovalReasonReorg indicates the transaction, or one of its
+	// ancestors, was in a block disconnected from the main chain and
+	// wasn't accepted back into the pool.
+	RemovalReasonReorg
+)
+
+// Map of RemovalReason values back to their constant names for pretty printing.
+var removalReasonStrings = map[RemovalReason]string{
+	RemovalReasonMined:      "mined",
+	RemovalReasonExpired:    "expired",
+	RemovalReasonConflicted: "conflicted",
+	RemovalReasonEvicted:    "evicted",
+	RemovalReasonReorg:      "reorg",
+}
+
+// String returns the RemovalReason in human-readable form.
+func (r RemovalReason) String() string {
+	if s, ok := removalReasonStrings[r]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// RemovedTx records why and when a transaction left the pool.
+type RemovedTx struct {
+	Reason RemovalReason
+	Time   time.Time
+}
+
+// recordRemoval remembers why the passed transaction left the pool, dropping
+// the oldest record when there are too many.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *TxPool) recordRemoval(txHash *hash.Hash, reason RemovalReason) {
+	removal := &RemovedTx{Reason: reason, Time: time.Now()}
+	if _, exists := mp.removed[*txHash]; exists {
+		mp.removed[*txHash] = removal
+		return
+	}
+	if len(mp.removedOrder) >= maxRemovedTxs {
+		delete(mp.removed, mp.removedOrder[0])
+		mp.removedOrder = mp.removedOrder[1:]
+	}
+	mp.removed[*txHash] = removal
+	mp.removedOrder = append(mp.removedOrder, *txHash)
+}
+
+// FetchRemovedTx returns why and when the passed transaction last left the
+// pool.  An error is returned when the transaction is in the pool or its
+// removal is not known.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) FetchRemovedTx(txHash *hash.Hash) (*RemovedTx, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	if _, exists := mp.pool[*txHash]; exists {
+		return nil, fmt.Errorf("transaction is in the pool")
+	}
+	removal, exists := mp.removed[*txHash]
+	if !exists {
+		return nil, fmt.Errorf("no removal of the transaction is known")
+	}
+	copied := *removal
+	return &copied, nil
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+)
+
+// signalsReplacement determines if a transaction is signaling that it can be
+// replaced using the Replace-By-Fee (RBF) policy.  This policy specifies two
+// ways a transaction can signal that it is replaceable:
+//
+// Explicit signaling: A transaction is considered to have opted in to allowing
+// replacement of itself if any of its inputs have a sequence number less than
+// or equal to MaxRBFSequence.
+//
+// Inherited signaling: Transactions that don't explicitly signal replaceability
+// are replaceable under this policy for as long as any one of their ancestors
+// signals replaceability and remains unconfirmed.
+//
+// The cache is optional and serves as an optimization to avoid visiting
+// transactions we've already determined don't signal replacement.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) signalsReplacement(tx *types.Tx, cache map[hash.Hash]struct{}) bool {
+	// If a cache was not provided, we'll initialize one now to use for the
+	// recursive calls.
+	if cache == nil {
+		cache = make(map[hash.Hash]struct{})
+	}
+
+	for _, txIn := range tx.Transaction().TxIn {
+		if txIn.Sequence <= MaxRBFSequence {
+			return true
+		}
+
+		h := txIn.PreviousOut.Hash
+		unconfirmedAncestor, exists := mp.pool[h]
+		if !exists {
+			continue
+		}
+
+		// If we've already determined the transaction doesn't signal
+		// replacement, we can avoid visiting it again.
+		if _, exists := cache[h]; exists {
+			continue
+		}
+
+		if mp.signalsReplacement(unconfirmedAncestor.Tx, cache) {
+			return true
+		}
+
+		// Since the transaction doesn't signal replacement, we'll cache
+		// its result to ensure we don't attempt to determine so again.
+		cache[h] = struct{}{}
+	}
+
+	return false
+}
+
+// txConflicts returns all of the unconfirmed transactions that would become
+// conflicts if the given transaction was accepted, along with their
+// descendants.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) txConflicts(tx *types.Tx) map[hash.Hash]*TxDesc {
+	conflicts := make(map[hash.Hash]*TxDesc)
+	for _, txIn := range tx.Transaction().TxIn {
+		conflict, exists := mp.outpoints[txIn.PreviousOut]
+		if !exists {
+			continue
+		}
+		conflicts[*conflict.Hash()] = mp.pool[*conflict.Hash()]
+		mp.txDescendants(conflict, conflicts)
+	}
+	return conflicts
+}
+
+// validateReplacement determines whether a transaction is deemed as a valid
+// replacement of all of its conflicts according to the RBF policy.  If it is
+// valid, the conflicts to be evicted are returned.  Otherwise, an error is
+// returned indicating what went wrong.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) validateReplacement(tx *types.Tx, txFee int64) (map[hash.Hash]*TxDesc, error) {
+	// First, we'll make sure the set of conflicting transactions doesn't
+	// exceed the maximum allowed.
+	conflicts := mp.txConflicts(tx)
+	if len(conflicts) > MaxReplacementEvictions {
+		str := fmt.Sprintf("replacement transaction %v evicts more "+
+			"transactions than permitted: max is %v, evicts %v",
+			tx.Hash(), MaxReplacementEvictions, len(conflicts))
+		return nil, txRuleError(message.RejectNonstandard, str)
+	}
+
+	// The set of conflicts (transactions we'll replace) and ancestors
+	// should not overlap, otherwise the replacement would be spending an
+	// output that no longer exists.
+	for ancestorHash := range mp.txAncestors(tx, nil) {
+		if _, exists := conflicts[ancestorHash]; !exists {
+			continue
+		}
+		str := fmt.Sprintf("replacement transaction %v spends parent "+
+			"transaction %v", tx.Hash(), ancestorHash)
+		return nil, txRuleError(message.RejectInvalid, str)
+	}
+
+	// The replacement should have a higher fee rate than each of the
+	// conflicting transactions and a higher absolute fee than the fee sum
+	// of all the conflicting transactions.
+	//
+	// We usually don't want to accept replacements with lower fee rates
+	// than what they replaced as that would lower the fee rate of the next
+	// block.  Requiring that the fee rate always be increased is also an
+	// easy-to-reason about way to prevent DoS attacks via replacements.
+	var (
+		txSize           = int64(tx.Transaction().SerializeSize())
+		txFeeRate        = txFee * 1000 / txSize
+		conflictsFee     int64
+		conflictsParents = make(map[hash.Hash]struct{})
+	)
+	for _, conflict := range conflicts {
+		if txFeeRate <= conflict.FeePerKB {
+			str := fmt.Sprintf("replacement transaction %v has an "+
+				"insufficient fee rate: needs more than %v, "+
+				"has %v", tx.Hash(), conflict.FeePerKB, txFeeRate)
+			return nil, txRuleError(message.RejectInsufficientFee, str)
+		}
+
+		conflictsFee += conflict.Fee
+
+		// We'll track each conflict's parents to ensure the replacement
+		// isn't spending any new unconfirmed inputs.
+		for _, txIn := range conflict.Tx.Transaction().TxIn {
+			conflictsParents[txIn.PreviousOut.Hash] = struct{}{}
+		}
+	}
+
+	// It should also have an absolute fee greater than all of the
+	// transactions it intends to replace and pay for its own bandwidth,
+	// which is determined by our minimum relay fee.
+	minFee := calcMinRequiredTxRelayFee(txSize, mp.cfg.Policy.MinRelayTxFee)
+	if txFee < conflictsFee+minFee {
+		str := fmt.Sprintf("replacement transaction %v has an "+
+			"insufficient absolute fee: needs %v, has %v",
+			tx.Hash(), conflictsFee+minFee, txFee)
+		return nil, txRuleError(message.RejectInsufficientFee, str)
+	}
+
+	// Finally, it should not spend any new unconfirmed outputs, other than
+	// the ones already included in the parents of the conflicting
+	// transactions it'll replace.
+	for _, txIn := range tx.Transaction().TxIn {
+		if _, exists := conflictsParents[txIn.PreviousOut.Hash]; exists {
+			continue
+		}
+		// Confirmed outputs are valid to spend in the replacement.
+		if _, exists := mp.pool[txIn.PreviousOut.Hash]; !exists {
+			continue
+		}
+		str := fmt.Sprintf("replacement transaction spends new "+
+			"unconfirmed input %v not found in conflicting "+
+			"transactions", txIn.PreviousOut)
+		return nil, txRuleError(message.RejectInvalid, str)
+	}
+
+	return conflicts, nil
+}
+
+// IsReplaceable returns whether the passed transaction in the pool signals
+// that it can be replaced, either explicitly or through one of its unconfirmed
+// ancestors.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) IsReplaceable(txHash *hash.Hash) bool {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txDesc, exists := mp.pool[*txHash]
+	if !exists {
+		return false
+	}
+	return mp.signalsReplacement(txDesc.Tx, nil)
+}
+
+// CalcReplacementFee returns the minimum fee a transaction of the passed
+// serialized size must pay to replace the passed transaction in the pool,
+// which evicts its descendants as well.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) CalcReplacementFee(txHash *hash.Hash, serializedSize int64) (int64, error) {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	txDesc, exists := mp.pool[*txHash]
+	if !exists {
+		return 0, fmt.Errorf("transaction is not in the pool")
+	}
+	if mp.cfg.Policy.RejectReplacement {
+		return 0, fmt.Errorf("transaction replacement is disabled")
+	}
+	if !mp.signalsReplacement(txDesc.Tx, nil) {
+		return 0, fmt.Errorf("transaction %v is not replaceable", txHash)
+	}
+
+	conflicts := mp.txDescendants(txDesc.Tx, nil)
+	conflicts[*txHash] = txDesc
+	var conflictsFee, maxFeePerKB int64
+	for _, conflict := range conflicts {
+		conflictsFee += conflict.Fee
+		if conflict.FeePerKB > maxFeePerKB {
+			maxFeePerKB = conflict.FeePerKB
+		}
+	}
+
+	// The fee must cover the fees of the conflicts and the relay fee, and
+	// the fee rate must exceed the fee rate of each conflict.
+	fee := conflictsFee + calcMinRequiredTxRelayFee(serializedSize,
+		mp.cfg.Policy.MinRelayTxFee)
+	rateFee := ((maxFeePerKB+1)*serializedSize + 999) / 1000
+	if rateFee > fee {
+		fee = rateFee
+	}
+	return fee, nil
+}
+
+// IsDust returns whether the passed transaction output is considered dust by
+// the relay policy of the pool, so a transaction paying it isn't standard.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) IsDust(txOut *types.TxOutput) bool {
+	return isDust(txOut, mp.cfg.Policy.MinRelayTxFee)
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package mempool
+
+import (
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"testing"
+)
+
+// TestValidateReplacement checks the rules a transaction must follow to
+// replace the transactions it conflicts with, along with their descendants.
+func TestValidateReplacement(t *testing.T) {
+	const amount = 1e8
+
+	tests := []struct {
+		name string
+		// replacement returns the replacement and its fee given the
+		// replaced parent and its child, and an unrelated transaction of
+		// the pool.
+		replacement func(parent, child, other *types.Tx) (*types.Tx, int64)
+		// conflicts is the number of the evicted transactions when the
+		// replacement is valid, otherwise code is the reject code.
+		conflicts int
+		code      message.RejectCode
+	}{
+		{
+			name: "higher fee rate and absolute fee",
+			replacement: func(parent, child, other *types.Tx) (*types.Tx, int64) {
+				tx := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 1,
+					amount-1, MaxRBFSequence)
+				return tx, 10000
+			},
+			conflicts: 2,
+		},
+		{
+			name: "insufficient fee rate",
+			replacement: func(parent, child, other *types.Tx) (*types.Tx, int64) {
+				tx := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 1,
+					amount-1, MaxRBFSequence)
+				return tx, 2000
+			},
+			code: message.RejectInsufficientFee,
+		},
+		{
+			name: "insufficient absolute fee",
+			replacement: func(parent, child, other *types.Tx) (*types.Tx, int64) {
+				tx := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 1,
+					amount-1, MaxRBFSequence)
+				return tx, 4000
+			},
+			code: message.RejectInsufficientFee,
+		},
+		{
+			name: "spends a replaced transaction",
+			replacement: func(parent, child, other *types.Tx) (*types.Tx, int64) {
+				prevOuts := append([]types.TxOutPoint{confirmedOutPoint(0)},
+					outPoints(parent, 1)...)
+				tx := newTestTx(prevOuts, 1, amount-1, MaxRBFSequence)
+				return tx, 100000
+			},
+			code: message.RejectInvalid,
+		},
+		{
+			name: "spends a new unconfirmed output",
+			replacement: func(parent, child, other *types.Tx) (*types.Tx, int64) {
+				prevOuts := append([]types.TxOutPoint{confirmedOutPoint(0)},
+					outPoints(other, 0)...)
+				tx := newTestTx(prevOuts, 1, amount-1, MaxRBFSequence)
+				return tx, 100000
+			},
+			code: message.RejectInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		mp := newTestPool(Policy{})
+		parent := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 2,
+			amount, MaxRBFSequence)
+		addTestTx(mp, parent, 3000)
+		child := newTestTx(outPoints(parent, 0), 1, amount, MaxRBFSequence)
+		addTestTx(mp, child, 1000)
+		other := newTestTx([]types.TxOutPoint{confirmedOutPoint(1)}, 1,
+			amount, MaxRBFSequence)
+		addTestTx(mp, other, 3000)
+
+		tx, fee := test.replacement(parent, child, other)
+		conflicts, err := mp.validateReplacement(tx, fee)
+		if test.code != 0 {
+			checkRejectCode(t, test.name, err, test.code)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(conflicts) != test.conflicts {
+			t.Errorf("%s: got %d conflicts, want %d", test.name,
+				len(conflicts), test.conflicts)
+		}
+		for _, conflict := range []*types.Tx{parent, child} {
+			if _, ok := conflicts[*conflict.Hash()]; !ok {
+				t.Errorf("%s: %v isn't replaced", test.name,
+					conflict.Hash())
+			}
+		}
+	}
+}
+
+// TestValidateReplacementEvictions checks that a replacement can't evict more
+// than MaxReplacementEvictions transactions.
+func TestValidateReplacementEvictions(t *testing.T) {
+	mp := newTestPool(Policy{})
+	parent := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)},
+		MaxReplacementEvictions, 1e6, MaxRBFSequence)
+	addTestTx(mp, parent, 1000)
+	for i := uint32(0); i < MaxReplacementEvictions; i++ {
+		addTestTx(mp, newTestTx(outPoints(parent, i), 1, 1e6,
+			MaxRBFSequence), 1000)
+	}
+
+	tx := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 1, 1e6,
+		MaxRBFSequence)
+	_, err := mp.validateReplacement(tx, 1e8)
+	checkRejectCode(t, "too many evictions", err, message.RejectNonstandard)
+}
+
+// TestSignalsReplacement checks that transactions signal replaceability
+// explicitly or through an unconfirmed ancestor.
+func TestSignalsReplacement(t *testing.T) {
+	mp := newTestPool(Policy{})
+	final := newTestTx([]types.TxOutPoint{confirmedOutPoint(0)}, 1, 1e6,
+		types.MaxTxInSequenceNum)
+	addTestTx(mp, final, 1000)
+	signaling := newTestTx([]types.TxOutPoint{confirmedOutPoint(1)}, 1,
+		1e6, MaxRBFSequence)
+	addTestTx(mp, signaling, 1000)
+	inherited := newTestTx(outPoints(signaling, 0), 1, 1e6,
+		types.MaxTxInSequenceNum)
+	addTestTx(mp, inherited, 1000)
+	notInherited := newTestTx(outPoints(final, 0), 1, 1e6,
+		types.MaxTxInSequenceNum)
+	addTestTx(mp, notInherited, 1000)
+
+	tests := []struct {
+		name string
+		tx   *types.Tx
+		want bool
+	}{
+		{"final", final, false},
+		{"signaling", signaling, true},
+		{"inherited", inherited, true},
+		{"final ancestor", notInherited, false},
+	}
+	for _, test := range tests {
+		if got := mp.IsReplaceable(test.tx.Hash()); got != test.want {
+			t.Errorf("%s: got replaceable %v, want %v", test.name, got,
+				test.want)
+		}
+	}
+}
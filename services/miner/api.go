@@ -3,13 +3,16 @@
 package miner
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/Qitmeer/qitmeer/core/blockdag"
 	"github.com/Qitmeer/qitmeer/core/types/pow"
 	"github.com/Qitmeer/qitmeer/engine/txscript"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -33,6 +36,10 @@ const gbtNonceRange = "00000000ffffffff"
 // in the memory pool.
 const gbtRegenerateSeconds = 60
 
+// gbtLongPollTimeout is the maximum time a getblocktemplate long poll waits
+// for the block template to change before the current one is returned.
+const gbtLongPollTimeout = time.Minute * 5
+
 func (c *CPUMiner) APIs() []rpc.API {
 	return []rpc.API{
 		{
@@ -55,8 +62,7 @@ type PublicMinerAPI struct {
 }
 
 func NewPublicMinerAPI(c *CPUMiner) *PublicMinerAPI {
-	pmAPI := &PublicMinerAPI{miner: c}
-	pmAPI.gbtWorkState = &gbtWorkState{timeSource: c.timeSource}
+	pmAPI := &PublicMinerAPI{miner: c, gbtWorkState: c.gbtWorkState}
 
 	pmAPI.gbtCoinbaseAux = &json.GetBlockTemplateResultAux{
 		Flags: hex.EncodeToString(builderScript(txscript.NewScriptBuilder().
@@ -66,13 +72,20 @@ func NewPublicMinerAPI(c *CPUMiner) *PublicMinerAPI {
 }
 
 //func (api *PublicMinerAPI) GetBlockTemplate(request *mining.TemplateRequest) (interface{}, error){
-func (api *PublicMinerAPI) GetBlockTemplate(capabilities []string) (interface{}, error) {
+
+// GetBlockTemplate returns a block template.  When the long poll id of a
+// previous template is passed, it waits until that template is stale, or
+// at most gbtLongPollTimeout, before returning the current one.
+func (api *PublicMinerAPI) GetBlockTemplate(ctx context.Context, capabilities []string, longPollID *string) (interface{}, error) {
 	// Set the default mode and override it if supplied.
 	mode := "template"
 	request := json.TemplateRequest{Mode: mode, Capabilities: capabilities}
+	if longPollID != nil {
+		request.LongPollID = *longPollID
+	}
 	switch mode {
 	case "template":
-		return handleGetBlockTemplateRequest(api, &request)
+		return handleGetBlockTemplateRequest(ctx, api, &request)
 	case "proposal":
 		//TODO LL, will be added
 		//return handleGetBlockTemplateProposal(s, request)
@@ -163,7 +176,7 @@ func (api *PublicMinerAPI) SubmitBlock(hexBlock string) (interface{}, error) {
 // in regards to whether or not it supports creating its own coinbase (the
 // coinbasetxn and coinbasevalue capabilities) and modifies the returned block
 // template accordingly.
-func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRequest) (interface{}, error) {
+func handleGetBlockTemplateRequest(ctx context.Context, api *PublicMinerAPI, request *json.TemplateRequest) (interface{}, error) {
 	// Extract the relevant passed capabilities and restrict the result to
 	// either a coinbase value or a coinbase transaction object depending on
 	// the request.  Default to only providing a coinbase value.
@@ -200,6 +213,14 @@ func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRe
 			"qitmeer is downloading blocks...")
 	}
 
+	// When a long poll ID was provided, this is a long poll request by the
+	// client to be notified when block template referenced by the ID should
+	// be replaced with a new one.
+	if request != nil && request.LongPollID != "" {
+		return handleGetBlockTemplateLongPoll(ctx, api, request.LongPollID,
+			useCoinbaseValue)
+	}
+
 	// Protect concurrent access when updating block templates.
 	state := api.gbtWorkState
 	state.Lock()
@@ -216,6 +237,91 @@ func handleGetBlockTemplateRequest(api *PublicMinerAPI, request *json.TemplateRe
 	return state.blockTemplateResult(api, useCoinbaseValue, nil)
 }
 
+// handleGetBlockTemplateLongPoll is a helper for handleGetBlockTemplateRequest
+// which deals with handling long polling for block templates.  When a caller
+// sends a request with a long poll ID that was previously returned, a response
+// is not sent until the caller should stop working on the previous block
+// template in favor of the new one, or the long poll times out.  In
+// particular, this is the case when new tips arrive in the DAG or after
+// gbtRegenerateSeconds when the transactions in the memory pool have changed.
+//
+// See https://en.bitcoin.it/wiki/BIP_0022 for more details.
+func handleGetBlockTemplateLongPoll(ctx context.Context, api *PublicMinerAPI, longPollID string, useCoinbaseValue bool) (interface{}, error) {
+	state := api.gbtWorkState
+	state.Lock()
+	// The state unlock is intentionally not deferred here since it needs to
+	// be manually unlocked before waiting for a notification about block
+	// template changes.
+
+	if err := state.updateBlockTemplate(api, useCoinbaseValue); err != nil {
+		state.Unlock()
+		return nil, err
+	}
+
+	// Just return the current block template if the long poll ID provided by
+	// the caller is invalid.
+	parentRoot, lastGenerated, err := decodeTemplateID(longPollID)
+	if err != nil {
+		defer state.Unlock()
+		return state.blockTemplateResult(api, useCoinbaseValue, nil)
+	}
+
+	// Return the block template now if the specific block template
+	// identified by the long poll ID no longer matches the current block
+	// template as this means the provided template is stale.
+	templateRoot := &state.template.Block.Header.ParentRoot
+	if !parentRoot.IsEqual(templateRoot) ||
+		lastGenerated != state.lastGenerated.Unix() {
+
+		// Include whether or not it is valid to submit work against the
+		// old block template depending on whether or not the tips it
+		// builds on are still the tips of the DAG.
+		defer state.Unlock()
+		submitOld := parentRoot.IsEqual(templateRoot)
+		return state.blockTemplateResult(api, useCoinbaseValue, &submitOld)
+	}
+
+	// Get a channel that will be notified when the template associated with
+	// the provided ID is stale and a new block template should be returned to
+	// the caller.
+	longPollChan := state.templateUpdateChan(parentRoot, lastGenerated)
+	state.Unlock()
+
+	timeout := time.NewTimer(gbtLongPollTimeout)
+	defer timeout.Stop()
+	select {
+	// When the client closes before it's time to send a reply, just return
+	// now so the goroutine doesn't hang around, and stop waiting on the
+	// channel so it doesn't stay registered until the template is stale.
+	case <-ctx.Done():
+		state.Lock()
+		state.releaseTemplateUpdateChan(parentRoot, lastGenerated,
+			longPollChan)
+		state.Unlock()
+		return nil, ctx.Err()
+
+	// Wait until signal received to send the reply, or until the long poll
+	// times out.
+	case <-longPollChan:
+	case <-timeout.C:
+	}
+
+	// Get the latest block template.
+	state.Lock()
+	defer state.Unlock()
+	state.releaseTemplateUpdateChan(parentRoot, lastGenerated, longPollChan)
+
+	if err := state.updateBlockTemplate(api, useCoinbaseValue); err != nil {
+		return nil, err
+	}
+
+	// Include whether or not it is valid to submit work against the old
+	// block template depending on whether or not the tips it builds on are
+	// still the tips of the DAG.
+	submitOld := parentRoot.IsEqual(&state.template.Block.Header.ParentRoot)
+	return state.blockTemplateResult(api, useCoinbaseValue, &submitOld)
+}
+
 //LL
 // encodeTemplateID encodes the passed details into an ID that can be used to
 // uniquely identify a block template.
@@ -223,6 +329,28 @@ func encodeTemplateID(prevHash hash.Hash, lastGenerated time.Time) string {
 	return fmt.Sprintf("%s-%d", prevHash.String(), lastGenerated.Unix())
 }
 
+// decodeTemplateID decodes an ID that is used to uniquely identify a block
+// template.  This is mainly used as a mechanism to track when to update clients
+// that are using long polling for block templates.  The ID consists of the
+// parent root of the block template and the time the template was generated.
+func decodeTemplateID(templateID string) (*hash.Hash, int64, error) {
+	fields := strings.Split(templateID, "-")
+	if len(fields) != 2 {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+
+	prevHash, err := hash.NewHashFromStr(fields[0])
+	if err != nil {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+	lastGenerated, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, 0, errors.New("invalid longpollid format")
+	}
+
+	return prevHash, lastGenerated, nil
+}
+
 // gbtWorkState houses state that is used in between multiple RPC invocations to
 // getblocktemplate.
 type gbtWorkState struct {
@@ -232,7 +360,162 @@ type gbtWorkState struct {
 	parentsSet    *blockdag.HashSet
 	minTimestamp  time.Time
 	template      *types.BlockTemplate
+	notifyMap     map[hash.Hash]map[int64]chan struct{}
 	timeSource    blockchain.MedianTimeSource
+
+	// waiters is the number of long poll clients waiting on each channel
+	// of notifyMap.
+	waiters map[chan struct{}]int
+}
+
+// newGbtWorkState returns a new instance of a gbtWorkState with all internal
+// fields initialized and ready to use.
+func newGbtWorkState(timeSource blockchain.MedianTimeSource) *gbtWorkState {
+	return &gbtWorkState{
+		notifyMap:  make(map[hash.Hash]map[int64]chan struct{}),
+		timeSource: timeSource,
+		waiters:    make(map[chan struct{}]int),
+	}
+}
+
+// notifyLongPollers notifies any channels that have been registered to be
+// notified when block templates are stale.  All the waiters of the templates
+// built on other tips than the passed parent root are notified, as well as
+// the waiters of templates built on the same tips which were generated before
+// the passed time.  A zero time only notifies the former.
+//
+// This function MUST be called with the state locked.
+func (state *gbtWorkState) notifyLongPollers(parentRoot *hash.Hash, lastGenerated time.Time) {
+	// Notify anything that is waiting for a block template update from
+	// other tips than the current ones since their work is now invalid.
+	for root, channels := range state.notifyMap {
+		if parentRoot == nil || !root.IsEqual(parentRoot) {
+			for _, c := range channels {
+				close(c)
+				delete(state.waiters, c)
+			}
+			delete(state.notifyMap, root)
+		}
+	}
+
+	// Return now if the provided last generated timestamp has not been
+	// initialized or there is nothing registered for updates to the
+	// current tips.
+	if parentRoot == nil || lastGenerated.IsZero() {
+		return
+	}
+	channels, ok := state.notifyMap[*parentRoot]
+	if !ok {
+		return
+	}
+
+	// Notify anything that is waiting for a block template update from a
+	// block template generated before the most recently generated block
+	// template.
+	lastGeneratedUnix := lastGenerated.Unix()
+	for lastGen, c := range channels {
+		if lastGen < lastGeneratedUnix {
+			close(c)
+			delete(channels, lastGen)
+			delete(state.waiters, c)
+		}
+	}
+
+	// Remove the entry altogether if there are no more registered
+	// channels.
+	if len(channels) == 0 {
+		delete(state.notifyMap, *parentRoot)
+	}
+}
+
+// NotifyBlockAccepted uses the newly-accepted block to notify any long poll
+// clients with a new block template when their existing block template is
+// stale.  Since every accepted block becomes a tip of the DAG, all the
+// templates handed out before are stale.
+func (state *gbtWorkState) NotifyBlockAccepted() {
+	go func() {
+		state.Lock()
+		defer state.Unlock()
+		state.notifyLongPollers(nil, time.Time{})
+	}()
+}
+
+// NotifyMempoolTx uses the new last updated time for the transaction memory
+// pool to notify any long poll clients with a new block template when their
+// existing block template is stale due to enough time passing and the contents
+// of the memory pool changing.
+func (state *gbtWorkState) NotifyMempoolTx(lastUpdated time.Time) {
+	go func() {
+		state.Lock()
+		defer state.Unlock()
+
+		// No need to notify anything if no block templates have been
+		// generated yet.
+		if state.template == nil || state.lastGenerated.IsZero() {
+			return
+		}
+
+		if time.Now().After(state.lastGenerated.Add(time.Second *
+			gbtRegenerateSeconds)) {
+
+			state.notifyLongPollers(&state.template.Block.Header.ParentRoot,
+				lastUpdated)
+		}
+	}()
+}
+
+// templateUpdateChan returns a channel that will be closed once the block
+// template associated with the passed parent root and last generated time
+// is stale.  The function will return existing channels for duplicate
+// parameters which allows multiple clients to wait for the same block
+// template without requiring a different channel for each client.  Every call
+// must be paired with a call to releaseTemplateUpdateChan once the client stops
+// waiting.
+//
+// This function MUST be called with the state locked.
+func (state *gbtWorkState) templateUpdateChan(parentRoot *hash.Hash, lastGenerated int64) chan struct{} {
+	// Either get the current list of channels waiting for updates about
+	// changes to block template for the parent root or create a new one.
+	channels, ok := state.notifyMap[*parentRoot]
+	if !ok {
+		m := make(map[int64]chan struct{})
+		state.notifyMap[*parentRoot] = m
+		channels = m
+	}
+
+	// Get the current channel associated with the time the block template
+	// was last generated or create a new one.
+	c, ok := channels[lastGenerated]
+	if !ok {
+		c = make(chan struct{})
+		channels[lastGenerated] = c
+	}
+	state.waiters[c]++
+
+	return c
+}
+
+// releaseTemplateUpdateChan releases the passed channel returned by
+// templateUpdateChan for a client which stopped waiting on it.  The channel is
+// removed once no client waits on it anymore, so the channels of the long polls
+// which were canceled or timed out don't pile up until their template is stale.
+// Nothing is done when the channel was already closed by a notification.
+//
+// This function MUST be called with the state locked.
+func (state *gbtWorkState) releaseTemplateUpdateChan(parentRoot *hash.Hash, lastGenerated int64, c chan struct{}) {
+	channels, ok := state.notifyMap[*parentRoot]
+	if !ok || channels[lastGenerated] != c {
+		return
+	}
+	state.waiters[c]--
+	if state.waiters[c] > 0 {
+		return
+	}
+	delete(state.waiters, c)
+	delete(channels, lastGenerated)
+	if len(channels) == 0 {
+		delete(state.notifyMap, *parentRoot)
+	}
 }
 
 // updateBlockTemplate creates or updates a block template for the work state.
@@ -417,7 +700,6 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 		}
 		parents = append(parents, resultPt)
 	}
-	//TODO,submitOld
 
 	// gbtMutableFields are the manipulations the server allows to be made
 	// to block templates generated by the getblocktemplate RPC.  It is
@@ -447,8 +729,7 @@ func (state *gbtWorkState) blockTemplateResult(api *PublicMinerAPI, useCoinbaseV
 		Transactions: transactions,
 		Version:      template.Block.Header.Version,
 		LongPollID:   longPollID,
-		//TODO, submitOld
-		SubmitOld: submitOld,
+		SubmitOld:    submitOld,
 		PowDiffReference: json.PowDiffReference{
 			Blake2bDBits: strconv.FormatInt(int64(template.PowDiffData.Blake2bDTarget), 16),
 			//blake2bd hash diff compare target
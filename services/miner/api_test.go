@@ -0,0 +1,334 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"context"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/database"
+	_ "github.com/Qitmeer/qitmeer/database/ffldb"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/blkmgr"
+	"github.com/Qitmeer/qitmeer/services/mempool"
+	"github.com/Qitmeer/qitmeer/services/mining"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestMinerAPI returns the miner API of a privnet node with only the genesis
+// block and an empty mempool.  The returned function stops the block manager
+// and removes the database.
+func newTestMinerAPI(t *testing.T) (*PublicMinerAPI, func()) {
+	dbPath, err := ioutil.TempDir("", "miner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	par := &params.PrivNetParams
+	db, err := database.Create("ffldb", dbPath, par.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("Failed to create the database: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+	cfg := &config.Config{MaxPeers: 1, DAGType: "phantom"}
+	timeSource := blockchain.NewMedianTime()
+	sigCache := txscript.NewSigCache(100)
+	bm, err := blkmgr.NewBlockManager(nil, nil, db, timeSource, sigCache,
+		cfg, par, mining.BlockVersion(par.Net), nil)
+	if err != nil {
+		teardown()
+		t.Fatalf("Failed to create the block manager: %v", err)
+	}
+	bm.Start()
+	closeDB := teardown
+	teardown = func() {
+		bm.Stop()
+		bm.WaitForStop()
+		closeDB()
+	}
+	policy := &mining.Policy{
+		BlockMaxSize: types.MaxBlockPayload,
+		StandardVerifyFlags: func() (txscript.ScriptFlags, error) {
+			return mempool.BaseStandardVerifyFlags, nil
+		},
+	}
+	pool := mempool.New(&mempool.Config{ChainParams: par})
+	m := NewCPUMiner(cfg, par, policy, sigCache, pool, timeSource, bm, 1)
+	return NewPublicMinerAPI(m), teardown
+}
+
+// currentTemplate returns the block template the long poll IDs refer to.
+func currentTemplate(t *testing.T, api *PublicMinerAPI) *json.GetBlockTemplateResult {
+	state := api.gbtWorkState
+	state.Lock()
+	defer state.Unlock()
+	if err := state.updateBlockTemplate(api, true); err != nil {
+		t.Fatalf("Failed to update the block template: %v", err)
+	}
+	result, err := state.blockTemplateResult(api, true, nil)
+	if err != nil {
+		t.Fatalf("Failed to get the block template: %v", err)
+	}
+	return result
+}
+
+// waitLongPollers waits until the number of long poll clients waiting for the
+// templates of the state is the passed one.
+func waitLongPollers(t *testing.T, state *gbtWorkState, want int) {
+	for i := 0; i < 100; i++ {
+		state.Lock()
+		waiters := 0
+		for _, n := range state.waiters {
+			waiters += n
+		}
+		state.Unlock()
+		if waiters == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("The number of long poll clients isn't %d", want)
+}
+
+// longPollResult is the reply of a long poll request.
+type longPollResult struct {
+	result interface{}
+	err    error
+}
+
+// longPoll sends the long poll request for the passed ID and returns the
+// channel receiving its reply.
+func longPoll(ctx context.Context, api *PublicMinerAPI, longPollID string) chan longPollResult {
+	reply := make(chan longPollResult, 1)
+	go func() {
+		result, err := handleGetBlockTemplateLongPoll(ctx, api, longPollID,
+			true)
+		reply <- longPollResult{result, err}
+	}()
+	return reply
+}
+
+// TestTemplateID checks that the long poll IDs are decoded into the parent
+// root and generation time they are encoded from, and that the malformed ones
+// are rejected.
+func TestTemplateID(t *testing.T) {
+	root := hash.HashH([]byte("parents"))
+	generated := time.Unix(1561939200, 0)
+	gotRoot, gotGenerated, err := decodeTemplateID(encodeTemplateID(root,
+		generated))
+	if err != nil {
+		t.Fatalf("Failed to decode the template ID: %v", err)
+	}
+	if !gotRoot.IsEqual(&root) || gotGenerated != generated.Unix() {
+		t.Fatalf("Got parent root %v generated at %d, want %v at %d",
+			gotRoot, gotGenerated, root, generated.Unix())
+	}
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"empty", ""},
+		{"no time", root.String()},
+		{"bad root", "xyz-1561939200"},
+		{"bad time", root.String() + "-now"},
+		{"negative time", root.String() + "--1"},
+		{"extra field", root.String() + "-1561939200-1"},
+	}
+	for _, test := range tests {
+		if _, _, err := decodeTemplateID(test.id); err == nil {
+			t.Errorf("%s: decoded the template ID %q", test.name, test.id)
+		}
+	}
+}
+
+// TestLongPollStaleID checks that the long polls for an ID which is malformed
+// or doesn't refer to the current template are answered right away, along with
+// whether the work on the old template can still be submitted.
+func TestLongPollStaleID(t *testing.T) {
+	api, teardown := newTestMinerAPI(t)
+	defer teardown()
+	template := currentTemplate(t, api)
+	root, generated, err := decodeTemplateID(template.LongPollID)
+	if err != nil {
+		t.Fatalf("Failed to decode the template ID: %v", err)
+	}
+	otherRoot := hash.HashH([]byte("other parents"))
+
+	// The context is canceled so the requests which wait fail.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	submitOld := func(b bool) *bool { return &b }
+	tests := []struct {
+		name      string
+		id        string
+		submitOld *bool
+	}{
+		{"malformed", "malformed", nil},
+		{"older template", fmt.Sprintf("%s-%d", root, generated-1),
+			submitOld(true)},
+		{"other tips", fmt.Sprintf("%s-%d", &otherRoot, generated),
+			submitOld(false)},
+	}
+	for _, test := range tests {
+		reply := <-longPoll(ctx, api, test.id)
+		if reply.err != nil {
+			t.Errorf("%s: the long poll failed: %v", test.name, reply.err)
+			continue
+		}
+		result := reply.result.(*json.GetBlockTemplateResult)
+		if result.LongPollID != template.LongPollID {
+			t.Errorf("%s: got template %s, want %s", test.name,
+				result.LongPollID, template.LongPollID)
+		}
+		switch {
+		case test.submitOld == nil && result.SubmitOld != nil:
+			t.Errorf("%s: got submitold %v, want none", test.name,
+				*result.SubmitOld)
+		case test.submitOld != nil && (result.SubmitOld == nil ||
+			*result.SubmitOld != *test.submitOld):
+			t.Errorf("%s: got submitold %v, want %v", test.name,
+				result.SubmitOld, *test.submitOld)
+		}
+	}
+}
+
+// TestLongPollNewTip checks that the long polls for the current template are
+// answered once a block is accepted, and that the canceled ones stop waiting
+// for it without leaving their channel behind.
+func TestLongPollNewTip(t *testing.T) {
+	api, teardown := newTestMinerAPI(t)
+	defer teardown()
+	state := api.gbtWorkState
+	id := currentTemplate(t, api).LongPollID
+
+	// Two clients wait on the same channel, which stays registered until
+	// both of them are gone.
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	reply1 := longPoll(ctx1, api, id)
+	reply2 := longPoll(ctx2, api, id)
+	waitLongPollers(t, state, 2)
+	cancel1()
+	if reply := <-reply1; reply.err != context.Canceled {
+		t.Fatalf("Got error %v for the canceled long poll, want %v",
+			reply.err, context.Canceled)
+	}
+	waitLongPollers(t, state, 1)
+	state.Lock()
+	registered := len(state.notifyMap)
+	state.Unlock()
+	if registered != 1 {
+		t.Fatalf("The channel of the waiting long poll was removed")
+	}
+
+	state.NotifyBlockAccepted()
+	select {
+	case reply := <-reply2:
+		if reply.err != nil {
+			t.Fatalf("The long poll failed: %v", reply.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The long poll wasn't answered for the new tip")
+	}
+
+	// The last canceled client removes the channel.
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	reply3 := longPoll(ctx3, api, id)
+	waitLongPollers(t, state, 1)
+	cancel3()
+	<-reply3
+	state.Lock()
+	defer state.Unlock()
+	if len(state.notifyMap) != 0 || len(state.waiters) != 0 {
+		t.Fatalf("The channel of the canceled long poll is still " +
+			"registered")
+	}
+}
+
+// TestNotifyLongPollers checks that the channels of the templates built on
+// other tips are closed on any notification, and the ones of the templates on
+// the current tips once the mempool changed after they were generated.
+func TestNotifyLongPollers(t *testing.T) {
+	state := newGbtWorkState(blockchain.NewMedianTime())
+	state.template = newTestTemplate(types.PowDiffStandard{})
+	root := &state.template.Block.Header.ParentRoot
+	otherRoot := hash.HashH([]byte("other parents"))
+
+	// isClosed returns whether the channel is closed within the passed
+	// time.
+	isClosed := func(c chan struct{}, wait time.Duration) bool {
+		select {
+		case <-c:
+			return true
+		default:
+		}
+		select {
+		case <-c:
+			return true
+		case <-time.After(wait):
+			return false
+		}
+	}
+
+	// The template was generated long enough ago to be regenerated.
+	generated := time.Now().Add(-2 * gbtRegenerateSeconds * time.Second)
+	state.Lock()
+	state.lastGenerated = generated
+	current := state.templateUpdateChan(root, generated.Unix())
+	older := state.templateUpdateChan(root, generated.Unix()-1)
+	other := state.templateUpdateChan(&otherRoot, generated.Unix())
+	state.notifyLongPollers(root, generated)
+	state.Unlock()
+	if !isClosed(other, 0) || !isClosed(older, 0) {
+		t.Fatalf("The channels of the stale templates weren't closed")
+	}
+	if isClosed(current, 0) {
+		t.Fatalf("The channel of the current template was closed")
+	}
+
+	// The mempool change is only notified once the template is old
+	// enough.
+	state.Lock()
+	state.lastGenerated = time.Now()
+	state.Unlock()
+	state.NotifyMempoolTx(time.Now().Add(time.Second))
+	if isClosed(current, 100*time.Millisecond) {
+		t.Fatalf("The mempool change was notified for a new template")
+	}
+	state.Lock()
+	state.lastGenerated = generated
+	state.Unlock()
+	state.NotifyMempoolTx(time.Now())
+	if !isClosed(current, 5*time.Second) {
+		t.Fatalf("The mempool change wasn't notified")
+	}
+
+	// A new tip makes all the templates stale.
+	state.Lock()
+	current = state.templateUpdateChan(root, time.Now().Unix())
+	state.Unlock()
+	state.NotifyBlockAccepted()
+	if !isClosed(current, 5*time.Second) {
+		t.Fatalf("The new tip wasn't notified")
+	}
+	state.Lock()
+	defer state.Unlock()
+	if len(state.notifyMap) != 0 || len(state.waiters) != 0 {
+		t.Fatalf("The closed channels are still registered")
+	}
+}
@@ -80,6 +80,10 @@ type CPUMiner struct {
 	speedMonitorQuit  chan struct{}
 	quit              chan struct{}
 
+	// gbtWorkState is shared by the getblocktemplate RPC invocations and
+	// notified of the changes of the DAG and the mempool for long polling.
+	gbtWorkState *gbtWorkState
+
 	// This is a map that keeps track of how many blocks have
 	// been mined on each parent by the CPUMiner. It is only
 	// for use in simulation networks, to diminish memory
@@ -107,9 +111,25 @@ func NewCPUMiner(cfg *config.Config, par *params.Params, policy *mining.Policy,
 		queryHashesPerSec: make(chan float64),
 		updateHashes:      make(chan uint64),
 		minedOnParents:    make(map[hash.Hash]uint8),
+		gbtWorkState:      newGbtWorkState(tsource),
 	}
 }
 
+// HandleChainNotification notifies the getblocktemplate long poll clients
+// when a new block makes their block template stale.  It is registered as a
+// subscriber of the block manager.
+func (m *CPUMiner) HandleChainNotification(notification *blockchain.Notification) {
+	if notification.Type == blockchain.BlockAccepted {
+		m.gbtWorkState.NotifyBlockAccepted()
+	}
+}
+
+// NotifyMempoolTx notifies the getblocktemplate long poll clients when the
+// transactions in the memory pool have changed.
+func (m *CPUMiner) NotifyMempoolTx() {
+	m.gbtWorkState.NotifyMempoolTx(m.txSource.LastUpdated())
+}
+
 // GenerateNBlocks generates the requested number of blocks. It is self
 // contained in that it creates block templates and attempts to solve them while
 // detecting when it is performing stale work and reacting accordingly by
@@ -752,16 +772,14 @@ out:
 	log.Trace("Generate blocks worker done")
 }
 
-func (m *CPUMiner) updateExtraNonce(msgBlock *types.Block, extraNonce uint64) error {
-	// TODO, decided if need extra nonce for coinbase-tx
-	// do nothing for now
-	blockHash := msgBlock.BlockHash()
-	order, err := m.blockManager.GetChain().BlockOrderByHash(&blockHash)
-	if err != nil {
-		return err
-	}
-	coinbaseScript, err := txscript.NewScriptBuilder().AddInt64(int64(order)).
-		AddInt64(int64(extraNonce)).AddData([]byte("qitmeer/test")).
+// updateExtraNonce updates the extra nonce in the coinbase script of the
+// passed block by regenerating the coinbase script with the passed value and
+// block height.  It also recalculates and updates the witness commitment of
+// the coinbase and the merkle root that result from changing the coinbase
+// script.
+func (m *CPUMiner) updateExtraNonce(msgBlock *types.Block, blockHeight uint64, extraNonce uint64) error {
+	coinbaseScript, err := txscript.NewScriptBuilder().AddInt64(int64(blockHeight)).
+		AddInt64(int64(extraNonce)).AddData([]byte(mining.CoinbaseFlags)).
 		Script()
 	if err != nil {
 		return err
@@ -772,7 +790,14 @@ func (m *CPUMiner) updateExtraNonce(msgBlock *types.Block, extraNonce uint64) er
 			len(coinbaseScript), blockchain.MinCoinbaseScriptLen,
 			blockchain.MaxCoinbaseScriptLen)
 	}
-	msgBlock.Transactions[0].TxIn[0].SignScript = coinbaseScript
+	coinbase := msgBlock.Transactions[0]
+	coinbase.TxIn[0].SignScript = coinbaseScript
+
+	// The coinbase script is committed to by the previous outpoint of the
+	// coinbase, which is in turn committed to by the merkle root.
+	witnessMerkles := merkle.BuildMerkleTreeStore(types.NewBlock(msgBlock).Transactions(), true)
+	witnessPreimage := append(witnessMerkles[len(witnessMerkles)-1].Bytes(), coinbaseScript...)
+	coinbase.TxIn[0].PreviousOut.Hash = hash.DoubleHashH(witnessPreimage)
 
 	// Recalculate the merkle root with the updated extra nonce.
 	block := types.NewBlock(msgBlock)
@@ -0,0 +1,796 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/blockdag"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/services/mining"
+	"math/big"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// The stratum server speaks the Stratum v1 protocol, newline-delimited
+// JSON-RPC over TCP, with the following methods:
+//
+//   mining.subscribe [<user agent>]
+//     => [[["mining.set_difficulty", <id>], ["mining.notify", <id>]],
+//         <extranonce1>, 0]
+//   mining.authorize [<worker>, <password>]
+//     => true
+//   mining.submit [<worker>, <job id>, "", <ntime>, <nonce>, <proof data>]
+//     => true
+//
+// and the following notifications sent by the server:
+//
+//   mining.set_difficulty [<share difficulty>]
+//   mining.notify [<job id>, <header>, <clean jobs>]
+//
+// The coinbase of a qitmeer block is committed to by its witness commitment,
+// so the extra nonce can't be rolled by the miners.  Instead, every client is
+// handed out its own range of extra nonces, starting with its extranonce1, and
+// the header of every job already commits to an extra nonce of the range.
+//
+// The header of a job is the 113 bytes hashed by the proof of work, with a
+// zero nonce, in which the miners replace the timestamp at offset 104 and the
+// nonce at offset 108, both little-endian.  The ntime and the nonce of a share
+// are submitted as 8 hex characters of their big-endian value.  The proof data
+// is only submitted for cuckaroo and cuckatoo, as the 169 bytes of the edge
+// bits and the circle nonces.
+//
+// The proof of work of a client defaults to blake2bd and is selected by the
+// pow=<blake2bd|cuckaroo|cuckatoo> option of the password, given as comma
+// separated options.  A share difficulty of 1 corresponds to the minimum
+// difficulty of the network for that proof of work.
+//
+// The fields of the password other than the pow option are the password of the
+// client, which must match the password of the server when one is set, as in
+// "secret,pow=cuckaroo".  Without a server password any client is authorized
+// whatever it sends, so the server should then only listen on trusted
+// interfaces.  The worker name only identifies the client in the logs.  The
+// shares of clients which aren't authorized are rejected.
+// -----------------------------------------------------------------------------
+
+const (
+	// stratumHeaderLen is the length of the header sent to the miners,
+	// which is the data hashed by the proof of work.
+	stratumHeaderLen = 113
+
+	// maxStratumJobs is the maximum number of jobs of a client whose shares
+	// are still accepted.
+	maxStratumJobs = 8
+
+	// maxStratumMessageSize is the maximum size of a message sent by a
+	// client.
+	maxStratumMessageSize = 4096
+
+	// stratumWriteTimeout is the time a client has to read a message sent
+	// by the server before it's disconnected.
+	stratumWriteTimeout = time.Second * 10
+
+	// stratumOutQueueSize is the maximum number of messages queued to be
+	// sent to a client before it's disconnected.
+	stratumOutQueueSize = 32
+
+	// stratumRefreshInterval is the interval at which the server checks
+	// whether the transactions in the memory pool have changed.
+	stratumRefreshInterval = time.Second * 5
+)
+
+// Stratum error codes.
+const (
+	stratumErrOther          = 20
+	stratumErrJobNotFound    = 21
+	stratumErrDuplicateShare = 22
+	stratumErrLowDifficulty  = 23
+	stratumErrUnauthorized   = 24
+	stratumErrNotSubscribed  = 25
+)
+
+// stratumError is the error of a response to a stratum client.
+type stratumError struct {
+	code    int
+	message string
+}
+
+func (e *stratumError) Error() string {
+	return e.message
+}
+
+// MarshalJSON encodes the error as [<code>, <message>, null].
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.code, e.message, nil})
+}
+
+// stratumRequest is a request or a notification sent by a stratum client.
+type stratumRequest struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// stratumResponse is a response sent to a stratum client.
+type stratumResponse struct {
+	ID     interface{}   `json:"id"`
+	Result interface{}   `json:"result"`
+	Error  *stratumError `json:"error"`
+}
+
+// stratumNotification is a notification sent to a stratum client.
+type stratumNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// stratumJob is a block handed out to a stratum client, with its own extra
+// nonce.
+type stratumJob struct {
+	id        string
+	block     *types.Block
+	height    uint64
+	shareBits uint32
+	shares    map[[8]byte]struct{}
+}
+
+// StratumServer serves work to external miners over the Stratum protocol.
+// The work is taken from the block templates shared with the block manager,
+// and the solutions found by the miners are submitted like the blocks mined
+// by the CPU miner.
+type StratumServer struct {
+	sync.Mutex
+	miner     *CPUMiner
+	listeners []net.Listener
+	shareDiff float64
+
+	// passSha is the hash of the password the clients must authorize with,
+	// nil when any client is authorized.
+	passSha *[sha256.Size]byte
+
+	template      *types.BlockTemplate
+	lastTxUpdate  time.Time
+	lastGenerated time.Time
+	clients       map[*stratumClient]struct{}
+	extraNonce1   uint32
+
+	started       int32
+	shutdown      int32
+	blockAccepted chan struct{}
+	quit          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// NewStratumServer returns a new stratum server listening on the passed
+// addresses, which hands out work to the miners with the passed share
+// difficulty.  The miners must authorize with the passed password, unless it's
+// empty.  Use Start to begin serving the miners.
+func NewStratumServer(m *CPUMiner, listenAddrs []string, shareDiff float64, password string) (*StratumServer, error) {
+	listeners := make([]net.Listener, 0, len(listenAddrs))
+	for _, addr := range listenAddrs {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("can't listen on %s: %v", addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	s := &StratumServer{
+		miner:         m,
+		listeners:     listeners,
+		shareDiff:     shareDiff,
+		clients:       make(map[*stratumClient]struct{}),
+		extraNonce1:   rand.Uint32(),
+		blockAccepted: make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+	}
+	if password != "" {
+		passSha := sha256.Sum256([]byte(password))
+		s.passSha = &passSha
+	}
+	return s, nil
+}
+
+// Start begins accepting the connections of the miners and handing out work.
+func (s *StratumServer) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+	if s.passSha == nil {
+		log.Warn("Stratum server authorizes any miner, set --stratumpass " +
+			"to require a password")
+	}
+	for _, listener := range s.listeners {
+		log.Info("Stratum server listening", "addr", listener.Addr())
+		s.wg.Add(1)
+		go s.listenHandler(listener)
+	}
+	s.wg.Add(1)
+	go s.workHandler()
+}
+
+// Stop disconnects all the miners and stops the server.
+func (s *StratumServer) Stop() {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		return
+	}
+	close(s.quit)
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	s.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.Unlock()
+	s.wg.Wait()
+	log.Info("Stratum server stopped")
+}
+
+// HandleChainNotification hands out new work to the miners when a new block
+// makes their work stale.  It is registered as a subscriber of the block
+// manager.
+func (s *StratumServer) HandleChainNotification(notification *blockchain.Notification) {
+	if notification.Type != blockchain.BlockAccepted {
+		return
+	}
+	select {
+	case s.blockAccepted <- struct{}{}:
+	default:
+	}
+}
+
+// listenHandler accepts the connections of the miners on the passed listener.
+//
+// It must be run as a goroutine.
+func (s *StratumServer) listenHandler(listener net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&s.shutdown) == 0 {
+				log.Error("Can't accept stratum connection", "error", err)
+			}
+			return
+		}
+		c := &stratumClient{
+			server:  s,
+			conn:    conn,
+			outChan: make(chan interface{}, stratumOutQueueSize),
+			quit:    make(chan struct{}),
+		}
+		s.Lock()
+		s.clients[c] = struct{}{}
+		s.Unlock()
+		log.Debug("New stratum client", "addr", conn.RemoteAddr())
+		s.wg.Add(2)
+		go c.inHandler()
+		go c.outHandler()
+	}
+}
+
+// workHandler hands out new work to the miners when new blocks are accepted,
+// or periodically when the transactions in the memory pool have changed.
+//
+// It must be run as a goroutine.
+func (s *StratumServer) workHandler() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(stratumRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.blockAccepted:
+			s.refreshWork(true)
+
+		case <-ticker.C:
+			s.Lock()
+			stale := s.template != nil &&
+				!s.lastTxUpdate.Equal(s.miner.txSource.LastUpdated()) &&
+				time.Now().After(s.lastGenerated.Add(time.Second*
+					gbtRegenerateSeconds))
+			s.Unlock()
+			if stale {
+				s.refreshWork(false)
+			}
+
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// refreshWork creates a new block template and hands it out to the miners.
+// The previous jobs are cleared when clean is set.
+func (s *StratumServer) refreshWork(clean bool) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.clients) == 0 {
+		// The template is created when the next miner is authorized.
+		s.template = nil
+		return
+	}
+	if err := s.updateTemplate(!clean); err != nil {
+		log.Error("Failed to create stratum block template", "error", err)
+		return
+	}
+	for c := range s.clients {
+		c.sendJob(s.template, clean)
+	}
+}
+
+// updateTemplate updates the block template handed out to the miners.  The
+// template cached by the block manager is used when it builds on the current
+// tips, unless a new template is forced.
+//
+// This function MUST be called with the server locked.
+func (s *StratumServer) updateTemplate(force bool) error {
+	m := s.miner
+	bm := m.blockManager
+	currentOrder := bm.GetChain().BestSnapshot().GraphState.GetTotal() - 1
+	if currentOrder != 0 && !bm.IsCurrent() {
+		return errors.New("client in initial download")
+	}
+
+	tips := blockdag.NewHashSet()
+	tips.AddList(bm.GetChain().GetMiningTips())
+	if !force {
+		template := bm.GetCurrentTemplate()
+		if template != nil {
+			parents := blockdag.NewHashSet()
+			parents.AddList(template.Block.Parents)
+			if parents.IsEqual(tips) {
+				s.template = template
+				return nil
+			}
+		}
+	}
+
+	lastTxUpdate := m.txSource.LastUpdated()
+	miningAddrs := m.config.GetMinningAddrs()
+	payToAddr := miningAddrs[rand.Intn(len(miningAddrs))]
+	m.submitBlockLock.Lock()
+	template, err := mining.NewBlockTemplate(m.policy, m.params, m.sigCache,
+		m.txSource, m.timeSource, bm, payToAddr, nil)
+	m.submitBlockLock.Unlock()
+	if err != nil {
+		return err
+	}
+	bm.SetCurrentTemplate(template)
+	s.template = template
+	s.lastTxUpdate = lastTxUpdate
+	s.lastGenerated = time.Now()
+	return nil
+}
+
+// nextExtraNonce1 returns the start of the next range of extra nonces handed
+// out to a client.
+//
+// This function MUST be called with the server locked.
+func (s *StratumServer) nextExtraNonce1() uint32 {
+	s.extraNonce1++
+	return s.extraNonce1
+}
+
+// removeClient removes a disconnected client.
+func (s *StratumServer) removeClient(c *stratumClient) {
+	s.Lock()
+	delete(s.clients, c)
+	s.Unlock()
+}
+
+// stratumClient is a miner connected to the stratum server.
+type stratumClient struct {
+	server  *StratumServer
+	conn    net.Conn
+	outChan chan interface{}
+	quit    chan struct{}
+
+	// The following fields are protected by the server lock.
+	subscribed  bool
+	authorized  bool
+	worker      string
+	powType     pow.PowType
+	extraNonce1 uint32
+	jobID       uint32
+	jobs        []*stratumJob
+}
+
+// inHandler reads and handles the messages of the client until it
+// disconnects.
+//
+// It must be run as a goroutine.
+func (c *stratumClient) inHandler() {
+	defer c.server.wg.Done()
+	defer c.server.removeClient(c)
+	defer close(c.quit)
+	defer c.conn.Close()
+
+	reader := bufio.NewReaderSize(c.conn, maxStratumMessageSize)
+	for {
+		line, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			log.Debug("Stratum client disconnected", "addr",
+				c.conn.RemoteAddr(), "error", err)
+			return
+		}
+		if isPrefix {
+			log.Debug("Stratum message too long", "addr",
+				c.conn.RemoteAddr())
+			return
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		var request stratumRequest
+		if err := json.Unmarshal(line, &request); err != nil {
+			log.Debug("Malformed stratum message", "addr",
+				c.conn.RemoteAddr(), "error", err)
+			return
+		}
+		result, rErr := c.handleRequest(&request)
+		response := stratumResponse{ID: request.ID, Result: result}
+		if rErr != nil {
+			response.Result = nil
+			response.Error = rErr
+		}
+		c.queueMessage(&response)
+
+		// The difficulty and the first job are sent once the worker
+		// has been authorized.
+		if request.Method == "mining.authorize" && rErr == nil {
+			c.sendWork()
+		}
+	}
+}
+
+// outHandler sends the queued messages to the client until it disconnects.
+//
+// It must be run as a goroutine.
+func (c *stratumClient) outHandler() {
+	defer c.server.wg.Done()
+	for {
+		select {
+		case msg := <-c.outChan:
+			serialized, err := json.Marshal(msg)
+			if err != nil {
+				log.Error("Can't encode stratum message", "error", err)
+				continue
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+			_, err = c.conn.Write(append(serialized, '\n'))
+			if err != nil {
+				log.Debug("Can't send stratum message", "addr",
+					c.conn.RemoteAddr(), "error", err)
+				c.conn.Close()
+				return
+			}
+
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// queueMessage queues the passed message to be sent to the client, and
+// disconnects it when too many messages are pending.
+func (c *stratumClient) queueMessage(msg interface{}) {
+	select {
+	case c.outChan <- msg:
+	default:
+		log.Debug("Too many pending stratum messages", "addr",
+			c.conn.RemoteAddr())
+		c.conn.Close()
+	}
+}
+
+// handleRequest handles a request of the client.
+func (c *stratumClient) handleRequest(request *stratumRequest) (interface{}, *stratumError) {
+	switch request.Method {
+	case "mining.subscribe":
+		return c.handleSubscribe()
+	case "mining.authorize":
+		return c.handleAuthorize(request.Params)
+	case "mining.submit":
+		return c.handleSubmit(request.Params)
+	}
+	return nil, &stratumError{stratumErrOther, "Unknown method " + request.Method}
+}
+
+// handleSubscribe handles the mining.subscribe request, which hands out the
+// range of extra nonces of the client.
+func (c *stratumClient) handleSubscribe() (interface{}, *stratumError) {
+	s := c.server
+	s.Lock()
+	defer s.Unlock()
+	if !c.subscribed {
+		c.subscribed = true
+		c.extraNonce1 = s.nextExtraNonce1()
+	}
+	extraNonce1 := make([]byte, 4)
+	binary.BigEndian.PutUint32(extraNonce1, c.extraNonce1)
+	subscriptionID := hex.EncodeToString(extraNonce1)
+	return []interface{}{
+		[][]string{
+			{"mining.set_difficulty", subscriptionID},
+			{"mining.notify", subscriptionID},
+		},
+		hex.EncodeToString(extraNonce1),
+		0,
+	}, nil
+}
+
+// handleAuthorize handles the mining.authorize request, which checks the
+// password of the client when the server has one and selects the proof of work
+// of the client, and then sends it the share difficulty and its first job.
+func (c *stratumClient) handleAuthorize(params []json.RawMessage) (interface{}, *stratumError) {
+	var worker, password string
+	if len(params) < 1 || json.Unmarshal(params[0], &worker) != nil {
+		return nil, &stratumError{stratumErrOther, "Invalid worker name"}
+	}
+	if len(params) > 1 {
+		json.Unmarshal(params[1], &password)
+	}
+	powType := pow.BLAKE2BD
+	var passFields []string
+	for _, option := range strings.Split(password, ",") {
+		kv := strings.SplitN(strings.TrimSpace(option), "=", 2)
+		if len(kv) != 2 || kv[0] != "pow" {
+			passFields = append(passFields, option)
+			continue
+		}
+		found := false
+		for t, name := range pow.PowMapString {
+			if name == kv[1] {
+				powType = t
+				found = true
+			}
+		}
+		if !found {
+			return nil, &stratumError{stratumErrOther, "Unknown pow " + kv[1]}
+		}
+	}
+
+	s := c.server
+	if s.passSha != nil {
+		passSha := sha256.Sum256([]byte(strings.Join(passFields, ",")))
+		if subtle.ConstantTimeCompare(passSha[:], s.passSha[:]) != 1 {
+			log.Info("Stratum worker failed to authorize", "worker",
+				worker, "addr", c.conn.RemoteAddr())
+			return nil, &stratumError{stratumErrUnauthorized,
+				"Invalid password"}
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if !c.subscribed {
+		return nil, &stratumError{stratumErrNotSubscribed, "Not subscribed"}
+	}
+	if s.template == nil {
+		if err := s.updateTemplate(false); err != nil {
+			return nil, &stratumError{stratumErrOther, err.Error()}
+		}
+	}
+	c.authorized = true
+	c.worker = worker
+	c.powType = powType
+	log.Info("Stratum worker authorized", "worker", worker,
+		"pow", pow.PowMapString[powType], "addr", c.conn.RemoteAddr())
+	return true, nil
+}
+
+// sendWork sends the share difficulty and a first job to the client.
+func (c *stratumClient) sendWork() {
+	s := c.server
+	s.Lock()
+	defer s.Unlock()
+	c.queueMessage(&stratumNotification{
+		Method: "mining.set_difficulty",
+		Params: []interface{}{s.shareDiff},
+	})
+	c.sendJob(s.template, true)
+}
+
+// handleSubmit handles the mining.submit request, which validates the share
+// against the share difficulty and submits the block when it's a solution.
+func (c *stratumClient) handleSubmit(params []json.RawMessage) (interface{}, *stratumError) {
+	var args [6]string
+	if len(params) < 5 {
+		return nil, &stratumError{stratumErrOther, "Invalid parameters"}
+	}
+	for i := 0; i < len(params) && i < len(args); i++ {
+		if json.Unmarshal(params[i], &args[i]) != nil {
+			return nil, &stratumError{stratumErrOther, "Invalid parameters"}
+		}
+	}
+	jobID, ntimeStr, nonceStr, proofStr := args[1], args[3], args[4], args[5]
+	ntime, err := strconv.ParseUint(ntimeStr, 16, 32)
+	if err != nil || len(ntimeStr) != 8 {
+		return nil, &stratumError{stratumErrOther, "Invalid ntime"}
+	}
+	nonce, err := strconv.ParseUint(nonceStr, 16, 32)
+	if err != nil || len(nonceStr) != 8 {
+		return nil, &stratumError{stratumErrOther, "Invalid nonce"}
+	}
+
+	s := c.server
+	m := s.miner
+	s.Lock()
+	if !c.authorized {
+		s.Unlock()
+		return nil, &stratumError{stratumErrUnauthorized, "Unauthorized worker"}
+	}
+	var job *stratumJob
+	for _, j := range c.jobs {
+		if j.id == jobID {
+			job = j
+		}
+	}
+	if job == nil {
+		s.Unlock()
+		return nil, &stratumError{stratumErrJobNotFound, "Job not found"}
+	}
+
+	// The timestamp may be rolled forward by the miners, up to the maximum
+	// allowed time of a block.  The malformed shares aren't recorded, so
+	// they don't make the valid share with the same ntime and nonce a
+	// duplicate.
+	timestamp := time.Unix(int64(ntime), 0)
+	maxTime := m.timeSource.AdjustedTime().Add(time.Second *
+		blockchain.MaxTimeOffsetSeconds)
+	if timestamp.Before(job.block.Header.Timestamp) || timestamp.After(maxTime) {
+		s.Unlock()
+		return nil, &stratumError{stratumErrOther, "Invalid ntime"}
+	}
+	powType := c.powType
+	var proofData []byte
+	if powType != pow.BLAKE2BD {
+		proofData, err = hex.DecodeString(proofStr)
+		if err != nil || len(proofData) != pow.PROOFDATA_LENGTH {
+			s.Unlock()
+			return nil, &stratumError{stratumErrOther, "Invalid proof data"}
+		}
+	}
+
+	var shareKey [8]byte
+	binary.BigEndian.PutUint32(shareKey[:4], uint32(ntime))
+	binary.BigEndian.PutUint32(shareKey[4:], uint32(nonce))
+	if _, ok := job.shares[shareKey]; ok {
+		s.Unlock()
+		return nil, &stratumError{stratumErrDuplicateShare, "Duplicate share"}
+	}
+	job.shares[shareKey] = struct{}{}
+	s.Unlock()
+
+	header := job.block.Header
+	header.Timestamp = timestamp
+	header.Pow = pow.GetInstance(powType, uint32(nonce), proofData)
+	header.Pow.SetParams(m.params.PowConfig)
+	headerData := header.BlockData()
+	blockHash := header.BlockHash()
+	if err := header.Pow.Verify(headerData, blockHash, job.shareBits); err != nil {
+		log.Debug("Stratum share rejected", "worker", c.worker,
+			"error", err)
+		return nil, &stratumError{stratumErrLowDifficulty, "Low difficulty share"}
+	}
+	log.Debug("Stratum share accepted", "worker", c.worker, "hash", blockHash)
+
+	if header.Pow.Verify(headerData, blockHash, header.Difficulty) != nil {
+		return true, nil
+	}
+
+	// The share is a solution of the block.
+	msgBlock := *job.block
+	msgBlock.Header = header
+	block := types.NewBlock(&msgBlock)
+	block.SetHeight(uint(job.height))
+	if m.submitBlock(block) {
+		log.Info("Stratum worker found a block", "worker", c.worker,
+			"hash", blockHash)
+	}
+	return true, nil
+}
+
+// sendJob hands out the passed template to the client as a new job, with the
+// next extra nonce of its range.  The previous jobs are cleared when clean is
+// set.
+//
+// This function MUST be called with the server locked.
+func (c *stratumClient) sendJob(template *types.BlockTemplate, clean bool) {
+	if !c.authorized || template == nil {
+		return
+	}
+	job, err := c.newJob(template)
+	if err != nil {
+		log.Error("Failed to create stratum job", "worker", c.worker,
+			"error", err)
+		return
+	}
+	if clean {
+		c.jobs = nil
+	}
+	if len(c.jobs) >= maxStratumJobs {
+		c.jobs = c.jobs[1:]
+	}
+	c.jobs = append(c.jobs, job)
+
+	headerData := job.block.Header.BlockData()[:stratumHeaderLen]
+	c.queueMessage(&stratumNotification{
+		Method: "mining.notify",
+		Params: []interface{}{job.id, hex.EncodeToString(headerData), clean},
+	})
+}
+
+// newJob returns a new job of the client for the passed template.
+//
+// This function MUST be called with the server locked.
+func (c *stratumClient) newJob(template *types.BlockTemplate) (*stratumJob, error) {
+	m := c.server.miner
+	msgBlock := types.NewBlockDeepCopyCoinbase(template.Block).Block()
+	c.jobID++
+	extraNonce := uint64(c.extraNonce1)<<32 | uint64(c.jobID)
+	err := m.updateExtraNonce(msgBlock, template.Height, extraNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// The share target is the network minimum difficulty scaled by the
+	// share difficulty, but never harder than the block target.
+	header := &msgBlock.Header
+	header.Pow = pow.GetInstance(c.powType, 0, []byte{})
+	powConfig := m.params.PowConfig
+	shareDiff := new(big.Float).SetFloat64(c.server.shareDiff)
+	var shareTarget *big.Int
+	switch c.powType {
+	case pow.BLAKE2BD:
+		header.Difficulty = template.PowDiffData.Blake2bDTarget
+		blockTarget := pow.CompactToBig(header.Difficulty)
+		shareTarget, _ = new(big.Float).Quo(new(big.Float).SetInt(
+			powConfig.Blake2bdPowLimit), shareDiff).Int(nil)
+		if shareTarget.Cmp(blockTarget) < 0 {
+			shareTarget = blockTarget
+		}
+	default:
+		blockDiff := template.PowDiffData.CuckarooBaseDiff
+		minDiff := powConfig.CuckarooMinDifficulty
+		if c.powType == pow.CUCKATOO {
+			blockDiff = template.PowDiffData.CuckatooBaseDiff
+			minDiff = powConfig.CuckatooMinDifficulty
+		}
+		blockTarget := new(big.Int).SetUint64(blockDiff)
+		header.Difficulty = pow.BigToCompact(blockTarget)
+		shareTarget, _ = new(big.Float).Mul(new(big.Float).SetInt(
+			pow.CompactToBig(minDiff)), shareDiff).Int(nil)
+		if shareTarget.Cmp(blockTarget) > 0 {
+			shareTarget = blockTarget
+		}
+	}
+
+	return &stratumJob{
+		id:        strconv.FormatUint(uint64(c.jobID), 16),
+		block:     msgBlock,
+		height:    template.Height,
+		shareBits: pow.BigToCompact(shareTarget),
+		shares:    make(map[[8]byte]struct{}),
+	}, nil
+}
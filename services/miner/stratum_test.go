@@ -0,0 +1,453 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package miner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/pow"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testBlockTarget is a blake2bd block target no share can solve, so the
+// shares are never submitted as blocks.
+const testBlockTarget = 0x03000001
+
+// newTestStratumServer returns a stratum server of the privnet handing out the
+// passed template, which doesn't listen for connections.
+func newTestStratumServer(shareDiff float64, template *types.BlockTemplate) *StratumServer {
+	return &StratumServer{
+		miner: &CPUMiner{
+			params:     &params.PrivNetParams,
+			timeSource: blockchain.NewMedianTime(),
+		},
+		shareDiff:     shareDiff,
+		template:      template,
+		clients:       make(map[*stratumClient]struct{}),
+		blockAccepted: make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+	}
+}
+
+// newTestStratumClient returns a subscribed client of the server mining the
+// passed proof of work, on a connection closed by the returned function.
+func newTestStratumClient(s *StratumServer, powType pow.PowType) (*stratumClient, func()) {
+	conn, remote := net.Pipe()
+	c := &stratumClient{
+		server:      s,
+		conn:        conn,
+		outChan:     make(chan interface{}, stratumOutQueueSize),
+		quit:        make(chan struct{}),
+		subscribed:  true,
+		powType:     powType,
+		extraNonce1: s.nextExtraNonce1(),
+	}
+	return c, func() {
+		conn.Close()
+		remote.Close()
+	}
+}
+
+// newTestTemplate returns a block template with a coinbase and the passed
+// difficulties.
+func newTestTemplate(diffData types.PowDiffStandard) *types.BlockTemplate {
+	coinbase := types.NewTransaction()
+	coinbase.AddTxIn(&types.TxInput{
+		PreviousOut: *types.NewOutPoint(&hash.ZeroHash,
+			types.MaxPrevOutIndex),
+		Sequence:   types.MaxTxInSequenceNum,
+		SignScript: []byte{},
+	})
+	coinbase.AddTxOut(types.NewTxOutput(1e8, []byte{txscript.OP_TRUE}))
+	block := &types.Block{
+		Header: types.BlockHeader{
+			Version:    8,
+			Timestamp:  time.Now().Truncate(time.Second),
+			Difficulty: diffData.Blake2bDTarget,
+			Pow:        pow.GetInstance(pow.BLAKE2BD, 0, []byte{}),
+		},
+		Parents:      []*hash.Hash{params.PrivNetParams.GenesisHash},
+		Transactions: []*types.Transaction{coinbase},
+	}
+	return &types.BlockTemplate{
+		Block:       block,
+		Height:      1,
+		PowDiffData: diffData,
+	}
+}
+
+// stratumParams returns the JSON encoded parameters of a request.
+func stratumParams(args ...interface{}) []json.RawMessage {
+	params := make([]json.RawMessage, 0, len(args))
+	for _, arg := range args {
+		serialized, _ := json.Marshal(arg)
+		params = append(params, serialized)
+	}
+	return params
+}
+
+// submitParams returns the parameters of a mining.submit request for the
+// job with the passed ntime and nonce.
+func submitParams(job *stratumJob, ntime int64, nonce uint32) []json.RawMessage {
+	return stratumParams("worker", job.id, "", fmt.Sprintf("%08x", ntime),
+		fmt.Sprintf("%08x", nonce))
+}
+
+// findNonce returns the first blake2bd nonce for which the header of the job
+// at the passed ntime meets the share target, or misses it when meets is
+// false.
+func findNonce(t *testing.T, job *stratumJob, ntime int64, meets bool) uint32 {
+	header := job.block.Header
+	header.Timestamp = time.Unix(ntime, 0)
+	for nonce := uint32(0); nonce < 1000; nonce++ {
+		header.Pow = pow.GetInstance(pow.BLAKE2BD, nonce, []byte{})
+		header.Pow.SetParams(params.PrivNetParams.PowConfig)
+		err := header.Pow.Verify(header.BlockData(), header.BlockHash(),
+			job.shareBits)
+		if (err == nil) == meets {
+			return nonce
+		}
+	}
+	t.Fatalf("No nonce found meeting the share target: %v", meets)
+	return 0
+}
+
+// checkStratumError fails the test when the error doesn't have the passed
+// code, or when an error is returned while code is zero.
+func checkStratumError(t *testing.T, name string, err *stratumError, code int) {
+	if code == 0 {
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		return
+	}
+	if err == nil || err.code != code {
+		t.Errorf("%s: got error %v, want code %d", name, err, code)
+	}
+}
+
+// TestStratumShareTarget checks the share targets of the jobs, which are the
+// minimum difficulty of the network scaled by the share difficulty, but never
+// harder than the block target.
+func TestStratumShareTarget(t *testing.T) {
+	powConfig := params.PrivNetParams.PowConfig
+	powLimitBits := pow.BigToCompact(powConfig.Blake2bdPowLimit)
+	quarterLimitBits := pow.BigToCompact(new(big.Int).Rsh(
+		powConfig.Blake2bdPowLimit, 2))
+	// The minimum cuckaroo and cuckatoo difficulties of the privnet are
+	// 48.
+	minCuckoo := pow.CompactToBig(powConfig.CuckarooMinDifficulty).Uint64()
+	if minCuckoo != 48 {
+		t.Fatalf("Got minimum cuckaroo difficulty %d, want 48", minCuckoo)
+	}
+	cuckooBits := func(diff uint64) uint32 {
+		return pow.BigToCompact(new(big.Int).SetUint64(diff))
+	}
+
+	tests := []struct {
+		name          string
+		powType       pow.PowType
+		shareDiff     float64
+		diffData      types.PowDiffStandard
+		wantBlockBits uint32
+		wantShareBits uint32
+	}{
+		{
+			name:          "blake2bd minimum difficulty",
+			powType:       pow.BLAKE2BD,
+			shareDiff:     1,
+			diffData:      types.PowDiffStandard{Blake2bDTarget: testBlockTarget},
+			wantBlockBits: testBlockTarget,
+			wantShareBits: powLimitBits,
+		},
+		{
+			name:          "blake2bd scaled difficulty",
+			powType:       pow.BLAKE2BD,
+			shareDiff:     4,
+			diffData:      types.PowDiffStandard{Blake2bDTarget: testBlockTarget},
+			wantBlockBits: testBlockTarget,
+			wantShareBits: quarterLimitBits,
+		},
+		{
+			name:          "blake2bd capped by the block target",
+			powType:       pow.BLAKE2BD,
+			shareDiff:     16,
+			diffData:      types.PowDiffStandard{Blake2bDTarget: quarterLimitBits},
+			wantBlockBits: quarterLimitBits,
+			wantShareBits: quarterLimitBits,
+		},
+		{
+			name:          "cuckaroo scaled difficulty",
+			powType:       pow.CUCKAROO,
+			shareDiff:     4,
+			diffData:      types.PowDiffStandard{CuckarooBaseDiff: 10000},
+			wantBlockBits: cuckooBits(10000),
+			wantShareBits: cuckooBits(4 * minCuckoo),
+		},
+		{
+			name:          "cuckaroo capped by the block difficulty",
+			powType:       pow.CUCKAROO,
+			shareDiff:     1000,
+			diffData:      types.PowDiffStandard{CuckarooBaseDiff: 10000},
+			wantBlockBits: cuckooBits(10000),
+			wantShareBits: cuckooBits(10000),
+		},
+		{
+			name:      "cuckatoo scaled difficulty",
+			powType:   pow.CUCKATOO,
+			shareDiff: 2,
+			diffData: types.PowDiffStandard{CuckarooBaseDiff: 10,
+				CuckatooBaseDiff: 10000},
+			wantBlockBits: cuckooBits(10000),
+			wantShareBits: cuckooBits(2 * minCuckoo),
+		},
+	}
+
+	for _, test := range tests {
+		s := newTestStratumServer(test.shareDiff, nil)
+		c, closeConn := newTestStratumClient(s, test.powType)
+		job, err := c.newJob(newTestTemplate(test.diffData))
+		closeConn()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		header := &job.block.Header
+		if header.Difficulty != test.wantBlockBits {
+			t.Errorf("%s: got block difficulty %08x, want %08x", test.name,
+				header.Difficulty, test.wantBlockBits)
+		}
+		if job.shareBits != test.wantShareBits {
+			t.Errorf("%s: got share target %08x, want %08x", test.name,
+				job.shareBits, test.wantShareBits)
+		}
+		if header.Pow.GetPowType() != test.powType {
+			t.Errorf("%s: got pow %v, want %v", test.name,
+				header.Pow.GetPowType(), test.powType)
+		}
+	}
+}
+
+// TestStratumAuthorize checks the parsing of the worker and of the proof of
+// work option of the password, and the check of the password when the server
+// has one.
+func TestStratumAuthorize(t *testing.T) {
+	tests := []struct {
+		name        string
+		unsubscribe bool
+		serverPass  string
+		params      []json.RawMessage
+		wantPow     pow.PowType
+		wantCode    int
+	}{
+		{
+			name:    "default pow",
+			params:  stratumParams("worker"),
+			wantPow: pow.BLAKE2BD,
+		},
+		{
+			name:    "cuckaroo",
+			params:  stratumParams("worker", "pow=cuckaroo"),
+			wantPow: pow.CUCKAROO,
+		},
+		{
+			name:    "other options",
+			params:  stratumParams("worker", "d=8, pow=cuckatoo,x"),
+			wantPow: pow.CUCKATOO,
+		},
+		{
+			name:     "unknown pow",
+			params:   stratumParams("worker", "pow=sha256d"),
+			wantCode: stratumErrOther,
+		},
+		{
+			name:     "missing worker",
+			params:   stratumParams(),
+			wantCode: stratumErrOther,
+		},
+		{
+			name:     "invalid worker",
+			params:   stratumParams(5, "pow=cuckaroo"),
+			wantCode: stratumErrOther,
+		},
+		{
+			name:        "not subscribed",
+			unsubscribe: true,
+			params:      stratumParams("worker"),
+			wantCode:    stratumErrNotSubscribed,
+		},
+		{
+			name:       "password",
+			serverPass: "secret",
+			params:     stratumParams("worker", "secret"),
+			wantPow:    pow.BLAKE2BD,
+		},
+		{
+			name:       "password and pow",
+			serverPass: "secret",
+			params:     stratumParams("worker", "pow=cuckaroo,secret"),
+			wantPow:    pow.CUCKAROO,
+		},
+		{
+			name:       "wrong password",
+			serverPass: "secret",
+			params:     stratumParams("worker", "secret2,pow=cuckaroo"),
+			wantCode:   stratumErrUnauthorized,
+		},
+		{
+			name:       "missing password",
+			serverPass: "secret",
+			params:     stratumParams("worker", "pow=cuckaroo"),
+			wantCode:   stratumErrUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		template := newTestTemplate(types.PowDiffStandard{
+			Blake2bDTarget: testBlockTarget})
+		s := newTestStratumServer(1, template)
+		if test.serverPass != "" {
+			passSha := sha256.Sum256([]byte(test.serverPass))
+			s.passSha = &passSha
+		}
+		c, closeConn := newTestStratumClient(s, pow.BLAKE2BD)
+		c.subscribed = !test.unsubscribe
+		result, err := c.handleAuthorize(test.params)
+		closeConn()
+		checkStratumError(t, test.name, err, test.wantCode)
+		if test.wantCode != 0 {
+			if c.authorized {
+				t.Errorf("%s: worker was authorized", test.name)
+			}
+			continue
+		}
+		if result != true || !c.authorized || c.worker != "worker" {
+			t.Errorf("%s: worker wasn't authorized", test.name)
+		}
+		if c.powType != test.wantPow {
+			t.Errorf("%s: got pow %v, want %v", test.name, c.powType,
+				test.wantPow)
+		}
+	}
+}
+
+// TestStratumSubmit checks the validation of the shares submitted by the
+// miners, including the parsing of their parameters and the rejection of the
+// duplicate, low difficulty and stale shares.
+func TestStratumSubmit(t *testing.T) {
+	template := newTestTemplate(types.PowDiffStandard{
+		Blake2bDTarget: testBlockTarget, CuckarooBaseDiff: 10000})
+	s := newTestStratumServer(2, template)
+	c, closeConn := newTestStratumClient(s, pow.BLAKE2BD)
+	defer closeConn()
+	c.authorized = true
+	c.sendJob(template, true)
+	job := c.jobs[0]
+	ntime := job.block.Header.Timestamp.Unix()
+
+	// A share of a worker which isn't authorized is rejected.
+	unauthorized, closeUnauthorized := newTestStratumClient(s, pow.BLAKE2BD)
+	_, err := unauthorized.handleSubmit(submitParams(job, ntime, 0))
+	closeUnauthorized()
+	checkStratumError(t, "unauthorized", err, stratumErrUnauthorized)
+
+	// The shares whose parameters are invalid are rejected without being
+	// recorded.
+	good := findNonce(t, job, ntime, true)
+	tests := []struct {
+		name   string
+		params []json.RawMessage
+	}{
+		{"too few parameters", submitParams(job, ntime, good)[:4]},
+		{"non string parameter", stratumParams("worker", 1, "",
+			fmt.Sprintf("%08x", ntime), fmt.Sprintf("%08x", good))},
+		{"short ntime", stratumParams("worker", job.id, "", "1234",
+			fmt.Sprintf("%08x", good))},
+		{"invalid ntime", stratumParams("worker", job.id, "", "zzzzzzzz",
+			fmt.Sprintf("%08x", good))},
+		{"long nonce", stratumParams("worker", job.id, "",
+			fmt.Sprintf("%08x", ntime), "000000001")},
+		{"invalid nonce", stratumParams("worker", job.id, "",
+			fmt.Sprintf("%08x", ntime), "0000000g")},
+		{"ntime before the job", submitParams(job, ntime-1, good)},
+		{"ntime too far ahead", submitParams(job,
+			ntime+2*blockchain.MaxTimeOffsetSeconds, good)},
+	}
+	for _, test := range tests {
+		_, err := c.handleSubmit(test.params)
+		checkStratumError(t, test.name, err, stratumErrOther)
+	}
+
+	// The shares missing the share target are rejected.
+	bad := findNonce(t, job, ntime, false)
+	_, err = c.handleSubmit(submitParams(job, ntime, bad))
+	checkStratumError(t, "low difficulty", err, stratumErrLowDifficulty)
+
+	// A share meeting the share target is accepted once.
+	result, err := c.handleSubmit(submitParams(job, ntime, good))
+	checkStratumError(t, "valid share", err, 0)
+	if result != true {
+		t.Errorf("Valid share got result %v", result)
+	}
+	_, err = c.handleSubmit(submitParams(job, ntime, good))
+	checkStratumError(t, "duplicate", err, stratumErrDuplicateShare)
+	_, err = c.handleSubmit(submitParams(job, ntime, bad))
+	checkStratumError(t, "duplicate low difficulty", err,
+		stratumErrDuplicateShare)
+
+	// The same nonce at a later ntime is another share.
+	later := findNonce(t, job, ntime+1, true)
+	_, err = c.handleSubmit(submitParams(job, ntime+1, later))
+	checkStratumError(t, "later ntime", err, 0)
+
+	// The jobs older than the last maxStratumJobs ones are stale, and so
+	// are all the jobs once a clean job is sent.
+	for i := 0; i < maxStratumJobs; i++ {
+		c.sendJob(template, false)
+	}
+	for len(c.outChan) > 0 {
+		<-c.outChan
+	}
+	if len(c.jobs) != maxStratumJobs {
+		t.Fatalf("Client has %d jobs, want %d", len(c.jobs), maxStratumJobs)
+	}
+	_, err = c.handleSubmit(submitParams(job, ntime, findNonce(t, job, ntime,
+		true)))
+	checkStratumError(t, "stale job", err, stratumErrJobNotFound)
+	kept := c.jobs[0]
+	keptNonce := findNonce(t, kept, ntime, true)
+	_, err = c.handleSubmit(submitParams(kept, ntime, keptNonce))
+	checkStratumError(t, "kept job", err, 0)
+	c.sendJob(template, true)
+	_, err = c.handleSubmit(submitParams(kept, ntime, keptNonce+1))
+	checkStratumError(t, "job cleared", err, stratumErrJobNotFound)
+
+	// The cuckaroo shares must have proof data.
+	c.powType = pow.CUCKAROO
+	c.sendJob(template, true)
+	job = c.jobs[0]
+	for _, proof := range []string{"", "00", "zz"} {
+		params := append(submitParams(job, ntime, 1),
+			stratumParams(proof)...)
+		_, err = c.handleSubmit(params)
+		checkStratumError(t, "proof data "+proof, err, stratumErrOther)
+	}
+	// The malformed shares weren't recorded, so the share with the same
+	// nonce is verified.
+	proof := hex.EncodeToString(make([]byte, pow.PROOFDATA_LENGTH))
+	params := append(submitParams(job, ntime, 1), stratumParams(proof)...)
+	_, err = c.handleSubmit(params)
+	checkStratumError(t, "invalid proof", err, stratumErrLowDifficulty)
+}
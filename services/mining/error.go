@@ -64,6 +64,10 @@ const (
 
 	// ErrFetchTxStore indicates a transaction store failed to fetch.
 	ErrFetchTxStore
+
+	// ErrGettingBlockVersion indicates that there was an error calculating
+	// the version of the block from the rule change deployments.
+	ErrGettingBlockVersion
 )
 
 // Map of MiningErrorCode values back to their constant names for pretty printing.
@@ -81,6 +85,7 @@ var miningErrorCodeStrings = map[MiningErrorCode]string{
 	ErrCoinbaseLengthOverflow: "ErrCoinbaseLengthOverflow",
 	ErrFraudProofIndex:        "ErrFraudProofIndex",
 	ErrFetchTxStore:           "ErrFetchTxStore",
+	ErrGettingBlockVersion:    "ErrGettingBlockVersion",
 }
 
 // String returns the MiningErrorCode as a human-readable name.
@@ -126,6 +126,15 @@ func NewBlockTemplate(policy *Policy, params *params.Params,
 	parentsSet.AddList(parents)
 
 	blues := int64(blockManager.GetChain().BlockDAG().GetBlues(parentsSet))
+
+	// The block is on the layer after the highest layer of its parents,
+	// which determines whether its transactions are expired.
+	parentsLayer, ok := blockManager.GetChain().BlockDAG().GetParentsMaxLayer(parentsSet)
+	if !ok {
+		return nil, fmt.Errorf("unknown parents of the block template")
+	}
+	nextExpiryPoint := blockchain.ExpiryPoint(nextBlockHeight,
+		uint64(parentsLayer+1), params)
 	coinbaseTx, err := createCoinbaseTx(subsidyCache,
 		coinbaseScript,
 		opReturnPkScript,
@@ -193,6 +202,10 @@ mempoolLoop:
 			log.Trace(fmt.Sprintf("Skipping non-finalized tx %s", tx.Hash()))
 			continue
 		}
+		if blockchain.IsExpired(tx, nextExpiryPoint) {
+			log.Trace(fmt.Sprintf("Skipping expired tx %s", tx.Hash()))
+			continue
+		}
 
 		// Fetch all of the utxos referenced by the this transaction.
 		// NOTE: This intentionally does not fetch inputs from the
@@ -249,8 +262,10 @@ mempoolLoop:
 		prioItem.priority = mempool.CalcPriority(tx.Tx, utxos,
 			nextBlockHeight, blockManager.GetChain().BlockDAG())
 
-		// Calculate the fee in Satoshi/kB.
-		prioItem.feePerKB = txDesc.FeePerKB
+		// Use the fee in Satoshi/kB of the package of the transaction
+		// and its descendants, so children paying high fees get their
+		// parents included.
+		prioItem.feePerKB = txDesc.PackageFeePerKB
 		prioItem.fee = txDesc.Fee
 
 		// Add the transaction to the priority queue to mark it ready
@@ -427,8 +442,12 @@ mempoolLoop:
 		return nil, miningRuleError(ErrGettingDifficulty, err.Error())
 	}
 
-	// Choose the block version to generate based on the network.
-	blockVersion := BlockVersion(params.Net)
+	// Choose the block version to generate based on the network and the
+	// state of the rule change deployments.
+	blockVersion, err := blockManager.GetChain().CalcNextBlockVersion()
+	if err != nil {
+		return nil, miningRuleError(ErrGettingBlockVersion, err.Error())
+	}
 
 	// Create a new block ready to be solved.
 	merkles := merkle.BuildMerkleTreeStore(blockTxns, false)
@@ -1,17 +1,81 @@
 package notifymgr
 
 import (
+	"github.com/Qitmeer/qitmeer/core/blockchain"
 	"github.com/Qitmeer/qitmeer/core/message"
 	"github.com/Qitmeer/qitmeer/core/types"
 	"github.com/Qitmeer/qitmeer/p2p/peerserver"
+	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/rpc"
 )
 
+// GbtNotifier is notified of the changes of the mempool so that the stale
+// block templates of the getblocktemplate long poll clients are replaced.
+type GbtNotifier interface {
+	NotifyMempoolTx()
+}
+
 // NotifyMgr manage message announce & relay & notification between mempool, websocket, gbt long pull
 // and rpc server.
 type NotifyMgr struct {
-	Server    *peerserver.PeerServer
-	RpcServer *rpc.RpcServer
+	Server      *peerserver.PeerServer
+	RpcServer   *rpc.RpcServer
+	GbtNotifier GbtNotifier
+
+	// subscriptions of the websocket clients, nil when the rpc server is
+	// disabled.
+	subs *subscriptions
+}
+
+// New returns a notify manager relaying to the peer server and pushing
+// notifications to the websocket clients of the rpc server.
+func New(server *peerserver.PeerServer, rpcServer *rpc.RpcServer, params *params.Params) *NotifyMgr {
+	ntmgr := &NotifyMgr{
+		Server:    server,
+		RpcServer: rpcServer,
+	}
+	if rpcServer != nil {
+		ntmgr.subs = newSubscriptions(params)
+	}
+	return ntmgr
+}
+
+func (ntmgr *NotifyMgr) Start() {
+	if ntmgr.subs != nil {
+		ntmgr.subs.Start()
+	}
+}
+
+func (ntmgr *NotifyMgr) Stop() {
+	if ntmgr.subs != nil {
+		ntmgr.subs.Stop()
+	}
+}
+
+func (ntmgr *NotifyMgr) APIs() []rpc.API {
+	if ntmgr.subs == nil {
+		return nil
+	}
+	return []rpc.API{
+		{
+			NameSpace: rpc.DefaultServiceNameSpace,
+			Service:   NewPublicSubscribeAPI(ntmgr.subs),
+			Public:    true,
+		},
+	}
+}
+
+// HandleChainNotification queues the block chain notifications for the
+// websocket clients.  It is registered as a subscriber of the block manager.
+func (ntmgr *NotifyMgr) HandleChainNotification(notification *blockchain.Notification) {
+	if ntmgr.subs == nil {
+		return
+	}
+	switch notification.Type {
+	case blockchain.BlockAccepted, blockchain.BlockConnected,
+		blockchain.BlockDisconnected:
+		ntmgr.subs.queue(notification)
+	}
 }
 
 // AnnounceNewTransactions generates and relays inventory vectors and notifies
@@ -27,16 +91,17 @@ func (ntmgr *NotifyMgr) AnnounceNewTransactions(newTxs []*types.Tx) {
 		iv := message.NewInvVect(message.InvTypeTx, tx.Hash())
 		// reply to p2p
 		ntmgr.RelayInventory(iv, tx)
-		// reply to rpc
-		if ntmgr.RpcServer != nil {
-			//TODO reply to rpc layer (if websockect long connection or gbt long poll)
-			// Notify websocket clients about mempool transactions.
-			//qitmeer.node.rpcServer.ntfnMgr.NotifyMempoolTx(tx, true)
-			//
-			// Potentially notify any getblocktemplate long poll clients
-			// about stale block templates due to the new transaction.
-			//qitmeer.node.rpcServer.gbtWorkState.NotifyMempoolTx(
-			//	qitmeer.txMemPool.LastUpdated())
+	}
+	// reply to rpc
+	if ntmgr.RpcServer != nil {
+		// Notify websocket clients about mempool transactions.
+		if ntmgr.subs != nil && len(newTxs) > 0 {
+			ntmgr.subs.queue(mempoolTxsNtfn(newTxs))
+		}
+		// Potentially notify any getblocktemplate long poll clients
+		// about stale block templates due to the new transaction.
+		if ntmgr.GbtNotifier != nil {
+			ntmgr.GbtNotifier.NotifyMempoolTx()
 		}
 	}
 }
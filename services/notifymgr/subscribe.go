@@ -0,0 +1,463 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package notifymgr
+
+import (
+	"context"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/marshal"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptionType identifies the kind of events a websocket client
+// subscribed to.
+type subscriptionType int
+
+const (
+	subNewBlocks subscriptionType = iota
+	subBlockOrders
+	subNewTransactions
+	subAddressTransactions
+)
+
+// maxSubscriptionOutPoints is the maximum number of outputs remembered by an
+// addressTransactions subscription, which bounds its memory when the outputs
+// paying to the addresses are never spent.
+const maxSubscriptionOutPoints = 100000
+
+// subscription is a single subscription of a websocket client.
+type subscription struct {
+	typ      subscriptionType
+	id       rpc.ID
+	notifier *rpc.Notifier
+
+	// verbose is used by the newTransactions subscription to send the
+	// full transactions instead of their ids.
+	verbose bool
+
+	// addrs and outpoints are used by the addressTransactions subscription.
+	// outpoints keeps the unspent outputs paying to one of the addresses, so
+	// the transactions spending them are sent too.  The outputs are removed
+	// when a connected block spends them.
+	addrs     map[string]struct{}
+	outpoints map[types.TxOutPoint]struct{}
+}
+
+// addOutPoint remembers an output paying to one of the addresses of the
+// subscription.  An arbitrary output is forgotten when the subscription
+// already keeps maxSubscriptionOutPoints outputs.
+func (s *subscription) addOutPoint(op types.TxOutPoint) {
+	if _, ok := s.outpoints[op]; ok {
+		return
+	}
+	if len(s.outpoints) >= maxSubscriptionOutPoints {
+		for evict := range s.outpoints {
+			delete(s.outpoints, evict)
+			break
+		}
+	}
+	s.outpoints[op] = struct{}{}
+}
+
+// notify sends the data to the client of the subscription.
+func (s *subscription) notify(data interface{}) {
+	if err := s.notifier.Notify(s.id, data); err != nil {
+		log.Debug("Failed to send notification", "id", s.id, "error", err)
+	}
+}
+
+// mempoolTxsNtfn is queued when new transactions were accepted into the
+// memory pool.
+type mempoolTxsNtfn []*types.Tx
+
+// subscriptions dispatches the block chain and memory pool events to the
+// websocket clients which subscribed to them.  The events are handled in a
+// separate goroutine, so slow clients never block the block manager or the
+// memory pool.
+type subscriptions struct {
+	started  int32
+	shutdown int32
+
+	params *params.Params
+
+	mtx  sync.Mutex
+	subs map[rpc.ID]*subscription
+
+	queueNotification chan interface{}
+	notificationMsgs  chan interface{}
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+func newSubscriptions(params *params.Params) *subscriptions {
+	return &subscriptions{
+		params:            params,
+		subs:              make(map[rpc.ID]*subscription),
+		queueNotification: make(chan interface{}),
+		notificationMsgs:  make(chan interface{}),
+		quit:              make(chan struct{}),
+	}
+}
+
+// Start begins the goroutines which handle the queued notifications.
+func (s *subscriptions) Start() {
+	if atomic.AddInt32(&s.started, 1) != 1 {
+		return
+	}
+	s.wg.Add(2)
+	go func() {
+		queueHandler(s.queueNotification, s.notificationMsgs, s.quit)
+		s.wg.Done()
+	}()
+	go s.notificationHandler()
+}
+
+// Stop shuts down the notification handlers and waits for them to finish.
+func (s *subscriptions) Stop() {
+	if atomic.AddInt32(&s.shutdown, 1) != 1 {
+		return
+	}
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// queue adds a notification to the handling queue unless there are no
+// subscriptions at all.
+func (s *subscriptions) queue(n interface{}) {
+	s.mtx.Lock()
+	empty := len(s.subs) == 0
+	s.mtx.Unlock()
+	if empty || atomic.LoadInt32(&s.started) == 0 {
+		return
+	}
+	select {
+	case s.queueNotification <- n:
+	case <-s.quit:
+	}
+}
+
+// queueHandler manages a queue of empty interfaces, reading from in and
+// sending the oldest unsent to out.  This handler stops when either of the
+// in or quit channels are closed, and closes out before returning, without
+// waiting to send any variables still remaining in the queue.
+func queueHandler(in <-chan interface{}, out chan<- interface{}, quit <-chan struct{}) {
+	var q []interface{}
+	var dequeue chan<- interface{}
+	skipQueue := out
+	var next interface{}
+out:
+	for {
+		select {
+		case n, ok := <-in:
+			if !ok {
+				// Sender closed input channel.
+				break out
+			}
+
+			// Either send to out immediately if skipQueue is
+			// non-nil (queue is empty) and reader is ready,
+			// or append to the queue and send later.
+			select {
+			case skipQueue <- n:
+			default:
+				q = append(q, n)
+				dequeue = out
+				skipQueue = nil
+				next = q[0]
+			}
+
+		case dequeue <- next:
+			copy(q, q[1:])
+			q[len(q)-1] = nil // avoid leak
+			q = q[:len(q)-1]
+			if len(q) == 0 {
+				dequeue = nil
+				skipQueue = out
+			} else {
+				next = q[0]
+			}
+
+		case <-quit:
+			break out
+		}
+	}
+	close(out)
+}
+
+// notificationHandler reads the queued notifications and sends them to the
+// subscribed clients.  It must be run as a goroutine.
+func (s *subscriptions) notificationHandler() {
+	defer s.wg.Done()
+
+	for n := range s.notificationMsgs {
+		switch n := n.(type) {
+		case *blockchain.Notification:
+			s.handleChainNotification(n)
+		case mempoolTxsNtfn:
+			for _, tx := range n {
+				s.notifyMempoolTx(tx)
+			}
+		default:
+			log.Warn(fmt.Sprintf("Unhandled notification %T", n))
+		}
+	}
+}
+
+// handleChainNotification sends the block chain events to the clients.
+func (s *subscriptions) handleChainNotification(n *blockchain.Notification) {
+	switch n.Type {
+	case blockchain.BlockAccepted:
+		band, ok := n.Data.(*blockchain.BlockAcceptedNotifyData)
+		if !ok {
+			log.Warn("Chain accepted notification is not BlockAcceptedNotifyData.")
+			return
+		}
+		s.notifyBlockAccepted(band.Block)
+
+	case blockchain.BlockConnected:
+		blockSlice, ok := n.Data.([]*types.SerializedBlock)
+		if !ok || len(blockSlice) != 1 {
+			log.Warn("Chain connected notification is not a block slice.")
+			return
+		}
+		s.notifyBlockOrder(blockSlice[0], true)
+		s.notifyBlockTxs(blockSlice[0])
+
+	case blockchain.BlockDisconnected:
+		block, ok := n.Data.(*types.SerializedBlock)
+		if !ok {
+			log.Warn("Chain disconnected notification is not a block.")
+			return
+		}
+		s.notifyBlockOrder(block, false)
+	}
+}
+
+// forEach calls f for every subscription of the given type.
+func (s *subscriptions) forEach(typ subscriptionType, f func(sub *subscription)) {
+	s.mtx.Lock()
+	subs := make([]*subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		if sub.typ == typ {
+			subs = append(subs, sub)
+		}
+	}
+	s.mtx.Unlock()
+
+	for _, sub := range subs {
+		f(sub)
+	}
+}
+
+func (s *subscriptions) notifyBlockAccepted(block *types.SerializedBlock) {
+	header := &block.Block().Header
+	parents := make([]string, len(block.Block().Parents))
+	for i, parent := range block.Block().Parents {
+		parents[i] = parent.String()
+	}
+	ntfn := &json.BlockNtfn{
+		Hash:       block.Hash().String(),
+		Height:     uint64(block.Height()),
+		Version:    header.Version,
+		Parents:    parents,
+		TxRoot:     header.TxRoot.String(),
+		StateRoot:  header.StateRoot.String(),
+		Difficulty: header.Difficulty,
+		Time:       header.Timestamp.Unix(),
+		TxCount:    len(block.Transactions()),
+	}
+	s.forEach(subNewBlocks, func(sub *subscription) {
+		sub.notify(ntfn)
+	})
+}
+
+func (s *subscriptions) notifyBlockOrder(block *types.SerializedBlock, connected bool) {
+	ntfn := &json.BlockOrderNtfn{
+		Hash:      block.Hash().String(),
+		Order:     block.Order(),
+		Connected: connected,
+	}
+	s.forEach(subBlockOrders, func(sub *subscription) {
+		sub.notify(ntfn)
+	})
+}
+
+func (s *subscriptions) notifyMempoolTx(tx *types.Tx) {
+	var verboseNtfn *json.TxRawResult
+	s.forEach(subNewTransactions, func(sub *subscription) {
+		if !sub.verbose {
+			sub.notify(tx.Hash().String())
+			return
+		}
+		if verboseNtfn == nil {
+			txr, err := marshal.MarshalJsonTx(tx, s.params, "", 0)
+			if err != nil {
+				log.Error("Failed to marshal transaction", "tx", tx.Hash(), "error", err)
+				return
+			}
+			verboseNtfn = &txr
+		}
+		sub.notify(verboseNtfn)
+	})
+	s.notifyAddressTx(tx, "", 0, false)
+}
+
+func (s *subscriptions) notifyBlockTxs(block *types.SerializedBlock) {
+	blockHash := block.Hash().String()
+	for _, tx := range block.Transactions() {
+		s.notifyAddressTx(tx, blockHash, block.Order(), true)
+	}
+}
+
+// notifyAddressTx sends the transaction to the addressTransactions
+// subscriptions watching any of the addresses it pays to or spends from.
+// The outputs spent by the transaction are forgotten when it is connected.
+func (s *subscriptions) notifyAddressTx(tx *types.Tx, blockHash string, order uint64, connected bool) {
+	var ntfn *json.AddressTxNtfn
+	s.forEach(subAddressTransactions, func(sub *subscription) {
+		if !s.isRelevant(sub, tx, connected) {
+			return
+		}
+		if ntfn == nil {
+			txr, err := marshal.MarshalJsonTx(tx, s.params, "", 0)
+			if err != nil {
+				log.Error("Failed to marshal transaction", "tx", tx.Hash(), "error", err)
+				return
+			}
+			ntfn = &json.AddressTxNtfn{Tx: txr, BlockHash: blockHash, Order: order}
+		}
+		sub.notify(ntfn)
+	})
+}
+
+// isRelevant returns whether the transaction pays to one of the addresses of
+// the subscription or spends an output which did.  The outputs paying to the
+// addresses are remembered, so the transactions spending them are found
+// later, and the spent outputs are removed when spent is set.
+func (s *subscriptions) isRelevant(sub *subscription, tx *types.Tx, spent bool) bool {
+	msgTx := tx.Transaction()
+	relevant := false
+	if !msgTx.IsCoinBase() {
+		for _, txIn := range msgTx.TxIn {
+			if _, ok := sub.outpoints[txIn.PreviousOut]; ok {
+				if spent {
+					delete(sub.outpoints, txIn.PreviousOut)
+				}
+				relevant = true
+			}
+		}
+	}
+	for i, txOut := range msgTx.TxOut {
+		_, addrs, _, _ := txscript.ExtractPkScriptAddrs(txOut.PkScript, s.params)
+		for _, addr := range addrs {
+			if _, ok := sub.addrs[addr.Encode()]; ok {
+				op := types.NewOutPoint(tx.Hash(), uint32(i))
+				sub.addOutPoint(*op)
+				relevant = true
+				break
+			}
+		}
+	}
+	return relevant
+}
+
+// subscribe registers a new subscription of the client of the context and
+// removes it again when the client unsubscribes or disconnects.
+func (s *subscriptions) subscribe(ctx context.Context, sub *subscription) (*rpc.Subscription, error) {
+	if atomic.LoadInt32(&s.started) == 0 || atomic.LoadInt32(&s.shutdown) != 0 {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+	sub.id = rpcSub.ID
+	sub.notifier = notifier
+
+	s.mtx.Lock()
+	s.subs[sub.id] = sub
+	s.mtx.Unlock()
+
+	go func() {
+		select {
+		case <-rpcSub.Err():
+		case <-notifier.Closed():
+		case <-s.quit:
+		}
+		s.mtx.Lock()
+		delete(s.subs, sub.id)
+		s.mtx.Unlock()
+	}()
+	return rpcSub, nil
+}
+
+// PublicSubscribeAPI provides the push notifications for websocket clients.
+// The subscriptions are created with qitmeer_subscribe and the notifications
+// are delivered as qitmeer_subscription messages.
+type PublicSubscribeAPI struct {
+	subs *subscriptions
+}
+
+func NewPublicSubscribeAPI(subs *subscriptions) *PublicSubscribeAPI {
+	return &PublicSubscribeAPI{subs}
+}
+
+// NewBlocks sends every block accepted into the block DAG.
+func (api *PublicSubscribeAPI) NewBlocks(ctx context.Context) (*rpc.Subscription, error) {
+	return api.subs.subscribe(ctx, &subscription{typ: subNewBlocks})
+}
+
+// BlockOrders sends the blocks connected to or disconnected from the DAG
+// order.
+func (api *PublicSubscribeAPI) BlockOrders(ctx context.Context) (*rpc.Subscription, error) {
+	return api.subs.subscribe(ctx, &subscription{typ: subBlockOrders})
+}
+
+// NewTransactions sends the ids of the transactions accepted into the memory
+// pool, or the full transactions when verbose is set.
+func (api *PublicSubscribeAPI) NewTransactions(ctx context.Context, verbose *bool) (*rpc.Subscription, error) {
+	sub := &subscription{typ: subNewTransactions}
+	if verbose != nil {
+		sub.verbose = *verbose
+	}
+	return api.subs.subscribe(ctx, sub)
+}
+
+// AddressTransactions sends the memory pool and block transactions paying to
+// one of the addresses or spending an output which paid to them.
+func (api *PublicSubscribeAPI) AddressTransactions(ctx context.Context, addrs []string) (*rpc.Subscription, error) {
+	if len(addrs) == 0 {
+		return nil, rpc.RpcInvalidError("No addresses")
+	}
+	sub := &subscription{
+		typ:       subAddressTransactions,
+		addrs:     make(map[string]struct{}, len(addrs)),
+		outpoints: make(map[types.TxOutPoint]struct{}),
+	}
+	for _, encodedAddr := range addrs {
+		addr, err := address.DecodeAddress(encodedAddr)
+		if err != nil {
+			return nil, rpc.RpcAddressKeyError("Could not decode "+
+				"address: %v", err)
+		}
+		if !address.IsForNetwork(addr, api.subs.params) {
+			return nil, rpc.RpcAddressKeyError("Wrong network: %v",
+				addr)
+		}
+		sub.addrs[addr.Encode()] = struct{}{}
+	}
+	return api.subs.subscribe(ctx, sub)
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package notifymgr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/config"
+	"github.com/Qitmeer/qitmeer/core/address"
+	qjson "github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"golang.org/x/net/websocket"
+	"net"
+	"testing"
+	"time"
+)
+
+// freeListenAddr returns a local address no listener is bound to, which the
+// rpc server of the test listens on.
+func freeListenAddr(t *testing.T) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// testTx returns a transaction paying to a privnet address with the passed
+// hash byte, and the address.
+func testTx(t *testing.T, b byte) (*types.Tx, types.Address) {
+	pkHash := make([]byte, 20)
+	pkHash[0] = b
+	addr, err := address.NewPubKeyHashAddress(pkHash, &params.PrivNetParams,
+		ecc.ECDSA_Secp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := hash.DoubleHashH([]byte{b})
+	tx := types.NewTransaction()
+	tx.AddTxIn(types.NewTxInput(types.NewOutPoint(&prev, 0), nil))
+	tx.AddTxOut(types.NewTxOutput(100, pkScript))
+	return types.NewTx(tx), addr
+}
+
+// testMessage is a response or a notification read by the websocket client.
+type testMessage struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+// call sends the request and returns its response.  No notifications are
+// expected before the response.
+func call(t *testing.T, conn *websocket.Conn, id int, method string, params ...interface{}) *testMessage {
+	req := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  method,
+		"params":  params,
+	}
+	if err := websocket.JSON.Send(conn, req); err != nil {
+		t.Fatalf("Failed to send %s: %v", method, err)
+	}
+	var msg testMessage
+	if err := websocket.JSON.Receive(conn, &msg); err != nil {
+		t.Fatalf("Failed to receive the response of %s: %v", method, err)
+	}
+	if msg.Method != "" || msg.ID != id || msg.Error != nil {
+		t.Fatalf("Got response %+v to %s", msg, method)
+	}
+	return &msg
+}
+
+// subscribe creates the subscription and returns its id.
+func subscribe(t *testing.T, conn *websocket.Conn, id int, params ...interface{}) string {
+	resp := call(t, conn, id, rpc.DefaultServiceNameSpace+"_subscribe", params...)
+	var subID string
+	if err := json.Unmarshal(resp.Result, &subID); err != nil {
+		t.Fatalf("Got subscription %s: %v", resp.Result, err)
+	}
+	return subID
+}
+
+// waitSubscriptions fails the test unless the number of subscriptions drops
+// to the passed number.
+func waitSubscriptions(t *testing.T, subs *subscriptions, want int) {
+	for i := 0; ; i++ {
+		subs.mtx.Lock()
+		n := len(subs.subs)
+		subs.mtx.Unlock()
+		if n == want {
+			return
+		}
+		if i == 500 {
+			t.Fatalf("Got %d subscriptions, want %d", n, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSubscriptions checks that the websocket clients of the rpc server are
+// sent the memory pool transactions they subscribed to, and that their
+// subscriptions are removed when they unsubscribe or disconnect.
+func TestSubscriptions(t *testing.T) {
+	listenAddr := freeListenAddr(t)
+	rpcServer, err := rpc.NewRPCServer(&config.Config{
+		RPCListeners:     []string{listenAddr},
+		RPCUser:          "user",
+		RPCPass:          "pass",
+		RPCMaxWebsockets: 1,
+		DisableTLS:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ntmgr := New(nil, rpcServer, &params.PrivNetParams)
+	for _, api := range ntmgr.APIs() {
+		if err := rpcServer.RegisterService(api.NameSpace, api.Service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rpcServer.Start(); err != nil {
+		t.Fatalf("Failed to start the rpc server: %v", err)
+	}
+	defer rpcServer.Stop()
+	ntmgr.Start()
+	defer ntmgr.Stop()
+
+	cfg, err := websocket.NewConfig("ws://"+listenAddr, "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Header.Set("Authorization", "Basic "+
+		base64.StdEncoding.EncodeToString([]byte("user:pass")))
+	conn, err := websocket.DialConfig(cfg)
+	if err != nil {
+		t.Fatalf("Failed to connect to the rpc server: %v", err)
+	}
+	defer conn.Close()
+
+	txA, addrA := testTx(t, 1)
+	txB, _ := testTx(t, 2)
+	txsID := subscribe(t, conn, 1, "newTransactions")
+	addrID := subscribe(t, conn, 2, "addressTransactions",
+		[]string{addrA.Encode()})
+	waitSubscriptions(t, ntmgr.subs, 2)
+
+	// The subscriptions are activated once their ids were sent, so the
+	// transactions are queued until both are notified.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				ntmgr.subs.queue(mempoolTxsNtfn{txB, txA})
+			}
+		}
+	}()
+	var gotTxs, gotAddr bool
+	for !gotTxs || !gotAddr {
+		var msg testMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("Failed to receive the notifications: %v", err)
+		}
+		if msg.Method != rpc.DefaultServiceNameSpace+"_subscription" {
+			t.Fatalf("Got message %+v", msg)
+		}
+		switch msg.Params.Subscription {
+		case txsID:
+			var txHash string
+			if err := json.Unmarshal(msg.Params.Result, &txHash); err != nil {
+				t.Fatalf("Got transaction notification %s", msg.Params.Result)
+			}
+			if txHash != txA.Hash().String() && txHash != txB.Hash().String() {
+				t.Fatalf("Got transaction %s", txHash)
+			}
+			gotTxs = true
+		case addrID:
+			var ntfn qjson.AddressTxNtfn
+			if err := json.Unmarshal(msg.Params.Result, &ntfn); err != nil {
+				t.Fatalf("Got address notification %s", msg.Params.Result)
+			}
+			if ntfn.Tx.Txid != txA.Tx.TxHash().String() || ntfn.BlockHash != "" {
+				t.Fatalf("Got address notification of transaction %s in "+
+					"block %q, want %s", ntfn.Tx.Txid, ntfn.BlockHash, txA.Hash())
+			}
+			gotAddr = true
+		default:
+			t.Fatalf("Got notification of unknown subscription %s",
+				msg.Params.Subscription)
+		}
+	}
+
+	// The client unsubscribes from the transactions.  The notifications
+	// still queued are skipped until the response is read.
+	req := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      3,
+		"method":  rpc.DefaultServiceNameSpace + "_unsubscribe",
+		"params":  []string{txsID},
+	}
+	if err := websocket.JSON.Send(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var msg testMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			t.Fatalf("Failed to receive the unsubscribe response: %v", err)
+		}
+		if msg.Method != "" {
+			continue
+		}
+		if msg.ID != 3 || string(msg.Result) != "true" {
+			t.Fatalf("Got unsubscribe response %+v", msg)
+		}
+		break
+	}
+	waitSubscriptions(t, ntmgr.subs, 1)
+
+	// The remaining subscription is removed when the client disconnects.
+	conn.Close()
+	waitSubscriptions(t, ntmgr.subs, 0)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package tx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/core/address"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/rpc"
+	"github.com/Qitmeer/qitmeer/services/index"
+)
+
+// addrUtxoIndex returns the address utxo index and the decoded passed
+// address, which must be of the network of the node.
+func (api *PublicTxAPI) addrUtxoIndex(addr string) (*index.AddrUtxoIndex, types.Address, error) {
+	addrUtxoIndex := api.txManager.addrUtxoIndex
+	if addrUtxoIndex == nil {
+		return nil, nil, fmt.Errorf("Address index must be enabled (--addrindex)")
+	}
+	decoded, err := address.DecodeAddress(addr)
+	if err != nil {
+		return nil, nil, rpc.RpcAddressKeyError("Could not decode "+
+			"address: %v", err)
+	}
+	if !address.IsForNetwork(decoded, api.txManager.bm.ChainParams()) {
+		return nil, nil, rpc.RpcAddressKeyError("Wrong network: %v",
+			decoded)
+	}
+	return addrUtxoIndex, decoded, nil
+}
+
+// GetAddressBalance returns the confirmed balance of an address, and the
+// total amounts it received and sent.
+func (api *PublicTxAPI) GetAddressBalance(addr string) (interface{}, error) {
+	addrUtxoIndex, decoded, err := api.addrUtxoIndex(addr)
+	if err != nil {
+		return nil, err
+	}
+	received, sent, err := addrUtxoIndex.Balance(decoded)
+	if err != nil {
+		context := "Failed to fetch the address balance"
+		return nil, rpc.RpcInternalError(err.Error(), context)
+	}
+	return &json.GetAddressBalanceResult{
+		Address:  addr,
+		Balance:  types.Amount(received - sent).ToUnit(types.AmountCoin),
+		Received: types.Amount(received).ToUnit(types.AmountCoin),
+		Sent:     types.Amount(sent).ToUnit(types.AmountCoin),
+	}, nil
+}
+
+// GetAddressUtxos returns the confirmed unspent outputs of an address.  Up to
+// count outputs, 100 by default, are returned after skipping the first skip
+// ones.
+func (api *PublicTxAPI) GetAddressUtxos(addr string, skip *uint, count *uint) (interface{}, error) {
+	addrUtxoIndex, decoded, err := api.addrUtxoIndex(addr)
+	if err != nil {
+		return nil, err
+	}
+	numRequested := uint(100)
+	if count != nil {
+		numRequested = *count
+	}
+	var numToSkip uint
+	if skip != nil {
+		numToSkip = *skip
+	}
+	utxos, err := addrUtxoIndex.Utxos(decoded, uint32(numToSkip),
+		uint32(numRequested))
+	if err != nil {
+		context := "Failed to fetch the address utxos"
+		return nil, rpc.RpcInternalError(err.Error(), context)
+	}
+
+	bd := api.txManager.bm.GetChain().BlockDAG()
+	results := make([]json.AddressUtxoResult, 0, len(utxos))
+	for _, utxo := range utxos {
+		results = append(results, json.AddressUtxoResult{
+			TxId:          utxo.OutPoint.Hash.String(),
+			Vout:          utxo.OutPoint.OutIndex,
+			ScriptPubKey:  hex.EncodeToString(utxo.PkScript),
+			Amount:        types.Amount(utxo.Amount).ToUnit(types.AmountCoin),
+			BlockHash:     utxo.BlockHash.String(),
+			Confirmations: int64(bd.GetConfirmations(&utxo.BlockHash)),
+			Coinbase:      utxo.IsCoinBase,
+		})
+	}
+	return results, nil
+}
+
+// GetAddressDeltas returns the confirmed balance changes of an address in the
+// blocks from the start order, 0 by default, to the end order, the last one by
+// default.  Up to count changes, 100 by default, are returned after skipping
+// the first skip ones.
+func (api *PublicTxAPI) GetAddressDeltas(addr string, start *uint, end *uint,
+	skip *uint, count *uint) (interface{}, error) {
+	addrUtxoIndex, decoded, err := api.addrUtxoIndex(addr)
+	if err != nil {
+		return nil, err
+	}
+	startOrder := uint(0)
+	if start != nil {
+		startOrder = *start
+	}
+	endOrder := uint(api.txManager.bm.GetChain().BestSnapshot().GraphState.GetMainOrder())
+	if end != nil {
+		endOrder = *end
+	}
+	if startOrder > endOrder {
+		return nil, rpc.RpcInvalidError("start order %d is after end "+
+			"order %d", startOrder, endOrder)
+	}
+	numRequested := uint(100)
+	if count != nil {
+		numRequested = *count
+	}
+	var numToSkip uint
+	if skip != nil {
+		numToSkip = *skip
+	}
+	deltas, err := addrUtxoIndex.Deltas(decoded, uint32(startOrder),
+		uint32(endOrder), uint32(numToSkip), uint32(numRequested))
+	if err != nil {
+		context := "Failed to fetch the address deltas"
+		return nil, rpc.RpcInternalError(err.Error(), context)
+	}
+
+	bd := api.txManager.bm.GetChain().BlockDAG()
+	results := make([]json.AddressDeltaResult, 0, len(deltas))
+	for _, delta := range deltas {
+		var blockHash string
+		if h := bd.GetBlockByOrder(uint(delta.Order)); h != nil {
+			blockHash = h.String()
+		}
+		results = append(results, json.AddressDeltaResult{
+			TxId:      delta.TxHash.String(),
+			Index:     delta.Index,
+			Input:     delta.IsInput,
+			Amount:    types.Amount(delta.Amount).ToUnit(types.AmountCoin),
+			BlockHash: blockHash,
+			Order:     delta.Order,
+		})
+	}
+	return results, nil
+}
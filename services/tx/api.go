@@ -18,6 +18,7 @@ import (
 	"github.com/Qitmeer/qitmeer/params"
 	"github.com/Qitmeer/qitmeer/rpc"
 	"github.com/Qitmeer/qitmeer/services/mempool"
+	"math"
 )
 
 func (tm *TxManager) APIs() []rpc.API {
@@ -55,7 +56,27 @@ type TransactionInput struct {
 type Amounts map[string]uint64 //{\"address\":amount,...}
 
 func (api *PublicTxAPI) CreateRawTransaction(inputs []TransactionInput,
-	amounts Amounts, lockTime *int64) (interface{}, error) {
+	amounts Amounts, lockTime *int64, replaceable *bool, expiry *int64) (interface{}, error) {
+	mtx, err := api.createTransaction(inputs, amounts, lockTime, replaceable, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the serialized and hex-encoded transaction.  Note that this
+	// is intentionally not directly returning because the first return
+	// value is a string and it would result in returning an empty string to
+	// the client instead of nothing (nil) in the case of an error.
+	mtxHex, err := marshal.MessageToHex(&message.MsgTx{Tx: mtx})
+	if err != nil {
+		return nil, err
+	}
+	return mtxHex, nil
+}
+
+// createTransaction returns the unsigned transaction spending the passed
+// inputs to the passed amounts.
+func (api *PublicTxAPI) createTransaction(inputs []TransactionInput,
+	amounts Amounts, lockTime *int64, replaceable *bool, expiry *int64) (*types.Transaction, error) {
 
 	// Validate the locktime, if given.
 	if lockTime != nil &&
@@ -63,6 +84,11 @@ func (api *PublicTxAPI) CreateRawTransaction(inputs []TransactionInput,
 		return nil, rpc.RpcInvalidError("Locktime out of range")
 	}
 
+	// Validate the expiry, if given.
+	if expiry != nil && (*expiry < 0 || *expiry > math.MaxUint32) {
+		return nil, rpc.RpcInvalidError("Expiry out of range")
+	}
+
 	// Add all transaction inputs to a new transaction after performing
 	// some validity checks.
 	mtx := types.NewTransaction()
@@ -76,6 +102,11 @@ func (api *PublicTxAPI) CreateRawTransaction(inputs []TransactionInput,
 		if lockTime != nil && *lockTime != 0 {
 			txIn.Sequence = types.MaxTxInSequenceNum - 1
 		}
+		// Signal that the transaction can be replaced by one paying a
+		// higher fee, if requested.
+		if replaceable != nil && *replaceable {
+			txIn.Sequence = mempool.MaxRBFSequence
+		}
 		mtx.AddTxIn(txIn)
 	}
 
@@ -125,15 +156,12 @@ func (api *PublicTxAPI) CreateRawTransaction(inputs []TransactionInput,
 		mtx.LockTime = uint32(*lockTime)
 	}
 
-	// Return the serialized and hex-encoded transaction.  Note that this
-	// is intentionally not directly returning because the first return
-	// value is a string and it would result in returning an empty string to
-	// the client instead of nothing (nil) in the case of an error.
-	mtxHex, err := marshal.MessageToHex(&message.MsgTx{Tx: mtx})
-	if err != nil {
-		return nil, err
+	// Set the expiry, if given.  The transaction can only be included in
+	// blocks on a lower layer of the block dag than the expiry.
+	if expiry != nil {
+		mtx.Expire = uint32(*expiry)
 	}
-	return mtxHex, nil
+	return mtx, nil
 }
 
 func (api *PublicTxAPI) DecodeRawTransaction(hexTx string) (interface{}, error) {
@@ -225,6 +253,90 @@ func (api *PublicTxAPI) SendRawTransaction(hexTx string, allowHighFees *bool) (i
 	return tx.Hash().String(), nil
 }
 
+// BumpFee creates a replacement of the passed transaction in the mempool which
+// pays a higher fee.  The fee is taken from the output at the passed index,
+// which is normally the change output.  The replacement pays the minimum fee
+// required to replace the transaction and its descendants, or the passed fee
+// rate in atoms/kB when it is higher.  The transaction must signal
+// replaceability.  The replacement is returned unsigned, it has to be signed
+// before it is sent.
+func (api *PublicTxAPI) BumpFee(txHash hash.Hash, changeVout uint32, feeRate *int64) (interface{}, error) {
+	txPool := api.txManager.txMemPool
+	txDesc, err := txPool.FetchTxDesc(&txHash)
+	if err != nil {
+		return nil, rpc.RpcNoTxInfoError(&txHash)
+	}
+	origTx := txDesc.Tx.Tx
+	if int(changeVout) >= len(origTx.TxOut) {
+		return nil, rpc.RpcInvalidError("Change output %d out of range",
+			changeVout)
+	}
+	if feeRate != nil && *feeRate < 0 {
+		return nil, rpc.RpcInvalidError("Fee rate %d out of range",
+			*feeRate)
+	}
+
+	// The size of the signed replacement is estimated by the size of the
+	// original transaction.  Signatures vary in length by up to two bytes.
+	size := int64(origTx.SerializeSize() + 2*len(origTx.TxIn))
+	fee, err := txPool.CalcReplacementFee(&txHash, size)
+	if err != nil {
+		return nil, rpc.RpcRuleError("%v", err)
+	}
+	if feeRate != nil && *feeRate*size/1000 > fee {
+		fee = *feeRate * size / 1000
+	}
+
+	// Reduce the change output by the fee increase.
+	delta := fee - txDesc.Fee
+	changeAmount := origTx.TxOut[changeVout].Amount
+	if delta < 0 || uint64(delta) >= changeAmount {
+		return nil, rpc.RpcInvalidError("Change output of %d atoms "+
+			"can't pay the fee increase of %d atoms", changeAmount, delta)
+	}
+	changeOut := types.NewTxOutput(changeAmount-uint64(delta),
+		origTx.TxOut[changeVout].PkScript)
+	if txPool.IsDust(changeOut) {
+		return nil, rpc.RpcInvalidError("Change output of %d atoms "+
+			"would be dust after the fee increase of %d atoms",
+			changeAmount, delta)
+	}
+
+	mtx := types.NewTransaction()
+	mtx.Version = origTx.Version
+	mtx.LockTime = origTx.LockTime
+	mtx.Expire = origTx.Expire
+	mtx.Message = origTx.Message
+	for _, txIn := range origTx.TxIn {
+		prevOut := txIn.PreviousOut
+		newTxIn := types.NewTxInput(&prevOut, []byte{})
+		newTxIn.Sequence = txIn.Sequence
+		if newTxIn.Sequence > mempool.MaxRBFSequence {
+			newTxIn.Sequence = mempool.MaxRBFSequence
+		}
+		mtx.AddTxIn(newTxIn)
+	}
+	for i, txOut := range origTx.TxOut {
+		if uint32(i) == changeVout {
+			mtx.AddTxOut(changeOut)
+			continue
+		}
+		mtx.AddTxOut(types.NewTxOutput(txOut.Amount, txOut.PkScript))
+	}
+
+	mtxHex, err := marshal.MessageToHex(&message.MsgTx{Tx: mtx})
+	if err != nil {
+		return nil, err
+	}
+	reply := json.OrderedResult{
+		{Key: "hex", Val: mtxHex},
+		{Key: "origfee", Val: txDesc.Fee},
+		{Key: "fee", Val: fee},
+		{Key: "feerate", Val: fee * 1000 / size},
+	}
+	return reply, nil
+}
+
 func (api *PublicTxAPI) GetRawTransaction(txHash hash.Hash, verbose bool) (interface{}, error) {
 
 	var mtx *types.Transaction
@@ -432,6 +544,57 @@ func (api *PublicTxAPI) GetUtxo(txHash hash.Hash, vout uint32, includeMempool *b
 	return txOutReply, nil
 }
 
+// Returns the transaction input which spends a transaction output
+// 1. txid           (string, required)                The hash of the transaction
+// 2. vout           (numeric, required)               The index of the output
+//
+//Result (null when the output isn't spent):
+//{
+// "txid": "value",             (string)          The hash of the spending transaction
+// "vin": n,                    (numeric)         The index of the spending input
+// "blockhash": "value",        (string)          The block hash that contains the spending transaction, omitted for the mempool
+// "confirmations": n,          (numeric)         The number of confirmations of the spending transaction
+//}
+func (api *PublicTxAPI) GetSpendingTx(txHash hash.Hash, vout uint32) (interface{}, error) {
+	outpoint := types.TxOutPoint{Hash: txHash, OutIndex: vout}
+
+	// The spenders in the memory pool aren't indexed, look them up first.
+	spender := api.txManager.txMemPool.FetchSpender(&outpoint)
+	if spender != nil {
+		for i, txIn := range spender.Transaction().TxIn {
+			if txIn.PreviousOut != outpoint {
+				continue
+			}
+			return json.OrderedResult{
+				{Key: "txid", Val: spender.Hash().String()},
+				{Key: "vin", Val: i},
+				{Key: "confirmations", Val: 0},
+			}, nil
+		}
+	}
+
+	spendIndex := api.txManager.spendIndex
+	if spendIndex == nil {
+		return nil, fmt.Errorf("the spend index must be enabled to query " +
+			"the blockchain (specify --spendindex in configuration)")
+	}
+	entry, err := spendIndex.SpendingTx(&outpoint)
+	if err != nil {
+		context := "Failed to retrieve the spending transaction"
+		return nil, rpc.RpcInternalError(err.Error(), context)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	confirmations := api.txManager.bm.GetChain().BlockDAG().GetConfirmations(&entry.BlockHash)
+	return json.OrderedResult{
+		{Key: "txid", Val: entry.TxHash.String()},
+		{Key: "vin", Val: entry.InIndex},
+		{Key: "blockhash", Val: entry.BlockHash.String()},
+		{Key: "confirmations", Val: confirmations},
+	}, nil
+}
+
 // handleSearchRawTransactions implements the searchrawtransactions command.
 func (api *PublicTxAPI) GetRawTransactions(addre string, vinext *bool, count *uint, skip *uint, revers *bool, verbose *bool, filterAddrs *[]string) (interface{}, error) {
 	addrIndex := api.txManager.addrIndex
@@ -837,6 +1000,48 @@ func (api *PublicTxAPI) fetchInputTxos(tx *message.MsgTx) (map[types.TxOutPoint]
 	return originOutputs, nil
 }
 
+// CreateMultisig returns the redeem script of a multisig requiring nRequired
+// signatures of the passed public keys, and its pay-to-script-hash address.
+func (api *PublicTxAPI) CreateMultisig(nRequired int, keys []string) (interface{}, error) {
+	// Standard multisig scripts encode the number of keys as a small
+	// integer.
+	if len(keys) == 0 || len(keys) > 16 {
+		return nil, rpc.RpcInvalidError("Number of keys %d must be "+
+			"between 1 and 16", len(keys))
+	}
+	if nRequired < 1 || nRequired > len(keys) {
+		return nil, rpc.RpcInvalidError("Invalid number of required "+
+			"signatures %d for %d keys", nRequired, len(keys))
+	}
+
+	params := api.txManager.bm.ChainParams()
+	pubKeys := make([]*address.SecpPubKeyAddress, 0, len(keys))
+	for _, key := range keys {
+		serializedPubKey, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, rpc.RpcDecodeHexError(key)
+		}
+		pubKey, err := address.NewSecpPubKeyAddress(serializedPubKey, params)
+		if err != nil {
+			return nil, rpc.RpcAddressKeyError("Invalid public key %s: %v",
+				key, err)
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	script, err := txscript.MultiSigScript(pubKeys, nRequired)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	addr, err := address.NewAddressScriptHashFromHash(hash.Hash160(script), params)
+	if err != nil {
+		return nil, rpc.RpcInternalError(err.Error(), "Pay to script hash address")
+	}
+	return json.CreateMultiSigResult{
+		Address:      addr.Encode(),
+		RedeemScript: hex.EncodeToString(script),
+	}, nil
+}
+
 type PrivateTxAPI struct {
 	txManager *TxManager
 }
@@ -0,0 +1,158 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/Qitmeer/qitmeer/common/hash"
+	"github.com/Qitmeer/qitmeer/core/blockchain"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/engine/txscript"
+	"github.com/Qitmeer/qitmeer/params"
+	"github.com/Qitmeer/qitmeer/services/mempool"
+	"strings"
+	"testing"
+)
+
+// TestBumpFee checks the replacements created to raise the fee of the
+// transactions in the mempool.
+func TestBumpFee(t *testing.T) {
+	const origFee = 1000
+	pkScript := append(append([]byte{txscript.OP_DUP, txscript.OP_HASH160,
+		txscript.OP_DATA_20}, make([]byte, 20)...), txscript.OP_EQUALVERIFY,
+		txscript.OP_CHECKSIG)
+
+	tests := []struct {
+		name       string
+		sequence   uint32
+		change     uint64
+		changeVout uint32
+		feeRate    *int64
+		// err is part of the expected error, if any.
+		err string
+	}{
+		{
+			name:       "minimum replacement fee",
+			sequence:   mempool.MaxRBFSequence,
+			change:     1e6,
+			changeVout: 1,
+		},
+		{
+			name:       "fee rate",
+			sequence:   mempool.MaxRBFSequence,
+			change:     1e6,
+			changeVout: 1,
+			feeRate:    func() *int64 { r := int64(1e5); return &r }(),
+		},
+		{
+			name:       "not replaceable",
+			sequence:   types.MaxTxInSequenceNum,
+			change:     1e6,
+			changeVout: 1,
+			err:        "not replaceable",
+		},
+		{
+			name:       "change output out of range",
+			sequence:   mempool.MaxRBFSequence,
+			change:     1e6,
+			changeVout: 2,
+			err:        "out of range",
+		},
+		{
+			name:       "change can't pay the fee",
+			sequence:   mempool.MaxRBFSequence,
+			change:     1000,
+			changeVout: 1,
+			err:        "can't pay",
+		},
+		{
+			name:       "change becomes dust",
+			sequence:   mempool.MaxRBFSequence,
+			change:     3000,
+			changeVout: 1,
+			err:        "dust",
+		},
+	}
+
+	for _, test := range tests {
+		txPool := mempool.New(&mempool.Config{
+			Policy: mempool.Policy{
+				MinRelayTxFee: types.Amount(mempool.DefaultMinRelayTxFee),
+			},
+			ChainParams: &params.PrivNetParams,
+		})
+		api := NewPublicTxAPI(&TxManager{txMemPool: txPool})
+
+		prevHash := hash.HashH([]byte(test.name))
+		mtx := types.NewTransaction()
+		mtx.AddTxIn(&types.TxInput{
+			PreviousOut: *types.NewOutPoint(&prevHash, 0),
+			SignScript:  []byte{},
+			Sequence:    test.sequence,
+		})
+		mtx.AddTxOut(types.NewTxOutput(1e8, pkScript))
+		mtx.AddTxOut(types.NewTxOutput(test.change, pkScript))
+		origTx := types.NewTx(mtx)
+		txPool.AddTransaction(blockchain.NewUtxoViewpoint(), origTx, 1,
+			origFee)
+
+		reply, err := api.BumpFee(*origTx.Hash(), test.changeVout,
+			test.feeRate)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want %q", test.name, err,
+					test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		result := make(map[string]interface{})
+		for _, kv := range reply.(json.OrderedResult) {
+			result[kv.Key] = kv.Val
+		}
+		fee := result["fee"].(int64)
+		size := int64(mtx.SerializeSize() + 2*len(mtx.TxIn))
+		minFee, err := txPool.CalcReplacementFee(origTx.Hash(), size)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		wantFee := minFee
+		if test.feeRate != nil && *test.feeRate*size/1000 > wantFee {
+			wantFee = *test.feeRate * size / 1000
+		}
+		if fee != wantFee {
+			t.Errorf("%s: got fee %d, want %d", test.name, fee, wantFee)
+		}
+
+		serialized, err := hex.DecodeString(result["hex"].(string))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		replacement := types.NewTransaction()
+		err = replacement.Deserialize(bytes.NewReader(serialized))
+		if err != nil {
+			t.Fatalf("%s: failed to decode the replacement: %v",
+				test.name, err)
+		}
+		if replacement.TxIn[0].PreviousOut != mtx.TxIn[0].PreviousOut ||
+			replacement.TxIn[0].Sequence > mempool.MaxRBFSequence {
+			t.Errorf("%s: replacement doesn't spend the same input",
+				test.name)
+		}
+		if replacement.TxOut[0].Amount != mtx.TxOut[0].Amount {
+			t.Errorf("%s: payment changed to %d", test.name,
+				replacement.TxOut[0].Amount)
+		}
+		wantChange := test.change - uint64(fee-origFee)
+		if replacement.TxOut[1].Amount != wantChange {
+			t.Errorf("%s: got change %d, want %d", test.name,
+				replacement.TxOut[1].Amount, wantChange)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2017-2018 The qitmeer developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package tx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/Qitmeer/qitmeer/common/marshal"
+	"github.com/Qitmeer/qitmeer/core/json"
+	"github.com/Qitmeer/qitmeer/core/message"
+	"github.com/Qitmeer/qitmeer/core/types"
+	"github.com/Qitmeer/qitmeer/core/types/psbt"
+	"github.com/Qitmeer/qitmeer/crypto/ecc"
+	"github.com/Qitmeer/qitmeer/rpc"
+)
+
+// PsbtRedeemScript represents the redeem script of a pay-to-script-hash input
+// of a partially signed transaction.
+type PsbtRedeemScript struct {
+	Index  int    `json:"index"`
+	Script string `json:"script"`
+}
+
+// CreatePsbt returns a base64-encoded partially signed transaction spending
+// the passed inputs to the passed amounts.
+func (api *PublicTxAPI) CreatePsbt(inputs []TransactionInput,
+	amounts Amounts, lockTime *int64, replaceable *bool, expiry *int64) (interface{}, error) {
+	mtx, err := api.createTransaction(inputs, amounts, lockTime, replaceable, expiry)
+	if err != nil {
+		return nil, err
+	}
+	p, err := psbt.New(mtx)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return p.B64Encode()
+}
+
+// UpdatePsbt adds the outputs spent by the inputs of a partially signed
+// transaction, which are looked up in the memory pool and the utxo set, and
+// the passed redeem scripts.
+func (api *PublicTxAPI) UpdatePsbt(psbtStr string, redeemScripts *[]PsbtRedeemScript) (interface{}, error) {
+	p, err := psbt.Parse([]byte(psbtStr), true)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+
+	for i, txIn := range p.UnsignedTx.TxIn {
+		if p.Inputs[i].PrevOut != nil {
+			continue
+		}
+		prevOut, err := api.fetchPrevOut(&txIn.PreviousOut)
+		if err != nil {
+			return nil, err
+		}
+		if prevOut == nil {
+			continue
+		}
+		if err := p.AddInPrevOut(i, prevOut); err != nil {
+			return nil, rpc.RpcInvalidError("%v", err)
+		}
+	}
+
+	if redeemScripts != nil {
+		for _, redeemScript := range *redeemScripts {
+			script, err := hex.DecodeString(redeemScript.Script)
+			if err != nil {
+				return nil, rpc.RpcDecodeHexError(redeemScript.Script)
+			}
+			err = p.AddInRedeemScript(redeemScript.Index, script)
+			if err != nil {
+				return nil, rpc.RpcInvalidError("%v", err)
+			}
+		}
+	}
+	return p.B64Encode()
+}
+
+// fetchPrevOut returns the unspent output referenced by the passed outpoint
+// from the memory pool or the utxo set, or nil when it's unknown or spent.
+func (api *PublicTxAPI) fetchPrevOut(outpoint *types.TxOutPoint) (*types.TxOutput, error) {
+	tx, err := api.txManager.txMemPool.FetchTransaction(&outpoint.Hash)
+	if err == nil {
+		txOuts := tx.Tx.TxOut
+		if outpoint.OutIndex >= uint32(len(txOuts)) {
+			return nil, rpc.RpcInvalidError("Output index %d out of "+
+				"range for transaction %s", outpoint.OutIndex, outpoint.Hash)
+		}
+		txOut := txOuts[outpoint.OutIndex]
+		return types.NewTxOutput(txOut.Amount, txOut.PkScript), nil
+	}
+
+	entry, err := api.txManager.bm.GetChain().FetchUtxoEntry(*outpoint)
+	if err != nil {
+		context := "Failed to fetch utxo"
+		return nil, rpc.RpcInternalError(err.Error(), context)
+	}
+	if entry == nil || entry.IsSpent() {
+		return nil, nil
+	}
+	return types.NewTxOutput(entry.Amount(), entry.PkScript()), nil
+}
+
+// CombinePsbt merges the signatures of partially signed transactions of the
+// same transaction.
+func (api *PublicTxAPI) CombinePsbt(psbtStrs []string) (interface{}, error) {
+	packets := make([]*psbt.Packet, 0, len(psbtStrs))
+	for _, psbtStr := range psbtStrs {
+		p, err := psbt.Parse([]byte(psbtStr), true)
+		if err != nil {
+			return nil, rpc.RpcInvalidError("%v", err)
+		}
+		packets = append(packets, p)
+	}
+	combined, err := psbt.Combine(packets...)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	return combined.B64Encode()
+}
+
+// FinalizePsbt builds the signature scripts of the inputs of a partially
+// signed transaction which have all their signatures.  The signed raw
+// transaction is returned with it when all the inputs are finalized, unless
+// extract is false.
+func (api *PublicTxAPI) FinalizePsbt(psbtStr string, extract *bool) (interface{}, error) {
+	p, err := psbt.Parse([]byte(psbtStr), true)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	complete, err := psbt.Finalize(p)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	finalized, err := p.B64Encode()
+	if err != nil {
+		return nil, err
+	}
+	result := json.OrderedResult{
+		{Key: "psbt", Val: finalized},
+	}
+	if complete && (extract == nil || *extract) {
+		tx, err := psbt.Extract(p)
+		if err != nil {
+			return nil, err
+		}
+		mtxHex, err := marshal.MessageToHex(&message.MsgTx{Tx: tx})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, json.KV{Key: "hex", Val: mtxHex})
+	}
+	result = append(result, json.KV{Key: "complete", Val: complete})
+	return result, nil
+}
+
+// SignPsbt signs the inputs of a partially signed transaction which require a
+// signature of the passed private key.
+func (api *PrivateTxAPI) SignPsbt(privkeyStr string, psbtStr string) (interface{}, error) {
+	privkeyByte, err := hex.DecodeString(privkeyStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(privkeyByte) != 32 {
+		return nil, fmt.Errorf("error:%d", len(privkeyByte))
+	}
+	privateKey, _ := ecc.Secp256k1.PrivKeyFromBytes(privkeyByte)
+
+	p, err := psbt.Parse([]byte(psbtStr), true)
+	if err != nil {
+		return nil, rpc.RpcInvalidError("%v", err)
+	}
+	signed, err := psbt.Sign(p, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if signed == 0 {
+		return nil, rpc.RpcInvalidError("The private key can't sign any input")
+	}
+	return p.B64Encode()
+}
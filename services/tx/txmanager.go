@@ -1,6 +1,7 @@
 package tx
 
 import (
+	"fmt"
 	"github.com/Qitmeer/qitmeer/common/hash"
 	"github.com/Qitmeer/qitmeer/config"
 	"github.com/Qitmeer/qitmeer/core/blockchain"
@@ -13,9 +14,14 @@ import (
 	"github.com/Qitmeer/qitmeer/services/common"
 	"github.com/Qitmeer/qitmeer/services/index"
 	"github.com/Qitmeer/qitmeer/services/mempool"
+	"path/filepath"
 	"time"
 )
 
+// mempoolDumpFile is the name of the file in the data directory the mempool is
+// dumped to on shutdown.
+const mempoolDumpFile = "mempool.dat"
+
 type TxManager struct {
 	bm *blkmgr.BlockManager
 	// tx index
@@ -23,6 +29,12 @@ type TxManager struct {
 
 	// addr index
 	addrIndex *index.AddrIndex
+
+	// addr utxo index
+	addrUtxoIndex *index.AddrUtxoIndex
+
+	// spend index
+	spendIndex *index.SpendIndex
 	// mempool hold tx that need to be mined into blocks and relayed to other peers.
 	txMemPool *mempool.TxPool
 
@@ -34,18 +46,82 @@ type TxManager struct {
 
 	//invalidTx hash->block hash
 	invalidTx map[hash.Hash]*blockdag.HashSet
+
+	// dataDir is the directory the mempool is dumped to.
+	dataDir string
+
+	// feeEstimator records how long transactions take to be confirmed at
+	// each fee rate.
+	feeEstimator *mempool.FeeEstimator
 }
 
 func (tm *TxManager) Start() error {
 	log.Info("Starting tx manager")
+
+	// Reload the transactions the mempool held on shutdown.
+	dumpPath := filepath.Join(tm.dataDir, mempoolDumpFile)
+	acceptedTxs, count, err := tm.txMemPool.Load(dumpPath)
+	if err != nil {
+		log.Warn("Failed to load mempool", "path", dumpPath, "error", err)
+	}
+	if count > 0 {
+		log.Info(fmt.Sprintf("Loaded %d of %d transactions from %s",
+			len(acceptedTxs), count, dumpPath))
+	}
+
+	tm.bm.Subscribe(tm.handleNotifyMsg)
 	return nil
 }
 
 func (tm *TxManager) Stop() error {
 	log.Info("Stopping tx manager")
+
+	// Save fee estimator state in the database.
+	err := tm.db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Put(mempool.EstimateFeeDatabaseKey,
+			tm.feeEstimator.Save())
+	})
+	if err != nil {
+		log.Warn("Failed to save fee estimator state", "error", err)
+	}
+
+	dumpPath := filepath.Join(tm.dataDir, mempoolDumpFile)
+	count, err := tm.txMemPool.Dump(dumpPath)
+	if err != nil {
+		log.Warn("Failed to dump mempool", "path", dumpPath, "error", err)
+		return nil
+	}
+	log.Info(fmt.Sprintf("Dumped %d transactions to %s", count, dumpPath))
 	return nil
 }
 
+// handleNotifyMsg registers the blocks connected to the block DAG with the fee
+// estimator, and rolls back the ones disconnected.
+func (tm *TxManager) handleNotifyMsg(notification *blockchain.Notification) {
+	switch notification.Type {
+	case blockchain.BlockConnected:
+		blockSlice, ok := notification.Data.([]*types.SerializedBlock)
+		if !ok {
+			return
+		}
+		for _, block := range blockSlice {
+			tm.feeEstimator.RegisterBlock(block)
+		}
+
+	case blockchain.BlockDisconnected:
+		block, ok := notification.Data.(*types.SerializedBlock)
+		if !ok {
+			return
+		}
+		// Rollback previous block recorded by the fee estimator.
+		err := tm.feeEstimator.Rollback(block.Hash())
+		if err != nil {
+			log.Debug("Fee estimator rollback", "block", block.Hash(),
+				"error", err)
+		}
+	}
+}
+
 func (tm *TxManager) MemPool() blockchain.TxPool {
 	return tm.txMemPool
 }
@@ -97,8 +173,39 @@ func (tm *TxManager) RemoveInvalidTx(bh *hash.Hash) {
 }
 
 func NewTxManager(bm *blkmgr.BlockManager, txIndex *index.TxIndex,
-	addrIndex *index.AddrIndex, cfg *config.Config, ntmgr notify.Notify,
+	addrIndex *index.AddrIndex, addrUtxoIndex *index.AddrUtxoIndex,
+	spendIndex *index.SpendIndex, cfg *config.Config, ntmgr notify.Notify,
 	sigCache *txscript.SigCache, db database.DB) (*TxManager, error) {
+	// Search for a FeeEstimator state in the database. If none can be found
+	// or if it cannot be loaded, create a new one.
+	var feeEstimator *mempool.FeeEstimator
+	db.Update(func(dbTx database.Tx) error {
+		metadata := dbTx.Metadata()
+		feeEstimationData := metadata.Get(mempool.EstimateFeeDatabaseKey)
+		if feeEstimationData != nil {
+			// delete it from the database so that we don't try to restore the
+			// same thing again somehow.
+			metadata.Delete(mempool.EstimateFeeDatabaseKey)
+
+			// If there is an error, log it and make a new fee estimator.
+			var err error
+			feeEstimator, err = mempool.RestoreFeeEstimator(feeEstimationData)
+
+			if err != nil {
+				log.Error(fmt.Sprintf("Failed to restore fee estimator %v", err))
+			}
+		}
+
+		return nil
+	})
+
+	// If no feeEstimator has been found, create a new one and start over.
+	if feeEstimator == nil {
+		feeEstimator = mempool.NewFeeEstimator(
+			mempool.DefaultEstimateFeeMaxRollback,
+			mempool.DefaultEstimateFeeMinRegisteredBlocks)
+	}
+
 	// mem-pool
 	txC := mempool.Config{
 		Policy: mempool.Policy{
@@ -110,6 +217,8 @@ func NewTxManager(bm *blkmgr.BlockManager, txIndex *index.TxIndex,
 			MaxOrphanTxSize:      mempool.DefaultMaxOrphanTxSize,
 			MaxSigOpsPerTx:       blockchain.MaxSigOpsPerBlock / 5,
 			MinRelayTxFee:        types.Amount(cfg.MinTxFee),
+			RejectReplacement:    cfg.RejectReplacement,
+			MaxPoolSize:          int64(cfg.MaxMempool) * 1000000,
 			StandardVerifyFlags: func() (txscript.ScriptFlags, error) {
 				return common.StandardScriptVerifyFlags()
 			},
@@ -119,14 +228,16 @@ func NewTxManager(bm *blkmgr.BlockManager, txIndex *index.TxIndex,
 		BlockByHash:      bm.GetChain().FetchBlockByHash,
 		BestHash:         func() *hash.Hash { return &bm.GetChain().BestSnapshot().Hash },
 		BestHeight:       func() uint64 { return uint64(bm.GetChain().BestSnapshot().GraphState.GetMainHeight()) },
+		BestLayer:        func() uint64 { return uint64(bm.GetChain().BestSnapshot().GraphState.GetLayer()) },
 		CalcSequenceLock: bm.GetChain().CalcSequenceLock,
 		SubsidyCache:     bm.GetChain().FetchSubsidyCache(),
 		SigCache:         sigCache,
 		PastMedianTime:   func() time.Time { return bm.GetChain().BestSnapshot().MedianTime },
 		AddrIndex:        addrIndex,
+		FeeEstimator:     feeEstimator,
 		BD:               bm.GetChain().BlockDAG(),
 	}
 	txMemPool := mempool.New(&txC)
 	invalidTx := make(map[hash.Hash]*blockdag.HashSet)
-	return &TxManager{bm, txIndex, addrIndex, txMemPool, ntmgr, db, invalidTx}, nil
+	return &TxManager{bm, txIndex, addrIndex, addrUtxoIndex, spendIndex, txMemPool, ntmgr, db, invalidTx, cfg.DataDir, feeEstimator}, nil
 }
@@ -37,9 +37,19 @@ _qx()
         wif-to-ec
         wif-to-public
         ec-to-addr
+        addr-to-bech32
+        multisig-new
+        script-to-addr
+        script-decode
         tx-decode
         tx-encode
         tx-sign
+        psbt-create
+        psbt-update
+        psbt-sign
+        psbt-combine
+        psbt-finalize
+        psbt-decode
         msg-sign
         msg-verify
     "
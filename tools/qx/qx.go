@@ -61,9 +61,19 @@ entropy (seed) & mnemoic & hd & ec
 
 addr & tx & sign
     ec-to-addr            convert an EC public key to a paymant address. default is qx address
+    addr-to-bech32        convert a paymant address to its bech32 encoding.
+    multisig-new          create a m-of-n multisig redeem script from public keys.
+    script-to-addr        convert a redeem script to a pay-to-script-hash address.
+    script-decode         decode a script in base16 to json format.
     tx-encode             encode a unsigned transaction.
     tx-decode             decode a transaction in base16 to json format.
     tx-sign               sign a transactions using a private key.
+    psbt-create           create a partially signed transaction from an unsigned transaction.
+    psbt-update           add the spent outputs and redeem scripts to a partially signed transaction.
+    psbt-sign             sign a partially signed transaction using a private key.
+    psbt-combine          combine the signatures of partially signed transactions.
+    psbt-finalize         finalize a partially signed transaction.
+    psbt-decode           decode a partially signed transaction to json format.
     msg-sign              create a message signature
     msg-verify            validate a message signature
     signature-decode      decode a ECDSA signature
@@ -107,7 +117,11 @@ var txOutputs qx.TxOutputsFlag
 var txVersion qx.TxVersionFlag
 var txLockTime qx.TxLockTimeFlag
 var privateKey string
+var psbtPrevOuts qx.PsbtPrevOutsFlag
+var psbtRedeemScripts qx.PsbtRedeemScriptsFlag
+var psbtExtract bool
 var msgSignatureMode string
+var multisigRequired int
 
 func main() {
 
@@ -314,6 +328,29 @@ func main() {
 	}
 	ecToAddrCmd.Var(&base58checkVersion, "v", "base58check `version` [mainnet|testnet|privnet]")
 
+	addrToBech32Cmd := flag.NewFlagSet("addr-to-bech32", flag.ExitOnError)
+	addrToBech32Cmd.Usage = func() {
+		cmdUsage(addrToBech32Cmd, "Usage: qx addr-to-bech32 [address] \n")
+	}
+
+	multisigNewCmd := flag.NewFlagSet("multisig-new", flag.ExitOnError)
+	multisigNewCmd.Usage = func() {
+		cmdUsage(multisigNewCmd, "Usage: qx multisig-new [-m required] [ec_public_key]... \n")
+	}
+	multisigNewCmd.IntVar(&multisigRequired, "m", 1, "the number of signatures required to spend")
+
+	scriptToAddrCmd := flag.NewFlagSet("script-to-addr", flag.ExitOnError)
+	scriptToAddrCmd.Usage = func() {
+		cmdUsage(scriptToAddrCmd, "Usage: qx script-to-addr [redeem_script_base16_string] \n")
+	}
+	scriptToAddrCmd.StringVar(&network, "n", "privnet", "the target network of the address. (mainnet, testnet, privnet)")
+
+	scriptDecodeCmd := flag.NewFlagSet("script-decode", flag.ExitOnError)
+	scriptDecodeCmd.Usage = func() {
+		cmdUsage(scriptDecodeCmd, "Usage: qx script-decode [script_base16_string] \n")
+	}
+	scriptDecodeCmd.StringVar(&network, "n", "privnet", "decode script for the target network. (mainnet, testnet, privnet)")
+
 	// Transaction
 	txDecodeCmd := flag.NewFlagSet("tx-decode", flag.ExitOnError)
 	txDecodeCmd.Usage = func() {
@@ -342,6 +379,46 @@ MEER is the 64 bit spend amount in qitmeer.`)
 	}
 	txSignCmd.StringVar(&privateKey, "k", "", "the ec private key to sign the raw transaction")
 
+	// Partially signed transaction
+	psbtCreateCmd := flag.NewFlagSet("psbt-create", flag.ExitOnError)
+	psbtCreateCmd.Usage = func() {
+		cmdUsage(psbtCreateCmd, "Usage: qx psbt-create [raw_tx_base16_string] \n")
+	}
+
+	psbtUpdateCmd := flag.NewFlagSet("psbt-update", flag.ExitOnError)
+	psbtUpdateCmd.Usage = func() {
+		cmdUsage(psbtUpdateCmd, "Usage: qx psbt-update [-p prevout] [-r redeem-script] [psbt_base64_string] \n")
+	}
+	psbtUpdateCmd.Var(&psbtPrevOuts, "p", `The set of outputs spent by the transaction encoded as INDEX:MEER:PKSCRIPT.
+INDEX is the index of the spending input. MEER is the 64 bit amount of
+the output. PKSCRIPT is the Base16 public key script of the output.`)
+	psbtUpdateCmd.Var(&psbtRedeemScripts, "r", `The set of redeem scripts of the pay-to-script-hash inputs encoded as
+INDEX:SCRIPT. INDEX is the index of the input. SCRIPT is the Base16
+redeem script.`)
+
+	psbtSignCmd := flag.NewFlagSet("psbt-sign", flag.ExitOnError)
+	psbtSignCmd.Usage = func() {
+		cmdUsage(psbtSignCmd, "Usage: qx psbt-sign [psbt_base64_string] \n")
+	}
+	psbtSignCmd.StringVar(&privateKey, "k", "", "the ec private key to sign the partially signed transaction")
+
+	psbtCombineCmd := flag.NewFlagSet("psbt-combine", flag.ExitOnError)
+	psbtCombineCmd.Usage = func() {
+		cmdUsage(psbtCombineCmd, "Usage: qx psbt-combine [psbt_base64_string]... \n")
+	}
+
+	psbtFinalizeCmd := flag.NewFlagSet("psbt-finalize", flag.ExitOnError)
+	psbtFinalizeCmd.Usage = func() {
+		cmdUsage(psbtFinalizeCmd, "Usage: qx psbt-finalize [psbt_base64_string] \n")
+	}
+	psbtFinalizeCmd.BoolVar(&psbtExtract, "e", false, "extract the signed raw transaction in base16")
+
+	psbtDecodeCmd := flag.NewFlagSet("psbt-decode", flag.ExitOnError)
+	psbtDecodeCmd.Usage = func() {
+		cmdUsage(psbtDecodeCmd, "Usage: qx psbt-decode [psbt_base64_string] \n")
+	}
+	psbtDecodeCmd.StringVar(&network, "n", "privnet", "decode psbt for the target network. (mainnet, testnet, privnet)")
+
 	msgSignCmd := flag.NewFlagSet("msg-sign", flag.ExitOnError)
 	msgSignCmd.Usage = func() {
 		cmdUsage(msgSignCmd, "Usage: msg-sign [wif] [message] \n")
@@ -388,9 +465,19 @@ MEER is the 64 bit spend amount in qitmeer.`)
 		wifToEcCmd,
 		wifToPubCmd,
 		ecToAddrCmd,
+		addrToBech32Cmd,
+		multisigNewCmd,
+		scriptToAddrCmd,
+		scriptDecodeCmd,
 		txEncodeCmd,
 		txDecodeCmd,
 		txSignCmd,
+		psbtCreateCmd,
+		psbtUpdateCmd,
+		psbtSignCmd,
+		psbtCombineCmd,
+		psbtFinalizeCmd,
+		psbtDecodeCmd,
 		msgSignCmd,
 		msgVerifyCmd,
 	}
@@ -1019,6 +1106,77 @@ MEER is the 64 bit spend amount in qitmeer.`)
 		}
 	}
 
+	if addrToBech32Cmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				addrToBech32Cmd.Usage()
+			} else {
+				qx.AddressToBech32STDO(os.Args[len(os.Args)-1])
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			str := strings.TrimSpace(string(src))
+			qx.AddressToBech32STDO(str)
+		}
+	}
+
+	if multisigNewCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				multisigNewCmd.Usage()
+			} else {
+				qx.MultisigNewSTDO(multisigRequired, multisigNewCmd.Args())
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			qx.MultisigNewSTDO(multisigRequired, strings.Fields(string(src)))
+		}
+	}
+
+	if scriptToAddrCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				scriptToAddrCmd.Usage()
+			} else {
+				qx.ScriptToAddressSTDO(network, os.Args[len(os.Args)-1])
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			str := strings.TrimSpace(string(src))
+			qx.ScriptToAddressSTDO(network, str)
+		}
+	}
+
+	if scriptDecodeCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				scriptDecodeCmd.Usage()
+			} else {
+				qx.ScriptDecode(network, os.Args[len(os.Args)-1])
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			str := strings.TrimSpace(string(src))
+			qx.ScriptDecode(network, str)
+		}
+	}
+
 	if txDecodeCmd.Parsed() {
 		stat, _ := os.Stdin.Stat()
 		if (stat.Mode() & os.ModeNamedPipe) == 0 {
@@ -1066,6 +1224,113 @@ MEER is the 64 bit spend amount in qitmeer.`)
 		}
 	}
 
+	if psbtCreateCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				psbtCreateCmd.Usage()
+			} else {
+				qx.PsbtCreateSTDO(os.Args[len(os.Args)-1])
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			str := strings.TrimSpace(string(src))
+			qx.PsbtCreateSTDO(str)
+		}
+	}
+
+	if psbtUpdateCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				psbtUpdateCmd.Usage()
+			} else {
+				qx.PsbtUpdateSTDO(os.Args[len(os.Args)-1], psbtPrevOuts, psbtRedeemScripts)
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			str := strings.TrimSpace(string(src))
+			qx.PsbtUpdateSTDO(str, psbtPrevOuts, psbtRedeemScripts)
+		}
+	}
+
+	if psbtSignCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				psbtSignCmd.Usage()
+			} else {
+				qx.PsbtSignSTDO(privateKey, os.Args[len(os.Args)-1])
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			str := strings.TrimSpace(string(src))
+			qx.PsbtSignSTDO(privateKey, str)
+		}
+	}
+
+	if psbtCombineCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				psbtCombineCmd.Usage()
+			} else {
+				qx.PsbtCombineSTDO(psbtCombineCmd.Args())
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			qx.PsbtCombineSTDO(strings.Fields(string(src)))
+		}
+	}
+
+	if psbtFinalizeCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				psbtFinalizeCmd.Usage()
+			} else {
+				qx.PsbtFinalizeSTDO(os.Args[len(os.Args)-1], psbtExtract)
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			str := strings.TrimSpace(string(src))
+			qx.PsbtFinalizeSTDO(str, psbtExtract)
+		}
+	}
+
+	if psbtDecodeCmd.Parsed() {
+		stat, _ := os.Stdin.Stat()
+		if (stat.Mode() & os.ModeNamedPipe) == 0 {
+			if len(os.Args) == 2 || os.Args[2] == "help" || os.Args[2] == "--help" {
+				psbtDecodeCmd.Usage()
+			} else {
+				qx.PsbtDecode(network, os.Args[len(os.Args)-1])
+			}
+		} else { //try from STDIN
+			src, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				errExit(err)
+			}
+			str := strings.TrimSpace(string(src))
+			qx.PsbtDecode(network, str)
+		}
+	}
+
 	if msgSignCmd.Parsed() {
 		stat, _ := os.Stdin.Stat()
 		if (stat.Mode() & os.ModeNamedPipe) == 0 {